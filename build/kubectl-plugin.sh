@@ -0,0 +1,41 @@
+#!/usr/bin/env bash
+
+set -euxo pipefail
+
+# Builds the kubectl crdb-certs plugin archives and their krew manifest to build/artifacts, which are
+# published with the chart by build/release.sh.
+
+helm_charts_toplevel="$(dirname "$(cd "$(dirname "${0}")"; pwd)")/"
+cd "${helm_charts_toplevel}"
+
+version="${VERSION:?VERSION of the plugin is required}"
+commit="$(git rev-parse HEAD)"
+build_date="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+artifacts_dir="build/artifacts/kubectl-crdb-certs/v${version}"
+charts_hostname="${CHARTS_HOSTNAME:-charts.cockroachdb.com}"
+
+mkdir -p "${artifacts_dir}"
+
+for platform in linux_amd64 darwin_amd64 windows_amd64; do
+  goos="${platform%_*}"
+  bin="kubectl-crdb_certs"
+  if [ "${goos}" = "windows" ]; then
+    bin="${bin}.exe"
+  fi
+
+  workdir="$(mktemp -d)"
+  CGO_ENABLED=0 GOOS="${goos}" GOARCH="${platform#*_}" go build \
+    -ldflags "-X github.com/cockroachdb/helm-charts/pkg/version.Version=${version} \
+      -X github.com/cockroachdb/helm-charts/pkg/version.Commit=${commit} \
+      -X github.com/cockroachdb/helm-charts/pkg/version.BuildDate=${build_date}" \
+    -o "${workdir}/${bin}" ./cmd/kubectl-crdb_certs
+  cp LICENSE "${workdir}/"
+
+  archive="${artifacts_dir}/kubectl-crdb_certs_${platform}.tar.gz"
+  tar -czf "${archive}" -C "${workdir}" "${bin}" LICENSE
+  rm -rf "${workdir}"
+
+  export "SHA256_${platform}=$(sha256sum "${archive}" | cut -d ' ' -f 1)"
+done
+
+VERSION="${version}" CHARTS_HOSTNAME="${charts_hostname}" envsubst < build/krew/crdb-certs.yaml > "${artifacts_dir}/crdb-certs.yaml"
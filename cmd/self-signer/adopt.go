@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+)
+
+// adoptCmd represents the adopt command
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "imports existing certificates into the self-signer secrets",
+	Long: `adopt sub-command validates the CA, node and client certificates created by the CockroachDB operator or the
+cockroach cert commands and stores them in the self-signer secrets, so that the cluster keeps its CA when its
+certificates are managed by the self-signer`,
+	Run: adopt,
+}
+
+var (
+	operatorCluster                  string
+	sourceCASecret, sourceNodeSecret string
+	sourceClientSecret               string
+	adoptCertsDir, adoptCertsSecret  string
+	adoptCAKey                       string
+	overwriteSecrets                 bool
+)
+
+func init() {
+	adoptCmd.Flags().StringVar(&operatorCluster, "operator-cluster", "", "name of the CrdbCluster whose operator secrets <name>-ca, <name>-node and <name>-root are adopted")
+	adoptCmd.Flags().StringVar(&sourceCASecret, "source-ca-secret", "", "secret holding the CA key and certificate to adopt, defaults to the operator CA secret")
+	adoptCmd.Flags().StringVar(&sourceNodeSecret, "source-node-secret", "", "secret holding the node certificate to adopt, defaults to the operator node secret")
+	adoptCmd.Flags().StringVar(&sourceClientSecret, "source-client-secret", "", "secret holding the root client certificate to adopt, defaults to the operator root secret")
+	adoptCmd.Flags().StringVar(&adoptCertsDir, "certs-dir", "", "certs directory created with the cockroach cert commands to adopt")
+	adoptCmd.Flags().StringVar(&adoptCertsSecret, "certs-secret", "", "secret created from a certs directory of the cockroach cert commands to adopt")
+	adoptCmd.Flags().StringVar(&adoptCAKey, "ca-key", "", "path of the CA key of the certs directory or secret, defaults to ca.key in the certs directory or secret")
+	adoptCmd.Flags().BoolVar(&overwriteSecrets, "overwrite", false, "replace the self-signer secrets if they already exist")
+	rootCmd.AddCommand(adoptCmd)
+}
+
+func adopt(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	var certs generator.AdoptedCerts
+	switch {
+	case adoptCertsDir != "":
+		certs, err = generator.LoadDirCerts(adoptCertsDir, defaultString(adoptCAKey, filepath.Join(adoptCertsDir, "ca.key")),
+			genCert.ClientUser())
+	case adoptCertsSecret != "":
+		certs, err = generator.LoadCertsSecret(ctx, cl, namespace, adoptCertsSecret, adoptCAKey, genCert.ClientUser())
+	default:
+		if operatorCluster != "" {
+			sourceCASecret = defaultString(sourceCASecret, operatorCluster+"-ca")
+			sourceNodeSecret = defaultString(sourceNodeSecret, operatorCluster+"-node")
+			sourceClientSecret = defaultString(sourceClientSecret, operatorCluster+"-root")
+		}
+
+		if sourceCASecret == "" || sourceNodeSecret == "" || sourceClientSecret == "" {
+			exitOnConfigError("--certs-dir, --certs-secret, --operator-cluster or the CA, node and client source secrets are required")
+		}
+
+		certs, err = generator.LoadSecretCerts(ctx, cl, namespace, sourceCASecret, sourceNodeSecret, sourceClientSecret)
+	}
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if err := genCert.Adopt(ctx, namespace, certs, overwriteSecrets); err != nil {
+		exitOnError(err)
+	}
+}
+
+// defaultString returns s, or def if s is empty.
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
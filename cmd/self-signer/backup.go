@@ -0,0 +1,270 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/backup"
+	"github.com/cockroachdb/helm-charts/pkg/objectstore"
+	"github.com/cockroachdb/helm-charts/pkg/proxy"
+)
+
+// backupPassphraseEnv is the env holding the passphrase used to encrypt and decrypt backups.
+const backupPassphraseEnv = "BACKUP_PASSPHRASE"
+
+// backupContentType is the content type of the backups uploaded to a bucket.
+const backupContentType = "application/octet-stream"
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "backs up the CA, node and client secrets",
+	Long: `backup sub-command exports the secrets managed by the self-signer to a file or an object store bucket, ` +
+		`so that the PKI state can be restored after a disaster. Set --age-recipient to encrypt the backup to age ` +
+		`recipients, --kms-key-id to encrypt it with an AWS KMS key or --encrypt to encrypt it with the ` +
+		`passphrase in the ` + backupPassphraseEnv + ` env`,
+	Run: backupSecrets,
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "restores the CA, node and client secrets from a backup",
+	Long: `restore sub-command recreates the secrets from a backup created by the backup sub-command. ` +
+		`Backups encrypted to age recipients are decrypted with the identities of --age-identity, backups ` +
+		`encrypted with a KMS key are decrypted through AWS KMS and passphrase encrypted backups with the ` +
+		`passphrase in the ` + backupPassphraseEnv + ` env`,
+	Run: restoreSecrets,
+}
+
+var (
+	backupFile          string
+	backupSecretSet     []string
+	backupEncrypt       bool
+	backupAgeRecipients []string
+	backupAgeIdentity   string
+	backupKMSKeyID      string
+	backupBucket        string
+	backupObject        string
+	backupEndpoint      string
+	backupRegion        string
+	backupSSE           string
+	backupProxy         string
+	restoreOverride     bool
+)
+
+func init() {
+	backupCmd.Flags().StringVar(&backupFile, "file", "", "path of the backup file")
+	backupCmd.Flags().StringSliceVar(&backupSecretSet, "secrets", nil, "secrets to back up. Defaults to the CA, CA key and certificate secrets of the release")
+	backupCmd.Flags().BoolVar(&backupEncrypt, "encrypt", false, "encrypt the backup with the passphrase in the "+backupPassphraseEnv+" env")
+	backupCmd.Flags().StringSliceVar(&backupAgeRecipients, "age-recipient", nil, "encrypt the backup to this age recipient, age1... Can be repeated")
+	backupCmd.Flags().StringVar(&backupKMSKeyID, "kms-key-id", "", "encrypt the backup with a data key encrypted by this AWS KMS key, using the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY envs")
+	backupCmd.Flags().StringVar(&backupSSE, "sse", objectstore.DefaultSSE, "server side encryption of the backup uploaded to --bucket, AES256 or aws:kms")
+
+	restoreCmd.Flags().StringVar(&backupFile, "file", "", "path of the backup file")
+	restoreCmd.Flags().StringVar(&backupAgeIdentity, "age-identity", "", "path of the age identity file decrypting backups encrypted to age recipients")
+	restoreCmd.Flags().BoolVar(&restoreOverride, "overwrite", false, "overwrite secrets which already exist")
+
+	for _, cmd := range []*cobra.Command{backupCmd, restoreCmd} {
+		cmd.Flags().StringVar(&backupBucket, "bucket", "", "object store bucket of the backup, instead of --file, using the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY envs")
+		cmd.Flags().StringVar(&backupObject, "object", "", "key of the backup object in --bucket")
+		cmd.Flags().StringVar(&backupEndpoint, "endpoint", objectstore.DefaultEndpoint, "endpoint of the S3 compatible object store. Use https://storage.googleapis.com for GCS")
+		cmd.Flags().StringVar(&backupRegion, "region", "us-east-1", "region of the bucket and the KMS key")
+		cmd.Flags().StringVar(&backupProxy, "proxy", "", "proxy URL of the requests to the object store and KMS, or direct. Defaults to the HTTPS_PROXY and NO_PROXY envs")
+	}
+
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func backupSecrets(cmd *cobra.Command, args []string) {
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		log.Panic("Required NAMESPACE env not found")
+	}
+
+	validateBackupLocation()
+
+	secrets := backupSecretSet
+	if len(secrets) == 0 {
+		genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+		if err != nil {
+			exitOnConfigError(err)
+		}
+		if secrets, err = genCert.BackupSecrets(ctx, namespace); err != nil {
+			exitOnError(errors.Wrap(err, "failed to list the secrets to back up"))
+		}
+	}
+
+	encryptions := 0
+	for _, set := range []bool{backupEncrypt, len(backupAgeRecipients) > 0, backupKMSKeyID != ""} {
+		if set {
+			encryptions++
+		}
+	}
+	if encryptions > 1 {
+		log.Panic("Only one of --encrypt, --age-recipient and --kms-key-id can be set")
+	}
+
+	b, err := backup.Create(ctx, cl, namespace, secrets)
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to back up secrets"))
+	}
+
+	var data []byte
+	switch {
+	case len(backupAgeRecipients) > 0:
+		var recipients []age.Recipient
+		for _, r := range backupAgeRecipients {
+			recipient, err := age.ParseX25519Recipient(r)
+			if err != nil {
+				log.Panic(err)
+			}
+			recipients = append(recipients, recipient)
+		}
+		data, err = backup.MarshalForRecipients(b, recipients)
+	case backupKMSKeyID != "":
+		kms, kmsErr := backupKMSClient()
+		if kmsErr != nil {
+			log.Panic(kmsErr)
+		}
+		data, err = backup.MarshalWithKMS(ctx, b, kms, backupKMSKeyID)
+	default:
+		var passphrase string
+		if backupEncrypt {
+			passphrase, exists = os.LookupEnv(backupPassphraseEnv)
+			if !exists {
+				log.Panicf("Required %s env not found", backupPassphraseEnv)
+			}
+		}
+		data, err = backup.Marshal(b, passphrase)
+	}
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to encode backup"))
+	}
+
+	if backupBucket != "" {
+		store, err := backupStore()
+		if err != nil {
+			log.Panic(err)
+		}
+		if err := store.Put(ctx, backupObject, data); err != nil {
+			exitOnError(errors.Wrap(err, "failed to upload backup"))
+		}
+		return
+	}
+
+	if err := ioutil.WriteFile(backupFile, data, 0600); err != nil {
+		exitOnError(errors.Wrap(err, "failed to write backup"))
+	}
+}
+
+func restoreSecrets(cmd *cobra.Command, args []string) {
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		log.Panic("Required NAMESPACE env not found")
+	}
+
+	validateBackupLocation()
+
+	var data []byte
+	var err error
+	if backupBucket != "" {
+		store, storeErr := backupStore()
+		if storeErr != nil {
+			log.Panic(storeErr)
+		}
+		data, err = store.Get(ctx, backupObject)
+	} else {
+		data, err = ioutil.ReadFile(backupFile)
+	}
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to read backup"))
+	}
+
+	keys := backup.Keys{Passphrase: os.Getenv(backupPassphraseEnv)}
+	if backupAgeIdentity != "" {
+		identities, err := ioutil.ReadFile(backupAgeIdentity)
+		if err != nil {
+			exitOnError(errors.Wrap(err, "failed to read age identity"))
+		}
+		if keys.AgeIdentities, err = age.ParseIdentities(bytes.NewReader(identities)); err != nil {
+			log.Panic(err)
+		}
+	}
+	// the KMS key is only needed by backups encrypted with it, which fail to decrypt without credentials
+	if kms, err := backupKMSClient(); err == nil {
+		keys.KMS = kms
+	}
+
+	b, err := backup.Unmarshal(ctx, data, keys)
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to decode backup"))
+	}
+
+	if err := backup.Restore(ctx, cl, namespace, b, restoreOverride); err != nil {
+		exitOnError(errors.Wrap(err, "failed to restore secrets"))
+	}
+}
+
+// validateBackupLocation checks that exactly one of --file and --bucket, with its --object, is set.
+func validateBackupLocation() {
+	if (backupFile == "") == (backupBucket == "") {
+		log.Panic("Exactly one of --file and --bucket must be set")
+	}
+	if backupBucket != "" && backupObject == "" {
+		log.Panic("--object is required with --bucket")
+	}
+}
+
+func backupStore() (*objectstore.S3Store, error) {
+	creds, err := objectstore.CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	store := objectstore.NewS3Store(backupEndpoint, backupRegion, backupBucket, creds)
+	store.SSE = backupSSE
+	store.ContentType = backupContentType
+	if store.HTTPClient, err = proxy.NewClient(backupProxy, store.HTTPClient.Timeout); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func backupKMSClient() (*objectstore.KMSClient, error) {
+	creds, err := objectstore.CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	kms := objectstore.NewKMSClient(backupRegion, creds)
+	if kms.HTTPClient, err = proxy.NewClient(backupProxy, kms.HTTPClient.Timeout); err != nil {
+		return nil, err
+	}
+
+	return kms, nil
+}
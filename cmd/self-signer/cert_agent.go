@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+
+	"github.com/cockroachdb/helm-charts/pkg/certagent"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// certAgentCmd represents the cert-agent command
+var certAgentCmd = &cobra.Command{
+	Use:   "cert-agent",
+	Short: "keeps the client certificates of a user up to date in a shared directory",
+	Long: `cert-agent sub-command runs as a sidecar writing the client certificates of a user to a directory shared with
+the application, and atomically replaces them when the client secret is rotated, optionally signaling the application
+to reload them, so that it picks up rotated certificates without a pod restart`,
+	Run: runCertAgent,
+}
+
+var (
+	agentCertsDir string
+	agentUser     string
+	agentInterval time.Duration
+	agentOnce     bool
+	agentProcess  string
+	agentSignal   string
+	agentNode     bool
+)
+
+// agentSignals are the signals the application can be reloaded with.
+var agentSignals = map[string]os.Signal{"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "TERM": syscall.SIGTERM}
+
+func init() {
+	certAgentCmd.Flags().StringVar(&agentCertsDir, "certs-dir", "/cockroach-certs", "directory shared with the application the certificates are written to")
+	certAgentCmd.Flags().StringVar(&agentUser, "user", security.RootUser, "user of the client certificate")
+	certAgentCmd.Flags().DurationVar(&agentInterval, "interval", time.Minute, "interval between two reads of the client secret")
+	certAgentCmd.Flags().BoolVar(&agentOnce, "once", false, "write the certificates and exit, e.g. in an init container")
+	certAgentCmd.Flags().StringVar(&agentProcess, "signal-process", "", "command name of the application processes signaled once the certificates were replaced. The pod must share its process namespace")
+	certAgentCmd.Flags().StringVar(&agentSignal, "signal", "HUP", "signal sent to the --signal-process, one of HUP, INT or TERM")
+	certAgentCmd.Flags().BoolVar(&agentNode, "node-certs", false, "write the node certificates of the pod named by the POD_NAME env instead of client certificates, e.g. next to CockroachDB with --reload-certs=sidecar")
+	rootCmd.AddCommand(certAgentCmd)
+}
+
+func runCertAgent(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	sig, ok := agentSignals[agentSignal]
+	if !ok {
+		exitOnConfigErrorf("unsupported signal %s", agentSignal)
+	}
+
+	load := func(ctx context.Context) ([]security.CertFile, string, error) {
+		return genCert.ClientCertFiles(ctx, namespace, agentUser)
+	}
+	if agentNode {
+		pod, exists := os.LookupEnv("POD_NAME")
+		if !exists {
+			exitOnConfigError("Required POD_NAME env not found")
+		}
+		load = func(ctx context.Context) ([]security.CertFile, string, error) {
+			return genCert.NodeCertFiles(ctx, namespace, pod)
+		}
+	}
+
+	agent := &certagent.Agent{
+		Load:    load,
+		Dir:     agentCertsDir,
+		Process: agentProcess,
+		Signal:  sig,
+	}
+
+	if agentOnce {
+		if _, err := agent.Sync(ctx); err != nil {
+			exitOnError(err)
+		}
+		return
+	}
+
+	if err := agent.Run(controllerruntime.SetupSignalHandler(), agentInterval); err != nil {
+		exitOnError(err)
+	}
+}
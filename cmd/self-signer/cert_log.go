@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// certLogCmd represents the cert-log command
+var certLogCmd = &cobra.Command{
+	Use:   "cert-log",
+	Short: "lists the certificates recorded in the certificate log",
+	Long: `cert-log sub-command lists the certificates recorded in the certificate log set by --cert-log-configmap, which
+keeps a record of every issued certificate independent of the current content of the secrets. It verifies the hash
+chain of the log and exits with code 5 if it was tampered with`,
+	Run: certLog,
+}
+
+var (
+	certLogJSON   bool
+	certLogSecret string
+	certLogSerial string
+	certLogSHA256 string
+)
+
+func init() {
+	certLogCmd.Flags().BoolVar(&certLogJSON, "json", false, "print the entries of the certificate log as JSON")
+	certLogCmd.Flags().StringVar(&certLogSecret, "secret", "", "only list the certificates issued to this secret")
+	certLogCmd.Flags().StringVar(&certLogSerial, "serial", "", "only list the certificate with this hex serial number, with or without colons")
+	certLogCmd.Flags().StringVar(&certLogSHA256, "sha256", "", "only list the certificate with this SHA-256 fingerprint of its DER encoding, with or without colons")
+	rootCmd.AddCommand(certLogCmd)
+}
+
+func certLog(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	serial := ""
+	if certLogSerial != "" {
+		parsed, err := resource.ParseSerial(certLogSerial)
+		if err != nil {
+			exitOnConfigError(err)
+		}
+		serial = resource.SerialKey(parsed)
+	}
+	fingerprint := strings.ToLower(strings.ReplaceAll(certLogSHA256, ":", ""))
+
+	entries, verifyErr := genCert.CertLog(ctx, namespace)
+	if verifyErr != nil && entries == nil {
+		exitOnError(verifyErr)
+	}
+
+	var matched []resource.CertLogEntry
+	for _, e := range entries {
+		if (certLogSecret == "" || e.Secret == certLogSecret) && (serial == "" || e.Serial == serial) &&
+			(fingerprint == "" || e.SHA256 == fingerprint) {
+			matched = append(matched, e)
+		}
+	}
+
+	if certLogJSON {
+		out, err := json.MarshalIndent(matched, "", "  ")
+		if err != nil {
+			exitOnError(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "INDEX\tTIME\tOPERATION\tSECRET\tSERIAL\tSUBJECT\tNOT AFTER\tSHA256")
+		for _, e := range matched {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Index, e.Time.Format(time.RFC3339), e.Operation,
+				e.Secret, e.Serial, e.Subject, e.NotAfter.Format(time.RFC3339), e.SHA256)
+		}
+		w.Flush()
+	}
+
+	if verifyErr != nil {
+		exitOnError(verifyErr)
+	}
+}
@@ -17,12 +17,13 @@ limitations under the License.
 package self_signer
 
 import (
-	"log"
 	"os"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"github.com/cockroachdb/helm-charts/pkg/audit"
 	"github.com/cockroachdb/helm-charts/pkg/resource"
 )
 
@@ -39,7 +40,7 @@ var namespace string
 func init() {
 	cleanupCmd.Flags().StringVar(&namespace, "namespace", "", "namespace of the resources to be cleaned up")
 	if err := cleanupCmd.MarkFlagRequired("namespace"); err != nil {
-		log.Fatal(err)
+		exitOnError(err)
 	}
 	rootCmd.AddCommand(cleanupCmd)
 }
@@ -48,8 +49,22 @@ func cleanup(cmd *cobra.Command, args []string) {
 
 	stsName, exists := os.LookupEnv("STATEFULSET_NAME")
 	if !exists {
-		log.Fatal("Required STATEFULSET_NAME env not found")
+		exitOnConfigError("Required STATEFULSET_NAME env not found")
 	}
 
-	resource.Clean(ctx, cl, namespace, stsName)
+	auditLog, err := newAuditLogger()
+	if err != nil {
+		exitOnError(err)
+	}
+
+	for _, name := range resource.Clean(ctx, cl, namespace, stsName) {
+		if err := audit.Record(ctx, auditLog, audit.Event{
+			Operation: audit.Delete,
+			Namespace: namespace,
+			Secret:    name,
+			Requester: audit.Requester(restConfig),
+		}); err != nil {
+			exitOnError(errors.Wrap(err, "failed to record audit event"))
+		}
+	}
 }
@@ -0,0 +1,479 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/cockroachdb/helm-charts/pkg/controller"
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+// controllerCmd represents the controller command
+var controllerCmd = &cobra.Command{
+	Use:   "controller",
+	Short: "runs the long-running certificate controller",
+	Long:  `controller sub-command runs the controllers which continuously reconcile the CockroachDB certificates`,
+	Run:   runController,
+}
+
+var (
+	metricsAddr         string
+	readinessGatePort   int
+	enableReadinessGate bool
+	releaseSelector     string
+	resyncPeriod        string
+	watchNamespaces     []string
+	ignoreNamespaces    []string
+	namespaceSelector   string
+	rotationRequests    bool
+	reloadInterval      time.Duration
+	leaderElect         bool
+	leaderElectionNS    string
+	retryBaseDelay      time.Duration
+	retryMaxDelay       time.Duration
+	queueQPS            float64
+	queueBurst          int
+	verifyInterval      time.Duration
+	recreateSecrets     bool
+	cacheSecrets        bool
+	memoizeCA           bool
+)
+
+func init() {
+	controllerCmd.Flags().StringVar(&metricsAddr, "metrics-bind-address", ":8080", "address the metrics endpoint binds to, 0 disables it")
+	controllerCmd.Flags().BoolVar(&enableReadinessGate, "readiness-gate", true, "if set, maintains the certs-valid readiness gate condition of the CockroachDB pods")
+	controllerCmd.Flags().IntVar(&readinessGatePort, "readiness-gate-port", 26257, "port on which the CockroachDB pods serve TLS")
+	controllerCmd.Flags().StringVar(&releaseSelector, "release-selector", "", "if set, manages the certificates of the releases of every statefulset matching this label selector in all namespaces, e.g. app.kubernetes.io/name=cockroachdb")
+	controllerCmd.Flags().StringSliceVar(&watchNamespaces, "watch-namespaces", nil, "namespaces whose releases are managed in multi-tenant mode, all namespaces if empty")
+	controllerCmd.Flags().StringSliceVar(&ignoreNamespaces, "ignore-namespaces", nil, "namespaces whose releases are never managed in multi-tenant mode, even if watched or matching the namespace selector")
+	controllerCmd.Flags().StringVar(&namespaceSelector, "namespace-selector", "", "label selector of the namespaces whose releases are managed in multi-tenant mode")
+	controllerCmd.Flags().StringVar(&resyncPeriod, "resync-period", "1h", "interval at which each secret of the secret work queue is checked for rotation")
+	controllerCmd.Flags().BoolVar(&rotationRequests, "rotation-requests", false, "if set, rotates the certs of the secrets annotated with crdb.io/rotate=true right away through the secret work queue")
+	controllerCmd.Flags().StringVar(&caCron, "ca-cron", "", "cron the CA certificate is checked against by the secret work queue, defaults to every resync period")
+	controllerCmd.Flags().StringVar(&nodeAndClientCron, "node-client-cron", "", "cron the node and client certificates are checked against by the secret work queue, defaults to every resync period")
+	controllerCmd.Flags().StringVar(&readinessWait, "readiness-wait", "30s", "readiness wait for each replica of crdb cluster")
+	controllerCmd.Flags().StringVar(&podUpdateTimeout, "pod-update-timeout", "2m", "time to wait for statefulset pod to restart and get to running state")
+	controllerCmd.Flags().DurationVar(&reloadInterval, "config-reload-interval", 30*time.Second, "interval at which the --config and --values files are checked for changes, which are applied without a restart. 0 disables the reload")
+	controllerCmd.Flags().BoolVar(&leaderElect, "leader-elect", false, "if set, only the elected replica of the controller reconciles the certificates, so that it can run with several replicas")
+	controllerCmd.Flags().StringVar(&leaderElectionNS, "leader-election-namespace", "", "namespace of the leader election lock, defaults to the namespace the controller runs in")
+	controllerCmd.Flags().DurationVar(&retryBaseDelay, "retry-base-delay", 5*time.Second, "delay before retrying a failed secret of the secret work queue, doubled on each failure")
+	controllerCmd.Flags().DurationVar(&retryMaxDelay, "retry-max-delay", 10*time.Minute, "maximum delay before retrying a failed secret of the secret work queue")
+	controllerCmd.Flags().Float64Var(&queueQPS, "queue-qps", 10, "overall rate of the reconciles of the secret work queue per second")
+	controllerCmd.Flags().IntVar(&queueBurst, "queue-burst", 100, "burst of the reconciles of the secret work queue")
+	controllerCmd.Flags().BoolVar(&cacheSecrets, "cache-secrets", false, "if set, the secrets are read from an informer cache instead of the API server on each reconcile. Needs to list and watch secrets in the managed namespaces, which are all held in memory")
+	controllerCmd.Flags().BoolVar(&memoizeCA, "memoize-ca", true, "if set, the CA secret is only read again once it changed, checked by reading its metadata, instead of on each reconcile. The CA is held in memory, encrypted with a key generated on start")
+	controllerCmd.Flags().BoolVar(&recreateSecrets, "recreate-secrets", false, "if set, regenerates the node and client certs of the secrets deleted by mistake right away through the secret work queue, signed by the current CA. Multi-tenant mode always does")
+	controllerCmd.Flags().DurationVar(&verifyInterval, "verify-interval", 0, "interval at which the certs are verified as by the verify command, with the results recorded in metrics and in the Compliant condition of the CrdbPKIStatus with --pki-status. 0 disables the verification")
+	controllerCmd.Flags().StringVar(&verifyNearExpiry, "verify-near-expiry", "", "report the certs expiring within this duration in the verification, the certs due for rotation if empty")
+	controllerCmd.Flags().IntVar(&verifyMinRSA, "verify-min-rsa-bits", generator.DefaultMinRSABits, "smallest RSA key size not reported as weak by the verification")
+	controllerCmd.Flags().IntVar(&verifyMinECDSA, "verify-min-ecdsa-bits", generator.DefaultMinECDSABits, "smallest ECDSA curve size not reported as weak by the verification")
+	addThrottleFlags(controllerCmd)
+	addHealthCheckFlags(controllerCmd)
+	rootCmd.AddCommand(controllerCmd)
+}
+
+func runController(cmd *cobra.Command, args []string) {
+	if memoizeCA {
+		memo, err := generator.NewCAMemo()
+		if err != nil {
+			exitOnError(errors.Wrap(err, "failed to create the CA memo"))
+		}
+		sharedCAMemo = memo
+	}
+
+	if releaseSelector != "" {
+		runMultiTenantController()
+		return
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	stsName, exists := os.LookupEnv("STATEFULSET_NAME")
+	if !exists {
+		exitOnConfigError("Required STATEFULSET_NAME env not found")
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	mgr, err := controllerruntime.NewManager(restConfig, withLeaderElection(controllerruntime.Options{
+		Scheme:             scheme,
+		Namespace:          namespace,
+		MetricsBindAddress: metricsAddr,
+	}))
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to create controller manager"))
+	}
+	withSecretCache(mgr, namespace)
+
+	if enableReadinessGate {
+		r := &controller.ReadinessGateReconciler{
+			Client:          mgr.GetClient(),
+			Namespace:       namespace,
+			StatefulSetName: stsName,
+			NodeSecretName:  stsName + "-node-secret",
+			Port:            readinessGatePort,
+			Clock:           runClock,
+		}
+		if err := r.SetupWithManager(mgr); err != nil {
+			exitOnError(errors.Wrap(err, "failed to set up readiness gate controller"))
+		}
+	}
+
+	// the configs of the controllers are rebuilt when the config files change
+	var configs []*liveConfig
+
+	// the renewals, rotation requests, deleted secrets and scale ups are all reconciled through the secret
+	// work queue, so that no secret is written by two reconciles at once
+	resync, err := time.ParseDuration(resyncPeriod)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse resync-period duration %s", err.Error())
+	}
+
+	// the check interval is set by the initial config, a reloaded lifetime only changes the cron
+	var interval time.Duration
+	cfg := newLiveConfig(func() (generator.GenerateCert, error) {
+		genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+		if err != nil {
+			return genCert, err
+		}
+		genCert.ReadinessWait, genCert.PodUpdateTimeout = rolloutTimeouts()
+		genCert.AnnotateStatefulSet = annotateStatefulSet
+		applyHealthCheckFlags(&genCert)
+		genCert.RotateCACert = true
+		genCert.RotateNodeCert = true
+		genCert.RotateClientCert = true
+
+		// short-lived certificates are renewed by the controller rather than by the rotation job
+		if interval == 0 {
+			interval = resync
+			if genCert.RenewalRatio > 0 && renewalInterval(genCert) < interval {
+				interval = renewalInterval(genCert)
+			}
+		}
+		genCert.CACronSchedule = defaultString(caCron, "@every "+resync.String())
+		genCert.NodeAndClientCronSchedule = defaultString(nodeAndClientCron, "@every "+interval.String())
+		return genCert, nil
+	})
+	configs = append(configs, cfg)
+	requeue := make(chan event.GenericEvent)
+
+	r := &controller.SecretReconciler{
+		Client:           mgr.GetClient(),
+		Namespace:        namespace,
+		StatefulSetName:  stsName,
+		ResyncPeriod:     interval,
+		RateLimiter:      secretRateLimiter(),
+		RotationRequests: rotationRequests,
+		Secrets: func(ctx context.Context, namespace, _ string) ([]string, error) {
+			genCert := cfg.get()
+			return genCert.ManagedSecrets(ctx, namespace)
+		},
+		BeginSync: func(ctx context.Context, namespace, _ string) (controller.SecretSync, error) {
+			genCert := cfg.get()
+			return beginSync(ctx, &genCert, namespace)
+		},
+		RecreateSecrets: recreateSecrets,
+		Requeue:         requeue,
+		Clock:           runClock,
+	}
+	if err := r.SetupWithManager(mgr); err != nil {
+		exitOnError(errors.Wrap(err, "failed to set up secret controller"))
+	}
+	if renewalRatio > 0 {
+		log.Printf("Renewing the node and client certificates at %g of their lifetime, checking every %s", renewalRatio, interval)
+	}
+
+	// the certificates are audited by the controller rotating them rather than by a verification job
+	if verifyInterval > 0 {
+		opts := verifyOptions()
+		cfg := newLiveConfig(func() (generator.GenerateCert, error) {
+			return getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+		})
+		configs = append(configs, cfg)
+
+		r := &controller.VerificationReconciler{
+			Client:          mgr.GetClient(),
+			Namespace:       namespace,
+			StatefulSetName: stsName,
+			Interval:        verifyInterval,
+			Verify: func(ctx context.Context, ns, _ string) error {
+				genCert := cfg.get()
+				_, err := genCert.RecordVerification(ctx, ns, opts)
+				return err
+			},
+		}
+		if err := r.SetupWithManager(mgr); err != nil {
+			exitOnError(errors.Wrap(err, "failed to set up verification controller"))
+		}
+	}
+
+	if protectSecrets {
+		r := &controller.DeletionGuardReconciler{Client: mgr.GetClient(), Namespace: namespace}
+		if err := r.SetupWithManager(mgr); err != nil {
+			exitOnError(errors.Wrap(err, "failed to set up deletion guard controller"))
+		}
+	}
+
+	// the secret work queue checks the certificates against the reloaded config right away, the other
+	// controllers apply it on their next event
+	err = addConfigReloader(mgr, configs, reloadInterval, func(ctx context.Context) error {
+		requeue <- event.GenericEvent{Object: &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: stsName, Namespace: namespace},
+		}}
+		return nil
+	})
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to set up config reloader"))
+	}
+
+	if err := mgr.Start(controllerruntime.SetupSignalHandler()); err != nil {
+		exitOnError(errors.Wrap(err, "controller manager exited with error"))
+	}
+}
+
+// beginSync begins a sync of the secrets of the release, which the secret work queue reuses for the secrets
+// it reconciles in a row.
+func beginSync(ctx context.Context, genCert *generator.GenerateCert, namespace string) (controller.SecretSync, error) {
+	sync, err := genCert.BeginSync(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return sync, nil
+}
+
+// rolloutTimeouts returns the readiness wait and pod update timeout of the node certificate rollouts.
+func rolloutTimeouts() (time.Duration, time.Duration) {
+	readinessTimeout, err := time.ParseDuration(readinessWait)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse readiness-wait duration %s", err.Error())
+	}
+	podTimeout, err := time.ParseDuration(podUpdateTimeout)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse pod-update-timeout duration %s", err.Error())
+	}
+	return readinessTimeout, podTimeout
+}
+
+// renewalInterval returns the interval at which the certificates are checked for renewal, a quarter of the
+// time left between the renewal and the expiry of the shortest lived node or client certificate, so that a
+// failed renewal is retried well before the certificate expires.
+func renewalInterval(genCert generator.GenerateCert) time.Duration {
+	lifetime := genCert.NodeCertConfig.Duration
+	if genCert.ClientCertConfig.Duration < lifetime {
+		lifetime = genCert.ClientCertConfig.Duration
+	}
+
+	interval := time.Duration(float64(lifetime) * (1 - genCert.RenewalRatio) / 4).Round(time.Minute)
+	if interval < time.Minute {
+		return time.Minute
+	}
+	return interval
+}
+
+// runMultiTenantController runs the controller managing the certificates of every release matching the
+// release selector, with the config of the flags.
+func runMultiTenantController() {
+	selector, err := labels.Parse(releaseSelector)
+	if err != nil {
+		exitOnConfigErrorf("invalid release-selector %s", err.Error())
+	}
+
+	nsSelector, err := labels.Parse(namespaceSelector)
+	if err != nil {
+		exitOnConfigErrorf("invalid namespace-selector %s", err.Error())
+	}
+
+	resync, err := time.ParseDuration(resyncPeriod)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse resync-period duration %s", err.Error())
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	options := controllerruntime.Options{
+		Scheme:             scheme,
+		MetricsBindAddress: metricsAddr,
+	}
+	// only the statefulsets of the watched namespaces are cached, so that no cluster wide access is needed
+	if len(watchNamespaces) > 0 {
+		options.NewCache = cache.MultiNamespacedCacheBuilder(watchNamespaces)
+	}
+
+	mgr, err := controllerruntime.NewManager(restConfig, withLeaderElection(options))
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to create controller manager"))
+	}
+	// the configs read the secrets through the cache, so they are built once the manager is created
+	withSecretCache(mgr, watchNamespaces...)
+
+	// the resync period is set by the initial config, and the releases share the Throttle across reloads
+	var lowered bool
+	rotationThrottle := newThrottle()
+	cfg := newLiveConfig(func() (generator.GenerateCert, error) {
+		genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+		if err != nil {
+			return genCert, err
+		}
+		genCert.ReadinessWait, genCert.PodUpdateTimeout = rolloutTimeouts()
+		if genCert.RenewalRatio > 0 && !lowered {
+			if interval := renewalInterval(genCert); interval < resync {
+				log.Printf("Lowering the resync period to %s to renew the short-lived certificates in time", interval)
+				resync = interval
+			}
+			lowered = true
+		}
+		genCert.AnnotateStatefulSet = annotateStatefulSet
+		genCert.Throttle = rotationThrottle
+		applyHealthCheckFlags(&genCert)
+		genCert.CACronSchedule = defaultString(caCron, "@every "+resync.String())
+		genCert.NodeAndClientCronSchedule = defaultString(nodeAndClientCron, "@every "+resync.String())
+		return genCert, nil
+	})
+
+	requeue := make(chan event.GenericEvent)
+	namespaces := controller.NamespaceFilter{
+		Allowed:  watchNamespaces,
+		Denied:   ignoreNamespaces,
+		Selector: nsSelector,
+	}
+	r := &controller.SecretReconciler{
+		Client:           mgr.GetClient(),
+		Selector:         selector,
+		Namespaces:       namespaces,
+		APIReader:        mgr.GetAPIReader(),
+		ResyncPeriod:     resync,
+		RateLimiter:      secretRateLimiter(),
+		RotationRequests: true,
+		Secrets: func(ctx context.Context, namespace, statefulSetName string) ([]string, error) {
+			genCert := cfg.get()
+			release := genCert.ForStatefulSet(statefulSetName)
+			return release.ManagedSecrets(ctx, namespace)
+		},
+		BeginSync: func(ctx context.Context, namespace, statefulSetName string) (controller.SecretSync, error) {
+			genCert := cfg.get()
+			release := genCert.ForStatefulSet(statefulSetName)
+			release.RotateCACert = true
+			release.RotateNodeCert = true
+			release.RotateClientCert = true
+			return beginSync(ctx, &release, namespace)
+		},
+		RecreateSecrets: true,
+		Requeue:         requeue,
+		Clock:           runClock,
+	}
+	if err := r.SetupWithManager(mgr); err != nil {
+		exitOnError(errors.Wrap(err, "failed to set up secret controller"))
+	}
+
+	if verifyInterval > 0 {
+		opts := verifyOptions()
+		r := &controller.VerificationReconciler{
+			Client:     mgr.GetClient(),
+			Selector:   selector,
+			Namespaces: namespaces,
+			APIReader:  mgr.GetAPIReader(),
+			Interval:   verifyInterval,
+			Verify: func(ctx context.Context, namespace, statefulSetName string) error {
+				genCert := cfg.get()
+				release := genCert.ForStatefulSet(statefulSetName)
+				_, err := release.RecordVerification(ctx, namespace, opts)
+				return err
+			},
+		}
+		if err := r.SetupWithManager(mgr); err != nil {
+			exitOnError(errors.Wrap(err, "failed to set up verification controller"))
+		}
+	}
+
+	if protectSecrets {
+		r := &controller.DeletionGuardReconciler{Client: mgr.GetClient()}
+		if err := r.SetupWithManager(mgr); err != nil {
+			exitOnError(errors.Wrap(err, "failed to set up deletion guard controller"))
+		}
+	}
+
+	// every release is checked against the reloaded config right away
+	err = addConfigReloader(mgr, []*liveConfig{cfg}, reloadInterval, func(ctx context.Context) error {
+		var list appsv1.StatefulSetList
+		if err := mgr.GetClient().List(ctx, &list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return err
+		}
+		for i := range list.Items {
+			requeue <- event.GenericEvent{Object: &list.Items[i]}
+		}
+		return nil
+	})
+	if err != nil {
+		exitOnError(errors.Wrap(err, "failed to set up config reloader"))
+	}
+
+	log.Printf("Managing the certificates of the releases matching [%s]", selector)
+	if err := mgr.Start(controllerruntime.SetupSignalHandler()); err != nil {
+		exitOnError(errors.Wrap(err, "controller manager exited with error"))
+	}
+}
+
+// secretRateLimiter returns the rate limiter of the secret work queue, which retries each failed secret with
+// an exponential backoff and limits the overall rate of the reconciles.
+func secretRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(queueQPS), queueBurst)},
+	)
+}
+
+// withSecretCache makes the certificate generation read the secrets of the namespaces cached by the manager,
+// all namespaces if none, from its cache with --cache-secrets.
+func withSecretCache(mgr manager.Manager, namespaces ...string) {
+	if cacheSecrets {
+		cl = kube.NewCachedSecretsClient(mgr.GetCache(), cl, namespaces...)
+	}
+}
+
+// withLeaderElection enables the leader election of the manager if requested, so that only one of the
+// replicas of the controller reconciles the certificates at a time.
+func withLeaderElection(options controllerruntime.Options) controllerruntime.Options {
+	options.LeaderElection = leaderElect
+	options.LeaderElectionID = "crdb-self-signer"
+	options.LeaderElectionNamespace = leaderElectionNS
+	return options
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/csiprovider"
+)
+
+// csiProviderCmd represents the csi-provider command
+var csiProviderCmd = &cobra.Command{
+	Use:   "csi-provider",
+	Short: "delivers client certificates through the Secrets Store CSI driver",
+	Long: `csi-provider sub-command serves the provider API of the Secrets Store CSI driver on a unix socket, so that
+the client certificates generated by the self-signer are mounted in pods through SecretProviderClasses of the
+crdb-certs provider. It runs as a daemonset on every node of the driver`,
+	Run: runCSIProvider,
+}
+
+var csiSocket string
+
+func init() {
+	csiProviderCmd.Flags().StringVar(&csiSocket, "socket", "/etc/kubernetes/secrets-store-csi-providers/"+csiprovider.Name+".sock", "unix socket the driver calls the provider on")
+	rootCmd.AddCommand(csiProviderCmd)
+}
+
+func runCSIProvider(cmd *cobra.Command, args []string) {
+	// the socket of a previous run is left behind when the pod is killed
+	if err := os.Remove(csiSocket); err != nil && !os.IsNotExist(err) {
+		exitOnConfigErrorf("failed to remove the socket %s: %s", csiSocket, err)
+	}
+
+	listener, err := net.Listen("unix", csiSocket)
+	if err != nil {
+		exitOnConfigErrorf("failed to listen on %s: %s", csiSocket, err)
+	}
+
+	provider := &csiprovider.Provider{Client: cl}
+	log.Printf("Serving the %s provider of the Secrets Store CSI driver on %s", csiprovider.Name, csiSocket)
+	if err := http.Serve(listener, provider.Handler()); err != nil {
+		exitOnError(err)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/diagnostics"
+	"github.com/cockroachdb/helm-charts/pkg/redact"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/version"
+)
+
+var (
+	diagnosticsConfigMap string
+	diagnosticsDir       string
+	diagnosticsLogLines  int
+	diagnosticsCmd       *cobra.Command
+	redactor             *redact.Redactor
+	logTail              *diagnostics.Tail
+)
+
+// diagnosticsEnvs are the envs recorded in the diagnostic bundle.
+var diagnosticsEnvs = []string{"NAMESPACE", "STATEFULSET_NAME", "CLUSTER_DOMAIN"}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&diagnosticsConfigMap, "diagnostics-configmap", "", "on failure, write a diagnostic bundle with the sanitized config, the metadata of the secrets, the last log lines and the API error to this ConfigMap")
+	rootCmd.PersistentFlags().StringVar(&diagnosticsDir, "diagnostics-dir", "", "on failure, write the diagnostic bundle to a file of this directory, such as an emptyDir volume")
+	rootCmd.PersistentFlags().IntVar(&diagnosticsLogLines, "diagnostics-log-lines", diagnostics.DefaultLogLines, "number of the last log lines kept in the diagnostic bundle")
+}
+
+func diagnosticsEnabled() bool {
+	return diagnosticsConfigMap != "" || diagnosticsDir != ""
+}
+
+// captureLogs returns the writer the logs are written to, also keeping their last lines for the diagnostic
+// bundle if enabled.
+func captureLogs(w io.Writer) io.Writer {
+	if !diagnosticsEnabled() {
+		return w
+	}
+
+	logTail = diagnostics.NewTail(diagnosticsLogLines)
+	return io.MultiWriter(w, logTail)
+}
+
+// startDiagnostics records the command the diagnostic bundle is written for.
+func startDiagnostics(cmd *cobra.Command) {
+	diagnosticsCmd = cmd
+}
+
+// writeDiagnostics writes the diagnostic bundle of the error the command fails with, if enabled. A failure to
+// write it is only logged, as the command fails anyway.
+func writeDiagnostics(err error, code int) {
+	if !diagnosticsEnabled() || diagnosticsCmd == nil {
+		return
+	}
+
+	// the redaction isn't set up yet if the config failed before, in which case the default filters apply
+	r := redactor
+	if r == nil {
+		r, _ = redact.New()
+	}
+
+	bundle := &diagnostics.Bundle{
+		Command:  diagnosticsCmd.CommandPath(),
+		Version:  version.Version,
+		Time:     time.Now(),
+		ExitCode: code,
+		Error:    r.Redact(err.Error()),
+		APIError: diagnostics.APIErrorOf(err),
+		Config:   diagnostics.SanitizeFlags(diagnosticsCmd.Flags(), r),
+	}
+	if bundle.APIError != nil {
+		bundle.APIError.Message = r.Redact(bundle.APIError.Message)
+	}
+	diagnostics.SanitizeEnv(bundle.Config, diagnosticsEnvs, r)
+	if logTail != nil {
+		bundle.Logs = logTail.Lines()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	namespace := os.Getenv("NAMESPACE")
+	if stsName, ok := os.LookupEnv("STATEFULSET_NAME"); ok && cl != nil {
+		secrets, err := diagnostics.ListSecrets(ctx, cl, namespace, map[string]string{resource.StatefulSetLabel: stsName})
+		if err != nil {
+			bundle.SecretsError = err.Error()
+		}
+		bundle.Secrets = secrets
+	}
+
+	if diagnosticsDir != "" {
+		path, err := bundle.WriteDir(diagnosticsDir)
+		if err != nil {
+			log.Printf("Failed to write the diagnostic bundle: %s", err)
+		} else {
+			log.Printf("Wrote the diagnostic bundle to %s", path)
+		}
+	}
+
+	if diagnosticsConfigMap != "" && cl != nil {
+		if err := bundle.WriteConfigMap(ctx, cl, namespace, diagnosticsConfigMap); err != nil {
+			log.Printf("Failed to write the diagnostic bundle: %s", err)
+		} else {
+			log.Printf("Wrote the diagnostic bundle to ConfigMap %s", diagnosticsConfigMap)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/policy"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// Exit codes of the generate, rotate and wait commands, so that Helm hooks and CI pipelines can branch on
+// the type of failure.
+const (
+	// exitError is returned for any failure not covered by the other codes.
+	exitError = 1
+	// exitConfigError is returned when the flags, environment or config file are invalid, or the service
+	// account is missing permissions.
+	exitConfigError = 2
+	// exitNamespaceTerminating is returned when the namespace is being deleted, in which case no
+	// resource is created and retrying is pointless.
+	exitNamespaceTerminating = 3
+	// exitTransientError is returned when the API server was unavailable, overloaded or timed out, in
+	// which case running the command again may succeed.
+	exitTransientError = 4
+	// exitValidationFailure is returned when a secret doesn't hold a usable certificate, or the certificate log
+	// was tampered with.
+	exitValidationFailure = 5
+	// exitPartialRotation is returned when the command failed after rotating some of the secrets.
+	exitPartialRotation = 6
+	// exitRolloutHalted is returned when the pods weren't all restarted after a rotation because the cluster
+	// was degraded. The next run resumes the rollout.
+	exitRolloutHalted = 7
+	// exitPolicyViolation is returned when a certificate violates the PKI policy, in which case running the
+	// command again fails the same way until the policy or the config is changed.
+	exitPolicyViolation = 8
+	// exitNonCompliant is returned by verify --strict when the certificates are usable but near expiry, with
+	// weak keys or names drifted from the config.
+	exitNonCompliant = 9
+)
+
+// exitCode returns the exit code of an error returned while generating or rotating certificates. Config
+// errors are detected before that and exit through exitOnConfigError.
+func exitCode(err error) int {
+	var partial *generator.PartialRotationError
+	var missing *kube.MissingPermissionsError
+	var violation *policy.ViolationError
+
+	switch {
+	case kube.IsNamespaceTerminating(err):
+		return exitNamespaceTerminating
+	case errors.Is(err, kube.ErrRolloutHalted):
+		return exitRolloutHalted
+	case errors.As(err, &violation):
+		return exitPolicyViolation
+	case errors.Is(err, generator.ErrNonCompliant):
+		return exitNonCompliant
+	case errors.As(err, &partial):
+		return exitPartialRotation
+	case errors.As(err, &missing):
+		return exitConfigError
+	case errors.Is(err, resource.ErrInvalidSecret), errors.Is(err, resource.ErrCertLogTampered):
+		return exitValidationFailure
+	case kube.IsTransient(err):
+		return exitTransientError
+	default:
+		return exitError
+	}
+}
+
+// errorClasses are the classes of the exit codes reported in the usage stats.
+var errorClasses = map[int]string{
+	exitError:                "error",
+	exitConfigError:          "config",
+	exitNamespaceTerminating: "namespace-terminating",
+	exitTransientError:       "transient",
+	exitValidationFailure:    "validation",
+	exitPartialRotation:      "partial-rotation",
+	exitRolloutHalted:        "rollout-halted",
+	exitPolicyViolation:      "policy-violation",
+	exitNonCompliant:         "non-compliant",
+}
+
+// exitOnError logs the error and exits with its exit code.
+func exitOnError(err error) {
+	code := exitCode(err)
+	sendTelemetry(errorClasses[code])
+	writeDiagnostics(err, code)
+	if code == exitNamespaceTerminating {
+		log.Printf("Namespace is terminating, skipping certificate generation: %s", err)
+	} else {
+		log.Print(err)
+	}
+
+	os.Exit(code)
+}
+
+// exitOnConfigError logs the error and exits with exitConfigError.
+func exitOnConfigError(v ...interface{}) {
+	exitOnConfigMessage(fmt.Sprint(v...))
+}
+
+// exitOnConfigErrorf formats the error, logs it and exits with exitConfigError.
+func exitOnConfigErrorf(format string, v ...interface{}) {
+	exitOnConfigMessage(fmt.Sprintf(format, v...))
+}
+
+func exitOnConfigMessage(msg string) {
+	log.Print(msg)
+	sendTelemetry(errorClasses[exitConfigError])
+	writeDiagnostics(errors.New(msg), exitConfigError)
+	os.Exit(exitConfigError)
+}
+
+// panicExitCode is the exit code of the Go runtime when the command panics, e.g. with log.Panic.
+const panicExitCode = 2
+
+// writePanicDiagnostics writes the diagnostic bundle of a panic of the command, before it carries on.
+func writePanicDiagnostics() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	writeDiagnostics(err, panicExitCode)
+	panic(r)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "exports the client certificates to a cockroach certs directory",
+	Long: `export sub-command writes the CA certificate and the client certificate and key of a user, read from the
+cluster secrets, to a directory with the layout the cockroach CLI expects`,
+	Run: export,
+}
+
+var (
+	exportCertsDir string
+	exportUser     string
+)
+
+func init() {
+	exportCmd.Flags().StringVar(&exportCertsDir, "certs-dir", "certs", "directory the certificates are written to")
+	exportCmd.Flags().StringVar(&exportUser, "user", security.RootUser, "user of the exported client certificate")
+	rootCmd.AddCommand(exportCmd)
+}
+
+func export(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	if err := genCert.Export(ctx, namespace, exportUser, exportCertsDir); err != nil {
+		exitOnError(err)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/federation"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// federateCmd represents the federate-ca command
+var federateCmd = &cobra.Command{
+	Use:   "federate-ca",
+	Short: "copies the CA secret to other clusters",
+	Long: `federate-ca sub-command copies the CA secret of the cluster selected by --context to the clusters of ` +
+		`the --target-contexts, so that multi-region CockroachDB clusters trust the same CA`,
+	Run: federateCA,
+}
+
+var (
+	targetContexts []string
+	federateSecret string
+	federateForce  bool
+)
+
+func init() {
+	federateCmd.Flags().StringSliceVar(&targetContexts, "target-contexts", nil, "kubeconfig contexts of the clusters to copy the CA secret to")
+	federateCmd.Flags().StringVar(&federateSecret, "secret", "", "name of the CA secret. Defaults to the secret holding the CA key of the statefulset")
+	federateCmd.Flags().BoolVar(&federateForce, "force", false, "if set, overwrites a different CA existing in a target cluster")
+	if err := federateCmd.MarkFlagRequired("target-contexts"); err != nil {
+		exitOnError(err)
+	}
+	rootCmd.AddCommand(federateCmd)
+}
+
+func federateCA(cmd *cobra.Command, args []string) {
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		log.Panic("Required NAMESPACE env not found")
+	}
+
+	// the CA key secret with --split-ca-secret, as the CA secret then only holds the CA certificate, which the
+	// self-signer of each target cluster publishes from it
+	secretName := federateSecret
+	if secretName == "" {
+		genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+		if err != nil {
+			exitOnConfigError(err)
+		}
+		secretName = genCert.CAKeySecret()
+	}
+
+	var targets []federation.Target
+	for _, name := range targetContexts {
+		config, err := kube.GetConfig(kubeconfig, name)
+		if err != nil {
+			log.Panicf("Failed to load kubeconfig context %s: %s", name, err)
+		}
+
+		targetClient, err := newClient(config)
+		if err != nil {
+			log.Panicf("Failed to create client for context %s: %s", name, err)
+		}
+
+		targets = append(targets, federation.Target{
+			Name:     name,
+			Resource: resource.NewKubeResource(ctx, targetClient, namespace, kube.ApplyPersister),
+		})
+	}
+
+	source := resource.NewKubeResource(ctx, cl, namespace, kube.ApplyPersister)
+	if err := federation.FederateCA(source, secretName, targets, federateForce); err != nil {
+		exitOnError(err)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "garbage collects the secrets of deleted releases",
+	Long: `gc sub-command lists the secrets written by the self-signer whose StatefulSet no longer exists. With --delete,
+the orphaned secrets are marked with the time they were first found orphaned and deleted once the grace period
+has passed, so that a release which is reinstalled in the meantime keeps its certificates`,
+	Run: gc,
+}
+
+var (
+	gcDelete bool
+	gcGrace  time.Duration
+	gcJSON   bool
+)
+
+func init() {
+	gcCmd.Flags().BoolVar(&gcDelete, "delete", false, "mark the orphaned secrets and delete them after the grace period, instead of only listing them")
+	gcCmd.Flags().DurationVar(&gcGrace, "grace-period", 24*time.Hour, "time a secret must be orphaned for before it is deleted")
+	gcCmd.Flags().BoolVar(&gcJSON, "json", false, "print the orphaned secrets as JSON")
+	rootCmd.AddCommand(gcCmd)
+}
+
+func gc(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	if gcGrace < 0 {
+		exitOnConfigError("--grace-period can't be negative")
+	}
+
+	orphaned, err := genCert.CollectOrphanedSecrets(ctx, namespace, gcGrace, !gcDelete)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if gcJSON {
+		out, err := json.MarshalIndent(orphaned, "", "  ")
+		if err != nil {
+			exitOnError(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SECRET\tSTATEFULSET\tORPHANED SINCE\tSTATE")
+	for _, o := range orphaned {
+		since, state := "", "candidate"
+		if !o.OrphanedSince.IsZero() {
+			since = o.OrphanedSince.Format(time.RFC3339)
+		}
+		switch {
+		case o.Deleted:
+			state = "deleted"
+		case gcDelete:
+			state = fmt.Sprintf("deleted after %s", o.OrphanedSince.Add(gcGrace).Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Secret, o.StatefulSet, since, state)
+	}
+	w.Flush()
+}
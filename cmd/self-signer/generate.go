@@ -17,11 +17,12 @@ limitations under the License.
 package self_signer
 
 import (
-	"log"
 	"os"
 
 	"github.com/spf13/cobra"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
 )
 
 // generateCmd represents the generate command
@@ -36,35 +37,66 @@ var (
 	caDuration, nodeDuration, clientDuration string
 	caExpiry, nodeExpiry, clientExpiry       string
 	caSecret                                 string
+	clientKeyAlgorithm                       string
 	clientOnly                               bool
+	provisionSQLUsers                        bool
+	sqlPort                                  int
+	rootPassword                             bool
+	rootPasswordSecret                       string
 )
 
 func init() {
 	generateCmd.Flags().BoolVar(&clientOnly, "client-only", false, "generate certificates for custom user")
+	generateCmd.Flags().BoolVar(&provisionSQLUsers, "provision-sql-users", false, "create the SQL users of the generated client certificates, requires the cluster to be running")
+	generateCmd.Flags().IntVar(&sqlPort, "sql-port", 26257, "SQL port of the cluster public service, used to provision SQL users")
+	addRootPasswordFlags(generateCmd)
 	rootCmd.AddCommand(generateCmd)
 }
 
+// addRootPasswordFlags adds the flags generating the password of the root SQL user to the command.
+func addRootPasswordFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&rootPassword, "root-password", false, "generate a password for the root SQL user, stored in a secret and set in the cluster once it is available")
+	cmd.Flags().StringVar(&rootPasswordSecret, "root-password-secret", "", "secret the root password is stored in, defaults to <statefulset>-root-password")
+}
+
 func generate(cmd *cobra.Command, args []string) {
 
 	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
 	if err != nil {
-		panic(err)
+		exitOnConfigError(err)
 	}
 
-	genCert.CaSecret = caSecret
+	genCert.ProvisionSQLUsers = provisionSQLUsers
+	genCert.SQLPort = sqlPort
+	applyRootPasswordFlags(&genCert)
 
 	namespace, exists := os.LookupEnv("NAMESPACE")
 	if !exists {
-		log.Panic("Required NAMESPACE env not found")
+		exitOnConfigError("Required NAMESPACE env not found")
 	}
 
 	if clientOnly {
+		// the client certificate jobs name the CA secret through the CA_SECRET env
+		if caSecret, exists := os.LookupEnv("CA_SECRET"); exists {
+			genCert.CaSecret = caSecret
+		}
 		if err := genCert.ClientCertGenerate(ctx, namespace); err != nil {
-			log.Panic(err)
+			exitOnError(err)
 		}
 	} else {
 		if err := genCert.Do(ctx, namespace); err != nil {
-			log.Panic(err)
+			exitOnError(err)
 		}
 	}
 }
+
+// applyRootPasswordFlags enables the root password if set by the flags, in addition to the values or
+// config file.
+func applyRootPasswordFlags(genCert *generator.GenerateCert) {
+	if rootPassword {
+		genCert.RootPassword = true
+	}
+	if rootPasswordSecret != "" {
+		genCert.RootPasswordSecretName = rootPasswordSecret
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// inspectCmd represents the inspect command
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <secret>",
+	Short: "prints the certificates of a secret",
+	Long: `inspect sub-command prints the annotations of a secret and the subject, issuer, serial, fingerprint, validity and
+hosts of the certificates it holds. Private keys are never printed`,
+	Args: cobra.ExactArgs(1),
+	Run:  inspect,
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+}
+
+func inspect(cmd *cobra.Command, args []string) {
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	secret, err := resource.LoadTLSSecret(args[0], resource.NewKubeResource(ctx, cl, namespace, kube.DefaultPersister))
+	if err != nil {
+		exitOnError(err)
+	}
+
+	fmt.Printf("Secret: %s/%s\n", namespace, args[0])
+
+	annotations := secret.Secret().Annotations
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Println("Annotations:")
+	for _, key := range keys {
+		fmt.Printf("  %s: %s\n", key, annotations[key])
+	}
+
+	for _, key := range []string{corev1.TLSCertKey, resource.CaCert} {
+		data, ok := secret.Secret().Data[key]
+		if !ok {
+			continue
+		}
+
+		certs, err := security.ParseCerts(data)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		for i, cert := range certs {
+			fmt.Printf("\n%s [%d]:\n", key, i)
+			printCert(cert)
+		}
+	}
+}
+
+func printCert(cert *x509.Certificate) {
+	sum := sha256.Sum256(cert.Raw)
+
+	fmt.Printf("  Subject:     %s\n", cert.Subject)
+	fmt.Printf("  Issuer:      %s\n", cert.Issuer)
+	fmt.Printf("  Serial:      %s\n", cert.SerialNumber)
+	fmt.Printf("  SHA-256:     %s\n", hex.EncodeToString(sum[:]))
+	fmt.Printf("  Not Before:  %s\n", cert.NotBefore.Format(time.RFC3339))
+	fmt.Printf("  Not After:   %s\n", cert.NotAfter.Format(time.RFC3339))
+	fmt.Printf("  CA:          %t\n", cert.IsCA)
+
+	hosts := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	if len(hosts) > 0 {
+		fmt.Printf("  Hosts:       %s\n", strings.Join(hosts, ", "))
+	}
+}
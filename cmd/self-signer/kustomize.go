@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/rest"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+)
+
+// kustomizeCmd represents the kustomize command
+var kustomizeCmd = &cobra.Command{
+	Use:   "kustomize",
+	Short: "emits a Kustomize component managing the certificates",
+	Long: `kustomize sub-command writes a Kustomize component, matching the cert configuration of its flags, which
+deploys the self-signer job with its permissions and config files, and patches the CockroachDB statefulset to mount
+the generated certificates, for clusters deployed with Kustomize instead of the chart`,
+	// the component is written without reaching the cluster
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              kustomize,
+}
+
+var (
+	kustomizeDir            string
+	kustomizeImage          string
+	kustomizeContainer      string
+	kustomizeServiceAccount string
+)
+
+// unforwardedFlags are the root flags which are not passed on to the job of the component, as they
+// select the cluster the command runs against, are hidden, or are read from the config files.
+var unforwardedFlags = map[string]bool{"kubeconfig": true, "context": true, "now": true, "chaos": true, "config": true, "values": true}
+
+func init() {
+	kustomizeCmd.Flags().StringVar(&kustomizeDir, "output-dir", "self-signer", "directory the component is written to")
+	kustomizeCmd.Flags().StringVar(&kustomizeImage, "image", "gcr.io/cockroachlabs-helm-charts/cockroach-self-signer-cert:1.3", "image of the self-signer")
+	kustomizeCmd.Flags().StringVar(&kustomizeContainer, "container", "db", "name of the CockroachDB container of the statefulset")
+	kustomizeCmd.Flags().StringVar(&kustomizeServiceAccount, "pods-service-account", "", "service account of the CockroachDB pods, which read their node secret with --per-node-certs. Defaults to the statefulset name")
+	rootCmd.AddCommand(kustomizeCmd)
+}
+
+func kustomize(cmd *cobra.Command, args []string) {
+	// the clients of the config are never used, as the component is only written
+	restConfig = &rest.Config{}
+
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	opts := generator.KustomizeOptions{
+		Image:          kustomizeImage,
+		ConfigFiles:    map[string][]byte{},
+		Container:      kustomizeContainer,
+		ServiceAccount: defaultString(kustomizeServiceAccount, genCert.DiscoveryServiceName),
+	}
+
+	// the root flags set on the command line configure the certs
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if rootCmd.PersistentFlags().Lookup(f.Name) != nil && !unforwardedFlags[f.Name] {
+			opts.Args = append(opts.Args, fmt.Sprintf("--%s=%s", f.Name, flagValue(f)))
+		}
+	})
+
+	for flag, path := range map[string]string{"config": configFile, "values": valuesFile} {
+		if path == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			exitOnConfigErrorf("failed to read the %s file: %s", flag, err)
+		}
+		opts.ConfigFiles[flag] = content
+	}
+
+	files, err := genCert.KustomizeComponent(opts)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if err := os.MkdirAll(kustomizeDir, 0755); err != nil {
+		exitOnConfigErrorf("failed to create %s: %s", kustomizeDir, err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(kustomizeDir, name), content, 0644); err != nil {
+			exitOnError(err)
+		}
+	}
+	log.Printf("Wrote the Kustomize component to %s", kustomizeDir)
+}
+
+// flagValue returns the value of the flag as given on the command line. Slices are printed by pflag
+// within brackets, which their flags don't parse.
+func flagValue(f *pflag.Flag) string {
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		return strings.Join(slice.GetSlice(), ",")
+	}
+	return f.Value.String()
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"github.com/cockroachdb/helm-charts/pkg/oidc"
+	"github.com/cockroachdb/helm-charts/pkg/proxy"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+// loginCmd represents the login command
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "requests a short-lived client certificate of your own SQL user",
+	Long: `login sub-command authenticates you with the OIDC provider through the device flow and requests a
+short-lived client certificate of the SQL user mapped from your identity from the signer API, writing it to the
+certs directory along with the CA certificate`,
+	// login talks to the OIDC provider and the signer, not the cluster
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              login,
+}
+
+var (
+	loginIssuer, loginClientID   string
+	loginScopes                  []string
+	signerURL, signerCA          string
+	loginCertsDir, loginProxyURL string
+)
+
+func init() {
+	loginCmd.Flags().StringVar(&loginIssuer, "oidc-issuer", "", "issuer URL of the OIDC provider")
+	loginCmd.Flags().StringVar(&loginClientID, "oidc-client-id", "", "client ID registered with the OIDC provider for the device flow")
+	loginCmd.Flags().StringSliceVar(&loginScopes, "oidc-scopes", []string{"email", "groups"}, "scopes requested from the OIDC provider in addition to openid")
+	loginCmd.Flags().StringVar(&signerURL, "signer-url", "", "URL of the signer API, e.g. https://crdb-signer:8443")
+	loginCmd.Flags().StringVar(&signerCA, "signer-ca", "", "CA certificate the signer API is verified with, defaults to the system roots")
+	loginCmd.Flags().StringVar(&loginCertsDir, "certs-dir", "certs", "directory the CA certificate and the client certificate and key are written to")
+	loginCmd.Flags().StringVar(&loginProxyURL, "proxy", "", "proxy of the requests to the OIDC provider and the signer, defaults to the HTTPS_PROXY env")
+	rootCmd.AddCommand(loginCmd)
+}
+
+func login(cmd *cobra.Command, args []string) {
+	if loginIssuer == "" || loginClientID == "" || signerURL == "" {
+		exitOnConfigError("--oidc-issuer, --oidc-client-id and --signer-url are required")
+	}
+
+	httpClient, err := proxy.NewClient(loginProxyURL, 30*time.Second)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	provider, err := oidc.Discover(ctx, loginIssuer, httpClient)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	deviceCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	idToken, err := provider.DeviceFlow(deviceCtx, loginClientID, loginScopes, func(auth *oidc.DeviceAuthorization) {
+		if auth.VerificationURIComplete != "" {
+			fmt.Printf("Open %s to log in\n", auth.VerificationURIComplete)
+			return
+		}
+		fmt.Printf("Open %s and enter the code %s to log in\n", auth.VerificationURI, auth.UserCode)
+	})
+	if err != nil {
+		exitOnError(err)
+	}
+
+	key, pemKey, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	// the signer sets the subject of the certificate to the SQL user mapped from the ID token
+	csr, err := security.CreateCSR(key, "oidc-user", nil)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	conn, err := dialSignerAPI()
+	if err != nil {
+		exitOnConfigError(err)
+	}
+	defer conn.Close()
+
+	resp, err := signer.NewSignerClient(conn).IssueUserCert(ctx, &signer.IssueUserCertRequest{IdToken: idToken, Csr: csr})
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if err := security.WriteClientCerts(loginCertsDir, resp.User, resp.Ca, resp.Certificate, pemKey); err != nil {
+		exitOnError(err)
+	}
+
+	fmt.Printf("Wrote the client certificate of %s to %s", resp.User, loginCertsDir)
+	if len(resp.Roles) > 0 {
+		fmt.Printf(", granted roles: %s", strings.Join(resp.Roles, ", "))
+	}
+	fmt.Println()
+}
+
+// dialSignerAPI connects to the signer API of --signer-url, verifying its certificate with --signer-ca.
+func dialSignerAPI() (*grpc.ClientConn, error) {
+	u, err := url.Parse(signerURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid signer URL %q", signerURL)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if signerCA != "" {
+		pemCA, err := ioutil.ReadFile(signerCA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the signer CA: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemCA) {
+			return nil, fmt.Errorf("no certificate found in %s", signerCA)
+		}
+	}
+
+	proxyFunc, err := proxy.Func(loginProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return signer.DialAPI(address, tlsConfig, proxyFunc)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+)
+
+// offlineCmd represents the offline command
+var offlineCmd = &cobra.Command{
+	Use:   "offline",
+	Short: "signs the node and client certificates on an air-gapped machine holding the CA",
+	Long: `offline sub-command issues the node and client certificates without the CA in the cluster: export writes the
+requests of the certificates due to a bundle, sign signs the bundle on the air-gapped machine holding the CA, and
+import stores the signed certificates and rolls them out`,
+}
+
+var offlineExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "exports the requests of the node and client certificates due to a bundle",
+	Run:   offlineExport,
+}
+
+var offlineSignCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "signs the requests of a bundle with the CA, without access to the cluster",
+	// the bundle is signed on a machine without access to the cluster
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              offlineSign,
+}
+
+var offlineImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "stores the certificates of a signed bundle and rolls them out",
+	Run:   offlineImport,
+}
+
+var (
+	offlineBundle string
+	offlineOutput string
+	offlineCACert string
+	offlineCAKey  string
+)
+
+func init() {
+	offlineExportCmd.Flags().StringVar(&offlineBundle, "bundle", "", "path of the bundle the requests are written to")
+	offlineExportCmd.Flags().StringVar(&nodeAndClientCron, "node-client-cron", "", "cron of the node and client certificate rotation cron")
+	_ = offlineExportCmd.MarkFlagRequired("bundle")
+
+	offlineSignCmd.Flags().StringVar(&offlineBundle, "bundle", "", "path of the bundle of requests")
+	offlineSignCmd.Flags().StringVar(&offlineOutput, "output", "", "path of the signed bundle. Defaults to --bundle")
+	offlineSignCmd.Flags().StringVar(&offlineCACert, "ca-cert", "", "path of the CA certificate bundle")
+	offlineSignCmd.Flags().StringVar(&offlineCAKey, "ca-key", "", "path of the CA key")
+	_ = offlineSignCmd.MarkFlagRequired("bundle")
+	_ = offlineSignCmd.MarkFlagRequired("ca-cert")
+	_ = offlineSignCmd.MarkFlagRequired("ca-key")
+
+	offlineImportCmd.Flags().StringVar(&offlineBundle, "bundle", "", "path of the signed bundle")
+	offlineImportCmd.Flags().StringVar(&readinessWait, "readiness-wait", "30s", "readiness wait for each replica of crdb cluster")
+	offlineImportCmd.Flags().StringVar(&podUpdateTimeout, "pod-update-timeout", "2m", "time to wait for statefulset pod to restart and get to running state")
+	offlineImportCmd.Flags().StringVar(&nodeAndClientCron, "node-client-cron", "", "cron of the node and client certificate rotation cron")
+	_ = offlineImportCmd.MarkFlagRequired("bundle")
+	addHealthCheckFlags(offlineImportCmd)
+
+	offlineCmd.AddCommand(offlineExportCmd, offlineSignCmd, offlineImportCmd)
+	rootCmd.AddCommand(offlineCmd)
+}
+
+func offlineExport(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	genCert.NodeAndClientCronSchedule = nodeAndClientCron
+	bundle, err := genCert.ExportOffline(ctx, namespace)
+	if err != nil {
+		exitOnError(err)
+	}
+	if bundle == nil {
+		return
+	}
+
+	if err := writeOfflineBundle(offlineBundle, bundle); err != nil {
+		exitOnError(err)
+	}
+}
+
+func offlineSign(cmd *cobra.Command, args []string) {
+	bundle, err := readOfflineBundle(offlineBundle)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	caCert, err := ioutil.ReadFile(offlineCACert)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+	caKey, err := ioutil.ReadFile(offlineCAKey)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	nodeLifetime, err := time.ParseDuration(nodeDuration)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse node-duration %s", err.Error())
+	}
+	clientLifetime, err := time.ParseDuration(clientDuration)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse client-duration %s", err.Error())
+	}
+
+	if err := generator.SignOfflineBundle(bundle, caCert, caKey, runClock.Now(), nodeLifetime, clientLifetime); err != nil {
+		exitOnError(err)
+	}
+
+	output := offlineOutput
+	if output == "" {
+		output = offlineBundle
+	}
+	if err := writeOfflineBundle(output, bundle); err != nil {
+		exitOnError(err)
+	}
+}
+
+func offlineImport(cmd *cobra.Command, args []string) {
+	bundle, err := readOfflineBundle(offlineBundle)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	timeout, err := time.ParseDuration(readinessWait)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse readiness-wait duration %s", err.Error())
+	}
+	podTimeout, err := time.ParseDuration(podUpdateTimeout)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse pod-update-timeout duration %s", err.Error())
+	}
+	genCert.ReadinessWait = timeout
+	genCert.PodUpdateTimeout = podTimeout
+	genCert.NodeAndClientCronSchedule = nodeAndClientCron
+	applyHealthCheckFlags(&genCert)
+
+	if err := genCert.ImportOffline(ctx, namespace, bundle); err != nil {
+		exitOnError(err)
+	}
+}
+
+func readOfflineBundle(path string) (*generator.OfflineBundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle generator.OfflineBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+func writeOfflineBundle(path string, bundle *generator.OfflineBundle) error {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
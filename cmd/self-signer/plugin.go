@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+var pluginNamespace, pluginStatefulSet string
+
+// ExecutePlugin runs the self-signer as the kubectl crdb-certs plugin. The plugin only has the status,
+// rotate, recover-expired-ca, inspect, export, login and request-client-cert sub-commands, and reads the
+// namespace and statefulset from flags like kubectl does instead of the envs set in the self-signer pods.
+func ExecutePlugin() {
+	rootCmd.Use = "crdb-certs"
+	rootCmd.Short = "manages the certificates of a CockroachDB cluster deployed by the Helm chart"
+	rootCmd.Long = `kubectl crdb-certs inspects, rotates and exports the certificates generated by the self-signer of the
+CockroachDB Helm chart, using the current kubeconfig context`
+
+	plugin := map[*cobra.Command]bool{statusCmd: true, rotateCmd: true, recoverExpiredCACmd: true, inspectCmd: true, exportCmd: true, versionCmd: true, loginCmd: true, requestClientCertCmd: true}
+	for _, cmd := range rootCmd.Commands() {
+		if !plugin[cmd] {
+			rootCmd.RemoveCommand(cmd)
+		}
+	}
+
+	rootCmd.PersistentFlags().StringVarP(&pluginNamespace, "namespace", "n", "", "namespace of the cluster, defaults to the namespace of the kubeconfig context")
+	rootCmd.PersistentFlags().StringVar(&pluginStatefulSet, "statefulset", "", "name of the CockroachDB statefulset, e.g. <release>-cockroachdb")
+
+	preRun := rootCmd.PersistentPreRun
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		setPluginEnv(cmd)
+		preRun(cmd, args)
+	}
+
+	Execute()
+}
+
+// setPluginEnv sets the envs read by the sub-commands from the plugin flags.
+func setPluginEnv(cmd *cobra.Command) {
+	namespace := pluginNamespace
+	if namespace == "" {
+		var err error
+		if namespace, err = kube.GetNamespace(kubeconfig, kubeContext); err != nil {
+			exitOnConfigError("Failed to read the namespace of the kubeconfig context: ", err)
+		}
+	}
+	os.Setenv("NAMESPACE", namespace)
+
+	if pluginStatefulSet != "" {
+		os.Setenv("STATEFULSET_NAME", pluginStatefulSet)
+	} else if _, exists := os.LookupEnv("STATEFULSET_NAME"); !exists && cmd != inspectCmd && cmd != requestClientCertCmd {
+		exitOnConfigError("--statefulset is required")
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// recoverExpiredCACmd represents the recover-expired-ca command
+var recoverExpiredCACmd = &cobra.Command{
+	Use:   "recover-expired-ca",
+	Short: "replaces an expired CA and restarts the cluster with new certificates",
+	Long: `recover-expired-ca sub-command generates a new CA, bundled with the expired one, reissues all the certificates
+signed by the CA, and restarts all the CockroachDB pods at once, as nodes with certificates of different CAs can't
+join each other during a rolling restart`,
+	Run: recoverExpiredCA,
+}
+
+var (
+	forceRecovery   bool
+	recoveryTimeout time.Duration
+)
+
+func init() {
+	recoverExpiredCACmd.Flags().BoolVar(&forceRecovery, "force", false, "replace the CA even if it didn't expire yet, e.g. when its key was compromised")
+	recoverExpiredCACmd.Flags().DurationVar(&recoveryTimeout, "pod-update-timeout", 10*time.Minute, "time to wait for all the pods to be ready again after restarting them")
+	recoverExpiredCACmd.Flags().BoolVar(&smokeTest, "smoke-test", false, "if set, the certs presented on the SQL and HTTP ports of each pod are checked once the pods were restarted")
+	recoverExpiredCACmd.Flags().IntVar(&sqlPort, "sql-port", 26257, "SQL and RPC port of the CockroachDB nodes, checked by --smoke-test")
+	recoverExpiredCACmd.Flags().IntVar(&httpPort, "http-port", 8080, "HTTP port of the CockroachDB nodes, checked by --smoke-test")
+	rootCmd.AddCommand(recoverExpiredCACmd)
+}
+
+func recoverExpiredCA(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	genCert.PodUpdateTimeout = recoveryTimeout
+	genCert.SmokeTest = smokeTest
+	genCert.SQLPort = sqlPort
+	genCert.HTTPPort = httpPort
+
+	if err := genCert.RecoverExpiredCA(ctx, namespace, forceRecovery); err != nil {
+		exitOnError(err)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	controllerruntime "sigs.k8s.io/controller-runtime"
+
+	"github.com/cockroachdb/helm-charts/pkg/controller"
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+)
+
+// liveConfig is the config of a controller, rebuilt from the flags and the config files when the files
+// change.
+type liveConfig struct {
+	mu      sync.RWMutex
+	genCert generator.GenerateCert
+	build   func() (generator.GenerateCert, error)
+}
+
+// newLiveConfig returns the config built by build, which is called again on each reload.
+func newLiveConfig(build func() (generator.GenerateCert, error)) *liveConfig {
+	genCert, err := build()
+	if err != nil {
+		exitOnConfigError(err)
+	}
+	return &liveConfig{genCert: genCert, build: build}
+}
+
+// get returns the current config.
+func (c *liveConfig) get() generator.GenerateCert {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.genCert
+}
+
+// reloadConfigs rebuilds the configs, and only replaces them once all of them were built, so that the
+// controllers never run with a mix of the previous and the new config.
+func reloadConfigs(configs []*liveConfig) error {
+	built := make([]generator.GenerateCert, len(configs))
+	for i, c := range configs {
+		genCert, err := c.build()
+		if err != nil {
+			return err
+		}
+		built[i] = genCert
+	}
+
+	for i, c := range configs {
+		c.mu.Lock()
+		c.genCert = built[i]
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// addConfigReloader reloads the configs when the config files change, and calls requeue so that the
+// secrets are checked against the new config. Nothing is reloaded without config files.
+func addConfigReloader(mgr controllerruntime.Manager, configs []*liveConfig, interval time.Duration,
+	requeue func(ctx context.Context) error) error {
+
+	var paths []string
+	for _, path := range []string{valuesFile, configFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 || len(configs) == 0 || interval <= 0 {
+		return nil
+	}
+
+	r := &controller.ConfigReloader{
+		Paths:    paths,
+		Interval: interval,
+		Reload: func(ctx context.Context) error {
+			if err := reloadConfigs(configs); err != nil {
+				return err
+			}
+			return requeue(ctx)
+		},
+	}
+	return r.SetupWithManager(mgr)
+}
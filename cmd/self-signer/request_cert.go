@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilwait "k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+// requestCertCmd represents the request-cert command
+var requestCertCmd = &cobra.Command{
+	Use:   "request-cert",
+	Short: "requests the node certificate of the pod it runs in from the signer",
+	Long: `request-cert sub-command generates the node key inside the pod and requests its certificate from the ` +
+		`signer, so that the node key is never stored in a secret. It runs as an init container, or as a ` +
+		`sidecar renewing the certificate when --renew-interval is set`,
+	Run: runRequestCert,
+}
+
+var (
+	podCertsDir   string
+	renewInterval time.Duration
+	renewBefore   time.Duration
+	fromSecret    bool
+)
+
+func init() {
+	requestCertCmd.Flags().StringVar(&podCertsDir, "certs-dir", "/cockroach-certs", "directory the node certificate and key are written to")
+	requestCertCmd.Flags().DurationVar(&renewInterval, "renew-interval", 0, "interval between two checks of the certificate expiry, 0 requests the certificate once and exits")
+	requestCertCmd.Flags().DurationVar(&renewBefore, "renew-before", 24*time.Hour, "time before expiry at which the certificate is renewed")
+	requestCertCmd.Flags().BoolVar(&fromSecret, "from-secret", false, "copy the node certificate of the pod from its secret, generated with --per-node-certs, instead of requesting it")
+	rootCmd.AddCommand(requestCertCmd)
+}
+
+func runRequestCert(cmd *cobra.Command, args []string) {
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	podName, exists := os.LookupEnv("POD_NAME")
+	if !exists {
+		exitOnConfigError("Required POD_NAME env not found")
+	}
+
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	if fromSecret {
+		// the secret of a pod added by a scale up may still be being generated by the controller
+		err := utilwait.PollImmediate(2*time.Second, signingTimeout, func() (bool, error) {
+			err := genCert.LoadPodCert(ctx, namespace, podName, podCertsDir)
+			if apierrors.IsNotFound(errors.Cause(err)) {
+				log.Printf("Waiting for the node secret of pod %s", podName)
+				return false, nil
+			}
+			return err == nil, err
+		})
+		if err != nil {
+			exitOnError(err)
+		}
+		return
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Panic("Failed to create client for certificate signing", err)
+	}
+
+	p := &signer.PodCert{
+		Client:       clientset,
+		CertsDir:     podCertsDir,
+		Name:         podName,
+		Hosts:        genCert.PodHosts(namespace, podName),
+		KeySize:      2048,
+		RenewBefore:  renewBefore,
+		Timeout:      signingTimeout,
+		PollInterval: 2 * time.Second,
+		Clock:        runClock,
+	}
+
+	if renewInterval == 0 {
+		if _, err := p.Ensure(ctx); err != nil {
+			exitOnError(err)
+		}
+		return
+	}
+
+	if err := p.Run(controllerruntime.SetupSignalHandler(), renewInterval); err != nil {
+		exitOnError(err)
+	}
+}
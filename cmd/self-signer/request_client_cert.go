@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+// requestClientCertCmd represents the request-client-cert command
+var requestClientCertCmd = &cobra.Command{
+	Use:   "request-client-cert",
+	Short: "requests a client certificate of a SQL user into your own namespace",
+	Long: `request-client-cert sub-command requests the client certificate of a SQL user from the signer for the ` +
+		`namespace, and writes it to a secret of the namespace. The signer only issues the SQL users allowed for ` +
+		`the namespace by its --tenant-users, to the users allowed to request them by RBAC`,
+	Run: requestClientCert,
+}
+
+var (
+	tenantUser       string
+	tenantDuration   time.Duration
+	tenantSecret     string
+	tenantCertsDir   string
+	tenantSkipSecret bool
+)
+
+func init() {
+	requestClientCertCmd.Flags().StringVar(&tenantUser, "user", "", "SQL user of the client certificate")
+	requestClientCertCmd.Flags().DurationVar(&tenantDuration, "duration", 0, "lifetime of the certificate, capped by the signer. Defaults to the longest lifetime allowed")
+	requestClientCertCmd.Flags().StringVar(&tenantSecret, "secret", "", "secret the certificate, its key and the CA certificate are written to, defaults to crdb-client-<user>")
+	requestClientCertCmd.Flags().StringVar(&tenantCertsDir, "certs-dir", "", "if set, the certificate, its key and the CA certificate are also written to this directory")
+	requestClientCertCmd.Flags().BoolVar(&tenantSkipSecret, "no-secret", false, "don't write the certificate to a secret, only to --certs-dir")
+	rootCmd.AddCommand(requestClientCertCmd)
+}
+
+func requestClientCert(cmd *cobra.Command, args []string) {
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	if tenantUser == "" {
+		exitOnConfigError("--user is required")
+	}
+	if tenantSkipSecret && tenantCertsDir == "" {
+		exitOnConfigError("--certs-dir is required with --no-secret")
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Panic("Failed to create client for certificate signing", err)
+	}
+
+	cert, key, ca, err := signer.RequestClientCert(ctx, clientset, namespace, tenantUser, tenantDuration,
+		signingTimeout, 2*time.Second)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if tenantCertsDir != "" {
+		if err := security.WriteClientCerts(tenantCertsDir, tenantUser, ca, cert, key); err != nil {
+			exitOnError(err)
+		}
+		fmt.Printf("Wrote the client certificate of %s to %s\n", tenantUser, tenantCertsDir)
+	}
+
+	if tenantSkipSecret {
+		return
+	}
+
+	name := tenantSecret
+	if name == "" {
+		name = "crdb-client-" + strings.ReplaceAll(strings.ToLower(tenantUser), "_", "-")
+	}
+
+	secret, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, cl, namespace, kube.DefaultPersister))
+	if client.IgnoreNotFound(err) != nil {
+		exitOnError(err)
+	}
+
+	parsed, err := security.GetCertObj(cert)
+	if err != nil {
+		exitOnError(err)
+	}
+	lifetime := parsed.NotAfter.Sub(parsed.NotBefore)
+	annotations := resource.GetSecretAnnotations(parsed.NotBefore.Format(time.RFC3339),
+		parsed.NotAfter.Format(time.RFC3339), lifetime.String(), "")
+
+	if err := secret.UpdateTLSSecret(cert, key, ca, annotations); err != nil {
+		exitOnError(err)
+	}
+	fmt.Printf("Wrote the client certificate of %s, valid until %s, to secret [%s]\n", tenantUser,
+		parsed.NotAfter.Format(time.RFC3339), name)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// revokeCmd represents the revoke command
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "revokes a certificate by its serial number",
+	Long: `revoke sub-command marks a certificate as revoked in the serial registry set by --serial-registry-configmap,
+so that the next rotation run replaces the certificate of the secret it was issued to`,
+	Run: revoke,
+}
+
+var (
+	revokeSerial string
+	revokeReason string
+)
+
+func init() {
+	revokeCmd.Flags().StringVar(&revokeSerial, "serial", "", "hex serial number of the certificate, with or without colons")
+	revokeCmd.Flags().StringVar(&revokeReason, "reason", "unspecified", "reason of the revocation, e.g. keyCompromise or superseded")
+	rootCmd.AddCommand(revokeCmd)
+}
+
+func revoke(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	if revokeSerial == "" {
+		exitOnConfigError("--serial is required")
+	}
+
+	serial, err := resource.ParseSerial(revokeSerial)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	if err := genCert.Revoke(ctx, namespace, serial, revokeReason); err != nil {
+		exitOnError(err)
+	}
+}
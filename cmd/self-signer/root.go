@@ -20,21 +20,130 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	goruntime "runtime"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
-	controllerruntime "sigs.k8s.io/controller-runtime"
+	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/cockroachdb/helm-charts/pkg/acme"
+	"github.com/cockroachdb/helm-charts/pkg/attestation"
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/chaos"
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/config"
 	"github.com/cockroachdb/helm-charts/pkg/generator"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/objectstore"
+	"github.com/cockroachdb/helm-charts/pkg/plugin"
+	"github.com/cockroachdb/helm-charts/pkg/policy"
+	"github.com/cockroachdb/helm-charts/pkg/proxy"
+	"github.com/cockroachdb/helm-charts/pkg/redact"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/spire"
+	"github.com/cockroachdb/helm-charts/pkg/window"
 )
 
 var (
-	cl  client.Client
-	ctx context.Context
+	cl                client.Client
+	ctx               context.Context
+	restConfig        *rest.Config
+	kubeconfig        string
+	kubeContext       string
+	kubeAPIQPS        float32
+	kubeAPIBurst      int
+	configFile        string
+	valuesFile        string
+	perPodSANReplicas int
+	perNodeCerts      bool
+	uiCert            bool
+	uiHosts           []string
+	uiDuration        string
+	uiExpiry          string
+	ingressHosts      []string
+	ingressCASecret   string
+	tenantIDs         []uint
+	sqlProxyHosts     []string
+	acmeEnabled       bool
+	acmeDirectory     string
+	acmeEmail         string
+	acmeSolver        string
+	acmeHTTPAddress   string
+	acmeDNSHook       string
+	acmeProxy         string
+	logFingerprints   bool
+	nowOverride       string
+	chaosSpec         string
+	bundleBucket      string
+	bundleEndpoint    string
+	bundleRegion      string
+	bundlePrefix      string
+	bundleSSE         string
+	bundleKMSKeyID    string
+	bundleProxy       string
+	auditStdout       bool
+	auditFile         string
+	auditConfigMap    string
+	auditSize         int
+	serialRegistry    string
+	certLogConfigMap  string
+	certLogSegment    int
+	protectSecrets    bool
+	reloader          string
+	restartWorkloads  []string
+	reportCompletion  bool
+	releaseRevision   string
+	attest            bool
+	attestKeySecret   string
+	attestRepository  string
+	attestAuth        string
+	immutableSecrets  bool
+	rotationStrategy  string
+	rollbackGrace     time.Duration
+	skipPermissions   bool
+	requestSigning    bool
+	signingTimeout    time.Duration
+	renewalRatio      float64
+	reuseKeys         bool
+	keyWorkers        int
+	pkiStatus         bool
+	nodeConditions    bool
+	detectSharedCA    bool
+	sharedCANs        []string
+	lintMinRSA        int
+	lintMinECDSA      int
+	lintMaxValidity   time.Duration
+	lintMaxCAValidity time.Duration
+	redactPatterns    []string
+	maintenanceWindow string
+	windowDuration    time.Duration
+	evictPods         bool
+	reloadCerts       string
+	reloadContainer   string
+	reloadCertsDir    string
+	signerPlugin      string
+	persisterPlugin   string
+	policyURL         string
+	policyFiles       []string
+	policyQuery       string
+	opaBinary         string
+	policyVersion     string
+	canaryRotation    bool
+	splitCASecret     bool
+	spireSocket       string
+	spireSelectors    []string
+	spireID           string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -42,50 +151,443 @@ var rootCmd = &cobra.Command{
 	Use:   "self-signer",
 	Short: "self-signer generates/rotates certs for secure CockroachDB mode",
 	Long:  `self-signer is a tool used to generate or rotate CA cert, Node cert and Client cert`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		startDiagnostics(cmd)
+		initClient()
+		startTelemetry(cmd)
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		sendTelemetry("")
+	},
+}
+
+// runClock is the time every command checks, issues and rotates the certificates at.
+var runClock clock.Clock = clock.Real
+
+// applyNow sets runClock to the --now time, from which it then advances with the real time.
+func applyNow() {
+	if nowOverride == "" {
+		return
+	}
+
+	now, err := time.Parse(time.RFC3339, nowOverride)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse --now time %s", err.Error())
+	}
+
+	log.Printf("WARNING: running at %s instead of the current time", now.Format(time.RFC3339))
+	runClock = clock.Offset(now)
+}
+
+// initRedaction keeps the secret data, the key paths and the tokens out of the logs of the command, at
+// every log level.
+func initRedaction() {
+	r, err := redact.New(redactPatterns...)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	r.Install(logrus.StandardLogger())
+	redactor = r
+
+	out := captureLogs(os.Stderr)
+	logrus.SetOutput(out)
+	log.SetOutput(r.Writer(out))
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	// the library leaves the log level to the program embedding it
+	logrus.SetLevel(logrus.InfoLevel)
+
+	// the config may fail before the command starts, which is then reported for the command being run
+	if cmd, _, err := rootCmd.Find(os.Args[1:]); err == nil {
+		startDiagnostics(cmd)
+	}
+	// the panics of the commands, e.g. of log.Panic, are reported in the diagnostic bundle as well
+	defer writePanicDiagnostics()
+
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		exitOnConfigError(err)
 	}
 }
 
 func init() {
+	// the logs are redacted and the --now time applied before any command runs, including the ones overriding
+	// PersistentPreRun
+	cobra.OnInitialize(initRedaction, applyNow)
+
 	// all the common flags are attached to root command
+	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "path of the kubeconfig file, used when running outside the cluster")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "kubeconfig context to use, used when running outside the cluster")
+	rootCmd.PersistentFlags().Float32Var(&kubeAPIQPS, "kube-api-qps", 20, "maximum rate of the requests to the API server per second, negative for no limit")
+	rootCmd.PersistentFlags().IntVar(&kubeAPIBurst, "kube-api-burst", 30, "maximum burst of the requests to the API server")
 	rootCmd.PersistentFlags().StringVar(&caSecret, "ca-secret", "", "name of user provided CA secret")
+	rootCmd.PersistentFlags().BoolVar(&splitCASecret, "split-ca-secret", false, "keep the CA key in the <statefulset>-ca-key-secret secret, and only the CA cert in the CA secret. Existing CA secrets are split on the next run")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "path of the certs config file, overrides the values set via flags")
+	rootCmd.PersistentFlags().StringVar(&valuesFile, "values", "", "path of the chart values file, its tls section overrides the values set via flags")
 
 	rootCmd.PersistentFlags().StringVar(&caDuration, "ca-duration", "43800h", "duration of CA cert. Defaults to 43800h (5 years)")
 	rootCmd.PersistentFlags().StringVar(&caExpiry, "ca-expiry", "648h", "expiry window for CA cert. Defaults to 27 days")
 
 	rootCmd.PersistentFlags().StringVar(&nodeDuration, "node-duration", "8760h", "duration of Node cert. Defaults to 365h (1 year)")
 	rootCmd.PersistentFlags().StringVar(&nodeExpiry, "node-expiry", "168h", "expiry window for Node cert. Defaults to 7 days")
+	rootCmd.PersistentFlags().IntVar(&perPodSANReplicas, "per-pod-sans", 0, "if set, the Node cert lists the DNS names of this many statefulset pods instead of wildcard names")
+	rootCmd.PersistentFlags().BoolVar(&perNodeCerts, "per-node-certs", false, "if set, each statefulset pod gets its own Node cert, valid only for its DNS names and stored in the <node secret>-<ordinal> secret")
+
+	rootCmd.PersistentFlags().BoolVar(&uiCert, "ui-cert", false, "if set, a DB Console cert is generated and stored in the <statefulset>-ui-secret secret")
+	rootCmd.PersistentFlags().StringSliceVar(&uiHosts, "ui-hosts", nil, "public hostnames of the DB Console, e.g. the Ingress host, added to the DB Console cert")
+	rootCmd.PersistentFlags().StringVar(&uiDuration, "ui-duration", "8760h", "duration of DB Console cert. Defaults to 365 days")
+	rootCmd.PersistentFlags().StringVar(&uiExpiry, "ui-expiry", "168h", "expiry window for DB Console cert. Defaults to 7 days")
+
+	rootCmd.PersistentFlags().StringSliceVar(&ingressHosts, "ingress-hosts", nil, "if set, a cert for these hosts is generated and stored in the <statefulset>-ingress-secret kubernetes.io/tls secret, for an Ingress or Route")
+	rootCmd.PersistentFlags().StringVar(&ingressCASecret, "ingress-ca-secret", "", "secret holding the tls.crt chain and tls.key of the CA signing the Ingress cert, e.g. a public intermediate CA. Defaults to the cluster CA")
+
+	rootCmd.PersistentFlags().UintSliceVar(&tenantIDs, "tenant-ids", nil, "IDs of the SQL tenants for which a tenant client cert is generated and stored in the <statefulset>-tenant-<id>-secret secret")
+	rootCmd.PersistentFlags().StringSliceVar(&sqlProxyHosts, "sqlproxy-hosts", nil, "if set, a cert for these hosts is generated and stored in the <statefulset>-sqlproxy-secret secret, for the SQL proxy routing clients to the tenants")
+
+	rootCmd.PersistentFlags().BoolVar(&acmeEnabled, "acme", false, "request the Ingress cert from an ACME CA such as Let's Encrypt, instead of signing it with a CA secret")
+	rootCmd.PersistentFlags().StringVar(&acmeDirectory, "acme-directory", acme.LetsEncryptURL, "directory URL of the ACME CA")
+	rootCmd.PersistentFlags().StringVar(&acmeEmail, "acme-email", "", "contact email of the ACME account")
+	rootCmd.PersistentFlags().StringVar(&acmeSolver, "acme-solver", "http-01", "ACME challenge type, http-01 or dns-01")
+	rootCmd.PersistentFlags().StringVar(&acmeHTTPAddress, "acme-http-address", ":8089", "address the http-01 challenge responses are served on")
+	rootCmd.PersistentFlags().StringVar(&acmeDNSHook, "acme-dns-hook", "", "command creating and deleting the dns-01 challenge records, run with present|cleanup <record> <value>")
+	rootCmd.PersistentFlags().StringVar(&acmeProxy, "acme-proxy", "", "proxy URL of the requests to the ACME CA, or direct. Defaults to the HTTPS_PROXY and NO_PROXY envs")
 
 	rootCmd.PersistentFlags().StringVar(&clientDuration, "client-duration", "672h", "duration of Client cert. Defaults to 28 days")
 	rootCmd.PersistentFlags().StringVar(&clientExpiry, "client-expiry", "48h", "expiry window for Client(root) cert. Defaults to 2 days")
+	rootCmd.PersistentFlags().StringVar(&clientKeyAlgorithm, "client-key-algorithm", "rsa", "key algorithm of Client certs, one of rsa or ed25519. Defaults to rsa. ed25519 falls back to rsa unless every node of the running cluster reports CockroachDB v21.1 or later")
+
+	rootCmd.PersistentFlags().StringVar(&bundleBucket, "bundle-bucket", "", "if set, client certs are also uploaded to this object store bucket, using the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY envs")
+	rootCmd.PersistentFlags().StringVar(&bundleEndpoint, "bundle-endpoint", objectstore.DefaultEndpoint, "endpoint of the S3 compatible object store. Use https://storage.googleapis.com for GCS")
+	rootCmd.PersistentFlags().StringVar(&bundleRegion, "bundle-region", "us-east-1", "region of the bundle bucket. Use auto for GCS")
+	rootCmd.PersistentFlags().StringVar(&bundlePrefix, "bundle-prefix", "", "prefix of the object keys in the bundle bucket")
+	rootCmd.PersistentFlags().StringVar(&bundleSSE, "bundle-sse", objectstore.DefaultSSE, "server side encryption of the uploaded objects, AES256 or aws:kms")
+	rootCmd.PersistentFlags().StringVar(&bundleKMSKeyID, "bundle-sse-kms-key-id", "", "KMS key used when --bundle-sse is aws:kms")
+	rootCmd.PersistentFlags().StringVar(&bundleProxy, "bundle-proxy", "", "proxy URL of the requests to the object store, or direct. Defaults to the HTTPS_PROXY and NO_PROXY envs")
+
+	rootCmd.PersistentFlags().BoolVar(&auditStdout, "audit-stdout", false, "write the audit log of the PKI operations to stdout as JSON lines")
+	rootCmd.PersistentFlags().StringVar(&auditFile, "audit-file", "", "append the audit log of the PKI operations to this file")
+	rootCmd.PersistentFlags().StringVar(&auditConfigMap, "audit-configmap", "", "keep the latest entries of the audit log of the PKI operations in this ConfigMap")
+	rootCmd.PersistentFlags().IntVar(&auditSize, "audit-configmap-size", audit.DefaultConfigMapSize, "number of entries kept in the audit ConfigMap")
+
+	rootCmd.PersistentFlags().StringVar(&serialRegistry, "serial-registry-configmap", "", "record the serial numbers of the issued certs in this ConfigMap, to guarantee they are unique and allow revoking them")
+	rootCmd.PersistentFlags().StringVar(&certLogConfigMap, "cert-log-configmap", "", "record every issued cert in an append-only, hash chained log kept in this ConfigMap and its sealed <name>-<n> segments")
+	rootCmd.PersistentFlags().IntVar(&certLogSegment, "cert-log-segment-size", resource.DefaultCertLogSegmentSize, "number of entries of the certificate log kept per ConfigMap")
+
+	rootCmd.PersistentFlags().BoolVar(&protectSecrets, "protect-secrets", false, "set the crdb.io/deletion-protection finalizer on the written secrets, so that deleting them by accident leaves them terminating with their certs until the unprotect command is run")
+	rootCmd.PersistentFlags().StringVar(&reloader, "reloader", "", "annotate the secrets and the deployments and statefulsets mounting them for this tool to restart the workloads when the certs are rotated, stakater for Stakater Reloader or wave")
+	rootCmd.PersistentFlags().StringArrayVar(&restartWorkloads, "restart-workloads", nil, "label selector of the deployments and statefulsets of the namespace restarted when the client certs are rotated, optionally prefixed with deployments: or statefulsets:, e.g. deployments:app=web. Repeat the flag for several selectors")
+	rootCmd.PersistentFlags().BoolVar(&reportCompletion, "report-completion", false, "report the state of the run in the <statefulset>-certs-completion ConfigMap, which the wait command waits for with --completion")
+	rootCmd.PersistentFlags().StringVar(&releaseRevision, "release-revision", "", "revision of the Helm release the run is part of, reported with --report-completion")
+
+	rootCmd.PersistentFlags().BoolVar(&attest, "attest", false, "add an in-toto attestation of each generated cert, signed by the attestation key, to the secret annotations")
+	rootCmd.PersistentFlags().StringVar(&attestKeySecret, "attest-key-secret", "", "secret holding the attestation key and its certificate issued by the CA, generated on first use, defaults to <statefulset>-attestation-key-secret")
+	rootCmd.PersistentFlags().StringVar(&attestRepository, "attest-repository", "", "if set, publish each attested cert as an OCI artifact of this repository, e.g. registry.example.com/pki/certs, along with its attestation as a cosign attestation")
+	rootCmd.PersistentFlags().StringVar(&attestAuth, "attest-docker-config", "", "path of the docker config, e.g. a mounted kubernetes.io/dockerconfigjson secret, holding the credentials of the registry of --attest-repository")
+	rootCmd.PersistentFlags().BoolVar(&immutableSecrets, "immutable-secrets", false, "write node and client certs to immutable secrets, rotations write a new version of the secret and point the <statefulset>-secret-versions ConfigMap to it")
+	rootCmd.PersistentFlags().StringVar(&rotationStrategy, "rotation-strategy", generator.InPlaceRotation, "rotation strategy of node and client certs, in-place or versioned. The versioned strategy writes rotated certs to the next version of the secret and points the statefulset volume to it")
+	rootCmd.PersistentFlags().BoolVar(&canaryRotation, "canary-rotation", false, "roll a rotated node secret out to the last pod of the statefulset first, and restore the previous secret unless that pod serves the new cert. Requires the versioned rotation strategy")
+	rootCmd.PersistentFlags().DurationVar(&rollbackGrace, "rollback-grace-period", 168*time.Hour, "duration for which the previous version of a secret is kept after a versioned rotation, for rollback. Defaults to 7 days")
+
+	rootCmd.PersistentFlags().Float64Var(&renewalRatio, "renewal-ratio", 0, "fraction of the lifetime of the node and client certs after which they are renewed, e.g. 0.5 for short-lived certs. 0 renews them on the rotation cron only")
+
+	rootCmd.PersistentFlags().BoolVar(&reuseKeys, "reuse-keys", false, "re-sign the existing key of the node and client certs when rotating them, so that only the certs of the secrets change")
+	rootCmd.PersistentFlags().IntVar(&keyWorkers, "key-workers", goruntime.NumCPU(), "number of workers generating the RSA keys of the certs signed in process while the CA is loaded or created, 0 generates each key when its cert is signed. Defaults to the number of CPUs")
+
+	rootCmd.PersistentFlags().StringVar(&maintenanceWindow, "maintenance-window", "", "cron at which the maintenance windows open, e.g. \"0 2 * * SAT\". Rotations are deferred to the windows unless the cert expires before the next one")
+	rootCmd.PersistentFlags().DurationVar(&windowDuration, "maintenance-window-duration", 2*time.Hour, "duration for which each maintenance window stays open")
+
+	rootCmd.PersistentFlags().BoolVar(&evictPods, "evict-pods", true, "restart the pods after a rotation with the eviction API, which respects their PodDisruptionBudgets, instead of deleting them")
+	rootCmd.PersistentFlags().StringVar(&reloadCerts, "reload-certs", "", "make CockroachDB reload the rotated certs on SIGHUP instead of restarting the pods: exec writes the certs to the pods and signals CockroachDB through exec, sidecar leaves both to a cert-agent --node-certs sidecar, auto uses exec if the running CockroachDB version supports it and restarts the pods otherwise")
+	rootCmd.PersistentFlags().StringVar(&reloadContainer, "reload-container", "db", "CockroachDB container of the pods the certs are written to with --reload-certs=exec or auto")
+	rootCmd.PersistentFlags().StringVar(&reloadCertsDir, "reload-certs-dir", generator.DefaultReloadCertsDir, "certs directory of the CockroachDB container the certs are written to with --reload-certs=exec or auto")
+
+	rootCmd.PersistentFlags().StringVar(&signerPlugin, "signer-plugin", "", "path of an exec plugin with the sign capability issuing the node and client certs, instead of the CA key")
+	rootCmd.PersistentFlags().StringVar(&persisterPlugin, "persister-plugin", "", "path of an exec plugin with the persist capability handed each secret once it was written")
+
+	rootCmd.PersistentFlags().StringVar(&policyURL, "policy-url", "", "URL of the rule of an OPA server returning the PKI policy violations of each cert before it is stored, e.g. http://opa:8181/v1/data/selfsigner/deny")
+	rootCmd.PersistentFlags().StringSliceVar(&policyFiles, "policy-file", nil, "Rego files of the PKI policy evaluated with the opa binary against each cert before it is stored")
+	rootCmd.PersistentFlags().StringVar(&policyQuery, "policy-query", policy.DefaultQuery, "query of the --policy-file returning the violations")
+	rootCmd.PersistentFlags().StringVar(&opaBinary, "opa-binary", "opa", "path of the opa binary evaluating the --policy-file")
+	rootCmd.PersistentFlags().StringVar(&policyVersion, "policy-version", "", "version of the PKI policy recorded in the crdb.io/policy-version annotation of the cert secrets, for admission policies")
+
+	rootCmd.PersistentFlags().BoolVar(&logFingerprints, "log-fingerprints", false, "log the serial and SHA-256 fingerprint of every cert generated, rotated or found in use, never the keys")
+	rootCmd.PersistentFlags().BoolVar(&pkiStatus, "pki-status", false, "summarize the CA fingerprint, expirations and conditions of the certs in the <statefulset>-pki CrdbPKIStatus object after each run")
+	rootCmd.PersistentFlags().BoolVar(&nodeConditions, "node-conditions", false, "report the expiry of the node certs as the CockroachDBCertificateExpiring condition and events of the Kubernetes nodes running the pods, for node-problem-detector style agents")
+	rootCmd.PersistentFlags().BoolVar(&detectSharedCA, "detect-shared-ca", false, "detect the other releases whose certs are issued by the same CA, record them in the crdb.io/ca-shared-with annotation of the CA secret and warn when the shared CA is rotated")
+	rootCmd.PersistentFlags().StringSliceVar(&sharedCANs, "shared-ca-namespaces", nil, "namespaces checked for releases sharing the CA with --detect-shared-ca, all the namespaces if empty")
+
+	rootCmd.PersistentFlags().IntVar(&lintMinRSA, "lint-min-rsa-bits", generator.DefaultMinRSABits, "smallest RSA key size of the user provided certs not reported as weak crypto")
+	rootCmd.PersistentFlags().IntVar(&lintMinECDSA, "lint-min-ecdsa-bits", generator.DefaultMinECDSABits, "smallest ECDSA curve size of the user provided certs not reported as weak crypto")
+	rootCmd.PersistentFlags().DurationVar(&lintMaxValidity, "lint-max-validity", generator.DefaultLintMaxValidity, "longest validity of the user provided leaf certs not reported as weak crypto")
+	rootCmd.PersistentFlags().DurationVar(&lintMaxCAValidity, "lint-max-ca-validity", generator.DefaultLintMaxCAValidity, "longest validity of the user provided CA certs not reported as weak crypto")
+
+	rootCmd.PersistentFlags().StringVar(&nowOverride, "now", "", "RFC3339 time the command runs at instead of the current time, e.g. to rehearse the expiry of the certs. The certs created by the cockroach binary keep the current time")
+	rootCmd.PersistentFlags().StringSliceVar(&redactPatterns, "redact-pattern", nil, "regular expression whose matches are redacted from the logs, on top of the PEM blocks, key paths and tokens always redacted")
+
+	// failures injected by the e2e tests, never to be used in production
+	rootCmd.PersistentFlags().StringVar(&chaosSpec, "chaos", "", "inject failures in the writes: api-errors=<rate>,fail-after-writes=<n>,expired-ca,seed=<n>")
+	_ = rootCmd.PersistentFlags().MarkHidden("chaos")
+
+	rootCmd.PersistentFlags().BoolVar(&skipPermissions, "skip-permission-check", false, "skip checking that the service account has the required permissions before generating certs")
+
+	rootCmd.PersistentFlags().BoolVar(&requestSigning, "request-signing", false, "request the node and client certs from the signer sub-command through CertificateSigningRequests, instead of reading the CA key")
+	rootCmd.PersistentFlags().DurationVar(&signingTimeout, "signing-timeout", 5*time.Minute, "time to wait for the signer to sign a request")
+
+	rootCmd.PersistentFlags().StringVar(&spireSocket, "spire-admin-socket", "", "if set, the node cert is the X509-SVID of the CockroachDB workload fetched from the delegated identity API on this SPIRE agent admin socket")
+	rootCmd.PersistentFlags().StringSliceVar(&spireSelectors, "spire-selectors", nil, "selectors of the registration entry of the CockroachDB workload, e.g. k8s:ns:crdb,k8s:sa:crdb-cockroachdb")
+	rootCmd.PersistentFlags().StringVar(&spireID, "spire-id", "", "SPIFFE ID of the node X509-SVID, needed when the selectors match several registration entries")
 
-	var err error
 	ctx = context.Background()
-	runtimeScheme := runtime.NewScheme()
+}
 
+// initClient creates the client from the kubeconfig flags, or the in-cluster config if they are not set.
+func initClient() {
+	var err error
+	restConfig, err = kube.GetConfig(kubeconfig, kubeContext)
+	if err != nil {
+		exitOnConfigErrorf("Failed to load kubeconfig: %s", err)
+	}
+	restConfig.QPS, restConfig.Burst = kubeAPIQPS, kubeAPIBurst
+
+	cl, err = newClient(restConfig)
+	if err != nil {
+		exitOnConfigErrorf("Failed to create client for certificate generation: %s", err)
+	}
+}
+
+func newClient(config *rest.Config) (client.Client, error) {
+	runtimeScheme := runtime.NewScheme()
 	_ = clientgoscheme.AddToScheme(runtimeScheme)
-	config := controllerruntime.GetConfigOrDie()
 
-	cl, err = client.New(config, client.Options{
+	return client.New(config, client.Options{
 		Scheme: runtimeScheme,
 		Mapper: nil,
 	})
+}
+
+// sharedACMEIssuer and sharedAuditLog are created by the first config and shared by the configs rebuilt when
+// the controller reloads its config files, as the issuer serves the http-01 challenges on an address and the
+// audit log appends to a file.
+var (
+	sharedACMEIssuer *acme.Issuer
+	sharedAuditLog   audit.Logger
+)
+
+// sharedCAMemo holds the CA secrets loaded by the runs of the controller, nil for the other commands.
+var sharedCAMemo *generator.CAMemo
+
+// newAttestationRepository returns the repository the attestations are published to, with the credentials of
+// the docker config if set.
+func newAttestationRepository() (*attestation.Repository, error) {
+	repo, err := attestation.NewRepository(attestRepository)
 	if err != nil {
-		log.Panic("Failed to create client for certificate generation", err)
+		return nil, err
 	}
+
+	if attestAuth != "" {
+		data, err := ioutil.ReadFile(attestAuth)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.LoadDockerConfig(data); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// newAuditLogger returns the audit logger configured by the audit flags, or nil if auditing is disabled.
+func newAuditLogger() (audit.Logger, error) {
+	var loggers audit.MultiLogger
+
+	if auditStdout {
+		loggers = append(loggers, audit.NewWriterLogger(os.Stdout))
+	}
+
+	if auditFile != "" {
+		l, err := audit.NewFileLogger(auditFile)
+		if err != nil {
+			return nil, err
+		}
+		loggers = append(loggers, l)
+	}
+
+	if auditConfigMap != "" {
+		loggers = append(loggers, &audit.ConfigMapLogger{Client: cl, Name: auditConfigMap, Size: auditSize})
+	}
+
+	if recorder != nil {
+		loggers = append(loggers, recorder)
+	}
+
+	if len(loggers) == 0 {
+		return nil, nil
+	}
+
+	return loggers, nil
 }
 
 func getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration,
 	clientExpiry string) (generator.GenerateCert, error) {
 
 	genCert := generator.NewGenerateCert(cl)
+	genCert.Clock = runClock
+	genCert.CaSecret = caSecret
+	// the client certificate jobs name the user of the client certificate through the USER_NAME env
+	genCert.ClientCertUser = os.Getenv("USER_NAME")
+	genCert.SplitCASecret = splitCASecret
+	genCert.PerPodSANReplicas = perPodSANReplicas
+	genCert.PerNodeCerts = perNodeCerts
+	genCert.UICert = uiCert
+	genCert.UIHosts = uiHosts
+	genCert.IngressHosts = ingressHosts
+	genCert.IngressCASecret = ingressCASecret
+	genCert.SQLProxyHosts = sqlProxyHosts
+	for _, id := range tenantIDs {
+		genCert.Tenants = append(genCert.Tenants, generator.Tenant{ID: uint64(id)})
+	}
+	genCert.LogFingerprints = logFingerprints
+	genCert.RecordPKIStatus = pkiStatus
+	genCert.NodeConditions = nodeConditions
+	genCert.DetectSharedCA = detectSharedCA
+	genCert.SharedCANamespaces = sharedCANs
+	genCert.Lint = generator.LintOptions{
+		MinRSABits:    lintMinRSA,
+		MinECDSABits:  lintMinECDSA,
+		MaxValidity:   lintMaxValidity,
+		MaxCAValidity: lintMaxCAValidity,
+	}
+
+	if chaosSpec != "" {
+		injector, err := chaos.Parse(chaosSpec)
+		if err != nil {
+			return genCert, err
+		}
+		log.Printf("WARNING: injecting failures: %s", chaosSpec)
+		genCert.Chaos = injector
+	}
+
+	if acmeEnabled {
+		if sharedACMEIssuer == nil {
+			issuer, err := newACMEIssuer()
+			if err != nil {
+				return genCert, err
+			}
+			sharedACMEIssuer = issuer
+		}
+		issuer := *sharedACMEIssuer
+		genCert.ACMEIssuer = &issuer
+	}
+	genCert.Attest = attest
+	genCert.AttestationKeySecretName = attestKeySecret
+	if attestRepository != "" {
+		repo, err := newAttestationRepository()
+		if err != nil {
+			return genCert, err
+		}
+		genCert.AttestationRepository = repo
+	}
+	genCert.ImmutableSecrets = immutableSecrets
+	genCert.RollbackGracePeriod = rollbackGrace
+	genCert.SkipPermissionCheck = skipPermissions
+
+	if requestSigning || evictPods {
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return genCert, err
+		}
+		if requestSigning {
+			genCert.SigningClient = clientset
+			genCert.SigningTimeout = signingTimeout
+		}
+		if evictPods {
+			genCert.EvictionClient = clientset
+		}
+	}
+
+	switch reloadCerts {
+	case "", generator.SidecarReload:
+	case generator.ExecReload, generator.AutoReload:
+		executor, err := kube.NewExecutor(restConfig)
+		if err != nil {
+			return genCert, err
+		}
+		genCert.Executor = executor
+		genCert.ReloadContainer = reloadContainer
+		genCert.ReloadCertsDir = reloadCertsDir
+	default:
+		return genCert, fmt.Errorf("unsupported cert reload %s, one of %s, %s or %s", reloadCerts, generator.ExecReload,
+			generator.SidecarReload, generator.AutoReload)
+	}
+	if reloadCerts != "" && annotateStatefulSet {
+		return genCert, errors.New("reload-certs can't be set with annotate-statefulset, which leaves the restarts to the statefulset controller")
+	}
+	genCert.ReloadCerts = reloadCerts
+
+	if signerPlugin != "" {
+		if requestSigning {
+			return genCert, errors.New("signer-plugin can't be set with request-signing")
+		}
+		p, err := newExecPlugin(signerPlugin, plugin.SignCapability)
+		if err != nil {
+			return genCert, err
+		}
+		genCert.SignerPlugin = p
+	}
+	if policyURL != "" && len(policyFiles) > 0 {
+		return genCert, errors.New("policy-url can't be set with policy-file")
+	} else if policyURL != "" {
+		genCert.Policy = &policy.Server{URL: policyURL}
+	} else if len(policyFiles) > 0 {
+		genCert.Policy = &policy.CLI{Binary: opaBinary, Files: policyFiles, Query: policyQuery}
+	}
+	genCert.PolicyVersion = policyVersion
+
+	if persisterPlugin != "" {
+		p, err := newExecPlugin(persisterPlugin, plugin.PersistCapability)
+		if err != nil {
+			return genCert, err
+		}
+		genCert.PersisterPlugin = p
+	}
+
+	if spireSocket != "" {
+		workload, err := newSPIREWorkload()
+		if err != nil {
+			return genCert, err
+		}
+		genCert.SPIRE = workload
+	}
+
+	switch rotationStrategy {
+	case generator.InPlaceRotation, generator.VersionedRotation:
+		genCert.RotationStrategy = rotationStrategy
+	default:
+		return genCert, fmt.Errorf("unsupported rotation strategy %s", rotationStrategy)
+	}
+
+	if canaryRotation && rotationStrategy != generator.VersionedRotation && !immutableSecrets {
+		return genCert, fmt.Errorf("canary-rotation requires the %s rotation strategy", generator.VersionedRotation)
+	}
+	genCert.CanaryRotation = canaryRotation
+
+	if renewalRatio < 0 || renewalRatio >= 1 {
+		return genCert, fmt.Errorf("renewal-ratio %g is not between 0 and 1", renewalRatio)
+	}
+	genCert.RenewalRatio = renewalRatio
+	genCert.ReuseKeys = reuseKeys
+	genCert.KeyWorkers = keyWorkers
+
+	if maintenanceWindow != "" {
+		w, err := window.Parse(maintenanceWindow, windowDuration)
+		if err != nil {
+			return genCert, err
+		}
+		genCert.MaintenanceWindow = w
+	}
 
 	if err := genCert.CaCertConfig.SetConfig(caDuration, caExpiry); err != nil {
 		return genCert, err
@@ -99,13 +601,106 @@ func getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDura
 		return genCert, err
 	}
 
-	if !clientOnly {
-		stsName, exists := os.LookupEnv("STATEFULSET_NAME")
-		if !exists {
-			return genCert, errors.New("Required STATEFULSET_NAME env not found")
+	if err := genCert.UICertConfig.SetConfig(uiDuration, uiExpiry); err != nil {
+		return genCert, err
+	}
+
+	switch clientKeyAlgorithm {
+	case security.RSAAlgorithm, security.Ed25519Algorithm:
+		genCert.ClientKeyAlgorithm = clientKeyAlgorithm
+	default:
+		return genCert, fmt.Errorf("unsupported client key algorithm %s", clientKeyAlgorithm)
+	}
+
+	if sharedAuditLog == nil {
+		auditLog, err := newAuditLogger()
+		if err != nil {
+			return genCert, err
+		}
+		sharedAuditLog = auditLog
+	}
+	genCert.AuditLog = sharedAuditLog
+	genCert.Requester = audit.Requester(restConfig)
+	genCert.SerialRegistryName = serialRegistry
+	genCert.CertLogName = certLogConfigMap
+	genCert.CertLogSegmentSize = certLogSegment
+	genCert.ProtectSecrets = protectSecrets
+	genCert.CAMemo = sharedCAMemo
+
+	if err := generator.ValidateReloader(reloader); err != nil {
+		return genCert, err
+	}
+	genCert.Reloader = reloader
+
+	selectors, err := generator.ParseWorkloadSelectors(restartWorkloads)
+	if err != nil {
+		return genCert, err
+	}
+	genCert.RestartWorkloads = selectors
+	genCert.ReportCompletion = reportCompletion
+	genCert.ReleaseRevision = releaseRevision
+
+	if bundleBucket != "" {
+		creds, err := objectstore.CredentialsFromEnv()
+		if err != nil {
+			return genCert, err
+		}
+
+		store := objectstore.NewS3Store(bundleEndpoint, bundleRegion, bundleBucket, creds)
+		store.SSE = bundleSSE
+		store.SSEKMSKeyID = bundleKMSKeyID
+		if store.HTTPClient, err = proxy.NewClient(bundleProxy, store.HTTPClient.Timeout); err != nil {
+			return genCert, err
+		}
+		genCert.BundleStore = store
+		genCert.BundlePrefix = bundlePrefix
+	}
+
+	if valuesFile != "" {
+		cfg, err := config.LoadValues(valuesFile)
+		if err != nil {
+			return genCert, err
+		}
+
+		if err := genCert.ApplyConfig(cfg); err != nil {
+			return genCert, err
+		}
+	}
+
+	if configFile != "" {
+		cfg, err := config.Load(configFile)
+		if err != nil {
+			return genCert, err
+		}
+
+		if err := genCert.ApplyConfig(cfg); err != nil {
+			return genCert, err
+		}
+	}
+
+	// the signer issues certificates with the usages of the cockroach CLI and no custom extensions
+	if requestSigning && (genCert.NodeProfile != nil || genCert.ClientProfile != nil) {
+		return genCert, errors.New("the key usages, policies and extensions of the node and client certs can't be set with --request-signing")
+	}
+	if signerPlugin != "" && (genCert.NodeProfile != nil || genCert.ClientProfile != nil) {
+		return genCert, errors.New("the key usages, policies and extensions of the node and client certs can't be set with --signer-plugin")
+	}
+	// the certificate requests only carry the common name of the client certs
+	if genCert.ClientSubject != nil && len(genCert.ClientSubject.OrganizationalUnits) > 0 && (requestSigning || signerPlugin != "") {
+		return genCert, errors.New("the organizational units of the client certs can't be set with --request-signing or --signer-plugin")
+	}
+
+	// the statefulset details are also needed to connect to the cluster when provisioning SQL users
+	if !clientOnly || provisionSQLUsers {
+		// in multi-tenant mode, the statefulset is the one of the release being reconciled
+		if releaseSelector == "" {
+			stsName, exists := os.LookupEnv("STATEFULSET_NAME")
+			if !exists {
+				return genCert, errors.New("Required STATEFULSET_NAME env not found")
+			}
+			genCert.PublicServiceName = stsName + "-public"
+			genCert.DiscoveryServiceName = stsName
 		}
-		genCert.PublicServiceName = stsName + "-public"
-		genCert.DiscoveryServiceName = stsName
 
 		domain, exists := os.LookupEnv("CLUSTER_DOMAIN")
 		if !exists {
@@ -116,3 +711,66 @@ func getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDura
 
 	return genCert, nil
 }
+
+// newACMEIssuer creates the ACME issuer of the Ingress cert. The http-01 challenge responses are served
+// in the background for the lifetime of the process.
+func newACMEIssuer() (*acme.Issuer, error) {
+	httpClient, err := proxy.NewClient(acmeProxy, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &acme.Issuer{DirectoryURL: acmeDirectory, Email: acmeEmail, HTTPClient: httpClient}
+
+	switch acmeSolver {
+	case "http-01":
+		solver := &acme.HTTPSolver{}
+		issuer.Solver = solver
+
+		listener, err := net.Listen("tcp", acmeHTTPAddress)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			_ = http.Serve(listener, solver)
+		}()
+	case "dns-01":
+		if acmeDNSHook == "" {
+			return nil, errors.New("--acme-dns-hook is required by the dns-01 solver")
+		}
+		issuer.Solver = &acme.ExecDNSSolver{Command: acmeDNSHook}
+	default:
+		return nil, fmt.Errorf("unsupported ACME solver %s", acmeSolver)
+	}
+
+	return issuer, nil
+}
+
+// newSPIREWorkload returns the workload whose X509-SVID is the node certificate.
+func newSPIREWorkload() (*spire.Workload, error) {
+	if requestSigning || perNodeCerts {
+		return nil, errors.New("--spire-admin-socket can't be used with --request-signing or --per-node-certs")
+	}
+
+	selectors, err := spire.ParseSelectors(spireSelectors)
+	if err != nil {
+		return nil, err
+	}
+	if len(selectors) == 0 {
+		return nil, errors.New("--spire-selectors is required with --spire-admin-socket")
+	}
+
+	return &spire.Workload{Client: spire.Client{SocketPath: spireSocket}, Selectors: selectors, ID: spireID}, nil
+}
+
+// newExecPlugin runs the handshake with the exec plugin at path, which must have the capability.
+func newExecPlugin(path, capability string) (*plugin.Plugin, error) {
+	p, err := plugin.New(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Supports(capability) {
+		return nil, fmt.Errorf("plugin %s doesn't have the %s capability", path, capability)
+	}
+	return p, nil
+}
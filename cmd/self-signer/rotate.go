@@ -17,11 +17,13 @@ limitations under the License.
 package self_signer
 
 import (
-	"log"
 	"os"
 	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+	"github.com/cockroachdb/helm-charts/pkg/throttle"
 )
 
 // rotateCmd represents the rotate command
@@ -37,6 +39,7 @@ var (
 	caCron, nodeAndClientCron    string
 	readinessWait                string
 	podUpdateTimeout             string
+	annotateStatefulSet          bool
 )
 
 func init() {
@@ -51,41 +54,49 @@ func init() {
 
 	rotateCmd.Flags().StringVar(&readinessWait, "readiness-wait", "30s", "readiness wait for each replica of crdb cluster")
 	rotateCmd.Flags().StringVar(&podUpdateTimeout, "pod-update-timeout", "2m", "time to wait for statefulset pod to restart and get to running state")
+	rotateCmd.Flags().BoolVar(&annotateStatefulSet, "annotate-statefulset", false, "if set, the node secret checksum is written to the statefulset pod template instead of restarting the pods")
+
+	addThrottleFlags(rotateCmd)
+	addHealthCheckFlags(rotateCmd)
+	addRootPasswordFlags(rotateCmd)
 }
 
 func rotate(cmd *cobra.Command, args []string) {
 	if (clientFlag || nodeFlag) && caFlag {
-		log.Panic("CA and (Node or client) can't be rotated at the same time. Only CA or (Node and Client) can be " +
+		exitOnConfigError("CA and (Node or client) can't be rotated at the same time. Only CA or (Node and Client) can be " +
 			"rotated at a time")
 	}
 
 	if !(clientFlag || nodeFlag || caFlag) {
-		log.Panic("None of the CA, Node and client is provided for cert rotation")
+		exitOnConfigError("None of the CA, Node and client is provided for cert rotation")
 	}
 
 	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
 	if err != nil {
-		panic(err)
+		exitOnConfigError(err)
 	}
 
 	namespace, exists := os.LookupEnv("NAMESPACE")
 	if !exists {
-		log.Panic("Required NAMESPACE env not found")
+		exitOnConfigError("Required NAMESPACE env not found")
 	}
 
 	timeout, err := time.ParseDuration(readinessWait)
 	if err != nil {
-		log.Panicf("failed to parse readiness-wait duration %s", err.Error())
+		exitOnConfigErrorf("failed to parse readiness-wait duration %s", err.Error())
 	}
 	podTimeout, err := time.ParseDuration(podUpdateTimeout)
 	if err != nil {
-		log.Panicf("failed to parse pod-update-timeout duration %s", err.Error())
+		exitOnConfigErrorf("failed to parse pod-update-timeout duration %s", err.Error())
 	}
 
 	genCert.ReadinessWait = timeout
 	genCert.PodUpdateTimeout = podTimeout
+	genCert.AnnotateStatefulSet = annotateStatefulSet
+	genCert.Throttle = newThrottle()
+	applyHealthCheckFlags(&genCert)
+	applyRootPasswordFlags(&genCert)
 
-	genCert.CaSecret = caSecret
 	genCert.RotateCACert = caFlag
 	genCert.CACronSchedule = caCron
 
@@ -94,7 +105,55 @@ func rotate(cmd *cobra.Command, args []string) {
 	genCert.NodeAndClientCronSchedule = nodeAndClientCron
 
 	if err := genCert.Do(ctx, namespace); err != nil {
-		log.Panic(err)
+		exitOnError(err)
 	}
 
 }
+
+var (
+	rotationJitter string
+	rotationRate   float64
+	rotationBurst  int
+)
+
+// addThrottleFlags adds the flags spreading the rotations of many namespaces to the command.
+func addThrottleFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&rotationJitter, "rotation-jitter", "0s", "upper bound of the delay of the rotation, fixed per namespace, so that releases sharing a cron schedule don't rotate at once")
+	cmd.Flags().Float64Var(&rotationRate, "rotation-rate", 0, "maximum number of namespaces rotated per minute by the process, 0 for no limit")
+	cmd.Flags().IntVar(&rotationBurst, "rotation-burst", 1, "number of namespaces rotated at once before --rotation-rate applies")
+}
+
+// newThrottle returns the throttle of the rotation flags.
+func newThrottle() *throttle.Throttle {
+	jitter, err := time.ParseDuration(rotationJitter)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse rotation-jitter duration %s", err.Error())
+	}
+
+	return throttle.New(jitter, rotationRate, rotationBurst)
+}
+
+var (
+	healthChecks  bool
+	smokeTest     bool
+	healthTimeout time.Duration
+	httpPort      int
+)
+
+// addHealthCheckFlags adds the flags checking the cluster during the pod restarts to the command.
+func addHealthCheckFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&healthChecks, "health-checks", false, "if set, each pod is restarted after a rotation only once all the nodes are ready and report no under-replicated or unavailable ranges")
+	cmd.Flags().DurationVar(&healthTimeout, "health-timeout", 10*time.Minute, "time to wait for a degraded cluster to recover before halting the rollout, which the next run resumes")
+	cmd.Flags().IntVar(&httpPort, "http-port", 8080, "HTTP port of the CockroachDB nodes, checked by --health-checks and --smoke-test")
+	cmd.Flags().BoolVar(&smokeTest, "smoke-test", false, "if set, the certs presented on the SQL and HTTP ports of each pod are checked once the pods were restarted after a rotation, and reported in the logs, pod events and metrics")
+	cmd.Flags().IntVar(&sqlPort, "sql-port", 26257, "SQL and RPC port of the CockroachDB nodes, checked by --canary-rotation and --smoke-test")
+}
+
+// applyHealthCheckFlags sets the health check flags in the config.
+func applyHealthCheckFlags(genCert *generator.GenerateCert) {
+	genCert.HealthChecks = healthChecks
+	genCert.HealthTimeout = healthTimeout
+	genCert.HTTPPort = httpPort
+	genCert.SQLPort = sqlPort
+	genCert.SmokeTest = smokeTest
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/oidc"
+	"github.com/cockroachdb/helm-charts/pkg/proxy"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+// signerCmd represents the signer command
+var signerCmd = &cobra.Command{
+	Use:   "signer",
+	Short: "signs the node and client certificate requests with the CA key",
+	Long: `signer sub-command signs the CertificateSigningRequests submitted by "generate --request-signing", ` +
+		`so that only the signer needs access to the CA key`,
+	Run: runSigner,
+}
+
+var (
+	signerRequesters []string
+	signerInterval   time.Duration
+	signerOnce       bool
+	apiAddress       string
+	apiHosts         []string
+	apiCallers       []string
+	apiCallerCA      string
+	apiRotateNode    bool
+	apiReceipts      bool
+	apiReceiptKey    string
+	tenantUsers      []string
+	tenantMaxLife    time.Duration
+	oidcIssuer       string
+	oidcClientID     string
+	oidcUserClaim    string
+	oidcGroupsClaim  string
+	oidcGroupRoles   []string
+	oidcCertDuration time.Duration
+)
+
+func init() {
+	signerCmd.Flags().StringSliceVar(&signerRequesters, "requesters", nil, "users allowed to request certificates, e.g. system:serviceaccount:<namespace>:<name>")
+	signerCmd.Flags().DurationVar(&signerInterval, "interval", 10*time.Second, "interval between two checks for pending requests")
+	signerCmd.Flags().BoolVar(&signerOnce, "once", false, "sign the pending requests and exit")
+	signerCmd.Flags().StringSliceVar(&tenantUsers, "tenant-users", nil, "SQL users whose client certificates the users of a namespace may request with request-client-cert, as namespace:user pairs")
+	signerCmd.Flags().DurationVar(&tenantMaxLife, "tenant-max-duration", 7*24*time.Hour, "maximum lifetime of the client certificates requested with request-client-cert")
+	signerCmd.Flags().StringVar(&apiAddress, "api-address", "", "if set, the signer API is served on this address over mTLS, e.g. :8443")
+	signerCmd.Flags().StringSliceVar(&apiHosts, "api-hosts", nil, "DNS names and IP addresses of the API server certificate")
+	signerCmd.Flags().StringSliceVar(&apiCallers, "api-callers", nil, "common names of the client certificates, signed by the CA, allowed to call the API")
+	signerCmd.Flags().StringVar(&apiCallerCA, "api-caller-ca", "", "path of the PEM bundle of the CA signing the client certificates of the API callers, instead of the CA")
+	signerCmd.Flags().BoolVar(&apiRotateNode, "api-rotate-node", false, "allow the API callers to rotate the node certificate")
+	signerCmd.Flags().BoolVar(&apiReceipts, "api-receipts", false, "return a JWT signed by the receipt key binding the serial of each certificate issued through the API to the caller")
+	signerCmd.Flags().StringVar(&apiReceiptKey, "api-receipt-key-secret", "", "secret holding the receipt key and its certificate issued by the CA, generated on first use, defaults to <discovery-service>-receipt-key-secret")
+	signerCmd.Flags().StringVar(&oidcIssuer, "oidc-issuer", "", "if set, humans authenticated by this OIDC provider are issued client certificates of their own SQL user through the API")
+	signerCmd.Flags().StringVar(&oidcClientID, "oidc-client-id", "", "client ID the ID tokens are issued to")
+	signerCmd.Flags().StringVar(&oidcUserClaim, "oidc-username-claim", "email", "claim of the ID token the SQL user is derived from")
+	signerCmd.Flags().StringVar(&oidcGroupsClaim, "oidc-groups-claim", "groups", "claim of the ID token listing the groups of the user")
+	signerCmd.Flags().StringSliceVar(&oidcGroupRoles, "oidc-group-roles", nil, "SQL roles granted to the members of the groups, as group:role pairs")
+	signerCmd.Flags().DurationVar(&oidcCertDuration, "oidc-cert-duration", 12*time.Hour, "lifetime of the client certificates issued to humans")
+	rootCmd.AddCommand(signerCmd)
+}
+
+func runSigner(cmd *cobra.Command, args []string) {
+	if len(signerRequesters) == 0 {
+		exitOnConfigError("At least one requester is required")
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	// the signer issues the certificates the generator would, so it shares its configuration
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Panic("Failed to create client for certificate signing", err)
+	}
+
+	principals, err := genCert.MappedPrincipals(namespace)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	tenants, err := signer.ParseTenantUsers(tenantUsers)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	s := &signer.Signer{
+		Client:       clientset,
+		CA:           resource.NewKubeResource(ctx, cl, namespace, kube.DefaultPersister),
+		CASecretName: genCert.CAKeySecret(),
+		Policy: signer.Policy{
+			Requesters:        signerRequesters,
+			NodeHosts:         genCert.NodeHosts(namespace),
+			ClientUsers:       append([]string{security.RootUser}, genCert.ClientUsers...),
+			ClientPrincipals:  principals,
+			TenantUsers:       tenants,
+			TenantMaxDuration: tenantMaxLife,
+			APICallers:        apiCallers,
+		},
+		NodeDuration:   genCert.NodeCertConfig.Duration,
+		ClientDuration: genCert.ClientCertConfig.Duration,
+		Clock:          runClock,
+	}
+
+	if apiAddress != "" && !signerOnce {
+		serveAPI(s, genCert, namespace)
+	}
+
+	if signerOnce {
+		if err := s.SignPending(ctx); err != nil {
+			exitOnError(err)
+		}
+		return
+	}
+
+	if err := s.Run(controllerruntime.SetupSignalHandler(), signerInterval); err != nil {
+		exitOnError(err)
+	}
+}
+
+// serveAPI serves the signer API in the background.
+func serveAPI(s *signer.Signer, genCert generator.GenerateCert, namespace string) {
+	if len(apiCallers) == 0 {
+		exitOnConfigError("At least one API caller is required")
+	}
+
+	api := &signer.API{
+		CA:             s.CA,
+		CASecretName:   s.CASecretName,
+		Callers:        apiCallers,
+		Policy:         s.Policy,
+		ClientDuration: s.ClientDuration,
+		Receipts:       apiReceipts,
+		Clock:          s.Clock,
+
+		ReceiptKeySecretName: defaultString(apiReceiptKey, genCert.DiscoveryServiceName+"-receipt-key-secret"),
+	}
+	if apiCallerCA != "" {
+		var err error
+		if api.CallerCA, err = ioutil.ReadFile(apiCallerCA); err != nil {
+			exitOnConfigError(err)
+		}
+	}
+	if apiRotateNode {
+		api.Rotate = func(ctx context.Context) (string, error) {
+			// without a cron schedule the node certificate is always rotated
+			rc := genCert
+			rc.RotateNodeCert = true
+			rc.NodeAndClientCronSchedule = ""
+			return defaultString(rc.NodeSecretName, rc.DiscoveryServiceName+"-node-secret"), rc.Do(ctx, namespace)
+		}
+	}
+
+	if oidcIssuer != "" {
+		if err := enableOIDC(api, genCert, namespace); err != nil {
+			exitOnConfigError(err)
+		}
+	}
+
+	tlsConfig, err := api.TLSConfig(apiHosts, genCert.NodeCertConfig.Duration)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	listener, err := net.Listen("tcp", apiAddress)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	go func() {
+		log.Printf("Serving the signer API on %s", apiAddress)
+		if err := api.Server(tlsConfig).Serve(listener); err != nil {
+			log.Panic("Signer API server exited with error", err)
+		}
+	}()
+}
+
+// enableOIDC lets humans authenticated by the OIDC provider request client certificates from the API.
+func enableOIDC(api *signer.API, genCert generator.GenerateCert, namespace string) error {
+	if oidcClientID == "" {
+		return errors.New("--oidc-client-id is required with --oidc-issuer")
+	}
+
+	groupRoles, err := oidc.ParseGroupRoles(oidcGroupRoles)
+	if err != nil {
+		return err
+	}
+
+	httpClient, err := proxy.NewClient("", 30*time.Second)
+	if err != nil {
+		return err
+	}
+	provider, err := oidc.Discover(ctx, oidcIssuer, httpClient)
+	if err != nil {
+		return err
+	}
+
+	api.OIDC = &oidc.Verifier{Provider: provider, ClientID: oidcClientID}
+	api.UserMapping = oidc.UserMapping{UsernameClaim: oidcUserClaim, GroupsClaim: oidcGroupsClaim, GroupRoles: groupRoles}
+	api.UserDuration = oidcCertDuration
+	api.GrantRoles = func(ctx context.Context, user string, roles []string) error {
+		return genCert.GrantRoles(ctx, namespace, user, roles)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "shows the state of the certificates",
+	Long: `status sub-command lists the secrets managed by the self-signer with the validity of their certificates, and
+exits with code 5 if one is missing or invalid`,
+	Run: status,
+}
+
+var statusJSON bool
+
+func init() {
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "print the state of the certificates as JSON")
+	rootCmd.AddCommand(statusCmd)
+}
+
+func status(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	statuses, err := genCert.Status(ctx, namespace)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if statusJSON {
+		out, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			exitOnError(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SECRET\tCOMMON NAME\tNOT AFTER\tROTATION DUE\tMANAGED BY\tSTATUS")
+		for _, s := range statuses {
+			state := "ok"
+			switch {
+			case !s.Exists:
+				state = "missing"
+			case s.Error != "":
+				state = s.Error
+			case s.Paused:
+				state = "paused"
+			}
+
+			notAfter := ""
+			if !s.NotAfter.IsZero() {
+				notAfter = s.NotAfter.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Secret, s.CommonName, notAfter, s.RotationDueAt, s.ManagedBy, state)
+		}
+		w.Flush()
+	}
+
+	for _, s := range statuses {
+		if !s.Exists || s.Error != "" {
+			exitOnError(errors.Wrapf(resource.ErrInvalidSecret, "secret %s is missing or invalid", s.Secret))
+		}
+	}
+}
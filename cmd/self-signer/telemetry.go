@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/proxy"
+	"github.com/cockroachdb/helm-charts/pkg/telemetry"
+)
+
+var (
+	telemetryEnabled  bool
+	telemetryEndpoint string
+	telemetryProxy    string
+	recorder          *telemetry.Recorder
+	commandName       string
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&telemetryEnabled, "telemetry", false, "opt in to sending anonymized usage stats (version, cert counts and error class) to --telemetry-endpoint")
+	rootCmd.PersistentFlags().StringVar(&telemetryEndpoint, "telemetry-endpoint", "", "URL the usage stats are posted to as JSON")
+	rootCmd.PersistentFlags().StringVar(&telemetryProxy, "telemetry-proxy", "", "proxy URL of the requests to the telemetry endpoint, or direct. Defaults to the HTTPS_PROXY and NO_PROXY envs")
+}
+
+// startTelemetry starts recording the usage stats of the command, if opted in.
+func startTelemetry(cmd *cobra.Command) {
+	if !telemetryEnabled {
+		return
+	}
+
+	if telemetryEndpoint == "" {
+		exitOnConfigError("--telemetry-endpoint is required to send usage stats")
+	}
+
+	recorder = telemetry.NewRecorder(telemetryEndpoint)
+	httpClient, err := proxy.NewClient(telemetryProxy, recorder.Client.Timeout)
+	if err != nil {
+		exitOnConfigErrorf("Invalid --telemetry-proxy: %s", err)
+	}
+	recorder.Client = httpClient
+	commandName = cmd.Name()
+}
+
+// sendTelemetry sends the usage stats of the command, with the class of the error it failed with. A failure
+// to send them doesn't fail the command.
+func sendTelemetry(errorClass string) {
+	if recorder == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := recorder.Send(ctx, commandName, errorClass); err != nil {
+		log.Printf("Failed to send usage stats: %s", err)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// unprotectCmd represents the unprotect command
+var unprotectCmd = &cobra.Command{
+	Use:   "unprotect",
+	Short: "removes the deletion protection of the secrets",
+	Long: `unprotect sub-command removes the crdb.io/deletion-protection finalizer set with --protect-secrets from the
+given secrets, or from all the secrets written by the self-signer in the namespace, so that they can be deleted.
+A secret whose deletion was blocked is deleted right away`,
+	Run: unprotect,
+}
+
+var unprotectSecrets []string
+
+func init() {
+	unprotectCmd.Flags().StringSliceVar(&unprotectSecrets, "secret", nil, "secrets to unprotect, all the secrets of the namespace if empty")
+	rootCmd.AddCommand(unprotectCmd)
+}
+
+func unprotect(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	if _, err := genCert.Unprotect(ctx, namespace, unprotectSecrets...); err != nil {
+		exitOnError(err)
+	}
+}
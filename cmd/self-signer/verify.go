@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "checks the certificates for compliance scans, without changing them",
+	Long: `verify sub-command checks the certificates of the secrets managed by the self-signer, without writing anything,
+and reports the ones missing, invalid, expired, near expiry, with weak keys or whose names drifted from the config.
+It exits with code 5 if a certificate is missing, invalid or expired, and with --strict, with code 9 on any other
+finding`,
+	Run: verify,
+}
+
+var (
+	verifyStrict     bool
+	verifyJSON       bool
+	verifyNearExpiry string
+	verifyMinRSA     int
+	verifyMinECDSA   int
+)
+
+func init() {
+	verifyCmd.Flags().BoolVar(&verifyStrict, "strict", false, "exit with a non-zero code on any finding, not only on missing, invalid or expired certs")
+	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "print the report as JSON, e.g. for compliance dashboards")
+	verifyCmd.Flags().StringVar(&verifyNearExpiry, "near-expiry", "", "report the certs expiring within this duration, e.g. 720h. The certs due for rotation are reported if empty")
+	verifyCmd.Flags().IntVar(&verifyMinRSA, "min-rsa-bits", generator.DefaultMinRSABits, "smallest RSA key size not reported as weak")
+	verifyCmd.Flags().IntVar(&verifyMinECDSA, "min-ecdsa-bits", generator.DefaultMinECDSABits, "smallest ECDSA curve size not reported as weak")
+	rootCmd.AddCommand(verifyCmd)
+}
+
+// verifyOptions returns the thresholds of the verification of the flags.
+func verifyOptions() generator.VerifyOptions {
+	opts := generator.VerifyOptions{MinRSABits: verifyMinRSA, MinECDSABits: verifyMinECDSA}
+	if verifyNearExpiry != "" {
+		var err error
+		if opts.NearExpiry, err = time.ParseDuration(verifyNearExpiry); err != nil {
+			exitOnConfigErrorf("failed to parse near-expiry duration %s", err.Error())
+		}
+	}
+	return opts
+}
+
+func verify(cmd *cobra.Command, args []string) {
+	genCert, err := getInitialConfig(caDuration, caExpiry, nodeDuration, nodeExpiry, clientDuration, clientExpiry)
+	if err != nil {
+		exitOnConfigError(err)
+	}
+
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	report, err := genCert.Verify(ctx, namespace, verifyOptions())
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if verifyJSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			exitOnError(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SECRET\tCHECK\tMESSAGE")
+		for _, f := range report.Findings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Secret, f.Check, f.Message)
+		}
+		w.Flush()
+		fmt.Printf("%d secrets checked, %d findings\n", len(report.Secrets), len(report.Findings))
+	}
+
+	if !report.Passed(false) {
+		exitOnError(errors.Wrap(resource.ErrInvalidSecret, "certificates are missing, invalid or expired"))
+	}
+	if verifyStrict && !report.Passed(true) {
+		exitOnError(generator.ErrNonCompliant)
+	}
+}
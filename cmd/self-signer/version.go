@@ -0,0 +1,57 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/version"
+)
+
+// versionCmd represents the version command
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "prints the version of the self-signer",
+	Long:  `version sub-command prints the version, git commit and build date the self-signer was built with`,
+	// the version doesn't need a cluster
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run:              printVersion,
+}
+
+var versionJSON bool
+
+func init() {
+	versionCmd.Flags().BoolVar(&versionJSON, "json", false, "print the build info as JSON")
+	rootCmd.AddCommand(versionCmd)
+}
+
+func printVersion(cmd *cobra.Command, args []string) {
+	info := version.Get()
+	if !versionJSON {
+		fmt.Println(info)
+		return
+	}
+
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		exitOnError(err)
+	}
+	fmt.Println(string(out))
+}
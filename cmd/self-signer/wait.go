@@ -0,0 +1,87 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package self_signer
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// waitCmd represents the wait command
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "waits until the node and client certificates are available",
+	Long: `wait sub-command blocks until the node and client secrets exist and contain valid certificates. ` +
+		`It is intended to run as an init container of the CockroachDB pods`,
+	Run: wait,
+}
+
+var (
+	waitTimeout    string
+	waitSecrets    []string
+	waitCompletion bool
+	waitRevision   string
+)
+
+func init() {
+	waitCmd.Flags().StringVar(&waitTimeout, "timeout", "5m", "time to wait for the secrets to become valid")
+	waitCmd.Flags().StringSliceVar(&waitSecrets, "secrets", nil, "secrets to wait for. Defaults to the node and client secrets of the statefulset")
+	waitCmd.Flags().BoolVar(&waitCompletion, "completion", false, "first wait for the <statefulset>-certs-completion ConfigMap to report a completed run of the job generating the certificates")
+	waitCmd.Flags().StringVar(&waitRevision, "revision", "", "release revision the completed run has to be part of with --completion, e.g. {{ .Release.Revision }}. Any revision if empty")
+	rootCmd.AddCommand(waitCmd)
+}
+
+func wait(cmd *cobra.Command, args []string) {
+	namespace, exists := os.LookupEnv("NAMESPACE")
+	if !exists {
+		exitOnConfigError("Required NAMESPACE env not found")
+	}
+
+	timeout, err := time.ParseDuration(waitTimeout)
+	if err != nil {
+		exitOnConfigErrorf("failed to parse timeout duration %s", err.Error())
+	}
+
+	stsName, exists := os.LookupEnv("STATEFULSET_NAME")
+	if !exists && (len(waitSecrets) == 0 || waitCompletion) {
+		exitOnConfigError("Required STATEFULSET_NAME env not found")
+	}
+
+	secrets := waitSecrets
+	if len(secrets) == 0 {
+		secrets = []string{stsName + "-node-secret", stsName + "-client-secret"}
+	}
+
+	r := resource.NewKubeResource(ctx, cl, namespace, kube.DefaultPersister)
+	if waitCompletion {
+		name := generator.CompletionName(stsName)
+		if err := resource.WaitForCompletion(r, name, waitRevision, timeout, 5*time.Second); err != nil {
+			exitOnError(errors.Wrapf(err, "completion %s is not complete", name))
+		}
+	}
+
+	if err := resource.WaitForTLSSecrets(r, secrets, runClock, timeout, 5*time.Second); err != nil {
+		exitOnError(errors.Wrapf(err, "secrets %v are not ready", secrets))
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package acme issues certificates for the public endpoints of the cluster from an ACME CA, such as
+// Let's Encrypt, so that external clients trust them without the cluster CA.
+package acme
+
+import (
+	"context"
+	"crypto"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	acmeapi "golang.org/x/crypto/acme"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// LetsEncryptURL is the directory URL of the Let's Encrypt production CA.
+const LetsEncryptURL = acmeapi.LetsEncryptURL
+
+// Solver fulfills the challenges proving the control of a domain.
+type Solver interface {
+	// Type returns the challenge type the solver fulfills, e.g. http-01 or dns-01.
+	Type() string
+	// Present makes the response to the challenge of the domain available to the CA.
+	Present(ctx context.Context, client *acmeapi.Client, domain string, challenge *acmeapi.Challenge) error
+	// CleanUp removes the response presented for the challenge.
+	CleanUp(ctx context.Context, client *acmeapi.Client, domain string, challenge *acmeapi.Challenge) error
+}
+
+// Issuer requests certificates from an ACME CA.
+type Issuer struct {
+	// DirectoryURL is the directory of the CA, LetsEncryptURL if empty.
+	DirectoryURL string
+	// AccountKey identifies the ACME account, which is registered on first use.
+	AccountKey crypto.Signer
+	// Email is the contact of the account, notified by the CA about expiring certificates.
+	Email  string
+	Solver Solver
+	// HTTPClient sends the requests to the CA, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Issue proves the control of the hosts to the CA and requests a certificate for them and the key. It
+// returns the PEM encoded certificate followed by the chain of the CA.
+func (i *Issuer) Issue(ctx context.Context, hosts []string, key crypto.Signer) ([]byte, error) {
+	client := &acmeapi.Client{Key: i.AccountKey, DirectoryURL: i.DirectoryURL, HTTPClient: i.HTTPClient}
+
+	account := &acmeapi.Account{}
+	if i.Email != "" {
+		account.Contact = []string{"mailto:" + i.Email}
+	}
+	if _, err := client.Register(ctx, account, acmeapi.AcceptTOS); err != nil && err != acmeapi.ErrAccountAlreadyExists {
+		return nil, errors.Wrap(err, "failed to register ACME account")
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acmeapi.DomainIDs(hosts...))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ACME order")
+	}
+
+	for _, url := range order.AuthzURLs {
+		if err := i.authorize(ctx, client, url); err != nil {
+			return nil, err
+		}
+	}
+
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, errors.Wrap(err, "ACME order failed")
+	}
+
+	pemCSR, err := security.CreateCSR(key, hosts[0], hosts)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemCSR)
+
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, block.Bytes, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to finalize ACME order")
+	}
+
+	var chain []byte
+	for _, cert := range der {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+
+	logrus.Infof("Issued ACME certificate for %v", hosts)
+	return chain, nil
+}
+
+// authorize fulfills the challenge of the authorization with the solver, unless the account is already
+// authorized for the domain.
+func (i *Issuer) authorize(ctx context.Context, client *acmeapi.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return errors.Wrap(err, "failed to get ACME authorization")
+	}
+
+	if authz.Status == acmeapi.StatusValid {
+		return nil
+	}
+
+	domain := authz.Identifier.Value
+	var challenge *acmeapi.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == i.Solver.Type() {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("the ACME CA doesn't offer a %s challenge for %s", i.Solver.Type(), domain)
+	}
+
+	logrus.Infof("Solving the %s challenge of %s", challenge.Type, domain)
+	if err := i.Solver.Present(ctx, client, domain, challenge); err != nil {
+		return errors.Wrapf(err, "failed to present the %s challenge of %s", challenge.Type, domain)
+	}
+	defer func() {
+		if err := i.Solver.CleanUp(ctx, client, domain, challenge); err != nil {
+			logrus.Warnf("Failed to clean up the %s challenge of %s: %s", challenge.Type, domain, err)
+		}
+	}()
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return errors.Wrapf(err, "failed to accept the %s challenge of %s", challenge.Type, domain)
+	}
+
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return errors.Wrapf(err, "ACME authorization of %s failed", domain)
+	}
+
+	return nil
+}
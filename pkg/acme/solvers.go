@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	acmeapi "golang.org/x/crypto/acme"
+)
+
+// HTTPSolver fulfills http-01 challenges by serving their responses under /.well-known/acme-challenge/.
+// The Ingress of the hosts has to route that path to the server the solver is mounted on.
+type HTTPSolver struct {
+	mu        sync.Mutex
+	responses map[string]string
+}
+
+var _ http.Handler = &HTTPSolver{}
+
+// Type implements Solver.
+func (s *HTTPSolver) Type() string {
+	return "http-01"
+}
+
+// Present implements Solver.
+func (s *HTTPSolver) Present(ctx context.Context, client *acmeapi.Client, domain string, challenge *acmeapi.Challenge) error {
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.responses == nil {
+		s.responses = map[string]string{}
+	}
+	s.responses[client.HTTP01ChallengePath(challenge.Token)] = response
+	return nil
+}
+
+// CleanUp implements Solver.
+func (s *HTTPSolver) CleanUp(ctx context.Context, client *acmeapi.Client, domain string, challenge *acmeapi.Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, client.HTTP01ChallengePath(challenge.Token))
+	return nil
+}
+
+// ServeHTTP serves the responses of the presented challenges.
+func (s *HTTPSolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	response, ok := s.responses[r.URL.Path]
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(response))
+}
+
+// ExecDNSSolver fulfills dns-01 challenges with a hook command managing the records of the DNS provider.
+// The command is run with the arguments present or cleanup, the record name, e.g.
+// _acme-challenge.crdb.example.com., and the record value.
+type ExecDNSSolver struct {
+	Command string
+}
+
+// Type implements Solver.
+func (s *ExecDNSSolver) Type() string {
+	return "dns-01"
+}
+
+// Present implements Solver.
+func (s *ExecDNSSolver) Present(ctx context.Context, client *acmeapi.Client, domain string, challenge *acmeapi.Challenge) error {
+	return s.run(ctx, client, "present", domain, challenge)
+}
+
+// CleanUp implements Solver.
+func (s *ExecDNSSolver) CleanUp(ctx context.Context, client *acmeapi.Client, domain string, challenge *acmeapi.Challenge) error {
+	return s.run(ctx, client, "cleanup", domain, challenge)
+}
+
+func (s *ExecDNSSolver) run(ctx context.Context, client *acmeapi.Client, action, domain string, challenge *acmeapi.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+
+	record := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+	out, err := exec.CommandContext(ctx, s.Command, action, record, value).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "DNS hook %s failed: %s", s.Command, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	acmeapi "golang.org/x/crypto/acme"
+
+	"github.com/cockroachdb/helm-charts/pkg/acme"
+)
+
+func newClient(t *testing.T) *acmeapi.Client {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &acmeapi.Client{Key: key}
+}
+
+func TestHTTPSolver(t *testing.T) {
+	client := newClient(t)
+	challenge := &acmeapi.Challenge{Type: "http-01", Token: "token"}
+	solver := &acme.HTTPSolver{}
+
+	require.NoError(t, solver.Present(context.TODO(), client, "crdb.example.com", challenge))
+
+	rec := httptest.NewRecorder()
+	solver.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/token", nil))
+	expected, err := client.HTTP01ChallengeResponse("token")
+	require.NoError(t, err)
+	assert.Equal(t, expected, rec.Body.String())
+
+	require.NoError(t, solver.CleanUp(context.TODO(), client, "crdb.example.com", challenge))
+
+	rec = httptest.NewRecorder()
+	solver.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/token", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestExecDNSSolver(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test hook is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "acme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	hook := filepath.Join(dir, "hook.sh")
+	require.NoError(t, ioutil.WriteFile(hook, []byte("#!/bin/sh\necho \"$@\" >> "+out+"\n"), 0700))
+
+	client := newClient(t)
+	challenge := &acmeapi.Challenge{Type: "dns-01", Token: "token"}
+	solver := &acme.ExecDNSSolver{Command: hook}
+
+	require.NoError(t, solver.Present(context.TODO(), client, "crdb.example.com", challenge))
+	require.NoError(t, solver.CleanUp(context.TODO(), client, "crdb.example.com", challenge))
+
+	value, err := client.DNS01ChallengeRecord("token")
+	require.NoError(t, err)
+
+	calls, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "present _acme-challenge.crdb.example.com. "+value+"\n"+
+		"cleanup _acme-challenge.crdb.example.com. "+value+"\n", string(calls))
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+const (
+	// StatementType is the in-toto statement version produced by this package.
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	// PredicateType identifies the certificate provenance predicate.
+	PredicateType = "https://github.com/cockroachdb/helm-charts/self-signer/certificate/v1"
+	// PayloadType is the DSSE payload type of in-toto statements.
+	PayloadType = "application/vnd.in-toto+json"
+
+	generatorName = "cockroachdb/helm-charts/self-signer"
+)
+
+// Statement is an in-toto statement about a certificate.
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Subject identifies the attested certificate by the digest of its DER encoding.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Predicate records how the certificate was generated.
+type Predicate struct {
+	Generator     string            `json:"generator"`
+	IssuedAt      time.Time         `json:"issuedAt"`
+	Serial        string            `json:"serial"`
+	CAFingerprint string            `json:"caFingerprint"`
+	Inputs        map[string]string `json:"inputs,omitempty"`
+}
+
+// Envelope is a DSSE envelope, as consumed by cosign verify-blob-attestation.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     []byte      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is a signature of a DSSE envelope.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// NewStatement returns a statement about the first certificate in pemCert, issued by the CA with the
+// given fingerprint.
+func NewStatement(name string, pemCert []byte, caFingerprint string, inputs map[string]string) (*Statement, error) {
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+
+	return &Statement{
+		Type: StatementType,
+		Subject: []Subject{{
+			Name:   name,
+			Digest: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+		}},
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			Generator:     generatorName,
+			IssuedAt:      time.Now().UTC(),
+			Serial:        cert.SerialNumber.Text(16),
+			CAFingerprint: caFingerprint,
+			Inputs:        inputs,
+		},
+	}, nil
+}
+
+// Sign wraps the statement in a DSSE envelope signed by signer.
+func Sign(s *Statement, signer crypto.Signer) (*Envelope, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := KeyID(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	message := pae(PayloadType, payload)
+
+	var sig []byte
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return nil, errors.Errorf("unsupported signing key type %T", signer.Public())
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign attestation")
+	}
+
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     payload,
+		Signatures:  []Signature{{KeyID: keyID, Sig: sig}},
+	}, nil
+}
+
+// Verify checks that the envelope is signed by pub and returns its statement.
+func Verify(e *Envelope, pub crypto.PublicKey) (*Statement, error) {
+	if e.PayloadType != PayloadType {
+		return nil, errors.Errorf("unexpected payload type %s", e.PayloadType)
+	}
+
+	message := pae(e.PayloadType, e.Payload)
+	digest := sha256.Sum256(message)
+
+	verified := false
+	for _, s := range e.Signatures {
+		switch key := pub.(type) {
+		case ed25519.PublicKey:
+			verified = ed25519.Verify(key, message, s.Sig)
+		case *rsa.PublicKey:
+			verified = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], s.Sig) == nil
+		case *ecdsa.PublicKey:
+			verified = ecdsa.VerifyASN1(key, digest[:], s.Sig)
+		default:
+			return nil, errors.Errorf("unsupported verification key type %T", pub)
+		}
+
+		if verified {
+			break
+		}
+	}
+
+	if !verified {
+		return nil, errors.New("attestation signature verification failed")
+	}
+
+	s := &Statement{}
+	if err := json.Unmarshal(e.Payload, s); err != nil {
+		return nil, errors.Wrap(err, "failed to decode attestation statement")
+	}
+
+	return s, nil
+}
+
+// KeyID returns the hex encoded SHA-256 of the PKIX encoding of the public key.
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// pae is the DSSE pre-authentication encoding of the payload.
+func pae(payloadType string, payload []byte) []byte {
+	return append([]byte(fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))), payload...)
+}
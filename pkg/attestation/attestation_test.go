@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/attestation"
+)
+
+func TestSignVerify(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	pemCert := selfSignedCert(t, ecKey)
+
+	for name, signer := range map[string]crypto.Signer{"ed25519": edKey, "ecdsa": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			statement, err := attestation.NewStatement("crdb-node-secret", pemCert, "abcd",
+				map[string]string{"duration": "8760h0m0s"})
+			require.NoError(t, err)
+
+			envelope, err := attestation.Sign(statement, signer)
+			require.NoError(t, err)
+
+			verified, err := attestation.Verify(envelope, signer.Public())
+			require.NoError(t, err)
+			assert.Equal(t, "crdb-node-secret", verified.Subject[0].Name)
+			assert.Equal(t, "abcd", verified.Predicate.CAFingerprint)
+			assert.Equal(t, "8760h0m0s", verified.Predicate.Inputs["duration"])
+			assert.Equal(t, "2a", verified.Predicate.Serial)
+
+			envelope.Payload = append(envelope.Payload, ' ')
+			_, err = attestation.Verify(envelope, signer.Public())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func selfSignedCert(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// The media types of the OCI artifacts of the certificates and of their cosign attestations.
+const (
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	CertMediaType     = "application/x-pem-file"
+	DSSEMediaType     = "application/vnd.dsse.envelope.v1+json"
+)
+
+// The annotations cosign reads from the layers of the attestations.
+const (
+	cosignSignature   = "dev.cosignproject.cosign/signature"
+	cosignCertificate = "dev.sigstore.cosign/certificate"
+	cosignChain       = "dev.sigstore.cosign/chain"
+	predicateType     = "predicateType"
+	imageTitle        = "org.opencontainers.image.title"
+)
+
+// Descriptor is an OCI content descriptor.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Artifact is an OCI image manifest along with the blobs it references, by digest.
+type Artifact struct {
+	Manifest []byte
+	Blobs    map[string][]byte
+}
+
+// Digest returns the digest of the manifest of the artifact, which identifies it in the repository.
+func (a *Artifact) Digest() string {
+	return digest(a.Manifest)
+}
+
+// CertArtifact returns the OCI artifact holding the PEM encoded certificate as its single layer, titled with the
+// name of its secret.
+func CertArtifact(name string, pemCert []byte) (*Artifact, error) {
+	return newArtifact(pemCert, Descriptor{
+		MediaType:   CertMediaType,
+		Annotations: map[string]string{imageTitle: name},
+	})
+}
+
+// AttestationArtifact returns the OCI artifact cosign reads the attestation of an artifact from, holding the
+// envelope as its single layer. The PEM encoded certificate of the signing key and its CA chain are annotated,
+// so that the attestation can also be verified against the CA.
+func AttestationArtifact(e *Envelope, pemCert, pemChain []byte) (*Artifact, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return newArtifact(data, Descriptor{
+		MediaType: DSSEMediaType,
+		Annotations: map[string]string{
+			cosignSignature:   "",
+			cosignCertificate: string(pemCert),
+			cosignChain:       string(pemChain),
+			predicateType:     PredicateType,
+		},
+	})
+}
+
+// AttestationTag returns the tag cosign looks up the attestations of the artifact with the digest under.
+func AttestationTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".att"
+}
+
+// newArtifact returns the artifact of the single layer, described by layer with its digest and size set. Its
+// config is the one cosign writes, so that the artifact is read as an image by the registry tooling.
+func newArtifact(data []byte, layer Descriptor) (*Artifact, error) {
+	layer.Digest = digest(data)
+	layer.Size = int64(len(data))
+
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"created":      "0001-01-01T00:00:00Z",
+		"history":      []map[string]string{{"created": "0001-01-01T00:00:00Z"}},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{layer.Digest}},
+		"config":       map[string]string{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Config:        Descriptor{MediaType: ConfigMediaType, Digest: digest(config), Size: int64(len(config))},
+		Layers:        []Descriptor{layer},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Artifact{
+		Manifest: manifest,
+		Blobs:    map[string][]byte{layer.Digest: data, digest(config): config},
+	}, nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Repository is a repository of an OCI registry the attestations are published to, pushed to with the OCI
+// distribution API.
+type Repository struct {
+	// Registry is the host of the registry, e.g. ghcr.io, and Name the repository in it, e.g. org/pki.
+	Registry string
+	Name     string
+	// Username and Password authenticate to the registry, with basic auth or to get a bearer token from the
+	// token server it redirects to. The registry is accessed anonymously if empty.
+	Username string
+	Password string
+	// Scheme is https if empty, or http for local registries.
+	Scheme     string
+	HTTPClient *http.Client
+
+	// mu guards the token, as the repository is shared by the concurrent runs of the controller
+	mu    sync.Mutex
+	token string
+}
+
+// NewRepository returns the repository of the reference registry/name, accessed over https unless prefixed
+// with http://.
+func NewRepository(ref string) (*Repository, error) {
+	scheme := "https"
+	if strings.HasPrefix(ref, "http://") {
+		scheme, ref = "http", strings.TrimPrefix(ref, "http://")
+	}
+
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(parts[1], ":@") {
+		return nil, errors.Errorf("invalid repository %s, expected <registry>/<repository>", ref)
+	}
+
+	return &Repository{
+		Registry:   parts[0],
+		Name:       parts[1],
+		Scheme:     scheme,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// LoadDockerConfig sets the credentials of the registry of the repository from the auths of a docker config,
+// as written to a kubernetes.io/dockerconfigjson secret.
+func (r *Repository) LoadDockerConfig(data []byte) error {
+	config := struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return errors.Wrap(err, "failed to parse docker config")
+	}
+
+	for host, auth := range config.Auths {
+		if strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://"), "/") != r.Registry {
+			continue
+		}
+
+		r.Username, r.Password = auth.Username, auth.Password
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return errors.Wrapf(err, "failed to decode the auth of %s", host)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return errors.Errorf("the auth of %s is not username:password", host)
+			}
+			r.Username, r.Password = parts[0], parts[1]
+		}
+		return nil
+	}
+	return errors.Errorf("no credentials of %s in docker config", r.Registry)
+}
+
+// Reference returns the reference of the artifact with the digest or tag in the repository.
+func (r *Repository) Reference(digestOrTag string) string {
+	if strings.HasPrefix(digestOrTag, "sha256:") {
+		return r.Registry + "/" + r.Name + "@" + digestOrTag
+	}
+	return r.Registry + "/" + r.Name + ":" + digestOrTag
+}
+
+// Push uploads the blobs of the artifact which are missing from the repository, then its manifest under the
+// tag.
+func (r *Repository) Push(ctx context.Context, a *Artifact, tag string) error {
+	for d, data := range a.Blobs {
+		if err := r.pushBlob(ctx, d, data); err != nil {
+			return err
+		}
+	}
+
+	resp, err := r.do(ctx, http.MethodPut, r.url("manifests/"+tag), ManifestMediaType, a.Manifest)
+	if err != nil {
+		return errors.Wrapf(err, "failed to push manifest %s", r.Reference(tag))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("failed to push manifest %s: %s: %s", r.Reference(tag), resp.Status, body)
+	}
+	return nil
+}
+
+// Publish pushes the artifact of the certificate under the tag, then the envelope as its cosign attestation,
+// under the tag cosign looks it up with. The statement of the envelope must have the digest of the artifact
+// as a subject for cosign to verify it. It returns the reference of the artifact by digest.
+func (r *Repository) Publish(ctx context.Context, cert *Artifact, tag string, e *Envelope, pemCert,
+	pemChain []byte) (string, error) {
+
+	att, err := AttestationArtifact(e, pemCert, pemChain)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.Push(ctx, cert, tag); err != nil {
+		return "", err
+	}
+	if err := r.Push(ctx, att, AttestationTag(cert.Digest())); err != nil {
+		return "", err
+	}
+	return r.Reference(cert.Digest()), nil
+}
+
+// pushBlob uploads the blob with a monolithic upload, unless the repository already has it.
+func (r *Repository) pushBlob(ctx context.Context, d string, data []byte) error {
+	resp, err := r.do(ctx, http.MethodHead, r.url("blobs/"+d), "", nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check blob %s", d)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = r.do(ctx, http.MethodPost, r.url("blobs/uploads/"), "", nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload blob %s", d)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.Errorf("failed to upload blob %s: %s", d, resp.Status)
+	}
+
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload blob %s", d)
+	}
+	query := location.Query()
+	query.Set("digest", d)
+	location.RawQuery = query.Encode()
+
+	resp, err = r.do(ctx, http.MethodPut, location.String(), "application/octet-stream", data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload blob %s", d)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("failed to upload blob %s: %s: %s", d, resp.Status, body)
+	}
+	return nil
+}
+
+// do sends the request, and sends it again authenticated if the registry challenges it.
+func (r *Repository) do(ctx context.Context, method, u, contentType string, body []byte) (*http.Response, error) {
+	resp, err := r.send(ctx, method, u, contentType, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	if err := r.authenticate(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+		return nil, err
+	}
+	return r.send(ctx, method, u, contentType, body)
+}
+
+func (r *Repository) send(ctx context.Context, method, u, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if token := r.bearerToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	return r.client().Do(req)
+}
+
+// challengeParam matches the parameters of a WWW-Authenticate challenge.
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authenticate gets a bearer token from the token server of the Bearer challenge, with the credentials of the
+// repository if any. Basic challenges are answered with the credentials.
+func (r *Repository) authenticate(ctx context.Context, challenge string) error {
+	if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+		if r.Username == "" {
+			return errors.Errorf("registry %s requires credentials", r.Registry)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer") {
+		return errors.Errorf("unsupported challenge of registry %s: %s", r.Registry, challenge)
+	}
+
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return errors.Errorf("no realm in the challenge of registry %s", r.Registry)
+	}
+	if params["scope"] == "" {
+		params["scope"] = "repository:" + r.Name + ":pull,push"
+	}
+
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return errors.Wrapf(err, "invalid realm of registry %s", r.Registry)
+	}
+	query := u.Query()
+	query.Set("scope", params["scope"])
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get a token of registry %s", r.Registry)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("failed to get a token of registry %s: %s: %s", r.Registry, resp.Status, body)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return errors.Wrapf(err, "failed to get a token of registry %s", r.Registry)
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return errors.Errorf("no token from the token server of registry %s", r.Registry)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = token.Token
+	return nil
+}
+
+func (r *Repository) bearerToken() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.token
+}
+
+func (r *Repository) url(path string) string {
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Registry + "/v2/" + r.Name + "/" + path
+}
+
+func (r *Repository) client() *http.Client {
+	if r.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return r.HTTPClient
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/attestation"
+)
+
+// fakeRegistry serves the blobs and manifests pushed to it, to the callers authenticated with the bearer token
+// of its token server.
+type fakeRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	reg := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	reg.Server = httptest.NewTLSServer(http.HandlerFunc(reg.serve))
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+func (reg *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "robot" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"registry-token"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer registry-token" {
+		w.Header().Set("WWW-Authenticate",
+			fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:pki/certs:pull,push"`, reg.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	path := strings.TrimPrefix(r.URL.Path, "/v2/pki/certs/")
+	switch {
+	case r.Method == http.MethodHead && strings.HasPrefix(path, "blobs/"):
+		if _, ok := reg.blobs[strings.TrimPrefix(path, "blobs/")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && path == "blobs/uploads/":
+		w.Header().Set("Location", "/v2/pki/certs/blobs/uploads/1?state=abc")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && path == "blobs/uploads/1":
+		reg.blobs[r.URL.Query().Get("digest")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "manifests/"):
+		if r.Header.Get("Content-Type") != attestation.ManifestMediaType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.manifests[strings.TrimPrefix(path, "manifests/")] = body
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	reg := newFakeRegistry(t)
+	repo, err := attestation.NewRepository(strings.TrimPrefix(reg.URL, "https://") + "/pki/certs")
+	require.NoError(t, err)
+	repo.HTTPClient = reg.Client()
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:secret"))
+	require.NoError(t, repo.LoadDockerConfig([]byte(fmt.Sprintf(`{"auths":{%q:{"auth":%q}}}`, reg.URL, auth))))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pemCert := selfSignedCert(t, key)
+
+	cert, err := attestation.CertArtifact("crdb-node-secret", pemCert)
+	require.NoError(t, err)
+	statement, err := attestation.NewStatement("crdb-node-secret", pemCert, "abcd", nil)
+	require.NoError(t, err)
+	envelope, err := attestation.Sign(statement, key)
+	require.NoError(t, err)
+
+	ref, err := repo.Publish(context.TODO(), cert, "default.crdb-node-secret", envelope, pemCert, pemCert)
+	require.NoError(t, err)
+	assert.Equal(t, repo.Registry+"/pki/certs@"+cert.Digest(), ref)
+
+	// the certificate is tagged, and its attestation is the DSSE envelope under the tag of cosign
+	assert.Equal(t, cert.Manifest, reg.manifests["default.crdb-node-secret"])
+	assert.Equal(t, pemCert, reg.blobs[layer(t, cert.Manifest).Digest])
+
+	att := reg.manifests[attestation.AttestationTag(cert.Digest())]
+	require.NotNil(t, att)
+	desc := layer(t, att)
+	assert.Equal(t, attestation.DSSEMediaType, desc.MediaType)
+	assert.Equal(t, attestation.PredicateType, desc.Annotations["predicateType"])
+
+	published := &attestation.Envelope{}
+	require.NoError(t, json.Unmarshal(reg.blobs[desc.Digest], published))
+	verified, err := attestation.Verify(published, key.Public())
+	require.NoError(t, err)
+	assert.Equal(t, "crdb-node-secret", verified.Subject[0].Name)
+
+	// the repository needs credentials
+	repo, err = attestation.NewRepository(strings.TrimPrefix(reg.URL, "https://") + "/pki/certs")
+	require.NoError(t, err)
+	repo.HTTPClient = reg.Client()
+	_, err = repo.Publish(context.TODO(), cert, "default.crdb-node-secret", envelope, pemCert, pemCert)
+	assert.Error(t, err)
+}
+
+func TestNewRepository(t *testing.T) {
+	repo, err := attestation.NewRepository("registry.example.com/pki/certs")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com", repo.Registry)
+	assert.Equal(t, "pki/certs", repo.Name)
+	assert.Equal(t, "registry.example.com/pki/certs:default.crdb-ca-secret", repo.Reference("default.crdb-ca-secret"))
+
+	for _, ref := range []string{"certs", "registry.example.com/", "registry.example.com/pki/certs:latest"} {
+		_, err := attestation.NewRepository(ref)
+		assert.Error(t, err, ref)
+	}
+}
+
+func layer(t *testing.T, manifest []byte) attestation.Descriptor {
+	m := attestation.Manifest{}
+	require.NoError(t, json.Unmarshal(manifest, &m))
+	require.Len(t, m.Layers, 1)
+	return m.Layers[0]
+}
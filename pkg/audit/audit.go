@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Operation is a PKI operation recorded in the audit log.
+type Operation string
+
+const (
+	// Issue is recorded when a certificate is generated for a secret which had none.
+	Issue Operation = "issue"
+	// Rotate is recorded when the certificate of a secret is replaced.
+	Rotate Operation = "rotate"
+	// Delete is recorded when a secret is deleted.
+	Delete Operation = "delete"
+	// Adopt is recorded when a certificate created by another tool is imported into a secret.
+	Adopt Operation = "adopt"
+)
+
+// Event is an entry of the audit log.
+type Event struct {
+	Time      time.Time         `json:"time"`
+	Operation Operation         `json:"operation"`
+	Namespace string            `json:"namespace"`
+	Secret    string            `json:"secret"`
+	Requester string            `json:"requester"`
+	Inputs    map[string]string `json:"inputs,omitempty"`
+	Serial    string            `json:"serial,omitempty"`
+}
+
+// Logger records audit events.
+type Logger interface {
+	Log(ctx context.Context, e Event) error
+}
+
+// Record sets the time of the event and logs it. It is a no-op when l is nil.
+func Record(ctx context.Context, l Logger, e Event) error {
+	if l == nil {
+		return nil
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	return l.Log(ctx, e)
+}
+
+// WriterLogger writes events as JSON lines.
+type WriterLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterLogger returns a logger writing to w, e.g. os.Stdout.
+func NewWriterLogger(w io.Writer) *WriterLogger {
+	return &WriterLogger{w: w}
+}
+
+// NewFileLogger returns a logger appending to the file at path, creating it if needed.
+func NewFileLogger(path string) (*WriterLogger, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open audit log %s", path)
+	}
+
+	return NewWriterLogger(f), nil
+}
+
+// Log writes the event as a single line of JSON.
+func (l *WriterLogger) Log(_ context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
+
+// MultiLogger logs each event to all of its loggers.
+type MultiLogger []Logger
+
+// Log logs the event to every logger, returning the first error.
+func (m MultiLogger) Log(ctx context.Context, e Event) error {
+	var first error
+	for _, l := range m {
+		if err := l.Log(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
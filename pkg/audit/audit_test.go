@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestWriterLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := audit.NewWriterLogger(&buf)
+
+	require.NoError(t, audit.Record(context.TODO(), l, audit.Event{
+		Operation: audit.Rotate,
+		Namespace: "test-namespace",
+		Secret:    "crdb-node-secret",
+		Requester: "system:serviceaccount:test-namespace:crdb-rotate",
+		Inputs:    map[string]string{"duration": "8760h0m0s"},
+		Serial:    "1f",
+	}))
+
+	var e audit.Event
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &e))
+	assert.Equal(t, audit.Rotate, e.Operation)
+	assert.Equal(t, "1f", e.Serial)
+	assert.False(t, e.Time.IsZero())
+}
+
+func TestRecordWithoutLogger(t *testing.T) {
+	assert.NoError(t, audit.Record(context.TODO(), nil, audit.Event{Operation: audit.Issue}))
+}
+
+func TestConfigMapLogger(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	l := &audit.ConfigMapLogger{Client: cl, Name: "crdb-audit", Size: 2}
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, audit.Record(ctx, l, audit.Event{
+			Operation: audit.Issue,
+			Namespace: "test-namespace",
+			Secret:    fmt.Sprintf("secret-%d", i),
+		}))
+	}
+
+	var cm corev1.ConfigMap
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "test-namespace", Name: "crdb-audit"}, &cm))
+
+	lines := strings.Split(strings.TrimSpace(cm.Data[audit.ConfigMapKey]), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "secret-1")
+	assert.Contains(t, lines[1], "secret-2")
+}
+
+func TestRequester(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:ns:crdb"}`))
+	token := "e30." + payload + ".c2ln"
+
+	assert.Equal(t, "system:serviceaccount:ns:crdb", audit.Requester(&rest.Config{BearerToken: token}))
+	assert.Equal(t, "admin", audit.Requester(&rest.Config{Username: "admin", BearerToken: token}))
+}
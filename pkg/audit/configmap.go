@@ -0,0 +1,87 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+// ConfigMapKey is the key of the ConfigMap holding the audit log.
+const ConfigMapKey = "audit.log"
+
+// DefaultConfigMapSize is the default number of events kept in the ConfigMap.
+const DefaultConfigMapSize = 100
+
+// ConfigMapLogger keeps the last Size events as JSON lines in a ConfigMap, in the namespace of the event.
+type ConfigMapLogger struct {
+	Client client.Client
+	Name   string
+	Size   int
+}
+
+// Log appends the event to the ConfigMap, dropping the oldest events beyond Size.
+func (l *ConfigMapLogger) Log(ctx context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	size := l.Size
+	if size <= 0 {
+		size = DefaultConfigMapSize
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      l.Name,
+				Namespace: e.Namespace,
+			},
+		}
+
+		_, err := kube.DefaultPersister(ctx, l.Client, cm, func() error {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+
+			var lines [][]byte
+			if existing := bytes.TrimSpace([]byte(cm.Data[ConfigMapKey])); len(existing) > 0 {
+				lines = bytes.Split(existing, []byte("\n"))
+			}
+			lines = append(lines, line)
+			if len(lines) > size {
+				lines = lines[len(lines)-size:]
+			}
+
+			cm.Data[ConfigMapKey] = string(append(bytes.Join(lines, []byte("\n")), '\n'))
+			return nil
+		})
+		return err
+	})
+
+	return errors.Wrapf(err, "failed to record audit event in configmap [%s]", l.Name)
+}
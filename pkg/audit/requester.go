@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// Requester returns the identity used to talk to the API server: the user name or the subject
+// of the service account token of the config, falling back to the local user.
+func Requester(config *rest.Config) string {
+	if config != nil {
+		if config.Impersonate.UserName != "" {
+			return config.Impersonate.UserName
+		}
+
+		if config.Username != "" {
+			return config.Username
+		}
+
+		token := config.BearerToken
+		if token == "" && config.BearerTokenFile != "" {
+			if data, err := ioutil.ReadFile(config.BearerTokenFile); err == nil {
+				token = string(data)
+			}
+		}
+
+		if subject := tokenSubject(token); subject != "" {
+			return subject
+		}
+	}
+
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+
+	return "unknown"
+}
+
+// tokenSubject returns the sub claim of a JWT, without verifying it. Service account tokens
+// have subjects like system:serviceaccount:<namespace>:<name>.
+func tokenSubject(token string) string {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+
+	return claims.Subject
+}
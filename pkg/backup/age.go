@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+)
+
+// The backups encrypted to age recipients are age v1 files (https://age-encryption.org/v1), which the age
+// CLI decrypts as well, e.g. age -d -i key.txt backup.age.
+const ageIntro = "age-encryption.org/v1\n"
+
+// IsAgeEncrypted returns true if the data is an age file.
+func IsAgeEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageIntro))
+}
+
+// ageEncrypt encrypts the data to the recipients.
+func ageEncrypt(data []byte, recipients []age.Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// ageDecrypt decrypts an age file with the first identity matching one of its recipients.
+func ageDecrypt(data []byte, identities []age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(data), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
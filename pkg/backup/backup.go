@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+// FormatVersion is the version of the backup format written by this package.
+const FormatVersion = 1
+
+// Backup is a snapshot of the PKI state, i.e. the managed secrets of a release.
+type Backup struct {
+	Version   int       `json:"version"`
+	Namespace string    `json:"namespace"`
+	CreatedAt time.Time `json:"createdAt"`
+	Secrets   []Secret  `json:"secrets"`
+}
+
+// Secret is the part of a secret which is backed up.
+type Secret struct {
+	Name        string            `json:"name"`
+	Type        corev1.SecretType `json:"type"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Data        map[string][]byte `json:"data"`
+}
+
+// Create backs up the named secrets. Secrets which don't exist are skipped.
+func Create(ctx context.Context, cl client.Client, namespace string, names []string) (*Backup, error) {
+	b := &Backup{
+		Version:   FormatVersion,
+		Namespace: namespace,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	for _, name := range names {
+		var secret corev1.Secret
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				logrus.Warnf("Secret [%s] not found, skipping it", name)
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to get secret [%s]", name)
+		}
+
+		b.Secrets = append(b.Secrets, Secret{
+			Name:        secret.Name,
+			Type:        secret.Type,
+			Labels:      secret.Labels,
+			Annotations: secret.Annotations,
+			Data:        secret.Data,
+		})
+		logrus.Infof("Backed up secret [%s]", name)
+	}
+
+	return b, nil
+}
+
+// Restore writes the secrets of the backup into the namespace. Existing secrets are only
+// overwritten if overwrite is set.
+func Restore(ctx context.Context, cl client.Client, namespace string, b *Backup, overwrite bool) error {
+	if b.Version != FormatVersion {
+		return errors.Errorf("unsupported backup format version %d", b.Version)
+	}
+
+	for _, s := range b.Secrets {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.Name,
+				Namespace: namespace,
+			},
+		}
+
+		err := cl.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+		if client.IgnoreNotFound(err) != nil {
+			return errors.Wrapf(err, "failed to get secret [%s]", s.Name)
+		}
+
+		if err == nil && !overwrite {
+			logrus.Infof("Secret [%s] already exists, skipping it", s.Name)
+			continue
+		}
+
+		s := s
+		if _, err := kube.DefaultPersister(ctx, cl, secret, func() error {
+			if secret.CreationTimestamp.IsZero() {
+				secret.Type = s.Type
+			}
+			secret.Labels = s.Labels
+			secret.Annotations = s.Annotations
+			secret.Data = s.Data
+			return nil
+		}); err != nil {
+			return errors.Wrapf(err, "failed to restore secret [%s]", s.Name)
+		}
+
+		logrus.Infof("Restored secret [%s]", s.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/backup"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.TODO()
+	scheme := testutils.InitScheme(t)
+	namespace := "test-namespace"
+
+	ca := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "crdb-ca-secret",
+			Namespace:   namespace,
+			Annotations: map[string]string{"certificate-valid-upto": "2026-01-01T00:00:00Z"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"ca.crt": []byte("cert"), "ca.key": []byte("key")},
+	}
+
+	b, err := backup.Create(ctx, testutils.NewFakeClient(scheme, ca), namespace,
+		[]string{"crdb-ca-secret", "crdb-node-secret"})
+	require.NoError(t, err)
+	require.Len(t, b.Secrets, 1)
+
+	data, err := backup.Marshal(b, "correct horse battery staple")
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "ca.key")
+
+	_, err = backup.Unmarshal(ctx, data, backup.Keys{Passphrase: "wrong passphrase!"})
+	assert.Error(t, err)
+
+	restored, err := backup.Unmarshal(ctx, data, backup.Keys{Passphrase: "correct horse battery staple"})
+	require.NoError(t, err)
+
+	cl := testutils.NewFakeClient(scheme)
+	require.NoError(t, backup.Restore(ctx, cl, namespace, restored, false))
+
+	var actual corev1.Secret
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "crdb-ca-secret"}, &actual))
+	assert.Equal(t, ca.Data, actual.Data)
+	assert.Equal(t, ca.Annotations, actual.Annotations)
+	assert.Equal(t, corev1.SecretTypeOpaque, actual.Type)
+}
+
+func TestRestoreSkipsExisting(t *testing.T) {
+	ctx := context.TODO()
+	scheme := testutils.InitScheme(t)
+	namespace := "test-namespace"
+
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-ca-secret", Namespace: namespace},
+		Data:       map[string][]byte{"ca.crt": []byte("current")},
+	}
+	cl := testutils.NewFakeClient(scheme, existing)
+
+	b := &backup.Backup{
+		Version: backup.FormatVersion,
+		Secrets: []backup.Secret{{Name: "crdb-ca-secret", Data: map[string][]byte{"ca.crt": []byte("old")}}},
+	}
+
+	var actual corev1.Secret
+	key := types.NamespacedName{Namespace: namespace, Name: "crdb-ca-secret"}
+
+	require.NoError(t, backup.Restore(ctx, cl, namespace, b, false))
+	require.NoError(t, cl.Get(ctx, key, &actual))
+	assert.Equal(t, []byte("current"), actual.Data["ca.crt"])
+
+	require.NoError(t, backup.Restore(ctx, cl, namespace, b, true))
+	require.NoError(t, cl.Get(ctx, key, &actual))
+	assert.Equal(t, []byte("old"), actual.Data["ca.crt"])
+}
+
+func TestAgeRecipients(t *testing.T) {
+	ctx := context.TODO()
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	// a secret larger than a payload chunk of 64 KiB
+	b := &backup.Backup{
+		Version: backup.FormatVersion,
+		Secrets: []backup.Secret{{Name: "crdb-ca-secret", Data: map[string][]byte{"ca.key": bytes.Repeat([]byte("k"), 100000)}}},
+	}
+
+	data, err := backup.MarshalForRecipients(b, []age.Recipient{identity.Recipient()})
+	require.NoError(t, err)
+	assert.True(t, backup.IsAgeEncrypted(data))
+	assert.True(t, bytes.HasPrefix(data, []byte("age-encryption.org/v1\n-> X25519 ")))
+
+	_, err = backup.Unmarshal(ctx, data, backup.Keys{AgeIdentities: []age.Identity{other}})
+	assert.Error(t, err)
+
+	restored, err := backup.Unmarshal(ctx, data, backup.Keys{AgeIdentities: []age.Identity{other, identity}})
+	require.NoError(t, err)
+	assert.Equal(t, b.Secrets, restored.Secrets)
+
+	data[len(data)-1] ^= 1
+	_, err = backup.Unmarshal(ctx, data, backup.Keys{AgeIdentities: []age.Identity{identity}})
+	assert.Error(t, err)
+}
+
+func TestAgeInterop(t *testing.T) {
+	ctx := context.TODO()
+	b := &backup.Backup{
+		Version: backup.FormatVersion,
+		Secrets: []backup.Secret{{Name: "crdb-ca-secret", Data: map[string][]byte{"ca.key": []byte("key")}}},
+	}
+
+	// the identity of an age-keygen key file
+	identities, err := age.ParseIdentities(strings.NewReader("# created: 2021-01-01T00:00:00Z\n" +
+		"# public key: age1...\nAGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX\n"))
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	identity := identities[0].(*age.X25519Identity)
+
+	// a backup is an age file the age tools decrypt
+	data, err := backup.MarshalForRecipients(b, []age.Recipient{identity.Recipient()})
+	require.NoError(t, err)
+	r, err := age.Decrypt(bytes.NewReader(data), identity)
+	require.NoError(t, err)
+	plain, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	expected, err := backup.Marshal(b, "")
+	require.NoError(t, err)
+	assert.Equal(t, expected, plain)
+
+	// and a backup encrypted by the age tools is restored
+	var encrypted bytes.Buffer
+	w, err := age.Encrypt(&encrypted, identity.Recipient())
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	restored, err := backup.Unmarshal(ctx, encrypted.Bytes(), backup.Keys{AgeIdentities: identities})
+	require.NoError(t, err)
+	assert.Equal(t, b.Secrets, restored.Secrets)
+}
+
+// fakeKMS seals the data keys by prefixing them with the key id.
+type fakeKMS struct{}
+
+func (fakeKMS) Encrypt(_ context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	return append([]byte(keyID+":"), plaintext...), nil
+}
+
+func (fakeKMS) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	return ciphertext[bytes.IndexByte(ciphertext, ':')+1:], nil
+}
+
+func TestKMS(t *testing.T) {
+	ctx := context.TODO()
+	b := &backup.Backup{
+		Version: backup.FormatVersion,
+		Secrets: []backup.Secret{{Name: "crdb-ca-secret", Data: map[string][]byte{"ca.key": []byte("key")}}},
+	}
+
+	data, err := backup.MarshalWithKMS(ctx, b, fakeKMS{}, "alias/backups")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "aws-kms-aes-256-gcm")
+
+	_, err = backup.Unmarshal(ctx, data, backup.Keys{})
+	assert.Error(t, err)
+
+	restored, err := backup.Unmarshal(ctx, data, backup.Keys{KMS: fakeKMS{}})
+	require.NoError(t, err)
+	assert.Equal(t, b.Secrets, restored.Secrets)
+}
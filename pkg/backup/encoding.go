@@ -0,0 +1,246 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The encryption schemes identify how an encrypted backup envelope was sealed. Backups encrypted to age
+// recipients are age files rather than envelopes.
+const (
+	encryptionScheme    = "scrypt-aes-256-gcm"
+	kmsEncryptionScheme = "aws-kms-aes-256-gcm"
+)
+
+// scrypt parameters recommended for interactive use as of 2017.
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	keyLength     = 32
+	saltLength    = 16
+	minPassphrase = 12
+)
+
+// envelope wraps an encrypted backup.
+type envelope struct {
+	Encryption string `json:"encryption"`
+	Salt       []byte `json:"salt,omitempty"`
+	// KeyID and Key are the KMS key and the data key it encrypted, for the KMS scheme.
+	KeyID string `json:"keyID,omitempty"`
+	Key   []byte `json:"key,omitempty"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// KeyService encrypts and decrypts the data keys of the backups, e.g. objectstore.KMSClient.
+type KeyService interface {
+	Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// Keys are the secrets Unmarshal may decrypt a backup with, depending on how it was encrypted.
+type Keys struct {
+	Passphrase    string
+	AgeIdentities []age.Identity
+	KMS           KeyService
+}
+
+// Marshal encodes the backup. If passphrase is not empty, the backup is encrypted with a key
+// derived from the passphrase.
+func Marshal(b *Backup, passphrase string) ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase == "" {
+		return data, nil
+	}
+
+	if len(passphrase) < minPassphrase {
+		return nil, errors.Errorf("passphrase must be at least %d characters long", minPassphrase)
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(envelope{
+		Encryption: encryptionScheme,
+		Salt:       salt,
+		Nonce:      nonce,
+		Data:       gcm.Seal(nil, nonce, data, nil),
+	}, "", "  ")
+}
+
+// MarshalForRecipients encodes the backup as an age file encrypted to the recipients, which only their
+// identities can decrypt.
+func MarshalForRecipients(b *Backup, recipients []age.Recipient) ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return ageEncrypt(data, recipients)
+}
+
+// MarshalWithKMS encodes the backup encrypted with a random data key, itself encrypted by the key service
+// with the key.
+func MarshalWithKMS(ctx context.Context, b *Backup, kms KeyService, keyID string) ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, keyLength)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	sealedKey, err := kms.Encrypt(ctx, keyID, dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt the data key")
+	}
+
+	gcm, err := newKeyGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(envelope{
+		Encryption: kmsEncryptionScheme,
+		KeyID:      keyID,
+		Key:        sealedKey,
+		Nonce:      nonce,
+		Data:       gcm.Seal(nil, nonce, data, nil),
+	}, "", "  ")
+}
+
+// Unmarshal decodes a backup, decrypting it with the keys if it is encrypted.
+func Unmarshal(ctx context.Context, data []byte, keys Keys) (*Backup, error) {
+	if IsAgeEncrypted(data) {
+		if len(keys.AgeIdentities) == 0 {
+			return nil, errors.New("backup is encrypted to age recipients, an age identity is required")
+		}
+
+		var err error
+		if data, err = ageDecrypt(data, keys.AgeIdentities); err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt backup")
+		}
+		return unmarshalPlain(data)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, errors.Wrap(err, "failed to decode backup")
+	}
+
+	switch env.Encryption {
+	case "":
+	case encryptionScheme:
+		if keys.Passphrase == "" {
+			return nil, errors.New("backup is encrypted, a passphrase is required")
+		}
+
+		gcm, err := newGCM(keys.Passphrase, env.Salt)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = gcm.Open(nil, env.Nonce, env.Data, nil)
+		if err != nil {
+			return nil, errors.New("failed to decrypt backup, wrong passphrase or corrupted backup")
+		}
+	case kmsEncryptionScheme:
+		if keys.KMS == nil {
+			return nil, errors.Errorf("backup is encrypted with the KMS key %s, a KMS client is required", env.KeyID)
+		}
+
+		dataKey, err := keys.KMS.Decrypt(ctx, env.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt the data key with the KMS key %s", env.KeyID)
+		}
+
+		gcm, err := newKeyGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = gcm.Open(nil, env.Nonce, env.Data, nil)
+		if err != nil {
+			return nil, errors.New("failed to decrypt backup, corrupted backup")
+		}
+	default:
+		return nil, errors.Errorf("unsupported backup encryption %s", env.Encryption)
+	}
+
+	return unmarshalPlain(data)
+}
+
+func unmarshalPlain(data []byte) (*Backup, error) {
+	b := &Backup{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, errors.Wrap(err, "failed to decode backup")
+	}
+
+	return b, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key")
+	}
+
+	return newKeyGCM(key)
+}
+
+func newKeyGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
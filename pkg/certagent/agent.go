@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certagent keeps the client certificates of a self-signer secret up to date in a directory shared
+// with an application container, like the kubelet projects ServiceAccount tokens, so that the application
+// picks up rotated certificates without restarting its pod.
+package certagent
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// LoadFn returns the certificate files to write along with the version of the secret they were read from.
+type LoadFn func(ctx context.Context) ([]security.CertFile, string, error)
+
+// Agent writes the certificate files returned by Load to Dir whenever their version changes, and signals
+// the Process of the application so that it reloads them.
+type Agent struct {
+	Load LoadFn
+	Dir  string
+	// Process is the name of the processes signaled after the files were replaced, none if empty. The
+	// application container must share the process namespace of the pod.
+	Process string
+	Signal  os.Signal
+	// ProcDir is the proc filesystem the processes are looked up in, /proc if empty.
+	ProcDir string
+
+	// version is the version of the files last written
+	version string
+}
+
+// Sync writes the files if their version differs from the one last written. It returns true if the files
+// were replaced.
+func (a *Agent) Sync(ctx context.Context) (bool, error) {
+	files, version, err := a.Load(ctx)
+	if err != nil {
+		return false, err
+	}
+	if version == a.version {
+		return false, nil
+	}
+
+	if err := WriteAtomic(a.Dir, files); err != nil {
+		return false, err
+	}
+
+	// the first write happens before the application starts, or after the agent restarted
+	updated := a.version != ""
+	a.version = version
+	logrus.Infof("Wrote the certificates of version %s to %s", version, a.Dir)
+
+	if updated && a.Process != "" {
+		procDir := a.ProcDir
+		if procDir == "" {
+			procDir = "/proc"
+		}
+		n, err := SignalProcesses(procDir, a.Process, a.Signal)
+		if err != nil {
+			return true, err
+		}
+		logrus.Infof("Signaled %d %s processes with %s", n, a.Process, a.Signal)
+	}
+	return true, nil
+}
+
+// Run calls Sync every interval until the context is done.
+func (a *Agent) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := a.Sync(ctx); err != nil {
+			logrus.Errorf("Failed to sync the certificates: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certagent_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/certagent"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, certagent.WriteAtomic(dir, security.ClientCertFiles("root", []byte("ca1"), []byte("cert1"), []byte("key1"))))
+	assert.Equal(t, "cert1", readFile(t, filepath.Join(dir, "client.root.crt")))
+
+	first, err := os.Readlink(filepath.Join(dir, "..data"))
+	require.NoError(t, err)
+
+	require.NoError(t, certagent.WriteAtomic(dir, security.ClientCertFiles("root", []byte("ca2"), []byte("cert2"), []byte("key2"))))
+	assert.Equal(t, "ca2", readFile(t, filepath.Join(dir, "ca.crt")))
+	assert.Equal(t, "cert2", readFile(t, filepath.Join(dir, "client.root.crt")))
+	assert.Equal(t, "key2", readFile(t, filepath.Join(dir, "client.root.key")))
+
+	// the files are links through ..data, and the previous data directory is removed
+	link, err := os.Readlink(filepath.Join(dir, "client.root.key"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..data", "client.root.key"), link)
+	_, err = os.Stat(filepath.Join(dir, first))
+	assert.True(t, os.IsNotExist(err))
+
+	info, err := os.Stat(filepath.Join(dir, "client.root.key"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(security.KeyFileMode), info.Mode().Perm())
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+}
+
+func TestAgentSync(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+
+	version, cert := "1", "cert1"
+	agent := &certagent.Agent{
+		Load: func(ctx context.Context) ([]security.CertFile, string, error) {
+			return security.ClientCertFiles("root", []byte("ca"), []byte(cert), []byte("key")), version, nil
+		},
+		Dir: dir,
+	}
+
+	updated, err := agent.Sync(ctx)
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	// the files are only written again once the secret changes
+	cert = "cert2"
+	updated, err = agent.Sync(ctx)
+	require.NoError(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, "cert1", readFile(t, filepath.Join(dir, "client.root.crt")))
+
+	version = "2"
+	updated, err = agent.Sync(ctx)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, "cert2", readFile(t, filepath.Join(dir, "client.root.crt")))
+}
+
+func TestAgentSignal(t *testing.T) {
+	ctx := context.TODO()
+
+	app := exec.Command("sleep", "60")
+	require.NoError(t, app.Start())
+	defer app.Process.Kill()
+
+	// a proc filesystem listing only the application
+	procDir := t.TempDir()
+	pidDir := filepath.Join(procDir, strconv.Itoa(app.Process.Pid))
+	require.NoError(t, os.Mkdir(pidDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(pidDir, "comm"), []byte("app\n"), 0644))
+
+	version := "1"
+	agent := &certagent.Agent{
+		Load: func(ctx context.Context) ([]security.CertFile, string, error) {
+			return security.ClientCertFiles("root", []byte("ca"), []byte("cert"), []byte("key")), version, nil
+		},
+		Dir:     t.TempDir(),
+		Process: "app",
+		Signal:  syscall.SIGHUP,
+		ProcDir: procDir,
+	}
+
+	// the first write isn't signaled, as the application reads the files on start
+	_, err := agent.Sync(ctx)
+	require.NoError(t, err)
+
+	version = "2"
+	_, err = agent.Sync(ctx)
+	require.NoError(t, err)
+
+	err = app.Wait()
+	require.Error(t, err)
+	status := app.ProcessState.Sys().(syscall.WaitStatus)
+	assert.Equal(t, syscall.SIGHUP, status.Signal())
+
+	_, err = certagent.SignalProcesses(procDir, "other", syscall.SIGHUP)
+	assert.Error(t, err)
+}
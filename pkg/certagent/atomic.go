@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certagent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+const (
+	// dataDir is the symlink to the directory holding the current files
+	dataDir    = "..data"
+	newDataDir = "..data_tmp"
+)
+
+// WriteAtomic replaces the files of dir at once, the way the kubelet updates projected volumes. The files
+// are written to a new timestamped directory, which the ..data symlink is then renamed to point to, and
+// each file of dir is a symlink through ..data. Readers therefore see either all the previous files or all
+// the new ones, e.g. never a certificate along with the key of another one.
+func WriteAtomic(dir string, files []security.CertFile) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create the certs directory")
+	}
+
+	tsDir, err := ioutil.TempDir(dir, ".."+time.Now().UTC().Format("2006_01_02_15_04_05."))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the data directory")
+	}
+
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(tsDir, f.Name), f.Data, f.Mode); err != nil {
+			os.RemoveAll(tsDir)
+			return errors.Wrapf(err, "failed to write %s", f.Name)
+		}
+	}
+	if err := os.Chmod(tsDir, 0755); err != nil {
+		os.RemoveAll(tsDir)
+		return errors.Wrap(err, "failed to set the mode of the data directory")
+	}
+
+	previous, err := os.Readlink(filepath.Join(dir, dataDir))
+	if err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(tsDir)
+		return errors.Wrap(err, "failed to read the data symlink")
+	}
+
+	// the rename of the symlink is the atomic switch to the new files
+	newData := filepath.Join(dir, newDataDir)
+	if err := os.Remove(newData); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(tsDir)
+		return errors.Wrap(err, "failed to remove the previous data symlink")
+	}
+	if err := os.Symlink(filepath.Base(tsDir), newData); err != nil {
+		os.RemoveAll(tsDir)
+		return errors.Wrap(err, "failed to create the data symlink")
+	}
+	if err := os.Rename(newData, filepath.Join(dir, dataDir)); err != nil {
+		os.Remove(newData)
+		os.RemoveAll(tsDir)
+		return errors.Wrap(err, "failed to switch the data symlink")
+	}
+
+	for _, f := range files {
+		link := filepath.Join(dir, f.Name)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(dataDir, f.Name), link); err != nil {
+			return errors.Wrapf(err, "failed to link %s", f.Name)
+		}
+	}
+
+	if previous != "" {
+		if err := os.RemoveAll(filepath.Join(dir, previous)); err != nil {
+			return errors.Wrap(err, "failed to remove the previous data directory")
+		}
+	}
+	return nil
+}
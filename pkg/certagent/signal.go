@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certagent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SignalProcesses sends the signal to the processes of procDir named name, other than the current process,
+// and returns how many were signaled. The name is the command name of /proc/<pid>/comm.
+func SignalProcesses(procDir, name string, sig os.Signal) (int, error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list the processes")
+	}
+
+	signaled := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+
+		// the process may have exited since it was listed
+		comm, err := ioutil.ReadFile(filepath.Join(procDir, entry.Name(), "comm"))
+		if err != nil || strings.TrimSpace(string(comm)) != name {
+			continue
+		}
+
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			continue
+		}
+		if err := process.Signal(sig); err != nil {
+			return signaled, errors.Wrapf(err, "failed to signal process %d", pid)
+		}
+		signaled++
+	}
+
+	if signaled == 0 {
+		return 0, errors.Errorf("no %s process found, does the pod share its process namespace?", name)
+	}
+	return signaled, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chaos injects controlled failures in the writes of the self-signer, so that the recovery paths of
+// the rotation can be exercised by the e2e tests. It must never be enabled in production.
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// ErrInjected is returned by the writes failed by FailAfterWrites.
+var ErrInjected = errors.New("chaos: injected write failure")
+
+// Injector fails the writes of the persisters it wraps as configured.
+type Injector struct {
+	// APIErrorRate is the probability of a write failing with a transient API error.
+	APIErrorRate float64
+	// FailAfterWrites fails every write after this many successful writes, leaving the secrets partially
+	// rotated. Zero disables it.
+	FailAfterWrites int
+	// ExpiredCA writes the CA secrets with annotations of an expired CA, so that the next rotation replaces
+	// the CA.
+	ExpiredCA bool
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	writes int
+}
+
+// Parse returns the injector configured by the comma separated spec, e.g.
+// "api-errors=0.2,fail-after-writes=3,expired-ca,seed=42".
+func Parse(spec string) (*Injector, error) {
+	i := &Injector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	for _, opt := range strings.Split(spec, ",") {
+		name, value := opt, ""
+		if idx := strings.Index(opt, "="); idx >= 0 {
+			name, value = opt[:idx], opt[idx+1:]
+		}
+
+		var err error
+		switch strings.TrimSpace(name) {
+		case "api-errors":
+			i.APIErrorRate, err = strconv.ParseFloat(value, 64)
+		case "fail-after-writes":
+			i.FailAfterWrites, err = strconv.Atoi(value)
+		case "expired-ca":
+			i.ExpiredCA = true
+		case "seed":
+			var seed int64
+			seed, err = strconv.ParseInt(value, 10, 64)
+			i.rand = rand.New(rand.NewSource(seed))
+		case "":
+		default:
+			return nil, errors.Errorf("unknown chaos option %q", name)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid chaos option %q", opt)
+		}
+	}
+
+	return i, nil
+}
+
+// Persister returns persist with the failures of the injector.
+func (i *Injector) Persister(persist kube.PersistFn) kube.PersistFn {
+	return func(ctx context.Context, cl client.Client, obj client.Object, f kube.MutateFn) (bool, error) {
+		if err := i.fail(obj); err != nil {
+			return false, err
+		}
+
+		mutate := f
+		if secret, ok := obj.(*corev1.Secret); ok && i.ExpiredCA {
+			mutate = func() error {
+				if err := f(); err != nil {
+					return err
+				}
+				expireCA(secret)
+				return nil
+			}
+		}
+
+		return persist(ctx, cl, obj, mutate)
+	}
+}
+
+// fail returns the failure injected in the write of the object, if any.
+func (i *Injector) fail(obj client.Object) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.FailAfterWrites > 0 && i.writes >= i.FailAfterWrites {
+		return errors.Wrapf(ErrInjected, "write of %s after %d writes", obj.GetName(), i.writes)
+	}
+
+	if i.rand.Float64() < i.APIErrorRate {
+		return apierrors.NewServiceUnavailable("chaos: injected API error writing " + obj.GetName())
+	}
+
+	i.writes++
+	return nil
+}
+
+// expireCA sets the validity annotations of a CA secret to those of a CA which expired an hour ago.
+func expireCA(secret *corev1.Secret) {
+	if _, ok := secret.Data[resource.CaKey]; !ok || secret.Annotations == nil {
+		return
+	}
+
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	for key, value := range resource.GetSecretAnnotations(secret.Annotations[resource.CertValidFrom], expired,
+		secret.Annotations[resource.CertDuration], "0s") {
+		secret.Annotations[key] = value
+	}
+}
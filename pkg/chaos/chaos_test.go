@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/chaos"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func write(t *testing.T, persist kube.PersistFn, name string, data map[string][]byte) (*corev1.Secret, error) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}}
+
+	_, err := persist(context.TODO(), cl, secret, func() error {
+		secret.Data = data
+		secret.Annotations = resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "1h0m0s", "10m")
+		return nil
+	})
+	return secret, err
+}
+
+func TestParse(t *testing.T) {
+	i, err := chaos.Parse("api-errors=0.5, fail-after-writes=3,expired-ca,seed=1")
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, i.APIErrorRate)
+	assert.Equal(t, 3, i.FailAfterWrites)
+	assert.True(t, i.ExpiredCA)
+
+	_, err = chaos.Parse("api-errors=often")
+	assert.Error(t, err)
+	_, err = chaos.Parse("delete-everything")
+	assert.Error(t, err)
+}
+
+func TestAPIErrors(t *testing.T) {
+	i, err := chaos.Parse("api-errors=1")
+	require.NoError(t, err)
+
+	_, err = write(t, i.Persister(kube.DefaultPersister), "crdb-node-secret", nil)
+	assert.True(t, kube.IsTransient(err))
+}
+
+func TestFailAfterWrites(t *testing.T) {
+	i, err := chaos.Parse("fail-after-writes=1")
+	require.NoError(t, err)
+	persist := i.Persister(kube.DefaultPersister)
+
+	_, err = write(t, persist, "crdb-ca-secret", nil)
+	require.NoError(t, err)
+	_, err = write(t, persist, "crdb-node-secret", nil)
+	assert.True(t, errors.Is(err, chaos.ErrInjected))
+}
+
+func TestExpiredCA(t *testing.T) {
+	i, err := chaos.Parse("expired-ca")
+	require.NoError(t, err)
+	persist := i.Persister(kube.DefaultPersister)
+
+	ca, err := write(t, persist, "crdb-ca-secret", map[string][]byte{resource.CaKey: []byte("key")})
+	require.NoError(t, err)
+	upto, err := time.Parse(time.RFC3339, ca.Annotations[resource.CertValidUpto])
+	require.NoError(t, err)
+	assert.True(t, upto.Before(time.Now()))
+
+	// only the CA is expired
+	node, err := write(t, persist, "crdb-node-secret", map[string][]byte{corev1.TLSCertKey: []byte("cert")})
+	require.NoError(t, err)
+	assert.Equal(t, "2121-01-01T00:00:00Z", node.Annotations[resource.CertValidUpto])
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clock provides the time the certificates are validated, issued and rotated at, so that tests and
+// recovery drills can run the self-signer at another time than the current one.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the clock of the system.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Offset returns a clock starting at start, which then advances with the real time. It runs a process as
+// if it was started at another time, e.g. to rehearse the expiry of the certificates.
+func Offset(start time.Time) Clock {
+	return offsetClock{offset: time.Until(start)}
+}
+
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+// Fake is a clock which only advances when told to, for the tests.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the time the clock is set to.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set sets the clock to now.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	assert.Equal(t, start, c.Now())
+
+	c.Advance(time.Hour)
+	assert.Equal(t, start.Add(time.Hour), c.Now())
+
+	c.Set(start)
+	assert.Equal(t, start, c.Now())
+}
+
+func TestOffset(t *testing.T) {
+	start := time.Now().Add(365 * 24 * time.Hour)
+	c := clock.Offset(start)
+
+	// the clock starts at the given time and advances with the real time
+	assert.WithinDuration(t, start, c.Now(), time.Second)
+	assert.False(t, c.Now().Before(start.Add(-time.Second)))
+	assert.WithinDuration(t, time.Now(), clock.Real.Now(), time.Second)
+}
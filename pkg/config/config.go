@@ -0,0 +1,256 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the declarative description of the certificates to be generated.
+type Config struct {
+	CA     CAConfig     `json:"ca,omitempty"`
+	Node   NodeConfig   `json:"node,omitempty"`
+	Client ClientConfig `json:"client,omitempty"`
+	// UI, if set, generates a DB Console certificate.
+	UI *UIConfig `json:"ui,omitempty"`
+	// Ingress, if set, generates the certificate of an Ingress or Route fronting the cluster.
+	Ingress *IngressConfig `json:"ingress,omitempty"`
+	// Tenants are the SQL tenants for which tenant client certificates are generated.
+	Tenants []TenantConfig `json:"tenants,omitempty"`
+	// SQLProxy, if set, generates the certificate of the SQL proxy routing SQL clients to the tenants.
+	SQLProxy *SQLProxyConfig `json:"sqlProxy,omitempty"`
+	// RootPassword, if set, generates a password for the root SQL user.
+	RootPassword *RootPasswordConfig `json:"rootPassword,omitempty"`
+}
+
+// CertConfig holds the settings common to all certificate types.
+type CertConfig struct {
+	Duration     string `json:"duration,omitempty"`
+	ExpiryWindow string `json:"expiryWindow,omitempty"`
+	// Secret is the name of the secret the certificate is stored in.
+	Secret string `json:"secret,omitempty"`
+}
+
+// ProfileConfig overrides the key usages of a certificate, named as in RFC 5280, e.g. digitalSignature and
+// serverAuth, and adds policies and extensions to it. The usages set by the cockroach CLI are kept if not set.
+type ProfileConfig struct {
+	KeyUsage    []string `json:"keyUsage,omitempty"`
+	ExtKeyUsage []string `json:"extKeyUsage,omitempty"`
+	// Policies are the dotted certificate policy OIDs of the certificate.
+	Policies   []string          `json:"policies,omitempty"`
+	Extensions []ExtensionConfig `json:"extensions,omitempty"`
+}
+
+// ExtensionConfig describes a custom certificate extension.
+type ExtensionConfig struct {
+	OID      string `json:"oid"`
+	Critical bool   `json:"critical,omitempty"`
+	// Value is the base64 encoded DER value of the extension.
+	Value string `json:"value"`
+}
+
+// CAConfig describes the CA certificate.
+type CAConfig struct {
+	CertConfig `json:",inline"`
+	// ProvidedSecret is the name of a user provided CA secret. If set, the CA is not generated.
+	ProvidedSecret string `json:"providedSecret,omitempty"`
+	// SplitKey keeps the CA key in its own secret, and only the CA certificate in the CA secret.
+	SplitKey bool `json:"splitKey,omitempty"`
+	// KeySecret is the name of the secret holding the CA key with SplitKey.
+	KeySecret string `json:"keySecret,omitempty"`
+}
+
+// NodeConfig describes the node certificate.
+type NodeConfig struct {
+	CertConfig    `json:",inline"`
+	ProfileConfig `json:",inline"`
+	// SANs are additional DNS names or IP addresses added to the node certificate.
+	SANs []string `json:"sans,omitempty"`
+	// PerPodSANReplicas, if set, replaces the wildcard pod DNS names with the names of each
+	// of the given number of statefulset pods.
+	PerPodSANReplicas int `json:"perPodSANReplicas,omitempty"`
+	// PerNode, if set, generates a node certificate for each statefulset pod, stored in its own secret.
+	PerNode bool `json:"perNode,omitempty"`
+}
+
+// UIConfig describes the DB Console certificate.
+type UIConfig struct {
+	CertConfig    `json:",inline"`
+	ProfileConfig `json:",inline"`
+	// Hosts are the public hostnames the DB Console is reached at, e.g. through an Ingress.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// IngressConfig describes the certificate of an Ingress or Route.
+type IngressConfig struct {
+	// Hosts are the hostnames the Ingress serves.
+	Hosts []string `json:"hosts"`
+	// Secret is the name of the kubernetes.io/tls secret the certificate is stored in.
+	Secret string `json:"secret,omitempty"`
+	// CASecret is the name of a secret holding the tls.crt chain and tls.key of the CA signing the
+	// certificate. The cluster CA signs it if not set.
+	CASecret string `json:"caSecret,omitempty"`
+}
+
+// TenantConfig describes the client certificate of a SQL tenant.
+type TenantConfig struct {
+	// ID is the tenant ID, the common name of the certificate.
+	ID uint64 `json:"id"`
+	// Hosts are the names the SQL pods of the tenant serve SQL clients at.
+	Hosts []string `json:"hosts,omitempty"`
+	// Secret is the name of the kubernetes.io/tls secret the certificate is stored in.
+	Secret string `json:"secret,omitempty"`
+}
+
+// SQLProxyConfig describes the certificate of the SQL proxy.
+type SQLProxyConfig struct {
+	// Hosts are the hostnames SQL clients reach the proxy at.
+	Hosts []string `json:"hosts"`
+	// Secret is the name of the kubernetes.io/tls secret the certificate is stored in.
+	Secret string `json:"secret,omitempty"`
+}
+
+// RootPasswordConfig describes the password of the root SQL user.
+type RootPasswordConfig struct {
+	// Secret is the name of the secret the password is stored in.
+	Secret string `json:"secret,omitempty"`
+}
+
+// ClientConfig describes the client certificates.
+type ClientConfig struct {
+	CertConfig    `json:",inline"`
+	ProfileConfig `json:",inline"`
+	KeyAlgorithm  string `json:"keyAlgorithm,omitempty"`
+	// Users are the SQL users, in addition to root, for which client certificates are generated.
+	Users []string `json:"users,omitempty"`
+	// Grants are the privileges and roles assigned to the users when SQL users are provisioned.
+	Grants []GrantConfig `json:"grants,omitempty"`
+	// Principals are the identities of the client certificates of the users, for clusters mapping them to
+	// SQL users with server.identity_map.configuration.
+	Principals []PrincipalConfig `json:"principals,omitempty"`
+	// Subject templates the common name and organizational units of the client certificates.
+	Subject *SubjectConfig `json:"subject,omitempty"`
+}
+
+// SubjectConfig describes the templates of the subject of the client certificates, executed with the User and
+// Namespace of the certificate and the attributes of the user, e.g. CN={{.User}},OU={{.Team}}.
+type SubjectConfig struct {
+	CommonName          string   `json:"commonName,omitempty"`
+	OrganizationalUnits []string `json:"organizationalUnits,omitempty"`
+	// Attributes are the values of the templates by user, e.g. app: {Team: payments}.
+	Attributes map[string]map[string]string `json:"attributes,omitempty"`
+}
+
+// PrincipalConfig describes the identity presented by the client certificate of a SQL user.
+type PrincipalConfig struct {
+	User       string   `json:"user"`
+	CommonName string   `json:"commonName"`
+	DNSNames   []string `json:"dnsNames,omitempty"`
+}
+
+// GrantConfig describes the privileges on a database and the roles granted to a SQL user.
+type GrantConfig struct {
+	User       string   `json:"user"`
+	Database   string   `json:"database,omitempty"`
+	Privileges []string `json:"privileges,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+}
+
+// Privileges are the CockroachDB privileges which can be granted on a database, as listed in the schema.
+var Privileges = []string{"ALL", "CONNECT", "CREATE", "DROP", "GRANT", "SELECT", "INSERT", "DELETE", "UPDATE", "ZONECONFIG"}
+
+// ValidatePrivileges checks that each privilege is one of the Privileges keywords, as they are written to the
+// GRANT statements unquoted.
+func ValidatePrivileges(privileges []string) error {
+	for _, p := range privileges {
+		if !contains(Privileges, p) {
+			return errors.Errorf("invalid privilege %q, must be one of %s", p, strings.Join(Privileges, ", "))
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads and validates the config file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %s", path)
+	}
+
+	return Parse(data)
+}
+
+// Parse validates the YAML or JSON document against the config schema and decodes it.
+func Parse(data []byte) (*Config, error) {
+	doc, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse config")
+	}
+
+	if err := Validate(doc); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(doc, cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to decode config")
+	}
+
+	for _, g := range cfg.Client.Grants {
+		if err := ValidatePrivileges(g.Privileges); err != nil {
+			return nil, errors.Wrapf(err, "invalid config: grants of user %s", g.User)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the JSON document against the config schema.
+func Validate(doc []byte) error {
+	result, err := gojsonschema.Validate(gojsonschema.NewStringLoader(schema), gojsonschema.NewBytesLoader(doc))
+	if err != nil {
+		return errors.Wrap(err, "failed to validate config")
+	}
+
+	if result.Valid() {
+		return nil
+	}
+
+	var msgs []string
+	for _, e := range result.Errors() {
+		msgs = append(msgs, e.String())
+	}
+
+	return fmt.Errorf("invalid config: %s", strings.Join(msgs, "; "))
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/config"
+)
+
+func TestParse(t *testing.T) {
+	data := `
+ca:
+  duration: 43800h
+  expiryWindow: 648h
+  secret: my-ca-secret
+node:
+  duration: 8760h
+  sans:
+  - cockroachdb.example.com
+  - 10.0.0.1
+  policies:
+  - 1.3.6.1.4.1.99999.1.1
+  extensions:
+  - oid: 1.3.6.1.4.1.99999.2
+    value: BQA=
+client:
+  duration: 672h
+  keyAlgorithm: ed25519
+  users:
+  - app
+  - reporting
+  grants:
+  - user: app
+    database: app_db
+    privileges: [ALL]
+  - user: reporting
+    roles: [readonly]
+  principals:
+  - user: app
+    commonName: app@corp.example.com
+    dnsNames:
+    - app.corp.example.com
+  subject:
+    organizationalUnits:
+    - "{{.Team}}"
+    attributes:
+      app:
+        Team: payments
+ui:
+  hosts:
+  - console.example.com
+tenants:
+- id: 10
+  hosts:
+  - tenant-10-sql
+sqlProxy:
+  hosts:
+  - sql.example.com
+`
+
+	cfg, err := config.Parse([]byte(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, "43800h", cfg.CA.Duration)
+	assert.Equal(t, "648h", cfg.CA.ExpiryWindow)
+	assert.Equal(t, "my-ca-secret", cfg.CA.Secret)
+	assert.Equal(t, "8760h", cfg.Node.Duration)
+	assert.Equal(t, []string{"cockroachdb.example.com", "10.0.0.1"}, cfg.Node.SANs)
+	assert.Equal(t, []string{"1.3.6.1.4.1.99999.1.1"}, cfg.Node.Policies)
+	assert.Equal(t, []config.ExtensionConfig{{OID: "1.3.6.1.4.1.99999.2", Value: "BQA="}}, cfg.Node.Extensions)
+	assert.Equal(t, "ed25519", cfg.Client.KeyAlgorithm)
+	assert.Equal(t, []string{"app", "reporting"}, cfg.Client.Users)
+	assert.Equal(t, []config.GrantConfig{
+		{User: "app", Database: "app_db", Privileges: []string{"ALL"}},
+		{User: "reporting", Roles: []string{"readonly"}},
+	}, cfg.Client.Grants)
+	assert.Equal(t, []config.PrincipalConfig{
+		{User: "app", CommonName: "app@corp.example.com", DNSNames: []string{"app.corp.example.com"}},
+	}, cfg.Client.Principals)
+	assert.Equal(t, &config.SubjectConfig{
+		OrganizationalUnits: []string{"{{.Team}}"},
+		Attributes:          map[string]map[string]string{"app": {"Team": "payments"}},
+	}, cfg.Client.Subject)
+	require.NotNil(t, cfg.UI)
+	assert.Equal(t, []string{"console.example.com"}, cfg.UI.Hosts)
+	assert.Equal(t, []config.TenantConfig{{ID: 10, Hosts: []string{"tenant-10-sql"}}}, cfg.Tenants)
+	require.NotNil(t, cfg.SQLProxy)
+	assert.Equal(t, []string{"sql.example.com"}, cfg.SQLProxy.Hosts)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "invalid duration",
+			data: "ca:\n  duration: 5years\n",
+		},
+		{
+			name: "unknown field",
+			data: "node:\n  lifetime: 8760h\n",
+		},
+		{
+			name: "unknown top level section",
+			data: "sql:\n  duration: 8760h\n",
+		},
+		{
+			name: "unsupported key algorithm",
+			data: "client:\n  keyAlgorithm: dsa\n",
+		},
+		{
+			name: "invalid secret name",
+			data: "client:\n  secret: My_Secret\n",
+		},
+		{
+			name: "unknown privilege",
+			data: "client:\n  grants:\n  - user: app\n    database: app_db\n    privileges: [EVERYTHING]\n",
+		},
+		{
+			name: "privilege injecting a statement",
+			data: "client:\n  grants:\n  - user: app\n    database: app_db\n    privileges: ['ALL ON DATABASE x TO y; DROP DATABASE app_db']\n",
+		},
+		{
+			name: "privileges without database",
+			data: "client:\n  grants:\n  - user: app\n    privileges: [ALL]\n",
+		},
+		{
+			name: "principal without common name",
+			data: "client:\n  principals:\n  - user: app\n",
+		},
+		{
+			name: "invalid subject attribute",
+			data: "client:\n  subject:\n    attributes:\n      app:\n        team-name: payments\n",
+		},
+		{
+			name: "duplicate users",
+			data: "client:\n  users: [app, app]\n",
+		},
+		{
+			name: "invalid policy OID",
+			data: "node:\n  policies: [internal]\n",
+		},
+		{
+			name: "extension without value",
+			data: "client:\n  extensions:\n  - oid: 1.3.6.1.4.1.99999.2\n",
+		},
+		{
+			name: "system tenant",
+			data: "tenants:\n- id: 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.Parse([]byte(tt.data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestValidatePrivileges(t *testing.T) {
+	assert.NoError(t, config.ValidatePrivileges([]string{"SELECT", "INSERT"}))
+	assert.NoError(t, config.ValidatePrivileges(config.Privileges))
+
+	for _, privileges := range [][]string{
+		{"ALL ON DATABASE x TO y; DROP DATABASE app_db"},
+		{"SELECT", "INSERT, DELETE"},
+		{"select"},
+		{""},
+	} {
+		assert.Error(t, config.ValidatePrivileges(privileges), "%v", privileges)
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Fingerprint returns the SHA-256 digest of the contents of the config files, which changes once any of
+// them is updated, e.g. when the ConfigMap they are mounted from changes.
+func Fingerprint(paths ...string) (string, error) {
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read config file %s", path)
+		}
+		// the digest of each file is added, so that the contents can't shift between files
+		sum := sha256.Sum256(data)
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
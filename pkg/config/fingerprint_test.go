@@ -0,0 +1,54 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/config"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg, values := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "values.yaml")
+	require.NoError(t, ioutil.WriteFile(cfg, []byte("node:\n  duration: 8760h\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(values, []byte("tls: {}\n"), 0600))
+
+	fingerprint, err := config.Fingerprint(cfg, values)
+	require.NoError(t, err)
+
+	same, err := config.Fingerprint(cfg, values)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, same)
+
+	require.NoError(t, ioutil.WriteFile(cfg, []byte("node:\n  duration: 720h\n"), 0600))
+	changed, err := config.Fingerprint(cfg, values)
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, changed)
+
+	_, err = config.Fingerprint(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+// schema is the JSON schema the certs config file is validated against.
+const schema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "additionalProperties": false,
+  "definitions": {
+    "duration": {
+      "type": "string",
+      "pattern": "^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$"
+    },
+    "sqlName": {
+      "type": "string",
+      "pattern": "^[a-z_][a-z0-9_.-]*$"
+    },
+    "secretName": {
+      "type": "string",
+      "pattern": "^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$",
+      "maxLength": 253
+    },
+    "keyUsage": {
+      "type": "array",
+      "items": { "enum": ["digitalSignature", "contentCommitment", "keyEncipherment", "dataEncipherment", "keyAgreement"] },
+      "uniqueItems": true
+    },
+    "extKeyUsage": {
+      "type": "array",
+      "items": { "enum": ["serverAuth", "clientAuth"] },
+      "uniqueItems": true
+    },
+    "oid": {
+      "type": "string",
+      "pattern": "^[0-2](\\.[0-9]+)+$"
+    },
+    "policies": {
+      "type": "array",
+      "items": { "$ref": "#/definitions/oid" },
+      "uniqueItems": true
+    },
+    "extensions": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["oid", "value"],
+        "properties": {
+          "oid": { "$ref": "#/definitions/oid" },
+          "critical": { "type": "boolean" },
+          "value": { "type": "string", "contentEncoding": "base64" }
+        }
+      }
+    },
+    "cert": {
+      "type": "object",
+      "properties": {
+        "duration": { "$ref": "#/definitions/duration" },
+        "expiryWindow": { "$ref": "#/definitions/duration" },
+        "secret": { "$ref": "#/definitions/secretName" }
+      }
+    }
+  },
+  "properties": {
+    "ca": {
+      "allOf": [
+        { "$ref": "#/definitions/cert" },
+        {
+          "properties": {
+            "duration": {},
+            "expiryWindow": {},
+            "secret": {},
+            "providedSecret": { "$ref": "#/definitions/secretName" },
+            "splitKey": { "type": "boolean" },
+            "keySecret": { "$ref": "#/definitions/secretName" }
+          },
+          "additionalProperties": false
+        }
+      ]
+    },
+    "node": {
+      "allOf": [
+        { "$ref": "#/definitions/cert" },
+        {
+          "properties": {
+            "duration": {},
+            "expiryWindow": {},
+            "secret": {},
+            "sans": {
+              "type": "array",
+              "items": { "type": "string", "minLength": 1 },
+              "uniqueItems": true
+            },
+            "perPodSANReplicas": { "type": "integer", "minimum": 0 },
+            "perNode": { "type": "boolean" },
+            "keyUsage": { "$ref": "#/definitions/keyUsage" },
+            "extKeyUsage": { "$ref": "#/definitions/extKeyUsage" },
+            "policies": { "$ref": "#/definitions/policies" },
+            "extensions": { "$ref": "#/definitions/extensions" }
+          },
+          "additionalProperties": false
+        }
+      ]
+    },
+    "client": {
+      "allOf": [
+        { "$ref": "#/definitions/cert" },
+        {
+          "properties": {
+            "duration": {},
+            "expiryWindow": {},
+            "secret": {},
+            "keyAlgorithm": { "enum": ["rsa", "ed25519"] },
+            "keyUsage": { "$ref": "#/definitions/keyUsage" },
+            "extKeyUsage": { "$ref": "#/definitions/extKeyUsage" },
+            "policies": { "$ref": "#/definitions/policies" },
+            "extensions": { "$ref": "#/definitions/extensions" },
+            "users": {
+              "type": "array",
+              "items": { "$ref": "#/definitions/sqlName" },
+              "uniqueItems": true
+            },
+            "grants": {
+              "type": "array",
+              "items": {
+                "type": "object",
+                "additionalProperties": false,
+                "required": ["user"],
+                "properties": {
+                  "user": { "$ref": "#/definitions/sqlName" },
+                  "database": { "$ref": "#/definitions/sqlName" },
+                  "privileges": {
+                    "type": "array",
+                    "items": {
+                      "enum": ["ALL", "CONNECT", "CREATE", "DROP", "GRANT", "SELECT", "INSERT", "DELETE", "UPDATE", "ZONECONFIG"]
+                    },
+                    "uniqueItems": true
+                  },
+                  "roles": {
+                    "type": "array",
+                    "items": { "$ref": "#/definitions/sqlName" },
+                    "uniqueItems": true
+                  }
+                },
+                "dependencies": {
+                  "privileges": ["database"]
+                }
+              }
+            },
+            "principals": {
+              "type": "array",
+              "items": {
+                "type": "object",
+                "additionalProperties": false,
+                "required": ["user", "commonName"],
+                "properties": {
+                  "user": { "$ref": "#/definitions/sqlName" },
+                  "commonName": { "type": "string", "minLength": 1, "maxLength": 64 },
+                  "dnsNames": {
+                    "type": "array",
+                    "items": { "type": "string", "minLength": 1 },
+                    "uniqueItems": true
+                  }
+                }
+              }
+            },
+            "subject": {
+              "type": "object",
+              "additionalProperties": false,
+              "properties": {
+                "commonName": { "type": "string", "minLength": 1 },
+                "organizationalUnits": {
+                  "type": "array",
+                  "items": { "type": "string", "minLength": 1 },
+                  "uniqueItems": true
+                },
+                "attributes": {
+                  "type": "object",
+                  "propertyNames": { "$ref": "#/definitions/sqlName" },
+                  "additionalProperties": {
+                    "type": "object",
+                    "propertyNames": { "pattern": "^[A-Za-z_][A-Za-z0-9_]*$" },
+                    "additionalProperties": { "type": "string" }
+                  }
+                }
+              }
+            }
+          },
+          "additionalProperties": false
+        }
+      ]
+    },
+    "ui": {
+      "allOf": [
+        { "$ref": "#/definitions/cert" },
+        {
+          "properties": {
+            "duration": {},
+            "expiryWindow": {},
+            "secret": {},
+            "keyUsage": { "$ref": "#/definitions/keyUsage" },
+            "extKeyUsage": { "$ref": "#/definitions/extKeyUsage" },
+            "policies": { "$ref": "#/definitions/policies" },
+            "extensions": { "$ref": "#/definitions/extensions" },
+            "hosts": {
+              "type": "array",
+              "items": { "type": "string", "minLength": 1 },
+              "uniqueItems": true
+            }
+          },
+          "additionalProperties": false
+        }
+      ]
+    },
+    "ingress": {
+      "type": "object",
+      "additionalProperties": false,
+      "required": ["hosts"],
+      "properties": {
+        "hosts": {
+          "type": "array",
+          "items": { "type": "string", "minLength": 1 },
+          "minItems": 1,
+          "uniqueItems": true
+        },
+        "secret": { "$ref": "#/definitions/secretName" },
+        "caSecret": { "$ref": "#/definitions/secretName" }
+      }
+    },
+    "tenants": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["id"],
+        "properties": {
+          "id": { "type": "integer", "minimum": 2 },
+          "hosts": {
+            "type": "array",
+            "items": { "type": "string", "minLength": 1 },
+            "uniqueItems": true
+          },
+          "secret": { "$ref": "#/definitions/secretName" }
+        }
+      }
+    },
+    "sqlProxy": {
+      "type": "object",
+      "additionalProperties": false,
+      "required": ["hosts"],
+      "properties": {
+        "hosts": {
+          "type": "array",
+          "items": { "type": "string", "minLength": 1 },
+          "minItems": 1,
+          "uniqueItems": true
+        },
+        "secret": { "$ref": "#/definitions/secretName" }
+      }
+    },
+    "rootPassword": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "secret": { "$ref": "#/definitions/secretName" }
+      }
+    }
+  }
+}`
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Values mirrors the part of the chart's values.yaml consumed by the self-signer utility.
+type Values struct {
+	TLS TLSValues `json:"tls"`
+}
+
+// TLSValues mirrors the `tls` section of the chart's values.yaml.
+type TLSValues struct {
+	Enabled bool        `json:"enabled"`
+	Certs   CertsValues `json:"certs"`
+}
+
+// CertsValues mirrors the `tls.certs` section of the chart's values.yaml.
+type CertsValues struct {
+	SelfSigner SelfSignerValues `json:"selfSigner"`
+}
+
+// SelfSignerValues mirrors the `tls.certs.selfSigner` section of the chart's values.yaml.
+type SelfSignerValues struct {
+	Enabled                bool               `json:"enabled"`
+	CAProvided             bool               `json:"caProvided"`
+	CASecret               string             `json:"caSecret"`
+	SplitCAKey             bool               `json:"splitCAKey"`
+	MinimumCertDuration    string             `json:"minimumCertDuration"`
+	CACertDuration         string             `json:"caCertDuration"`
+	CACertExpiryWindow     string             `json:"caCertExpiryWindow"`
+	ClientCertDuration     string             `json:"clientCertDuration"`
+	ClientCertExpiryWindow string             `json:"clientCertExpiryWindow"`
+	NodeCertDuration       string             `json:"nodeCertDuration"`
+	NodeCertExpiryWindow   string             `json:"nodeCertExpiryWindow"`
+	RotateCerts            bool               `json:"rotateCerts"`
+	ReadinessWait          string             `json:"readinessWait"`
+	PodUpdateTimeout       string             `json:"podUpdateTimeout"`
+	RootPassword           RootPasswordValues `json:"rootPassword"`
+}
+
+// RootPasswordValues mirrors the `tls.certs.selfSigner.rootPassword` section of the chart's values.yaml.
+type RootPasswordValues struct {
+	Enabled bool   `json:"enabled"`
+	Secret  string `json:"secret"`
+}
+
+// LoadValues reads the chart values file at path, typically mounted from a ConfigMap,
+// and converts its `tls` section into a Config.
+func LoadValues(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read values file %s", path)
+	}
+
+	return ParseValues(data)
+}
+
+// ParseValues decodes the chart values document and converts its `tls` section into a validated Config.
+func ParseValues(data []byte) (*Config, error) {
+	values := &Values{}
+	if err := yaml.Unmarshal(data, values); err != nil {
+		return nil, errors.Wrap(err, "failed to parse values")
+	}
+
+	cfg := values.Config()
+
+	doc, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode config")
+	}
+
+	// the converted config goes through the same validation as a config file
+	return Parse(doc)
+}
+
+// Config converts the self-signer values into a Config.
+func (v *Values) Config() *Config {
+	s := v.TLS.Certs.SelfSigner
+
+	cfg := &Config{
+		CA: CAConfig{
+			CertConfig: CertConfig{
+				Duration:     s.CACertDuration,
+				ExpiryWindow: s.CACertExpiryWindow,
+			},
+		},
+		Node: NodeConfig{
+			CertConfig: CertConfig{
+				Duration:     s.NodeCertDuration,
+				ExpiryWindow: s.NodeCertExpiryWindow,
+			},
+		},
+		Client: ClientConfig{
+			CertConfig: CertConfig{
+				Duration:     s.ClientCertDuration,
+				ExpiryWindow: s.ClientCertExpiryWindow,
+			},
+		},
+	}
+
+	if s.CAProvided {
+		cfg.CA.ProvidedSecret = s.CASecret
+	}
+	cfg.CA.SplitKey = s.SplitCAKey
+
+	if s.RootPassword.Enabled {
+		cfg.RootPassword = &RootPasswordConfig{Secret: s.RootPassword.Secret}
+	}
+
+	return cfg
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/config"
+)
+
+// TestLoadChartValues loads the chart's own values.yaml so that changes to the chart defaults
+// which the loader can't understand are caught here.
+func TestLoadChartValues(t *testing.T) {
+	cfg, err := config.LoadValues("../../cockroachdb/values.yaml")
+	require.NoError(t, err)
+
+	assert.Equal(t, "43800h", cfg.CA.Duration)
+	assert.Equal(t, "648h", cfg.CA.ExpiryWindow)
+	assert.Equal(t, "", cfg.CA.ProvidedSecret)
+	assert.Equal(t, "8760h", cfg.Node.Duration)
+	assert.Equal(t, "168h", cfg.Node.ExpiryWindow)
+	assert.Equal(t, "672h", cfg.Client.Duration)
+	assert.Equal(t, "48h", cfg.Client.ExpiryWindow)
+	assert.Nil(t, cfg.RootPassword)
+}
+
+func TestParseValues(t *testing.T) {
+	data := `
+tls:
+  enabled: true
+  certs:
+    selfSigner:
+      enabled: true
+      caProvided: true
+      caSecret: custom-ca-secret
+      clientCertDuration: 240h
+      rootPassword:
+        enabled: true
+`
+
+	cfg, err := config.ParseValues([]byte(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, "custom-ca-secret", cfg.CA.ProvidedSecret)
+	assert.Equal(t, "240h", cfg.Client.Duration)
+	assert.Equal(t, "", cfg.Node.Duration)
+	require.NotNil(t, cfg.RootPassword)
+	assert.Equal(t, "", cfg.RootPassword.Secret)
+
+	_, err = config.ParseValues([]byte("tls:\n  certs:\n    selfSigner:\n      nodeCertDuration: 1y\n"))
+	assert.Error(t, err)
+}
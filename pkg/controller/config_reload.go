@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/cockroachdb/helm-charts/pkg/config"
+)
+
+// ConfigReloader reloads the config of the controllers once its files change, e.g. when the ConfigMap they
+// are mounted from is updated, so that the controllers apply it without a restart.
+type ConfigReloader struct {
+	// Paths are the config files, checked for changes every Interval.
+	Paths    []string
+	Interval time.Duration
+	// Reload rebuilds the config from the files and requeues the releases, so that their secrets are checked
+	// against the new config. The previous config is kept if it fails.
+	Reload func(ctx context.Context) error
+}
+
+// SetupWithManager runs the reloader with the manager.
+func (r *ConfigReloader) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(r)
+}
+
+// Start checks the config files every Interval until the context is done.
+func (r *ConfigReloader) Start(ctx context.Context) error {
+	fingerprint, err := config.Fingerprint(r.Paths...)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := config.Fingerprint(r.Paths...)
+		if err != nil {
+			// the files are briefly missing while the ConfigMap volume is updated
+			logrus.Warnf("Failed to check the config files for changes: %s", err)
+			continue
+		}
+		if current == fingerprint {
+			continue
+		}
+
+		// a config which fails to load is only retried once the files change again
+		fingerprint = current
+		logrus.Infof("Config files %v changed, reloading the config", r.Paths)
+		if err := r.Reload(ctx); err != nil {
+			logrus.Errorf("Failed to reload the config, keeping the previous one: %s", err)
+			continue
+		}
+		logrus.Info("Reloaded the config")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/controller"
+)
+
+func TestConfigReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("node:\n  duration: 8760h\n"), 0600))
+
+	var reloads int32
+	fail := errors.New("invalid config")
+	r := &controller.ConfigReloader{
+		Paths:    []string{path},
+		Interval: 10 * time.Millisecond,
+		Reload: func(ctx context.Context) error {
+			if atomic.AddInt32(&reloads, 1) == 1 {
+				return fail
+			}
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan error)
+	go func() {
+		done <- r.Start(ctx)
+	}()
+
+	// the config is only reloaded once the files change
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&reloads))
+
+	// a config failing to load is retried once the files change again
+	require.NoError(t, ioutil.WriteFile(path, []byte("node:\n  duration: 720h\n"), 0600))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&reloads) == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("node:\n  duration: 168h\n"), 0600))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&reloads) == 2 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestConfigReloaderMissingFile(t *testing.T) {
+	r := &controller.ConfigReloader{
+		Paths:    []string{"/nonexistent/config.yaml"},
+		Interval: time.Second,
+		Reload:   func(ctx context.Context) error { return nil },
+	}
+	assert.Error(t, r.Start(context.TODO()))
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// DeletionBlocked is the reason of the warning event recorded on a protected secret when its deletion is
+// blocked.
+const DeletionBlocked = "DeletionBlocked"
+
+// DeletionGuardReconciler reports the deletions of the secrets protected by the resource.DeletionProtection
+// finalizer. The finalizer blocks the deletion, so that the secret keeps its certificate, and the incident
+// is logged and recorded as a warning event on the secret until the finalizer is removed.
+type DeletionGuardReconciler struct {
+	Client client.Client
+	// Namespace scopes the secrets, all namespaces if empty.
+	Namespace string
+}
+
+// SetupWithManager registers the reconciler for the protected secrets of the namespace, which are queued once
+// their deletion is requested.
+func (r *DeletionGuardReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("deletion-guard").
+		For(&corev1.Secret{}).
+		WithEventFilter(predicate.Funcs{
+			// protected secrets already being deleted when the controller starts are reported once
+			CreateFunc: func(e event.CreateEvent) bool { return r.blocked(e.Object) },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return e.ObjectOld.GetDeletionTimestamp() == nil && r.blocked(e.ObjectNew)
+			},
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		}).
+		Complete(r)
+}
+
+// blocked returns true if the deletion of the secret is blocked by the finalizer.
+func (r *DeletionGuardReconciler) blocked(o client.Object) bool {
+	return (r.Namespace == "" || o.GetNamespace() == r.Namespace) && o.GetDeletionTimestamp() != nil &&
+		controllerutil.ContainsFinalizer(o, resource.DeletionProtection)
+}
+
+// Reconcile logs the blocked deletion of the secret and records it as a warning event.
+func (r *DeletionGuardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var secret corev1.Secret
+	if err := r.Client.Get(ctx, req.NamespacedName, &secret); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !r.blocked(&secret) {
+		return ctrl.Result{}, nil
+	}
+
+	message := fmt.Sprintf("Deletion of secret %s is blocked by the %s finalizer, the secret keeps its certificate. "+
+		"Run self-signer unprotect --secret %s to allow it", secret.Name, resource.DeletionProtection, secret.Name)
+	logrus.Warnf("Deletion of secret [%s] in namespace [%s] requested at %s is blocked by the %s finalizer",
+		secret.Name, secret.Namespace, secret.DeletionTimestamp.UTC().Format(time.RFC3339),
+		resource.DeletionProtection)
+
+	ref := corev1.ObjectReference{Kind: "Secret", APIVersion: "v1", Namespace: secret.Namespace, Name: secret.Name, UID: secret.UID}
+	if err := kube.RecordEvent(ctx, r.Client, ref, corev1.EventTypeWarning, DeletionBlocked, message); err != nil {
+		logrus.Warnf("Failed to record the blocked deletion of secret [%s]: %s", secret.Name, err)
+	}
+
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/cockroachdb/helm-charts/pkg/controller"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestDeletionGuardReconcile(t *testing.T) {
+	now := metav1.Now()
+	protected := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:              "crdb-ca-secret",
+		Namespace:         "ns",
+		DeletionTimestamp: &now,
+		Finalizers:        []string{resource.DeletionProtection},
+	}}
+	unprotected := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "crdb-node-secret",
+		Namespace: "ns",
+	}}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), protected, unprotected)
+
+	r := &controller.DeletionGuardReconciler{Client: cl, Namespace: "ns"}
+	for _, name := range []string{"crdb-ca-secret", "crdb-node-secret", "missing"} {
+		_, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: name}})
+		require.NoError(t, err)
+	}
+
+	// the deletion is blocked and reported on the protected secret only
+	var events corev1.EventList
+	require.NoError(t, cl.List(context.TODO(), &events))
+	require.Len(t, events.Items, 1)
+	assert.Equal(t, controller.DeletionBlocked, events.Items[0].Reason)
+	assert.Equal(t, "crdb-ca-secret", events.Items[0].InvolvedObject.Name)
+	assert.Equal(t, corev1.EventTypeWarning, events.Items[0].Type)
+
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-ca-secret"}, &secret))
+	assert.Contains(t, secret.Finalizers, resource.DeletionProtection)
+}
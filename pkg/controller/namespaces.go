@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceFilter scopes the namespaces whose releases are managed.
+type NamespaceFilter struct {
+	// Allowed are the only namespaces managed, all of them if empty.
+	Allowed []string
+	// Denied are never managed, even if allowed or matching the selector.
+	Denied []string
+	// Selector matches the labels of the managed namespaces, all of them if nil.
+	Selector labels.Selector
+}
+
+// allows returns false if the name of the namespace excludes it. Its labels are matched separately.
+func (f NamespaceFilter) allows(namespace string) bool {
+	for _, ns := range f.Denied {
+		if ns == namespace {
+			return false
+		}
+	}
+
+	if len(f.Allowed) == 0 {
+		return true
+	}
+	for _, ns := range f.Allowed {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// managesRelease returns true if the statefulset matches the selector in a namespace allowed by the filter.
+func managesRelease(selector labels.Selector, namespaces NamespaceFilter, o client.Object) bool {
+	return namespaces.allows(o.GetNamespace()) && selector.Matches(labels.Set(o.GetLabels()))
+}
+
+// matches returns true if the labels of the namespace, read with the reader, match the selector.
+func (f NamespaceFilter) matches(ctx context.Context, reader client.Reader, name string) (bool, error) {
+	if f.Selector == nil || f.Selector.Empty() {
+		return true, nil
+	}
+
+	var ns corev1.Namespace
+	if err := reader.Get(ctx, client.ObjectKey{Name: name}, &ns); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	return f.Selector.Matches(labels.Set(ns.Labels)), nil
+}
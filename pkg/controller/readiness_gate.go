@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// CertsValidCondition is the pod condition type set by the ReadinessGateReconciler. Pods opt in by
+// listing it in their spec.readinessGates.
+const CertsValidCondition corev1.PodConditionType = "crdb.io/certs-valid"
+
+const defaultDialTimeout = 5 * time.Second
+
+// ReadinessGateReconciler sets the CertsValidCondition of the CockroachDB pods based on whether the
+// certificate presented by the pod is signed by the cluster CA and not expired. It keeps traffic away
+// from pods still serving an expired certificate during a partial rotation.
+type ReadinessGateReconciler struct {
+	Client          client.Client
+	Namespace       string
+	StatefulSetName string
+	NodeSecretName  string
+	// Port is the port on which the pods serve TLS, the gRPC/SQL port by default.
+	Port int
+	// Clock is the time the certificates are verified at, the real time if nil.
+	Clock clock.Clock
+}
+
+// SetupWithManager registers the reconciler for the pods of the statefulset.
+func (r *ReadinessGateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("readiness-gate").
+		For(&corev1.Pod{}).
+		WithEventFilter(predicate.NewPredicateFuncs(func(o client.Object) bool {
+			return o.GetNamespace() == r.Namespace && strings.HasPrefix(o.GetName(), r.StatefulSetName+"-")
+		})).
+		Complete(r)
+}
+
+// Reconcile updates the CertsValidCondition of a single pod.
+func (r *ReadinessGateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var pod corev1.Pod
+	if err := r.Client.Get(ctx, req.NamespacedName, &pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !hasReadinessGate(&pod, CertsValidCondition) || pod.Status.PodIP == "" {
+		return ctrl.Result{}, nil
+	}
+
+	status, reason, message, expiry := r.checkPod(ctx, &pod)
+
+	if err := r.setCondition(ctx, &pod, status, reason, message); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// re-evaluate when the certificate expires, so the condition flips even without pod events
+	if status == corev1.ConditionTrue {
+		return ctrl.Result{RequeueAfter: time.Until(expiry)}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+// checkPod dials the pod and verifies the presented certificate against the CA in the node secret.
+func (r *ReadinessGateReconciler) checkPod(ctx context.Context, pod *corev1.Pod) (status corev1.ConditionStatus,
+	reason, message string, expiry time.Time) {
+
+	secret, err := resource.LoadTLSSecret(r.NodeSecretName, resource.NewKubeResource(ctx, r.Client, r.Namespace, kube.DefaultPersister))
+	if err != nil {
+		return corev1.ConditionFalse, "NodeSecretUnavailable", err.Error(), expiry
+	}
+
+	addr := net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(r.Port))
+	chain, err := security.PeerCertificates(addr, defaultDialTimeout)
+	if err != nil {
+		return corev1.ConditionFalse, "HandshakeFailed", err.Error(), expiry
+	}
+
+	if err := security.VerifyChain(chain, secret.CA(), r.now()); err != nil {
+		return corev1.ConditionFalse, "InvalidCertificate", err.Error(), expiry
+	}
+
+	expiry = chain[0].NotAfter
+	return corev1.ConditionTrue, "CertificateValid", fmt.Sprintf("certificate valid until %s",
+		expiry.Format(time.RFC3339)), expiry
+}
+
+func (r *ReadinessGateReconciler) setCondition(ctx context.Context, pod *corev1.Pod, status corev1.ConditionStatus,
+	reason, message string) error {
+
+	i, existing := kube.GetPodCondition(&pod.Status, CertsValidCondition)
+	if existing != nil && existing.Status == status && existing.Reason == reason {
+		return nil
+	}
+
+	condition := corev1.PodCondition{
+		Type:               CertsValidCondition,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	if existing == nil {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	} else {
+		pod.Status.Conditions[i] = condition
+	}
+
+	logrus.Infof("Setting %s condition of pod [%s] to %s: %s", CertsValidCondition, pod.Name, status, message)
+	return r.Client.Status().Update(ctx, pod)
+}
+
+func hasReadinessGate(pod *corev1.Pod, conditionType corev1.PodConditionType) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+// now returns the current time of the Clock.
+func (r *ReadinessGateReconciler) now() time.Time {
+	if r.Clock == nil {
+		return clock.Real.Now()
+	}
+	return r.Clock.Now()
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+const (
+	// SecretRecreated is the reason of the event recorded on the statefulset when a deleted secret of the
+	// release was recreated.
+	SecretRecreated = "SecretRecreated"
+	// SecretRecreationFailed is the reason of the warning event recorded on the statefulset when a deleted
+	// secret of the release couldn't be recreated. The recreation is retried with backoff.
+	SecretRecreationFailed = "SecretRecreationFailed"
+)
+
+// SecretReconciler manages the certificates of releases with a work queue keyed by secret. Each secret of a
+// release is reconciled on its own, retried with backoff when it fails and requeued every ResyncPeriod, so
+// that a failing certificate doesn't hold back the others of its release. As the queue never reconciles a
+// secret twice at the same time, it is the only way the controller writes the certificates.
+type SecretReconciler struct {
+	Client client.Client
+	// StatefulSetName, in the Namespace, is the only release managed if set. Otherwise, the releases of the
+	// statefulsets matching the Selector in the namespaces of the filter are managed.
+	Namespace       string
+	StatefulSetName string
+	Selector        labels.Selector
+	Namespaces      NamespaceFilter
+	// APIReader reads the namespaces when they are matched by labels.
+	APIReader client.Reader
+	// ResyncPeriod is the interval at which each secret is checked for rotation.
+	ResyncPeriod time.Duration
+	// RateLimiter delays the retries of the failed secrets and limits the overall rate of the reconciles,
+	// the default rate limiter of controller-runtime if nil.
+	RateLimiter ratelimiter.RateLimiter
+	// RotationRequests queues a secret right away when it is annotated with resource.RotateRequested.
+	RotationRequests bool
+	// Secrets returns the names of the secrets managed for the release of the statefulset.
+	Secrets func(ctx context.Context, namespace, statefulSetName string) ([]string, error)
+	// BeginSync prepares the sync of the secrets of the release, whose setup is shared by the reconciles of
+	// its secrets until the release is queued again, a reconcile fails or the ResyncPeriod elapses.
+	BeginSync func(ctx context.Context, namespace, statefulSetName string) (SecretSync, error)
+	// RecreateSecrets regenerates the certificate of a deleted secret of the release right away. The deleted
+	// secrets are left to their next check otherwise.
+	RecreateSecrets bool
+	// Requeue receives the statefulsets whose secrets are reconciled right away, e.g. once the config was
+	// reloaded.
+	Requeue <-chan event.GenericEvent
+	// Clock is the time the syncs begin at, the real time if nil.
+	Clock clock.Clock
+
+	mu sync.Mutex
+	// releases maps the queued secrets to the name of the statefulset of their release
+	releases map[types.NamespacedName]string
+	// deleted are the queued secrets deleted since their last reconcile
+	deleted map[types.NamespacedName]bool
+	// syncs are the current syncs of the releases, keyed by statefulset
+	syncs map[types.NamespacedName]*releaseSync
+}
+
+// SecretSync reconciles the secrets of a release with the setup of a single sync.
+type SecretSync interface {
+	// ReconcileSecret generates or rotates the certificate of a secret of the release.
+	ReconcileSecret(ctx context.Context, secretName string) error
+	// RecreateSecret regenerates the certificate of a deleted secret of the release, and returns false if the
+	// secret isn't one that is recreated, such as the CA secret.
+	RecreateSecret(ctx context.Context, secretName string) (bool, error)
+	// Close releases the resources of the sync.
+	Close()
+}
+
+// releaseSync is the sync of a release, which is reused until it is stale.
+type releaseSync struct {
+	SecretSync
+	began time.Time
+	// stale is set once the release was queued again, so that its next reconcile begins a new sync
+	stale bool
+}
+
+// SetupWithManager registers the reconciler for the secrets of the releases. The secrets of a release are
+// queued when it is installed or its spec changes, e.g. on a scale up, and a secret is queued again when
+// it is deleted or annotated with a rotation request.
+func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	releases := builder.WithPredicates(predicate.GenerationChangedPredicate{}, predicate.NewPredicateFuncs(r.manages))
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("secrets").
+		For(&corev1.Secret{}, builder.WithPredicates(predicate.Funcs{
+			// the secrets are created by the reconciles, and their updates only matter for rotation requests
+			CreateFunc: func(event.CreateEvent) bool { return false },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return r.RotationRequests && r.queued(e.ObjectNew) &&
+					e.ObjectNew.GetAnnotations()[resource.RotateRequested] == "true"
+			},
+			DeleteFunc:  func(e event.DeleteEvent) bool { return r.RecreateSecrets && r.markDeleted(e.Object) },
+			GenericFunc: func(e event.GenericEvent) bool { return r.queued(e.Object) },
+		})).
+		Watches(&source.Kind{Type: &appsv1.StatefulSet{}}, handler.EnqueueRequestsFromMapFunc(r.releaseSecrets), releases).
+		WithOptions(controller.Options{RateLimiter: r.RateLimiter})
+	if r.Requeue != nil {
+		b = b.Watches(&source.Channel{Source: r.Requeue}, handler.EnqueueRequestsFromMapFunc(r.releaseSecrets), releases)
+	}
+	return b.Complete(r)
+}
+
+func (r *SecretReconciler) manages(o client.Object) bool {
+	if r.StatefulSetName != "" {
+		return o.GetNamespace() == r.Namespace && o.GetName() == r.StatefulSetName
+	}
+	return managesRelease(r.Selector, r.Namespaces, o)
+}
+
+// queued returns true if the secret was queued for the release of a statefulset.
+func (r *SecretReconciler) queued(o client.Object) bool {
+	_, ok := r.release(types.NamespacedName{Namespace: o.GetNamespace(), Name: o.GetName()})
+	return ok
+}
+
+// markDeleted records that the queued secret was deleted, so that it is recreated, and returns false if the
+// secret isn't queued.
+func (r *SecretReconciler) markDeleted(o client.Object) bool {
+	key := types.NamespacedName{Namespace: o.GetNamespace(), Name: o.GetName()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.releases[key]; !ok {
+		return false
+	}
+	if r.deleted == nil {
+		r.deleted = map[types.NamespacedName]bool{}
+	}
+	r.deleted[key] = true
+	return true
+}
+
+// wasDeleted returns true if the secret was deleted since its last reconcile.
+func (r *SecretReconciler) wasDeleted(secret types.NamespacedName) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deleted[secret]
+}
+
+// release returns the name of the statefulset of the release of the secret.
+func (r *SecretReconciler) release(secret types.NamespacedName) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name, ok := r.releases[secret]
+	return name, ok
+}
+
+// releaseSecrets returns the requests of the secrets of the release of the statefulset, and records the
+// release of each of them.
+func (r *SecretReconciler) releaseSecrets(o client.Object) []reconcile.Request {
+	names, err := r.Secrets(context.TODO(), o.GetNamespace(), o.GetName())
+	if err != nil {
+		logrus.Errorf("Failed to list the secrets of statefulset [%s] in namespace [%s]: %s", o.GetName(),
+			o.GetNamespace(), err)
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.releases == nil {
+		r.releases = map[types.NamespacedName]string{}
+	}
+
+	// the secrets are reconciled against the current state of the release
+	if current, ok := r.syncs[types.NamespacedName{Namespace: o.GetNamespace(), Name: o.GetName()}]; ok {
+		current.stale = true
+	}
+
+	requests := make([]reconcile.Request, 0, len(names))
+	for _, name := range names {
+		key := types.NamespacedName{Namespace: o.GetNamespace(), Name: name}
+		r.releases[key] = o.GetName()
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+	return requests
+}
+
+// forget drops the secret, which is no longer managed or no longer deleted.
+func (r *SecretReconciler) forget(secret types.NamespacedName, managed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.deleted, secret)
+	if !managed {
+		delete(r.releases, secret)
+	}
+}
+
+// Reconcile generates or rotates the certificate of the secret, and requeues it for its next check. The
+// errors are returned, so that the secret is retried with the backoff of the rate limiter.
+func (r *SecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	stsName, ok := r.release(req.NamespacedName)
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+
+	var sts appsv1.StatefulSet
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: stsName}, &sts); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			r.forget(req.NamespacedName, false)
+			r.endSync(types.NamespacedName{Namespace: req.Namespace, Name: stsName})
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// the labels may have changed since the secret was queued, and nothing is recreated once the release is
+	// uninstalled
+	if !r.manages(&sts) || sts.DeletionTimestamp != nil {
+		r.forget(req.NamespacedName, false)
+		r.endSync(types.NamespacedName{Namespace: sts.Namespace, Name: sts.Name})
+		return ctrl.Result{}, nil
+	}
+
+	if r.StatefulSetName == "" {
+		managed, err := r.Namespaces.matches(ctx, r.APIReader, sts.Namespace)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !managed {
+			r.forget(req.NamespacedName, false)
+			r.endSync(types.NamespacedName{Namespace: sts.Namespace, Name: sts.Name})
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// the secret is dropped from the release when it is removed from the config or the release scaled down
+	names, err := r.Secrets(ctx, sts.Namespace, sts.Name)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !containsName(names, req.Name) {
+		r.forget(req.NamespacedName, false)
+		return ctrl.Result{}, nil
+	}
+
+	release := types.NamespacedName{Namespace: sts.Namespace, Name: sts.Name}
+	secrets, err := r.currentSync(ctx, release)
+	if err != nil {
+		logrus.Errorf("Failed to begin the sync of statefulset [%s] in namespace [%s], retrying: %s", sts.Name,
+			sts.Namespace, err)
+		return ctrl.Result{}, err
+	}
+
+	if r.wasDeleted(req.NamespacedName) {
+		if err := r.recreate(ctx, &sts, secrets, req.Name); err != nil {
+			r.endSync(release)
+			return ctrl.Result{}, err
+		}
+		r.forget(req.NamespacedName, true)
+		return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+	}
+
+	logrus.Infof("Reconciling secret [%s] of statefulset [%s] in namespace [%s]", req.Name, sts.Name, sts.Namespace)
+	if err := secrets.ReconcileSecret(ctx, req.Name); err != nil {
+		logrus.Errorf("Failed to reconcile secret [%s] in namespace [%s], retrying: %s", req.Name, req.Namespace, err)
+		// the retry starts over from the current state of the release
+		r.endSync(release)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// currentSync returns the current sync of the release of the statefulset, or begins a new one if it is stale or
+// began more than a ResyncPeriod ago.
+func (r *SecretReconciler) currentSync(ctx context.Context, release types.NamespacedName) (SecretSync, error) {
+	r.mu.Lock()
+	current, ok := r.syncs[release]
+	reuse := ok && !current.stale && r.now().Sub(current.began) < r.ResyncPeriod
+	r.mu.Unlock()
+	if reuse {
+		return current, nil
+	}
+	r.endSync(release)
+
+	began := r.now()
+	secrets, err := r.BeginSync(ctx, release.Namespace, release.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.syncs == nil {
+		r.syncs = map[types.NamespacedName]*releaseSync{}
+	}
+	r.syncs[release] = &releaseSync{SecretSync: secrets, began: began}
+	return secrets, nil
+}
+
+// endSync closes the current sync of the release of the statefulset, if any.
+func (r *SecretReconciler) endSync(release types.NamespacedName) {
+	r.mu.Lock()
+	current, ok := r.syncs[release]
+	delete(r.syncs, release)
+	r.mu.Unlock()
+	if ok {
+		current.Close()
+	}
+}
+
+// now returns the current time of the Clock.
+func (r *SecretReconciler) now() time.Time {
+	if r.Clock == nil {
+		return clock.Real.Now()
+	}
+	return r.Clock.Now()
+}
+
+// recreate regenerates the deleted secret and records the recreation, or its failure, on the statefulset.
+func (r *SecretReconciler) recreate(ctx context.Context, sts *appsv1.StatefulSet, secrets SecretSync,
+	name string) error {
+	ref := corev1.ObjectReference{Kind: "StatefulSet", APIVersion: "apps/v1", Namespace: sts.Namespace, Name: sts.Name, UID: sts.UID}
+	recreated, err := secrets.RecreateSecret(ctx, name)
+	if err != nil {
+		logrus.Errorf("Failed to recreate deleted secret [%s] in namespace [%s], retrying: %s", name, sts.Namespace, err)
+		message := fmt.Sprintf("Failed to recreate deleted secret %s: %s", name, err)
+		if err := kube.RecordEvent(ctx, r.Client, ref, corev1.EventTypeWarning, SecretRecreationFailed, message); err != nil {
+			logrus.Warnf("Failed to record the failed recreation of secret [%s]: %s", name, err)
+		}
+		return err
+	}
+	if !recreated {
+		return nil
+	}
+
+	logrus.Warnf("Secret [%s] in namespace [%s] was deleted, recreated it", name, sts.Namespace)
+	message := fmt.Sprintf("Secret %s was deleted and has been recreated with a new certificate", name)
+	if err := kube.RecordEvent(ctx, r.Client, ref, corev1.EventTypeNormal, SecretRecreated, message); err != nil {
+		logrus.Warnf("Failed to record the recreation of secret [%s]: %s", name, err)
+	}
+	return nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
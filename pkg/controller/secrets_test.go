@@ -0,0 +1,344 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestSecretReconcile(t *testing.T) {
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns",
+		Labels: map[string]string{"app.kubernetes.io/name": "cockroachdb"}}}
+
+	selector, err := labels.Parse("app.kubernetes.io/name=cockroachdb")
+	require.NoError(t, err)
+
+	secrets := []string{"crdb-ca-secret", "crdb-node-secret", "crdb-client-secret"}
+	failing := errors.New("failed to sign")
+	var reconciled []string
+	r := &SecretReconciler{
+		Client:       testutils.NewFakeClient(testutils.InitScheme(t), sts),
+		Selector:     selector,
+		ResyncPeriod: time.Hour,
+		Secrets: func(ctx context.Context, namespace, statefulSetName string) ([]string, error) {
+			return secrets, nil
+		},
+		BeginSync: func(ctx context.Context, namespace, statefulSetName string) (SecretSync, error) {
+			return &funcSync{reconcile: func(secretName string) error {
+				reconciled = append(reconciled, namespace+"/"+statefulSetName+"/"+secretName)
+				if secretName == "crdb-node-secret" {
+					return failing
+				}
+				return nil
+			}}, nil
+		},
+	}
+
+	// the release queues each of its secrets
+	requests := r.releaseSecrets(sts)
+	require.Len(t, requests, 3)
+	assert.Equal(t, types.NamespacedName{Namespace: "ns", Name: "crdb-ca-secret"}, requests[0].NamespacedName)
+
+	res, err := r.Reconcile(context.TODO(), requests[0])
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, res.RequeueAfter)
+	assert.Equal(t, []string{"ns/crdb/crdb-ca-secret"}, reconciled)
+
+	// a failing secret is returned to the queue for a retry with backoff, and doesn't hold back the others
+	_, err = r.Reconcile(context.TODO(), requests[1])
+	assert.Equal(t, failing, err)
+	res, err = r.Reconcile(context.TODO(), requests[2])
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, res.RequeueAfter)
+	assert.Len(t, reconciled, 3)
+
+	// the secrets of unknown releases are ignored
+	res, err = r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "other"}})
+	require.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+	assert.Len(t, reconciled, 3)
+
+	// a secret removed from the release is forgotten
+	secrets = secrets[:2]
+	res, err = r.Reconcile(context.TODO(), requests[2])
+	require.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+	assert.Len(t, reconciled, 3)
+	assert.False(t, r.queued(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "crdb-client-secret", Namespace: "ns"}}))
+}
+
+func TestSecretReconcileUnmanagedRelease(t *testing.T) {
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"}}
+
+	selector, err := labels.Parse("app.kubernetes.io/name=cockroachdb")
+	require.NoError(t, err)
+
+	r := &SecretReconciler{
+		Client:   testutils.NewFakeClient(testutils.InitScheme(t), sts),
+		Selector: selector,
+		Secrets: func(ctx context.Context, namespace, statefulSetName string) ([]string, error) {
+			return []string{"crdb-node-secret"}, nil
+		},
+		BeginSync: func(ctx context.Context, namespace, statefulSetName string) (SecretSync, error) {
+			t.Fatal("the secrets of a release whose labels no longer match are synced")
+			return nil, nil
+		},
+	}
+
+	requests := r.releaseSecrets(sts)
+	_, err = r.Reconcile(context.TODO(), requests[0])
+	require.NoError(t, err)
+	assert.False(t, r.queued(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"}}))
+}
+
+func TestSecretReconcileNamespaces(t *testing.T) {
+	labelled := map[string]string{"app.kubernetes.io/name": "cockroachdb"}
+	objs := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Labels: map[string]string{"crdb-certs": "managed"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-b"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-c", Labels: map[string]string{"crdb-certs": "managed"}}},
+	}
+	var statefulSets []*appsv1.StatefulSet
+	for _, ns := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+		sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: ns, Labels: labelled}}
+		statefulSets = append(statefulSets, sts)
+		objs = append(objs, sts)
+	}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), objs...)
+
+	selector, err := labels.Parse("app.kubernetes.io/name=cockroachdb")
+	require.NoError(t, err)
+	nsSelector, err := labels.Parse("crdb-certs=managed")
+	require.NoError(t, err)
+
+	var reconciled []string
+	r := &SecretReconciler{
+		Client:   cl,
+		Selector: selector,
+		Namespaces: NamespaceFilter{
+			Denied:   []string{"tenant-c"},
+			Selector: nsSelector,
+		},
+		APIReader: cl,
+		Secrets: func(ctx context.Context, namespace, statefulSetName string) ([]string, error) {
+			return []string{"crdb-node-secret"}, nil
+		},
+		BeginSync: func(ctx context.Context, namespace, statefulSetName string) (SecretSync, error) {
+			return &funcSync{reconcile: func(secretName string) error {
+				reconciled = append(reconciled, namespace)
+				return nil
+			}}, nil
+		},
+	}
+
+	for _, sts := range statefulSets {
+		for _, req := range r.releaseSecrets(sts) {
+			_, err := r.Reconcile(context.TODO(), req)
+			require.NoError(t, err)
+		}
+	}
+	// tenant-b doesn't match the namespace selector and tenant-c is denied
+	assert.Equal(t, []string{"tenant-a"}, reconciled)
+}
+
+func TestSecretReconcileRelease(t *testing.T) {
+	ctx := context.TODO()
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"}}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), sts,
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "ns"}})
+
+	var recreateErr error
+	var reconciled, recreated []string
+	r := &SecretReconciler{
+		Client:          cl,
+		Namespace:       "ns",
+		StatefulSetName: "crdb",
+		ResyncPeriod:    time.Hour,
+		Secrets: func(ctx context.Context, namespace, statefulSetName string) ([]string, error) {
+			return []string{"crdb-ca-secret", "crdb-node-secret", "crdb-client-secret"}, nil
+		},
+		BeginSync: func(ctx context.Context, namespace, statefulSetName string) (SecretSync, error) {
+			return &funcSync{
+				reconcile: func(secretName string) error {
+					reconciled = append(reconciled, secretName)
+					return nil
+				},
+				recreate: func(secretName string) (bool, error) {
+					recreated = append(recreated, secretName)
+					return secretName != "crdb-ca-secret", recreateErr
+				},
+			}, nil
+		},
+		RecreateSecrets: true,
+	}
+
+	// only the statefulset of the release is managed, whatever its labels
+	assert.False(t, r.manages(&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "ns"}}))
+	requests := r.releaseSecrets(sts)
+	for _, req := range requests {
+		res, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, time.Hour, res.RequeueAfter)
+	}
+	assert.Equal(t, []string{"crdb-ca-secret", "crdb-node-secret", "crdb-client-secret"}, reconciled)
+
+	reasons := func() []string {
+		var events corev1.EventList
+		require.NoError(t, cl.List(ctx, &events))
+		var reasons []string
+		for _, e := range events.Items {
+			reasons = append(reasons, e.Reason)
+		}
+		return reasons
+	}
+
+	// the deleted secrets are recreated through the queue, except for the CA
+	reconciled = nil
+	for _, req := range requests[:2] {
+		require.True(t, r.markDeleted(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: req.Name, Namespace: req.Namespace}}))
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"crdb-ca-secret", "crdb-node-secret"}, recreated)
+	assert.Empty(t, reconciled)
+	assert.Equal(t, []string{SecretRecreated}, reasons())
+
+	// the failures are reported and retried as recreations
+	recreateErr = errors.New("CA is missing")
+	require.True(t, r.markDeleted(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-client-secret", Namespace: "ns"}}))
+	_, err := r.Reconcile(ctx, requests[2])
+	assert.Error(t, err)
+	assert.ElementsMatch(t, []string{SecretRecreated, SecretRecreationFailed}, reasons())
+	assert.True(t, r.wasDeleted(requests[2].NamespacedName))
+
+	// once recreated, the secret is back to its checks
+	recreateErr = nil
+	_, err = r.Reconcile(ctx, requests[2])
+	require.NoError(t, err)
+	assert.False(t, r.wasDeleted(requests[2].NamespacedName))
+	_, err = r.Reconcile(ctx, requests[2])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-client-secret"}, reconciled)
+
+	// nothing is recreated once the release is uninstalled
+	require.NoError(t, cl.Delete(ctx, sts))
+	recreated = nil
+	r.markDeleted(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"}})
+	_, err = r.Reconcile(ctx, requests[1])
+	require.NoError(t, err)
+	assert.Empty(t, recreated)
+	assert.False(t, r.queued(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"}}))
+}
+
+func TestSecretReconcileSync(t *testing.T) {
+	ctx := context.TODO()
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"}}
+
+	failing := errors.New("failed to sign")
+	var reconcileErr error
+	var syncs []*funcSync
+	clk := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	r := &SecretReconciler{
+		Client:          testutils.NewFakeClient(testutils.InitScheme(t), sts),
+		Namespace:       "ns",
+		StatefulSetName: "crdb",
+		ResyncPeriod:    time.Hour,
+		Clock:           clk,
+		Secrets: func(ctx context.Context, namespace, statefulSetName string) ([]string, error) {
+			return []string{"crdb-ca-secret", "crdb-node-secret", "crdb-client-secret"}, nil
+		},
+		BeginSync: func(ctx context.Context, namespace, statefulSetName string) (SecretSync, error) {
+			s := &funcSync{reconcile: func(string) error { return reconcileErr }}
+			syncs = append(syncs, s)
+			return s, nil
+		},
+	}
+
+	reconcileAll := func(requests []reconcile.Request) {
+		for _, req := range requests {
+			_, err := r.Reconcile(ctx, req)
+			require.NoError(t, err)
+		}
+	}
+
+	// the secrets of the release share the setup of a single sync
+	requests := r.releaseSecrets(sts)
+	reconcileAll(requests)
+	require.Len(t, syncs, 1)
+	assert.False(t, syncs[0].closed)
+
+	// the release queued again begins a new sync
+	reconcileAll(r.releaseSecrets(sts))
+	require.Len(t, syncs, 2)
+	assert.True(t, syncs[0].closed)
+
+	// as does the next check of the secrets
+	clk.Advance(time.Hour)
+	reconcileAll(requests)
+	require.Len(t, syncs, 3)
+	assert.True(t, syncs[1].closed)
+
+	// and the retry of a failed secret
+	reconcileErr = failing
+	_, err := r.Reconcile(ctx, requests[1])
+	assert.Equal(t, failing, err)
+	assert.True(t, syncs[2].closed)
+	reconcileErr = nil
+	reconcileAll(requests[1:])
+	require.Len(t, syncs, 4)
+
+	// the sync is closed once the release is uninstalled
+	require.NoError(t, r.Client.Delete(ctx, sts))
+	reconcileAll(requests[:1])
+	assert.True(t, syncs[3].closed)
+}
+
+// funcSync is a SecretSync calling its funcs.
+type funcSync struct {
+	reconcile func(secretName string) error
+	recreate  func(secretName string) (bool, error)
+	closed    bool
+}
+
+func (s *funcSync) ReconcileSecret(ctx context.Context, secretName string) error {
+	return s.reconcile(secretName)
+}
+
+func (s *funcSync) RecreateSecret(ctx context.Context, secretName string) (bool, error) {
+	return s.recreate(secretName)
+}
+
+func (s *funcSync) Close() {
+	s.closed = true
+}
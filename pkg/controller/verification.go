@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// VerificationReconciler verifies the certificates of the releases on an interval and records the results,
+// so that the controller audits the certificates it rotates instead of a separate verification CronJob.
+type VerificationReconciler struct {
+	Client client.Client
+	// StatefulSetName, in the Namespace, is the only statefulset verified if set. Otherwise, the statefulsets
+	// matching the Selector in the namespaces of the filter are verified.
+	Namespace       string
+	StatefulSetName string
+	Selector        labels.Selector
+	Namespaces      NamespaceFilter
+	// APIReader reads the namespaces when they are matched by labels.
+	APIReader client.Reader
+	// Interval is the interval at which the certificates of a release are verified.
+	Interval time.Duration
+	// Verify verifies the certificates of the release of the statefulset and records the results.
+	Verify func(ctx context.Context, namespace, statefulSetName string) error
+}
+
+// SetupWithManager registers the reconciler for the verified statefulsets. They are verified when the
+// controller starts, when their spec changes, and every Interval.
+func (r *VerificationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("verification").
+		For(&appsv1.StatefulSet{}).
+		WithEventFilter(predicate.And(
+			predicate.GenerationChangedPredicate{},
+			predicate.NewPredicateFuncs(r.verifies),
+		)).
+		Complete(r)
+}
+
+func (r *VerificationReconciler) verifies(o client.Object) bool {
+	if r.StatefulSetName != "" {
+		return o.GetNamespace() == r.Namespace && o.GetName() == r.StatefulSetName
+	}
+	return managesRelease(r.Selector, r.Namespaces, o)
+}
+
+// Reconcile verifies the certificates of the release of the statefulset and requeues it for the next
+// verification. Failures to verify are retried with a backoff, while the findings are only recorded.
+func (r *VerificationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var sts appsv1.StatefulSet
+	if err := r.Client.Get(ctx, req.NamespacedName, &sts); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !r.verifies(&sts) || sts.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	if r.StatefulSetName == "" {
+		managed, err := r.Namespaces.matches(ctx, r.APIReader, sts.Namespace)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !managed {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	if err := r.Verify(ctx, sts.Namespace, sts.Name); err != nil {
+		logrus.Errorf("Failed to verify the certificates of statefulset [%s] in namespace [%s]: %s", sts.Name,
+			sts.Namespace, err)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: r.Interval}, nil
+}
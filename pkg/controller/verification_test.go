@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/cockroachdb/helm-charts/pkg/controller"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestVerificationReconcile(t *testing.T) {
+	crdb := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns",
+		Labels: map[string]string{"app.kubernetes.io/name": "cockroachdb"}}}
+	other := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns"}}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), crdb, other)
+
+	var verified []string
+	r := &controller.VerificationReconciler{
+		Client:          cl,
+		Namespace:       "ns",
+		StatefulSetName: "crdb",
+		Interval:        6 * time.Hour,
+		Verify: func(ctx context.Context, namespace, statefulSetName string) error {
+			verified = append(verified, namespace+"/"+statefulSetName)
+			return nil
+		},
+	}
+
+	reconcile := func(name string) ctrl.Result {
+		result, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: name}})
+		require.NoError(t, err)
+		return result
+	}
+
+	assert.Equal(t, 6*time.Hour, reconcile("crdb").RequeueAfter)
+	assert.Zero(t, reconcile("other").RequeueAfter)
+	assert.Zero(t, reconcile("deleted").RequeueAfter)
+	assert.Equal(t, []string{"ns/crdb"}, verified)
+
+	// in multi-tenant mode, the statefulsets matching the selector are verified
+	selector, err := labels.Parse("app.kubernetes.io/name=cockroachdb")
+	require.NoError(t, err)
+	r.StatefulSetName, r.Selector = "", selector
+
+	assert.Equal(t, 6*time.Hour, reconcile("crdb").RequeueAfter)
+	assert.Zero(t, reconcile("other").RequeueAfter)
+	assert.Equal(t, []string{"ns/crdb", "ns/crdb"}, verified)
+}
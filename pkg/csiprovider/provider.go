@@ -0,0 +1,155 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csiprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// Name is the provider name referred to by the SecretProviderClasses.
+const Name = "crdb-certs"
+
+// Parameters of the SecretProviderClass, and attributes of the pod added by the driver.
+const (
+	// SecretNameParameter is the client secret delivered to the pod.
+	SecretNameParameter = "secretName"
+	// UserParameter is the SQL user of the client certificate, which names the files. Defaults to root.
+	UserParameter = "user"
+
+	podNamespaceAttribute = "csi.storage.k8s.io/pod.namespace"
+	podNameAttribute      = "csi.storage.k8s.io/pod.name"
+)
+
+// ErrInvalidRequest is returned when the parameters of the SecretProviderClass are invalid.
+var ErrInvalidRequest = errors.New("invalid mount request")
+
+// MountRequest is the request of the Mount RPC of the driver.
+type MountRequest struct {
+	// Attributes is the JSON encoded map of the parameters of the SecretProviderClass and the pod attributes.
+	Attributes string
+	// Secrets is the JSON encoded node publish secret, unused.
+	Secrets    string
+	TargetPath string
+	// Permission is the JSON encoded mode of the files.
+	Permission            string
+	CurrentObjectVersions []ObjectVersion
+}
+
+// MountResponse is the response of the Mount RPC. The driver writes the files to the target path.
+type MountResponse struct {
+	ObjectVersions []ObjectVersion
+	Files          []File
+}
+
+// ObjectVersion is the version of a mounted object, which the driver compares on rotation.
+type ObjectVersion struct {
+	ID      string
+	Version string
+}
+
+// File is a file written by the driver.
+type File struct {
+	Path     string
+	Mode     int32
+	Contents []byte
+}
+
+// Provider delivers the CA certificate and the client certificate and key of a client secret generated by
+// the self-signer, as the files the cockroach CLI expects: ca.crt, client.<user>.crt and client.<user>.key.
+type Provider struct {
+	// Client reads the client secrets of every namespace.
+	Client client.Client
+}
+
+// Mount returns the files of the client secret of the SecretProviderClass. Only the self-signer secrets of
+// the namespace of the pod are delivered, so that the provider can't be used to read any other secret.
+func (p *Provider) Mount(ctx context.Context, req *MountRequest) (*MountResponse, error) {
+	attributes := map[string]string{}
+	if err := json.Unmarshal([]byte(req.Attributes), &attributes); err != nil {
+		return nil, errors.Wrap(ErrInvalidRequest, "failed to decode the attributes")
+	}
+
+	var mode os.FileMode
+	if err := json.Unmarshal([]byte(req.Permission), &mode); err != nil {
+		return nil, errors.Wrap(ErrInvalidRequest, "failed to decode the file permission")
+	}
+
+	namespace, secretName := attributes[podNamespaceAttribute], attributes[SecretNameParameter]
+	if namespace == "" || secretName == "" {
+		return nil, errors.Wrapf(ErrInvalidRequest, "the %s parameter and the pod namespace are required", SecretNameParameter)
+	}
+	user := attributes[UserParameter]
+	if user == "" {
+		user = security.RootUser
+	}
+
+	secret, err := resource.LoadTLSSecret(secretName, resource.NewKubeResource(ctx, p.Client, namespace, kube.DefaultPersister))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get secret [%s]", secretName)
+	}
+	if !secret.Ready() || !secret.ValidateAnnotations() {
+		return nil, errors.Wrapf(resource.ErrInvalidSecret, "secret [%s] is not a client secret of the self-signer", secretName)
+	}
+
+	version := secret.Secret().ResourceVersion
+	resp := &MountResponse{}
+	for _, f := range []File{
+		{Path: resource.CaCert, Mode: int32(mode), Contents: secret.CA()},
+		{Path: fmt.Sprintf("client.%s.crt", user), Mode: int32(mode), Contents: secret.TLSCert()},
+		// the cockroach CLI refuses keys readable by the group or others
+		{Path: fmt.Sprintf("client.%s.key", user), Mode: int32(mode &^ 0077), Contents: secret.TLSPrivateKey()},
+	} {
+		resp.Files = append(resp.Files, f)
+		resp.ObjectVersions = append(resp.ObjectVersions, ObjectVersion{ID: f.Path, Version: version})
+	}
+
+	if changed(req.CurrentObjectVersions, resp.ObjectVersions) {
+		logrus.Infof("Mounting client secret [%s] in pod [%s] of namespace [%s]", secretName,
+			attributes[podNameAttribute], namespace)
+	}
+	return resp, nil
+}
+
+// changed returns true if the versions differ from the ones currently mounted, i.e. on first mount and
+// after the secret was rotated.
+func changed(current, versions []ObjectVersion) bool {
+	if len(current) != len(versions) {
+		return true
+	}
+
+	mounted := map[string]string{}
+	for _, v := range current {
+		mounted[v.ID] = v.Version
+	}
+	for _, v := range versions {
+		if mounted[v.ID] != v.Version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csiprovider_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/csiprovider"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+const attributes = `{"secretName": "crdb-client-secret", "user": "app", "csi.storage.k8s.io/pod.namespace": "ns"}`
+
+func newProvider(t *testing.T) *csiprovider.Provider {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	secret := resource.CreateTLSSecret("crdb-client-secret", corev1.SecretTypeTLS,
+		resource.NewKubeResource(context.TODO(), cl, "ns", kube.DefaultPersister))
+	annotations := resource.GetSecretAnnotations("2021-07-06T04:00:00Z", "2021-07-06T12:00:00Z", "8h0m0s", "1h")
+	require.NoError(t, secret.UpdateTLSSecret([]byte("cert"), []byte("key"), []byte("ca"), annotations))
+
+	return &csiprovider.Provider{Client: cl}
+}
+
+func TestMount(t *testing.T) {
+	p := newProvider(t)
+
+	resp, err := p.Mount(context.TODO(), &csiprovider.MountRequest{Attributes: attributes, Permission: "420"})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 3)
+	assert.Equal(t, csiprovider.File{Path: "ca.crt", Mode: 0644, Contents: []byte("ca")}, resp.Files[0])
+	assert.Equal(t, csiprovider.File{Path: "client.app.crt", Mode: 0644, Contents: []byte("cert")}, resp.Files[1])
+	assert.Equal(t, csiprovider.File{Path: "client.app.key", Mode: 0600, Contents: []byte("key")}, resp.Files[2])
+	assert.Len(t, resp.ObjectVersions, 3)
+
+	// only the self-signer secrets of the namespace of the pod are delivered
+	_, err = p.Mount(context.TODO(), &csiprovider.MountRequest{
+		Attributes: `{"secretName": "crdb-client-secret", "csi.storage.k8s.io/pod.namespace": "other"}`,
+		Permission: "420",
+	})
+	assert.Error(t, err)
+
+	_, err = p.Mount(context.TODO(), &csiprovider.MountRequest{Attributes: `{}`, Permission: "420"})
+	assert.True(t, errors.Is(err, csiprovider.ErrInvalidRequest))
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(newProvider(t).Handler())
+	defer server.Close()
+
+	// the driver calls the provider over HTTP/2 without TLS
+	c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+
+	req := protowire.AppendTag(nil, 1, protowire.BytesType)
+	req = protowire.AppendString(req, attributes)
+	req = protowire.AppendTag(req, 4, protowire.BytesType)
+	req = protowire.AppendString(req, "420")
+
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(req)))
+	resp, err := c.Post(server.URL+"/v1alpha1.CSIDriverProvider/Mount", "application/grpc",
+		bytes.NewReader(append(header, req...)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+	require.Greater(t, len(body), 5)
+	assert.EqualValues(t, len(body)-5, binary.BigEndian.Uint32(body[1:5]))
+	assert.Contains(t, string(body), "client.app.key")
+
+	resp, err = c.Post(server.URL+"/v1alpha1.CSIDriverProvider/Unknown", "application/grpc",
+		bytes.NewReader(make([]byte, 5)))
+	require.NoError(t, err)
+	_, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "12", resp.Trailer.Get("Grpc-Status"))
+}
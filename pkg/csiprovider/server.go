@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csiprovider
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/wire"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/version"
+)
+
+const (
+	// serviceName is the gRPC service the driver calls on the socket of the provider.
+	serviceName = "v1alpha1.CSIDriverProvider"
+	apiVersion  = "v1alpha1"
+)
+
+// Handler serves the Version and Mount RPCs over HTTP/2 without TLS, as the gRPC client of the driver does
+// on the unix socket of the provider. Only uncompressed unary calls are supported.
+func (p *Provider) Handler() http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(p.serveRPC), &http2.Server{})
+}
+
+func (p *Provider) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	in, err := readMessage(r.Body)
+	if err != nil {
+		wire.WriteStatus(w, wire.CodeInvalidArgument, err.Error())
+		return
+	}
+
+	var out []byte
+	switch strings.TrimPrefix(r.URL.Path, "/"+serviceName+"/") {
+	case "Version":
+		out = marshalVersionResponse(apiVersion, Name, version.Get().Version)
+	case "Mount":
+		req, err := unmarshalMountRequest(in)
+		if err != nil {
+			wire.WriteStatus(w, wire.CodeInvalidArgument, err.Error())
+			return
+		}
+
+		resp, err := p.Mount(r.Context(), req)
+		if err != nil {
+			logrus.Errorf("Failed to mount client certificates: %s", err)
+			wire.WriteStatus(w, errorCode(err), err.Error())
+			return
+		}
+		out = marshalMountResponse(resp)
+	default:
+		wire.WriteStatus(w, wire.CodeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+
+	if _, err := w.Write(wire.Frame(out)); err != nil {
+		return
+	}
+	wire.WriteStatus(w, wire.CodeOK, "")
+}
+
+// readMessage reads the length prefixed message of a unary call.
+func readMessage(body io.Reader) ([]byte, error) {
+	msg, err := wire.ReadMessage(body)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(ioutil.Discard, body)
+	return msg, nil
+}
+
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		return wire.CodeInvalidArgument
+	case apierrors.IsNotFound(errors.Cause(err)):
+		return wire.CodeNotFound
+	case errors.Is(err, resource.ErrInvalidSecret):
+		return wire.CodeFailedPrecondition
+	default:
+		return wire.CodeInternal
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csiprovider
+
+import (
+	"github.com/cockroachdb/helm-charts/pkg/internal/wire"
+)
+
+// The messages of the v1alpha1 CSIDriverProvider service of the Secrets Store CSI driver are encoded by
+// hand, as only the Version and Mount RPCs are served.
+
+func unmarshalMountRequest(b []byte) (*MountRequest, error) {
+	fields, err := wire.DecodeFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &MountRequest{}
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			req.Attributes = string(f.Bytes)
+		case 2:
+			req.Secrets = string(f.Bytes)
+		case 3:
+			req.TargetPath = string(f.Bytes)
+		case 4:
+			req.Permission = string(f.Bytes)
+		case 5:
+			version, err := unmarshalObjectVersion(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			req.CurrentObjectVersions = append(req.CurrentObjectVersions, version)
+		}
+	}
+	return req, nil
+}
+
+func unmarshalObjectVersion(b []byte) (ObjectVersion, error) {
+	fields, err := wire.DecodeFields(b)
+	if err != nil {
+		return ObjectVersion{}, err
+	}
+
+	var v ObjectVersion
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			v.ID = string(f.Bytes)
+		case 2:
+			v.Version = string(f.Bytes)
+		}
+	}
+	return v, nil
+}
+
+func marshalObjectVersion(v ObjectVersion) []byte {
+	return wire.AppendString(wire.AppendString(nil, 1, v.ID), 2, v.Version)
+}
+
+func marshalMountResponse(resp *MountResponse) []byte {
+	var b []byte
+	for _, v := range resp.ObjectVersions {
+		b = wire.AppendBytes(b, 1, marshalObjectVersion(v))
+	}
+	for _, f := range resp.Files {
+		file := wire.AppendString(nil, 1, f.Path)
+		file = wire.AppendVarint(file, 2, uint64(f.Mode))
+		file = wire.AppendBytes(file, 3, f.Contents)
+		b = wire.AppendBytes(b, 3, file)
+	}
+	return b
+}
+
+// marshalVersionResponse encodes the version of the provider API, and the name and version of the provider.
+func marshalVersionResponse(version, runtimeName, runtimeVersion string) []byte {
+	return wire.AppendString(wire.AppendString(wire.AppendString(nil, 1, version), 2, runtimeName), 3, runtimeVersion)
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diagnostics collects a bundle of what is needed to debug a failed run of the self-signer: its
+// sanitized config, the metadata of the secrets of the release, the last lines it logged and the details of
+// the API error it failed with. The bundle is written to a ConfigMap or to a directory, as the pods of the
+// failed hook jobs are garbage-collected along with their logs.
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/redact"
+)
+
+// BundleKey is the key of the bundle in the ConfigMap, as JSON.
+const BundleKey = "bundle.json"
+
+// DefaultLogLines is the default number of log lines kept in the bundle.
+const DefaultLogLines = 200
+
+// sensitiveFlag matches the names of the flags whose values are never written to the bundle.
+var sensitiveFlag = regexp.MustCompile(`(?i)(token|password|passwd|secret-key|client-secret|credential)`)
+
+// droppedAnnotations are the annotations of the secrets left out of the bundle, as they may hold their data.
+var droppedAnnotations = []string{corev1.LastAppliedConfigAnnotation}
+
+// Bundle is the diagnostic bundle of a failed run.
+type Bundle struct {
+	Command  string    `json:"command"`
+	Version  string    `json:"version"`
+	Time     time.Time `json:"time"`
+	ExitCode int       `json:"exitCode"`
+	Error    string    `json:"error"`
+	// APIError details the error returned by the API server, if the run failed with one.
+	APIError *APIError `json:"apiError,omitempty"`
+	// Config holds the flags set on the command line and the relevant envs, sanitized.
+	Config  map[string]string `json:"config,omitempty"`
+	Secrets []SecretMetadata  `json:"secrets,omitempty"`
+	// SecretsError tells why the metadata of the secrets couldn't be listed.
+	SecretsError string   `json:"secretsError,omitempty"`
+	Logs         []string `json:"logs,omitempty"`
+}
+
+// APIError is the status returned by the API server.
+type APIError struct {
+	Code    int32                 `json:"code"`
+	Reason  metav1.StatusReason   `json:"reason"`
+	Message string                `json:"message"`
+	Details *metav1.StatusDetails `json:"details,omitempty"`
+}
+
+// SecretMetadata is the metadata of a secret, along with the keys of its data but not their values.
+type SecretMetadata struct {
+	Name              string            `json:"name"`
+	Type              corev1.SecretType `json:"type"`
+	ResourceVersion   string            `json:"resourceVersion"`
+	CreationTimestamp metav1.Time       `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	Keys              []string          `json:"keys"`
+}
+
+// APIErrorOf returns the status of the API error wrapped by err, or nil.
+func APIErrorOf(err error) *APIError {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return nil
+	}
+
+	s := status.Status()
+	return &APIError{Code: s.Code, Reason: s.Reason, Message: s.Message, Details: s.Details}
+}
+
+// SanitizeFlags returns the flags set on the command line, with the values of the sensitive ones replaced by
+// the redact.Placeholder and the others redacted with r.
+func SanitizeFlags(flags *pflag.FlagSet, r *redact.Redactor) map[string]string {
+	config := map[string]string{}
+	flags.Visit(func(f *pflag.Flag) {
+		config["--"+f.Name] = sanitize(f.Name, f.Value.String(), r)
+	})
+
+	return config
+}
+
+// SanitizeEnv adds the envs which are set to the config, sanitized as the flags.
+func SanitizeEnv(config map[string]string, names []string, r *redact.Redactor) {
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			config[name] = sanitize(name, value, r)
+		}
+	}
+}
+
+func sanitize(name, value string, r *redact.Redactor) string {
+	if sensitiveFlag.MatchString(name) {
+		return redact.Placeholder
+	}
+
+	return r.Redact(value)
+}
+
+// ListSecrets returns the metadata of the secrets of the namespace matching the labels.
+func ListSecrets(ctx context.Context, cl client.Client, namespace string, labels map[string]string) ([]SecretMetadata, error) {
+	var secrets corev1.SecretList
+	if err := cl.List(ctx, &secrets, client.InNamespace(namespace), client.MatchingLabels(labels)); err != nil {
+		return nil, errors.Wrap(err, "failed to list the secrets")
+	}
+
+	var metadata []SecretMetadata
+	for _, s := range secrets.Items {
+		annotations := map[string]string{}
+		for k, v := range s.Annotations {
+			annotations[k] = v
+		}
+		for _, k := range droppedAnnotations {
+			delete(annotations, k)
+		}
+
+		keys := make([]string, 0, len(s.Data))
+		for k := range s.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		metadata = append(metadata, SecretMetadata{
+			Name:              s.Name,
+			Type:              s.Type,
+			ResourceVersion:   s.ResourceVersion,
+			CreationTimestamp: s.CreationTimestamp,
+			Labels:            s.Labels,
+			Annotations:       annotations,
+			Keys:              keys,
+		})
+	}
+
+	return metadata, nil
+}
+
+// WriteConfigMap writes the bundle to the ConfigMap, replacing the bundle of an earlier failure.
+func (b *Bundle) WriteConfigMap(ctx context.Context, cl client.Client, namespace, name string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the diagnostic bundle")
+	}
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	_, err = kube.DefaultPersister(ctx, cl, cm, func() error {
+		cm.Data = map[string]string{BundleKey: string(data)}
+		return nil
+	})
+
+	return errors.Wrapf(err, "failed to write the diagnostic bundle to ConfigMap [%s]", name)
+}
+
+// WriteDir writes the bundle to a diagnostics-<time>.json file of the directory, such as an emptyDir volume,
+// and returns its path.
+func (b *Bundle) WriteDir(dir string) (string, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal the diagnostic bundle")
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("diagnostics-%s.json", b.Time.UTC().Format("20060102T150405Z")))
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", errors.Wrap(err, "failed to write the diagnostic bundle")
+	}
+
+	return path, nil
+}
+
+// Tail is a writer keeping the last lines written to it, such as the output of the loggers.
+type Tail struct {
+	mu      sync.Mutex
+	size    int
+	lines   []string
+	partial string
+}
+
+// NewTail returns a Tail keeping the last size lines.
+func NewTail(size int) *Tail {
+	return &Tail{size: size}
+}
+
+// Write splits the input into lines, keeping an unterminated line until the rest of it is written.
+func (t *Tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	text := t.partial + string(p)
+	lines := strings.Split(text, "\n")
+	t.partial = lines[len(lines)-1]
+	t.lines = append(t.lines, lines[:len(lines)-1]...)
+	if len(t.lines) > t.size {
+		t.lines = append([]string{}, t.lines[len(t.lines)-t.size:]...)
+	}
+
+	return len(p), nil
+}
+
+// Lines returns the last lines written, including an unterminated one.
+func (t *Tail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := append([]string{}, t.lines...)
+	if t.partial != "" {
+		lines = append(lines, t.partial)
+	}
+	if len(lines) > t.size {
+		lines = lines[len(lines)-t.size:]
+	}
+
+	return lines
+}
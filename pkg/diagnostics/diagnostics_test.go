@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagnostics_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/diagnostics"
+	"github.com/cockroachdb/helm-charts/pkg/redact"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestTail(t *testing.T) {
+	tail := diagnostics.NewTail(3)
+	for i := 1; i <= 4; i++ {
+		fmt.Fprintf(tail, "line %d\n", i)
+	}
+	_, _ = tail.Write([]byte("partial"))
+	assert.Equal(t, []string{"line 3", "line 4", "partial"}, tail.Lines())
+
+	_, _ = tail.Write([]byte(" line\n"))
+	assert.Equal(t, []string{"line 3", "line 4", "partial line"}, tail.Lines())
+}
+
+func TestSanitizeFlags(t *testing.T) {
+	r, err := redact.New()
+	require.NoError(t, err)
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("ca-secret", "", "")
+	flags.String("oidc-client-secret", "", "")
+	flags.String("ca-key", "", "")
+	flags.String("node-duration", "8760h", "")
+	require.NoError(t, flags.Parse([]string{"--ca-secret=crdb-ca", "--oidc-client-secret=s3cr3t", "--ca-key=/certs/ca.key"}))
+
+	assert.Equal(t, map[string]string{
+		"--ca-secret":          "crdb-ca",
+		"--oidc-client-secret": redact.Placeholder,
+		"--ca-key":             redact.Placeholder,
+	}, diagnostics.SanitizeFlags(flags, r))
+}
+
+func TestAPIErrorOf(t *testing.T) {
+	err := errors.Wrap(apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "crdb-ca-secret",
+		errors.New("denied")), "failed to get the CA")
+
+	apiErr := diagnostics.APIErrorOf(err)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, int32(403), apiErr.Code)
+	assert.Equal(t, metav1.StatusReasonForbidden, apiErr.Reason)
+	assert.Equal(t, "crdb-ca-secret", apiErr.Details.Name)
+
+	assert.Nil(t, diagnostics.APIErrorOf(errors.New("not an API error")))
+}
+
+func TestWriteBundle(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "crdb-node-secret",
+				Namespace: "ns",
+				Labels:    map[string]string{"app": "crdb"},
+				Annotations: map[string]string{
+					"certificate-valid-upto":           "2030-01-01T00:00:00Z",
+					corev1.LastAppliedConfigAnnotation: `{"data":{"tls.key":"..."}}`,
+				},
+			},
+			Data: map[string][]byte{"tls.key": []byte("key"), "tls.crt": []byte("cert"), "ca.crt": []byte("ca")},
+		},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns"}},
+	)
+
+	secrets, err := diagnostics.ListSecrets(ctx, cl, "ns", map[string]string{"app": "crdb"})
+	require.NoError(t, err)
+	require.Len(t, secrets, 1)
+	assert.Equal(t, []string{"ca.crt", "tls.crt", "tls.key"}, secrets[0].Keys)
+	assert.Equal(t, map[string]string{"certificate-valid-upto": "2030-01-01T00:00:00Z"}, secrets[0].Annotations)
+
+	bundle := &diagnostics.Bundle{
+		Command:  "generate",
+		Time:     time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC),
+		ExitCode: 1,
+		Error:    "error Generating CA",
+		Secrets:  secrets,
+		Logs:     []string{"Generating CA"},
+	}
+	require.NoError(t, bundle.WriteConfigMap(ctx, cl, "ns", "crdb-diagnostics"))
+
+	var cm corev1.ConfigMap
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-diagnostics"}, &cm))
+	var written diagnostics.Bundle
+	require.NoError(t, json.Unmarshal([]byte(cm.Data[diagnostics.BundleKey]), &written))
+	assert.Equal(t, "error Generating CA", written.Error)
+	assert.Equal(t, "crdb-node-secret", written.Secrets[0].Name)
+
+	// a later failure replaces the bundle
+	bundle.Error = "error Deleting Retired Secrets"
+	require.NoError(t, bundle.WriteConfigMap(ctx, cl, "ns", "crdb-diagnostics"))
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-diagnostics"}, &cm))
+	assert.Contains(t, cm.Data[diagnostics.BundleKey], "error Deleting Retired Secrets")
+
+	dir, err := ioutil.TempDir("", "diagnostics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path, err := bundle.WriteDir(dir)
+	require.NoError(t, err)
+	assert.Contains(t, path, "diagnostics-20300101T120000Z.json")
+}
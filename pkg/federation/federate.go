@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// Target is a cluster to which the CA secret is copied.
+type Target struct {
+	// Name identifies the cluster in logs, typically the kubeconfig context.
+	Name     string
+	Resource resource.Resource
+}
+
+// FederateCA copies the CA secret from the source cluster to each of the targets, so that every
+// cluster trusts the same CA. A target which already has a CA secret with a different CA is only
+// overwritten if force is set. The fingerprint of every copy is verified after writing it.
+func FederateCA(source resource.Resource, secretName string, targets []Target, force bool) error {
+	caSecret, err := resource.LoadTLSSecret(secretName, source)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get CA secret [%s] from the source cluster", secretName)
+	}
+
+	if !caSecret.ReadyCA() {
+		return fmt.Errorf("CA secret [%s] doesn't contain the required CA cert/key", secretName)
+	}
+
+	fingerprint, err := security.Fingerprint(caSecret.CA())
+	if err != nil {
+		return errors.Wrap(err, "failed to get fingerprint of the source CA")
+	}
+	logrus.Infof("Federating CA secret [%s] with fingerprint %s", secretName, fingerprint)
+
+	var failed []string
+	for _, t := range targets {
+		if err := federate(caSecret, fingerprint, t, force); err != nil {
+			logrus.Errorf("Failed to federate CA to [%s]: %s", t.Name, err.Error())
+			failed = append(failed, t.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to federate CA to %v", failed)
+	}
+
+	return nil
+}
+
+func federate(caSecret *resource.TLSSecret, fingerprint string, t Target, force bool) error {
+	name := caSecret.Secret().Name
+
+	existing, err := resource.LoadTLSSecret(name, t.Resource)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+
+	if err == nil && existing.ReadyCA() {
+		existingFingerprint, err := security.Fingerprint(existing.CA())
+		if err == nil && existingFingerprint == fingerprint {
+			logrus.Infof("CA secret in [%s] is already in sync", t.Name)
+			return nil
+		}
+
+		if !force {
+			return fmt.Errorf("a different CA (fingerprint %s) already exists, use force to overwrite it",
+				existingFingerprint)
+		}
+		logrus.Warnf("Overwriting CA secret in [%s] with fingerprint %s", t.Name, existingFingerprint)
+	}
+
+	annotations := map[string]string{}
+	for k, v := range caSecret.Secret().Annotations {
+		annotations[k] = v
+	}
+
+	target := resource.CreateTLSSecret(name, corev1.SecretTypeOpaque, t.Resource)
+	if err := target.UpdateCASecret(caSecret.CAKey(), caSecret.CA(), annotations); err != nil {
+		return errors.Wrap(err, "failed to write CA secret")
+	}
+
+	// read the secret back to verify what the target cluster stored
+	written, err := resource.LoadTLSSecret(name, t.Resource)
+	if err != nil {
+		return errors.Wrap(err, "failed to read back CA secret")
+	}
+
+	writtenFingerprint, err := security.Fingerprint(written.CA())
+	if err != nil || writtenFingerprint != fingerprint {
+		return fmt.Errorf("fingerprint mismatch after writing CA secret, expected %s got %s", fingerprint,
+			writtenFingerprint)
+	}
+
+	logrus.Infof("Federated CA secret to [%s]", t.Name)
+	return nil
+}
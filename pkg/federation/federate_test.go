@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package federation_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/federation"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestFederateCA(t *testing.T) {
+	ctx := context.TODO()
+	scheme := testutils.InitScheme(t)
+	namespace := "test-namespace"
+	name := "crdb-ca-secret"
+
+	ca := caSecret(name, namespace, testcerts.CACert, testcerts.CAKey)
+	other := caSecret(name, namespace, testcerts.OtherCACert, testcerts.OtherCAKey)
+
+	source := resource.NewKubeResource(ctx, testutils.NewFakeClient(scheme, ca), namespace, kube.DefaultPersister)
+	empty := resource.NewKubeResource(ctx, testutils.NewFakeClient(scheme), namespace, kube.DefaultPersister)
+	synced := resource.NewKubeResource(ctx, testutils.NewFakeClient(scheme, ca.DeepCopy()), namespace, kube.DefaultPersister)
+	different := resource.NewKubeResource(ctx, testutils.NewFakeClient(scheme, other), namespace, kube.DefaultPersister)
+
+	// a target with a different CA is not overwritten without force
+	err := federation.FederateCA(source, name, []federation.Target{
+		{Name: "empty", Resource: empty},
+		{Name: "synced", Resource: synced},
+		{Name: "different", Resource: different},
+	}, false)
+	assert.EqualError(t, err, "failed to federate CA to [different]")
+
+	copied, err := resource.LoadTLSSecret(name, empty)
+	require.NoError(t, err)
+	assert.Equal(t, ca.Data, copied.Secret().Data)
+
+	require.NoError(t, federation.FederateCA(source, name, []federation.Target{{Name: "different", Resource: different}}, true))
+
+	overwritten, err := resource.LoadTLSSecret(name, different)
+	require.NoError(t, err)
+	assert.Equal(t, ca.Data, overwritten.Secret().Data)
+}
+
+func caSecret(name, namespace, cert, key string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Data: map[string][]byte{
+			resource.CaCert: []byte(cert),
+			resource.CaKey:  []byte(key),
+		},
+	}
+}
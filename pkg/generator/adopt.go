@@ -0,0 +1,329 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	util "github.com/cockroachdb/helm-charts/pkg/utils"
+)
+
+// AdoptedCerts are the CA, node and client certificates of a cluster created by another tool, which are
+// imported into the secrets of the self-signer so that the cluster keeps its CA.
+type AdoptedCerts struct {
+	// Source describes where the certificates were loaded from, for the audit log.
+	Source string
+
+	CACert, CAKey         []byte
+	NodeCert, NodeKey     []byte
+	ClientCert, ClientKey []byte
+}
+
+// Validate checks that the CA key matches the CA certificate, and that the node and client certificates
+// match their keys, are issued to the node and the user and are signed by the CA.
+func (c AdoptedCerts) Validate(user string, now time.Time) error {
+	if _, _, err := security.ParseCAPair(c.CACert, c.CAKey); err != nil {
+		return errors.Wrapf(resource.ErrInvalidSecret, "invalid CA certificate and key: %s", err)
+	}
+
+	if err := security.VerifyPair(c.NodeCert, c.NodeKey, c.CACert, security.NodeUser, now); err != nil {
+		return errors.Wrapf(resource.ErrInvalidSecret, "invalid node certificate: %s", err)
+	}
+
+	if err := security.VerifyPair(c.ClientCert, c.ClientKey, c.CACert, user, now); err != nil {
+		return errors.Wrapf(resource.ErrInvalidSecret, "invalid client certificate: %s", err)
+	}
+
+	return nil
+}
+
+// LoadSecretCerts loads the certificates to adopt from the CA, node and client secrets of the namespace.
+// Both the tls.crt and tls.key keys and the key names of the cockroach CLI are read, and the CA certificate
+// is read from the node secret if the CA secret only holds the CA key.
+func LoadSecretCerts(ctx context.Context, cl client.Client, namespace, caSecret, nodeSecret, clientSecret string) (AdoptedCerts, error) {
+	certs := AdoptedCerts{Source: "secrets " + caSecret + ", " + nodeSecret + ", " + clientSecret}
+
+	load := func(name string) (map[string][]byte, error) {
+		secret, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, cl, namespace, kube.DefaultPersister))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get secret [%s]", name)
+		}
+		return secret.Secret().Data, nil
+	}
+
+	ca, err := load(caSecret)
+	if err != nil {
+		return certs, err
+	}
+	node, err := load(nodeSecret)
+	if err != nil {
+		return certs, err
+	}
+	clientData, err := load(clientSecret)
+	if err != nil {
+		return certs, err
+	}
+
+	certs.CACert = firstKey(ca, resource.CaCert, corev1.TLSCertKey)
+	if certs.CACert == nil {
+		certs.CACert = node[resource.CaCert]
+	}
+	certs.CAKey = firstKey(ca, resource.CaKey, corev1.TLSPrivateKeyKey)
+	certs.NodeCert = firstKey(node, corev1.TLSCertKey, "node.crt")
+	certs.NodeKey = firstKey(node, corev1.TLSPrivateKeyKey, "node.key")
+	certs.ClientCert = firstKey(clientData, corev1.TLSCertKey, "client.root.crt")
+	certs.ClientKey = firstKey(clientData, corev1.TLSPrivateKeyKey, "client.root.key")
+
+	return certs, nil
+}
+
+// LoadDirCerts loads the certificates to adopt from a certs directory created with the cockroach cert
+// commands. The CA key is read from caKey, as it is usually kept out of the certs directory.
+func LoadDirCerts(certsDir, caKey, user string) (AdoptedCerts, error) {
+	files := map[string][]byte{}
+	for _, name := range cockroachFiles(user) {
+		path := filepath.Join(certsDir, name)
+		if name == resource.CaKey {
+			path = caKey
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return AdoptedCerts{}, errors.Wrapf(err, "unable to read %s", name)
+		}
+		files[name] = data
+	}
+
+	return cockroachCerts(files, user, "directory "+certsDir), nil
+}
+
+// LoadCertsSecret loads the certificates to adopt from a secret created from a certs directory of the
+// cockroach cert commands, e.g. with kubectl create secret generic --from-file. The CA key is read from the
+// secret, or from the caKey file if given.
+func LoadCertsSecret(ctx context.Context, cl client.Client, namespace, name, caKey, user string) (AdoptedCerts, error) {
+	secret, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, cl, namespace, kube.DefaultPersister))
+	if err != nil {
+		return AdoptedCerts{}, errors.Wrapf(err, "failed to get secret [%s]", name)
+	}
+
+	files := secret.Secret().Data
+	if caKey != "" {
+		if files[resource.CaKey], err = ioutil.ReadFile(caKey); err != nil {
+			return AdoptedCerts{}, errors.Wrap(err, "unable to read ca.key")
+		}
+	}
+
+	for _, name := range cockroachFiles(user) {
+		if _, ok := files[name]; !ok {
+			return AdoptedCerts{}, errors.Wrapf(resource.ErrInvalidSecret, "secret [%s] doesn't contain %s", secret.Secret().Name, name)
+		}
+	}
+
+	return cockroachCerts(files, user, "secret "+name), nil
+}
+
+// cockroachFiles returns the names of the files of a certs directory of the cockroach CLI holding the CA,
+// node and client certificates of the user.
+func cockroachFiles(user string) []string {
+	return []string{resource.CaCert, resource.CaKey, "node.crt", "node.key",
+		"client." + user + ".crt", "client." + user + ".key"}
+}
+
+// cockroachCerts returns the certificates held by the files of a certs directory of the cockroach CLI.
+func cockroachCerts(files map[string][]byte, user, source string) AdoptedCerts {
+	return AdoptedCerts{
+		Source:     source,
+		CACert:     files[resource.CaCert],
+		CAKey:      files[resource.CaKey],
+		NodeCert:   files["node.crt"],
+		NodeKey:    files["node.key"],
+		ClientCert: files["client."+user+".crt"],
+		ClientKey:  files["client."+user+".key"],
+	}
+}
+
+// ClientUser returns the user of the client certificate.
+func (rc *GenerateCert) ClientUser() string {
+	user, _ := rc.clientUser(rc.getClientSecretName())
+	return user
+}
+
+// firstKey returns the value of the first of the keys found in the data.
+func firstKey(data map[string][]byte, keys ...string) []byte {
+	for _, key := range keys {
+		if value, ok := data[key]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+// Adopt validates the certificates and stores them in the CA, node and client secrets, annotated like the
+// certificates the self-signer generates so that they are rotated on schedule instead of being regenerated.
+// Existing secrets are only replaced if overwrite is set.
+func (rc *GenerateCert) Adopt(ctx context.Context, namespace string, certs AdoptedCerts, overwrite bool) error {
+	if rc.CaSecret != "" || rc.signed() {
+		return errors.New("certificates can't be adopted when the CA is provided by the user or the signer")
+	}
+
+	if rc.PerNodeCerts {
+		return errors.New("certificates can't be adopted with per-node certificates")
+	}
+
+	user, clientSecretName := rc.clientUser(rc.getClientSecretName())
+	if err := certs.Validate(user, rc.now()); err != nil {
+		return err
+	}
+
+	if err := rc.checkPermissions(ctx, namespace, true); err != nil {
+		return err
+	}
+
+	// the CA key is read from a file to attest the adopted certificates
+	caDir, cleanup := util.CreateTempDir("caDir")
+	defer cleanup()
+	rc.CAKey = filepath.Join(caDir, resource.CaKey)
+	if err := ioutil.WriteFile(rc.CAKey, certs.CAKey, security.KeyFileMode); err != nil {
+		return errors.Wrap(err, "unable to write ca.key")
+	}
+
+	if err := rc.adoptCA(ctx, namespace, certs, overwrite); err != nil {
+		return err
+	}
+
+	if err := rc.adoptPair(ctx, namespace, rc.getNodeSecretName(), certs.NodeCert, certs.NodeKey, certs.CACert,
+		rc.NodeCertConfig, map[string]string{"source": certs.Source}, rc.persister(), overwrite); err != nil {
+		return err
+	}
+
+	algorithm := security.RSAAlgorithm
+	if key, err := security.ParsePrivateKey(certs.ClientKey); err == nil {
+		if _, ok := key.(ed25519.PrivateKey); ok {
+			algorithm = security.Ed25519Algorithm
+		}
+	}
+
+	return rc.adoptPair(ctx, namespace, clientSecretName, certs.ClientCert, certs.ClientKey, certs.CACert,
+		rc.ClientCertConfig, map[string]string{"source": certs.Source, "user": user, "keyAlgorithm": algorithm},
+		rc.clientPersister(), overwrite)
+}
+
+// adoptCA stores the adopted CA certificate and key in the CA secret, or the CA key secret with
+// SplitCASecret.
+func (rc *GenerateCert) adoptCA(ctx context.Context, namespace string, certs AdoptedCerts, overwrite bool) error {
+	name := rc.CAKeySecret()
+
+	loaded, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, "failed to get CA secret")
+	}
+
+	version := loaded.Secret().ResourceVersion
+	if version != "" && !overwrite {
+		return errors.Errorf("secret [%s] already exists", name)
+	}
+
+	validFrom, validUpto, err := rc.getCertLife(certs.CACert)
+	if err != nil {
+		return err
+	}
+	rc.lintUserCerts(namespace, name, certs.CACert)
+
+	annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.CaCertConfig.Duration.String(),
+		rc.CaCertConfig.ExpiryWindow.String())
+	inputs := map[string]string{"source": certs.Source}
+
+	if err := rc.attest(ctx, namespace, name, certs.CACert, inputs, annotations); err != nil {
+		return err
+	}
+
+	secret := resource.CreateTLSSecret(name, corev1.SecretTypeOpaque,
+		resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	secret.ExpectResourceVersion(version)
+	secret.SetPolicyVersion(rc.PolicyVersion)
+
+	if err := secret.UpdateCASecret(certs.CAKey, certs.CACert, annotations); err != nil {
+		return errors.Wrap(err, "failed to update ca key secret")
+	}
+
+	logrus.Infof("Adopted CA key and certificate in secret [%s]", name)
+	if err := rc.publishCACert(ctx, namespace, secret); err != nil {
+		return err
+	}
+
+	return rc.recordIssued(ctx, audit.Adopt, namespace, name, certs.CACert, inputs)
+}
+
+// adoptPair stores an adopted certificate and key in the secret name.
+func (rc *GenerateCert) adoptPair(ctx context.Context, namespace, name string, pemCert, pemKey, ca []byte,
+	config *CertConfig, inputs map[string]string, persister kube.PersistFn, overwrite bool) error {
+
+	currentName, err := rc.currentSecretName(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := resource.LoadTLSSecret(currentName, resource.NewKubeResource(ctx, rc.client, namespace, persister))
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrapf(err, "failed to get secret [%s]", currentName)
+	}
+
+	if loaded.Secret().ResourceVersion != "" && !overwrite {
+		return errors.Errorf("secret [%s] already exists", currentName)
+	}
+
+	validFrom, validUpto, err := rc.getCertLife(pemCert)
+	if err != nil {
+		return err
+	}
+	rc.lintUserCerts(namespace, name, pemCert)
+
+	annotations := resource.GetSecretAnnotations(validFrom, validUpto, config.Duration.String(),
+		config.ExpiryWindow.String())
+	if algorithm, ok := inputs["keyAlgorithm"]; ok {
+		annotations[resource.KeyAlgorithm] = algorithm
+	}
+
+	if err := rc.attest(ctx, namespace, name, pemCert, inputs, annotations); err != nil {
+		return err
+	}
+
+	secret := rc.newTLSSecret(ctx, namespace, loaded, persister)
+	if err := secret.UpdateTLSSecret(pemCert, pemKey, ca, annotations); err != nil {
+		return errors.Wrapf(err, "failed to update secret [%s]", name)
+	}
+
+	if err := rc.pointToSecret(ctx, namespace, name, secret.Secret().Name); err != nil {
+		return err
+	}
+
+	logrus.Infof("Adopted certificate and key in secret [%s]", secret.Secret().Name)
+	return rc.recordIssued(ctx, audit.Adopt, namespace, name, pemCert, inputs)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+// signPair returns a certificate and key for the common name, signed by the test CA.
+func signPair(t *testing.T, commonName string) ([]byte, []byte) {
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+
+	key, pemKey, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	require.NoError(t, err)
+	pemCSR, err := security.CreateCSR(key, commonName, []string{"localhost"})
+	require.NoError(t, err)
+	req, err := security.ParseCSR(pemCSR)
+	require.NoError(t, err)
+	pemCert, err := security.SignCSR(req, caCert, caKey, time.Now(), time.Hour)
+	require.NoError(t, err)
+
+	return pemCert, pemKey
+}
+
+func TestAdoptOperatorSecrets(t *testing.T) {
+	ctx := context.TODO()
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	clientCert, clientKey := signPair(t, security.RootUser)
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb-ca", Namespace: "ns"},
+			Data:       map[string][]byte{resource.CaKey: []byte(testcerts.CAKey)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb-node", Namespace: "ns"},
+			Data: map[string][]byte{resource.CaCert: []byte(testcerts.CACert),
+				corev1.TLSCertKey: nodeCert, corev1.TLSPrivateKeyKey: nodeKey},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb-root", Namespace: "ns"},
+			Data: map[string][]byte{resource.CaCert: []byte(testcerts.CACert),
+				corev1.TLSCertKey: clientCert, corev1.TLSPrivateKeyKey: clientKey},
+		},
+	)
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb-cockroachdb"
+	rc.Persister = kube.DefaultPersister
+	rc.SkipPermissionCheck = true
+	require.NoError(t, rc.CaCertConfig.SetConfig("43800h", "648h"))
+	require.NoError(t, rc.NodeCertConfig.SetConfig("8760h", "168h"))
+	require.NoError(t, rc.ClientCertConfig.SetConfig("672h", "48h"))
+
+	certs, err := LoadSecretCerts(ctx, cl, "ns", "crdb-ca", "crdb-node", "crdb-root")
+	require.NoError(t, err)
+	require.NoError(t, rc.Adopt(ctx, "ns", certs, false))
+
+	for name, expected := range map[string]struct {
+		cert     []byte
+		duration time.Duration
+	}{
+		"crdb-cockroachdb-ca-secret":     {[]byte(testcerts.CACert), rc.CaCertConfig.Duration},
+		"crdb-cockroachdb-node-secret":   {nodeCert, rc.NodeCertConfig.Duration},
+		"crdb-cockroachdb-client-secret": {clientCert, rc.ClientCertConfig.Duration},
+	} {
+		secret, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister))
+		require.NoError(t, err, name)
+
+		// the adopted certificates are annotated with the configured duration, so they aren't rotated
+		// before they are due
+		assert.True(t, secret.ValidateAnnotations(), name)
+		assert.Equal(t, expected.duration.String(), secret.Secret().Annotations[resource.CertDuration], name)
+
+		if name == "crdb-cockroachdb-ca-secret" {
+			assert.True(t, secret.ReadyCA())
+			assert.Equal(t, expected.cert, secret.CA())
+		} else {
+			assert.True(t, secret.Ready(), name)
+			assert.Equal(t, expected.cert, secret.TLSCert(), name)
+		}
+	}
+
+	// adopted secrets are only replaced on request
+	assert.Error(t, rc.Adopt(ctx, "ns", certs, false))
+	assert.NoError(t, rc.Adopt(ctx, "ns", certs, true))
+}
+
+func TestAdoptInvalidCerts(t *testing.T) {
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	clientCert, clientKey := signPair(t, security.RootUser)
+	certs := AdoptedCerts{
+		CACert:     []byte(testcerts.CACert),
+		CAKey:      []byte(testcerts.CAKey),
+		NodeCert:   nodeCert,
+		NodeKey:    nodeKey,
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+	}
+	require.NoError(t, certs.Validate(security.RootUser, time.Now()))
+
+	swapped := certs
+	swapped.NodeKey = clientKey
+	assert.True(t, errors.Is(swapped.Validate(security.RootUser, time.Now()), resource.ErrInvalidSecret))
+
+	assert.Error(t, certs.Validate("admin", time.Now()))
+	assert.Error(t, certs.Validate(security.RootUser, time.Now().Add(2*time.Hour)))
+}
+
+func TestLoadDirCerts(t *testing.T) {
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	clientCert, clientKey := signPair(t, security.RootUser)
+
+	// the CA key is kept out of the certs directory
+	certsDir, safeDir := t.TempDir(), t.TempDir()
+	for name, data := range map[string][]byte{
+		"ca.crt":          []byte(testcerts.CACert),
+		"node.crt":        nodeCert,
+		"node.key":        nodeKey,
+		"client.root.crt": clientCert,
+		"client.root.key": clientKey,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(certsDir, name), data, security.KeyFileMode))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(safeDir, "ca.key"), []byte(testcerts.CAKey), security.KeyFileMode))
+
+	_, err := LoadDirCerts(certsDir, filepath.Join(certsDir, "ca.key"), security.RootUser)
+	assert.Error(t, err)
+
+	certs, err := LoadDirCerts(certsDir, filepath.Join(safeDir, "ca.key"), security.RootUser)
+	require.NoError(t, err)
+	assert.Equal(t, nodeCert, certs.NodeCert)
+	assert.Equal(t, clientKey, certs.ClientKey)
+	assert.NoError(t, certs.Validate(security.RootUser, time.Now()))
+}
+
+func TestLoadCertsSecret(t *testing.T) {
+	ctx := context.TODO()
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	clientCert, clientKey := signPair(t, security.RootUser)
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cockroachdb.node", Namespace: "ns"},
+		Data: map[string][]byte{
+			"ca.crt":          []byte(testcerts.CACert),
+			"node.crt":        nodeCert,
+			"node.key":        nodeKey,
+			"client.root.crt": clientCert,
+			"client.root.key": clientKey,
+		},
+	})
+
+	// the CA key is neither in the secret nor given
+	_, err := LoadCertsSecret(ctx, cl, "ns", "cockroachdb.node", "", security.RootUser)
+	assert.True(t, errors.Is(err, resource.ErrInvalidSecret))
+
+	caKey := filepath.Join(t.TempDir(), "ca.key")
+	require.NoError(t, ioutil.WriteFile(caKey, []byte(testcerts.CAKey), security.KeyFileMode))
+
+	certs, err := LoadCertsSecret(ctx, cl, "ns", "cockroachdb.node", caKey, security.RootUser)
+	require.NoError(t, err)
+	assert.NoError(t, certs.Validate(security.RootUser, time.Now()))
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/attestation"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// AttestationSigner is the common name of the certificate of the attestation key.
+const AttestationSigner = "cockroachdb-self-signer-attestation"
+
+// attestationKeyLifetime is the lifetime of the certificates of the attestation key. They never outlive the CA.
+const attestationKeyLifetime = 365 * 24 * time.Hour
+
+func (rc *GenerateCert) getAttestationKeySecretName() string {
+	if rc.AttestationKeySecretName != "" {
+		return rc.AttestationKeySecretName
+	}
+	return rc.DiscoveryServiceName + "-attestation-key-secret"
+}
+
+// attest adds an attestation of the certificate, signed by the attestation key, to the secret annotations, and
+// publishes the certificate to the AttestationRepository along with its attestation, if set. It is a no-op
+// unless Attest is set.
+func (rc *GenerateCert) attest(ctx context.Context, namespace, secretName string, pemCert []byte,
+	inputs map[string]string, annotations map[string]string) error {
+
+	if !rc.Attest {
+		return nil
+	}
+
+	caBundle, err := ioutil.ReadFile(filepath.Join(rc.CertsDir, resource.CaCert))
+	if err != nil {
+		return errors.Wrap(err, "unable to read ca.crt")
+	}
+
+	caFingerprint, err := issuerFingerprint(caBundle, pemCert)
+	if err != nil {
+		return err
+	}
+
+	r := resource.NewKubeResource(ctx, rc.client, namespace, rc.persister())
+	keyCert, key, err := resource.LoadSigningKey(rc.getAttestationKeySecretName(), r, caBundle, AttestationSigner, rc.now(),
+		func(key crypto.Signer) ([]byte, error) {
+			return rc.issueAttestationCert(caBundle, key)
+		})
+	if err != nil {
+		return err
+	}
+
+	statement, err := attestation.NewStatement(secretName, pemCert, caFingerprint, inputs)
+	if err != nil {
+		return err
+	}
+
+	var artifact *attestation.Artifact
+	tag := namespace + "." + secretName
+	if rc.AttestationRepository != nil {
+		if artifact, err = attestation.CertArtifact(secretName, pemCert); err != nil {
+			return err
+		}
+
+		// cosign only trusts the attestations whose statement has the digest of the artifact as a subject
+		statement.Subject = append(statement.Subject, attestation.Subject{
+			Name:   rc.AttestationRepository.Reference(tag),
+			Digest: map[string]string{"sha256": strings.TrimPrefix(artifact.Digest(), "sha256:")},
+		})
+	}
+
+	envelope, err := attestation.Sign(statement, key)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	annotations[resource.Attestation] = string(data)
+
+	if artifact == nil {
+		return nil
+	}
+
+	pemKeyCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: keyCert.Raw})
+	ref, err := rc.AttestationRepository.Publish(ctx, artifact, tag, envelope, pemKeyCert, caBundle)
+	if err != nil {
+		return errors.Wrapf(err, "failed to publish the attestation of secret [%s]", secretName)
+	}
+
+	annotations[resource.AttestationRef] = ref
+	logrus.Infof("Published the attestation of secret [%s] as %s", secretName, ref)
+	return nil
+}
+
+// issueAttestationCert issues the certificate of the attestation key with the CA key, so that the CA key
+// certifies the attestation key without signing any attestation itself.
+func (rc *GenerateCert) issueAttestationCert(caBundle []byte, key crypto.Signer) ([]byte, error) {
+	pemKey, err := ioutil.ReadFile(rc.CAKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read ca.key")
+	}
+
+	caCert, caKey, err := security.ParseCAPair(caBundle, pemKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return security.CreateSigningCert(caCert, caKey, key, AttestationSigner, rc.now(), attestationKeyLifetime)
+}
+
+// issuerFingerprint returns the fingerprint of the certificate of the CA bundle which issued the certificate,
+// or of the certificate itself if it is one of the CA certificates.
+func issuerFingerprint(caBundle, pemCert []byte) (string, error) {
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		return "", err
+	}
+
+	cas, err := security.ParseCerts(caBundle)
+	if err != nil {
+		return "", err
+	}
+
+	for _, ca := range cas {
+		if bytes.Equal(ca.Raw, cert.Raw) || cert.CheckSignatureFrom(ca) == nil {
+			return security.Fingerprint(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+		}
+	}
+	return "", errors.New("no CA certificate of the bundle issued the certificate")
+}
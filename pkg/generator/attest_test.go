@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/attestation"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestAttest(t *testing.T) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.Attest = true
+	rc.CertsDir = t.TempDir()
+	rc.CAKey = filepath.Join(rc.CertsDir, resource.CaKey)
+	require.NoError(t, ioutil.WriteFile(rc.CAKey, []byte(testcerts.CAKey), security.KeyFileMode))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), []byte(testcerts.CACert), security.CertFileMode))
+
+	attest := func() *attestation.Statement {
+		annotations := map[string]string{}
+		require.NoError(t, rc.attest(context.TODO(), "ns", "crdb-client-secret", []byte(testcerts.ClientCert),
+			map[string]string{"user": "root"}, annotations))
+
+		envelope := &attestation.Envelope{}
+		require.NoError(t, json.Unmarshal([]byte(annotations[resource.Attestation]), envelope))
+
+		// the attestation is signed by the attestation key, certified by the CA
+		keySecret, err := resource.LoadTLSSecret("crdb-attestation-key-secret",
+			resource.NewKubeResource(context.TODO(), cl, "ns", kube.DefaultPersister))
+		require.NoError(t, err)
+		keyCert, err := security.GetCertObj(keySecret.TLSCert())
+		require.NoError(t, err)
+		require.NoError(t, security.VerifySigningCert(keyCert, []byte(testcerts.CACert), AttestationSigner, time.Now()))
+
+		statement, err := attestation.Verify(envelope, keyCert.PublicKey)
+		require.NoError(t, err)
+		return statement
+	}
+
+	statement := attest()
+	fingerprint, err := security.Fingerprint([]byte(testcerts.CACert))
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, statement.Predicate.CAFingerprint)
+	assert.Equal(t, "crdb-client-secret", statement.Subject[0].Name)
+
+	// the attestation key is reused without the CA key
+	require.NoError(t, os.Remove(rc.CAKey))
+	attest()
+}
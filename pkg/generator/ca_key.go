@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+func (rc *GenerateCert) getCAKeySecretName() string {
+	if rc.CAKeySecretName != "" {
+		return rc.CAKeySecretName
+	}
+	return rc.DiscoveryServiceName + "-ca-key-secret"
+}
+
+// CAKeySecret returns the name of the secret holding the CA key along with the CA certificate. It is the CA
+// key secret with SplitCASecret, and the CA secret otherwise.
+func (rc *GenerateCert) CAKeySecret() string {
+	if rc.CaSecret != "" {
+		return rc.CaSecret
+	}
+	if rc.SplitCASecret {
+		return rc.getCAKeySecretName()
+	}
+	return rc.getCASecretName()
+}
+
+// publishCACert writes the CA certificate of the CA key secret to the CA secret, without the key, if
+// SplitCASecret is set and the CA secret doesn't hold it yet. The CA key secret is written first, so that
+// a failed run leaves the CA secret behind and the next run catches it up.
+func (rc *GenerateCert) publishCACert(ctx context.Context, namespace string, keySecret *resource.TLSSecret) error {
+	if !rc.SplitCASecret || rc.CaSecret != "" {
+		return nil
+	}
+
+	name := rc.getCASecretName()
+	r := resource.NewKubeResource(ctx, rc.client, namespace, rc.persister())
+	loaded, err := resource.LoadTLSSecret(name, r)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, "failed to get CA secret")
+	}
+
+	if bytes.Equal(loaded.CA(), keySecret.CA()) && len(loaded.CAKey()) == 0 {
+		return nil
+	}
+
+	annotations := map[string]string{}
+	for k, v := range keySecret.Secret().Annotations {
+		annotations[k] = v
+	}
+
+	secret := resource.CreateTLSSecret(name, corev1.SecretTypeOpaque, r)
+	secret.ExpectResourceVersion(loaded.Secret().ResourceVersion)
+	if err := secret.UpdateCACertSecret(keySecret.CA(), annotations); err != nil {
+		return errors.Wrap(err, "failed to update CA secret")
+	}
+
+	logrus.Infof("Saved CA certificate in secret [%s], the CA key is kept in secret [%s]", name, keySecret.Secret().Name)
+	return nil
+}
+
+// migrateCASecret moves the CA key between the CA secret and the CA key secret when SplitCASecret is
+// toggled. Splitting copies the combined CA secret to the CA key secret, then removes the key from the CA
+// secret. Merging copies the CA key secret back to the CA secret.
+func (rc *GenerateCert) migrateCASecret(ctx context.Context, namespace string) error {
+	if rc.CaSecret != "" {
+		return nil
+	}
+
+	r := resource.NewKubeResource(ctx, rc.client, namespace, rc.persister())
+	from, to := rc.getCASecretName(), rc.getCAKeySecretName()
+	if !rc.SplitCASecret {
+		from, to = to, from
+	}
+
+	source, err := resource.LoadTLSSecret(from, r)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrapf(err, "failed to get CA secret [%s]", from)
+	}
+	if !source.ReadyCA() {
+		return nil
+	}
+
+	target, err := resource.LoadTLSSecret(to, r)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrapf(err, "failed to get CA secret [%s]", to)
+	}
+	if target.ReadyCA() {
+		// a previous run already copied the CA, only the CA certificate secret may be behind
+		return rc.publishCACert(ctx, namespace, target)
+	}
+
+	annotations := map[string]string{}
+	for k, v := range source.Secret().Annotations {
+		annotations[k] = v
+	}
+
+	secret := resource.CreateTLSSecret(to, corev1.SecretTypeOpaque, r)
+	secret.ExpectResourceVersion(target.Secret().ResourceVersion)
+	if err := secret.UpdateCASecret(source.CAKey(), source.CA(), annotations); err != nil {
+		return errors.Wrapf(err, "failed to copy CA secret [%s] to [%s]", from, to)
+	}
+	logrus.Infof("Moved CA key from secret [%s] to secret [%s]", from, to)
+
+	return rc.publishCACert(ctx, namespace, secret)
+}
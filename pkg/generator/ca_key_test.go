@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestMigrateCASecret(t *testing.T) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "crdb-ca-secret",
+			Namespace:   "ns",
+			Annotations: map[string]string{resource.CertDuration: "43800h0m0s"},
+		},
+		Data: map[string][]byte{
+			resource.CaCert: []byte(testcerts.CACert),
+			resource.CaKey:  []byte(testcerts.CAKey),
+		},
+	})
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.SplitCASecret = true
+	assert.Equal(t, "crdb-ca-key-secret", rc.CAKeySecret())
+
+	get := func(name string) corev1.Secret {
+		var secret corev1.Secret
+		require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: name}, &secret))
+		return secret
+	}
+
+	// splitting moves the key to the CA key secret
+	require.NoError(t, rc.migrateCASecret(context.TODO(), "ns"))
+	keySecret := get("crdb-ca-key-secret")
+	assert.Equal(t, testcerts.CAKey, string(keySecret.Data[resource.CaKey]))
+	assert.Equal(t, testcerts.CACert, string(keySecret.Data[resource.CaCert]))
+	assert.Equal(t, "43800h0m0s", keySecret.Annotations[resource.CertDuration])
+
+	caSecret := get("crdb-ca-secret")
+	assert.Equal(t, map[string][]byte{resource.CaCert: []byte(testcerts.CACert)}, caSecret.Data)
+
+	// a second run has nothing to move
+	require.NoError(t, rc.migrateCASecret(context.TODO(), "ns"))
+	assert.Equal(t, caSecret.ResourceVersion, get("crdb-ca-secret").ResourceVersion)
+
+	// merging copies the key back to the CA secret
+	rc.SplitCASecret = false
+	require.NoError(t, rc.migrateCASecret(context.TODO(), "ns"))
+	caSecret = get("crdb-ca-secret")
+	assert.Equal(t, testcerts.CAKey, string(caSecret.Data[resource.CaKey]))
+	assert.Equal(t, "crdb-ca-secret", rc.CAKeySecret())
+}
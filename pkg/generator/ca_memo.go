@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"sync"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// CAMemo holds the CA secrets loaded by the runs of a process, so that the runs of a controller don't read,
+// validate and parse the CA secret again for every certificate they issue while it is unchanged. A memoized
+// secret is only used while the secret is still at the resource version it was loaded at, which is checked
+// by reading the metadata of the secret alone.
+//
+// The data of the secrets is held encrypted with a key generated for the process, so that the CA keys aren't
+// left in plain text in the memory of a long running controller.
+type CAMemo struct {
+	aead cipher.AEAD
+
+	mu      sync.Mutex
+	entries map[types.NamespacedName]caMemoEntry
+}
+
+type caMemoEntry struct {
+	version    string
+	secretType corev1.SecretType
+	nonce      []byte
+	sealed     []byte
+}
+
+// NewCAMemo returns an empty CAMemo with a new encryption key.
+func NewCAMemo() (*CAMemo, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate the CA memo key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the CA memo cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the CA memo cipher")
+	}
+
+	return &CAMemo{aead: aead, entries: map[types.NamespacedName]caMemoEntry{}}, nil
+}
+
+// additionalData binds the sealed data to the secret and version it was read from.
+func additionalData(key types.NamespacedName, version string) []byte {
+	return []byte(key.String() + "@" + version)
+}
+
+// get returns the data and type of the secret memoized at the version, if any.
+func (m *CAMemo) get(key types.NamespacedName, version string) (map[string][]byte, corev1.SecretType, bool) {
+	m.mu.Lock()
+	entry, ok := m.entries[key]
+	m.mu.Unlock()
+	if !ok || entry.version != version {
+		return nil, "", false
+	}
+
+	plain, err := m.aead.Open(nil, entry.nonce, entry.sealed, additionalData(key, version))
+	if err != nil {
+		return nil, "", false
+	}
+
+	var data map[string][]byte
+	if err := json.Unmarshal(plain, &data); err != nil {
+		return nil, "", false
+	}
+	return data, entry.secretType, true
+}
+
+// put memoizes the secret at its resource version, replacing the version memoized before.
+func (m *CAMemo) put(secret *corev1.Secret) {
+	key := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	if secret.ResourceVersion == "" {
+		m.forget(key)
+		return
+	}
+
+	plain, err := json.Marshal(secret.Data)
+	if err != nil {
+		m.forget(key)
+		return
+	}
+
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		m.forget(key)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = caMemoEntry{
+		version:    secret.ResourceVersion,
+		secretType: secret.Type,
+		nonce:      nonce,
+		sealed:     m.aead.Seal(nil, nonce, plain, additionalData(key, secret.ResourceVersion)),
+	}
+}
+
+// forget drops the memoized secret.
+func (m *CAMemo) forget(key types.NamespacedName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+// loadCASecret loads the CA secret, from the CAMemo if it is memoized at the current version of the secret, in
+// which case it was found ready when memoized.
+func (rc *GenerateCert) loadCASecret(ctx context.Context, namespace, name string) (*resource.TLSSecret, bool, error) {
+	r := resource.NewKubeResource(ctx, rc.client, namespace, rc.persister())
+	if rc.CAMemo == nil {
+		secret, err := resource.LoadTLSSecret(name, r)
+		return secret, false, err
+	}
+
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	meta := &metav1.PartialObjectMetadata{}
+	meta.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+	if err := rc.client.Get(ctx, key, meta); err == nil {
+		if data, secretType, ok := rc.CAMemo.get(key, meta.ResourceVersion); ok {
+			caMemoReads.WithLabelValues("hit").Inc()
+			secret := &corev1.Secret{ObjectMeta: meta.ObjectMeta, Type: secretType, Data: data}
+			return resource.NewTLSSecret(secret, r), true, nil
+		}
+	}
+
+	caMemoReads.WithLabelValues("miss").Inc()
+	rc.CAMemo.forget(key)
+	secret, err := resource.LoadTLSSecret(name, r)
+	return secret, false, err
+}
+
+// memoizeCA memoizes the CA secret found ready, if the CAMemo is enabled.
+func (rc *GenerateCert) memoizeCA(secret *resource.TLSSecret) {
+	if rc.CAMemo != nil {
+		rc.CAMemo.put(secret.Secret())
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+// secretGetsClient counts the gets of whole secrets.
+type secretGetsClient struct {
+	client.Client
+	gets int
+}
+
+func (c *secretGetsClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if _, ok := obj.(*corev1.Secret); ok {
+		c.gets++
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestCAMemo(t *testing.T) {
+	ctx := context.TODO()
+	cl := &secretGetsClient{Client: testutils.NewFakeClient(testutils.InitScheme(t), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-ca-secret", Namespace: "ns"},
+		Data: map[string][]byte{
+			resource.CaCert: []byte(testcerts.CACert),
+			resource.CaKey:  []byte(testcerts.CAKey),
+		},
+	})}
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+
+	memo, err := NewCAMemo()
+	require.NoError(t, err)
+	rc.CAMemo = memo
+
+	// the secret is read until it's memoized
+	secret, memoized, err := rc.loadCASecret(ctx, "ns", "crdb-ca-secret")
+	require.NoError(t, err)
+	assert.False(t, memoized)
+	assert.Equal(t, 1, cl.gets)
+	rc.memoizeCA(secret)
+
+	// the key is held encrypted
+	key := types.NamespacedName{Namespace: "ns", Name: "crdb-ca-secret"}
+	assert.False(t, bytes.Contains(memo.entries[key].sealed, []byte(testcerts.CAKey)))
+
+	// the memoized secret is used while unchanged
+	secret, memoized, err = rc.loadCASecret(ctx, "ns", "crdb-ca-secret")
+	require.NoError(t, err)
+	assert.True(t, memoized)
+	assert.Equal(t, 1, cl.gets)
+	assert.Equal(t, testcerts.CAKey, string(secret.CAKey()))
+	assert.Equal(t, testcerts.CACert, string(secret.CA()))
+
+	// a change of the secret invalidates it
+	var changed corev1.Secret
+	require.NoError(t, cl.Get(ctx, key, &changed))
+	changed.Data[resource.CaCert] = []byte("changed")
+	require.NoError(t, cl.Update(ctx, &changed))
+
+	secret, memoized, err = rc.loadCASecret(ctx, "ns", "crdb-ca-secret")
+	require.NoError(t, err)
+	assert.False(t, memoized)
+	assert.Equal(t, "changed", string(secret.CA()))
+	assert.NotContains(t, memo.entries, key)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/health"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// canaryRollout is a rollout of the node secret to the pod with the highest ordinal of the statefulset only.
+type canaryRollout struct {
+	pod string
+	// partition is the partition of the rolling update of the statefulset before the rollout
+	partition int32
+}
+
+// startCanary sets the partition of the rolling update of the statefulset to its last pod, so that only that
+// pod is updated once the node secret volume is replaced. It returns nil if the statefulset isn't updated
+// with the RollingUpdate strategy, in which case the secret is rolled out to all the pods at once.
+func (rc *GenerateCert) startCanary(ctx context.Context, namespace string) (*canaryRollout, error) {
+	replicas, err := rc.statefulSetReplicas(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	last := int32(replicas - 1)
+	partition, ok, err := kube.SetPartition(ctx, rc.client, rc.DiscoveryServiceName, namespace, last)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set the partition of statefulset [%s]", rc.DiscoveryServiceName)
+	}
+	if !ok {
+		logrus.Warnf("Statefulset [%s] isn't updated with the RollingUpdate strategy, rolling out without canary", rc.DiscoveryServiceName)
+		return nil, nil
+	}
+
+	return &canaryRollout{pod: fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, last), partition: partition}, nil
+}
+
+// finishCanary waits for the canary pod to serve the certificate of the node secret current, then restores
+// the partition so that the statefulset controller updates the other pods. If the canary fails, the
+// statefulset volumes and the secret versions are pointed back to previous before restoring the partition,
+// which rolls the canary back.
+func (rc *GenerateCert) finishCanary(ctx context.Context, namespace, name, previous string, current *resource.TLSSecret,
+	canary *canaryRollout) error {
+
+	logrus.Infof("Rolling secret [%s] out to canary pod [%s]", current.Secret().Name, canary.pod)
+	if err := rc.verifyCanary(ctx, namespace, canary.pod, current); err != nil {
+		logrus.Errorf("Canary pod [%s] failed, restoring secret [%s]: %s", canary.pod, previous, err)
+
+		if _, restoreErr := kube.ReplaceSecretVolume(ctx, rc.client, rc.DiscoveryServiceName, namespace,
+			current.Secret().Name, previous); restoreErr != nil {
+			return errors.Wrap(restoreErr, "failed to restore the node secret volume of the statefulset")
+		}
+		if restoreErr := rc.pointToSecret(ctx, namespace, name, previous); restoreErr != nil {
+			return restoreErr
+		}
+		if restoreErr := rc.restorePartition(ctx, namespace, canary); restoreErr != nil {
+			return restoreErr
+		}
+
+		return errors.Wrapf(err, "canary pod [%s] failed, secret [%s] was restored", canary.pod, previous)
+	}
+
+	logrus.Infof("Canary pod [%s] serves the rotated certificate, rolling it out to all the pods", canary.pod)
+	return rc.restorePartition(ctx, namespace, canary)
+}
+
+// verifyCanary waits for the canary pod to be updated and ready, then checks that it presents the
+// certificate of the node secret on its RPC port, verified with the CA of the secret.
+func (rc *GenerateCert) verifyCanary(ctx context.Context, namespace, pod string, current *resource.TLSSecret) error {
+	if err := kube.WaitForPodUpdated(ctx, rc.client, rc.DiscoveryServiceName, pod, namespace, rc.PodUpdateTimeout,
+		5*time.Second); err != nil {
+		return err
+	}
+
+	port := rc.SQLPort
+	if port == 0 {
+		port = defaultSQLPort
+	}
+
+	host := rc.podFQDN(namespace, pod)
+	_, err := health.VerifyTLS(ctx, fmt.Sprintf("%s:%d", host, port), host, current.CA(), current.TLSCert())
+	return err
+}
+
+// restorePartition sets the partition of the statefulset back to its value before the canary rollout.
+func (rc *GenerateCert) restorePartition(ctx context.Context, namespace string, canary *canaryRollout) error {
+	_, _, err := kube.SetPartition(ctx, rc.client, rc.DiscoveryServiceName, namespace, canary.partition)
+	return errors.Wrapf(err, "failed to restore the partition of statefulset [%s]", rc.DiscoveryServiceName)
+}
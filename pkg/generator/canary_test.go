@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestCanaryRollback(t *testing.T) {
+	ctx := context.TODO()
+	replicas := int32(2)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{
+						Name: "certs",
+						VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{SecretName: "crdb-node-secret"},
+						},
+					}},
+				},
+			},
+		},
+		Status: appsv1.StatefulSetStatus{UpdateRevision: "crdb-2"},
+	}
+	// the canary pod is updated, but the cluster can't be reached
+	canary := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-1", Namespace: "ns",
+			Labels: map[string]string{appsv1.ControllerRevisionHashLabelKey: "crdb-2"}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), sts, canary)
+	r := resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister)
+
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	current := resource.CreateTLSSecret("crdb-node-secret-v2", corev1.SecretTypeTLS, r)
+	require.NoError(t, current.UpdateTLSSecret(nodeCert, nodeKey, []byte(testcerts.CACert), map[string]string{}))
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.ClusterDomain = "invalid"
+	rc.Persister = kube.DefaultPersister
+	rc.RotationStrategy = VersionedRotation
+	rc.CanaryRotation = true
+	rc.PodUpdateTimeout = time.Second
+	require.NoError(t, rc.pointToSecret(ctx, "ns", "crdb-node-secret", "crdb-node-secret-v2"))
+
+	err := rc.rolloutNodeSecret(ctx, "ns", "crdb-node-secret", current)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "canary pod [crdb-1] failed")
+
+	// the previous secret is mounted and current again, and the partition is restored
+	var updated appsv1.StatefulSet
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb"}, &updated))
+	assert.Equal(t, "crdb-node-secret", updated.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+	assert.Equal(t, int32(0), *updated.Spec.UpdateStrategy.RollingUpdate.Partition)
+
+	currentName, err := rc.currentSecretName(ctx, "ns", "crdb-node-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "crdb-node-secret", currentName)
+}
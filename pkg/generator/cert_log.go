@@ -0,0 +1,87 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// appendCertLog records the certificate issued to the secret in the certificate log of the namespace, if
+// CertLogName is set.
+func (rc *GenerateCert) appendCertLog(ctx context.Context, operation audit.Operation, namespace, secretName string,
+	cert *x509.Certificate) error {
+
+	if rc.CertLogName == "" {
+		return nil
+	}
+
+	certLog, err := resource.LoadCertLog(rc.CertLogName, rc.CertLogSegmentSize,
+		resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get certificate log [%s]", rc.CertLogName)
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	_, err = certLog.Append(resource.CertLogEntry{
+		Time:        rc.now().UTC(),
+		Operation:   string(operation),
+		Secret:      secretName,
+		SHA256:      hex.EncodeToString(sum[:]),
+		Serial:      resource.SerialKey(cert.SerialNumber),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		DNSNames:    cert.DNSNames,
+		IPAddresses: ips,
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+	})
+	return errors.Wrapf(err, "failed to record the certificate of secret [%s] in the certificate log", secretName)
+}
+
+// CertLog returns the entries of the certificate log of the namespace. The entries are returned along with
+// an error wrapping resource.ErrCertLogTampered if they don't form an unbroken hash chain.
+func (rc *GenerateCert) CertLog(ctx context.Context, namespace string) ([]resource.CertLogEntry, error) {
+	if rc.CertLogName == "" {
+		return nil, errors.New("the certificate log is only kept with --cert-log-configmap")
+	}
+
+	certLog, err := resource.LoadCertLog(rc.CertLogName, rc.CertLogSegmentSize,
+		resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get certificate log [%s]", rc.CertLogName)
+	}
+
+	entries, err := certLog.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, errors.Wrapf(resource.VerifyCertLog(entries), "certificate log [%s]", rc.CertLogName)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestCertLog(t *testing.T) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.CaCertConfig.Duration = 43800 * time.Hour
+	rc.NodeCertConfig.Duration = 8760 * time.Hour
+	rc.NodeAndClientCronSchedule = "0 0 1 */1 *"
+	rc.SQLProxyHosts = []string{"sql.example.com"}
+	rc.CertLogName = "crdb-cert-log"
+	rc.RotateNodeCert = true
+
+	rc.CertsDir = t.TempDir()
+	rc.CAKey = filepath.Join(t.TempDir(), "ca.key")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), []byte(testcerts.CACert), security.CertFileMode))
+	require.NoError(t, ioutil.WriteFile(rc.CAKey, []byte(testcerts.CAKey), security.KeyFileMode))
+
+	_, err := rc.CertLog(context.TODO(), "ns")
+	require.NoError(t, err)
+
+	require.NoError(t, rc.generateTenantCerts(context.TODO(), "ns"))
+
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-sqlproxy-secret"}, &secret))
+	cert, err := security.GetCertObj(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+
+	// the entry outlives the content of the secret
+	require.NoError(t, cl.Delete(context.TODO(), &secret))
+
+	entries, err := rc.CertLog(context.TODO(), "ns")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	sum := sha256.Sum256(cert.Raw)
+	assert.Equal(t, "crdb-sqlproxy-secret", entries[0].Secret)
+	assert.Equal(t, hex.EncodeToString(sum[:]), entries[0].SHA256)
+	assert.Equal(t, resource.SerialKey(cert.SerialNumber), entries[0].Serial)
+	assert.Equal(t, cert.DNSNames, entries[0].DNSNames)
+	assert.Equal(t, cert.NotAfter.UTC(), entries[0].NotAfter)
+}
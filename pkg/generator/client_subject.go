@@ -0,0 +1,159 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// ClientSubject templates the common name and organizational units of the client certificates, for the auth
+// integrations keying off the organizational unit rather than the common name. The templates are executed
+// with the User and Namespace of the certificate and the Attributes of the user, e.g. {{.Team}}.
+type ClientSubject struct {
+	CommonName          *template.Template
+	OrganizationalUnits []*template.Template
+	// Attributes are the values of the templates by user, e.g. {"app": {"Team": "payments"}}.
+	Attributes map[string]map[string]string
+}
+
+// NewClientSubject parses the templates of the common name and organizational units. An empty common name
+// keeps the user as the common name.
+func NewClientSubject(commonName string, organizationalUnits []string,
+	attributes map[string]map[string]string) (*ClientSubject, error) {
+
+	s := &ClientSubject{Attributes: attributes}
+
+	var err error
+	if commonName != "" {
+		if s.CommonName, err = parseSubjectTemplate("commonName", commonName); err != nil {
+			return nil, err
+		}
+	}
+	for _, ou := range organizationalUnits {
+		t, err := parseSubjectTemplate("organizationalUnit", ou)
+		if err != nil {
+			return nil, err
+		}
+		s.OrganizationalUnits = append(s.OrganizationalUnits, t)
+	}
+
+	return s, nil
+}
+
+func parseSubjectTemplate(name, text string) (*template.Template, error) {
+	// a missing attribute of a user fails instead of being rendered as "<no value>"
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s template of the client certificates", name)
+	}
+	return t, nil
+}
+
+// Principal returns the principal of the client certificate of the user in the namespace.
+func (s *ClientSubject) Principal(namespace, user string) (security.ClientPrincipal, error) {
+	data := map[string]string{}
+	for k, v := range s.Attributes[user] {
+		data[k] = v
+	}
+	data["User"] = user
+	data["Namespace"] = namespace
+
+	var principal security.ClientPrincipal
+	var err error
+	if s.CommonName != nil {
+		if principal.CommonName, err = executeSubjectTemplate(s.CommonName, user, data); err != nil {
+			return principal, err
+		}
+	}
+	for _, t := range s.OrganizationalUnits {
+		ou, err := executeSubjectTemplate(t, user, data)
+		if err != nil {
+			return principal, err
+		}
+		principal.OrganizationalUnits = append(principal.OrganizationalUnits, ou)
+	}
+
+	return principal, nil
+}
+
+func executeSubjectTemplate(t *template.Template, user string, data map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "failed to render the %s of the client certificate of user %s", t.Name(), user)
+	}
+	if buf.Len() == 0 {
+		return "", errors.Errorf("the %s of the client certificate of user %s is empty", t.Name(), user)
+	}
+	return buf.String(), nil
+}
+
+// clientPrincipal returns the principal of the client certificate of the user, from the ClientSubject and the
+// ClientPrincipals of the user, and whether the certificate doesn't identify the user by its common name
+// alone. The principal can't impersonate the tenants, the nodes or the other users.
+func (rc *GenerateCert) clientPrincipal(namespace, user string) (security.ClientPrincipal, bool, error) {
+	principal, mapped := rc.ClientPrincipals[user]
+	if rc.ClientSubject != nil {
+		templated, err := rc.ClientSubject.Principal(namespace, user)
+		if err != nil {
+			return principal, false, err
+		}
+		// the principal set for the user takes precedence over the template
+		if principal.CommonName != "" {
+			templated.CommonName = principal.CommonName
+		}
+		templated.DNSNames = principal.DNSNames
+		principal, mapped = templated, true
+	}
+
+	for _, ou := range principal.OrganizationalUnits {
+		if ou == security.TenantsOU {
+			return principal, false, errors.Errorf("the client certificate of user %s can't be in the %s unit, "+
+				"which identifies the SQL tenants", user, security.TenantsOU)
+		}
+	}
+	if principal.CommonName != "" && principal.CommonName != user {
+		for _, other := range append([]string{security.RootUser, security.NodeUser}, rc.ClientUsers...) {
+			if principal.CommonName == other {
+				return principal, false, errors.Errorf("the client certificate of user %s can't have the "+
+					"common name of user %s", user, other)
+			}
+		}
+	}
+
+	return principal, mapped, nil
+}
+
+// MappedPrincipals returns the principals of the client certificates of the root user and the ClientUsers
+// which don't only identify the user by its common name, for the signer to allow them.
+func (rc *GenerateCert) MappedPrincipals(namespace string) ([]security.ClientPrincipal, error) {
+	var principals []security.ClientPrincipal
+	for _, user := range append([]string{security.RootUser}, rc.ClientUsers...) {
+		principal, mapped, err := rc.clientPrincipal(namespace, user)
+		if err != nil {
+			return nil, err
+		}
+		if mapped && principal.CommonName != "" {
+			principals = append(principals, principal)
+		}
+	}
+	return principals, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestClientSubject(t *testing.T) {
+	_, err := NewClientSubject("{{.User", nil, nil)
+	assert.Error(t, err)
+
+	subject, err := NewClientSubject("{{.User}}.{{.Namespace}}", []string{"{{.Team}}", "crdb"},
+		map[string]map[string]string{"app": {"Team": "payments"}})
+	require.NoError(t, err)
+
+	rc := NewGenerateCert(nil)
+	rc.ClientUsers = []string{"app", "reporting"}
+	rc.ClientSubject = subject
+	rc.ClientPrincipals = map[string]security.ClientPrincipal{
+		"root": {CommonName: "dba@corp.example.com", DNSNames: []string{"dba.corp.example.com"}},
+	}
+
+	principal, mapped, err := rc.clientPrincipal("ns", "app")
+	require.NoError(t, err)
+	assert.True(t, mapped)
+	assert.Equal(t, security.ClientPrincipal{
+		CommonName:          "app.ns",
+		OrganizationalUnits: []string{"payments", "crdb"},
+	}, principal)
+
+	// the attributes used by the templates are required
+	_, _, err = rc.clientPrincipal("ns", "root")
+	assert.EqualError(t, err, `failed to render the organizationalUnit of the client certificate of user root: `+
+		`template: organizationalUnit:1:2: executing "organizationalUnit" at <.Team>: map has no entry for key "Team"`)
+
+	// the principal of the user overrides the templated common name
+	subject.Attributes["root"] = map[string]string{"Team": "dba"}
+	principal, _, err = rc.clientPrincipal("ns", "root")
+	require.NoError(t, err)
+	assert.Equal(t, security.ClientPrincipal{
+		CommonName:          "dba@corp.example.com",
+		DNSNames:            []string{"dba.corp.example.com"},
+		OrganizationalUnits: []string{"dba", "crdb"},
+	}, principal)
+
+	_, err = rc.MappedPrincipals("ns")
+	assert.Error(t, err, "reporting has no team")
+	subject.Attributes["reporting"] = map[string]string{"Team": "analytics"}
+	principals, err := rc.MappedPrincipals("ns")
+	require.NoError(t, err)
+	assert.Len(t, principals, 3)
+
+	// the certificates can't impersonate the tenants, nodes or other users
+	rc.ClientSubject, err = NewClientSubject("", []string{security.TenantsOU}, nil)
+	require.NoError(t, err)
+	_, _, err = rc.clientPrincipal("ns", "app")
+	assert.Error(t, err)
+
+	for _, cn := range []string{"root", "node", "reporting"} {
+		rc.ClientSubject, err = NewClientSubject(cn, nil, nil)
+		require.NoError(t, err)
+		_, _, err = rc.clientPrincipal("ns", "app")
+		assert.Error(t, err, cn)
+	}
+
+	// without templates, only the users with a principal are mapped
+	rc.ClientSubject = nil
+	principal, mapped, err = rc.clientPrincipal("ns", "app")
+	require.NoError(t, err)
+	assert.False(t, mapped)
+	assert.Equal(t, security.ClientPrincipal{}, principal)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// CompletionName returns the name of the ConfigMap reporting the state of the runs generating the
+// certificates of the statefulset.
+func CompletionName(stsName string) string {
+	return stsName + "-certs-completion"
+}
+
+// reportCompletion writes the state of the run to the completion ConfigMap, if enabled with ReportCompletion.
+func (rc *GenerateCert) reportCompletion(ctx context.Context, namespace, state, message string) error {
+	if !rc.ReportCompletion {
+		return nil
+	}
+
+	name := CompletionName(rc.DiscoveryServiceName)
+	completion, err := resource.LoadCompletion(name, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get completion [%s]", name)
+	}
+
+	if err := completion.Set(state, rc.ReleaseRevision, message, rc.now()); err != nil {
+		return errors.Wrapf(err, "failed to report the %s state in completion [%s]", state, name)
+	}
+
+	return nil
+}
+
+// completeRun reports the result of the run once it returns with *err. The run fails if its completion
+// can't be reported, as the pods waiting for it would never start.
+func (rc *GenerateCert) completeRun(ctx context.Context, namespace string, err *error) {
+	if *err == nil {
+		*err = rc.reportCompletion(ctx, namespace, resource.CompletionComplete, "")
+		return
+	}
+
+	if reportErr := rc.reportCompletion(ctx, namespace, resource.CompletionFailed, (*err).Error()); reportErr != nil {
+		logrus.Warnf("Failed to report the failed run: %s", reportErr)
+	}
+}
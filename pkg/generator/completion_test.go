@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestCompleteRun(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.DiscoveryServiceName = "crdb"
+	rc.Clock = clock.NewFake(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	rc.ReleaseRevision = "4"
+
+	completion := func() *resource.Completion {
+		c, err := resource.LoadCompletion(CompletionName("crdb"), resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister))
+		require.NoError(t, err)
+		return c
+	}
+
+	// nothing is reported unless enabled
+	var err error
+	rc.completeRun(ctx, "ns", &err)
+	require.NoError(t, err)
+	assert.Equal(t, "", completion().State())
+
+	rc.ReportCompletion = true
+	require.NoError(t, rc.reportCompletion(ctx, "ns", resource.CompletionPending, ""))
+	assert.Equal(t, resource.CompletionPending, completion().State())
+
+	err = errors.New("error Generating CA")
+	rc.completeRun(ctx, "ns", &err)
+	assert.EqualError(t, err, "error Generating CA")
+	assert.Equal(t, resource.CompletionFailed, completion().State())
+	assert.Equal(t, "error Generating CA", completion().Message())
+
+	err = nil
+	rc.completeRun(ctx, "ns", &err)
+	require.NoError(t, err)
+	assert.NoError(t, completion().Complete("4"))
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/config"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// ApplyConfig overrides the generator settings with the values set in the config file.
+// Fields left empty in the config keep their current value.
+func (rc *GenerateCert) ApplyConfig(cfg *config.Config) error {
+	if err := applyCertConfig(rc.CaCertConfig, cfg.CA.CertConfig); err != nil {
+		return err
+	}
+	if err := applyCertConfig(rc.NodeCertConfig, cfg.Node.CertConfig); err != nil {
+		return err
+	}
+	if err := applyCertConfig(rc.ClientCertConfig, cfg.Client.CertConfig); err != nil {
+		return err
+	}
+
+	if cfg.CA.ProvidedSecret != "" {
+		rc.CaSecret = cfg.CA.ProvidedSecret
+	}
+	if cfg.CA.Secret != "" {
+		rc.CASecretName = cfg.CA.Secret
+	}
+	if cfg.CA.SplitKey {
+		rc.SplitCASecret = true
+	}
+	if cfg.CA.KeySecret != "" {
+		rc.CAKeySecretName = cfg.CA.KeySecret
+	}
+	if cfg.Node.Secret != "" {
+		rc.NodeSecretName = cfg.Node.Secret
+	}
+	if cfg.Client.Secret != "" {
+		rc.ClientSecretName = cfg.Client.Secret
+	}
+	if cfg.Client.KeyAlgorithm != "" {
+		rc.ClientKeyAlgorithm = cfg.Client.KeyAlgorithm
+	}
+
+	if cfg.Node.PerPodSANReplicas != 0 {
+		rc.PerPodSANReplicas = cfg.Node.PerPodSANReplicas
+	}
+	if cfg.Node.PerNode {
+		rc.PerNodeCerts = true
+	}
+
+	var err error
+	if rc.NodeProfile, err = newProfile(rc.NodeProfile, cfg.Node.ProfileConfig); err != nil {
+		return err
+	}
+	if rc.ClientProfile, err = newProfile(rc.ClientProfile, cfg.Client.ProfileConfig); err != nil {
+		return err
+	}
+
+	if cfg.UI != nil {
+		if err := applyCertConfig(rc.UICertConfig, cfg.UI.CertConfig); err != nil {
+			return err
+		}
+		if rc.UIProfile, err = newProfile(rc.UIProfile, cfg.UI.ProfileConfig); err != nil {
+			return err
+		}
+		if cfg.UI.Secret != "" {
+			rc.UISecretName = cfg.UI.Secret
+		}
+		rc.UICert = true
+		rc.UIHosts = append(rc.UIHosts, cfg.UI.Hosts...)
+	}
+
+	if cfg.Ingress != nil {
+		if cfg.Ingress.Secret != "" {
+			rc.IngressSecretName = cfg.Ingress.Secret
+		}
+		if cfg.Ingress.CASecret != "" {
+			rc.IngressCASecret = cfg.Ingress.CASecret
+		}
+		rc.IngressHosts = append(rc.IngressHosts, cfg.Ingress.Hosts...)
+	}
+
+	for _, t := range cfg.Tenants {
+		rc.Tenants = append(rc.Tenants, Tenant{ID: t.ID, Hosts: t.Hosts, SecretName: t.Secret})
+	}
+
+	if cfg.SQLProxy != nil {
+		if cfg.SQLProxy.Secret != "" {
+			rc.SQLProxySecretName = cfg.SQLProxy.Secret
+		}
+		rc.SQLProxyHosts = append(rc.SQLProxyHosts, cfg.SQLProxy.Hosts...)
+	}
+
+	if cfg.RootPassword != nil {
+		rc.RootPassword = true
+		if cfg.RootPassword.Secret != "" {
+			rc.RootPasswordSecretName = cfg.RootPassword.Secret
+		}
+	}
+
+	rc.NodeSANs = append(rc.NodeSANs, cfg.Node.SANs...)
+	rc.ClientUsers = append(rc.ClientUsers, cfg.Client.Users...)
+
+	for _, p := range cfg.Client.Principals {
+		if rc.ClientPrincipals == nil {
+			rc.ClientPrincipals = map[string]security.ClientPrincipal{}
+		}
+		rc.ClientPrincipals[p.User] = security.ClientPrincipal{CommonName: p.CommonName, DNSNames: p.DNSNames}
+	}
+
+	if s := cfg.Client.Subject; s != nil {
+		if rc.ClientSubject, err = NewClientSubject(s.CommonName, s.OrganizationalUnits, s.Attributes); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range cfg.Client.Grants {
+		// the privileges are written to the GRANT statements unquoted
+		if err := config.ValidatePrivileges(g.Privileges); err != nil {
+			return errors.Wrapf(err, "grants of user %s", g.User)
+		}
+		rc.Grants = append(rc.Grants, security.Grant{
+			User:       g.User,
+			Database:   g.Database,
+			Privileges: g.Privileges,
+			Roles:      g.Roles,
+		})
+	}
+
+	return nil
+}
+
+func applyCertConfig(c *CertConfig, cfg config.CertConfig) error {
+	duration, expiryWindow := c.Duration.String(), c.ExpiryWindow.String()
+	if cfg.Duration != "" {
+		duration = cfg.Duration
+	}
+	if cfg.ExpiryWindow != "" {
+		expiryWindow = cfg.ExpiryWindow
+	}
+
+	return c.SetConfig(duration, expiryWindow)
+}
+
+// newProfile returns the profile of the config, or the current profile if the config doesn't set any.
+func newProfile(current *security.Profile, cfg config.ProfileConfig) (*security.Profile, error) {
+	spec := security.ProfileSpec{
+		KeyUsage:    cfg.KeyUsage,
+		ExtKeyUsage: cfg.ExtKeyUsage,
+		Policies:    cfg.Policies,
+	}
+	for _, ext := range cfg.Extensions {
+		spec.Extensions = append(spec.Extensions, security.ExtensionSpec{OID: ext.OID, Critical: ext.Critical, Value: ext.Value})
+	}
+
+	profile, err := security.NewProfile(spec)
+	if err != nil || profile == nil {
+		return current, err
+	}
+	return profile, nil
+}
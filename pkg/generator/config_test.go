@@ -0,0 +1,43 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/config"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestApplyConfigGrants(t *testing.T) {
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t)))
+
+	cfg := &config.Config{}
+	cfg.Client.Grants = []config.GrantConfig{{User: "app", Database: "app_db", Privileges: []string{"SELECT"}}}
+	require.NoError(t, rc.ApplyConfig(cfg))
+	assert.Equal(t, []security.Grant{{User: "app", Database: "app_db", Privileges: []string{"SELECT"}}}, rc.Grants)
+
+	// configs built without Parse are checked as well, as the privileges are written to the SQL unquoted
+	rc = NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t)))
+	cfg.Client.Grants[0].Privileges = []string{"ALL ON DATABASE x TO y; DROP DATABASE app_db"}
+	assert.Error(t, rc.ApplyConfig(cfg))
+	assert.Empty(t, rc.Grants)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"net"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// missingSANs returns true if the certificate of the secret isn't valid for all the hosts, e.g. once names
+// were added to the config, so that it is reissued for them. The names removed from the config are only
+// dropped when the certificate is next rotated.
+func missingSANs(secret *resource.TLSSecret, hosts []string) (bool, string) {
+	cert, err := security.GetCertObj(secret.TLSCert())
+	if err != nil {
+		return true, "Failed to parse certificate, rotating certificate"
+	}
+
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	for _, host := range hosts {
+		names := cert.DNSNames
+		if ip := net.ParseIP(host); ip != nil {
+			names, host = ips, ip.String()
+		}
+		if !contains(names, host) {
+			return true, "Certificate doesn't match the hosts of the config, rotating certificate"
+		}
+	}
+	return false, ""
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestMissingSANs(t *testing.T) {
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+	pemCert, pemKey, err := security.CreateServerCert(caCert, caKey, nil, 2048, time.Now(), time.Hour,
+		[]string{"crdb.example.com", "crdb-public", "127.0.0.1"})
+	require.NoError(t, err)
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "ns"},
+			Data:       map[string][]byte{corev1.TLSCertKey: pemCert, corev1.TLSPrivateKeyKey: pemKey},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "ns"},
+			Data:       map[string][]byte{corev1.TLSCertKey: []byte("invalid"), corev1.TLSPrivateKeyKey: pemKey},
+		})
+	r := resource.NewKubeResource(context.TODO(), cl, "ns", kube.DefaultPersister)
+
+	server, err := resource.LoadTLSSecret("server", r)
+	require.NoError(t, err)
+
+	missing, _ := missingSANs(server, []string{"crdb-public", "crdb.example.com", "127.0.0.1"})
+	assert.False(t, missing)
+
+	// the names removed from the config are kept until the next rotation
+	missing, _ = missingSANs(server, []string{"crdb-public"})
+	assert.False(t, missing)
+
+	missing, reason := missingSANs(server, []string{"crdb-public", "crdb.internal"})
+	assert.True(t, missing)
+	assert.Contains(t, reason, "doesn't match the hosts of the config")
+
+	missing, _ = missingSANs(server, []string{"10.0.0.1"})
+	assert.True(t, missing)
+
+	invalid, err := resource.LoadTLSSecret("invalid", r)
+	require.NoError(t, err)
+	missing, _ = missingSANs(invalid, nil)
+	assert.True(t, missing)
+}
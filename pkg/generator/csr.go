@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/plugin"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+const defaultSigningTimeout = 5 * time.Minute
+
+// signed returns true if the certificates are requested from the signer or the signer plugin, or signed
+// offline, instead of being signed with the CA key.
+func (rc *GenerateCert) signed() bool {
+	return rc.SigningClient != nil || rc.Offline != nil || rc.SignerPlugin != nil
+}
+
+// requestPair generates a key and gets a certificate for it from the signer through a
+// CertificateSigningRequest, from the signer plugin, or from the offline bundle. The certificate, key and CA bundle are written to
+// the certs directory, as the cockroach CLI does.
+func (rc *GenerateCert) requestPair(ctx context.Context, namespace, secretName, commonName string, hosts []string,
+	algorithm, certFile, keyFile string) error {
+
+	var cert, ca, pemKey []byte
+	var err error
+	if rc.Offline != nil {
+		cert, ca, pemKey, err = rc.offlinePair(ctx, namespace, secretName, commonName, hosts, algorithm)
+	} else if rc.SignerPlugin != nil {
+		cert, ca, pemKey, err = rc.pluginPair(ctx, secretName, commonName, hosts, algorithm)
+	} else {
+		cert, ca, pemKey, err = rc.signerPair(ctx, namespace, secretName, commonName, hosts, algorithm)
+	}
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{resource.CaCert, ca, security.CertFileMode},
+		{certFile, cert, security.CertFileMode},
+		{keyFile, pemKey, security.KeyFileMode},
+	}
+
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(rc.CertsDir, f.name), f.data, f.mode); err != nil {
+			return errors.Wrapf(err, "failed to write %s", f.name)
+		}
+	}
+
+	return nil
+}
+
+// signerPair generates a key and gets a certificate for it from the signer, along with the CA bundle.
+func (rc *GenerateCert) signerPair(ctx context.Context, namespace, secretName, commonName string, hosts []string,
+	algorithm string) (cert, ca, pemKey []byte, err error) {
+
+	key, pemKey, err := rc.keys.GenerateKey(algorithm, rc.keySize())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	csr, err := security.CreateCSR(key, commonName, hosts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	timeout := rc.SigningTimeout
+	if timeout == 0 {
+		timeout = defaultSigningTimeout
+	}
+
+	cert, ca, err = signer.Request(ctx, rc.SigningClient, fmt.Sprintf("%s-%s", namespace, secretName), csr,
+		commonName == security.NodeUser, timeout, 2*time.Second)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cert, ca, pemKey, nil
+}
+
+// pluginPair generates a key and gets a certificate for it from the signer plugin, along with the CA bundle.
+func (rc *GenerateCert) pluginPair(ctx context.Context, secretName, commonName string, hosts []string,
+	algorithm string) (cert, ca, pemKey []byte, err error) {
+
+	key, pemKey, err := rc.keys.GenerateKey(algorithm, rc.keySize())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	csr, err := security.CreateCSR(key, commonName, hosts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	lifetime := rc.ClientCertConfig.Duration
+	if commonName == security.NodeUser {
+		lifetime = rc.NodeCertConfig.Duration
+	}
+
+	cert, ca, err = rc.SignerPlugin.Sign(ctx, plugin.SignRequest{
+		Name:       secretName,
+		CSR:        string(csr),
+		CommonName: commonName,
+		Hosts:      hosts,
+		Node:       commonName == security.NodeUser,
+		Lifetime:   lifetime.String(),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cert, ca, pemKey, nil
+}
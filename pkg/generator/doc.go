@@ -0,0 +1,29 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package generator generates and rotates the CA, node and client certificates of a CockroachDB cluster
+// and stores them in Kubernetes secrets. It is the library behind the self-signer commands, and can be
+// embedded by other operators and tools instead of running the self-signer binary.
+//
+// A GenerateCert created with NewGenerateCert holds the whole config of a cluster: the names of its
+// statefulset and services, the lifetimes of the certificates and which of them are rotated. Do then
+// reconciles the secrets of the cluster in a namespace, as shown by its example.
+//
+// Apart from the Prometheus metrics registered with controller-runtime, the package holds no global state: it
+// reads no env and leaves the log level to the program, so several GenerateCert can be used at once, and
+// every call reading or writing the API server takes a context. The certificates are created with the cockroach binary, which has
+// to be in the PATH.
+package generator
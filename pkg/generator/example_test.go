@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator_test
+
+import (
+	"context"
+	"log"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/generator"
+)
+
+// Generates the certificates of the crdb statefulset in the default namespace, and rotates the node and
+// client certificates which are about to expire.
+func ExampleGenerateCert_Do() {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cl, err := client.New(cfg, client.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rc := generator.NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.PublicServiceName = "crdb-public"
+	rc.ClusterDomain = "cluster.local"
+	rc.RotateNodeCert = true
+	rc.RotateClientCert = true
+	rc.NodeAndClientCronSchedule = "0 0 */26 * *"
+
+	for c, lifetime := range map[*generator.CertConfig][2]string{
+		rc.CaCertConfig:     {"43800h", "648h"},
+		rc.NodeCertConfig:   {"8760h", "168h"},
+		rc.ClientCertConfig: {"672h", "48h"},
+		rc.UICertConfig:     {"8760h", "168h"},
+	} {
+		if err := c.SetConfig(lifetime[0], lifetime[1]); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := rc.Do(context.Background(), "default"); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// Export writes the CA certificate and the client certificate and key of the user, read from the current
+// version of the client secret, to certsDir with the names and modes the cockroach CLI expects.
+func (rc *GenerateCert) Export(ctx context.Context, namespace, user, certsDir string) error {
+	secret, err := rc.loadClientSecret(ctx, namespace, user)
+	if err != nil {
+		return err
+	}
+
+	if err := security.WriteClientCerts(certsDir, user, secret.CA(), secret.TLSCert(), secret.TLSPrivateKey()); err != nil {
+		return err
+	}
+
+	logrus.Infof("Exported the certificates of secret [%s] to %s", secret.Secret().Name, certsDir)
+	return nil
+}
+
+// ClientCertFiles returns the files of the client certificates of the user, read from the current version
+// of the client secret, along with the version of the secret they were read from, which changes when the
+// certificate is rotated.
+func (rc *GenerateCert) ClientCertFiles(ctx context.Context, namespace, user string) ([]security.CertFile, string, error) {
+	secret, err := rc.loadClientSecret(ctx, namespace, user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version := secret.Secret().Name + "/" + secret.Secret().ResourceVersion
+	return security.ClientCertFiles(user, secret.CA(), secret.TLSCert(), secret.TLSPrivateKey()), version, nil
+}
+
+// loadClientSecret returns the current version of the client secret of the user.
+func (rc *GenerateCert) loadClientSecret(ctx context.Context, namespace, user string) (*resource.TLSSecret, error) {
+	name := rc.getClientSecretName()
+	if user != security.RootUser {
+		name = fmt.Sprintf("%s-client-secret", user)
+	}
+
+	currentName, err := rc.currentSecretName(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := resource.LoadTLSSecret(currentName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get client secret [%s]", currentName)
+	}
+
+	if !secret.Ready() {
+		return nil, errors.Wrapf(resource.ErrInvalidSecret, "secret [%s] doesn't contain the required cert/key", currentName)
+	}
+	return secret, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestExport(t *testing.T) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-client-secret", Namespace: "ns"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			resource.CaCert:         []byte("ca"),
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		},
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	certsDir := filepath.Join(t.TempDir(), "certs")
+	// an existing key readable by others is made private
+	require.NoError(t, os.MkdirAll(certsDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(certsDir, "client.root.key"), []byte("old"), 0644))
+
+	require.NoError(t, rc.Export(context.TODO(), "ns", security.RootUser, certsDir))
+
+	for name, expected := range map[string]struct {
+		data string
+		mode os.FileMode
+	}{
+		"ca.crt":          {"ca", security.CertFileMode},
+		"client.root.crt": {"cert", security.CertFileMode},
+		"client.root.key": {"key", security.KeyFileMode},
+	} {
+		path := filepath.Join(certsDir, name)
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected.data, string(data))
+
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, expected.mode, info.Mode().Perm(), name)
+		}
+	}
+
+	assert.Error(t, rc.Export(context.TODO(), "ns", "admin", certsDir))
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// logFingerprints logs the serial and SHA-256 fingerprint of each certificate of the PEM data, if enabled
+// with LogFingerprints, to correlate the certificates with the TLS logs of CockroachDB. Keys are never
+// logged.
+func (rc *GenerateCert) logFingerprints(action, secretName string, pemCerts []byte) {
+	if !rc.LogFingerprints {
+		return
+	}
+
+	certs, err := security.ParseCerts(pemCerts)
+	if err != nil {
+		logrus.Warnf("Failed to parse the certificates of secret [%s] to log their fingerprints: %s", secretName, err)
+		return
+	}
+
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.Raw)
+		logrus.Infof("Certificate %s in secret [%s]: subject=%q serial=%s sha256=%s not-after=%s", action, secretName,
+			cert.Subject.CommonName, cert.SerialNumber.Text(16), hex.EncodeToString(sum[:]), cert.NotAfter.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+func TestLogFingerprints(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	rc := NewGenerateCert(nil)
+
+	// nothing is logged unless enabled
+	rc.logFingerprints("issue", "crdb-node-secret", nodeCert)
+	assert.Empty(t, hook.AllEntries())
+
+	rc.LogFingerprints = true
+	// keys mixed with the certificates are skipped
+	rc.logFingerprints("issue", "crdb-node-secret", append(append([]byte{}, nodeKey...), []byte(testcerts.CACert)...))
+	require.Len(t, hook.AllEntries(), 1)
+
+	fingerprint, err := security.Fingerprint([]byte(testcerts.CACert))
+	require.NoError(t, err)
+
+	entry := hook.LastEntry()
+	assert.Equal(t, logrus.InfoLevel, entry.Level)
+	assert.Contains(t, entry.Message, "sha256="+fingerprint)
+	assert.Contains(t, entry.Message, "[crdb-node-secret]")
+	assert.False(t, strings.Contains(entry.Message, "PRIVATE KEY"))
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// OrphanedSince is the annotation recording when the StatefulSet of a secret was first found missing by the
+// garbage collection. It is removed if the StatefulSet comes back.
+const OrphanedSince = "crdb.io/orphaned-since"
+
+// OrphanedSecret is a secret written by the self-signer whose StatefulSet no longer exists.
+type OrphanedSecret struct {
+	Secret        string    `json:"secret"`
+	StatefulSet   string    `json:"statefulSet"`
+	OrphanedSince time.Time `json:"orphanedSince,omitempty"`
+	Deleted       bool      `json:"deleted"`
+}
+
+// labelSecrets returns a persister labeling the secrets with the StatefulSet of the release before writing
+// them with next.
+func labelSecrets(statefulSet string, next kube.PersistFn) kube.PersistFn {
+	return func(ctx context.Context, cl client.Client, obj client.Object, f kube.MutateFn) (bool, error) {
+		if _, ok := obj.(*corev1.Secret); !ok {
+			return next(ctx, cl, obj, f)
+		}
+
+		return next(ctx, cl, obj, func() error {
+			if err := f(); err != nil {
+				return err
+			}
+
+			labels := obj.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[resource.StatefulSetLabel] = statefulSet
+			obj.SetLabels(labels)
+			return nil
+		})
+	}
+}
+
+// CollectOrphanedSecrets returns the secrets of the namespace written by the self-signer whose StatefulSet no
+// longer exists. Unless dryRun is set, the orphaned secrets are annotated with the time they were first found
+// orphaned, and deleted once they were orphaned for longer than grace. Secrets written before they were labeled
+// with their StatefulSet are skipped.
+func (rc *GenerateCert) CollectOrphanedSecrets(ctx context.Context, namespace string, grace time.Duration,
+	dryRun bool) ([]OrphanedSecret, error) {
+
+	var list corev1.SecretList
+	err := rc.client.List(ctx, &list, client.InNamespace(namespace),
+		client.MatchingLabels{resource.ManagedByLabel: resource.ManagedByValue})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the cert secrets")
+	}
+
+	exists := map[string]bool{}
+	var orphaned []OrphanedSecret
+	for i := range list.Items {
+		secret := &list.Items[i]
+		name, ok := secret.Labels[resource.StatefulSetLabel]
+		if !ok {
+			logrus.Debugf("Secret [%s] isn't labeled with its StatefulSet, skipping", secret.Name)
+			continue
+		}
+
+		found, ok := exists[name]
+		if !ok {
+			err := rc.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &appsv1.StatefulSet{})
+			if client.IgnoreNotFound(err) != nil {
+				return orphaned, errors.Wrapf(err, "failed to get StatefulSet [%s]", name)
+			}
+			found = err == nil
+			exists[name] = found
+		}
+
+		if found {
+			if _, marked := secret.Annotations[OrphanedSince]; marked && !dryRun {
+				if err := rc.markOrphaned(ctx, secret, ""); err != nil {
+					return orphaned, err
+				}
+				logrus.Infof("StatefulSet [%s] of secret [%s] is back, the secret is no longer orphaned", name, secret.Name)
+			}
+			continue
+		}
+
+		o := OrphanedSecret{Secret: secret.Name, StatefulSet: name}
+		if since, err := time.Parse(time.RFC3339, secret.Annotations[OrphanedSince]); err == nil {
+			o.OrphanedSince = since
+		}
+
+		if dryRun {
+			orphaned = append(orphaned, o)
+			continue
+		}
+
+		if o.OrphanedSince.IsZero() {
+			o.OrphanedSince = rc.now().UTC().Truncate(time.Second)
+			if err := rc.markOrphaned(ctx, secret, o.OrphanedSince.Format(time.RFC3339)); err != nil {
+				return orphaned, err
+			}
+			logrus.Infof("StatefulSet [%s] of secret [%s] doesn't exist, the secret is deleted after %s",
+				name, secret.Name, grace)
+		}
+
+		if rc.now().Sub(o.OrphanedSince) >= grace {
+			if err := rc.deleteOrphaned(ctx, namespace, secret); err != nil {
+				return orphaned, err
+			}
+			o.Deleted = true
+		}
+		orphaned = append(orphaned, o)
+	}
+
+	return orphaned, nil
+}
+
+// markOrphaned sets the OrphanedSince annotation of the secret to since, or removes it if since is empty. The
+// secret is updated as listed rather than through the persister, which would label it with the StatefulSet of
+// the current release.
+func (rc *GenerateCert) markOrphaned(ctx context.Context, secret *corev1.Secret, since string) error {
+	if since == "" {
+		delete(secret.Annotations, OrphanedSince)
+	} else {
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[OrphanedSince] = since
+	}
+
+	return errors.Wrapf(rc.client.Update(ctx, secret), "failed to annotate orphaned secret [%s]", secret.Name)
+}
+
+// deleteOrphaned removes the deletion protection of the secret and deletes it, unless it was modified since it
+// was listed, and records the deletion in the audit log.
+func (rc *GenerateCert) deleteOrphaned(ctx context.Context, namespace string, secret *corev1.Secret) error {
+	if controllerutil.ContainsFinalizer(secret, resource.DeletionProtection) {
+		controllerutil.RemoveFinalizer(secret, resource.DeletionProtection)
+		if err := rc.client.Update(ctx, secret); err != nil {
+			return errors.Wrapf(err, "failed to remove the deletion protection of orphaned secret [%s]", secret.Name)
+		}
+	}
+
+	err := rc.client.Delete(ctx, secret, client.Preconditions{UID: &secret.UID, ResourceVersion: &secret.ResourceVersion})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete orphaned secret [%s]", secret.Name)
+	}
+
+	logrus.Infof("Deleted orphaned secret [%s] of StatefulSet [%s]", secret.Name, secret.Labels[resource.StatefulSetLabel])
+	return errors.Wrap(audit.Record(ctx, rc.AuditLog, audit.Event{
+		Operation: audit.Delete,
+		Namespace: namespace,
+		Secret:    secret.Name,
+		Requester: rc.Requester,
+	}), "failed to record audit event")
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestLabelSecrets(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	persist := labelSecrets("crdb", kube.DefaultPersister)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"}}
+	_, err := persist(ctx, cl, secret, func() error { return nil })
+	require.NoError(t, err)
+
+	var stored corev1.Secret
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-node-secret"}, &stored))
+	assert.Equal(t, "crdb", stored.Labels[resource.StatefulSetLabel])
+
+	// other objects aren't labeled
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "crdb-serials", Namespace: "ns"}}
+	_, err = persist(ctx, cl, cm, func() error { return nil })
+	require.NoError(t, err)
+	assert.Empty(t, cm.Labels)
+}
+
+func TestCollectOrphanedSecrets(t *testing.T) {
+	ctx := context.TODO()
+	secret := func(name, statefulSet string, annotations map[string]string) *corev1.Secret {
+		labels := map[string]string{resource.ManagedByLabel: resource.ManagedByValue}
+		if statefulSet != "" {
+			labels[resource.StatefulSetLabel] = statefulSet
+		}
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "ns",
+			Labels:      labels,
+			Annotations: annotations,
+		}}
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"}},
+		secret("crdb-node-secret", "crdb", map[string]string{OrphanedSince: now.Add(-time.Hour).Format(time.RFC3339)}),
+		secret("old-node-secret", "old", nil),
+		secret("old-client-secret", "old", nil),
+		secret("legacy-node-secret", "", nil),
+	)
+
+	fake := clock.NewFake(now)
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.Clock = fake
+
+	get := func(name string) (*corev1.Secret, error) {
+		var s corev1.Secret
+		err := cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: name}, &s)
+		return &s, err
+	}
+
+	// the candidates are reported without touching the secrets
+	orphaned, err := rc.CollectOrphanedSecrets(ctx, "ns", time.Hour, true)
+	require.NoError(t, err)
+	require.Len(t, orphaned, 2)
+	assert.Equal(t, "old", orphaned[0].StatefulSet)
+	assert.True(t, orphaned[0].OrphanedSince.IsZero())
+	s, err := get("old-node-secret")
+	require.NoError(t, err)
+	assert.NotContains(t, s.Annotations, OrphanedSince)
+
+	// the orphaned secrets are marked, and kept during the grace period
+	orphaned, err = rc.CollectOrphanedSecrets(ctx, "ns", time.Hour, false)
+	require.NoError(t, err)
+	require.Len(t, orphaned, 2)
+	assert.False(t, orphaned[0].Deleted)
+	s, err = get("old-node-secret")
+	require.NoError(t, err)
+	assert.Equal(t, now.Format(time.RFC3339), s.Annotations[OrphanedSince])
+
+	// the secret of the existing StatefulSet is no longer marked
+	s, err = get("crdb-node-secret")
+	require.NoError(t, err)
+	assert.NotContains(t, s.Annotations, OrphanedSince)
+
+	fake.Advance(time.Hour)
+	orphaned, err = rc.CollectOrphanedSecrets(ctx, "ns", time.Hour, false)
+	require.NoError(t, err)
+	require.Len(t, orphaned, 2)
+	assert.True(t, orphaned[0].Deleted)
+	assert.True(t, orphaned[1].Deleted)
+
+	_, err = get("old-node-secret")
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = get("old-client-secret")
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = get("crdb-node-secret")
+	assert.NoError(t, err)
+	_, err = get("legacy-node-secret")
+	assert.NoError(t, err)
+}
@@ -20,64 +20,245 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/cockroachdb/helm-charts/pkg/acme"
+	"github.com/cockroachdb/helm-charts/pkg/attestation"
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/chaos"
+	"github.com/cockroachdb/helm-charts/pkg/clock"
 	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/objectstore"
+	"github.com/cockroachdb/helm-charts/pkg/plugin"
+	"github.com/cockroachdb/helm-charts/pkg/policy"
 	"github.com/cockroachdb/helm-charts/pkg/resource"
 	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/spire"
+	"github.com/cockroachdb/helm-charts/pkg/throttle"
 	util "github.com/cockroachdb/helm-charts/pkg/utils"
+	"github.com/cockroachdb/helm-charts/pkg/window"
 )
 
+// defaultKeySize is the size of the RSA keys when KeySize is not set.
 const defaultKeySize = 2048
 
-// Options settable via command-line flags. See below for defaults.
-var keySize int
-var allowCAKeyReuse bool
-var overwriteFiles bool
-var generatePKCS8Key bool
-
-func init() {
-	keySize = defaultKeySize
-	allowCAKeyReuse = false
-	overwriteFiles = true
-	generatePKCS8Key = false
-}
-
-// GenerateCert is the structure containing all the certificate related info
+// GenerateCert is the structure containing all the certificate related info. It is created with
+// NewGenerateCert, and holds no state shared with other instances, so that several of them can be used
+// side by side, e.g. for different clusters.
 type GenerateCert struct {
 	client                    client.Client
+	KeySize                   int
+	AllowCAKeyReuse           bool
+	OverwriteFiles            bool
+	GeneratePKCS8Key          bool
 	CertsDir                  string
 	CaSecret                  string
 	CAKey                     string
-	CaCertConfig              *certConfig
+	CaCertConfig              *CertConfig
 	RotateCACert              bool
 	CACronSchedule            string
-	NodeCertConfig            *certConfig
+	NodeCertConfig            *CertConfig
 	RotateNodeCert            bool
-	ClientCertConfig          *certConfig
+	ClientCertConfig          *CertConfig
+	ClientKeyAlgorithm        string
 	RotateClientCert          bool
 	NodeAndClientCronSchedule string
 	PublicServiceName         string
 	DiscoveryServiceName      string
 	ClusterDomain             string
+	AnnotateStatefulSet       bool
+	ProvisionSQLUsers         bool
+	Grants                    []security.Grant
+	SQLPort                   int
+	NodeSANs                  []string
+	PerPodSANReplicas         int
+	ClientUsers               []string
+	ClientCertUser            string
+	CASecretName              string
+	NodeSecretName            string
+	ClientSecretName          string
+	Persister                 kube.PersistFn
+	ImmutableSecrets          bool
+	RotationStrategy          string
+	RollbackGracePeriod       time.Duration
+	SecretVersionsName        string
+	BundleStore               objectstore.Store
+	BundlePrefix              string
+	AuditLog                  audit.Logger
+	Attest                    bool
+	Requester                 string
 	ReadinessWait             time.Duration
 	PodUpdateTimeout          time.Duration
+	SkipPermissionCheck       bool
+	SigningClient             kubernetes.Interface
+	SigningTimeout            time.Duration
+	PerNodeCerts              bool
+	UICert                    bool
+	UICertConfig              *CertConfig
+	UIHosts                   []string
+	UISecretName              string
+	IngressHosts              []string
+	IngressSecretName         string
+	IngressCASecret           string
+	Tenants                   []Tenant
+	SQLProxyHosts             []string
+	SQLProxySecretName        string
+	ACMEIssuer                *acme.Issuer
+	LogFingerprints           bool
+	// Chaos injects failures in the writes, for e2e tests
+	Chaos *chaos.Injector
+	// Throttle delays the first rotation of each run, when many namespaces are rotated on the same schedule
+	Throttle *throttle.Throttle
+	// SPIRE fetches the node certificate from the SPIRE agent, as the X509-SVID of the CockroachDB workload,
+	// instead of signing it with the CA. The bundle of its trust domain is added to the CA of the node secret.
+	SPIRE *spire.Workload
+	// RenewalRatio is the fraction of the lifetime of the node and client certificates after which they are
+	// renewed, e.g. 0.5 for short-lived certificates. Zero renews them on the rotation cron only.
+	RenewalRatio float64
+	// RootPassword generates a password for the root SQL user, stored in the RootPasswordSecretName secret,
+	// and sets it in the cluster once it is available.
+	RootPassword           bool
+	RootPasswordSecretName string
+	// NodeProfile, ClientProfile and UIProfile override the key usages of the node, client and DB Console
+	// certificates signed with the CA, and add policies and extensions to them.
+	NodeProfile   *security.Profile
+	ClientProfile *security.Profile
+	UIProfile     *security.Profile
+	// SerialRegistryName is the ConfigMap recording the serial numbers of the issued certificates, which
+	// are unique and can be revoked. No registry is kept if empty.
+	SerialRegistryName string
+	// CertLogName is the ConfigMap of the append-only log of the issued certificates, which keeps a record of
+	// every certificate independent of the current content of the secrets. No log is kept if empty.
+	CertLogName        string
+	CertLogSegmentSize int
+	// ProtectSecrets sets the resource.DeletionProtection finalizer on the secrets, so that a secret deleted by
+	// accident is kept until the finalizer is removed with Unprotect.
+	ProtectSecrets bool
+	// SplitCASecret keeps the CA key in the CAKeySecretName secret, by default <statefulset>-ca-key-secret, and
+	// only the CA certificate in the CA secret, so that the CA key is in a secret no pod has to mount.
+	SplitCASecret   bool
+	CAKeySecretName string
+	// ReuseKeys re-signs the existing key of the node and client certificates when they are rotated, instead
+	// of creating a new key, so that only the certificate of their secrets changes.
+	ReuseKeys bool
+	// MaintenanceWindow defers the rotations to its windows, unless the certificate expires before the next
+	// window opens, was revoked or its rotation was requested with the crdb.io/rotate annotation.
+	MaintenanceWindow *window.Window
+	// HealthChecks restarts the pods after a rotation only while all the nodes are ready and report no
+	// under-replicated or unavailable ranges on their HTTPPort. The rollout halts if the cluster doesn't
+	// recover within HealthTimeout, and is resumed by the next run.
+	HealthChecks  bool
+	HealthTimeout time.Duration
+	HTTPPort      int
+	// EvictionClient restarts the pods after a rotation with the eviction API, so that their
+	// PodDisruptionBudgets are respected, instead of deleting them.
+	EvictionClient kubernetes.Interface
+	// CanaryRotation rolls a rotated versioned node secret out to the pod with the highest ordinal first, with
+	// the partition of the rolling update of the statefulset, and only updates the other pods once that pod
+	// serves the new certificate. The previous secret is restored if it doesn't.
+	CanaryRotation bool
+	// SmokeTest checks the certificates presented on the SQL and HTTP ports of each pod once the statefulset
+	// was restarted after a rotation, and reports the results in the logs, pod events and metrics.
+	SmokeTest bool
+	// Clock is the time the certificates are checked, signed and rotated at, the real time if nil.
+	Clock clock.Clock
+	// KeyWorkers generates the RSA keys of the certificates signed in process on this many goroutines while
+	// the CA is loaded or created. Zero generates each key when its certificate is signed.
+	KeyWorkers int
+	// RecordPKIStatus summarizes the state of the certificates in the <statefulset>-pki CrdbPKIStatus
+	// object after each run.
+	RecordPKIStatus bool
+	// NodeConditions reports the expiry of the node certificates as the NodeCertificateCondition of the
+	// Kubernetes nodes running the pods, for node-problem-detector style agents.
+	NodeConditions bool
+	// ReloadCerts makes CockroachDB reload its rotated certificates online, on SIGHUP, instead of restarting
+	// the pods. One of ExecReload, SidecarReload or AutoReload, the pods are restarted if empty.
+	ReloadCerts string
+	// Executor writes the certificates to the ReloadContainer of the pods with ExecReload and AutoReload, in
+	// ReloadCertsDir or DefaultReloadCertsDir.
+	Executor        kube.Executor
+	ReloadContainer string
+	ReloadCertsDir  string
+	// Offline signs the node and client certificates offline through a bundle of requests instead of the
+	// signer or the CA key.
+	Offline *OfflineSigning
+	// SignerPlugin issues the node and client certificates instead of the CA key, and PersisterPlugin is
+	// handed each secret once it was written.
+	SignerPlugin    *plugin.Plugin
+	PersisterPlugin *plugin.Plugin
+	// Policy is evaluated against each issued certificate before it is stored, which fails on a violation.
+	Policy policy.Evaluator
+	// PolicyVersion is recorded in the annotations of the secrets written, for admission policies to
+	// block the pods mounting certificates issued under an outdated PKI policy.
+	PolicyVersion string
+	// ClientPrincipals are the identities of the client certificates of the users mapped to SQL users by the
+	// server.identity_map.configuration of the cluster, by user. The certificates of the other users identify
+	// the user itself.
+	ClientPrincipals map[string]security.ClientPrincipal
+	// ClientSubject templates the common name and organizational units of the client certificates. The
+	// ClientPrincipals still set the common name of their users.
+	ClientSubject *ClientSubject
+	// DetectSharedCA detects the other releases whose certificates are issued by the same CA, records them
+	// in the CASharedWith annotation of the CA secret and warns when the CA shared with them is rotated.
+	// The secrets of SharedCANamespaces are checked, or of all the namespaces if empty.
+	DetectSharedCA     bool
+	SharedCANamespaces []string
+	// Lint are the thresholds of the weak crypto checks of the user provided certificates, such as the CaSecret,
+	// the IngressCASecret and the adopted certificates.
+	Lint LintOptions
+	// Reloader is the tool restarting the workloads mounting the secrets of the release on rotation, whose
+	// annotations are written on the secrets and on the Deployments and StatefulSets mounting them. One of
+	// StakaterReloader or WaveReloader, no annotations are written if empty.
+	Reloader string
+	// RestartWorkloads select the Deployments and StatefulSets of the namespace restarted when the client
+	// certificates are rotated, such as the applications mounting the client secrets.
+	RestartWorkloads []WorkloadSelector
+	// ReportCompletion reports the state of each run in the <statefulset>-certs-completion ConfigMap, which
+	// the pods mounting the certificates wait for with the wait command.
+	ReportCompletion bool
+	// ReleaseRevision is the revision of the Helm release the run is part of, reported in the completion.
+	ReleaseRevision string
+	// CAMemo holds the CA secrets loaded by the previous runs of the process, which are used instead of
+	// reading the CA secret again while it's unchanged. It is shared by the runs of the controller.
+	CAMemo *CAMemo
+	// AttestationKeySecretName is the secret of the key the attestations of Attest are signed with, along with
+	// its certificate issued by the CA, by default <statefulset>-attestation-key-secret. The CA key never signs
+	// attestations.
+	AttestationKeySecretName string
+	// AttestationRepository publishes the certificates attested with Attest as OCI artifacts of the repository,
+	// tagged <namespace>.<secret>, along with their attestations as cosign attestations. The attestations are
+	// only kept in the secret annotations if nil.
+	AttestationRepository *attestation.Repository
+
+	// serials is the serial registry of the namespace of the current run
+	serials *resource.SerialRegistry
+	// keys pre-generates the RSA keys of the certificates of the current run, when KeyWorkers is set
+	keys *security.KeyPool
+	// rotated holds the secrets rotated by the current run, and rotatedClients the client secrets among them
+	rotated        []string
+	rotatedClients []string
+	// throttled is set once the current run waited for the Throttle
+	throttled bool
+	// recovering is set while RecoverExpiredCA replaces the CA and reissues the certificates it signed
+	recovering bool
 }
 
-type certConfig struct {
+// CertConfig is the lifetime of a certificate.
+type CertConfig struct {
 	Duration     time.Duration
 	ExpiryWindow time.Duration
 }
 
 // SetConfig sets the certificate duration and expiryWindow
-func (c *certConfig) SetConfig(duration, expiryWindow string) error {
+func (c *CertConfig) SetConfig(duration, expiryWindow string) error {
 
 	dur, err := time.ParseDuration(duration)
 	if err != nil {
@@ -97,32 +278,75 @@ func (c *certConfig) SetConfig(duration, expiryWindow string) error {
 func NewGenerateCert(cl client.Client) GenerateCert {
 	return GenerateCert{
 		client:           cl,
-		CaCertConfig:     &certConfig{},
-		NodeCertConfig:   &certConfig{},
-		ClientCertConfig: &certConfig{},
+		KeySize:          defaultKeySize,
+		OverwriteFiles:   true,
+		CaCertConfig:     &CertConfig{},
+		NodeCertConfig:   &CertConfig{},
+		ClientCertConfig: &CertConfig{},
+		UICertConfig:     &CertConfig{},
 	}
 }
 
+// ForStatefulSet returns a copy of the config managing the certificates of the release of the statefulset,
+// used by the controller managing many releases with the same config. The copies share the audit log, the
+// bundle store and the Throttle.
+func (rc *GenerateCert) ForStatefulSet(name string) GenerateCert {
+	release := *rc
+	release.DiscoveryServiceName = name
+	release.PublicServiceName = name + "-public"
+	release.rotated = nil
+	release.rotatedClients = nil
+	release.keys = nil
+
+	// the ACME account key is loaded from the namespace of the release
+	if rc.ACMEIssuer != nil {
+		issuer := *rc.ACMEIssuer
+		release.ACMEIssuer = &issuer
+	}
+
+	return release
+}
+
 // Do func generates the various certificates required and then stores them in respective secrets.
-func (rc *GenerateCert) Do(ctx context.Context, namespace string) error {
+func (rc *GenerateCert) Do(ctx context.Context, namespace string) (err error) {
 
 	// create the various temporary directories to store the certificates in.
 	// These directories will be deleted when the code flow is completed.
-	logrus.SetLevel(logrus.InfoLevel)
-
-	certsDir, cleanup := util.CreateTempDir("certsDir")
+	run, cleanup, err := rc.beginRun(ctx, namespace)
 	defer cleanup()
-	rc.CertsDir = certsDir
+	if err != nil {
+		rc.completeRun(ctx, namespace, &err)
+		return err
+	}
+	// a paused namespace keeps reporting its last run, as nothing is generated
+	if !run {
+		return nil
+	}
 
-	caDir, cleanupCADir := util.CreateTempDir("caDir")
-	defer cleanupCADir()
-	rc.CAKey = filepath.Join(caDir, "ca.key")
+	// the completion is reported once everything the run defers is done
+	if err := rc.reportCompletion(ctx, namespace, resource.CompletionPending, ""); err != nil {
+		return err
+	}
+	defer rc.completeRun(ctx, namespace, &err)
+	defer rc.recordPKIStatus(ctx, namespace)
+	defer rc.reportNodeConditions(ctx, namespace)
+
+	// the CA rotation doesn't issue the other certificates
+	if !rc.RotateCACert {
+		stopKeyPool := rc.startKeyPool(ctx, namespace)
+		defer stopKeyPool()
+	}
 
-	// generate the base CA cert and key
-	if err := rc.generateCA(ctx, rc.getCASecretName(), namespace); err != nil {
+	if rc.signed() {
+		// the CA key is only read by the signer, which also rotates the CA
+		if rc.RotateCACert {
+			return errors.New("the CA is rotated by the signer when certificates are requested from it")
+		}
+	} else if err := rc.generateCA(ctx, rc.CAKeySecret(), namespace); err != nil {
+		// generate the base CA cert and key
 		msg := " error Generating CA"
 		logrus.Error(err, msg)
-		return errors.Wrap(err, msg)
+		return rc.partialRotation(errors.Wrap(err, msg))
 	}
 
 	// In the case of rotate CA, skip node and client certificate rotation
@@ -130,18 +354,151 @@ func (rc *GenerateCert) Do(ctx context.Context, namespace string) error {
 		return nil
 	}
 
+	if err := rc.generateLeafCerts(ctx, namespace); err != nil {
+		return err
+	}
+
+	// delete the previous versions of the secrets once they can no longer be rolled back to
+	if err := rc.deleteRetiredSecrets(ctx, namespace); err != nil {
+		msg := " error Deleting Retired Secrets"
+		logrus.Error(err, msg)
+		return errors.Wrap(err, msg)
+	}
+
+	if err := rc.restartWorkloads(ctx, namespace); err != nil {
+		msg := " error Restarting Workloads"
+		logrus.Error(err, msg)
+		return errors.Wrap(err, msg)
+	}
+
+	// the workloads are restarted by the reloader on the next rotation, so the annotations aren't critical
+	if err := rc.annotateReloadTargets(ctx, namespace); err != nil {
+		logrus.Warnf("Failed to annotate the workloads mounting the secrets for %s: %s", rc.Reloader, err)
+	}
+
+	if rc.RootPassword {
+		if err := rc.generateRootPassword(ctx, namespace); err != nil {
+			msg := " error Generating Root Password"
+			logrus.Error(err, msg)
+			return errors.Wrap(err, msg)
+		}
+	}
+
+	if rc.ProvisionSQLUsers {
+		return rc.provisionSQLUsers(ctx, namespace, rc.ClientUsers)
+	}
+
+	return nil
+}
+
+// beginRun checks that the certificates of the namespace can be managed, upgrades the secrets of older
+// versions, resumes a halted rollout and creates the temporary directories the certificates are written
+// to. It returns false if the namespace is paused, and the func deleting the directories.
+func (rc *GenerateCert) beginRun(ctx context.Context, namespace string) (bool, func(), error) {
+	// nothing can be created in a namespace which is being deleted
+	if err := kube.CheckNamespace(ctx, rc.client, namespace); err != nil {
+		return false, func() {}, err
+	}
+
+	paused, err := rc.namespacePaused(ctx, namespace)
+	if err != nil {
+		return false, func() {}, err
+	}
+	if paused {
+		logrus.Infof("Certificate management of namespace [%s] is paused, skipping", namespace)
+		return false, func() {}, nil
+	}
+
+	if err := rc.checkPermissions(ctx, namespace, false); err != nil {
+		return false, func() {}, err
+	}
+
+	// secrets of older versions are upgraded before they are reconciled
+	if err := rc.migrateSecrets(ctx, namespace); err != nil {
+		return false, func() {}, err
+	}
+	rc.throttled = false
+
+	if err := rc.resumeRollout(ctx, namespace); err != nil {
+		return false, func() {}, err
+	}
+
+	if err := rc.loadSerialRegistry(ctx, namespace); err != nil {
+		return false, func() {}, err
+	}
+
+	certsDir, cleanupCertsDir := util.CreateTempDir("certsDir")
+	rc.CertsDir = certsDir
+
+	caDir, cleanupCADir := util.CreateTempDir("caDir")
+	rc.CAKey = filepath.Join(caDir, "ca.key")
+
+	return true, func() {
+		cleanupCADir()
+		cleanupCertsDir()
+	}, nil
+}
+
+// generateLeafCerts generates the client, node, DB Console, Ingress and tenant certificates signed by the CA
+// of the certs dir, and rolls out the rotated node certificates.
+func (rc *GenerateCert) generateLeafCerts(ctx context.Context, namespace string) error {
 	// generate the client certificates for the database to use
 	if err := rc.generateClientCert(ctx, rc.getClientSecretName(), namespace); err != nil {
 		msg := " error Generating Client Certificate"
 		logrus.Error(err, msg)
-		return errors.Wrap(err, msg)
+		return rc.partialRotation(errors.Wrap(err, msg))
+	}
+
+	// generate the client certificates of the additional users
+	for _, user := range rc.ClientUsers {
+		if err := rc.generateUserClientCert(ctx, user, fmt.Sprintf("%s-client-secret", user), namespace); err != nil {
+			msg := fmt.Sprintf(" error Generating Client Certificate for user %s", user)
+			logrus.Error(err, msg)
+			return rc.partialRotation(errors.Wrap(err, msg))
+		}
 	}
 
 	// generate the node certificate for the database to use
-	if err := rc.generateNodeCert(ctx, rc.getNodeSecretName(), namespace); err != nil {
+	if rc.PerNodeCerts {
+		if err := rc.generatePerNodeCerts(ctx, namespace); err != nil {
+			msg := " error Generating Per-Node Certificates"
+			logrus.Error(err, msg)
+			return rc.partialRotation(errors.Wrap(err, msg))
+		}
+	} else if err := rc.generateNodeCert(ctx, rc.getNodeSecretName(), namespace, rc.NodeHosts(namespace),
+		func(previous string, current *resource.TLSSecret) error {
+			return rc.rolloutNodeSecret(ctx, namespace, previous, current)
+		}); err != nil {
 		msg := " error Generating Node Certificate"
 		logrus.Error(err, msg)
-		return errors.Wrap(err, msg)
+		return rc.partialRotation(errors.Wrap(err, msg))
+	}
+
+	// generate the DB Console certificate, served instead of the node certificate
+	if rc.UICert {
+		if err := rc.generateUICert(ctx, namespace); err != nil {
+			msg := " error Generating DB Console Certificate"
+			logrus.Error(err, msg)
+			return rc.partialRotation(errors.Wrap(err, msg))
+		}
+	}
+
+	// generate the certificate of the Ingress or Route fronting the cluster
+	if len(rc.IngressHosts) > 0 {
+		if err := rc.generateIngressCert(ctx, namespace); err != nil {
+			msg := " error Generating Ingress Certificate"
+			logrus.Error(err, msg)
+			return rc.partialRotation(errors.Wrap(err, msg))
+		}
+	}
+
+	// generate the certificates of the SQL tenants and of the SQL proxy
+	if len(rc.Tenants) > 0 || len(rc.SQLProxyHosts) > 0 {
+		if err := rc.generateTenantCerts(ctx, namespace); err != nil {
+			msg := " error Generating Tenant Certificates"
+			logrus.Error(err, msg)
+			return rc.partialRotation(errors.Wrap(err, msg))
+		}
 	}
 
 	return nil
@@ -149,7 +506,27 @@ func (rc *GenerateCert) Do(ctx context.Context, namespace string) error {
 
 // ClientCertGenerate generates the custom user client only certificates and creates the secret.
 func (rc *GenerateCert) ClientCertGenerate(ctx context.Context, namespace string) error {
-	logrus.SetLevel(logrus.InfoLevel)
+	// nothing can be created in a namespace which is being deleted
+	if err := kube.CheckNamespace(ctx, rc.client, namespace); err != nil {
+		return err
+	}
+
+	paused, err := rc.namespacePaused(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if paused {
+		logrus.Infof("Certificate management of namespace [%s] is paused, skipping", namespace)
+		return nil
+	}
+
+	if err := rc.checkPermissions(ctx, namespace, true); err != nil {
+		return err
+	}
+
+	if err := rc.loadSerialRegistry(ctx, namespace); err != nil {
+		return err
+	}
 
 	certsDir, cleanup := util.CreateTempDir("certsDir")
 	defer cleanup()
@@ -159,16 +536,16 @@ func (rc *GenerateCert) ClientCertGenerate(ctx context.Context, namespace string
 	defer cleanupCADir()
 	rc.CAKey = filepath.Join(caDir, "ca.key")
 
-	caSecret, caSecretExist := os.LookupEnv("CA_SECRET")
-	if rc.CaSecret == "" && caSecret == "" {
-		return errors.New("provide CA secret name to generate custom user client certificates")
-	} else if caSecretExist {
-		rc.CaSecret = caSecret
-	}
+	// with a signer, the certificate is signed without reading the CA secret
+	if !rc.signed() {
+		if rc.CaSecret == "" {
+			return errors.New("provide CA secret name to generate custom user client certificates")
+		}
 
-	// Load the CA secrets into certificate files in caDir and certDir
-	if err := rc.LoadCASecret(ctx, namespace); err != nil {
-		return err
+		// Load the CA secrets into certificate files in caDir and certDir
+		if err := rc.LoadCASecret(ctx, namespace); err != nil {
+			return err
+		}
 	}
 
 	// generate the client certificates for the database to use
@@ -178,10 +555,16 @@ func (rc *GenerateCert) ClientCertGenerate(ctx context.Context, namespace string
 		return errors.Wrap(err, msg)
 	}
 
+	if rc.ProvisionSQLUsers {
+		user, _ := rc.clientUser(rc.getClientSecretName())
+		return rc.provisionSQLUsers(ctx, namespace, []string{user})
+	}
+
 	return nil
 }
 
-// generateCA generates the CA key and certificate if not given by the user and stores them in a secret.
+// generateCA generates the CA key and certificate if not given by the user and stores them in a secret. With
+// SplitCASecret, the secret is the CA key secret and the CA certificate is also published to the CA secret.
 func (rc *GenerateCert) generateCA(ctx context.Context, CASecretName string, namespace string) error {
 
 	// if CA secret is given by user then validate it and use that
@@ -191,11 +574,16 @@ func (rc *GenerateCert) generateCA(ctx context.Context, CASecretName string, nam
 		return rc.LoadCASecret(ctx, namespace)
 	}
 
-	secret, err := resource.LoadTLSSecret(CASecretName, resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+	secret, memoized, err := rc.loadCASecret(ctx, namespace, CASecretName)
 	if client.IgnoreNotFound(err) != nil {
 		return errors.Wrap(err, "failed to get CA secret")
 	}
 
+	// the secret is only written if no other run updated it since it was loaded
+	loadedVersion := secret.Secret().ResourceVersion
+
+	operation := audit.Issue
+
 	// inline func used to generate CA cert and key
 	generate := func(rc *GenerateCert, CASecretName, namespace string) error {
 		logrus.Info("Generating CA")
@@ -205,10 +593,10 @@ func (rc *GenerateCert) generateCA(ctx context.Context, CASecretName string, nam
 			security.CreateCAPair(
 				rc.CertsDir,
 				rc.CAKey,
-				keySize,
+				rc.keySize(),
 				rc.CaCertConfig.Duration,
-				allowCAKeyReuse,
-				overwriteFiles),
+				rc.AllowCAKeyReuse,
+				rc.OverwriteFiles),
 			"failed to generate CA cert and key"); err != nil {
 			return err
 		}
@@ -232,26 +620,54 @@ func (rc *GenerateCert) generateCA(ctx context.Context, CASecretName string, nam
 
 		// create and save the TLS certificates into a secret
 		secret = resource.CreateTLSSecret(CASecretName, corev1.SecretTypeOpaque,
-			resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+			resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+		secret.ExpectResourceVersion(loadedVersion)
+		secret.SetPolicyVersion(rc.PolicyVersion)
 
 		// add certificate info in the secret annotations
-		annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.CaCertConfig.Duration.String())
+		annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.CaCertConfig.Duration.String(),
+			rc.CaCertConfig.ExpiryWindow.String())
+
+		inputs := map[string]string{
+			"duration":     rc.CaCertConfig.Duration.String(),
+			"expiryWindow": rc.CaCertConfig.ExpiryWindow.String(),
+		}
+
+		if err = rc.checkPolicy(ctx, namespace, CASecretName, caCert, inputs); err != nil {
+			return err
+		}
+
+		if err = rc.attest(ctx, namespace, CASecretName, caCert, inputs, annotations); err != nil {
+			return err
+		}
 
 		if err = secret.UpdateCASecret(cakey, caCert, annotations); err != nil {
 			return errors.Wrap(err, "failed to update ca key secret ")
 		}
 
 		logrus.Infof("Generated and saved CA key and certificate in secret [%s]", CASecretName)
-		return nil
+		if err = rc.publishCACert(ctx, namespace, secret); err != nil {
+			return err
+		}
+
+		return rc.recordIssued(ctx, operation, namespace, CASecretName, caCert, inputs)
 	}
 
 	// check if the existing secret is ready to be consumed. If found ready, skip cert generation
-	if secret.ReadyCA() && secret.ValidateAnnotations() {
+	if memoized || (secret.ReadyCA() && secret.ValidateAnnotations()) {
 
 		if rc.RotateCACert {
-			isRequired, reason := secret.IsRotationRequired(rc.CaCertConfig.Duration, rc.CACronSchedule)
-			if isRequired {
+			isRequired, reason := secret.IsRotationRequiredAt(rc.CaCertConfig.Duration, rc.CACronSchedule, rc.now())
+			if !isRequired {
+				isRequired, reason = rc.recovered(secret)
+			}
+			if isRequired && !rc.deferRotation(namespace, secret, rc.CACronSchedule, rc.CaCertConfig.ExpiryWindow) {
 				logrus.Infof("CA Certificate: %s", reason)
+				operation = audit.Rotate
+				if err := rc.throttleRotation(ctx, namespace); err != nil {
+					return err
+				}
+				rc.warnSharedCARotation(ctx, namespace, secret)
 
 				// writing old cert file so that the new CA is a bundle of both old and new CA cert
 				if err := ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), secret.CA(), security.CertFileMode); err != nil {
@@ -261,13 +677,24 @@ func (rc *GenerateCert) generateCA(ctx context.Context, CASecretName string, nam
 				if err := generate(rc, CASecretName, namespace); err != nil {
 					return err
 				}
+				rc.rotated = append(rc.rotated, CASecretName)
 
+				// the certificates signed by the expired CA are reissued by the recovery instead
+				if rc.recovering {
+					return nil
+				}
 				return rc.UpdateNewCA(ctx, namespace)
 
 			}
 		}
 
 		logrus.Infof("CA secret [%s] is found in ready state, skipping CA generation", CASecretName)
+		// the fingerprints were logged when the secret was memoized
+		if !memoized {
+			rc.logFingerprints("in use", CASecretName, secret.CA())
+			rc.memoizeCA(secret)
+		}
+		rc.detectSharedCA(ctx, namespace, secret)
 
 		if err := ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), secret.CA(), security.CertFileMode); err != nil {
 			return errors.Wrap(err, "failed to write CA cert")
@@ -276,48 +703,59 @@ func (rc *GenerateCert) generateCA(ctx context.Context, CASecretName string, nam
 		if err := ioutil.WriteFile(rc.CAKey, secret.CAKey(), security.KeyFileMode); err != nil {
 			return errors.Wrap(err, "failed to write CA key")
 		}
-		return nil
+		return rc.publishCACert(ctx, namespace, secret)
 	}
 
 	// generate new certificate
 	return generate(rc, CASecretName, namespace)
 }
 
-// generateNodeCert generates the Node key and certificate and stores them in a secret.
-func (rc *GenerateCert) generateNodeCert(ctx context.Context, nodeSecretName string, namespace string) (err error) {
+// generateNodeCert generates the Node key and certificate for the hosts and stores them in a secret. A rotated
+// secret is rolled out with the rollout func, which is given the name of the secret it replaced.
+func (rc *GenerateCert) generateNodeCert(ctx context.Context, nodeSecretName string, namespace string, hosts []string,
+	rollout func(previous string, current *resource.TLSSecret) error) (err error) {
+
+	currentName, err := rc.currentSecretName(ctx, namespace, nodeSecretName)
+	if err != nil {
+		return err
+	}
 
-	secret, err := resource.LoadTLSSecret(nodeSecretName, resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+	secret, err := resource.LoadTLSSecret(currentName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
 	if client.IgnoreNotFound(err) != nil {
 		return errors.Wrap(err, "failed to get node TLS secret")
 	}
+	loaded := secret
+
+	operation := audit.Issue
 
 	// inline func used to generate node cert and key
 	generate := func(rc *GenerateCert, nodeSecretName, namespace string) error {
-		logrus.Info("Generating node certificate")
-
-		// hosts are the various DNS names and IP address that have to exist in the Node certificates
-		// for the database to function
-		hosts := []string{
-			"localhost",
-			"127.0.0.1",
-			rc.PublicServiceName,
-			fmt.Sprintf("%s.%s", rc.PublicServiceName, namespace),
-			fmt.Sprintf("%s.%s.svc.%s", rc.PublicServiceName, namespace, rc.ClusterDomain),
-			fmt.Sprintf("*.%s", rc.DiscoveryServiceName),
-			fmt.Sprintf("*.%s.%s", rc.DiscoveryServiceName, namespace),
-			fmt.Sprintf("*.%s.%s.svc.%s", rc.DiscoveryServiceName, namespace, rc.ClusterDomain),
-		}
+		logrus.Infof("Generating node certificate for secret [%s]", nodeSecretName)
 
 		// create the Node Pair certificates
-		if err = errors.Wrap(
-			security.CreateNodePair(
+		var bundle []byte
+		if rc.signed() {
+			err = rc.requestPair(ctx, namespace, nodeSecretName, security.NodeUser, hosts, security.RSAAlgorithm,
+				"node.crt", "node.key")
+		} else if rc.SPIRE != nil {
+			bundle, err = rc.fetchNodeSVID(ctx, hosts)
+		} else if pemKey := rc.reusableKey(loaded, operation, security.RSAAlgorithm); pemKey != nil {
+			logrus.Infof("Re-signing the key of secret [%s]", loaded.Secret().Name)
+			err = security.ReissueNodePair(rc.CertsDir, rc.CAKey, pemKey, rc.now(), rc.NodeCertConfig.Duration,
+				hosts, rc.NodeProfile)
+		} else if rc.NodeProfile != nil {
+			err = security.CreateNodePairWithProfile(rc.CertsDir, rc.CAKey, rc.keys, rc.keySize(), rc.now(),
+				rc.NodeCertConfig.Duration, hosts, rc.NodeProfile)
+		} else {
+			err = security.CreateNodePair(
 				rc.CertsDir,
 				rc.CAKey,
-				keySize,
+				rc.keySize(),
 				rc.NodeCertConfig.Duration,
-				overwriteFiles,
-				hosts),
-			"failed to generate node certificate and key"); err != nil {
+				rc.OverwriteFiles,
+				hosts)
+		}
+		if err = errors.Wrap(err, "failed to generate node certificate and key"); err != nil {
 			return err
 		}
 
@@ -326,6 +764,8 @@ func (rc *GenerateCert) generateNodeCert(ctx context.Context, nodeSecretName str
 		if err != nil {
 			return errors.Wrap(err, "unable to read ca.crt")
 		}
+		// the nodes trust the SVIDs of each other and the client certificates signed by the CA
+		ca = append(bundle, ca...)
 
 		// Read the node certificate into memory
 		pemCert, err := ioutil.ReadFile(filepath.Join(rc.CertsDir, "node.crt"))
@@ -345,61 +785,172 @@ func (rc *GenerateCert) generateNodeCert(ctx context.Context, nodeSecretName str
 		}
 
 		// add certificate info in the secret annotations
-		annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.NodeCertConfig.Duration.String())
+		annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.NodeCertConfig.Duration.String(),
+			rc.NodeCertConfig.ExpiryWindow.String())
+
+		inputs := map[string]string{
+			"duration":     rc.NodeCertConfig.Duration.String(),
+			"expiryWindow": rc.NodeCertConfig.ExpiryWindow.String(),
+			"hosts":        strings.Join(hosts, ","),
+		}
+
+		if err = rc.checkPolicy(ctx, namespace, nodeSecretName, pemCert, inputs); err != nil {
+			return err
+		}
+
+		if err = rc.attest(ctx, namespace, nodeSecretName, pemCert, inputs, annotations); err != nil {
+			return err
+		}
 
 		// create and save the TLS certificates into a secret
-		secret = resource.CreateTLSSecret(nodeSecretName, corev1.SecretTypeTLS,
-			resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+		secret = rc.newTLSSecret(ctx, namespace, loaded, rc.persister())
 
 		if err = secret.UpdateTLSSecret(pemCert, pemKey, ca, annotations); err != nil {
 			return errors.Wrap(err, "failed to update node TLS secret certs")
 		}
 
-		logrus.Infof("Generated and saved node key and certificate in secret [%s]", nodeSecretName)
+		if err = rc.pointToSecret(ctx, namespace, nodeSecretName, secret.Secret().Name); err != nil {
+			return err
+		}
 
-		return nil
+		logrus.Infof("Generated and saved node key and certificate in secret [%s]", secret.Secret().Name)
+
+		return rc.recordIssued(ctx, operation, namespace, secret.Secret().Name, pemCert, inputs)
 	}
 	// check if the existing secret is ready to be consumed. If found ready, skip cert generation
 	if secret.Ready() && secret.ValidateAnnotations() {
 
 		if rc.RotateNodeCert {
-			isRequired, reason := secret.IsRotationRequired(rc.NodeCertConfig.Duration, rc.NodeAndClientCronSchedule)
-			if isRequired {
+			isRequired, reason := rc.rotationRequired(secret, rc.NodeCertConfig.Duration)
+			// the SVIDs are issued for the names of the SPIRE registration entry
+			if !isRequired && rc.SPIRE == nil {
+				isRequired, reason = missingSANs(secret, hosts)
+			}
+			if isRequired && !rc.deferRotation(namespace, secret, rc.NodeAndClientCronSchedule,
+				rc.NodeCertConfig.ExpiryWindow) {
 				logrus.Infof("Node Certificate: %s", reason)
+				operation = audit.Rotate
+				if err := rc.throttleRotation(ctx, namespace); err != nil {
+					return err
+				}
 
 				if err = generate(rc, nodeSecretName, namespace); err != nil {
+					if modifiedConcurrently(err, nodeSecretName) {
+						return nil
+					}
 					return err
 				}
+				rc.rotated = append(rc.rotated, secret.Secret().Name)
 
-				if err = kube.RollingUpdate(ctx, rc.client, rc.DiscoveryServiceName, namespace, rc.ReadinessWait, rc.PodUpdateTimeout); err != nil {
-					return
-				}
-				return nil
+				return rollout(loaded.Secret().Name, secret)
 			}
 		}
 
 		logrus.Infof("Node secret [%s] is found in ready state, skipping Node cert generation", nodeSecretName)
+		rc.logFingerprints("in use", secret.Secret().Name, secret.TLSCert())
 		return nil
 	}
 
-	return generate(rc, nodeSecretName, namespace)
+	if err = generate(rc, nodeSecretName, namespace); err != nil && !modifiedConcurrently(err, nodeSecretName) {
+		return err
+	}
+
+	return nil
+}
+
+// NodeHosts returns the various DNS names and IP address that have to exist in the Node certificates
+// for the database to function. The pods are matched by wildcard names, unless PerPodSANReplicas is
+// set, in which case the DNS names of each pod are listed explicitly.
+func (rc *GenerateCert) NodeHosts(namespace string) []string {
+	hosts := rc.serviceHosts(namespace)
+
+	if rc.PerPodSANReplicas > 0 {
+		for i := 0; i < rc.PerPodSANReplicas; i++ {
+			pod := fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)
+			hosts = append(hosts, pod)
+			hosts = append(hosts, rc.podHosts(namespace, pod)...)
+		}
+	} else {
+		hosts = append(hosts,
+			fmt.Sprintf("*.%s", rc.DiscoveryServiceName),
+			fmt.Sprintf("*.%s.%s", rc.DiscoveryServiceName, namespace),
+			fmt.Sprintf("*.%s.%s.svc.%s", rc.DiscoveryServiceName, namespace, rc.ClusterDomain),
+		)
+	}
+
+	return append(hosts, rc.NodeSANs...)
+}
 
+// PodHosts returns the DNS names and IP address that have to exist in the certificate of a single pod,
+// which are the names of the pod under the discovery service instead of the wildcard names.
+func (rc *GenerateCert) PodHosts(namespace, pod string) []string {
+	hosts := append(rc.serviceHosts(namespace), rc.podHosts(namespace, pod)...)
+	return append(hosts, rc.NodeSANs...)
+}
+
+// serviceHosts returns the local and public service names shared by all the pods.
+func (rc *GenerateCert) serviceHosts(namespace string) []string {
+	return []string{
+		"localhost",
+		"127.0.0.1",
+		rc.PublicServiceName,
+		fmt.Sprintf("%s.%s", rc.PublicServiceName, namespace),
+		fmt.Sprintf("%s.%s.svc.%s", rc.PublicServiceName, namespace, rc.ClusterDomain),
+	}
+}
+
+// podHosts returns the names of the pod under the discovery service.
+func (rc *GenerateCert) podHosts(namespace, pod string) []string {
+	return []string{
+		fmt.Sprintf("%s.%s", pod, rc.DiscoveryServiceName),
+		fmt.Sprintf("%s.%s.%s", pod, rc.DiscoveryServiceName, namespace),
+		rc.podFQDN(namespace, pod),
+	}
+}
+
+// podFQDN returns the fully qualified name of the pod under the discovery service.
+func (rc *GenerateCert) podFQDN(namespace, pod string) string {
+	return fmt.Sprintf("%s.%s.%s.svc.%s", pod, rc.DiscoveryServiceName, namespace, rc.ClusterDomain)
 }
 
 // generateClientCert generates the Client key and certificate and stores them in a secret.
 func (rc *GenerateCert) generateClientCert(ctx context.Context, clientSecretName string, namespace string) error {
 
-	user, userExist := os.LookupEnv("USER_NAME")
-	if !userExist {
-		user = security.RootUser
-	} else {
-		clientSecretName = fmt.Sprintf("%s-client-secret", user)
+	user, clientSecretName := rc.clientUser(clientSecretName)
+
+	return rc.generateUserClientCert(ctx, user, clientSecretName, namespace)
+}
+
+// clientUser returns the ClientCertUser along with the name of its secret. Without ClientCertUser, the root
+// user and defaultSecretName are returned.
+func (rc *GenerateCert) clientUser(defaultSecretName string) (user string, secretName string) {
+	if rc.ClientCertUser == "" {
+		return security.RootUser, defaultSecretName
+	}
+
+	return rc.ClientCertUser, fmt.Sprintf("%s-client-secret", rc.ClientCertUser)
+}
+
+// generateUserClientCert generates the Client key and certificate of the given user and stores them in a secret.
+func (rc *GenerateCert) generateUserClientCert(ctx context.Context, user, clientSecretName, namespace string) error {
+
+	currentName, err := rc.currentSecretName(ctx, namespace, clientSecretName)
+	if err != nil {
+		return err
 	}
 
-	secret, err := resource.LoadTLSSecret(clientSecretName, resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+	secret, err := resource.LoadTLSSecret(currentName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
 	if client.IgnoreNotFound(err) != nil {
 		return errors.Wrap(err, "failed to get client secret")
 	}
+	loaded := secret
+
+	principal, mapped, err := rc.clientPrincipal(namespace, user)
+	if err != nil {
+		return err
+	}
+
+	operation := audit.Issue
 
 	// inline func used to generate client cert and key
 	generate := func(rc *GenerateCert, clientSecretName, namespace string) error {
@@ -411,16 +962,42 @@ func (rc *GenerateCert) generateClientCert(ctx context.Context, clientSecretName
 		}
 
 		// Create the client certificates
-		if err = errors.Wrap(
-			security.CreateClientPair(
+		algorithm := rc.clientKeyAlgorithm(ctx, namespace)
+		if rc.signed() && len(principal.OrganizationalUnits) > 0 {
+			return errors.Errorf("the organizational units of the client certificate of user %s can't be set "+
+				"when the certificates are signed by a signer", user)
+		}
+		if rc.signed() {
+			commonName := user
+			if principal.CommonName != "" {
+				commonName = principal.CommonName
+			}
+			err = rc.requestPair(ctx, namespace, clientSecretName, commonName, principal.DNSNames, algorithm,
+				fmt.Sprintf("client.%s.crt", user), fmt.Sprintf("client.%s.key", user))
+		} else if pemKey := rc.reusableKey(loaded, operation, algorithm); pemKey != nil {
+			logrus.Infof("Re-signing the key of secret [%s]", loaded.Secret().Name)
+			err = security.ReissueClientPairForPrincipal(rc.CertsDir, rc.CAKey, pemKey, rc.now(),
+				rc.ClientCertConfig.Duration, *u, principal, rc.ClientProfile)
+		} else if mapped {
+			// the cockroach CLI always names the user in the subject
+			err = security.CreateClientPairForPrincipal(rc.CertsDir, rc.CAKey, rc.keys, algorithm, rc.keySize(),
+				rc.now(), rc.ClientCertConfig.Duration, *u, principal, rc.ClientProfile)
+		} else if rc.ClientProfile != nil {
+			err = security.CreateClientPairWithProfile(rc.CertsDir, rc.CAKey, rc.keys, algorithm, rc.keySize(),
+				rc.now(), rc.ClientCertConfig.Duration, *u, rc.ClientProfile)
+		} else if algorithm == security.Ed25519Algorithm {
+			err = security.CreateEd25519ClientPair(rc.CertsDir, rc.CAKey, rc.now(), rc.ClientCertConfig.Duration, *u)
+		} else {
+			err = security.CreateClientPair(
 				rc.CertsDir,
 				rc.CAKey,
-				keySize,
+				rc.keySize(),
 				rc.ClientCertConfig.Duration,
-				overwriteFiles,
+				rc.OverwriteFiles,
 				*u,
-				generatePKCS8Key),
-			"failed to generate client certificate and key"); err != nil {
+				rc.GeneratePKCS8Key)
+		}
+		if err = errors.Wrap(err, "failed to generate client certificate and key"); err != nil {
 			return err
 		}
 
@@ -429,6 +1006,9 @@ func (rc *GenerateCert) generateClientCert(ctx context.Context, clientSecretName
 		if err != nil {
 			return errors.Wrap(err, "unable to read ca.crt")
 		}
+		if ca, err = rc.withSPIREBundle(ctx, ca); err != nil {
+			return err
+		}
 
 		// Load the client user certificate into memory
 		userCertFile := fmt.Sprintf("client.%s.crt", user)
@@ -451,47 +1031,275 @@ func (rc *GenerateCert) generateClientCert(ctx context.Context, clientSecretName
 		}
 
 		// add certificate info in the secret annotations
-		annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.ClientCertConfig.Duration.String())
+		annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.ClientCertConfig.Duration.String(),
+			rc.ClientCertConfig.ExpiryWindow.String())
+		annotations[resource.KeyAlgorithm] = algorithm
+
+		inputs := map[string]string{
+			"duration":     rc.ClientCertConfig.Duration.String(),
+			"expiryWindow": rc.ClientCertConfig.ExpiryWindow.String(),
+			"user":         user,
+			"keyAlgorithm": algorithm,
+		}
+		if principal.CommonName != "" {
+			inputs["principal"] = principal.CommonName
+		}
+
+		if err = rc.checkPolicy(ctx, namespace, clientSecretName, pemCert, inputs); err != nil {
+			return err
+		}
+
+		if err = rc.attest(ctx, namespace, clientSecretName, pemCert, inputs, annotations); err != nil {
+			return err
+		}
 
 		// create and save the TLS certificates into a secret
-		secret = resource.CreateTLSSecret(clientSecretName, corev1.SecretTypeTLS,
-			resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+		secret = rc.newTLSSecret(ctx, namespace, loaded, rc.clientPersister())
 
 		if err = secret.UpdateTLSSecret(pemCert, pemKey, ca, annotations); err != nil {
 			return errors.Wrap(err, "failed to update client TLS secret certs")
 		}
 
-		logrus.Infof("Generated and saved client key and certificate in secret [%s]", clientSecretName)
-		return nil
+		if err = rc.pointToSecret(ctx, namespace, clientSecretName, secret.Secret().Name); err != nil {
+			return err
+		}
+
+		logrus.Infof("Generated and saved client key and certificate in secret [%s]", secret.Secret().Name)
+
+		return rc.recordIssued(ctx, operation, namespace, secret.Secret().Name, pemCert, inputs)
 	}
 
 	// check if the existing is ready to be consumed. If found ready, skip cert generation
 	if secret.Ready() && secret.ValidateAnnotations() {
 
 		if rc.RotateClientCert {
-			isRequired, reason := secret.IsRotationRequired(rc.ClientCertConfig.Duration, rc.NodeAndClientCronSchedule)
-			if isRequired {
+			isRequired, reason := rc.rotationRequired(secret, rc.ClientCertConfig.Duration)
+			if isRequired && !rc.deferRotation(namespace, secret, rc.NodeAndClientCronSchedule,
+				rc.ClientCertConfig.ExpiryWindow) {
 				logrus.Infof("Client Certificate: %s", reason)
-				return generate(rc, clientSecretName, namespace)
+				operation = audit.Rotate
+				if err := rc.throttleRotation(ctx, namespace); err != nil {
+					return err
+				}
+				if err := generate(rc, clientSecretName, namespace); err != nil {
+					if modifiedConcurrently(err, clientSecretName) {
+						return nil
+					}
+					return err
+				}
+				rc.rotated = append(rc.rotated, secret.Secret().Name)
+				rc.rotatedClients = append(rc.rotatedClients, secret.Secret().Name)
+				return nil
 			}
 		}
 
 		logrus.Infof("Client secret [%s] is found in ready state, skipping Client cert generation", clientSecretName)
+		rc.logFingerprints("in use", secret.Secret().Name, secret.TLSCert())
 		return nil
 	}
 
-	return generate(rc, clientSecretName, namespace)
+	if err := generate(rc, clientSecretName, namespace); err != nil && !modifiedConcurrently(err, clientSecretName) {
+		return err
+	}
+
+	return nil
+}
+
+// rotationRequired returns true if the node or client certificate of the secret expires before the next
+// rotation cron, is past the RenewalRatio of its lifetime, was revoked, or is signed by a recovered CA.
+func (rc *GenerateCert) rotationRequired(secret *resource.TLSSecret, duration time.Duration) (bool, string) {
+	isRequired, reason := secret.IsRotationRequiredAt(duration, rc.NodeAndClientCronSchedule, rc.now())
+	if !isRequired && rc.RenewalRatio > 0 {
+		isRequired, reason = secret.IsRenewalDue(rc.RenewalRatio, rc.now())
+	}
+	if !isRequired {
+		isRequired, reason = rc.revoked(secret)
+	}
+	if !isRequired {
+		return rc.recovered(secret)
+	}
+	return isRequired, reason
+}
+
+// modifiedConcurrently reports whether writing the secret failed because another run updated it since it
+// was loaded, in which case the certificate written by the other run is kept. The CA secret is not handled
+// this way, since the certificates generated afterwards would be signed by a CA which was never saved.
+func modifiedConcurrently(err error, secretName string) bool {
+	if !errors.Is(err, resource.ErrModified) {
+		return false
+	}
+
+	logrus.Warnf("Secret [%s] was updated by another run, keeping its certificate", secretName)
+	return true
+}
+
+// PartialRotationError is returned when a run failed after rotating some of the secrets, which may then
+// hold certificates of different generations until the rotation is run again.
+type PartialRotationError struct {
+	Rotated []string
+	Err     error
+}
+
+func (e *PartialRotationError) Error() string {
+	return fmt.Sprintf("rotation partially applied, secrets %v were rotated: %s", e.Rotated, e.Err)
+}
+
+func (e *PartialRotationError) Unwrap() error {
+	return e.Err
+}
+
+// partialRotation returns the error as a PartialRotationError if some secrets were rotated before it occurred.
+func (rc *GenerateCert) partialRotation(err error) error {
+	if len(rc.rotated) == 0 {
+		return err
+	}
+
+	return &PartialRotationError{Rotated: rc.rotated, Err: err}
+}
+
+// throttleRotation waits for the Throttle before the first rotation of the run. Runs which don't rotate
+// anything are not delayed.
+func (rc *GenerateCert) throttleRotation(ctx context.Context, namespace string) error {
+	if rc.Throttle == nil || rc.throttled {
+		return nil
+	}
+	rc.throttled = true
+
+	return errors.Wrap(rc.Throttle.Wait(ctx, namespace), "failed to wait for rotation slot")
+}
+
+// recordIssued records the issuance or rotation of the certificate stored in the secret in the audit log.
+func (rc *GenerateCert) recordIssued(ctx context.Context, operation audit.Operation, namespace, secretName string,
+	pemCert []byte, inputs map[string]string) error {
+
+	rc.logFingerprints(string(operation), secretName, pemCert)
+
+	if rc.AuditLog == nil && rc.serials == nil && rc.CertLogName == "" {
+		return nil
+	}
+
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		return err
+	}
+
+	if err := rc.registerSerial(secretName, cert); err != nil {
+		return err
+	}
+
+	if err := rc.appendCertLog(ctx, operation, namespace, secretName, cert); err != nil {
+		return err
+	}
+
+	if rc.AuditLog == nil {
+		return nil
+	}
+
+	return errors.Wrap(audit.Record(ctx, rc.AuditLog, audit.Event{
+		Operation: operation,
+		Namespace: namespace,
+		Secret:    secretName,
+		Requester: rc.Requester,
+		Inputs:    inputs,
+		Serial:    cert.SerialNumber.Text(16),
+	}), "failed to record audit event")
+}
+
+// clientPersister returns the persister for client secrets, which also uploads them to the
+// bundle store when one is configured.
+func (rc *GenerateCert) clientPersister() kube.PersistFn {
+	if rc.BundleStore == nil {
+		return rc.persister()
+	}
+
+	return objectstore.NewPersister(rc.BundleStore, rc.BundlePrefix, rc.persister())
+}
+
+// persister returns the persister used to write secrets, server-side apply unless Persister is set, which
+// hands the secrets to the PersisterPlugin, if set. The secrets are labeled with the StatefulSet of the
+// release, and protected from deletion with ProtectSecrets. The writes fail as configured by Chaos, if set.
+func (rc *GenerateCert) persister() kube.PersistFn {
+	persist := rc.Persister
+	if persist == nil {
+		persist = kube.ApplyPersister
+	}
+	if rc.PersisterPlugin != nil {
+		persist = plugin.NewPersister(rc.PersisterPlugin, persist)
+	}
+	if rc.DiscoveryServiceName != "" {
+		persist = labelSecrets(rc.DiscoveryServiceName, persist)
+	}
+	if rc.ProtectSecrets {
+		persist = protectSecrets(persist)
+	}
+	if rc.Reloader != "" {
+		persist = reloaderSecrets(rc.Reloader, persist)
+	}
+
+	if rc.Chaos != nil {
+		return rc.Chaos.Persister(persist)
+	}
+	return persist
+}
+
+// clientKeyAlgorithm returns the key algorithm to use for client certificates. It falls back to RSA
+// when Ed25519 is requested but a node of the cluster runs a version of CockroachDB which doesn't support
+// it, or the version of the nodes can't be queried, e.g. before the cluster is first started.
+func (rc *GenerateCert) clientKeyAlgorithm(ctx context.Context, namespace string) string {
+	if rc.ClientKeyAlgorithm != security.Ed25519Algorithm {
+		return security.RSAAlgorithm
+	}
+
+	versions, err := rc.clusterVersions(ctx, namespace)
+	if err != nil {
+		logrus.Warnf("Unable to detect the cockroach version of the cluster, falling back to RSA client key: %s", err)
+		return security.RSAAlgorithm
+	}
+
+	for _, version := range versions {
+		if !security.SupportsEd25519(version) {
+			logrus.Warnf("Cockroach version %s doesn't support Ed25519 client certs, falling back to RSA client key", version)
+			return security.RSAAlgorithm
+		}
+	}
+
+	return security.Ed25519Algorithm
+}
+
+// keySize returns the size of the RSA keys.
+func (rc *GenerateCert) keySize() int {
+	if rc.KeySize == 0 {
+		return defaultKeySize
+	}
+	return rc.KeySize
+}
+
+// now returns the current time of the Clock.
+func (rc *GenerateCert) now() time.Time {
+	if rc.Clock == nil {
+		return clock.Real.Now()
+	}
+	return rc.Clock.Now()
 }
 
 func (rc *GenerateCert) getCASecretName() string {
+	if rc.CASecretName != "" {
+		return rc.CASecretName
+	}
 	return rc.DiscoveryServiceName + "-ca-secret"
 }
 
 func (rc *GenerateCert) getNodeSecretName() string {
+	if rc.NodeSecretName != "" {
+		return rc.NodeSecretName
+	}
 	return rc.DiscoveryServiceName + "-node-secret"
 }
 
 func (rc *GenerateCert) getClientSecretName() string {
+	if rc.ClientSecretName != "" {
+		return rc.ClientSecretName
+	}
 	return rc.DiscoveryServiceName + "-client-secret"
 }
 
@@ -511,51 +1319,97 @@ func (rc *GenerateCert) UpdateNewCA(ctx context.Context, namespace string) error
 	if err != nil {
 		return errors.Wrap(err, "unable to read ca.crt")
 	}
+	if ca, err = rc.withSPIREBundle(ctx, ca); err != nil {
+		return err
+	}
 
-	logrus.Info("Updating new CA in node secret")
-	nodeSecret, err := resource.LoadTLSSecret(rc.getNodeSecretName(), resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+	if rc.PerNodeCerts {
+		return rc.updatePerNodeCA(ctx, namespace, ca)
+	}
+
+	previousNodeSecret, err := rc.currentSecretName(ctx, namespace, rc.getNodeSecretName())
 	if err != nil {
-		return errors.Wrap(err, "failed to get node TLS secret")
+		return err
 	}
 
-	if err = nodeSecret.UpdateTLSSecret(nodeSecret.TLSCert(), nodeSecret.TLSPrivateKey(), ca,
-		nodeSecret.Secret().Annotations); err != nil {
+	logrus.Info("Updating new CA in node secret")
+	nodeSecret, err := rc.updateSecretCA(ctx, namespace, rc.getNodeSecretName(), ca, rc.persister())
+	if err != nil {
 		return errors.Wrap(err, "failed to update node TLS secret certs")
 	}
 
 	logrus.Info("Updated new CA in node secret")
 
-	logrus.Info("Updating new CA in client secret")
-
-	clientSecret, err := resource.LoadTLSSecret(rc.getClientSecretName(), resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
-	if err != nil {
-		return errors.Wrap(err, "failed to get client secret")
+	if err = rc.updateClientCA(ctx, namespace, ca); err != nil {
+		return err
 	}
 
-	if err = clientSecret.UpdateTLSSecret(clientSecret.TLSCert(), clientSecret.TLSPrivateKey(), ca,
-		clientSecret.Secret().Annotations); err != nil {
+	return rc.rolloutNodeSecret(ctx, namespace, previousNodeSecret, nodeSecret)
+}
+
+// updateClientCA replaces the CA certificate of the root client secret.
+func (rc *GenerateCert) updateClientCA(ctx context.Context, namespace string, ca []byte) error {
+	logrus.Info("Updating new CA in client secret")
+
+	if _, err := rc.updateSecretCA(ctx, namespace, rc.getClientSecretName(), ca, rc.clientPersister()); err != nil {
 		return errors.Wrap(err, "failed to update client TLS secret certs")
 	}
 
 	logrus.Info("Updating new CA in client secret")
+	return nil
+}
+
+// restartStatefulSet restarts the CockroachDB pods so that they pick up the updated node secret. If
+// AnnotateStatefulSet is set, the secret checksum is written to the pod template and the rollout is left
+// to the statefulset controller, otherwise the pods are restarted one by one, once the cluster is healthy
+// if HealthChecks is set.
+func (rc *GenerateCert) restartStatefulSet(ctx context.Context, namespace, secretName, checksum string) error {
+	// the pods are restarted at once by the recovery, once all the certificates were reissued
+	if rc.recovering {
+		return nil
+	}
 
-	if err := kube.RollingUpdate(ctx, rc.client, rc.DiscoveryServiceName, namespace, rc.ReadinessWait, rc.PodUpdateTimeout); err != nil {
+	if rc.AnnotateStatefulSet {
+		return kube.AnnotatePodTemplate(ctx, rc.client, rc.DiscoveryServiceName, namespace,
+			map[string]string{"checksum/" + secretName: checksum})
+	}
+
+	if rc.reloadSupported(ctx, namespace) {
+		return rc.reloadStatefulSet(ctx, namespace)
+	}
+
+	var err error
+	if rc.HealthChecks {
+		err = kube.ProgressiveRollout(ctx, rc.client, rc.EvictionClient, rc.DiscoveryServiceName, namespace, rc.ReadinessWait,
+			rc.PodUpdateTimeout, rc.HealthTimeout, rc.clusterHealth(namespace))
+	} else {
+		err = kube.RollingUpdate(ctx, rc.client, rc.EvictionClient, rc.DiscoveryServiceName, namespace, rc.ReadinessWait, rc.PodUpdateTimeout)
+	}
+	if err != nil {
 		return err
 	}
-	return nil
+
+	return rc.smokeTest(ctx, namespace)
 }
 
 // LoadCASecret loads the CA secret and write the CA certificate and key to the CA cert directory.
 func (rc *GenerateCert) LoadCASecret(ctx context.Context, namespace string) error {
-	secret, err := resource.LoadTLSSecret(rc.CaSecret, resource.NewKubeResource(ctx, rc.client, namespace, kube.DefaultPersister))
+	secret, memoized, err := rc.loadCASecret(ctx, namespace, rc.CaSecret)
 	if err != nil {
 		return errors.Wrap(err, "failed to get CA key secret")
 	}
 
-	// check if the secret contains required info
-	if !secret.ReadyCA() {
-		return errors.Wrap(err, "CA secret doesn't contain the required CA cert/key")
+	// the memoized secret was checked and linted when it was memoized
+	if !memoized {
+		// check if the secret contains required info
+		if !secret.ReadyCA() {
+			return errors.Wrap(resource.ErrInvalidSecret, "CA secret doesn't contain the required CA cert/key")
+		}
+		rc.logFingerprints("in use", rc.CaSecret, secret.CA())
+		rc.lintUserCerts(namespace, rc.CaSecret, secret.CA())
+		rc.memoizeCA(secret)
 	}
+	rc.detectSharedCA(ctx, namespace, secret)
 
 	if err := ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), secret.CA(), security.CertFileMode); err != nil {
 		return errors.Wrap(err, "failed to write CA cert")
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+	"github.com/cockroachdb/helm-charts/pkg/throttle"
+)
+
+func TestNodeHosts(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	rc.PublicServiceName = "crdb-public"
+	rc.DiscoveryServiceName = "crdb"
+	rc.ClusterDomain = "cluster.local"
+	rc.NodeSANs = []string{"crdb.example.com"}
+
+	assert.Equal(t, []string{
+		"localhost",
+		"127.0.0.1",
+		"crdb-public",
+		"crdb-public.ns",
+		"crdb-public.ns.svc.cluster.local",
+		"*.crdb",
+		"*.crdb.ns",
+		"*.crdb.ns.svc.cluster.local",
+		"crdb.example.com",
+	}, rc.NodeHosts("ns"))
+
+	rc.PerPodSANReplicas = 2
+	assert.Equal(t, []string{
+		"localhost",
+		"127.0.0.1",
+		"crdb-public",
+		"crdb-public.ns",
+		"crdb-public.ns.svc.cluster.local",
+		"crdb-0",
+		"crdb-0.crdb",
+		"crdb-0.crdb.ns",
+		"crdb-0.crdb.ns.svc.cluster.local",
+		"crdb-1",
+		"crdb-1.crdb",
+		"crdb-1.crdb.ns",
+		"crdb-1.crdb.ns.svc.cluster.local",
+		"crdb.example.com",
+	}, rc.NodeHosts("ns"))
+}
+
+func TestPodHosts(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	rc.PublicServiceName = "crdb-public"
+	rc.DiscoveryServiceName = "crdb"
+	rc.ClusterDomain = "cluster.local"
+
+	assert.Equal(t, []string{
+		"localhost",
+		"127.0.0.1",
+		"crdb-public",
+		"crdb-public.ns",
+		"crdb-public.ns.svc.cluster.local",
+		"crdb-1.crdb",
+		"crdb-1.crdb.ns",
+		"crdb-1.crdb.ns.svc.cluster.local",
+	}, rc.PodHosts("ns", "crdb-1"))
+}
+
+func TestPartialRotation(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	failure := errors.New("failed to update client TLS secret certs")
+	assert.Equal(t, failure, rc.partialRotation(failure))
+
+	rc.rotated = []string{"crdb-ca-secret"}
+	err := rc.partialRotation(failure)
+
+	var partial *PartialRotationError
+	assert.True(t, errors.As(err, &partial))
+	assert.Equal(t, []string{"crdb-ca-secret"}, partial.Rotated)
+	assert.True(t, errors.Is(err, failure))
+}
+
+func TestForStatefulSet(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	rc.ClusterDomain = "cluster.local"
+	rc.rotated = []string{"crdb-ca-secret"}
+
+	release := rc.ForStatefulSet("tenant")
+	assert.Equal(t, "tenant", release.DiscoveryServiceName)
+	assert.Equal(t, "tenant-public", release.PublicServiceName)
+	assert.Equal(t, "tenant-node-secret", release.getNodeSecretName())
+	assert.Equal(t, "cluster.local", release.ClusterDomain)
+	assert.Empty(t, release.rotated)
+	assert.Empty(t, rc.DiscoveryServiceName)
+}
+
+func TestClientUser(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	rc.DiscoveryServiceName = "crdb"
+
+	user, secretName := rc.clientUser(rc.getClientSecretName())
+	assert.Equal(t, security.RootUser, user)
+	assert.Equal(t, "crdb-client-secret", secretName)
+
+	rc.ClientCertUser = "app"
+	user, secretName = rc.clientUser(rc.getClientSecretName())
+	assert.Equal(t, "app", user)
+	assert.Equal(t, "app-client-secret", secretName)
+	assert.Equal(t, "app", rc.ClientUser())
+}
+
+func TestThrottleRotation(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	assert.NoError(t, rc.throttleRotation(context.Background(), "ns"))
+
+	// the first rotation waits for the jitter of the namespace, the later ones of the run don't
+	rc.Throttle = throttle.New(time.Hour, 0, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(rc.throttleRotation(ctx, "ns"), context.DeadlineExceeded))
+	assert.NoError(t, rc.throttleRotation(ctx, "ns"))
+}
+
+func TestMissingDNSNames(t *testing.T) {
+	hosts := []string{"localhost", "127.0.0.1", "crdb-public", "*.crdb"}
+	assert.Equal(t, []string{"localhost", "*.crdb"}, missingDNSNames([]string{"crdb-public"}, hosts))
+	assert.Empty(t, missingDNSNames([]string{"localhost", "crdb-public", "*.crdb"}, hosts))
+}
+
+func TestClientPrincipal(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.CertsDir = t.TempDir()
+	rc.CAKey = filepath.Join(rc.CertsDir, resource.CaKey)
+	rc.KeySize = 2048
+	rc.ClientPrincipals = map[string]security.ClientPrincipal{
+		"app": {CommonName: "app@corp.example.com", DNSNames: []string{"app.corp.example.com"}},
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), []byte(testcerts.CACert), security.CertFileMode))
+	require.NoError(t, ioutil.WriteFile(rc.CAKey, []byte(testcerts.CAKey), security.KeyFileMode))
+
+	require.NoError(t, rc.generateUserClientCert(ctx, "app", "app-client-secret", "ns"))
+
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "app-client-secret"}, &secret))
+	cert, err := security.GetCertObj(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	assert.Equal(t, "app@corp.example.com", cert.Subject.CommonName)
+	assert.Equal(t, []string{"app.corp.example.com"}, cert.DNSNames)
+}
+
+func TestClientKeyAlgorithm(t *testing.T) {
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t)))
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	assert.Equal(t, security.RSAAlgorithm, rc.clientKeyAlgorithm(context.TODO(), "ns"))
+
+	// Ed25519 isn't used unless every node of the cluster is known to support it, whatever the version of the
+	// cockroach binary of the job
+	rc.ClientKeyAlgorithm = security.Ed25519Algorithm
+	assert.Equal(t, security.RSAAlgorithm, rc.clientKeyAlgorithm(context.TODO(), "ns"))
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/health"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+const defaultHTTPPort = 8080
+
+// clusterHealth returns the check of the nodes of the statefulset run before each pod restart.
+func (rc *GenerateCert) clusterHealth(namespace string) kube.HealthCheck {
+	return func(ctx context.Context) error {
+		checker, nodes, err := rc.nodeChecker(ctx, namespace)
+		if err != nil {
+			return err
+		}
+
+		return checker.Check(ctx, nodes)
+	}
+}
+
+// nodeChecker returns a checker of the nodes of the statefulset, along with the base URLs of their HTTP port.
+// The nodes are verified with the CA of the root client secret, which holds the old and new CA during a CA
+// rotation.
+func (rc *GenerateCert) nodeChecker(ctx context.Context, namespace string) (*health.Checker, []string, error) {
+	secretName, err := rc.currentSecretName(ctx, namespace, rc.getClientSecretName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret, err := resource.LoadTLSSecret(secretName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get root client secret")
+	}
+
+	checker, err := health.NewChecker(secret.CA())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replicas, err := rc.statefulSetReplicas(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	port := rc.HTTPPort
+	if port == 0 {
+		port = defaultHTTPPort
+	}
+
+	nodes := make([]string, replicas)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("https://%s:%d", rc.podFQDN(namespace, fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)), port)
+	}
+
+	return checker, nodes, nil
+}
+
+// clusterVersions returns the version of CockroachDB run by each node of the statefulset, e.g. v21.1.0, as
+// reported on its HTTP port. It fails if any node can't be queried, e.g. before the cluster is first started.
+func (rc *GenerateCert) clusterVersions(ctx context.Context, namespace string) ([]string, error) {
+	checker, nodes, err := rc.nodeChecker(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		tag, err := checker.Version(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// resumeRollout resumes the progressive rollout of the statefulset halted by a previous run, before any
+// other certificate is rotated.
+func (rc *GenerateCert) resumeRollout(ctx context.Context, namespace string) error {
+	if !rc.HealthChecks || rc.AnnotateStatefulSet {
+		return nil
+	}
+
+	pending, err := kube.RolloutPending(ctx, rc.client, rc.DiscoveryServiceName, namespace)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get statefulset [%s]", rc.DiscoveryServiceName)
+	}
+	if !pending {
+		return nil
+	}
+
+	logrus.Infof("Resuming the halted rollout of statefulset [%s]", rc.DiscoveryServiceName)
+	if err := kube.ProgressiveRollout(ctx, rc.client, rc.EvictionClient, rc.DiscoveryServiceName, namespace, rc.ReadinessWait,
+		rc.PodUpdateTimeout, rc.HealthTimeout, rc.clusterHealth(namespace)); err != nil {
+		return err
+	}
+
+	return rc.smokeTest(ctx, namespace)
+}
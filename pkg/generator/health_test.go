@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestResumeRollout(t *testing.T) {
+	ctx := context.TODO()
+	started := time.Now().Add(-time.Hour)
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns",
+				Annotations: map[string]string{kube.RolloutStarted: started.Format(time.RFC3339)}},
+			Status: appsv1.StatefulSetStatus{Replicas: 1, ReadyReplicas: 1},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "crdb-0", Namespace: "ns", CreationTimestamp: metav1.Now()}})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.PodUpdateTimeout, rc.HealthTimeout = time.Second, time.Second
+
+	// halted rollouts are only resumed with health checks
+	require.NoError(t, rc.resumeRollout(ctx, "ns"))
+	pending, err := kube.RolloutPending(ctx, cl, "crdb", "ns")
+	require.NoError(t, err)
+	assert.True(t, pending)
+
+	// the pod was restarted since, so the rollout completes without checking the cluster
+	rc.HealthChecks = true
+	require.NoError(t, rc.resumeRollout(ctx, "ns"))
+
+	var sts appsv1.StatefulSet
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb"}, &sts))
+	assert.NotContains(t, sts.Annotations, kube.RolloutStarted)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func (rc *GenerateCert) getIngressSecretName() string {
+	if rc.IngressSecretName != "" {
+		return rc.IngressSecretName
+	}
+	return rc.DiscoveryServiceName + "-ingress-secret"
+}
+
+// ingressIssuer returns the CA certificate and key signing the Ingress certificate, along with the PEM
+// encoded chain appended to the certificate. It is the user provided CA of IngressCASecret if set, such as
+// an intermediate CA of a public PKI, and the cluster CA otherwise.
+func (rc *GenerateCert) ingressIssuer(ctx context.Context, namespace string) (*x509.Certificate, crypto.Signer, []byte, error) {
+	if rc.IngressCASecret == "" {
+		if rc.signed() {
+			return nil, nil, nil, errors.New("the Ingress certificate can't be requested from the signer, set an Ingress CA secret")
+		}
+
+		pemCerts, err := ioutil.ReadFile(filepath.Join(rc.CertsDir, resource.CaCert))
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "unable to read ca.crt")
+		}
+
+		pemKey, err := ioutil.ReadFile(rc.CAKey)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "unable to read ca.key")
+		}
+
+		caCert, caKey, err := security.ParseCAPair(pemCerts, pemKey)
+		return caCert, caKey, nil, err
+	}
+
+	secret, err := resource.LoadTLSSecret(rc.IngressCASecret, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "failed to get Ingress CA secret [%s]", rc.IngressCASecret)
+	}
+
+	chain, pemKey := secret.TLSCert(), secret.TLSPrivateKey()
+	if len(chain) == 0 || len(pemKey) == 0 {
+		return nil, nil, nil, errors.Wrapf(resource.ErrInvalidSecret, "Ingress CA secret [%s] doesn't contain %s and %s",
+			rc.IngressCASecret, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+
+	rc.lintUserCerts(namespace, rc.IngressCASecret, chain)
+
+	caCert, caKey, err := security.ParseCAPair(chain, pemKey)
+	return caCert, caKey, chain, err
+}
+
+// generateIngressCert generates the certificate of the Ingress or Route fronting the cluster, for the
+// IngressHosts, and stores it in a kubernetes.io/tls secret. The secret is always updated in place, as the
+// Ingress refers to it by name.
+func (rc *GenerateCert) generateIngressCert(ctx context.Context, namespace string) error {
+	secretName := rc.getIngressSecretName()
+	r := resource.NewKubeResource(ctx, rc.client, namespace, rc.persister())
+
+	loaded, err := resource.LoadTLSSecret(secretName, r)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, "failed to get Ingress TLS secret")
+	}
+
+	operation := audit.Issue
+	if loaded.Ready() && loaded.ValidateAnnotations() {
+		isRequired, reason := loaded.IsRotationRequiredAt(rc.NodeCertConfig.Duration, rc.NodeAndClientCronSchedule, rc.now())
+		if rc.ACMEIssuer != nil && !isRequired {
+			isRequired, reason = acmeRenewalDue(loaded, rc.now())
+		}
+		// only the certificates signed by the cluster CA are affected by its recovery
+		if rc.ACMEIssuer == nil && rc.IngressCASecret == "" && !isRequired {
+			isRequired, reason = rc.recovered(loaded)
+		}
+		if !isRequired {
+			isRequired, reason = missingSANs(loaded, rc.IngressHosts)
+		}
+		if !rc.RotateNodeCert || !isRequired || rc.deferRotation(namespace, loaded, rc.NodeAndClientCronSchedule,
+			rc.NodeCertConfig.ExpiryWindow) {
+			logrus.Infof("Ingress secret [%s] is found in ready state, skipping Ingress cert generation", secretName)
+			rc.logFingerprints("in use", secretName, loaded.TLSCert())
+			return nil
+		}
+
+		logrus.Infof("Ingress Certificate: %s", reason)
+		operation = audit.Rotate
+		if err := rc.throttleRotation(ctx, namespace); err != nil {
+			return err
+		}
+	}
+
+	var pemCert, pemKey, chain, ca []byte
+	if rc.ACMEIssuer != nil {
+		pemCert, pemKey, chain, err = rc.issueACMECert(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		ca = chain
+	} else {
+		caCert, caKey, issuerChain, err := rc.ingressIssuer(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		chain = issuerChain
+
+		logrus.Info("Generating Ingress certificate")
+		pemCert, pemKey, err = security.CreateServerCert(caCert, caKey, rc.keys, rc.keySize(), rc.now(), rc.NodeCertConfig.Duration,
+			rc.IngressHosts)
+		if err != nil {
+			return errors.Wrap(err, "failed to generate Ingress certificate and key")
+		}
+
+		ca = chain
+		if ca == nil {
+			if ca, err = ioutil.ReadFile(filepath.Join(rc.CertsDir, resource.CaCert)); err != nil {
+				return errors.Wrap(err, "unable to read ca.crt")
+			}
+		}
+	}
+
+	validFrom, validUpto, err := rc.getCertLife(pemCert)
+	if err != nil {
+		return err
+	}
+
+	annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.NodeCertConfig.Duration.String(),
+		rc.NodeCertConfig.ExpiryWindow.String())
+
+	inputs := map[string]string{
+		"duration":     rc.NodeCertConfig.Duration.String(),
+		"expiryWindow": rc.NodeCertConfig.ExpiryWindow.String(),
+		"hosts":        strings.Join(rc.IngressHosts, ","),
+	}
+
+	// Ingress controllers serve tls.crt as is, so it holds the chain up to the issuing CA
+	secret := resource.CreateTLSSecret(secretName, corev1.SecretTypeTLS, r)
+	secret.ExpectResourceVersion(loaded.Secret().ResourceVersion)
+	secret.SetPolicyVersion(rc.PolicyVersion)
+	if err := secret.UpdateTLSSecret(append(pemCert, chain...), pemKey, ca, annotations); err != nil {
+		if modifiedConcurrently(err, secretName) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to update Ingress TLS secret certs")
+	}
+
+	logrus.Infof("Generated and saved Ingress key and certificate in secret [%s]", secretName)
+	if operation == audit.Rotate {
+		rc.rotated = append(rc.rotated, secretName)
+	}
+
+	return rc.recordIssued(ctx, operation, namespace, secretName, pemCert, inputs)
+}
+
+// acmeRenewBefore is the time before expiry at which ACME certificates are renewed. The lifetime of ACME
+// certificates is set by the CA, e.g. 90 days for Let's Encrypt, which recommends renewing them 30 days
+// before they expire.
+const acmeRenewBefore = 30 * 24 * time.Hour
+
+// acmeRenewalDue returns true if the ACME certificate of the secret expires within acmeRenewBefore of now.
+func acmeRenewalDue(secret *resource.TLSSecret, now time.Time) (bool, string) {
+	validUpto, err := time.Parse(time.RFC3339, secret.Secret().Annotations[resource.CertValidUpto])
+	if err != nil || validUpto.Sub(now) < acmeRenewBefore {
+		return true, "ACME certificate about to expire, renewing certificate"
+	}
+	return false, ""
+}
+
+// issueACMECert requests the Ingress certificate from the ACME CA. It returns the certificate, its key and
+// the chain of the CA.
+func (rc *GenerateCert) issueACMECert(ctx context.Context, namespace string) (pemCert, pemKey, chain []byte, err error) {
+	if rc.ACMEIssuer.AccountKey == nil {
+		if rc.ACMEIssuer.AccountKey, err = rc.loadACMEAccountKey(ctx, namespace); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	key, pemKey, err := rc.keys.GenerateKey(security.RSAAlgorithm, rc.keySize())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	logrus.Info("Requesting Ingress certificate from ACME CA")
+	bundle, err := rc.ACMEIssuer.Issue(ctx, rc.IngressHosts, key)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to issue ACME certificate")
+	}
+
+	block, rest := pem.Decode(bundle)
+	if block == nil {
+		return nil, nil, nil, errors.New("the ACME CA returned no certificate")
+	}
+
+	return pem.EncodeToMemory(block), pemKey, rest, nil
+}
+
+// loadACMEAccountKey returns the key of the ACME account stored in the <statefulset>-acme-account secret,
+// generating it on first use so that later runs reuse the same account.
+func (rc *GenerateCert) loadACMEAccountKey(ctx context.Context, namespace string) (crypto.Signer, error) {
+	secret := &corev1.Secret{}
+	secret.SetName(rc.DiscoveryServiceName + "-acme-account")
+	secret.SetNamespace(namespace)
+
+	_, err := rc.persister()(ctx, rc.client, secret, func() error {
+		if len(secret.Data[corev1.TLSPrivateKeyKey]) > 0 {
+			return nil
+		}
+
+		_, pemKey, err := rc.keys.GenerateKey(security.RSAAlgorithm, rc.keySize())
+		if err != nil {
+			return err
+		}
+
+		logrus.Infof("Generated ACME account key in secret [%s]", secret.Name)
+		secret.Data = map[string][]byte{corev1.TLSPrivateKeyKey: pemKey}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store ACME account key")
+	}
+
+	return security.ParsePrivateKey(secret.Data[corev1.TLSPrivateKeyKey])
+}
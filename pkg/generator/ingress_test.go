@@ -0,0 +1,71 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestGenerateIngressCertWithProvidedCA(t *testing.T) {
+	issuer := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "public-ca", Namespace: "ns"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(testcerts.CACert),
+			corev1.TLSPrivateKeyKey: []byte(testcerts.CAKey),
+		},
+	}
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), issuer)
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.NodeCertConfig.Duration = time.Hour
+	rc.IngressHosts = []string{"crdb.example.com"}
+	rc.IngressCASecret = "public-ca"
+
+	require.NoError(t, rc.generateIngressCert(context.TODO(), "ns"))
+
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-ingress-secret"}, &secret))
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+
+	// the certificate is followed by the chain of the issuer
+	cert, err := security.GetCertObj(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb.example.com"}, cert.DNSNames)
+	assert.Contains(t, string(secret.Data[corev1.TLSCertKey]), testcerts.CACert)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM([]byte(testcerts.CACert)))
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "crdb.example.com", Roots: roots})
+	assert.NoError(t, err)
+}
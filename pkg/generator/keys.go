@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// expectedKeys returns the number of RSA keys generated in process when all the certificates of the release
+// are issued. The keys of the node and client certificates created by the cockroach CLI aren't counted.
+func (rc *GenerateCert) expectedKeys(ctx context.Context, namespace string) int {
+	nodes := 1
+	if rc.PerNodeCerts {
+		replicas, err := rc.statefulSetReplicas(ctx, namespace)
+		if err != nil {
+			// the error is reported when the node certificates are generated
+			replicas = 1
+		}
+		nodes = replicas
+	}
+
+	keys := 0
+	if (rc.signed() || rc.NodeProfile != nil) && rc.SPIRE == nil {
+		keys += nodes
+	}
+	if rc.ClientKeyAlgorithm != security.Ed25519Algorithm {
+		for _, user := range append([]string{security.RootUser}, rc.ClientUsers...) {
+			// the certificates of the users mapped to a principal are created in process too
+			_, mapped := rc.ClientPrincipals[user]
+			if mapped || rc.ClientSubject != nil || rc.signed() || rc.ClientProfile != nil {
+				keys++
+			}
+		}
+	}
+	if rc.UICert {
+		keys++
+	}
+	if len(rc.IngressHosts) > 0 {
+		keys++
+	}
+	keys += len(rc.Tenants)
+	if len(rc.SQLProxyHosts) > 0 {
+		keys++
+	}
+	return keys
+}
+
+// startKeyPool starts generating the keys of the certificates on KeyWorkers goroutines while the CA is
+// loaded or created, so that they are ready when the certificates are signed. It returns the func stopping
+// the pool, which must be called once the certificates are issued. The keys of the certificates which
+// don't need to be rotated are discarded.
+func (rc *GenerateCert) startKeyPool(ctx context.Context, namespace string) func() {
+	count := rc.expectedKeys(ctx, namespace)
+	if rc.KeyWorkers <= 0 || count == 0 {
+		return func() {}
+	}
+
+	logrus.Debugf("Generating up to %d keys on %d workers", count, rc.KeyWorkers)
+	pool := security.StartKeyPool(rc.keySize(), rc.KeyWorkers, count)
+	rc.keys = pool
+	return func() {
+		pool.Stop()
+		rc.keys = nil
+	}
+}
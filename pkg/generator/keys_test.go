@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestExpectedKeys(t *testing.T) {
+	ctx := context.TODO()
+	replicas := int32(3)
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+
+	// the keys of the node and client certificates are created by the cockroach CLI
+	assert.Equal(t, 0, rc.expectedKeys(ctx, "ns"))
+
+	rc.UICert = true
+	rc.IngressHosts = []string{"crdb.example.com"}
+	rc.Tenants = []Tenant{{ID: 2}, {ID: 3}}
+	rc.SQLProxyHosts = []string{"sqlproxy"}
+	assert.Equal(t, 5, rc.expectedKeys(ctx, "ns"))
+
+	// the node certificates with a profile are created in process, one per pod with per-node certificates
+	rc.NodeProfile = &security.Profile{}
+	rc.PerNodeCerts = true
+	assert.Equal(t, 8, rc.expectedKeys(ctx, "ns"))
+
+	// the client certificates of the users mapped to a principal are created in process
+	rc.ClientUsers = []string{"app", "reporting"}
+	rc.ClientPrincipals = map[string]security.ClientPrincipal{"app": {CommonName: "app@corp.example.com"}}
+	assert.Equal(t, 9, rc.expectedKeys(ctx, "ns"))
+	rc.ClientPrincipals = nil
+
+	// Ed25519 client keys aren't pooled
+	rc.ClientProfile = &security.Profile{}
+	rc.ClientUsers = []string{"app"}
+	assert.Equal(t, 10, rc.expectedKeys(ctx, "ns"))
+	rc.ClientKeyAlgorithm = security.Ed25519Algorithm
+	assert.Equal(t, 8, rc.expectedKeys(ctx, "ns"))
+}
+
+func TestStartKeyPool(t *testing.T) {
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t)))
+	rc.KeySize = 1024
+	rc.UICert = true
+
+	// no pool is started without workers
+	stop := rc.startKeyPool(context.TODO(), "ns")
+	assert.Nil(t, rc.keys)
+	stop()
+
+	rc.KeyWorkers = 2
+	stop = rc.startKeyPool(context.TODO(), "ns")
+	assert.NotNil(t, rc.keys.Take(1024))
+
+	// the pool is owned by the run, so another release run at the same time doesn't take its keys
+	other := rc.ForStatefulSet("other")
+	assert.Nil(t, other.keys)
+
+	stop()
+	assert.Nil(t, rc.keys)
+}
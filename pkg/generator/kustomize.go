@@ -0,0 +1,316 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+// Files of the Kustomize component.
+const (
+	KustomizationFile    = "kustomization.yaml"
+	SelfSignerFile       = "self-signer.yaml"
+	StatefulSetPatchFile = "statefulset-certs.yaml"
+	NodeCertsFile        = "node-certs.yaml"
+)
+
+// kustomizeConfigDir is the directory the files of the config secret are mounted at in the job.
+const kustomizeConfigDir = "/etc/self-signer"
+
+// KustomizeOptions sets the self-signer deployed by the Kustomize component.
+type KustomizeOptions struct {
+	// Image is the image of the self-signer.
+	Image string
+	// Args are the flags the generate job runs with, along with a --<name>=<mount path> flag for each of
+	// the ConfigFiles.
+	Args []string
+	// ConfigFiles are the contents of the files read by the self-signer, keyed by the name of their flag,
+	// e.g. config or values. They are stored in a secret of the component's secretGenerator.
+	ConfigFiles map[string][]byte
+	// Container is the name of the CockroachDB container of the statefulset, which reads its certificates
+	// from /cockroach/cockroach-certs.
+	Container string
+	// ServiceAccount is the service account of the CockroachDB pods, which read their node secret with
+	// per-node certificates.
+	ServiceAccount string
+}
+
+// KustomizeComponent returns the files of a Kustomize component, keyed by name, which deploys a job
+// generating the certificates of the configuration, with the permissions it needs, and patches the
+// statefulset to mount them, so that a CockroachDB deployed with Kustomize gets the PKI management of the
+// self-signer without the chart.
+func (rc *GenerateCert) KustomizeComponent(opts KustomizeOptions) (map[string][]byte, error) {
+	fullname := rc.DiscoveryServiceName + "-self-signer"
+	configSecret := fullname + "-config"
+
+	kustomization := map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1alpha1",
+		"kind":       "Component",
+		"resources":  []string{SelfSignerFile},
+		"patches":    []map[string]string{{"path": StatefulSetPatchFile}},
+	}
+
+	files := map[string][]byte{}
+	args := append([]string{"generate"}, opts.Args...)
+	if len(opts.ConfigFiles) > 0 {
+		var flags, names []string
+		for flag := range opts.ConfigFiles {
+			flags = append(flags, flag)
+		}
+		sort.Strings(flags)
+
+		for _, flag := range flags {
+			name := flag + ".yaml"
+			files[name] = opts.ConfigFiles[flag]
+			names = append(names, name)
+			args = append(args, fmt.Sprintf("--%s=%s", flag, path.Join(kustomizeConfigDir, name)))
+		}
+
+		// the job refers to the secret by its name, as jobs can't be updated with the hash of its contents
+		kustomization["secretGenerator"] = []map[string]interface{}{{
+			"name":    configSecret,
+			"files":   names,
+			"options": map[string]bool{"disableNameSuffixHash": true},
+		}}
+	}
+
+	selfSigner, err := rc.kustomizeSelfSigner(fullname, configSecret, opts.Image, args, len(opts.ConfigFiles) > 0)
+	if err != nil {
+		return nil, err
+	}
+	files[SelfSignerFile] = selfSigner
+
+	patch, err := rc.kustomizeStatefulSetPatch(opts)
+	if err != nil {
+		return nil, err
+	}
+	files[StatefulSetPatchFile] = patch
+
+	if rc.PerNodeCerts {
+		// the pods copy their certificate from their node secret
+		kustomization["resources"] = []string{SelfSignerFile, NodeCertsFile}
+		nodeCerts, err := rc.kustomizeNodeCertsAccess(opts.ServiceAccount)
+		if err != nil {
+			return nil, err
+		}
+		files[NodeCertsFile] = nodeCerts
+	}
+
+	if files[KustomizationFile], err = yaml.Marshal(kustomization); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the kustomization")
+	}
+	return files, nil
+}
+
+// kustomizeSelfSigner returns the service account, role, role binding and job generating the certificates.
+func (rc *GenerateCert) kustomizeSelfSigner(fullname, configSecret, image string, args []string, config bool) ([]byte, error) {
+	sa := &corev1.ServiceAccount{}
+	sa.APIVersion, sa.Kind = "v1", "ServiceAccount"
+	sa.Name = fullname
+
+	role := &rbacv1.Role{Rules: policyRules(rc.requiredPermissions(false))}
+	role.APIVersion, role.Kind = rbacv1.SchemeGroupVersion.String(), "Role"
+	role.Name = fullname
+
+	binding := roleBinding(fullname, fullname)
+
+	container := corev1.Container{
+		Name:  "cert-generate-job",
+		Image: image,
+		Args:  args,
+		Env: []corev1.EnvVar{
+			{Name: "STATEFULSET_NAME", Value: rc.DiscoveryServiceName},
+			{Name: "NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+			{Name: "CLUSTER_DOMAIN", Value: rc.ClusterDomain},
+		},
+	}
+
+	job := &batchv1.Job{}
+	job.APIVersion, job.Kind = batchv1.SchemeGroupVersion.String(), "Job"
+	job.Name = fullname
+	job.Spec.Template.Spec.ServiceAccountName = fullname
+	job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	if config {
+		container.VolumeMounts = []corev1.VolumeMount{{Name: "config", MountPath: kustomizeConfigDir, ReadOnly: true}}
+		job.Spec.Template.Spec.Volumes = []corev1.Volume{{
+			Name:         "config",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: configSecret}},
+		}}
+	}
+	job.Spec.Template.Spec.Containers = []corev1.Container{container}
+
+	return manifests(sa, role, binding, job)
+}
+
+// kustomizeStatefulSetPatch returns the strategic merge patch of the statefulset copying the certificates
+// of its pods to the certs directory of CockroachDB, as the chart does.
+func (rc *GenerateCert) kustomizeStatefulSetPatch(opts KustomizeOptions) ([]byte, error) {
+	const mode = int32(0400)
+
+	var sources []corev1.VolumeProjection
+	if !rc.PerNodeCerts {
+		sources = append(sources, corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{Name: rc.getNodeSecretName()},
+			Items: []corev1.KeyToPath{
+				{Key: "ca.crt", Path: "ca.crt", Mode: &[]int32{mode}[0]},
+				{Key: corev1.TLSCertKey, Path: "node.crt", Mode: &[]int32{mode}[0]},
+				{Key: corev1.TLSPrivateKeyKey, Path: "node.key", Mode: &[]int32{mode}[0]},
+			},
+		}})
+	}
+	if rc.UICert {
+		sources = append(sources, corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{Name: rc.getUISecretName()},
+			Items: []corev1.KeyToPath{
+				{Key: corev1.TLSCertKey, Path: "ui.crt", Mode: &[]int32{mode}[0]},
+				{Key: corev1.TLSPrivateKeyKey, Path: "ui.key", Mode: &[]int32{mode}[0]},
+			},
+		}})
+	}
+
+	volumes := []corev1.Volume{{Name: "certs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	var initContainers []corev1.Container
+	if rc.PerNodeCerts {
+		initContainers = append(initContainers, corev1.Container{
+			Name:  "request-cert",
+			Image: opts.Image,
+			Args:  []string{"request-cert", "--from-secret", "--certs-dir=/cockroach-certs"},
+			Env: []corev1.EnvVar{
+				{Name: "STATEFULSET_NAME", Value: rc.DiscoveryServiceName},
+				{Name: "NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+				{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+				{Name: "CLUSTER_DOMAIN", Value: rc.ClusterDomain},
+			},
+			VolumeMounts: []corev1.VolumeMount{{Name: "certs", MountPath: "/cockroach-certs/"}},
+		})
+	}
+	if len(sources) > 0 {
+		volumes = append(volumes, corev1.Volume{Name: "certs-secret",
+			VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: sources}}})
+		initContainers = append(initContainers, corev1.Container{
+			Name:    "copy-certs",
+			Image:   "busybox",
+			Command: []string{"/bin/sh", "-c", "cp -f /certs/* /cockroach-certs/; chmod 0400 /cockroach-certs/*.key"},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "certs", MountPath: "/cockroach-certs/"},
+				{Name: "certs-secret", MountPath: "/certs/"},
+			},
+		})
+	}
+
+	container := corev1.Container{
+		Name:         opts.Container,
+		VolumeMounts: []corev1.VolumeMount{{Name: "certs", MountPath: "/cockroach/cockroach-certs/"}},
+	}
+
+	podSpec := map[string]interface{}{
+		"initContainers": initContainers,
+		"containers":     []corev1.Container{container},
+		"volumes":        volumes,
+	}
+	patch := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "StatefulSet",
+		"metadata":   map[string]string{"name": rc.DiscoveryServiceName},
+		"spec":       map[string]interface{}{"template": map[string]interface{}{"spec": podSpec}},
+	}
+
+	out, err := yaml.Marshal(patch)
+	return out, errors.Wrap(err, "failed to marshal the statefulset patch")
+}
+
+// kustomizeNodeCertsAccess returns the role and role binding allowing the service account of the pods to
+// read their node secret with per-node certificates.
+func (rc *GenerateCert) kustomizeNodeCertsAccess(serviceAccount string) ([]byte, error) {
+	name := rc.DiscoveryServiceName + "-node-certs"
+
+	// the secrets of the pods added by a scale up can't be listed in advance
+	role := &rbacv1.Role{Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}}}
+	role.APIVersion, role.Kind = rbacv1.SchemeGroupVersion.String(), "Role"
+	role.Name = name
+
+	return manifests(role, roleBinding(name, serviceAccount))
+}
+
+func roleBinding(role, serviceAccount string) *rbacv1.RoleBinding {
+	binding := &rbacv1.RoleBinding{
+		RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role},
+		Subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount}},
+	}
+	binding.APIVersion, binding.Kind = rbacv1.SchemeGroupVersion.String(), "RoleBinding"
+	binding.Name = role
+	return binding
+}
+
+// policyRules returns the RBAC rules granting the permissions, with one rule per resource and object.
+func policyRules(permissions []kube.Permission) []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	index := map[string]int{}
+	for _, p := range permissions {
+		resource := p.Resource
+		if p.Subresource != "" {
+			resource += "/" + p.Subresource
+		}
+
+		key := strings.Join([]string{p.Group, resource, p.Name}, "/")
+		i, ok := index[key]
+		if !ok {
+			rule := rbacv1.PolicyRule{APIGroups: []string{p.Group}, Resources: []string{resource}}
+			if p.Name != "" {
+				rule.ResourceNames = []string{p.Name}
+			}
+			index[key], i = len(rules), len(rules)
+			rules = append(rules, rule)
+		}
+		rules[i].Verbs = append(rules[i].Verbs, p.Verb)
+	}
+	return rules
+}
+
+// manifests returns the objects as a multi-document YAML, without their empty status and creation time.
+func manifests(objs ...runtime.Object) ([]byte, error) {
+	var docs []string
+	for _, obj := range objs {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert the manifest")
+		}
+		unstructured.RemoveNestedField(u, "status")
+		unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+		unstructured.RemoveNestedField(u, "spec", "template", "metadata", "creationTimestamp")
+
+		doc, err := yaml.Marshal(u)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal the manifest")
+		}
+		docs = append(docs, string(doc))
+	}
+	return []byte(strings.Join(docs, "---\n")), nil
+}
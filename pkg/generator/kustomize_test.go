@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+func TestKustomizeComponent(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	rc.DiscoveryServiceName = "crdb"
+	rc.ClusterDomain = "cluster.local"
+	rc.UICert = true
+
+	files, err := rc.KustomizeComponent(KustomizeOptions{
+		Image:       "self-signer:test",
+		Args:        []string{"--ui-cert=true"},
+		ConfigFiles: map[string][]byte{"config": []byte("node:\n  perPodSANReplicas: 3\n")},
+		Container:   "db",
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, files, NodeCertsFile)
+	assert.Equal(t, "node:\n  perPodSANReplicas: 3\n", string(files["config.yaml"]))
+
+	var kustomization map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(files[KustomizationFile], &kustomization))
+	assert.Equal(t, "Component", kustomization["kind"])
+	assert.Equal(t, []interface{}{SelfSignerFile}, kustomization["resources"])
+	generators := kustomization["secretGenerator"].([]interface{})
+	require.Len(t, generators, 1)
+	assert.Equal(t, "crdb-self-signer-config", generators[0].(map[string]interface{})["name"])
+
+	docs := strings.Split(string(files[SelfSignerFile]), "---\n")
+	require.Len(t, docs, 4)
+
+	var role rbacv1.Role
+	require.NoError(t, yaml.Unmarshal([]byte(docs[1]), &role))
+	assert.Equal(t, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"},
+		Verbs: []string{"get", "create", "patch", "update"}}, role.Rules[0])
+
+	var job batchv1.Job
+	require.NoError(t, yaml.Unmarshal([]byte(docs[3]), &job))
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "self-signer:test", container.Image)
+	assert.Equal(t, []string{"generate", "--ui-cert=true", "--config=/etc/self-signer/config.yaml"}, container.Args)
+	assert.Equal(t, "crdb-self-signer-config", job.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+
+	var sts appsv1.StatefulSet
+	require.NoError(t, yaml.Unmarshal(files[StatefulSetPatchFile], &sts))
+	assert.Equal(t, "crdb", sts.Name)
+	require.Len(t, sts.Spec.Template.Spec.InitContainers, 1)
+	assert.Equal(t, "copy-certs", sts.Spec.Template.Spec.InitContainers[0].Name)
+	sources := sts.Spec.Template.Spec.Volumes[1].Projected.Sources
+	require.Len(t, sources, 2)
+	assert.Equal(t, "crdb-node-secret", sources[0].Secret.Name)
+	assert.Equal(t, "crdb-ui-secret", sources[1].Secret.Name)
+	assert.Equal(t, "db", sts.Spec.Template.Spec.Containers[0].Name)
+}
+
+func TestKustomizeComponentPerNodeCerts(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	rc.DiscoveryServiceName = "crdb"
+	rc.PerNodeCerts = true
+
+	files, err := rc.KustomizeComponent(KustomizeOptions{Image: "self-signer:test", Container: "db", ServiceAccount: "crdb-sa"})
+	require.NoError(t, err)
+	assert.Contains(t, string(files[KustomizationFile]), NodeCertsFile)
+	assert.NotContains(t, string(files[KustomizationFile]), "secretGenerator")
+
+	docs := strings.Split(string(files[NodeCertsFile]), "---\n")
+	require.Len(t, docs, 2)
+	var binding rbacv1.RoleBinding
+	require.NoError(t, yaml.Unmarshal([]byte(docs[1]), &binding))
+	assert.Equal(t, "crdb-sa", binding.Subjects[0].Name)
+
+	// the pods copy their certificate from their node secret, and no secret is projected
+	var sts appsv1.StatefulSet
+	require.NoError(t, yaml.Unmarshal(files[StatefulSetPatchFile], &sts))
+	require.Len(t, sts.Spec.Template.Spec.InitContainers, 1)
+	assert.Equal(t, "request-cert", sts.Spec.Template.Spec.InitContainers[0].Name)
+	assert.Len(t, sts.Spec.Template.Spec.Volumes, 1)
+}
+
+func TestPolicyRules(t *testing.T) {
+	rules := policyRules([]kube.Permission{
+		{Verb: "get", Resource: "secrets"},
+		{Verb: "get", Group: "apps", Resource: "statefulsets", Name: "crdb"},
+		{Verb: "create", Resource: "secrets"},
+		{Verb: "create", Resource: "pods", Subresource: "eviction"},
+		{Verb: "update", Group: "apps", Resource: "statefulsets", Name: "crdb"},
+	})
+
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get", "create"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, ResourceNames: []string{"crdb"}, Verbs: []string{"get", "update"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/eviction"}, Verbs: []string{"create"}},
+	}, rules)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// Checks of the weak crypto linter of the user provided certificates.
+const (
+	LintWeakSignature = "weak-signature"
+	LintSmallKey      = "small-key"
+	LintLongValidity  = "long-validity"
+)
+
+// lintChecks are all the checks of the linter.
+var lintChecks = []string{LintWeakSignature, LintSmallKey, LintLongValidity}
+
+// Default maximum validities of the linter. The leaf certificates follow the former CA/Browser Forum limit.
+const (
+	DefaultLintMaxValidity   = 825 * 24 * time.Hour
+	DefaultLintMaxCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+// LintOptions are the thresholds of the weak crypto linter of the user provided certificates.
+type LintOptions struct {
+	// MinRSABits and MinECDSABits are the smallest RSA key and ECDSA curve sizes which are not reported,
+	// DefaultMinRSABits and DefaultMinECDSABits if zero.
+	MinRSABits   int
+	MinECDSABits int
+	// MaxValidity and MaxCAValidity are the longest validities of the leaf and CA certificates which are
+	// not reported, DefaultLintMaxValidity and DefaultLintMaxCAValidity if zero.
+	MaxValidity   time.Duration
+	MaxCAValidity time.Duration
+}
+
+// withDefaults returns the options with the defaults of the thresholds left unset.
+func (o LintOptions) withDefaults() LintOptions {
+	if o.MinRSABits == 0 {
+		o.MinRSABits = DefaultMinRSABits
+	}
+	if o.MinECDSABits == 0 {
+		o.MinECDSABits = DefaultMinECDSABits
+	}
+	if o.MaxValidity == 0 {
+		o.MaxValidity = DefaultLintMaxValidity
+	}
+	if o.MaxCAValidity == 0 {
+		o.MaxCAValidity = DefaultLintMaxCAValidity
+	}
+	return o
+}
+
+// LintCert returns the findings of the weak crypto checks of the certificate of the secret: a signature
+// relying on a broken hash, a key smaller than the minimum size, or a validity longer than the maximum.
+func LintCert(secret string, cert *x509.Certificate, opts LintOptions) []Finding {
+	opts = opts.withDefaults()
+	finding := func(check, format string, args ...interface{}) Finding {
+		return Finding{Secret: secret, Check: check, Message: fmt.Sprintf("certificate %q %s", cert.Subject.CommonName,
+			fmt.Sprintf(format, args...))}
+	}
+
+	var findings []Finding
+	if weakSignatures[cert.SignatureAlgorithm] {
+		findings = append(findings, finding(LintWeakSignature, "is signed with %s", cert.SignatureAlgorithm))
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := key.N.BitLen(); size < opts.MinRSABits {
+			findings = append(findings, finding(LintSmallKey, "has an RSA key of %d bits, smaller than %d bits",
+				size, opts.MinRSABits))
+		}
+	case *ecdsa.PublicKey:
+		if size := key.Curve.Params().BitSize; size < opts.MinECDSABits {
+			findings = append(findings, finding(LintSmallKey, "has an ECDSA key of %d bits, smaller than %d bits",
+				size, opts.MinECDSABits))
+		}
+	}
+
+	maxValidity := opts.MaxValidity
+	if cert.IsCA {
+		maxValidity = opts.MaxCAValidity
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity > maxValidity {
+		findings = append(findings, finding(LintLongValidity, "is valid for %s, longer than %s",
+			validity.Round(time.Hour), maxValidity))
+	}
+
+	return findings
+}
+
+// lintUserCerts checks the certificates of the PEM data of a user provided secret, such as the CA secret
+// given by the user or the adopted certificates, for weak crypto. The findings are logged as warnings and
+// recorded in the weakCryptoFindings metric, they don't prevent the certificates from being used.
+func (rc *GenerateCert) lintUserCerts(namespace, secret string, pemCerts ...[]byte) []Finding {
+	counts := map[string]int{}
+	var findings []Finding
+	for _, pem := range pemCerts {
+		if len(pem) == 0 {
+			continue
+		}
+
+		// invalid certificates are reported when they are used
+		certs, err := security.ParseCerts(pem)
+		if err != nil {
+			continue
+		}
+		for _, cert := range certs {
+			for _, f := range LintCert(secret, cert, rc.Lint) {
+				logrus.Warnf("User provided secret [%s] has weak crypto: %s", secret, f.Message)
+				counts[f.Check]++
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	for _, check := range lintChecks {
+		weakCryptoFindings.WithLabelValues(namespace, secret, check).Set(float64(counts[check]))
+	}
+	return findings
+}
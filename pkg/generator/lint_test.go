@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+// legacyCert returns a self-signed certificate with a 1024 bits RSA key signed with SHA-1.
+func legacyCert(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "legacy"},
+		NotBefore:          time.Now(),
+		NotAfter:           time.Now().Add(5 * 365 * 24 * time.Hour),
+		SignatureAlgorithm: x509.SHA1WithRSA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestLintCert(t *testing.T) {
+	cert, err := security.GetCertObj(legacyCert(t))
+	require.NoError(t, err)
+
+	var checks []string
+	for _, f := range LintCert("legacy-secret", cert, LintOptions{}) {
+		assert.Equal(t, "legacy-secret", f.Secret)
+		checks = append(checks, f.Check)
+	}
+	assert.Equal(t, []string{LintWeakSignature, LintSmallKey, LintLongValidity}, checks)
+
+	findings := LintCert("legacy-secret", cert, LintOptions{MinRSABits: 1024, MaxValidity: 10 * 365 * 24 * time.Hour})
+	require.Len(t, findings, 1)
+	assert.Equal(t, LintWeakSignature, findings[0].Check)
+	assert.Equal(t, `certificate "legacy" is signed with SHA1-RSA`, findings[0].Message)
+
+	// the test CA is valid for a century, longer than the CA limit
+	ca, err := security.GetCertObj([]byte(testcerts.CACert))
+	require.NoError(t, err)
+	findings = LintCert("ca-secret", ca, LintOptions{})
+	require.Len(t, findings, 1)
+	assert.Equal(t, LintLongValidity, findings[0].Check)
+	assert.Empty(t, LintCert("ca-secret", ca, LintOptions{MaxCAValidity: 101 * 365 * 24 * time.Hour}))
+}
+
+func TestLintUserCASecret(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-ca", Namespace: "linted"},
+		Data: map[string][]byte{
+			resource.CaCert: []byte(testcerts.CACert),
+			resource.CaKey:  []byte(testcerts.CAKey),
+		},
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.CertsDir = t.TempDir()
+	rc.CAKey = filepath.Join(rc.CertsDir, resource.CaKey)
+	rc.CaSecret = "user-ca"
+
+	// the findings don't prevent the CA from being used
+	require.NoError(t, rc.LoadCASecret(ctx, "linted"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(weakCryptoFindings.WithLabelValues("linted", "user-ca", LintLongValidity)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(weakCryptoFindings.WithLabelValues("linted", "user-ca", LintSmallKey)))
+
+	// the metric is cleared once the thresholds are met
+	rc.Lint.MaxCAValidity = 101 * 365 * 24 * time.Hour
+	require.NoError(t, rc.LoadCASecret(ctx, "linted"))
+	assert.Equal(t, float64(0), testutil.ToFloat64(weakCryptoFindings.WithLabelValues("linted", "user-ca", LintLongValidity)))
+}
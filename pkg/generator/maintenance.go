@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// deferRotation returns true if the rotation of the secret is deferred because no MaintenanceWindow is open.
+// The rotation is deferred to the first run of cronStr inside a window, so rotations which can't wait for it,
+// as the certificate enters its expiryWindow before or was revoked, the rotations requested with the
+// RotateRequested annotation and the recovery of an expired CA are never deferred.
+func (rc *GenerateCert) deferRotation(namespace string, secret *resource.TLSSecret, cronStr string,
+	expiryWindow time.Duration) bool {
+	if rc.MaintenanceWindow == nil || rc.recovering || secret.RotationRequested() {
+		return false
+	}
+
+	now := rc.now()
+	if rc.MaintenanceWindow.Open(now) {
+		return false
+	}
+	if revoked, _ := rc.revoked(secret); revoked {
+		return false
+	}
+
+	name := secret.Secret().Name
+	validUpto, err := time.Parse(time.RFC3339, secret.Secret().Annotations[resource.CertValidUpto])
+	if err != nil {
+		logrus.Warnf("Failed to verify expiry date of secret [%s], rotating it outside of the maintenance window", name)
+		return false
+	}
+
+	schedule, err := cron.ParseStandard(cronStr)
+	if err != nil {
+		logrus.Warnf("Invalid cron [%s], rotating secret [%s] outside of the maintenance window", cronStr, name)
+		return false
+	}
+
+	run, ok := rc.MaintenanceWindow.NextRun(schedule, now, validUpto.Add(-expiryWindow))
+	if !ok {
+		logrus.Warnf("Secret [%s] expires before a run inside a maintenance window, rotating it outside of the window",
+			name)
+		return false
+	}
+
+	logrus.Infof("Deferring rotation of secret [%s] to the run inside the maintenance window at %s", name,
+		run.Format(time.RFC3339))
+	deferredRotations.WithLabelValues(namespace, name).Inc()
+	return true
+}
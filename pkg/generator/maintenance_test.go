@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+	"github.com/cockroachdb/helm-charts/pkg/window"
+)
+
+func TestDeferRotation(t *testing.T) {
+	now := clock.NewFake(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	validUpto := func(d time.Duration) map[string]string {
+		return map[string]string{resource.CertValidUpto: now.Now().Add(d).Format(time.RFC3339)}
+	}
+	requested := validUpto(24 * time.Hour)
+	requested[resource.RotateRequested] = "true"
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "valid", Namespace: "ns", Annotations: validUpto(24 * time.Hour)}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "expiring", Namespace: "ns", Annotations: validUpto(time.Minute)}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "requested", Namespace: "ns", Annotations: requested}},
+		// expiring on saturday at 5am
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "saturday", Namespace: "ns",
+			Annotations: validUpto(3*24*time.Hour + 17*time.Hour)}})
+	r := resource.NewKubeResource(context.TODO(), cl, "ns", kube.DefaultPersister)
+
+	load := func(name string) *resource.TLSSecret {
+		secret, err := resource.LoadTLSSecret(name, r)
+		require.NoError(t, err)
+		return secret
+	}
+
+	rc := NewGenerateCert(cl)
+	rc.Clock = now
+	assert.False(t, rc.deferRotation("ns", load("valid"), "*/5 * * * *", 0))
+
+	// the window opening in an hour
+	w, err := window.Parse("0 13 * * *", 10*time.Minute)
+	require.NoError(t, err)
+	rc.MaintenanceWindow = w
+
+	before := testutil.ToFloat64(deferredRotations.WithLabelValues("ns", "valid"))
+	assert.True(t, rc.deferRotation("ns", load("valid"), "*/5 * * * *", 0))
+	assert.Equal(t, before+1, testutil.ToFloat64(deferredRotations.WithLabelValues("ns", "valid")))
+
+	assert.False(t, rc.deferRotation("ns", load("expiring"), "*/5 * * * *", 0))
+	assert.False(t, rc.deferRotation("ns", load("requested"), "*/5 * * * *", 0))
+
+	// once the window opened
+	now.Advance(61 * time.Minute)
+	assert.False(t, rc.deferRotation("ns", load("valid"), "*/5 * * * *", 0))
+
+	// the run in the window opening in an hour is too late once the certificate is in its expiry window
+	assert.False(t, rc.deferRotation("ns", load("valid"), "*/5 * * * *", 23*time.Hour+30*time.Minute))
+
+	// saturdays from 2am to 3am
+	w, err = window.Parse("0 2 * * SAT", time.Hour)
+	require.NoError(t, err)
+	rc.MaintenanceWindow = w
+	assert.True(t, rc.deferRotation("ns", load("saturday"), "*/5 * * * *", 2*time.Hour))
+	assert.False(t, rc.deferRotation("ns", load("saturday"), "*/5 * * * *", 4*time.Hour))
+
+	// no daily run at midnight falls inside the window before the certificate expires
+	assert.False(t, rc.deferRotation("ns", load("saturday"), "0 0 * * *", 0))
+}
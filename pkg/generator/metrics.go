@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The metrics of the generator are served on the metrics endpoint of the controller.
+var (
+	// deferredRotations counts the rotations deferred to the next maintenance window.
+	deferredRotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crdb_certs_deferred_rotations_total",
+		Help: "Number of certificate rotations deferred to the next maintenance window",
+	}, []string{"namespace", "secret"})
+
+	// smokeTestResults records the result of the last TLS handshake with each port of the pods after a rotation.
+	smokeTestResults = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "crdb_certs_tls_smoke_test_success",
+		Help: "1 if the port of the pod presented a certificate verified by the CA after the last rotation, 0 otherwise",
+	}, []string{"namespace", "pod", "port"})
+
+	// verificationFindings records the number of findings of each check of the last verification of a release.
+	verificationFindings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "crdb_certs_verification_findings",
+		Help: "Number of findings of the check in the last verification of the certificates of the release",
+	}, []string{"namespace", "statefulset", "check"})
+
+	// verificationTimestamp records the time of the last verification of a release.
+	verificationTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "crdb_certs_verification_timestamp_seconds",
+		Help: "UNIX time of the last verification of the certificates of the release",
+	}, []string{"namespace", "statefulset"})
+
+	// weakCryptoFindings records the number of findings of each check of the linter of a user provided secret.
+	weakCryptoFindings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "crdb_certs_weak_crypto_findings",
+		Help: "Number of certificates of the user provided secret failing the weak crypto check",
+	}, []string{"namespace", "secret", "check"})
+
+	// caMemoReads counts the loads of the CA secret served by the CAMemo, as hits, or read from the API server.
+	caMemoReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crdb_certs_ca_memo_reads_total",
+		Help: "Number of loads of the CA secret, by whether the memoized CA was used (hit) or the secret was read (miss)",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(deferredRotations, smokeTestResults, verificationFindings, verificationTimestamp,
+		weakCryptoFindings, caMemoReads)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/helm-charts/pkg/migration"
+)
+
+// migrateSecrets upgrades the secrets written by older versions of the self-signer to the current layout,
+// so that they are reconciled like the secrets the current version writes instead of being rotated.
+func (rc *GenerateCert) migrateSecrets(ctx context.Context, namespace string) error {
+	if err := rc.migrateCASecret(ctx, namespace); err != nil {
+		return err
+	}
+
+	names := []string{rc.getCASecretName(), rc.getClientSecretName()}
+	if rc.SplitCASecret {
+		names = append(names, rc.getCAKeySecretName())
+	}
+	for _, user := range rc.ClientUsers {
+		names = append(names, fmt.Sprintf("%s-client-secret", user))
+	}
+	if !rc.PerNodeCerts {
+		names = append(names, rc.getNodeSecretName())
+	}
+	if rc.UICert {
+		names = append(names, rc.getUISecretName())
+	}
+
+	for i, name := range names {
+		current, err := rc.currentSecretName(ctx, namespace, name)
+		if err != nil {
+			return err
+		}
+		names[i] = current
+	}
+
+	return migration.Run(ctx, rc.client, namespace, names, migration.Migrations)
+}
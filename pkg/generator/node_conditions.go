@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// NodeCertificateCondition is the condition of the Kubernetes nodes running CockroachDB pods, true when the
+// certificate of one of the pods is expired or due for rotation, in the style of node-problem-detector.
+const NodeCertificateCondition corev1.NodeConditionType = "CockroachDBCertificateExpiring"
+
+// nodeCertExpiry is the certificate of a node expiring first.
+type nodeCertExpiry struct {
+	pod      string
+	secret   string
+	notAfter time.Time
+}
+
+// reportNodeConditions sets the NodeCertificateCondition of the Kubernetes nodes running the pods of the
+// statefulset, if enabled with NodeConditions, and records an event of the node when it changes. Failures
+// are only logged, as the conditions must not fail the generation of the certificates they report on.
+func (rc *GenerateCert) reportNodeConditions(ctx context.Context, namespace string) {
+	if !rc.NodeConditions {
+		return
+	}
+
+	expiries, err := rc.nodeCertExpiries(ctx, namespace)
+	if err != nil {
+		logrus.Warnf("Failed to report the certificate conditions of the nodes: %s", err)
+		return
+	}
+
+	for node, expiry := range expiries {
+		if err := rc.setNodeCondition(ctx, namespace, node, expiry); err != nil {
+			logrus.Warnf("Failed to report the certificate condition of node [%s]: %s", node, err)
+		}
+	}
+}
+
+// nodeCertExpiries returns the certificate expiring first of the pods scheduled on each node.
+func (rc *GenerateCert) nodeCertExpiries(ctx context.Context, namespace string) (map[string]nodeCertExpiry, error) {
+	replicas, err := rc.statefulSetReplicas(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	expiries := map[string]nodeCertExpiry{}
+	for i := 0; i < replicas; i++ {
+		pod := fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)
+
+		var p corev1.Pod
+		if err := rc.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: pod}, &p); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to get pod [%s]", pod)
+		}
+		if p.Spec.NodeName == "" {
+			continue
+		}
+
+		secretName := rc.getNodeSecretName()
+		if rc.PerNodeCerts {
+			secretName = rc.PodSecretName(pod)
+		}
+
+		secret, err := rc.loadCurrentSecret(ctx, namespace, secretName)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := security.GetCertObj(secret.TLSCert())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the certificate of secret [%s]", secret.Secret().Name)
+		}
+
+		if current, ok := expiries[p.Spec.NodeName]; !ok || cert.NotAfter.Before(current.notAfter) {
+			expiries[p.Spec.NodeName] = nodeCertExpiry{pod: pod, secret: secret.Secret().Name, notAfter: cert.NotAfter}
+		}
+	}
+
+	return expiries, nil
+}
+
+// setNodeCondition updates the NodeCertificateCondition of the node from the expiry of its certificate.
+func (rc *GenerateCert) setNodeCondition(ctx context.Context, namespace, nodeName string, expiry nodeCertExpiry) error {
+	var node corev1.Node
+	if err := rc.client.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+		return errors.Wrapf(err, "failed to get node [%s]", nodeName)
+	}
+
+	now := rc.now()
+	condition := corev1.NodeCondition{Type: NodeCertificateCondition, Status: corev1.ConditionFalse, Reason: "CertificateValid"}
+	state := "expires"
+	switch {
+	case !now.Before(expiry.notAfter):
+		condition.Status, condition.Reason, state = corev1.ConditionTrue, "CertificateExpired", "expired"
+	case !now.Before(expiry.notAfter.Add(-rc.NodeCertConfig.ExpiryWindow)):
+		condition.Status, condition.Reason, state = corev1.ConditionTrue, "CertificateExpiring", "is due for rotation, expires"
+	}
+	condition.Message = fmt.Sprintf("The certificate of pod %s/%s in secret %s %s at %s", namespace, expiry.pod,
+		expiry.secret, state, expiry.notAfter.UTC().Format(time.RFC3339))
+
+	heartbeat := metav1.NewTime(now)
+	condition.LastHeartbeatTime, condition.LastTransitionTime = heartbeat, heartbeat
+
+	changed := true
+	for i, c := range node.Status.Conditions {
+		if c.Type != NodeCertificateCondition {
+			continue
+		}
+		if changed = c.Status != condition.Status || c.Reason != condition.Reason; !changed {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		node.Status.Conditions = append(node.Status.Conditions[:i], node.Status.Conditions[i+1:]...)
+		break
+	}
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+
+	if err := rc.client.Status().Update(ctx, &node); err != nil {
+		return errors.Wrapf(err, "failed to update the conditions of node [%s]", nodeName)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	eventType := corev1.EventTypeNormal
+	if condition.Status == corev1.ConditionTrue {
+		eventType = corev1.EventTypeWarning
+	}
+	ref := corev1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: nodeName, UID: node.UID}
+	return kube.RecordEvent(ctx, rc.client, ref, eventType, condition.Reason, condition.Message)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestReportNodeConditions(t *testing.T) {
+	ctx := context.TODO()
+	replicas := int32(3)
+	objs := []client.Object{
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "crdb-0", Namespace: "ns"}, Spec: corev1.PodSpec{NodeName: "node-a"}},
+		// unscheduled pods are skipped, as are the missing pods, e.g. crdb-2
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "crdb-1", Namespace: "ns"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"},
+			Data: map[string][]byte{
+				resource.CaCert:         []byte(testcerts.CACert),
+				corev1.TLSCertKey:       []byte(testcerts.NodeCert),
+				corev1.TLSPrivateKeyKey: []byte(testcerts.NodeKey),
+			},
+		},
+	}
+
+	cert, err := security.GetCertObj([]byte(testcerts.NodeCert))
+	require.NoError(t, err)
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), objs...)
+	var events []client.ObjectKey
+	cl.AddReactor("create", "events", func(action testutils.Action) (bool, error) {
+		events = append(events, action.Key())
+		return false, nil
+	})
+
+	fake := clock.NewFake(cert.NotAfter.Add(-10 * 24 * time.Hour))
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.DiscoveryServiceName = "crdb"
+	rc.NodeConditions = true
+	rc.NodeCertConfig.ExpiryWindow = 7 * 24 * time.Hour
+	rc.Clock = fake
+
+	condition := func() corev1.NodeCondition {
+		var node corev1.Node
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Name: "node-a"}, &node))
+		require.Len(t, node.Status.Conditions, 1)
+		return node.Status.Conditions[0]
+	}
+
+	rc.reportNodeConditions(ctx, "ns")
+	valid := condition()
+	assert.Equal(t, NodeCertificateCondition, valid.Type)
+	assert.Equal(t, corev1.ConditionFalse, valid.Status)
+	assert.Equal(t, "CertificateValid", valid.Reason)
+	assert.Contains(t, valid.Message, "pod ns/crdb-0 in secret crdb-node-secret")
+
+	// the transition time is kept while the condition doesn't change
+	fake.Advance(time.Hour)
+	rc.reportNodeConditions(ctx, "ns")
+	assert.Equal(t, valid.LastTransitionTime.Unix(), condition().LastTransitionTime.Unix())
+
+	fake.Advance(4 * 24 * time.Hour)
+	rc.reportNodeConditions(ctx, "ns")
+	expiring := condition()
+	assert.Equal(t, corev1.ConditionTrue, expiring.Status)
+	assert.Equal(t, "CertificateExpiring", expiring.Reason)
+
+	fake.Set(cert.NotAfter)
+	rc.reportNodeConditions(ctx, "ns")
+	assert.Equal(t, "CertificateExpired", condition().Reason)
+
+	// an event of the node is recorded on each change
+	var reasons []string
+	for _, key := range events {
+		var event corev1.Event
+		require.NoError(t, cl.Get(ctx, key, &event))
+		assert.Equal(t, "node-a", event.InvolvedObject.Name)
+		reasons = append(reasons, event.Reason)
+	}
+	assert.Equal(t, []string{"CertificateValid", "CertificateExpiring", "CertificateExpired"}, reasons)
+}
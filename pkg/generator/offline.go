@@ -0,0 +1,279 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// ErrOfflineSigningPending is returned when a certificate is requested for the offline bundle being
+// exported, and is only issued once the signed bundle is imported.
+var ErrOfflineSigningPending = errors.New("certificate is pending offline signing")
+
+// OfflineBundle holds the certificate requests of a statefulset signed on an air-gapped machine holding the
+// CA. The certificates and the CA bundle are set once it was signed.
+type OfflineBundle struct {
+	Namespace   string           `json:"namespace"`
+	StatefulSet string           `json:"statefulSet"`
+	CA          string           `json:"ca,omitempty"`
+	Requests    []OfflineRequest `json:"requests"`
+}
+
+// OfflineRequest is the request of the certificate of a secret, in PEM.
+type OfflineRequest struct {
+	Secret      string   `json:"secret"`
+	CommonName  string   `json:"commonName"`
+	Hosts       []string `json:"hosts,omitempty"`
+	CSR         string   `json:"csr"`
+	Certificate string   `json:"certificate,omitempty"`
+}
+
+// OfflineSigning replaces the signer with an offline bundle. When exporting, the keys of the certificates
+// due are generated and kept in the <statefulset>-offline-keys secret, and their requests are added to the
+// bundle. When importing, the certificates of the signed bundle are stored along with these keys.
+type OfflineSigning struct {
+	Bundle *OfflineBundle
+	Import bool
+
+	// keys holds the PEM keys of the requests by secret name
+	keys map[string][]byte
+}
+
+func (rc *GenerateCert) getOfflineKeysSecretName() string {
+	return rc.DiscoveryServiceName + "-offline-keys"
+}
+
+// ExportOffline adds the requests of the node and client certificates due to the offline bundle, and stores
+// their keys in the offline keys secret, which replaces the keys of a previous export. Only the node and
+// client certificates can be signed offline.
+func (rc *GenerateCert) ExportOffline(ctx context.Context, namespace string) (*OfflineBundle, error) {
+	rc.Offline = &OfflineSigning{Bundle: &OfflineBundle{Namespace: namespace, StatefulSet: rc.DiscoveryServiceName}}
+	rc.RotateNodeCert, rc.RotateClientCert = true, true
+
+	run, cleanup, err := rc.beginRun(ctx, namespace)
+	defer cleanup()
+	if err != nil || !run {
+		return nil, err
+	}
+
+	exports := []func() error{
+		func() error { return rc.generateClientCert(ctx, rc.getClientSecretName(), namespace) },
+	}
+	for _, user := range rc.ClientUsers {
+		user := user
+		exports = append(exports, func() error {
+			return rc.generateUserClientCert(ctx, user, fmt.Sprintf("%s-client-secret", user), namespace)
+		})
+	}
+
+	// the requests are only exported, so nothing is rolled out
+	rollout := func(string, *resource.TLSSecret) error { return nil }
+	if rc.PerNodeCerts {
+		replicas, err := rc.statefulSetReplicas(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < replicas; i++ {
+			pod := fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)
+			exports = append(exports, func() error {
+				return rc.generateNodeCert(ctx, rc.PodSecretName(pod), namespace, rc.PodHosts(namespace, pod), rollout)
+			})
+		}
+	} else {
+		exports = append(exports, func() error {
+			return rc.generateNodeCert(ctx, rc.getNodeSecretName(), namespace, rc.NodeHosts(namespace), rollout)
+		})
+	}
+
+	for _, export := range exports {
+		if err := export(); err != nil && errors.Cause(err) != ErrOfflineSigningPending {
+			return nil, err
+		}
+	}
+
+	if len(rc.Offline.Bundle.Requests) == 0 {
+		logrus.Info("No certificate is due, nothing to sign offline")
+		return rc.Offline.Bundle, nil
+	}
+
+	secret := &corev1.Secret{}
+	secret.SetName(rc.getOfflineKeysSecretName())
+	secret.SetNamespace(namespace)
+	if _, err := rc.persister()(ctx, rc.client, secret, func() error {
+		secret.Data = rc.Offline.keys
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to store the keys of the offline requests")
+	}
+
+	logrus.Infof("Exported %d certificate requests, their keys are kept in secret [%s]",
+		len(rc.Offline.Bundle.Requests), secret.Name)
+	return rc.Offline.Bundle, nil
+}
+
+// ImportOffline stores the certificates of the signed bundle along with the keys of the export, and rolls
+// them out like a rotation. The certificates due must all be in the bundle. The keys are deleted once
+// imported.
+func (rc *GenerateCert) ImportOffline(ctx context.Context, namespace string, bundle *OfflineBundle) error {
+	if bundle.Namespace != namespace || bundle.StatefulSet != rc.DiscoveryServiceName {
+		return errors.Errorf("bundle was exported for statefulset [%s] of namespace [%s]", bundle.StatefulSet,
+			bundle.Namespace)
+	}
+	if bundle.CA == "" {
+		return errors.New("bundle is not signed")
+	}
+
+	rc.Offline = &OfflineSigning{Bundle: bundle, Import: true}
+	rc.RotateNodeCert, rc.RotateClientCert = true, true
+	if err := rc.Do(ctx, namespace); err != nil {
+		return err
+	}
+
+	name := rc.getOfflineKeysSecretName()
+	return errors.Wrapf(rc.deleteSecret(ctx, namespace, name), "failed to delete secret [%s]", name)
+}
+
+// offlinePair returns the certificate of the secret, signed offline, along with the CA bundle and its key.
+// When exporting, its request is added to the bundle instead and ErrOfflineSigningPending is returned.
+func (rc *GenerateCert) offlinePair(ctx context.Context, namespace, secretName, commonName string, hosts []string,
+	algorithm string) (cert, ca, pemKey []byte, err error) {
+
+	o := rc.Offline
+	if !o.Import {
+		key, pemKey, err := rc.keys.GenerateKey(algorithm, rc.keySize())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		csr, err := security.CreateCSR(key, commonName, hosts)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if o.keys == nil {
+			o.keys = map[string][]byte{}
+		}
+		o.keys[secretName] = pemKey
+		o.Bundle.Requests = append(o.Bundle.Requests, OfflineRequest{
+			Secret: secretName, CommonName: commonName, Hosts: hosts, CSR: string(csr),
+		})
+		logrus.Infof("Exporting the certificate request of secret [%s] for offline signing", secretName)
+		return nil, nil, nil, ErrOfflineSigningPending
+	}
+
+	var request *OfflineRequest
+	for i := range o.Bundle.Requests {
+		if o.Bundle.Requests[i].Secret == secretName {
+			request = &o.Bundle.Requests[i]
+		}
+	}
+	if request == nil || request.Certificate == "" {
+		return nil, nil, nil, errors.Errorf("no certificate of secret [%s] was signed offline, export a new bundle", secretName)
+	}
+
+	if o.keys == nil {
+		var secret corev1.Secret
+		key := types.NamespacedName{Namespace: namespace, Name: rc.getOfflineKeysSecretName()}
+		if err := rc.client.Get(ctx, key, &secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil, nil, errors.Errorf("keys of the offline requests not found in secret [%s], export a new bundle", key.Name)
+			}
+			return nil, nil, nil, errors.Wrapf(err, "failed to get secret [%s]", key.Name)
+		}
+		o.keys = secret.Data
+	}
+
+	cert, ca, pemKey = []byte(request.Certificate), []byte(o.Bundle.CA), o.keys[secretName]
+	if err := security.VerifyPair(cert, pemKey, ca, commonName, rc.now()); err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "certificate of secret [%s] signed offline doesn't match the exported key", secretName)
+	}
+	return cert, ca, pemKey, nil
+}
+
+// SignOfflineBundle signs the requests of the bundle with the CA, the node certificates for nodeDuration and
+// the client certificates for clientDuration from now, and adds the CA bundle. Each request must match the
+// common name and hosts it was exported with.
+func SignOfflineBundle(bundle *OfflineBundle, caCert, caKey []byte, now time.Time,
+	nodeDuration, clientDuration time.Duration) error {
+	cert, key, err := security.ParseCAPair(caCert, caKey)
+	if err != nil {
+		return err
+	}
+
+	for i := range bundle.Requests {
+		request := &bundle.Requests[i]
+		req, err := security.ParseCSR([]byte(request.CSR))
+		if err != nil {
+			return errors.Wrapf(err, "invalid request of secret [%s]", request.Secret)
+		}
+
+		if err := checkOfflineRequest(request, req.Subject.CommonName, req.DNSNames, req.IPAddresses); err != nil {
+			return err
+		}
+
+		lifetime := clientDuration
+		if request.CommonName == security.NodeUser {
+			lifetime = nodeDuration
+		}
+
+		pemCert, err := security.SignCSR(req, cert, key, now, lifetime)
+		if err != nil {
+			return errors.Wrapf(err, "failed to sign request of secret [%s]", request.Secret)
+		}
+		request.Certificate = string(pemCert)
+		logrus.Infof("Signed the certificate of secret [%s] for %s %s", request.Secret, request.CommonName,
+			strings.Join(request.Hosts, ","))
+	}
+
+	bundle.CA = string(caCert)
+	return nil
+}
+
+// checkOfflineRequest returns an error unless the request is for the common name and hosts listed in the
+// bundle, which is what the operator of the air-gapped machine reviews.
+func checkOfflineRequest(request *OfflineRequest, commonName string, dnsNames []string, ips []net.IP) error {
+	if commonName != request.CommonName {
+		return errors.Errorf("request of secret [%s] is for %s instead of %s", request.Secret, commonName,
+			request.CommonName)
+	}
+
+	hosts := append([]string{}, dnsNames...)
+	for _, ip := range ips {
+		hosts = append(hosts, ip.String())
+	}
+	expected := append([]string{}, request.Hosts...)
+	sort.Strings(hosts)
+	sort.Strings(expected)
+	if strings.Join(hosts, ",") != strings.Join(expected, ",") {
+		return errors.Errorf("request of secret [%s] is for hosts %s instead of %s", request.Secret,
+			strings.Join(hosts, ","), strings.Join(expected, ","))
+	}
+	return nil
+}
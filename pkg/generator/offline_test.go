@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func newOfflineTestConfig(t *testing.T) GenerateCert {
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}))
+	rc.DiscoveryServiceName = "crdb"
+	rc.PublicServiceName = "crdb-public"
+	rc.ClusterDomain = "cluster.local"
+	rc.Persister = kube.DefaultPersister
+	rc.SkipPermissionCheck = true
+	rc.NodeAndClientCronSchedule = "0 0 */26 * *"
+	require.NoError(t, rc.NodeCertConfig.SetConfig("8760h", "168h"))
+	require.NoError(t, rc.ClientCertConfig.SetConfig("672h", "48h"))
+	return rc
+}
+
+func TestOfflineSigning(t *testing.T) {
+	ctx := context.TODO()
+	rc := newOfflineTestConfig(t)
+
+	bundle, err := rc.ExportOffline(ctx, "ns")
+	require.NoError(t, err)
+	require.Len(t, bundle.Requests, 2)
+	assert.Equal(t, "crdb-client-secret", bundle.Requests[0].Secret)
+	assert.Equal(t, security.RootUser, bundle.Requests[0].CommonName)
+	assert.Equal(t, "crdb-node-secret", bundle.Requests[1].Secret)
+	assert.Equal(t, rc.NodeHosts("ns"), bundle.Requests[1].Hosts)
+
+	// the keys never leave the cluster
+	var keys corev1.Secret
+	require.NoError(t, rc.client.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-offline-keys"}, &keys))
+	assert.Len(t, keys.Data, 2)
+
+	// the bundle can't be imported before it is signed
+	other := newOfflineTestConfig(t)
+	assert.Error(t, other.ImportOffline(ctx, "ns", bundle))
+
+	require.NoError(t, SignOfflineBundle(bundle, []byte(testcerts.CACert), []byte(testcerts.CAKey), time.Now(), 8760*time.Hour,
+		672*time.Hour))
+	assert.Equal(t, testcerts.CACert, bundle.CA)
+
+	importer := rc
+	importer.Offline = nil
+	require.NoError(t, importer.ImportOffline(ctx, "ns", bundle))
+
+	node, err := resource.LoadTLSSecret("crdb-node-secret", resource.NewKubeResource(ctx, rc.client, "ns", rc.persister()))
+	require.NoError(t, err)
+	assert.True(t, node.Ready())
+	assert.Equal(t, bundle.Requests[1].Certificate, string(node.TLSCert()))
+	assert.Equal(t, keys.Data["crdb-node-secret"], node.TLSPrivateKey())
+	assert.Equal(t, testcerts.CACert, string(node.CA()))
+
+	err = rc.client.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-offline-keys"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// nothing is due anymore
+	bundle, err = rc.ExportOffline(ctx, "ns")
+	require.NoError(t, err)
+	assert.Empty(t, bundle.Requests)
+}
+
+func TestSignOfflineBundle(t *testing.T) {
+	rc := newOfflineTestConfig(t)
+	bundle, err := rc.ExportOffline(context.TODO(), "ns")
+	require.NoError(t, err)
+
+	// the hosts of the requests are reviewed in the bundle, and must match the requests
+	bundle.Requests[1].Hosts = []string{"localhost"}
+	err = SignOfflineBundle(bundle, []byte(testcerts.CACert), []byte(testcerts.CAKey), time.Now(), time.Hour, time.Hour)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "crdb-node-secret")
+
+	err = SignOfflineBundle(bundle, []byte(testcerts.CACert), []byte(testcerts.OtherCAKey), time.Now(), time.Hour, time.Hour)
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestPausedNamespace(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "ns", Annotations: map[string]string{resource.Paused: "true"}},
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	paused, err := rc.namespacePaused(ctx, "ns")
+	require.NoError(t, err)
+	assert.True(t, paused)
+
+	// nothing is generated, nor reported complete, while the namespace is paused
+	rc.ReportCompletion = true
+	require.NoError(t, rc.Do(ctx, "ns"))
+	err = cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-ca-secret"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+	err = cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: CompletionName("crdb")}, &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	statuses, err := rc.Status(ctx, "ns")
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Paused, status.Secret)
+	}
+
+	paused, err = rc.namespacePaused(ctx, "other")
+	require.NoError(t, err)
+	assert.False(t, paused)
+}
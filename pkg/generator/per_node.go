@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// PodSecretName returns the name of the secret holding the node certificate of the statefulset pod when
+// PerNodeCerts is set, which is the node secret name suffixed with the ordinal of the pod.
+func (rc *GenerateCert) PodSecretName(pod string) string {
+	ordinal := strings.TrimPrefix(pod, rc.DiscoveryServiceName+"-")
+	return fmt.Sprintf("%s-%s", rc.getNodeSecretName(), ordinal)
+}
+
+// statefulSetReplicas returns the number of pods the statefulset is scaled to.
+func (rc *GenerateCert) statefulSetReplicas(ctx context.Context, namespace string) (int, error) {
+	var sts appsv1.StatefulSet
+	if err := rc.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: rc.DiscoveryServiceName}, &sts); err != nil {
+		return 0, errors.Wrapf(err, "failed to get statefulset [%s]", rc.DiscoveryServiceName)
+	}
+
+	if sts.Spec.Replicas == nil {
+		return 1, nil
+	}
+	return int(*sts.Spec.Replicas), nil
+}
+
+// generatePerNodeCerts generates a node certificate for each pod of the statefulset, valid only for the
+// names of that pod, and stores it in the secret of the pod.
+func (rc *GenerateCert) generatePerNodeCerts(ctx context.Context, namespace string) error {
+	replicas, err := rc.statefulSetReplicas(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < replicas; i++ {
+		pod := fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)
+		rollout := func(previous string, current *resource.TLSSecret) error {
+			return rc.restartPod(ctx, namespace, pod, current)
+		}
+
+		if err := rc.generateNodeCert(ctx, rc.PodSecretName(pod), namespace, rc.PodHosts(namespace, pod), rollout); err != nil {
+			return errors.Wrapf(err, "failed to generate node certificate of pod [%s]", pod)
+		}
+	}
+
+	return nil
+}
+
+// restartPod makes the pod pick up its rotated node secret. If AnnotateStatefulSet is set, the secret
+// checksum is written to the pod template, otherwise only the pod is restarted.
+func (rc *GenerateCert) restartPod(ctx context.Context, namespace, pod string, secret *resource.TLSSecret) error {
+	// the pods are restarted at once by the recovery, once all the certificates were reissued
+	if rc.recovering {
+		return nil
+	}
+
+	if rc.AnnotateStatefulSet {
+		return kube.AnnotatePodTemplate(ctx, rc.client, rc.DiscoveryServiceName, namespace,
+			map[string]string{"checksum/" + rc.PodSecretName(pod): secret.Checksum()})
+	}
+
+	if rc.reloadSupported(ctx, namespace) {
+		return rc.reloadPod(ctx, namespace, pod, secret)
+	}
+
+	logrus.Infof("Restarting pod [%s] after certificate rotation", pod)
+	if err := kube.RestartPod(ctx, rc.client, rc.EvictionClient, pod, namespace, rc.PodUpdateTimeout); err != nil {
+		return errors.Wrapf(err, "failed to restart pod [%s]", pod)
+	}
+
+	if err := kube.WaitForPodReady(ctx, rc.client, pod, namespace, rc.PodUpdateTimeout, 5*time.Second); err != nil {
+		return err
+	}
+
+	return rc.smokeTest(ctx, namespace, pod)
+}
+
+// updatePerNodeCA replaces the CA certificate of the secrets of all the pods and of the root client secret,
+// then restarts the statefulset.
+func (rc *GenerateCert) updatePerNodeCA(ctx context.Context, namespace string, ca []byte) error {
+	replicas, err := rc.statefulSetReplicas(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	logrus.Info("Updating new CA in the node secrets of the pods")
+	checksums := map[string][]byte{}
+	for i := 0; i < replicas; i++ {
+		name := rc.PodSecretName(fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i))
+		secret, err := rc.updateSecretCA(ctx, namespace, name, ca, rc.persister())
+		if err != nil {
+			return errors.Wrapf(err, "failed to update node TLS secret [%s] certs", name)
+		}
+		checksums[name] = []byte(secret.Checksum())
+	}
+
+	if err := rc.updateClientCA(ctx, namespace, ca); err != nil {
+		return err
+	}
+
+	return rc.restartStatefulSet(ctx, namespace, rc.getNodeSecretName(), resource.DataChecksum(checksums))
+}
+
+// LoadPodCert writes the CA certificate, node certificate and node key of the pod secret to the certs dir.
+func (rc *GenerateCert) LoadPodCert(ctx context.Context, namespace, pod, certsDir string) error {
+	name, err := rc.currentSecretName(ctx, namespace, rc.PodSecretName(pod))
+	if err != nil {
+		return err
+	}
+
+	secret, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get node secret [%s]", name)
+	}
+
+	if !secret.Ready() {
+		return errors.Wrapf(resource.ErrInvalidSecret, "node secret [%s] doesn't contain the required cert/key", name)
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{resource.CaCert, secret.CA(), security.CertFileMode},
+		{"node.crt", secret.TLSCert(), security.CertFileMode},
+		{"node.key", secret.TLSPrivateKey(), security.KeyFileMode},
+	}
+
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(certsDir, f.name), f.data, f.mode); err != nil {
+			return errors.Wrapf(err, "failed to write %s", f.name)
+		}
+	}
+
+	logrus.Infof("Wrote node certificate of secret [%s] to %s", name, certsDir)
+	return nil
+}
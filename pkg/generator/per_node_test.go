@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestLoadPodCert(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret-1", Namespace: "ns"},
+		Data: map[string][]byte{
+			resource.CaCert:         []byte(testcerts.CACert),
+			corev1.TLSCertKey:       []byte(testcerts.NodeCert),
+			corev1.TLSPrivateKeyKey: []byte(testcerts.NodeKey),
+		},
+	}
+
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t), secret))
+	rc.DiscoveryServiceName = "crdb"
+	assert.Equal(t, "crdb-node-secret-1", rc.PodSecretName("crdb-1"))
+
+	dir, err := ioutil.TempDir("", "podcert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, rc.LoadPodCert(context.TODO(), "ns", "crdb-1", dir))
+
+	cert, err := ioutil.ReadFile(filepath.Join(dir, "node.crt"))
+	require.NoError(t, err)
+	assert.Equal(t, testcerts.NodeCert, string(cert))
+
+	assert.Error(t, rc.LoadPodCert(context.TODO(), "ns", "crdb-2", dir))
+}
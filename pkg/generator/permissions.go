@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+// requiredPermissions returns the permissions needed to generate the certificates, or only the client
+// certificates if clientOnly is set.
+func (rc *GenerateCert) requiredPermissions(clientOnly bool) []kube.Permission {
+	// server-side apply patches the secrets, while the other persisters update them
+	write := "patch"
+	if rc.Persister != nil {
+		write = "update"
+	}
+
+	permissions := []kube.Permission{
+		{Verb: "get", Resource: "secrets"},
+		{Verb: "create", Resource: "secrets"},
+		{Verb: write, Resource: "secrets"},
+	}
+
+	// secrets of older versions are migrated in place with an update
+	if write != "update" {
+		permissions = append(permissions, kube.Permission{Verb: "update", Resource: "secrets"})
+	}
+
+	if rc.SigningClient != nil {
+		permissions = append(permissions,
+			kube.Permission{Verb: "create", Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+			kube.Permission{Verb: "get", Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+			kube.Permission{Verb: "delete", Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+		)
+	}
+
+	// the keys of the offline requests are deleted once imported
+	if rc.Offline != nil && rc.Offline.Import {
+		permissions = append(permissions, kube.Permission{Verb: "delete", Resource: "secrets", Name: rc.getOfflineKeysSecretName()})
+	}
+
+	if rc.versioned() {
+		versions := rc.getSecretVersionsName()
+		permissions = append(permissions,
+			kube.Permission{Verb: "delete", Resource: "secrets"},
+			kube.Permission{Verb: "get", Resource: "configmaps", Name: versions},
+			kube.Permission{Verb: "create", Resource: "configmaps"},
+			kube.Permission{Verb: write, Resource: "configmaps", Name: versions},
+		)
+	}
+
+	if rc.ReportCompletion {
+		completion := CompletionName(rc.DiscoveryServiceName)
+		permissions = append(permissions,
+			kube.Permission{Verb: "get", Resource: "configmaps", Name: completion},
+			kube.Permission{Verb: "create", Resource: "configmaps"},
+			kube.Permission{Verb: write, Resource: "configmaps", Name: completion},
+		)
+	}
+
+	// the workloads mounting the secrets are annotated for the reloader, or restarted
+	if rc.Reloader != "" || len(rc.RestartWorkloads) > 0 {
+		for _, verb := range []string{"list", "get", "update"} {
+			permissions = append(permissions,
+				kube.Permission{Verb: verb, Group: "apps", Resource: "deployments"},
+				kube.Permission{Verb: verb, Group: "apps", Resource: "statefulsets"},
+			)
+		}
+	}
+
+	if rc.RecordPKIStatus && !clientOnly {
+		permissions = append(permissions,
+			kube.Permission{Verb: "get", Group: PKIStatusKind.Group, Resource: "crdbpkistatuses", Name: rc.getPKIStatusName()},
+			kube.Permission{Verb: "create", Group: PKIStatusKind.Group, Resource: "crdbpkistatuses"},
+			kube.Permission{Verb: write, Group: PKIStatusKind.Group, Resource: "crdbpkistatuses", Name: rc.getPKIStatusName()},
+		)
+	}
+
+	if clientOnly || !(rc.RotateCACert || rc.RotateNodeCert) {
+		if !clientOnly && rc.PerNodeCerts {
+			// the pods of the statefulset are counted to generate their certificates
+			permissions = append(permissions, kube.Permission{Verb: "get", Group: "apps", Resource: "statefulsets", Name: rc.DiscoveryServiceName})
+		}
+		return permissions
+	}
+
+	// rotating the node certificate restarts the statefulset
+	permissions = append(permissions, kube.Permission{Verb: "get", Group: "apps", Resource: "statefulsets", Name: rc.DiscoveryServiceName})
+	// progressive rollouts are recorded in an annotation of the statefulset
+	if rc.AnnotateStatefulSet || rc.versioned() || rc.HealthChecks {
+		permissions = append(permissions, kube.Permission{Verb: "update", Group: "apps", Resource: "statefulsets", Name: rc.DiscoveryServiceName})
+	}
+	if !rc.AnnotateStatefulSet {
+		if rc.SmokeTest {
+			permissions = append(permissions, kube.Permission{Verb: "create", Resource: "events"})
+		}
+		permissions = append(permissions, kube.Permission{Verb: "get", Resource: "pods"})
+		if rc.ReloadCerts == ExecReload || rc.ReloadCerts == AutoReload {
+			permissions = append(permissions, kube.Permission{Verb: "create", Resource: "pods", Subresource: "exec"})
+		}
+		if rc.EvictionClient != nil {
+			permissions = append(permissions, kube.Permission{Verb: "create", Resource: "pods", Subresource: "eviction"})
+		} else {
+			permissions = append(permissions, kube.Permission{Verb: "delete", Resource: "pods"})
+		}
+	}
+
+	return permissions
+}
+
+// checkPermissions fails with the list of missing permissions, if the service account isn't allowed to
+// do everything needed to generate the certificates.
+func (rc *GenerateCert) checkPermissions(ctx context.Context, namespace string, clientOnly bool) error {
+	if rc.SkipPermissionCheck {
+		return nil
+	}
+
+	if err := kube.CheckPermissions(ctx, rc.client, namespace, rc.requiredPermissions(clientOnly)); err != nil {
+		return err
+	}
+
+	logrus.Info("Service account has the required permissions")
+	return nil
+}
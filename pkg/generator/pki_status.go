@@ -0,0 +1,204 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// PKIStatusKind is the kind of the object summarizing the health of the certificates of a release, defined
+// by the CrdbPKIStatus CRD of the chart.
+var PKIStatusKind = schema.GroupVersionKind{Group: "crdb.cockroachlabs.com", Version: "v1alpha1", Kind: "CrdbPKIStatus"}
+
+// Conditions of the PKIStatus.
+const (
+	// ConditionReady is true if all the secrets hold a valid certificate.
+	ConditionReady = "Ready"
+	// ConditionRotationPending is true if the certificate of a secret is due for rotation.
+	ConditionRotationPending = "RotationPending"
+	// ConditionDegraded is true if a secret holds an invalid or expired certificate.
+	ConditionDegraded = "Degraded"
+	// ConditionCompliant is true if the last verification of the certificates had no finding. It is only set
+	// by RecordVerification.
+	ConditionCompliant = "Compliant"
+)
+
+// PKIStatus summarizes the state of the certificates of a release, so that GitOps tools and dashboards
+// have a single object to watch instead of each secret.
+type PKIStatus struct {
+	// CAFingerprint is the SHA-256 fingerprint of the CA certificate.
+	CAFingerprint string       `json:"caFingerprint,omitempty"`
+	CANotAfter    *metav1.Time `json:"caNotAfter,omitempty"`
+	// NextExpiry is the earliest expiry of the certificates signed by the CA, held by NextExpirySecret.
+	NextExpiry       *metav1.Time `json:"nextExpiry,omitempty"`
+	NextExpirySecret string       `json:"nextExpirySecret,omitempty"`
+	// LastRotation is the time the most recent certificate was issued at.
+	LastRotation *metav1.Time       `json:"lastRotation,omitempty"`
+	Secrets      []SecretStatus     `json:"secrets,omitempty"`
+	Conditions   []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PKIStatus returns the summary of the certificates of the secrets managed with the configuration. The
+// conditions keep the transition times of previous, which may be nil, when their status is unchanged.
+func (rc *GenerateCert) PKIStatus(ctx context.Context, namespace string, previous []metav1.Condition) (PKIStatus, error) {
+	statuses, err := rc.Status(ctx, namespace)
+	if err != nil {
+		return PKIStatus{}, err
+	}
+
+	pki := PKIStatus{Secrets: statuses, Conditions: previous}
+	now := rc.now()
+
+	var missing, invalid, due []string
+	for i, s := range statuses {
+		if !s.Exists {
+			missing = append(missing, s.Secret)
+			continue
+		}
+		if s.Error != "" {
+			invalid = append(invalid, s.Secret)
+		}
+
+		if i == 0 && s.Error == "" {
+			if fingerprint, err := rc.caFingerprint(ctx, namespace, s.Secret); err == nil {
+				pki.CAFingerprint = fingerprint
+			}
+			caNotAfter := metav1.NewTime(s.NotAfter)
+			pki.CANotAfter = &caNotAfter
+		} else if i > 0 && !s.NotAfter.IsZero() && (pki.NextExpiry == nil || s.NotAfter.Before(pki.NextExpiry.Time)) {
+			nextExpiry := metav1.NewTime(s.NotAfter)
+			pki.NextExpiry, pki.NextExpirySecret = &nextExpiry, s.Secret
+		}
+
+		if !s.NotBefore.IsZero() && (pki.LastRotation == nil || s.NotBefore.After(pki.LastRotation.Time)) {
+			lastRotation := metav1.NewTime(s.NotBefore)
+			pki.LastRotation = &lastRotation
+		}
+
+		if dueAt, err := time.Parse(time.RFC3339, s.RotationDueAt); err == nil && !now.Before(dueAt) {
+			due = append(due, s.Secret)
+		}
+	}
+
+	ready := metav1.Condition{Type: ConditionReady, Status: metav1.ConditionTrue, Reason: "CertificatesValid",
+		Message: "All the secrets hold a valid certificate"}
+	switch {
+	case len(invalid) > 0:
+		ready.Status, ready.Reason = metav1.ConditionFalse, "CertificatesInvalid"
+		ready.Message = "Invalid certificates in secrets " + strings.Join(invalid, ", ")
+	case len(missing) > 0:
+		ready.Status, ready.Reason = metav1.ConditionFalse, "SecretsMissing"
+		ready.Message = "Missing secrets " + strings.Join(missing, ", ")
+	}
+
+	pending := metav1.Condition{Type: ConditionRotationPending, Status: metav1.ConditionFalse, Reason: "NoRotationDue"}
+	if len(due) > 0 {
+		pending.Status, pending.Reason = metav1.ConditionTrue, "RotationDue"
+		pending.Message = "Rotation due for secrets " + strings.Join(due, ", ")
+	}
+
+	degraded := metav1.Condition{Type: ConditionDegraded, Status: metav1.ConditionFalse, Reason: "CertificatesValid"}
+	if len(invalid) > 0 {
+		degraded.Status, degraded.Reason, degraded.Message = metav1.ConditionTrue, ready.Reason, ready.Message
+	}
+
+	for _, condition := range []metav1.Condition{ready, pending, degraded} {
+		meta.SetStatusCondition(&pki.Conditions, condition)
+	}
+	return pki, nil
+}
+
+// caFingerprint returns the SHA-256 fingerprint of the CA certificate of the secret.
+func (rc *GenerateCert) caFingerprint(ctx context.Context, namespace, secretName string) (string, error) {
+	secret, err := resource.LoadTLSSecret(secretName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return "", err
+	}
+
+	cert, err := security.GetCertObj(secret.CA())
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (rc *GenerateCert) getPKIStatusName() string {
+	return rc.DiscoveryServiceName + "-pki"
+}
+
+// recordPKIStatus writes the PKIStatus to the <statefulset>-pki CrdbPKIStatus object, if enabled with
+// RecordPKIStatus. Failures are only logged, as the status must not fail the generation of the
+// certificates it reports on.
+func (rc *GenerateCert) recordPKIStatus(ctx context.Context, namespace string) {
+	if !rc.RecordPKIStatus {
+		return
+	}
+
+	if err := rc.writePKIStatus(ctx, namespace); err != nil {
+		logrus.Warnf("Failed to record the PKI status in [%s]: %s", rc.getPKIStatusName(), err)
+	}
+}
+
+// writePKIStatus writes the PKIStatus, with the extra conditions.
+func (rc *GenerateCert) writePKIStatus(ctx context.Context, namespace string, extra ...metav1.Condition) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(PKIStatusKind)
+	obj.SetName(rc.getPKIStatusName())
+	obj.SetNamespace(namespace)
+
+	_, err := rc.persister()(ctx, rc.client, obj, func() error {
+		var previous PKIStatus
+		if current, ok := obj.Object["status"].(map[string]interface{}); ok {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(current, &previous); err != nil {
+				return errors.Wrap(err, "failed to read the current PKI status")
+			}
+		}
+
+		pki, err := rc.PKIStatus(ctx, namespace, previous.Conditions)
+		if err != nil {
+			return err
+		}
+		for _, condition := range extra {
+			meta.SetStatusCondition(&pki.Conditions, condition)
+		}
+
+		status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pki)
+		if err != nil {
+			return errors.Wrap(err, "failed to convert the PKI status")
+		}
+		obj.Object["status"] = status
+		return nil
+	})
+	return errors.Wrapf(err, "failed to write %s", PKIStatusKind.Kind)
+}
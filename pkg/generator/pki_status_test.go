@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestPKIStatus(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister)
+
+	ca := resource.CreateTLSSecret("crdb-ca-secret", corev1.SecretTypeOpaque, r)
+	require.NoError(t, ca.UpdateCASecret([]byte(testcerts.CAKey), []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "43800h0m0s", "648h")))
+
+	// the annotations of the node secret tell its rotation is due
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	node := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, node.UpdateTLSSecret(nodeCert, nodeKey, []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2021-01-01T01:00:00Z", "1h0m0s", "10m")))
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	pki, err := rc.PKIStatus(ctx, "ns", nil)
+	require.NoError(t, err)
+
+	assert.Len(t, pki.CAFingerprint, 64)
+	require.NotNil(t, pki.NextExpiry)
+	assert.Equal(t, "crdb-node-secret", pki.NextExpirySecret)
+	require.NotNil(t, pki.LastRotation)
+	assert.Len(t, pki.Secrets, 3)
+
+	// the client secret is missing
+	ready := meta.FindStatusCondition(pki.Conditions, ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "SecretsMissing", ready.Reason)
+	assert.Contains(t, ready.Message, "crdb-client-secret")
+	assert.True(t, meta.IsStatusConditionFalse(pki.Conditions, ConditionDegraded))
+	pending := meta.FindStatusCondition(pki.Conditions, ConditionRotationPending)
+	require.NotNil(t, pending)
+	assert.Equal(t, metav1.ConditionTrue, pending.Status)
+	assert.Contains(t, pending.Message, "crdb-node-secret")
+}
+
+func TestRecordPKIStatus(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister)
+
+	ca := resource.CreateTLSSecret("crdb-ca-secret", corev1.SecretTypeOpaque, r)
+	require.NoError(t, ca.UpdateCASecret([]byte(testcerts.CAKey), []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "43800h0m0s", "648h")))
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.RecordPKIStatus = true
+
+	read := func() PKIStatus {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(PKIStatusKind)
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "crdb-pki"}, obj))
+
+		var pki PKIStatus
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object["status"].(map[string]interface{}), &pki))
+		return pki
+	}
+
+	rc.recordPKIStatus(ctx, "ns")
+	first := read()
+	assert.True(t, meta.IsStatusConditionFalse(first.Conditions, ConditionReady))
+	assert.NotEmpty(t, first.CAFingerprint)
+	transition := meta.FindStatusCondition(first.Conditions, ConditionReady).LastTransitionTime
+
+	// the transition time of an unchanged condition is kept
+	rc.recordPKIStatus(ctx, "ns")
+	second := read()
+	assert.True(t, transition.Equal(&meta.FindStatusCondition(second.Conditions, ConditionReady).LastTransitionTime))
+	assert.Equal(t, first.CAFingerprint, second.CAFingerprint)
+}
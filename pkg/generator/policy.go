@@ -0,0 +1,39 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+
+	"github.com/cockroachdb/helm-charts/pkg/policy"
+)
+
+// checkPolicy returns a policy.ViolationError if the certificate about to be stored in the secret violates
+// the Policy. It is a no-op unless Policy is set.
+func (rc *GenerateCert) checkPolicy(ctx context.Context, namespace, secretName string, pemCert []byte,
+	inputs map[string]string) error {
+
+	if rc.Policy == nil {
+		return nil
+	}
+
+	input, err := policy.NewInput(namespace, secretName, pemCert, inputs)
+	if err != nil {
+		return err
+	}
+	return policy.Check(ctx, rc.Policy, input)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/policy"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+type denyNodes struct {
+	inputs []*policy.Input
+}
+
+func (d *denyNodes) Evaluate(ctx context.Context, input *policy.Input) ([]string, error) {
+	d.inputs = append(d.inputs, input)
+	if input.CommonName == "node" {
+		return []string{"node certificates are issued by the corporate CA"}, nil
+	}
+	return nil, nil
+}
+
+func TestCheckPolicy(t *testing.T) {
+	ctx := context.TODO()
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t)))
+
+	// nothing is evaluated without a policy
+	require.NoError(t, rc.checkPolicy(ctx, "ns", "crdb-node-secret", []byte(testcerts.NodeCert), nil))
+
+	evaluator := &denyNodes{}
+	rc.Policy = evaluator
+	require.NoError(t, rc.checkPolicy(ctx, "ns", "crdb-client-secret", []byte(testcerts.ClientCert),
+		map[string]string{"user": "root"}))
+
+	err := rc.checkPolicy(ctx, "ns", "crdb-node-secret", []byte(testcerts.NodeCert), nil)
+	var violation *policy.ViolationError
+	require.True(t, errors.As(err, &violation))
+	assert.Equal(t, "crdb-node-secret", violation.Secret)
+
+	require.Len(t, evaluator.inputs, 2)
+	assert.Equal(t, "ns", evaluator.inputs[0].Namespace)
+	assert.Equal(t, "root", evaluator.inputs[0].Inputs["user"])
+}
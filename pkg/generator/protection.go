@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// protectSecrets returns a persister setting the resource.DeletionProtection finalizer on the secrets before
+// writing them with next.
+func protectSecrets(next kube.PersistFn) kube.PersistFn {
+	return func(ctx context.Context, cl client.Client, obj client.Object, f kube.MutateFn) (bool, error) {
+		if _, ok := obj.(*corev1.Secret); !ok {
+			return next(ctx, cl, obj, f)
+		}
+
+		return next(ctx, cl, obj, func() error {
+			if err := f(); err != nil {
+				return err
+			}
+
+			// finalizers can't be added to a secret being deleted
+			if obj.GetDeletionTimestamp() == nil {
+				controllerutil.AddFinalizer(obj, resource.DeletionProtection)
+			}
+			return nil
+		})
+	}
+}
+
+// Unprotect removes the deletion protection of the named secrets of the namespace, or of all the secrets written
+// by the self-signer if none is named, so that they can be deleted, and returns the names of the secrets which
+// were protected.
+func (rc *GenerateCert) Unprotect(ctx context.Context, namespace string, names ...string) ([]string, error) {
+	if len(names) == 0 {
+		var list corev1.SecretList
+		err := rc.client.List(ctx, &list, client.InNamespace(namespace),
+			client.MatchingLabels{resource.ManagedByLabel: resource.ManagedByValue})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list the cert secrets")
+		}
+
+		for _, secret := range list.Items {
+			if controllerutil.ContainsFinalizer(&secret, resource.DeletionProtection) {
+				names = append(names, secret.Name)
+			}
+		}
+	}
+
+	var unprotected []string
+	for _, name := range names {
+		ok, err := resource.Unprotect(ctx, rc.client, namespace, name)
+		if err != nil {
+			return unprotected, err
+		}
+		if ok {
+			logrus.Infof("Removed the deletion protection of secret [%s]", name)
+			unprotected = append(unprotected, name)
+		}
+	}
+
+	return unprotected, nil
+}
+
+// deleteSecret removes the deletion protection of the secret and deletes it. A missing secret is ignored.
+func (rc *GenerateCert) deleteSecret(ctx context.Context, namespace, name string) error {
+	if _, err := resource.Unprotect(ctx, rc.client, namespace, name); err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	secret.SetName(name)
+	secret.SetNamespace(namespace)
+	return client.IgnoreNotFound(rc.client.Delete(ctx, secret))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestProtectSecrets(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.DiscoveryServiceName = "crdb"
+	rc.ProtectSecrets = true
+
+	get := func(name string) (*corev1.Secret, error) {
+		var s corev1.Secret
+		err := cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: name}, &s)
+		return &s, err
+	}
+
+	for _, name := range []string{"crdb-ca-secret", "crdb-node-secret"} {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}}
+		_, err := rc.persister()(ctx, cl, secret, func() error {
+			secret.Labels = map[string]string{resource.ManagedByLabel: resource.ManagedByValue}
+			return nil
+		})
+		require.NoError(t, err)
+
+		stored, err := get(name)
+		require.NoError(t, err)
+		assert.Equal(t, []string{resource.DeletionProtection}, stored.Finalizers)
+	}
+
+	// config maps aren't protected
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "crdb-serials", Namespace: "ns"}}
+	_, err := rc.persister()(ctx, cl, cm, func() error { return nil })
+	require.NoError(t, err)
+	assert.Empty(t, cm.Finalizers)
+
+	unprotected, err := rc.Unprotect(ctx, "ns", "crdb-node-secret", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-node-secret"}, unprotected)
+	stored, err := get("crdb-node-secret")
+	require.NoError(t, err)
+	assert.Empty(t, stored.Finalizers)
+
+	// without names, every protected secret is unprotected
+	unprotected, err = rc.Unprotect(ctx, "ns")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-ca-secret"}, unprotected)
+
+	require.NoError(t, rc.deleteSecret(ctx, "ns", "crdb-ca-secret"))
+	_, err = get("crdb-ca-secret")
+	assert.True(t, apierrors.IsNotFound(err))
+	require.NoError(t, rc.deleteSecret(ctx, "ns", "crdb-ca-secret"))
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// ManagedSecrets returns the names of the secrets of the release whose certificates ReconcileSecret
+// generates and rotates. The secret of the CA comes first, as it signs the other certificates.
+func (rc *GenerateCert) ManagedSecrets(ctx context.Context, namespace string) ([]string, error) {
+	var names []string
+	if !rc.signed() {
+		names = append(names, rc.CAKeySecret())
+	}
+
+	names = append(names, rc.getClientSecretName())
+	for _, user := range rc.ClientUsers {
+		names = append(names, fmt.Sprintf("%s-client-secret", user))
+	}
+
+	if rc.PerNodeCerts {
+		replicas, err := rc.statefulSetReplicas(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < replicas; i++ {
+			names = append(names, rc.PodSecretName(fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)))
+		}
+	} else {
+		names = append(names, rc.getNodeSecretName())
+	}
+
+	if rc.UICert {
+		names = append(names, rc.getUISecretName())
+	}
+	if len(rc.IngressHosts) > 0 {
+		names = append(names, rc.getIngressSecretName())
+	}
+	for _, t := range rc.Tenants {
+		names = append(names, rc.getTenantSecretName(t))
+	}
+	if len(rc.SQLProxyHosts) > 0 {
+		names = append(names, rc.getSQLProxySecretName())
+	}
+	if rc.RootPassword {
+		names = append(names, rc.getRootPasswordSecretName())
+	}
+
+	return names, nil
+}
+
+// BackupSecrets returns the names of the secrets restoring the PKI of the release: the ManagedSecrets, along
+// with the CA secret publishing the CA certificate with SplitCASecret, as the CA key lives in its own secret.
+func (rc *GenerateCert) BackupSecrets(ctx context.Context, namespace string) ([]string, error) {
+	names, err := rc.ManagedSecrets(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if caSecret := rc.getCASecretName(); rc.CaSecret == "" && caSecret != rc.CAKeySecret() {
+		names = append([]string{caSecret}, names...)
+	}
+	return names, nil
+}
+
+// Sync is a pass over the secrets of ManagedSecrets, which checks that the certificates of the namespace can be
+// managed, upgrades its secrets and loads its serial registry once for all of them, rather than for each secret.
+// A controller reconciling the secrets one at a time keeps a Sync for the secrets it reconciles in a row, and
+// closes it once it starts over from the current state of the cluster.
+type Sync struct {
+	rc        *GenerateCert
+	namespace string
+	// run is false if the namespace is paused, in which case nothing is generated
+	run     bool
+	cleanup func()
+}
+
+// BeginSync prepares a sync of the secrets of the namespace, which must be closed once done.
+func (rc *GenerateCert) BeginSync(ctx context.Context, namespace string) (*Sync, error) {
+	run, cleanup, err := rc.beginRun(ctx, namespace)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &Sync{rc: rc, namespace: namespace, run: run, cleanup: cleanup}, nil
+}
+
+// Close deletes the temporary directories of the sync.
+func (s *Sync) Close() {
+	s.cleanup()
+}
+
+// ReconcileSecret generates the certificate of a single secret of ManagedSecrets, or rotates it if it is
+// due, so that a controller can work through the secrets of many releases one at a time and retry each of
+// them on its own, instead of running Do. The CA is only rotated when its own secret is reconciled, and
+// the other certificates are signed with the current CA.
+func (rc *GenerateCert) ReconcileSecret(ctx context.Context, namespace, name string) error {
+	sync, err := rc.BeginSync(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	defer sync.Close()
+	return sync.ReconcileSecret(ctx, name)
+}
+
+// ReconcileSecret generates or rotates the certificate of a secret of ManagedSecrets, as
+// GenerateCert.ReconcileSecret does, with the setup of the sync.
+func (s *Sync) ReconcileSecret(ctx context.Context, name string) error {
+	rc, namespace := s.rc, s.namespace
+	if !s.run {
+		return nil
+	}
+	defer rc.recordPKIStatus(ctx, namespace)
+	defer rc.reportNodeConditions(ctx, namespace)
+
+	isCA := name == rc.CAKeySecret() || name == rc.getCASecretName()
+	if !rc.signed() {
+		rotateCA := rc.RotateCACert
+		rc.RotateCACert = rotateCA && isCA
+		err := rc.generateCA(ctx, rc.CAKeySecret(), namespace)
+		rc.RotateCACert = rotateCA
+		if err != nil {
+			return errors.Wrap(err, "failed to generate CA")
+		}
+	}
+	if isCA {
+		return nil
+	}
+
+	if err := rc.reconcileLeafSecret(ctx, namespace, name); err != nil {
+		logrus.Errorf("Failed to reconcile secret [%s]: %s", name, err)
+		return rc.partialRotation(err)
+	}
+
+	// delete the previous versions of the secrets once they can no longer be rolled back to
+	return errors.Wrap(rc.deleteRetiredSecrets(ctx, namespace), "failed to delete retired secrets")
+}
+
+// reconcileLeafSecret generates or rotates the certificate of the secret, which is signed by the CA.
+func (rc *GenerateCert) reconcileLeafSecret(ctx context.Context, namespace, name string) error {
+	if name == rc.getClientSecretName() {
+		return rc.generateClientCert(ctx, name, namespace)
+	}
+	for _, user := range rc.ClientUsers {
+		if name == fmt.Sprintf("%s-client-secret", user) {
+			return rc.generateUserClientCert(ctx, user, name, namespace)
+		}
+	}
+
+	if rc.PerNodeCerts {
+		replicas, err := rc.statefulSetReplicas(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < replicas; i++ {
+			pod := fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)
+			if name == rc.PodSecretName(pod) {
+				return rc.generateNodeCert(ctx, name, namespace, rc.PodHosts(namespace, pod),
+					func(previous string, current *resource.TLSSecret) error {
+						return rc.restartPod(ctx, namespace, pod, current)
+					})
+			}
+		}
+	} else if name == rc.getNodeSecretName() {
+		return rc.generateNodeCert(ctx, name, namespace, rc.NodeHosts(namespace),
+			func(previous string, current *resource.TLSSecret) error {
+				return rc.rolloutNodeSecret(ctx, namespace, previous, current)
+			})
+	}
+
+	switch {
+	case rc.UICert && name == rc.getUISecretName():
+		return rc.generateUICert(ctx, namespace)
+	case len(rc.IngressHosts) > 0 && name == rc.getIngressSecretName():
+		return rc.generateIngressCert(ctx, namespace)
+	case len(rc.SQLProxyHosts) > 0 && name == rc.getSQLProxySecretName():
+		return rc.generateSQLProxyCert(ctx, namespace)
+	case rc.RootPassword && name == rc.getRootPasswordSecretName():
+		return rc.generateRootPassword(ctx, namespace)
+	}
+	for _, t := range rc.Tenants {
+		if name == rc.getTenantSecretName(t) {
+			return rc.generateTenantCert(ctx, namespace, t)
+		}
+	}
+
+	return errors.Errorf("secret [%s] is not managed for statefulset [%s]", name, rc.DiscoveryServiceName)
+}
+
+// RecreateSecret regenerates the certificate of a secret of ManagedSecrets which was deleted, signed by the
+// current CA, and returns true if the secret was recreated. The CA secrets are never recreated, as a new CA
+// would no longer be trusted by the certificates it didn't sign, nor are the secrets which still exist.
+func (rc *GenerateCert) RecreateSecret(ctx context.Context, namespace, name string) (bool, error) {
+	sync, err := rc.BeginSync(ctx, namespace)
+	if err != nil {
+		return false, err
+	}
+	defer sync.Close()
+	return sync.RecreateSecret(ctx, name)
+}
+
+// RecreateSecret regenerates the certificate of a deleted secret of ManagedSecrets, as
+// GenerateCert.RecreateSecret does, with the setup of the sync.
+func (s *Sync) RecreateSecret(ctx context.Context, name string) (bool, error) {
+	rc, namespace := s.rc, s.namespace
+	if name == rc.CAKeySecret() || name == rc.getCASecretName() {
+		return false, nil
+	}
+
+	names, err := rc.ManagedSecrets(ctx, namespace)
+	if err != nil {
+		return false, err
+	}
+	if !contains(names, name) {
+		return false, nil
+	}
+
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	err = rc.client.Get(ctx, key, &corev1.Secret{})
+	if client.IgnoreNotFound(err) != nil {
+		return false, errors.Wrapf(err, "failed to get secret [%s]", name)
+	}
+	if err == nil {
+		return false, nil
+	}
+
+	if err := s.ReconcileSecret(ctx, name); err != nil {
+		return false, err
+	}
+
+	// nothing is generated while the namespace is paused
+	err = rc.client.Get(ctx, key, &corev1.Secret{})
+	if client.IgnoreNotFound(err) != nil {
+		return false, errors.Wrapf(err, "failed to get secret [%s]", name)
+	}
+	return err == nil, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestManagedSecrets(t *testing.T) {
+	ctx := context.TODO()
+	replicas := int32(2)
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+
+	names, err := rc.ManagedSecrets(ctx, "ns")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-ca-secret", "crdb-client-secret", "crdb-node-secret"}, names)
+
+	rc.SplitCASecret = true
+	rc.ClientUsers = []string{"app"}
+	rc.PerNodeCerts = true
+	rc.UICert = true
+	rc.IngressHosts = []string{"crdb.example.com"}
+	rc.Tenants = []Tenant{{ID: 2}}
+	rc.SQLProxyHosts = []string{"sqlproxy"}
+	rc.RootPassword = true
+	names, err = rc.ManagedSecrets(ctx, "ns")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"crdb-ca-key-secret",
+		"crdb-client-secret",
+		"app-client-secret",
+		rc.PodSecretName("crdb-0"),
+		rc.PodSecretName("crdb-1"),
+		rc.getUISecretName(),
+		"crdb-ingress-secret",
+		rc.getTenantSecretName(Tenant{ID: 2}),
+		"crdb-sqlproxy-secret",
+		"crdb-root-password",
+	}, names)
+
+	// the statefulset is needed to list the per-node secrets
+	_, err = rc.ManagedSecrets(ctx, "other")
+	assert.Error(t, err)
+}
+
+func TestBackupSecrets(t *testing.T) {
+	ctx := context.TODO()
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t)))
+	rc.DiscoveryServiceName = "crdb"
+
+	names, err := rc.BackupSecrets(ctx, "ns")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-ca-secret", "crdb-client-secret", "crdb-node-secret"}, names)
+
+	// the CA key lives in its own secret with the split CA secret
+	rc.SplitCASecret = true
+	names, err = rc.BackupSecrets(ctx, "ns")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-ca-secret", "crdb-ca-key-secret", "crdb-client-secret", "crdb-node-secret"}, names)
+}
+
+func TestReconcileSecretPaused(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "ns", Annotations: map[string]string{resource.Paused: "true"}},
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	// nothing is generated while the namespace is paused
+	require.NoError(t, rc.ReconcileSecret(ctx, "ns", "crdb-node-secret"))
+	err := cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-ca-secret"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Empty(t, rc.CertsDir)
+}
+
+func TestRecreateSecret(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "ns", Annotations: map[string]string{resource.Paused: "true"}},
+		},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-client-secret", Namespace: "ns"}},
+	)
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	// the CA, the secrets of other releases and the existing secrets aren't recreated
+	for _, name := range []string{"crdb-ca-secret", "other-node-secret", "crdb-client-secret"} {
+		recreated, err := rc.RecreateSecret(ctx, "ns", name)
+		require.NoError(t, err)
+		assert.False(t, recreated, name)
+	}
+	assert.Empty(t, rc.CertsDir)
+
+	// the missing secret is reconciled, which generates nothing while the namespace is paused
+	recreated, err := rc.RecreateSecret(ctx, "ns", "crdb-node-secret")
+	require.NoError(t, err)
+	assert.False(t, recreated)
+	err = cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-ca-secret"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-client-secret", Namespace: "ns"}},
+	)
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.SkipPermissionCheck = true
+
+	// the setup of the sync is kept for all the secrets it reconciles
+	sync, err := rc.BeginSync(ctx, "ns")
+	require.NoError(t, err)
+	certsDir := rc.CertsDir
+	require.DirExists(t, certsDir)
+	for _, name := range []string{"crdb-ca-secret", "crdb-client-secret"} {
+		recreated, err := sync.RecreateSecret(ctx, name)
+		require.NoError(t, err)
+		assert.False(t, recreated, name)
+	}
+	assert.Equal(t, certsDir, rc.CertsDir)
+	assert.DirExists(t, certsDir)
+
+	sync.Close()
+	assert.NoDirExists(t, certsDir)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	util "github.com/cockroachdb/helm-charts/pkg/utils"
+)
+
+// recoveryReason is logged for the certificates reissued by RecoverExpiredCA.
+const recoveryReason = "CA recovered, reissuing certificate"
+
+// recovered returns true while RecoverExpiredCA reissues the certificates, unless the secret is paused.
+func (rc *GenerateCert) recovered(secret *resource.TLSSecret) (bool, string) {
+	if !rc.recovering || secret.Paused() {
+		return false, ""
+	}
+	return true, recoveryReason
+}
+
+// RecoverExpiredCA replaces the expired CA of the namespace, which the cluster can't recover from with a
+// rolling restart: the nodes restarted with certificates of a new CA couldn't join the nodes still serving
+// the expired ones. A new CA is generated and bundled with the expired one, as in a CA rotation, then all
+// the certificates signed by the CA are reissued, and all the pods are restarted at once. A CA which didn't
+// expire yet is only replaced if force is set, e.g. when its key was compromised.
+func (rc *GenerateCert) RecoverExpiredCA(ctx context.Context, namespace string, force bool) error {
+	if err := kube.CheckNamespace(ctx, rc.client, namespace); err != nil {
+		return err
+	}
+
+	switch {
+	case rc.CaSecret != "":
+		return errors.Errorf("the CA of secret [%s] is provided by the user, replace it and run rotate instead", rc.CaSecret)
+	case rc.signed():
+		return errors.New("the CA is managed by the signer, which has to be recovered instead")
+	case rc.SPIRE != nil:
+		return errors.New("the node certificates are issued by SPIRE, which has to be recovered instead")
+	}
+
+	paused, err := rc.namespacePaused(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if paused {
+		return errors.Errorf("certificate management of namespace [%s] is paused, resume it to recover the CA", namespace)
+	}
+
+	if err := rc.migrateSecrets(ctx, namespace); err != nil {
+		return err
+	}
+
+	caSecretName := rc.CAKeySecret()
+	notAfter, err := rc.caNotAfter(ctx, namespace, caSecretName)
+	if err != nil {
+		return err
+	}
+	if rc.now().Before(notAfter) {
+		if !force {
+			return errors.Errorf("the CA of secret [%s] is valid until %s, rotate it with rotate --ca instead",
+				caSecretName, notAfter.Format(time.RFC3339))
+		}
+		logrus.Warnf("Replacing the CA of secret [%s], valid until %s", caSecretName, notAfter.Format(time.RFC3339))
+	} else {
+		logrus.Warnf("The CA of secret [%s] expired at %s, recovering", caSecretName, notAfter.Format(time.RFC3339))
+	}
+
+	// every certificate is reissued and the pods are deleted, as neither a canary, the health of the cluster
+	// nor its disruption budgets can be waited for
+	rc.recovering = true
+	rc.RotateCACert, rc.RotateNodeCert, rc.RotateClientCert = true, true, true
+	rc.CanaryRotation, rc.HealthChecks, rc.AnnotateStatefulSet = false, false, false
+	rc.EvictionClient = nil
+	defer func() { rc.recovering = false }()
+
+	if err := rc.checkPermissions(ctx, namespace, false); err != nil {
+		return err
+	}
+
+	if err := rc.loadSerialRegistry(ctx, namespace); err != nil {
+		return err
+	}
+
+	certsDir, cleanup := util.CreateTempDir("certsDir")
+	defer cleanup()
+	rc.CertsDir = certsDir
+
+	caDir, cleanupCADir := util.CreateTempDir("caDir")
+	defer cleanupCADir()
+	rc.CAKey = filepath.Join(caDir, "ca.key")
+
+	stopKeyPool := rc.startKeyPool(ctx, namespace)
+	defer stopKeyPool()
+
+	if err := rc.generateCA(ctx, caSecretName, namespace); err != nil {
+		msg := " error Recovering CA"
+		logrus.Error(err, msg)
+		return errors.Wrap(err, msg)
+	}
+
+	if err := rc.generateLeafCerts(ctx, namespace); err != nil {
+		return err
+	}
+
+	if err := kube.RestartAllPods(ctx, rc.client, rc.DiscoveryServiceName, namespace, rc.PodUpdateTimeout); err != nil {
+		return rc.partialRotation(errors.Wrap(err, "failed to restart the pods with the recovered certificates"))
+	}
+
+	logrus.Infof("Recovered the CA of secret [%s], the clients need the new CA certificate", caSecretName)
+	return rc.smokeTest(ctx, namespace)
+}
+
+// caNotAfter returns the expiry of the current CA certificate, the first one of the CA secret.
+func (rc *GenerateCert) caNotAfter(ctx context.Context, namespace, name string) (time.Time, error) {
+	secret, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to get CA secret [%s]", name)
+	}
+	if secret.Paused() {
+		return time.Time{}, errors.Errorf("CA secret [%s] is paused, resume it to recover the CA", name)
+	}
+	if !secret.ReadyCA() {
+		return time.Time{}, errors.Wrapf(resource.ErrInvalidSecret, "CA secret [%s] doesn't contain the CA cert and key", name)
+	}
+
+	cert, err := security.GetCertObj(secret.CA())
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to parse the CA certificate of secret [%s]", name)
+	}
+
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestRecoverValidCA(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb-ca-secret", Namespace: "ns"},
+			Data:       map[string][]byte{resource.CaCert: []byte(testcerts.CACert), resource.CaKey: []byte(testcerts.CAKey)},
+		})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	// a CA which didn't expire is only replaced when forced
+	err := rc.RecoverExpiredCA(ctx, "ns", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rotate it with rotate --ca instead")
+	assert.False(t, rc.recovering)
+
+	// a CA provided by the user isn't replaced
+	rc.CaSecret = "user-ca"
+	err = rc.RecoverExpiredCA(ctx, "ns", true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "provided by the user")
+}
+
+func TestRecoveredCertificates(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister)
+
+	nodeCert, nodeKey := signPair(t, "node")
+	secret := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, secret.UpdateTLSSecret(nodeCert, nodeKey, []byte(testcerts.CACert), map[string]string{}))
+
+	rc := NewGenerateCert(cl)
+
+	required, _ := rc.recovered(secret)
+	assert.False(t, required)
+
+	// during a recovery, every certificate signed by the CA is reissued
+	rc.recovering = true
+	required, reason := rc.recovered(secret)
+	assert.True(t, required)
+	assert.Equal(t, recoveryReason, reason)
+
+	// but the paused secrets are kept
+	secret.Secret().Annotations[resource.Paused] = "true"
+	required, _ = rc.recovered(secret)
+	assert.False(t, required)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// reusableKey returns the PEM encoded key of the secret to re-sign when its certificate is rotated, if
+// ReuseKeys is set, so that only the certificate of the secret changes. It returns nil if a new key has to
+// be created instead: the key doesn't match the certificate, the certificate was revoked, or the key isn't of
+// the configured algorithm and size.
+func (rc *GenerateCert) reusableKey(secret *resource.TLSSecret, operation audit.Operation, algorithm string) []byte {
+	if !rc.ReuseKeys || operation != audit.Rotate {
+		return nil
+	}
+
+	if revoked, _ := rc.revoked(secret); revoked {
+		return nil
+	}
+
+	pemKey := secret.TLSPrivateKey()
+	pair, err := tls.X509KeyPair(secret.TLSCert(), pemKey)
+	if err != nil {
+		logrus.Warnf("Key of secret [%s] can't be reused, creating a new key: %s", secret.Secret().Name, err)
+		return nil
+	}
+
+	switch key := pair.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != security.RSAAlgorithm || key.N.BitLen() != rc.keySize() {
+			return nil
+		}
+	case ed25519.PrivateKey:
+		if algorithm != security.Ed25519Algorithm {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	return pemKey
+}
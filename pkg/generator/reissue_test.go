@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestReusableKey(t *testing.T) {
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+	pemCert, pemKey, err := security.CreateServerCert(caCert, caKey, nil, 2048, time.Now(), time.Hour, []string{"crdb"})
+	require.NoError(t, err)
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Namespace: "ns"},
+			Data:       map[string][]byte{corev1.TLSCertKey: pemCert, corev1.TLSPrivateKeyKey: pemKey},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "mismatch", Namespace: "ns"},
+			Data:       map[string][]byte{corev1.TLSCertKey: pemCert, corev1.TLSPrivateKeyKey: []byte(testcerts.NodeKey)},
+		})
+	r := resource.NewKubeResource(context.TODO(), cl, "ns", kube.DefaultPersister)
+
+	node, err := resource.LoadTLSSecret("node", r)
+	require.NoError(t, err)
+	mismatch, err := resource.LoadTLSSecret("mismatch", r)
+	require.NoError(t, err)
+
+	rc := NewGenerateCert(cl)
+	rc.KeySize = 2048
+	assert.Nil(t, rc.reusableKey(node, audit.Rotate, security.RSAAlgorithm))
+
+	rc.ReuseKeys = true
+	assert.Equal(t, pemKey, rc.reusableKey(node, audit.Rotate, security.RSAAlgorithm))
+	assert.Nil(t, rc.reusableKey(node, audit.Issue, security.RSAAlgorithm))
+	assert.Nil(t, rc.reusableKey(node, audit.Rotate, security.Ed25519Algorithm))
+	assert.Nil(t, rc.reusableKey(mismatch, audit.Rotate, security.RSAAlgorithm))
+
+	// a new key is created when the key size changes
+	rc.KeySize = 4096
+	assert.Nil(t, rc.reusableKey(node, audit.Rotate, security.RSAAlgorithm))
+}
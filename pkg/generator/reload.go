@@ -0,0 +1,204 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/version"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// Modes of ReloadCerts.
+const (
+	// ExecReload writes the rotated certificates to the certs directory of the CockroachDB containers and
+	// sends SIGHUP to CockroachDB through exec.
+	ExecReload = "exec"
+	// SidecarReload leaves the rotated certificates to a cert-agent sidecar of the pods, which sends SIGHUP
+	// to CockroachDB once it wrote them.
+	SidecarReload = "sidecar"
+	// AutoReload uses ExecReload if every node of the cluster runs a version of CockroachDB reloading its
+	// certificates on SIGHUP, and restarts the pods otherwise.
+	AutoReload = "auto"
+)
+
+// minReloadVersion is the first version of CockroachDB reloading its certificates on SIGHUP.
+var minReloadVersion = version.MustParseGeneric("v1.1.0")
+
+// DefaultReloadCertsDir is the certs directory of the CockroachDB containers of the chart.
+const DefaultReloadCertsDir = "/cockroach/cockroach-certs"
+
+// reloadSupported returns whether the rotated certificates are reloaded online rather than by restarting the
+// pods. With AutoReload, the version of each node is queried on its HTTP port, and the pods are restarted if a
+// node runs a version which doesn't reload its certificates, or if its version can't be determined.
+func (rc *GenerateCert) reloadSupported(ctx context.Context, namespace string) bool {
+	if rc.ReloadCerts != AutoReload {
+		return rc.ReloadCerts != ""
+	}
+
+	tags, err := rc.clusterVersions(ctx, namespace)
+	if err != nil {
+		logrus.Warnf("Failed to query the version of CockroachDB, restarting the pods: %v", err)
+		return false
+	}
+
+	for _, tag := range tags {
+		v, err := version.ParseGeneric(tag)
+		if err != nil {
+			logrus.Warnf("Unknown version %s of CockroachDB, restarting the pods: %v", tag, err)
+			return false
+		}
+		if !v.AtLeast(minReloadVersion) {
+			logrus.Infof("CockroachDB %s doesn't reload its certificates online, restarting the pods", tag)
+			return false
+		}
+	}
+
+	return true
+}
+
+// reloadStatefulSet makes all the CockroachDB pods of the statefulset reload the certificates of the node
+// secret, and of the DB Console secret if UICert is set, without restarting them.
+func (rc *GenerateCert) reloadStatefulSet(ctx context.Context, namespace string) error {
+	if rc.ReloadCerts == SidecarReload {
+		logrus.Info("Certificates are reloaded by the cert-agent sidecar of the pods, skipping pod restart")
+		return nil
+	}
+
+	replicas, err := rc.statefulSetReplicas(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < replicas; i++ {
+		pod := fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)
+		files, _, err := rc.NodeCertFiles(ctx, namespace, pod)
+		if err != nil {
+			return err
+		}
+		if err := rc.reloadPodCerts(ctx, namespace, pod, files); err != nil {
+			return err
+		}
+	}
+
+	return rc.smokeTest(ctx, namespace)
+}
+
+// reloadPod makes the CockroachDB pod reload the certificates of its node secret without restarting it.
+func (rc *GenerateCert) reloadPod(ctx context.Context, namespace, pod string, secret *resource.TLSSecret) error {
+	if rc.ReloadCerts == SidecarReload {
+		logrus.Infof("Certificates of pod [%s] are reloaded by its cert-agent sidecar, skipping pod restart", pod)
+		return nil
+	}
+
+	files, _, err := rc.nodeCertFiles(ctx, namespace, secret)
+	if err != nil {
+		return err
+	}
+	if err := rc.reloadPodCerts(ctx, namespace, pod, files); err != nil {
+		return err
+	}
+	return rc.smokeTest(ctx, namespace, pod)
+}
+
+// NodeCertFiles returns the files of the certs directory of the CockroachDB pod, read from the current version
+// of its node secret, along with the DB Console certificate if UICert is set, and the versions of the secrets
+// they were read from.
+func (rc *GenerateCert) NodeCertFiles(ctx context.Context, namespace, pod string) ([]security.CertFile, string, error) {
+	name := rc.getNodeSecretName()
+	if rc.PerNodeCerts {
+		name = rc.PodSecretName(pod)
+	}
+
+	current, err := rc.currentSecretName(ctx, namespace, name)
+	if err != nil {
+		return nil, "", err
+	}
+	node, err := resource.LoadTLSSecret(current, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to get node secret [%s]", current)
+	}
+
+	files, versions, err := rc.nodeCertFiles(ctx, namespace, node)
+	if err != nil {
+		return nil, "", err
+	}
+	return files, strings.Join(versions, ","), nil
+}
+
+// nodeCertFiles returns the files of the certs directory of the CockroachDB pods holding the node secret,
+// and the DB Console secret if UICert is set, along with the <name>/<resource version> of the secrets.
+func (rc *GenerateCert) nodeCertFiles(ctx context.Context, namespace string, node *resource.TLSSecret) ([]security.CertFile, []string, error) {
+	if !node.Ready() {
+		return nil, nil, errors.Wrapf(resource.ErrInvalidSecret, "node secret [%s] doesn't contain the required cert/key", node.Secret().Name)
+	}
+
+	files := []security.CertFile{
+		// the key is written first, so that the certificate never lags its key
+		{Name: "node.key", Data: node.TLSPrivateKey(), Mode: security.KeyFileMode},
+		{Name: "node.crt", Data: node.TLSCert(), Mode: security.CertFileMode},
+		{Name: resource.CaCert, Data: node.CA(), Mode: security.CertFileMode},
+	}
+	versions := []string{node.Secret().Name + "/" + node.Secret().ResourceVersion}
+
+	if rc.UICert {
+		name, err := rc.currentSecretName(ctx, namespace, rc.getUISecretName())
+		if err != nil {
+			return nil, nil, err
+		}
+		ui, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to get DB Console secret [%s]", name)
+		}
+		versions = append(versions, name+"/"+ui.Secret().ResourceVersion)
+		files = append(files,
+			security.CertFile{Name: "ui.key", Data: ui.TLSPrivateKey(), Mode: security.KeyFileMode},
+			security.CertFile{Name: "ui.crt", Data: ui.TLSCert(), Mode: security.CertFileMode})
+	}
+	return files, versions, nil
+}
+
+// reloadPodCerts writes the files to the certs directory of the pod, then sends SIGHUP to CockroachDB, the
+// first process of its container, which reloads them online.
+func (rc *GenerateCert) reloadPodCerts(ctx context.Context, namespace, pod string, files []security.CertFile) error {
+	certsDir := rc.ReloadCertsDir
+	if certsDir == "" {
+		certsDir = DefaultReloadCertsDir
+	}
+
+	logrus.Infof("Reloading the certificates of pod [%s]", pod)
+	for _, f := range files {
+		// the file is replaced by a rename, so that CockroachDB never reads it partially written
+		file := path.Join(certsDir, f.Name)
+		script := fmt.Sprintf("cat > %[1]s.tmp && chmod %[2]o %[1]s.tmp && mv -f %[1]s.tmp %[1]s", file, f.Mode)
+		if err := rc.Executor.Exec(ctx, namespace, pod, rc.ReloadContainer, []string{"sh", "-c", script}, bytes.NewReader(f.Data)); err != nil {
+			return errors.Wrapf(err, "failed to write %s to pod [%s]", f.Name, pod)
+		}
+	}
+
+	if err := rc.Executor.Exec(ctx, namespace, pod, rc.ReloadContainer, []string{"sh", "-c", "kill -HUP 1"}, nil); err != nil {
+		return errors.Wrapf(err, "failed to signal CockroachDB in pod [%s]", pod)
+	}
+	return nil
+}
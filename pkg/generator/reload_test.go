@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+type execCall struct {
+	pod, container, command, stdin string
+}
+
+type fakeExecutor struct {
+	calls []execCall
+}
+
+func (e *fakeExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader) error {
+	call := execCall{pod: pod, container: container, command: strings.Join(command, " ")}
+	if stdin != nil {
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		call.stdin = string(data)
+	}
+	e.calls = append(e.calls, call)
+	return nil
+}
+
+func nodeSecret(name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+		Data: map[string][]byte{
+			resource.CaCert:         []byte(testcerts.CACert),
+			corev1.TLSCertKey:       []byte(testcerts.NodeCert),
+			corev1.TLSPrivateKeyKey: []byte(testcerts.NodeKey),
+		},
+	}
+}
+
+func TestReloadStatefulSet(t *testing.T) {
+	replicas := int32(2)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+
+	executor := &fakeExecutor{}
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t), sts, nodeSecret("crdb-node-secret")))
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.ReloadCerts = ExecReload
+	rc.Executor = executor
+	rc.ReloadContainer = "db"
+
+	require.NoError(t, rc.restartStatefulSet(context.TODO(), "ns", "crdb-node-secret", "checksum"))
+
+	// the key, certificate and CA are written to each pod before CockroachDB is signaled
+	require.Len(t, executor.calls, 8)
+	assert.Equal(t, execCall{pod: "crdb-0", container: "db", stdin: testcerts.NodeKey,
+		command: "sh -c cat > /cockroach/cockroach-certs/node.key.tmp && chmod 600 /cockroach/cockroach-certs/node.key.tmp && " +
+			"mv -f /cockroach/cockroach-certs/node.key.tmp /cockroach/cockroach-certs/node.key"}, executor.calls[0])
+	assert.Equal(t, testcerts.NodeCert, executor.calls[1].stdin)
+	assert.Equal(t, testcerts.CACert, executor.calls[2].stdin)
+	assert.Equal(t, execCall{pod: "crdb-0", container: "db", command: "sh -c kill -HUP 1"}, executor.calls[3])
+	assert.Equal(t, "crdb-1", executor.calls[7].pod)
+	assert.Equal(t, "sh -c kill -HUP 1", executor.calls[7].command)
+}
+
+func TestReloadPod(t *testing.T) {
+	executor := &fakeExecutor{}
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t), nodeSecret("crdb-node-secret-1")))
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.PerNodeCerts = true
+	rc.ReloadCerts = ExecReload
+	rc.ReloadCertsDir = "/certs"
+	rc.Executor = executor
+
+	secret, err := resource.LoadTLSSecret("crdb-node-secret-1", resource.NewKubeResource(context.TODO(), rc.client, "ns", rc.persister()))
+	require.NoError(t, err)
+	require.NoError(t, rc.restartPod(context.TODO(), "ns", "crdb-1", secret))
+
+	require.Len(t, executor.calls, 4)
+	for _, call := range executor.calls {
+		assert.Equal(t, "crdb-1", call.pod)
+	}
+	assert.Contains(t, executor.calls[1].command, "/certs/node.crt")
+
+	// the pods of the sidecar reload are left to their agent
+	executor.calls = nil
+	rc.ReloadCerts = SidecarReload
+	require.NoError(t, rc.restartPod(context.TODO(), "ns", "crdb-1", secret))
+	assert.Empty(t, executor.calls)
+}
+
+func TestNodeCertFiles(t *testing.T) {
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t), nodeSecret("crdb-node-secret-0"), nodeSecret("crdb-ui-secret")))
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.PerNodeCerts = true
+	rc.UICert = true
+
+	files, version, err := rc.NodeCertFiles(context.TODO(), "ns", "crdb-0")
+	require.NoError(t, err)
+	require.Len(t, files, 5)
+	assert.Equal(t, "node.key", files[0].Name)
+	assert.Equal(t, "ui.crt", files[4].Name)
+	assert.Regexp(t, `^crdb-node-secret-0/\d+,crdb-ui-secret/\d+$`, version)
+
+	_, _, err = rc.NodeCertFiles(context.TODO(), "ns", "crdb-1")
+	assert.Error(t, err)
+}
+
+func TestReloadSupported(t *testing.T) {
+	rc := NewGenerateCert(testutils.NewFakeClient(testutils.InitScheme(t)))
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	assert.False(t, rc.reloadSupported(context.TODO(), "ns"))
+	rc.ReloadCerts = SidecarReload
+	assert.True(t, rc.reloadSupported(context.TODO(), "ns"))
+
+	// the pods are restarted when the version of the nodes can't be queried
+	rc.ReloadCerts = AutoReload
+	assert.False(t, rc.reloadSupported(context.TODO(), "ns"))
+}
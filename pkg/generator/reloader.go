@@ -0,0 +1,237 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// The tools restarting the workloads mounting the secrets when they change, whose annotations are written with
+// Reloader.
+const (
+	// StakaterReloader is Stakater Reloader: the secrets are annotated with StakaterMatch and the workloads with
+	// the secrets they mount in StakaterSecretReload.
+	StakaterReloader = "stakater"
+	// WaveReloader is Wave: the workloads are annotated with WaveUpdateOnChange.
+	WaveReloader = "wave"
+
+	StakaterMatch        = "reloader.stakater.com/match"
+	StakaterSecretReload = "secret.reloader.stakater.com/reload"
+	WaveUpdateOnChange   = "wave.pusher.com/update-on-config-change"
+)
+
+// reloaderSecrets returns a persister annotating the secrets as expected by the reloader before writing them with
+// next.
+func reloaderSecrets(reloader string, next kube.PersistFn) kube.PersistFn {
+	return func(ctx context.Context, cl client.Client, obj client.Object, f kube.MutateFn) (bool, error) {
+		if _, ok := obj.(*corev1.Secret); !ok || reloader != StakaterReloader {
+			return next(ctx, cl, obj, f)
+		}
+
+		return next(ctx, cl, obj, func() error {
+			if err := f(); err != nil {
+				return err
+			}
+
+			annotations := obj.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[StakaterMatch] = "true"
+			obj.SetAnnotations(annotations)
+			return nil
+		})
+	}
+}
+
+// workload is a Deployment or StatefulSet of the namespace.
+type workload struct {
+	kind     string
+	obj      client.Object
+	template *corev1.PodTemplateSpec
+}
+
+// listWorkloads returns the Deployments and StatefulSets of the namespace matching the options.
+func (rc *GenerateCert) listWorkloads(ctx context.Context, namespace string, opts ...client.ListOption) ([]workload, error) {
+	opts = append(opts, client.InNamespace(namespace))
+
+	var deployments appsv1.DeploymentList
+	if err := rc.client.List(ctx, &deployments, opts...); err != nil {
+		return nil, errors.Wrap(err, "failed to list the deployments")
+	}
+	var statefulSets appsv1.StatefulSetList
+	if err := rc.client.List(ctx, &statefulSets, opts...); err != nil {
+		return nil, errors.Wrap(err, "failed to list the statefulsets")
+	}
+
+	var workloads []workload
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		workloads = append(workloads, workload{kind: "Deployment", obj: d, template: &d.Spec.Template})
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		workloads = append(workloads, workload{kind: "StatefulSet", obj: s, template: &s.Spec.Template})
+	}
+	return workloads, nil
+}
+
+// mountedSecrets returns the names of the secrets mounted by the volumes of the pods.
+func mountedSecrets(spec corev1.PodSpec) []string {
+	var names []string
+	for _, v := range spec.Volumes {
+		if v.Secret != nil {
+			names = append(names, v.Secret.SecretName)
+		}
+		if v.Projected != nil {
+			for _, source := range v.Projected.Sources {
+				if source.Secret != nil {
+					names = append(names, source.Secret.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// annotateReloadTargets annotates the workloads of the namespace mounting the secrets of the release, so that the
+// Reloader restarts them when their certificates are rotated. The StatefulSet of the release is restarted by the
+// self-signer itself.
+func (rc *GenerateCert) annotateReloadTargets(ctx context.Context, namespace string) error {
+	if rc.Reloader == "" {
+		return nil
+	}
+
+	workloads, err := rc.listWorkloads(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	// whether each secret mounted is a secret of the release
+	released := map[string]bool{}
+	ofRelease := func(name string) (bool, error) {
+		if v, ok := released[name]; ok {
+			return v, nil
+		}
+		var secret corev1.Secret
+		err := rc.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret)
+		if client.IgnoreNotFound(err) != nil {
+			return false, errors.Wrapf(err, "failed to get secret [%s]", name)
+		}
+		released[name] = err == nil && secret.Labels[resource.ManagedByLabel] == resource.ManagedByValue &&
+			secret.Labels[resource.StatefulSetLabel] == rc.DiscoveryServiceName
+		return released[name], nil
+	}
+
+	for _, w := range workloads {
+		if w.kind == "StatefulSet" && w.obj.GetName() == rc.DiscoveryServiceName {
+			continue
+		}
+
+		var secrets []string
+		for _, name := range mountedSecrets(w.template.Spec) {
+			ok, err := ofRelease(name)
+			if err != nil {
+				return err
+			}
+			if ok {
+				secrets = append(secrets, name)
+			}
+		}
+		if len(secrets) == 0 {
+			continue
+		}
+
+		if err := rc.annotateReloadTarget(ctx, w, secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// annotateReloadTarget annotates the workload to be restarted by the Reloader when the secrets change.
+func (rc *GenerateCert) annotateReloadTarget(ctx context.Context, w workload, secrets []string) error {
+	key := client.ObjectKeyFromObject(w.obj)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := rc.client.Get(ctx, key, w.obj); err != nil {
+			return err
+		}
+
+		annotations := w.obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		name, value := WaveUpdateOnChange, "true"
+		if rc.Reloader == StakaterReloader {
+			name, value = StakaterSecretReload, mergeList(annotations[StakaterSecretReload], secrets)
+		}
+		if annotations[name] == value {
+			return nil
+		}
+
+		annotations[name] = value
+		w.obj.SetAnnotations(annotations)
+		if err := rc.client.Update(ctx, w.obj); err != nil {
+			return err
+		}
+		logrus.Infof("Annotated %s [%s] to be restarted by %s when secrets %v are rotated", w.kind, key.Name,
+			rc.Reloader, secrets)
+		return nil
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return errors.Wrapf(err, "failed to annotate %s [%s]", w.kind, key.Name)
+}
+
+// mergeList appends the values missing from the comma separated list.
+func mergeList(list string, values []string) string {
+	var items []string
+	seen := map[string]bool{}
+	for _, item := range append(strings.Split(list, ","), values...) {
+		item = strings.TrimSpace(item)
+		if item != "" && !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// ValidateReloader returns an error if the reloader isn't supported.
+func ValidateReloader(reloader string) error {
+	switch reloader {
+	case "", StakaterReloader, WaveReloader:
+		return nil
+	}
+	return fmt.Errorf("unsupported reloader %s, expected %s or %s", reloader, StakaterReloader, WaveReloader)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+// mounting returns a pod template mounting the secrets.
+func mounting(secrets ...string) corev1.PodTemplateSpec {
+	var template corev1.PodTemplateSpec
+	for _, name := range secrets {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name:         name,
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}},
+		})
+	}
+	return template
+}
+
+func TestAnnotateReloadTargets(t *testing.T) {
+	ctx := context.TODO()
+	released := map[string]string{resource.ManagedByLabel: resource.ManagedByValue, resource.StatefulSetLabel: "crdb"}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-client-secret", Namespace: "ns", Labels: released}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-ca-secret", Namespace: "ns", Labels: released}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "ns"}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "ns",
+				Annotations: map[string]string{StakaterSecretReload: "app-config"}},
+			Spec: appsv1.DeploymentSpec{Template: mounting("crdb-client-secret", "app-config")},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns"},
+			Spec:       appsv1.DeploymentSpec{Template: mounting("app-config")},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+			Spec:       appsv1.StatefulSetSpec{Template: mounting("crdb-ca-secret")},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "ns"},
+			Spec:       appsv1.StatefulSetSpec{Template: mounting("crdb-ca-secret", "crdb-client-secret")},
+		},
+	)
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.DiscoveryServiceName = "crdb"
+	rc.Reloader = StakaterReloader
+
+	annotations := func(obj client.Object, name string) map[string]string {
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: name}, obj))
+		return obj.GetAnnotations()
+	}
+
+	// the workloads mounting the secrets of the release are annotated, except for the release itself
+	require.NoError(t, rc.annotateReloadTargets(ctx, "ns"))
+	assert.Equal(t, "app-config,crdb-client-secret", annotations(&appsv1.Deployment{}, "app")[StakaterSecretReload])
+	assert.Empty(t, annotations(&appsv1.Deployment{}, "other"))
+	assert.Empty(t, annotations(&appsv1.StatefulSet{}, "crdb"))
+	assert.Equal(t, "crdb-ca-secret,crdb-client-secret", annotations(&appsv1.StatefulSet{}, "worker")[StakaterSecretReload])
+
+	// the secrets are marked for Reloader
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"}}
+	_, err := rc.persister()(ctx, cl, secret, func() error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, "true", annotations(&corev1.Secret{}, "crdb-node-secret")[StakaterMatch])
+
+	rc.Reloader = WaveReloader
+	require.NoError(t, rc.annotateReloadTargets(ctx, "ns"))
+	assert.Equal(t, "true", annotations(&appsv1.Deployment{}, "app")[WaveUpdateOnChange])
+
+	assert.NoError(t, ValidateReloader(""))
+	assert.Error(t, ValidateReloader("flux"))
+}
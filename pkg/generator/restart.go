@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RestartedAt is the annotation of the pod template set to restart the pods of a workload, as by
+// kubectl rollout restart.
+const RestartedAt = "kubectl.kubernetes.io/restartedAt"
+
+// WorkloadSelector selects the Deployments and StatefulSets of the namespace restarted when the client
+// certificates are rotated.
+type WorkloadSelector struct {
+	// Kind is Deployment or StatefulSet, both if empty.
+	Kind     string
+	Selector labels.Selector
+}
+
+// ParseWorkloadSelectors parses the [deployments:|statefulsets:]<label selector> workload selectors.
+func ParseWorkloadSelectors(values []string) ([]WorkloadSelector, error) {
+	var selectors []WorkloadSelector
+	for _, value := range values {
+		var kind string
+		switch {
+		case strings.HasPrefix(value, "deployments:"):
+			kind, value = "Deployment", strings.TrimPrefix(value, "deployments:")
+		case strings.HasPrefix(value, "statefulsets:"):
+			kind, value = "StatefulSet", strings.TrimPrefix(value, "statefulsets:")
+		}
+
+		// an empty selector would restart every workload of the namespace
+		if strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("empty label selector of the workloads to restart")
+		}
+		selector, err := labels.Parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid label selector %q of the workloads to restart", value)
+		}
+		selectors = append(selectors, WorkloadSelector{Kind: kind, Selector: selector})
+	}
+	return selectors, nil
+}
+
+// restartWorkloads restarts the workloads selected by RestartWorkloads if client certificates were rotated by the
+// current run, so that the applications mounting them pick the new certificates. The StatefulSet of the release
+// is never restarted for the client certificates.
+func (rc *GenerateCert) restartWorkloads(ctx context.Context, namespace string) error {
+	if len(rc.RestartWorkloads) == 0 || len(rc.rotatedClients) == 0 {
+		return nil
+	}
+
+	restarted := map[string]bool{}
+	for _, s := range rc.RestartWorkloads {
+		workloads, err := rc.listWorkloads(ctx, namespace, client.MatchingLabelsSelector{Selector: s.Selector})
+		if err != nil {
+			return err
+		}
+
+		for _, w := range workloads {
+			id := w.kind + "/" + w.obj.GetName()
+			if (s.Kind != "" && w.kind != s.Kind) || restarted[id] ||
+				(w.kind == "StatefulSet" && w.obj.GetName() == rc.DiscoveryServiceName) {
+				continue
+			}
+			restarted[id] = true
+
+			if err := rc.restartWorkload(ctx, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restartWorkload starts a rolling restart of the pods of the workload.
+func (rc *GenerateCert) restartWorkload(ctx context.Context, w workload) error {
+	key := client.ObjectKeyFromObject(w.obj)
+	now := rc.now().Format(time.RFC3339)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := rc.client.Get(ctx, key, w.obj); err != nil {
+			return err
+		}
+
+		if w.template.Annotations == nil {
+			w.template.Annotations = map[string]string{}
+		}
+		w.template.Annotations[RestartedAt] = now
+		return rc.client.Update(ctx, w.obj)
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to restart %s [%s]", w.kind, key.Name)
+	}
+
+	logrus.Infof("Restarted %s [%s] for the rotation of client secrets %v", w.kind, key.Name, rc.rotatedClients)
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestRestartWorkloads(t *testing.T) {
+	ctx := context.TODO()
+	app := map[string]string{"app": "web"}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", Labels: app}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "ns"}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web-cache", Namespace: "ns", Labels: app}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns", Labels: app}},
+	)
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.DiscoveryServiceName = "crdb"
+	rc.Clock = clock.NewFake(now)
+
+	selectors, err := ParseWorkloadSelectors([]string{"deployments:app=web", "statefulsets:app in (web)"})
+	require.NoError(t, err)
+	rc.RestartWorkloads = selectors
+
+	restartedAt := func(obj client.Object, name string) string {
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: name}, obj))
+		switch w := obj.(type) {
+		case *appsv1.Deployment:
+			return w.Spec.Template.Annotations[RestartedAt]
+		case *appsv1.StatefulSet:
+			return w.Spec.Template.Annotations[RestartedAt]
+		}
+		return ""
+	}
+
+	// nothing is restarted until client certificates are rotated
+	require.NoError(t, rc.restartWorkloads(ctx, "ns"))
+	assert.Empty(t, restartedAt(&appsv1.Deployment{}, "web"))
+
+	rc.rotatedClients = []string{"crdb-client-secret"}
+	require.NoError(t, rc.restartWorkloads(ctx, "ns"))
+	assert.Equal(t, now.Format(time.RFC3339), restartedAt(&appsv1.Deployment{}, "web"))
+	assert.Equal(t, now.Format(time.RFC3339), restartedAt(&appsv1.StatefulSet{}, "web-cache"))
+	assert.Empty(t, restartedAt(&appsv1.Deployment{}, "batch"))
+	// the statefulset of the release isn't restarted for its client certificates
+	assert.Empty(t, restartedAt(&appsv1.StatefulSet{}, "crdb"))
+
+	// the kind is optional, and the selector required
+	selectors, err = ParseWorkloadSelectors([]string{"app=web"})
+	require.NoError(t, err)
+	assert.Equal(t, "", selectors[0].Kind)
+	_, err = ParseWorkloadSelectors([]string{"deployments:"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// The keys of the root password secret, and the annotation holding the checksum of the password last
+// set in the cluster.
+const (
+	RootPasswordUsernameKey = "username"
+	RootPasswordKey         = "password"
+	RootPasswordApplied     = "root-password-applied-checksum"
+)
+
+func (rc *GenerateCert) getRootPasswordSecretName() string {
+	if rc.RootPasswordSecretName != "" {
+		return rc.RootPasswordSecretName
+	}
+	return rc.DiscoveryServiceName + "-root-password"
+}
+
+// generateRootPassword stores a random password for the root SQL user in the root password secret, for
+// clients which can't authenticate with a client certificate, and sets it in the cluster once the cluster
+// is available. Until then, the password is only stored and is set by a later run. The password is
+// generated once and kept across runs; changing it in the secret sets the new password in the cluster.
+func (rc *GenerateCert) generateRootPassword(ctx context.Context, namespace string) error {
+	name := rc.getRootPasswordSecretName()
+
+	// the current secret is read first, as the apply persister mutates an empty secret
+	current := &corev1.Secret{}
+	err := rc.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, current)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrapf(err, "failed to get root password secret [%s]", name)
+	}
+
+	password := current.Data[RootPasswordKey]
+	if len(password) == 0 {
+		generated, err := security.GeneratePassword()
+		if err != nil {
+			return err
+		}
+		password = []byte(generated)
+
+		if err := rc.storeRootPassword(ctx, namespace, password, ""); err != nil {
+			return err
+		}
+		logrus.Infof("Generated root password in secret [%s]", name)
+	}
+
+	checksum := passwordChecksum(password)
+	if current.Annotations[RootPasswordApplied] == checksum {
+		return nil
+	}
+
+	available, err := rc.clusterAvailable(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if !available {
+		logrus.Infof("Cluster is not available yet, the root password of secret [%s] is set by the next run", name)
+		return nil
+	}
+
+	statement := security.AlterPasswordStatement(security.RootUser, string(password)) + ";\n"
+	if err := rc.execSQLInput(ctx, namespace, statement); err != nil {
+		return errors.Wrap(err, "failed to set root password")
+	}
+
+	if err := rc.storeRootPassword(ctx, namespace, password, checksum); err != nil {
+		return err
+	}
+
+	logrus.Infof("Set root password of secret [%s]", name)
+	return nil
+}
+
+// storeRootPassword writes the password to the root password secret, along with the checksum of the password
+// set in the cluster if not empty, in a single write so that the persister keeps owning both.
+func (rc *GenerateCert) storeRootPassword(ctx context.Context, namespace string, password []byte, applied string) error {
+	secret := &corev1.Secret{}
+	secret.SetName(rc.getRootPasswordSecretName())
+	secret.SetNamespace(namespace)
+
+	_, err := rc.persister()(ctx, rc.client, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[RootPasswordUsernameKey] = []byte(security.RootUser)
+		secret.Data[RootPasswordKey] = password
+
+		if applied != "" {
+			if secret.Annotations == nil {
+				secret.Annotations = map[string]string{}
+			}
+			secret.Annotations[RootPasswordApplied] = applied
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to store root password in secret [%s]", secret.Name)
+	}
+	return nil
+}
+
+// clusterAvailable returns true once a pod of the statefulset is ready, which requires the cluster to be
+// initialized.
+func (rc *GenerateCert) clusterAvailable(ctx context.Context, namespace string) (bool, error) {
+	var sts appsv1.StatefulSet
+	err := rc.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: rc.DiscoveryServiceName}, &sts)
+	if client.IgnoreNotFound(err) != nil {
+		return false, errors.Wrapf(err, "failed to get statefulset [%s]", rc.DiscoveryServiceName)
+	}
+
+	return sts.Status.ReadyReplicas > 0, nil
+}
+
+// passwordChecksum returns the checksum of the password recorded once it is set in the cluster.
+func passwordChecksum(password []byte) string {
+	sum := sha256.Sum256(password)
+	return hex.EncodeToString(sum[:])
+}
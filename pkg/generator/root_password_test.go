@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestGenerateRootPasswordBeforeClusterIsAvailable(t *testing.T) {
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"}}
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), sts)
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+
+	require.NoError(t, rc.generateRootPassword(context.TODO(), "ns"))
+
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-root-password"}, &secret))
+	assert.Equal(t, "root", string(secret.Data[RootPasswordUsernameKey]))
+	assert.Len(t, secret.Data[RootPasswordKey], 43)
+
+	// no pod is ready, so the password isn't set in the cluster yet
+	assert.NotContains(t, secret.Annotations, RootPasswordApplied)
+
+	// the password is kept by later runs
+	password := secret.Data[RootPasswordKey]
+	require.NoError(t, rc.generateRootPassword(context.TODO(), "ns"))
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-root-password"}, &secret))
+	assert.Equal(t, password, secret.Data[RootPasswordKey])
+}
+
+func TestGenerateRootPasswordAlreadyApplied(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "custom-password",
+			Namespace:   "ns",
+			Annotations: map[string]string{RootPasswordApplied: passwordChecksum([]byte("secret"))},
+		},
+		Data: map[string][]byte{RootPasswordKey: []byte("secret")},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Status:     appsv1.StatefulSetStatus{ReadyReplicas: 3},
+	}
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), secret, sts)
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.RootPasswordSecretName = "custom-password"
+
+	// the cluster is available but the password was set already, so no SQL is run
+	require.NoError(t, rc.generateRootPassword(context.TODO(), "ns"))
+}
+
+func TestGenerateRootPasswordKeepsUserPassword(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-root-password", Namespace: "ns"},
+		Data:       map[string][]byte{RootPasswordKey: []byte("user-set")},
+	}
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"}}
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), secret, sts)
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+
+	// the password set by the user is kept, and set in the cluster once available
+	require.NoError(t, rc.generateRootPassword(context.TODO(), "ns"))
+
+	var stored corev1.Secret
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-root-password"}, &stored))
+	assert.Equal(t, "user-set", string(stored.Data[RootPasswordKey]))
+	assert.NotContains(t, stored.Annotations, RootPasswordApplied)
+}
+
+func TestStoreRootPasswordApplied(t *testing.T) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+
+	password := []byte("secret")
+	require.NoError(t, rc.storeRootPassword(context.TODO(), "ns", password, ""))
+	require.NoError(t, rc.storeRootPassword(context.TODO(), "ns", password, passwordChecksum(password)))
+
+	// the password is applied along with the annotation, so it isn't dropped from the secret
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-root-password"}, &secret))
+	assert.Equal(t, "root", string(secret.Data[RootPasswordUsernameKey]))
+	assert.Equal(t, password, secret.Data[RootPasswordKey])
+	assert.Equal(t, passwordChecksum(password), secret.Annotations[RootPasswordApplied])
+}
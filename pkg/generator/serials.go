@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/x509"
+	"math/big"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// loadSerialRegistry loads the registry of issued serial numbers of the namespace, if SerialRegistryName is
+// set, and prunes the records of the certificates which expired more than a CA lifetime ago.
+func (rc *GenerateCert) loadSerialRegistry(ctx context.Context, namespace string) error {
+	rc.serials = nil
+	if rc.SerialRegistryName == "" {
+		return nil
+	}
+
+	registry, err := resource.LoadSerialRegistry(rc.SerialRegistryName,
+		resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get serial registry [%s]", rc.SerialRegistryName)
+	}
+
+	if err := registry.Prune(rc.now().Add(-rc.CaCertConfig.Duration)); err != nil {
+		return errors.Wrapf(err, "failed to prune serial registry [%s]", rc.SerialRegistryName)
+	}
+
+	rc.serials = registry
+	return nil
+}
+
+// registerSerial records the serial number of the certificate issued to the secret. Serial numbers are
+// random, so a serial number issued twice by the CA is not expected, but it would make revoking one of the
+// certificates revoke the other one as well, so it fails the run.
+func (rc *GenerateCert) registerSerial(secretName string, cert *x509.Certificate) error {
+	if rc.serials == nil {
+		return nil
+	}
+
+	err := rc.serials.Register(cert.SerialNumber, resource.IssuedSerial{
+		Secret:     secretName,
+		CommonName: cert.Subject.CommonName,
+		IssuedAt:   rc.now().UTC(),
+		NotAfter:   cert.NotAfter.UTC(),
+	})
+	return errors.Wrapf(err, "failed to register the serial of the certificate of secret [%s]", secretName)
+}
+
+// revoked returns true if the certificate of the secret was revoked in the serial registry.
+func (rc *GenerateCert) revoked(secret *resource.TLSSecret) (bool, string) {
+	if rc.serials == nil {
+		return false, ""
+	}
+
+	cert, err := security.GetCertObj(secret.TLSCert())
+	if err != nil {
+		return false, ""
+	}
+
+	if issued, ok := rc.serials.Lookup(cert.SerialNumber); ok && issued.RevokedAt != nil {
+		return true, "Certificate revoked, renewing certificate"
+	}
+	return false, ""
+}
+
+// Revoke marks the certificate of the serial number as revoked in the serial registry, so that the next
+// rotation run replaces it.
+func (rc *GenerateCert) Revoke(ctx context.Context, namespace string, serial *big.Int, reason string) error {
+	if rc.SerialRegistryName == "" {
+		return errors.New("certificates can only be revoked with a serial registry")
+	}
+
+	if err := rc.loadSerialRegistry(ctx, namespace); err != nil {
+		return err
+	}
+
+	issued, ok := rc.serials.Lookup(serial)
+	if !ok {
+		return errors.Errorf("serial %s wasn't issued", resource.SerialKey(serial))
+	}
+
+	if err := rc.serials.Revoke(serial, reason, rc.now()); err != nil {
+		return errors.Wrapf(err, "failed to revoke serial %s", resource.SerialKey(serial))
+	}
+
+	logrus.Infof("Revoked certificate %s of secret [%s], it is replaced by the next rotation run",
+		resource.SerialKey(serial), issued.Secret)
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestRevokeSerial(t *testing.T) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.CaCertConfig.Duration = 43800 * time.Hour
+	rc.NodeCertConfig.Duration = 8760 * time.Hour
+	rc.NodeAndClientCronSchedule = "0 0 1 */1 *"
+	rc.SQLProxyHosts = []string{"sql.example.com"}
+	rc.SerialRegistryName = "crdb-serials"
+	rc.RotateNodeCert = true
+
+	rc.CertsDir = t.TempDir()
+	rc.CAKey = filepath.Join(t.TempDir(), "ca.key")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), []byte(testcerts.CACert), security.CertFileMode))
+	require.NoError(t, ioutil.WriteFile(rc.CAKey, []byte(testcerts.CAKey), security.KeyFileMode))
+
+	issued := func() *x509.Certificate {
+		var secret corev1.Secret
+		require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-sqlproxy-secret"}, &secret))
+		cert, err := security.GetCertObj(secret.Data[corev1.TLSCertKey])
+		require.NoError(t, err)
+		return cert
+	}
+
+	require.NoError(t, rc.loadSerialRegistry(context.TODO(), "ns"))
+	require.NoError(t, rc.generateTenantCerts(context.TODO(), "ns"))
+	cert := issued()
+
+	record, ok := rc.serials.Lookup(cert.SerialNumber)
+	require.True(t, ok)
+	assert.Equal(t, "crdb-sqlproxy-secret", record.Secret)
+
+	// a certificate which isn't revoked is kept
+	require.NoError(t, rc.generateTenantCerts(context.TODO(), "ns"))
+	assert.Equal(t, cert.SerialNumber, issued().SerialNumber)
+
+	require.NoError(t, rc.Revoke(context.TODO(), "ns", cert.SerialNumber, "keyCompromise"))
+	require.NoError(t, rc.generateTenantCerts(context.TODO(), "ns"))
+	assert.NotEqual(t, cert.SerialNumber, issued().SerialNumber)
+
+	record, _ = rc.serials.Lookup(cert.SerialNumber)
+	require.NotNil(t, record.RevokedAt)
+	assert.Equal(t, "keyCompromise", record.Reason)
+}
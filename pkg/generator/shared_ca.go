@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// CASharedWith lists the namespaces of the other releases whose certificates are issued by the CA of the
+// secret, as a comma separated list. It is removed once the CA is no longer shared.
+const CASharedWith = "crdb.io/ca-shared-with"
+
+// SharedCARotation is the reason of the warning event recorded on the CA secret when a CA shared with
+// other releases is rotated.
+const SharedCARotation = "SharedCARotation"
+
+// sharedCA returns the sorted namespaces, other than namespace, holding secrets written by the self-signer
+// whose certificates are issued by the CA certificate of the secret.
+func (rc *GenerateCert) sharedCA(ctx context.Context, namespace string, ca *resource.TLSSecret) ([]string, error) {
+	fingerprint, err := security.Fingerprint(ca.CA())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the fingerprint of CA secret [%s]", ca.Secret().Name)
+	}
+
+	namespaces := rc.SharedCANamespaces
+	if len(namespaces) == 0 {
+		// all the namespaces of the cluster
+		namespaces = []string{""}
+	}
+
+	shared := map[string]bool{}
+	for _, ns := range namespaces {
+		var list corev1.SecretList
+		err := rc.client.List(ctx, &list, client.InNamespace(ns),
+			client.MatchingLabels{resource.ManagedByLabel: resource.ManagedByValue})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list the cert secrets")
+		}
+
+		for _, secret := range list.Items {
+			if secret.Namespace != namespace && secret.Annotations[resource.IssuerFingerprint] == fingerprint {
+				shared[secret.Namespace] = true
+			}
+		}
+	}
+
+	var sharedWith []string
+	for ns := range shared {
+		sharedWith = append(sharedWith, ns)
+	}
+	sort.Strings(sharedWith)
+
+	return sharedWith, nil
+}
+
+// detectSharedCA records the namespaces of the other releases sharing the CA of the secret in its
+// CASharedWith annotation and returns them. It is best effort, a failure is only logged.
+func (rc *GenerateCert) detectSharedCA(ctx context.Context, namespace string, ca *resource.TLSSecret) []string {
+	if !rc.DetectSharedCA {
+		return nil
+	}
+
+	sharedWith, err := rc.sharedCA(ctx, namespace, ca)
+	if err != nil {
+		logrus.Warnf("Failed to detect the releases sharing CA secret [%s]: %s", ca.Secret().Name, err)
+		return nil
+	}
+
+	value := strings.Join(sharedWith, ",")
+	secret := ca.Secret()
+	if secret.Annotations[CASharedWith] == value {
+		return sharedWith
+	}
+
+	_, err = rc.persister()(ctx, rc.client, secret, func() error {
+		if value == "" {
+			delete(secret.Annotations, CASharedWith)
+			return nil
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[CASharedWith] = value
+		return nil
+	})
+	if err != nil {
+		logrus.Warnf("Failed to annotate CA secret [%s] with the releases sharing it: %s", secret.Name, err)
+		return sharedWith
+	}
+
+	if value != "" {
+		logrus.Infof("CA secret [%s] is shared with the releases of namespaces %s", secret.Name, value)
+	}
+	return sharedWith
+}
+
+// warnSharedCARotation warns that rotating the CA of the secret breaks the other releases sharing it, whose
+// certificates are no longer trusted by the pods of this release once the old CA is dropped from the bundle.
+// The secret isn't annotated, as the rotation replaces its annotations.
+func (rc *GenerateCert) warnSharedCARotation(ctx context.Context, namespace string, ca *resource.TLSSecret) {
+	if !rc.DetectSharedCA {
+		return
+	}
+
+	sharedWith, err := rc.sharedCA(ctx, namespace, ca)
+	if err != nil {
+		logrus.Warnf("Failed to detect the releases sharing CA secret [%s]: %s", ca.Secret().Name, err)
+		return
+	}
+	if len(sharedWith) == 0 {
+		return
+	}
+
+	secret := ca.Secret()
+	message := fmt.Sprintf("Rotating CA secret [%s] shared with the releases of namespaces %s, their certificates "+
+		"must be reissued by the new CA before the old CA expires", secret.Name, strings.Join(sharedWith, ","))
+	logrus.Warn(message)
+
+	ref := corev1.ObjectReference{Kind: "Secret", APIVersion: "v1", Namespace: namespace, Name: secret.Name, UID: secret.UID}
+	if err := kube.RecordEvent(ctx, rc.client, ref, corev1.EventTypeWarning, SharedCARotation, message); err != nil {
+		logrus.Warnf("Failed to record the shared CA rotation event of secret [%s]: %s", secret.Name, err)
+	}
+}
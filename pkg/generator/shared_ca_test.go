@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestDetectSharedCA(t *testing.T) {
+	ctx := context.TODO()
+	fingerprint, err := security.Fingerprint([]byte(testcerts.CACert))
+	require.NoError(t, err)
+	otherFingerprint, err := security.Fingerprint([]byte(testcerts.OtherCACert))
+	require.NoError(t, err)
+
+	managed := func(namespace, name, issuer string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{resource.ManagedByLabel: resource.ManagedByValue},
+			Annotations: map[string]string{resource.IssuerFingerprint: issuer},
+		}}
+	}
+
+	objs := []client.Object{
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "user-ca", Namespace: "tenant-a"},
+			Data: map[string][]byte{
+				resource.CaCert: []byte(testcerts.CACert),
+				resource.CaKey:  []byte(testcerts.CAKey),
+			},
+		},
+		// the secrets of the release itself don't make the CA shared
+		managed("tenant-a", "crdb-node-secret", fingerprint),
+		managed("tenant-b", "crdb-node-secret", fingerprint),
+		managed("tenant-b", "crdb-client-secret", fingerprint),
+		managed("tenant-c", "crdb-node-secret", otherFingerprint),
+		managed("tenant-d", "crdb-node-secret", fingerprint),
+	}
+
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), objs...)
+	var events []client.ObjectKey
+	cl.AddReactor("create", "events", func(action testutils.Action) (bool, error) {
+		events = append(events, action.Key())
+		return false, nil
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.Persister = kube.DefaultPersister
+	rc.CertsDir = t.TempDir()
+	rc.CAKey = filepath.Join(rc.CertsDir, resource.CaKey)
+	rc.CaSecret = "user-ca"
+
+	caSecret := func() *corev1.Secret {
+		var secret corev1.Secret
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "tenant-a", Name: "user-ca"}, &secret))
+		return &secret
+	}
+
+	// disabled by default
+	require.NoError(t, rc.generateCA(ctx, "crdb-ca-secret", "tenant-a"))
+	assert.NotContains(t, caSecret().Annotations, CASharedWith)
+
+	rc.DetectSharedCA = true
+	require.NoError(t, rc.generateCA(ctx, "crdb-ca-secret", "tenant-a"))
+	assert.Equal(t, "tenant-b,tenant-d", caSecret().Annotations[CASharedWith])
+
+	rc.SharedCANamespaces = []string{"tenant-b", "tenant-c"}
+	require.NoError(t, rc.generateCA(ctx, "crdb-ca-secret", "tenant-a"))
+	assert.Equal(t, "tenant-b", caSecret().Annotations[CASharedWith])
+
+	// the rotation of the shared CA is warned about on the CA secret
+	secret, err := resource.LoadTLSSecret("user-ca", resource.NewKubeResource(ctx, cl, "tenant-a", rc.persister()))
+	require.NoError(t, err)
+	rc.warnSharedCARotation(ctx, "tenant-a", secret)
+	require.Len(t, events, 1)
+
+	var event corev1.Event
+	require.NoError(t, cl.Get(ctx, events[0], &event))
+	assert.Equal(t, SharedCARotation, event.Reason)
+	assert.Equal(t, corev1.EventTypeWarning, event.Type)
+	assert.Equal(t, "user-ca", event.InvolvedObject.Name)
+	assert.Contains(t, event.Message, "tenant-b")
+
+	// the annotation is removed once the CA is no longer shared
+	rc.SharedCANamespaces = []string{"tenant-c"}
+	require.NoError(t, rc.generateCA(ctx, "crdb-ca-secret", "tenant-a"))
+	assert.NotContains(t, caSecret().Annotations, CASharedWith)
+
+	rc.warnSharedCARotation(ctx, "tenant-a", secret)
+	assert.Len(t, events, 1)
+}
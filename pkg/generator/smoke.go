@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/health"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// smokeTestPort is a port of the pods whose certificate is checked after a rotation.
+type smokeTestPort struct {
+	name       string
+	port       int
+	serverName string
+	// secret holds the CA verifying the port and the certificate it is expected to present
+	secret *resource.TLSSecret
+}
+
+// smokeTest dials the SQL and HTTP ports of the pods, all the pods of the statefulset if none is given, once
+// they were restarted after a rotation, and checks that each presents the certificate of its secret, verified
+// with the CA of the secret. The result of each port is logged, recorded as an event of the pod and in the
+// smokeTestResults metric. Failures don't fail the run, as the certificates were already rotated.
+func (rc *GenerateCert) smokeTest(ctx context.Context, namespace string, pods ...string) error {
+	if !rc.SmokeTest {
+		return nil
+	}
+
+	if len(pods) == 0 {
+		replicas, err := rc.statefulSetReplicas(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < replicas; i++ {
+			pods = append(pods, fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i))
+		}
+	}
+
+	for _, pod := range pods {
+		ports, err := rc.smokeTestPorts(ctx, namespace, pod)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range ports {
+			rc.smokeTestPort(ctx, namespace, pod, p)
+		}
+	}
+
+	return nil
+}
+
+// smokeTestPorts returns the ports of the pod to check. The SQL port presents the node certificate for the
+// name of the pod, and the HTTP port the DB Console certificate, if any, for the name of the public service.
+func (rc *GenerateCert) smokeTestPorts(ctx context.Context, namespace, pod string) ([]smokeTestPort, error) {
+	nodeSecretName := rc.getNodeSecretName()
+	if rc.PerNodeCerts {
+		nodeSecretName = rc.PodSecretName(pod)
+	}
+
+	node, err := rc.loadCurrentSecret(ctx, namespace, nodeSecretName)
+	if err != nil {
+		return nil, err
+	}
+
+	ui := node
+	if rc.UICert {
+		if ui, err = rc.loadCurrentSecret(ctx, namespace, rc.getUISecretName()); err != nil {
+			return nil, err
+		}
+	}
+
+	sqlPort, httpPort := rc.SQLPort, rc.HTTPPort
+	if sqlPort == 0 {
+		sqlPort = defaultSQLPort
+	}
+	if httpPort == 0 {
+		httpPort = defaultHTTPPort
+	}
+
+	return []smokeTestPort{
+		{name: "sql", port: sqlPort, serverName: rc.podFQDN(namespace, pod), secret: node},
+		{name: "http", port: httpPort, secret: ui,
+			serverName: fmt.Sprintf("%s.%s.svc.%s", rc.PublicServiceName, namespace, rc.ClusterDomain)},
+	}, nil
+}
+
+// smokeTestPort checks a port of the pod and reports the result.
+func (rc *GenerateCert) smokeTestPort(ctx context.Context, namespace, pod string, p smokeTestPort) {
+	addr := fmt.Sprintf("%s:%d", rc.podFQDN(namespace, pod), p.port)
+	leaf, err := health.VerifyTLS(ctx, addr, p.serverName, p.secret.CA(), p.secret.TLSCert())
+
+	eventType, reason, message := corev1.EventTypeNormal, "TLSSmokeTestPassed", ""
+	if err != nil {
+		eventType, reason = corev1.EventTypeWarning, "TLSSmokeTestFailed"
+		message = fmt.Sprintf("The %s port doesn't present the certificate of secret %s: %s", p.name, p.secret.Secret().Name, err)
+		logrus.Warnf("Pod [%s]: %s", pod, message)
+		smokeTestResults.WithLabelValues(namespace, pod, p.name).Set(0)
+	} else {
+		message = fmt.Sprintf("The %s port presents the certificate with serial %s of secret %s", p.name,
+			leaf.SerialNumber.Text(16), p.secret.Secret().Name)
+		logrus.Infof("Pod [%s]: %s", pod, message)
+		smokeTestResults.WithLabelValues(namespace, pod, p.name).Set(1)
+	}
+
+	ref := corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: namespace, Name: pod}
+	if err := kube.RecordEvent(ctx, rc.client, ref, eventType, reason, message); err != nil {
+		logrus.Warnf("Failed to record the smoke test event of pod [%s]: %s", pod, err)
+	}
+}
+
+// loadCurrentSecret loads the secret holding the current version of the secret name.
+func (rc *GenerateCert) loadCurrentSecret(ctx context.Context, namespace, name string) (*resource.TLSSecret, error) {
+	currentName, err := rc.currentSecretName(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := resource.LoadTLSSecret(currentName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	return secret, errors.Wrapf(err, "failed to get secret [%s]", currentName)
+}
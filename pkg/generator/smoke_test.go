@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestSmokeTestFailure(t *testing.T) {
+	ctx := context.TODO()
+	replicas := int32(2)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), sts)
+	r := resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister)
+
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	secret := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, secret.UpdateTLSSecret(nodeCert, nodeKey, []byte(testcerts.CACert), map[string]string{}))
+
+	var events []*corev1.Event
+	cl.AddReactor("create", "events", func(action testutils.Action) (bool, error) {
+		events = append(events, action.(*testutils.CreateAction).Object().(*corev1.Event))
+		return false, nil
+	})
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.PublicServiceName = "crdb-public"
+	rc.ClusterDomain = "invalid"
+	rc.Persister = kube.DefaultPersister
+
+	// nothing is checked unless enabled
+	require.NoError(t, rc.smokeTest(ctx, "ns"))
+	assert.Empty(t, events)
+
+	// the pods can't be reached, which is reported without failing the run
+	rc.SmokeTest = true
+	require.NoError(t, rc.smokeTest(ctx, "ns"))
+	require.Len(t, events, 4)
+	for _, event := range events {
+		assert.Equal(t, corev1.EventTypeWarning, event.Type)
+		assert.Equal(t, "TLSSmokeTestFailed", event.Reason)
+		assert.Contains(t, event.Message, "crdb-node-secret")
+	}
+	assert.Equal(t, "crdb-0", events[0].InvolvedObject.Name)
+	assert.Equal(t, "crdb-1", events[3].InvolvedObject.Name)
+
+	for _, pod := range []string{"crdb-0", "crdb-1"} {
+		for _, port := range []string{"sql", "http"} {
+			assert.Equal(t, float64(0), testutil.ToFloat64(smokeTestResults.WithLabelValues("ns", pod, port)))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// fetchNodeSVID writes the X509-SVID of the CockroachDB workload to node.crt and node.key in the certs
+// directory, and returns the PEM encoded bundle of its trust domain, which the nodes need to trust.
+func (rc *GenerateCert) fetchNodeSVID(ctx context.Context, hosts []string) ([]byte, error) {
+	svid, bundle, err := rc.SPIRE.FetchX509(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// SVIDs only hold the DNS names of the registration entry, so the hosts can't be checked by the CA
+	if missing := missingDNSNames(svid.DNSNames(), hosts); len(missing) > 0 {
+		logrus.Warnf("The X509-SVID %s doesn't cover the hosts %s, add them to the DNS names of its registration entry",
+			svid.ID, strings.Join(missing, ", "))
+	}
+
+	pemCert, pemKey, err := svid.PEM()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"node.crt", pemCert, security.CertFileMode},
+		{"node.key", pemKey, security.KeyFileMode},
+	}
+
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(rc.CertsDir, f.name), f.data, f.mode); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %s", f.name)
+		}
+	}
+
+	logrus.Infof("Fetched X509-SVID %s from the SPIRE agent, expiring at %s", svid.ID, svid.ExpiresAt)
+	return bundle, nil
+}
+
+// missingDNSNames returns the hosts which are not DNS names of the SVID. IP addresses are skipped, as
+// SVIDs don't hold any.
+func missingDNSNames(dnsNames, hosts []string) []string {
+	names := map[string]bool{}
+	for _, name := range dnsNames {
+		names[name] = true
+	}
+
+	var missing []string
+	for _, host := range hosts {
+		if net.ParseIP(host) == nil && !names[host] {
+			missing = append(missing, host)
+		}
+	}
+	return missing
+}
+
+// withSPIREBundle prepends the bundle of the trust domain of the node SVIDs to the CA, so that the clients
+// verify the node certificates.
+func (rc *GenerateCert) withSPIREBundle(ctx context.Context, ca []byte) ([]byte, error) {
+	if rc.SPIRE == nil {
+		return ca, nil
+	}
+
+	_, bundle, err := rc.SPIRE.FetchX509(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(bundle, ca...), nil
+}
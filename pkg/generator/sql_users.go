@@ -0,0 +1,199 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	util "github.com/cockroachdb/helm-charts/pkg/utils"
+)
+
+const defaultSQLPort = 26257
+
+// sqlHost returns the address of the public service of the cluster.
+func (rc *GenerateCert) sqlHost(namespace string) string {
+	port := rc.SQLPort
+	if port == 0 {
+		port = defaultSQLPort
+	}
+
+	return fmt.Sprintf("%s.%s.svc.%s:%d", rc.PublicServiceName, namespace, rc.ClusterDomain, port)
+}
+
+// execSQL runs the SQL statements against the cluster using the root client certificate.
+func (rc *GenerateCert) execSQL(ctx context.Context, namespace string, statements ...string) error {
+	return rc.withSQLCerts(ctx, namespace, func(certsDir, host string) error {
+		return security.ExecSQL(certsDir, host, statements...)
+	})
+}
+
+// execSQLInput runs the SQL statements of input against the cluster using the root client certificate,
+// piping them to the crdb binary so that they don't show up in its command line.
+func (rc *GenerateCert) execSQLInput(ctx context.Context, namespace, input string) error {
+	return rc.withSQLCerts(ctx, namespace, func(certsDir, host string) error {
+		return security.ExecSQLInput(certsDir, host, input)
+	})
+}
+
+// withSQLCerts writes the root client certificate to a temporary certs directory and calls fn with it
+// and the address of the cluster.
+func (rc *GenerateCert) withSQLCerts(ctx context.Context, namespace string, fn func(certsDir, host string) error) error {
+	if rc.PublicServiceName == "" {
+		return errors.New("statefulset name is required to connect to the cluster")
+	}
+
+	secretName, err := rc.currentSecretName(ctx, namespace, rc.getClientSecretName())
+	if err != nil {
+		return err
+	}
+
+	secret, err := resource.LoadTLSSecret(secretName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return errors.Wrap(err, "failed to get root client secret")
+	}
+
+	if !secret.Ready() {
+		return errors.New("root client secret doesn't contain the required cert/key")
+	}
+
+	sqlCertsDir, cleanup := util.CreateTempDir("sqlCertsDir")
+	defer cleanup()
+
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{resource.CaCert, secret.CA(), security.CertFileMode},
+		{fmt.Sprintf("client.%s.crt", security.RootUser), secret.TLSCert(), security.CertFileMode},
+		{fmt.Sprintf("client.%s.key", security.RootUser), secret.TLSPrivateKey(), security.KeyFileMode},
+	}
+
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(sqlCertsDir, f.name), f.data, f.mode); err != nil {
+			return errors.Wrapf(err, "failed to write %s", f.name)
+		}
+	}
+
+	return fn(sqlCertsDir, rc.sqlHost(namespace))
+}
+
+// provisionSQLUsers creates the SQL users for which client certificates were generated, and reconciles their
+// configured grants, so that the certificates are usable right away. The privileges and roles of a user with
+// grants which are no longer configured are revoked. The cluster must be running.
+func (rc *GenerateCert) provisionSQLUsers(ctx context.Context, namespace string, users []string) error {
+	var creates []string
+	var grants []security.Grant
+	for _, user := range users {
+		if user == security.RootUser {
+			continue
+		}
+		creates = append(creates, security.CreateUserStatement(user))
+		grants = append(grants, rc.userGrants(user)...)
+	}
+
+	if len(creates) == 0 {
+		return nil
+	}
+
+	logrus.Infof("Provisioning SQL users %v", users)
+	err := rc.withSQLCerts(ctx, namespace, func(certsDir, host string) error {
+		if err := security.ExecSQL(certsDir, host, creates...); err != nil {
+			return err
+		}
+
+		var statements []string
+		for _, g := range grants {
+			current, err := security.CurrentGrant(certsDir, host, g)
+			if err != nil {
+				return err
+			}
+			statements = append(statements, security.GrantStatements(g, current)...)
+		}
+		return security.ExecSQL(certsDir, host, statements...)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to provision SQL users")
+	}
+
+	logrus.Info("Provisioned SQL users")
+	return nil
+}
+
+// userGrants returns the Grants of the user merged into one grant per database and one grant of its roles, so
+// that the privileges and roles listed by one of them are never revoked by another. Users without Grants are
+// left alone.
+func (rc *GenerateCert) userGrants(user string) []security.Grant {
+	var grants []security.Grant
+	roles := security.Grant{User: user}
+	databases := map[string]int{}
+	found := false
+
+	for _, g := range rc.Grants {
+		if g.User != user {
+			continue
+		}
+		found = true
+		roles.Roles = appendMissing(roles.Roles, g.Roles...)
+
+		if g.Database == "" {
+			continue
+		}
+		i, ok := databases[g.Database]
+		if !ok {
+			i = len(grants)
+			databases[g.Database] = i
+			grants = append(grants, security.Grant{User: user, Database: g.Database})
+		}
+		grants[i].Privileges = appendMissing(grants[i].Privileges, g.Privileges...)
+	}
+
+	if !found {
+		return nil
+	}
+	return append(grants, roles)
+}
+
+// appendMissing appends the values which aren't in the slice yet.
+func appendMissing(slice []string, values ...string) []string {
+	for _, v := range values {
+		if !contains(slice, v) {
+			slice = append(slice, v)
+		}
+	}
+	return slice
+}
+
+// GrantRoles creates the SQL user if it doesn't exist and grants it the roles. The cluster must be running.
+func (rc *GenerateCert) GrantRoles(ctx context.Context, namespace, user string, roles []string) error {
+	statements := append([]string{security.CreateUserStatement(user)},
+		security.GrantStatements(security.Grant{User: user, Roles: roles}, security.Grant{})...)
+
+	if err := rc.execSQL(ctx, namespace, statements...); err != nil {
+		return errors.Wrapf(err, "failed to grant roles to SQL user %s", user)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestUserGrants(t *testing.T) {
+	rc := NewGenerateCert(nil)
+	rc.Grants = []security.Grant{
+		{User: "app", Database: "app_db", Privileges: []string{"SELECT"}, Roles: []string{"reporting"}},
+		{User: "app", Database: "app_db", Privileges: []string{"SELECT", "INSERT"}},
+		{User: "app", Database: "audit_db", Privileges: []string{"SELECT"}, Roles: []string{"auditor", "reporting"}},
+		{User: "other", Database: "other_db", Privileges: []string{"ALL"}},
+	}
+
+	// the roles of every grant are kept, so that none of them revokes the roles of another
+	assert.Equal(t, []security.Grant{
+		{User: "app", Database: "app_db", Privileges: []string{"SELECT", "INSERT"}},
+		{User: "app", Database: "audit_db", Privileges: []string{"SELECT"}},
+		{User: "app", Roles: []string{"reporting", "auditor"}},
+	}, rc.userGrants("app"))
+
+	// the users without grants are left alone
+	assert.Empty(t, rc.userGrants("unknown"))
+}
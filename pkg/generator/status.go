@@ -0,0 +1,143 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// SecretStatus is the state of the certificate held by a secret of the self-signer.
+type SecretStatus struct {
+	// Name is the name of the secret, and Secret the name of its current version.
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+	Exists bool   `json:"exists"`
+
+	CommonName    string    `json:"commonName,omitempty"`
+	Serial        string    `json:"serial,omitempty"`
+	NotBefore     time.Time `json:"notBefore,omitempty"`
+	NotAfter      time.Time `json:"notAfter,omitempty"`
+	RotationDueAt string    `json:"rotationDueAt,omitempty"`
+	ManagedBy     string    `json:"managedByVersion,omitempty"`
+	// Paused is true if the secret or its namespace is annotated to freeze its certificate.
+	Paused bool `json:"paused,omitempty"`
+	// Error tells why the secret doesn't hold a usable certificate.
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the state of the certificates of the secrets managed with the configuration.
+func (rc *GenerateCert) Status(ctx context.Context, namespace string) ([]SecretStatus, error) {
+	names := []string{rc.CAKeySecret(), rc.getClientSecretName()}
+	for _, user := range rc.ClientUsers {
+		names = append(names, fmt.Sprintf("%s-client-secret", user))
+	}
+	if !rc.PerNodeCerts {
+		names = append(names, rc.getNodeSecretName())
+	}
+	if rc.UICert {
+		names = append(names, rc.getUISecretName())
+	}
+	if len(rc.IngressHosts) > 0 {
+		names = append(names, rc.getIngressSecretName())
+	}
+	for _, t := range rc.Tenants {
+		names = append(names, rc.getTenantSecretName(t))
+	}
+	if len(rc.SQLProxyHosts) > 0 {
+		names = append(names, rc.getSQLProxySecretName())
+	}
+
+	paused, err := rc.namespacePaused(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []SecretStatus
+	for i, name := range names {
+		current := name
+		if i > 0 {
+			var err error
+			if current, err = rc.currentSecretName(ctx, namespace, name); err != nil {
+				return nil, err
+			}
+		}
+
+		status, err := rc.secretStatus(ctx, namespace, name, current, i == 0)
+		if err != nil {
+			return nil, err
+		}
+		status.Paused = status.Paused || paused
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// secretStatus returns the state of the certificate of the secret current, the current version of name.
+func (rc *GenerateCert) secretStatus(ctx context.Context, namespace, name, current string, ca bool) (SecretStatus, error) {
+	status := SecretStatus{Name: name, Secret: current}
+
+	secret, err := resource.LoadTLSSecret(current, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if apierrors.IsNotFound(err) {
+		return status, nil
+	} else if err != nil {
+		return status, errors.Wrapf(err, "failed to get secret [%s]", current)
+	}
+	status.Exists = true
+
+	annotations := secret.Secret().Annotations
+	status.RotationDueAt = annotations[resource.RotationDueAt]
+	status.ManagedBy = annotations[resource.ManagedByVersion]
+	status.Paused = secret.Paused()
+
+	pemCert := secret.TLSCert()
+	if ca {
+		pemCert = secret.CA()
+	}
+
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		status.Error = err.Error()
+		return status, nil
+	}
+
+	status.CommonName = cert.Subject.CommonName
+	status.Serial = cert.SerialNumber.String()
+	status.NotBefore = cert.NotBefore
+	status.NotAfter = cert.NotAfter
+
+	now := rc.now()
+	switch {
+	case !ca:
+		if err := secret.Validate(now); err != nil {
+			status.Error = err.Error()
+		}
+	case !secret.ReadyCA():
+		status.Error = "secret doesn't contain the required CA cert/key"
+	case now.After(cert.NotAfter):
+		status.Error = "CA certificate expired at " + cert.NotAfter.Format(time.RFC3339)
+	}
+	return status, nil
+}
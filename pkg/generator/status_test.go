@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestStatus(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister)
+
+	ca := resource.CreateTLSSecret("crdb-ca-secret", corev1.SecretTypeOpaque, r)
+	require.NoError(t, ca.UpdateCASecret([]byte(testcerts.CAKey), []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "43800h0m0s", "648h")))
+
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	node := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, node.UpdateTLSSecret(nodeCert, nodeKey, []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "1h0m0s", "10m")))
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+
+	statuses, err := rc.Status(ctx, "ns")
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+
+	assert.Equal(t, "crdb-ca-secret", statuses[0].Secret)
+	assert.True(t, statuses[0].Exists)
+	assert.Equal(t, "Cockroach CA", statuses[0].CommonName)
+	assert.Empty(t, statuses[0].Error)
+
+	assert.Equal(t, "crdb-client-secret", statuses[1].Secret)
+	assert.False(t, statuses[1].Exists)
+
+	assert.Equal(t, "crdb-node-secret", statuses[2].Secret)
+	assert.Equal(t, security.NodeUser, statuses[2].CommonName)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), statuses[2].NotAfter, time.Minute)
+	assert.Empty(t, statuses[2].Error)
+}
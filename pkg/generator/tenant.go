@@ -0,0 +1,208 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// Tenant is a SQL tenant of the cluster, whose SQL pods connect to the KV layer with a tenant client
+// certificate.
+type Tenant struct {
+	ID uint64
+	// Hosts are the names the SQL pods of the tenant serve SQL clients at.
+	Hosts []string
+	// SecretName is the secret the certificate is stored in, <statefulset>-tenant-<id>-secret by default.
+	SecretName string
+}
+
+func (rc *GenerateCert) getTenantSecretName(t Tenant) string {
+	if t.SecretName != "" {
+		return t.SecretName
+	}
+	return fmt.Sprintf("%s-tenant-%d-secret", rc.DiscoveryServiceName, t.ID)
+}
+
+func (rc *GenerateCert) getSQLProxySecretName() string {
+	if rc.SQLProxySecretName != "" {
+		return rc.SQLProxySecretName
+	}
+	return rc.DiscoveryServiceName + "-sqlproxy-secret"
+}
+
+// generateTenantCerts generates the client certificates of the SQL tenants, with the tenant ID as common
+// name in the Tenants organizational unit as CockroachDB expects, and the certificate of the SQL proxy
+// routing SQL clients to the tenants.
+func (rc *GenerateCert) generateTenantCerts(ctx context.Context, namespace string) error {
+	for _, t := range rc.Tenants {
+		if err := rc.generateTenantCert(ctx, namespace, t); err != nil {
+			return err
+		}
+	}
+
+	if len(rc.SQLProxyHosts) == 0 {
+		return nil
+	}
+	return rc.generateSQLProxyCert(ctx, namespace)
+}
+
+// generateTenantCert generates the client certificate of the SQL tenant.
+func (rc *GenerateCert) generateTenantCert(ctx context.Context, namespace string, tenant Tenant) error {
+	issue := func(caCert *x509.Certificate, caKey crypto.Signer) ([]byte, []byte, error) {
+		return security.CreateTenantClientCert(caCert, caKey, rc.keys, rc.keySize(), rc.now(),
+			rc.ClientCertConfig.Duration, tenant.ID, tenant.Hosts)
+	}
+
+	inputs := map[string]string{
+		"tenantID": strconv.FormatUint(tenant.ID, 10),
+		"hosts":    strings.Join(tenant.Hosts, ","),
+	}
+
+	name := fmt.Sprintf("Tenant %d", tenant.ID)
+	return rc.generateSharedCert(ctx, namespace, name, rc.getTenantSecretName(tenant), rc.ClientCertConfig,
+		rc.RotateClientCert, tenant.Hosts, inputs, issue)
+}
+
+// generateSQLProxyCert generates the certificate of the SQL proxy, for the SQLProxyHosts.
+func (rc *GenerateCert) generateSQLProxyCert(ctx context.Context, namespace string) error {
+	issue := func(caCert *x509.Certificate, caKey crypto.Signer) ([]byte, []byte, error) {
+		return security.CreateServerCert(caCert, caKey, rc.keys, rc.keySize(), rc.now(), rc.NodeCertConfig.Duration,
+			rc.SQLProxyHosts)
+	}
+
+	inputs := map[string]string{"hosts": strings.Join(rc.SQLProxyHosts, ",")}
+	return rc.generateSharedCert(ctx, namespace, "SQL Proxy", rc.getSQLProxySecretName(), rc.NodeCertConfig,
+		rc.RotateNodeCert, rc.SQLProxyHosts, inputs, issue)
+}
+
+// generateSharedCert generates a certificate signed by the cluster CA for a component running outside the
+// statefulset, such as a SQL tenant or the SQL proxy, and stores it in a kubernetes.io/tls secret. The
+// secret is updated in place, as the component mounts it by name and is restarted by its own deployment.
+// The certificate is reissued once it isn't valid for the hosts.
+func (rc *GenerateCert) generateSharedCert(ctx context.Context, namespace, name, secretName string,
+	certConfig *CertConfig, rotate bool, hosts []string, inputs map[string]string,
+	issue func(*x509.Certificate, crypto.Signer) ([]byte, []byte, error)) error {
+
+	if rc.signed() {
+		return errors.Errorf("the %s certificate can't be requested from the signer", name)
+	}
+
+	r := resource.NewKubeResource(ctx, rc.client, namespace, rc.persister())
+	loaded, err := resource.LoadTLSSecret(secretName, r)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrapf(err, "failed to get %s TLS secret", name)
+	}
+
+	operation := audit.Issue
+	if loaded.Ready() && loaded.ValidateAnnotations() {
+		isRequired, reason := loaded.IsRotationRequiredAt(certConfig.Duration, rc.NodeAndClientCronSchedule, rc.now())
+		if !isRequired {
+			isRequired, reason = rc.revoked(loaded)
+		}
+		if !isRequired {
+			isRequired, reason = rc.recovered(loaded)
+		}
+		if !isRequired {
+			isRequired, reason = missingSANs(loaded, hosts)
+		}
+		if !rotate || !isRequired || rc.deferRotation(namespace, loaded, rc.NodeAndClientCronSchedule,
+			certConfig.ExpiryWindow) {
+			logrus.Infof("%s secret [%s] is found in ready state, skipping %s cert generation", name, secretName, name)
+			rc.logFingerprints("in use", secretName, loaded.TLSCert())
+			return nil
+		}
+
+		logrus.Infof("%s Certificate: %s", name, reason)
+		operation = audit.Rotate
+		if err := rc.throttleRotation(ctx, namespace); err != nil {
+			return err
+		}
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(rc.CertsDir, resource.CaCert))
+	if err != nil {
+		return errors.Wrap(err, "unable to read ca.crt")
+	}
+
+	pemKey, err := ioutil.ReadFile(rc.CAKey)
+	if err != nil {
+		return errors.Wrap(err, "unable to read ca.key")
+	}
+
+	caCert, caKey, err := security.ParseCAPair(ca, pemKey)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Generating %s certificate", name)
+	pemCert, pemKey, err := issue(caCert, caKey)
+	if err != nil {
+		return errors.Wrapf(err, "failed to generate %s certificate and key", name)
+	}
+
+	validFrom, validUpto, err := rc.getCertLife(pemCert)
+	if err != nil {
+		return err
+	}
+
+	annotations := resource.GetSecretAnnotations(validFrom, validUpto, certConfig.Duration.String(),
+		certConfig.ExpiryWindow.String())
+
+	inputs["duration"] = certConfig.Duration.String()
+	inputs["expiryWindow"] = certConfig.ExpiryWindow.String()
+
+	if err := rc.checkPolicy(ctx, namespace, secretName, pemCert, inputs); err != nil {
+		return err
+	}
+
+	if err := rc.attest(ctx, namespace, secretName, pemCert, inputs, annotations); err != nil {
+		return err
+	}
+
+	secret := resource.CreateTLSSecret(secretName, corev1.SecretTypeTLS, r)
+	secret.ExpectResourceVersion(loaded.Secret().ResourceVersion)
+	secret.SetPolicyVersion(rc.PolicyVersion)
+	if err := secret.UpdateTLSSecret(pemCert, pemKey, ca, annotations); err != nil {
+		if modifiedConcurrently(err, secretName) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to update %s TLS secret certs", name)
+	}
+
+	logrus.Infof("Generated and saved %s key and certificate in secret [%s]", name, secretName)
+	if operation == audit.Rotate {
+		rc.rotated = append(rc.rotated, secretName)
+	}
+
+	return rc.recordIssued(ctx, operation, namespace, secretName, pemCert, inputs)
+}
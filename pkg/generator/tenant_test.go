@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestGenerateTenantCerts(t *testing.T) {
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.ClientCertConfig.Duration = time.Hour
+	rc.NodeCertConfig.Duration = time.Hour
+	rc.Tenants = []Tenant{{ID: 10, Hosts: []string{"tenant-10-sql"}}}
+	rc.SQLProxyHosts = []string{"sql.example.com"}
+
+	rc.CertsDir = t.TempDir()
+	rc.CAKey = filepath.Join(t.TempDir(), "ca.key")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rc.CertsDir, resource.CaCert), []byte(testcerts.CACert), security.CertFileMode))
+	require.NoError(t, ioutil.WriteFile(rc.CAKey, []byte(testcerts.CAKey), security.KeyFileMode))
+
+	require.NoError(t, rc.generateTenantCerts(context.TODO(), "ns"))
+
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-tenant-10-secret"}, &secret))
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Equal(t, testcerts.CACert, string(secret.Data[resource.CaCert]))
+
+	cert, err := security.GetCertObj(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	id, err := security.TenantID(cert)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), id)
+
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns", Name: "crdb-sqlproxy-secret"}, &secret))
+	cert, err = security.GetCertObj(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sql.example.com"}, cert.DNSNames)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func (rc *GenerateCert) getUISecretName() string {
+	if rc.UISecretName != "" {
+		return rc.UISecretName
+	}
+	return rc.DiscoveryServiceName + "-ui-secret"
+}
+
+// UIHostNames returns the DNS names and IP addresses of the DB Console certificate, which are the public
+// hostnames of the console followed by the names of the public service.
+func (rc *GenerateCert) UIHostNames(namespace string) []string {
+	return append(append([]string{}, rc.UIHosts...), rc.serviceHosts(namespace)...)
+}
+
+// generateUICert generates the DB Console key and certificate and stores them in their own secret, so that
+// the console can present a certificate different from the node certificate.
+func (rc *GenerateCert) generateUICert(ctx context.Context, namespace string) error {
+	if rc.signed() {
+		return errors.New("the DB Console certificate can't be requested from the signer")
+	}
+
+	uiSecretName := rc.getUISecretName()
+	currentName, err := rc.currentSecretName(ctx, namespace, uiSecretName)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := resource.LoadTLSSecret(currentName, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, "failed to get DB Console TLS secret")
+	}
+
+	operation := audit.Issue
+	if loaded.Ready() && loaded.ValidateAnnotations() {
+		if !rc.RotateNodeCert {
+			logrus.Infof("DB Console secret [%s] is found in ready state, skipping DB Console cert generation", uiSecretName)
+			rc.logFingerprints("in use", currentName, loaded.TLSCert())
+			return nil
+		}
+
+		isRequired, reason := loaded.IsRotationRequiredAt(rc.UICertConfig.Duration, rc.NodeAndClientCronSchedule, rc.now())
+		if !isRequired {
+			isRequired, reason = rc.revoked(loaded)
+		}
+		if !isRequired {
+			isRequired, reason = rc.recovered(loaded)
+		}
+		if !isRequired {
+			isRequired, reason = missingSANs(loaded, rc.UIHostNames(namespace))
+		}
+		if !isRequired || rc.deferRotation(namespace, loaded, rc.NodeAndClientCronSchedule,
+			rc.UICertConfig.ExpiryWindow) {
+			logrus.Infof("DB Console secret [%s] is found in ready state, skipping DB Console cert generation", uiSecretName)
+			rc.logFingerprints("in use", currentName, loaded.TLSCert())
+			return nil
+		}
+
+		logrus.Infof("DB Console Certificate: %s", reason)
+		operation = audit.Rotate
+		if err := rc.throttleRotation(ctx, namespace); err != nil {
+			return err
+		}
+	}
+
+	logrus.Info("Generating DB Console certificate")
+	hosts := rc.UIHostNames(namespace)
+	if err := security.CreateUIPair(rc.CertsDir, rc.CAKey, rc.keys, rc.keySize(), rc.now(), rc.UICertConfig.Duration,
+		hosts, rc.UIProfile); err != nil {
+		return errors.Wrap(err, "failed to generate DB Console certificate and key")
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(rc.CertsDir, resource.CaCert))
+	if err != nil {
+		return errors.Wrap(err, "unable to read ca.crt")
+	}
+
+	pemCert, err := ioutil.ReadFile(filepath.Join(rc.CertsDir, security.UICert))
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", security.UICert)
+	}
+
+	pemKey, err := ioutil.ReadFile(filepath.Join(rc.CertsDir, security.UIKey))
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %s", security.UIKey)
+	}
+
+	validFrom, validUpto, err := rc.getCertLife(pemCert)
+	if err != nil {
+		return err
+	}
+
+	annotations := resource.GetSecretAnnotations(validFrom, validUpto, rc.UICertConfig.Duration.String(),
+		rc.UICertConfig.ExpiryWindow.String())
+
+	inputs := map[string]string{
+		"duration":     rc.UICertConfig.Duration.String(),
+		"expiryWindow": rc.UICertConfig.ExpiryWindow.String(),
+		"hosts":        strings.Join(hosts, ","),
+	}
+
+	if err := rc.checkPolicy(ctx, namespace, uiSecretName, pemCert, inputs); err != nil {
+		return err
+	}
+
+	if err := rc.attest(ctx, namespace, uiSecretName, pemCert, inputs, annotations); err != nil {
+		return err
+	}
+
+	secret := rc.newTLSSecret(ctx, namespace, loaded, rc.persister())
+	if err := secret.UpdateTLSSecret(pemCert, pemKey, ca, annotations); err != nil {
+		if modifiedConcurrently(err, uiSecretName) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to update DB Console TLS secret certs")
+	}
+
+	if err := rc.pointToSecret(ctx, namespace, uiSecretName, secret.Secret().Name); err != nil {
+		return err
+	}
+
+	logrus.Infof("Generated and saved DB Console key and certificate in secret [%s]", secret.Secret().Name)
+	if err := rc.recordIssued(ctx, operation, namespace, secret.Secret().Name, pemCert, inputs); err != nil {
+		return err
+	}
+
+	if operation != audit.Rotate {
+		return nil
+	}
+
+	rc.rotated = append(rc.rotated, secret.Secret().Name)
+	return rc.rolloutSecret(ctx, namespace, uiSecretName, loaded.Secret().Name, secret)
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// Checks of Verify reported in the findings.
+const (
+	CheckMissing    = "missing"
+	CheckInvalid    = "invalid"
+	CheckExpired    = "expired"
+	CheckNearExpiry = "near-expiry"
+	CheckWeakKey    = "weak-key"
+	CheckSANDrift   = "san-drift"
+)
+
+// checks are all the checks of Verify.
+var checks = []string{CheckMissing, CheckInvalid, CheckExpired, CheckNearExpiry, CheckWeakKey, CheckSANDrift}
+
+// ErrNonCompliant is returned when the certificates don't pass a strict verification, even though they are
+// all usable.
+var ErrNonCompliant = errors.New("certificates are not compliant")
+
+// Default minimum key sizes of Verify.
+const (
+	DefaultMinRSABits   = 2048
+	DefaultMinECDSABits = 256
+)
+
+// weakSignatures are the signature algorithms relying on broken hashes.
+var weakSignatures = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// VerifyOptions are the thresholds of Verify.
+type VerifyOptions struct {
+	// NearExpiry reports the certificates expiring within this duration. If zero, the certificates are
+	// reported once due for rotation, at the start of their expiry window.
+	NearExpiry time.Duration
+	// MinRSABits and MinECDSABits are the smallest RSA key and ECDSA curve sizes which are not reported as
+	// weak, DefaultMinRSABits and DefaultMinECDSABits if zero.
+	MinRSABits   int
+	MinECDSABits int
+}
+
+// Finding is a failed check of a secret.
+type Finding struct {
+	Secret  string `json:"secret"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// Critical returns true if the secret doesn't hold a usable certificate.
+func (f Finding) Critical() bool {
+	return f.Check == CheckMissing || f.Check == CheckInvalid || f.Check == CheckExpired
+}
+
+// VerifyReport is the result of Verify, for compliance dashboards.
+type VerifyReport struct {
+	CheckedAt time.Time `json:"checkedAt"`
+	// Secrets are the secrets checked, and Findings their failed checks.
+	Secrets  []string  `json:"secrets"`
+	Findings []Finding `json:"findings"`
+}
+
+// Passed returns true if no finding is critical, or if there is no finding at all when strict.
+func (r *VerifyReport) Passed(strict bool) bool {
+	for _, f := range r.Findings {
+		if strict || f.Critical() {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks the certificates of the secrets managed with the configuration, including the secrets of
+// each pod with PerNodeCerts, without writing anything. On top of the checks of Status, it reports the
+// certificates near expiry, with weak keys or signatures, and whose names drifted from the config.
+func (rc *GenerateCert) Verify(ctx context.Context, namespace string, opts VerifyOptions) (*VerifyReport, error) {
+	if opts.MinRSABits == 0 {
+		opts.MinRSABits = DefaultMinRSABits
+	}
+	if opts.MinECDSABits == 0 {
+		opts.MinECDSABits = DefaultMinECDSABits
+	}
+
+	statuses, err := rc.Status(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := map[string][]string{}
+	if rc.SPIRE == nil && !rc.PerNodeCerts {
+		hosts[rc.getNodeSecretName()] = rc.NodeHosts(namespace)
+	}
+	if rc.UICert {
+		hosts[rc.getUISecretName()] = rc.UIHostNames(namespace)
+	}
+	if len(rc.IngressHosts) > 0 {
+		hosts[rc.getIngressSecretName()] = rc.IngressHosts
+	}
+
+	if rc.PerNodeCerts {
+		replicas, err := rc.statefulSetReplicas(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < replicas; i++ {
+			pod := fmt.Sprintf("%s-%d", rc.DiscoveryServiceName, i)
+			name := rc.PodSecretName(pod)
+			status, err := rc.secretStatus(ctx, namespace, name, name, false)
+			if err != nil {
+				return nil, err
+			}
+			statuses = append(statuses, status)
+			if rc.SPIRE == nil {
+				hosts[name] = rc.PodHosts(namespace, pod)
+			}
+		}
+	}
+
+	report := &VerifyReport{CheckedAt: rc.now()}
+	for _, status := range statuses {
+		report.Secrets = append(report.Secrets, status.Secret)
+		findings, err := rc.verifySecret(ctx, namespace, status, hosts[status.Name], opts, report.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+
+	return report, nil
+}
+
+// verifySecret returns the failed checks of the secret of the status. The SANs are checked against hosts,
+// unless empty.
+func (rc *GenerateCert) verifySecret(ctx context.Context, namespace string, status SecretStatus, hosts []string,
+	opts VerifyOptions, now time.Time) ([]Finding, error) {
+
+	finding := func(check, format string, args ...interface{}) Finding {
+		return Finding{Secret: status.Secret, Check: check, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch {
+	case !status.Exists:
+		return []Finding{finding(CheckMissing, "secret doesn't exist")}, nil
+	case !status.NotAfter.IsZero() && !now.Before(status.NotAfter):
+		return []Finding{finding(CheckExpired, "certificate expired at %s", status.NotAfter.Format(time.RFC3339))}, nil
+	case status.Error != "":
+		return []Finding{finding(CheckInvalid, "%s", status.Error)}, nil
+	}
+
+	secret, err := resource.LoadTLSSecret(status.Secret, resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get secret [%s]", status.Secret)
+	}
+
+	pemCert := secret.TLSCert()
+	if status.Name == rc.CAKeySecret() {
+		pemCert = secret.CA()
+	}
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		return []Finding{finding(CheckInvalid, "%s", err)}, nil
+	}
+
+	var findings []Finding
+	if opts.NearExpiry > 0 {
+		if !now.Add(opts.NearExpiry).Before(cert.NotAfter) {
+			findings = append(findings, finding(CheckNearExpiry, "certificate expires at %s, within %s",
+				cert.NotAfter.Format(time.RFC3339), opts.NearExpiry))
+		}
+	} else if dueAt, err := time.Parse(time.RFC3339, status.RotationDueAt); err == nil && !now.Before(dueAt) {
+		findings = append(findings, finding(CheckNearExpiry, "certificate expires at %s, due for rotation since %s",
+			cert.NotAfter.Format(time.RFC3339), status.RotationDueAt))
+	}
+
+	if weak := weakKey(cert, opts); weak != "" {
+		findings = append(findings, finding(CheckWeakKey, "%s", weak))
+	}
+
+	if len(hosts) > 0 {
+		missing, extra := sanDrift(cert, hosts)
+		var drift []string
+		if len(missing) > 0 {
+			drift = append(drift, "missing names "+strings.Join(missing, ", "))
+		}
+		if len(extra) > 0 {
+			drift = append(drift, "names no longer configured "+strings.Join(extra, ", "))
+		}
+		if len(drift) > 0 {
+			findings = append(findings, finding(CheckSANDrift, "certificate has %s", strings.Join(drift, "; ")))
+		}
+	}
+
+	return findings, nil
+}
+
+// weakKey returns why the key or the signature of the certificate is weak, empty if it isn't.
+func weakKey(cert *x509.Certificate, opts VerifyOptions) string {
+	if weakSignatures[cert.SignatureAlgorithm] {
+		return fmt.Sprintf("certificate is signed with %s", cert.SignatureAlgorithm)
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := key.N.BitLen(); size < opts.MinRSABits {
+			return fmt.Sprintf("RSA key of %d bits is smaller than %d bits", size, opts.MinRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if size := key.Curve.Params().BitSize; size < opts.MinECDSABits {
+			return fmt.Sprintf("ECDSA key of %d bits is smaller than %d bits", size, opts.MinECDSABits)
+		}
+	}
+	return ""
+}
+
+// sanDrift returns the hosts missing from the certificate, and the names of the certificate which are not
+// among the hosts anymore.
+func sanDrift(cert *x509.Certificate, hosts []string) (missing, extra []string) {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	expected := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		expected = append(expected, host)
+		if !contains(names, host) {
+			missing = append(missing, host)
+		}
+	}
+
+	for _, name := range names {
+		if !contains(expected, name) {
+			extra = append(extra, name)
+		}
+	}
+	return missing, extra
+}
+
+// RecordVerification verifies the certificates and records the number of findings of each check in the
+// verificationFindings metric. With RecordPKIStatus, the PKIStatus is refreshed with the Compliant condition
+// as well, a failure to write it being only logged. The findings are logged, they don't fail the
+// verification.
+func (rc *GenerateCert) RecordVerification(ctx context.Context, namespace string, opts VerifyOptions) (*VerifyReport, error) {
+	report, err := rc.Verify(ctx, namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, f := range report.Findings {
+		counts[f.Check]++
+		logrus.Warnf("Verification of secret [%s] failed the %s check: %s", f.Secret, f.Check, f.Message)
+	}
+	for _, check := range checks {
+		verificationFindings.WithLabelValues(namespace, rc.DiscoveryServiceName, check).Set(float64(counts[check]))
+	}
+	verificationTimestamp.WithLabelValues(namespace, rc.DiscoveryServiceName).Set(float64(report.CheckedAt.Unix()))
+
+	if !rc.RecordPKIStatus {
+		return report, nil
+	}
+
+	compliant := metav1.Condition{Type: ConditionCompliant, Status: metav1.ConditionTrue, Reason: "NoFindings",
+		Message: fmt.Sprintf("%d secrets verified", len(report.Secrets))}
+	if len(report.Findings) > 0 {
+		var found []string
+		for _, check := range checks {
+			if counts[check] > 0 {
+				found = append(found, fmt.Sprintf("%d %s", counts[check], check))
+			}
+		}
+		compliant.Status, compliant.Reason = metav1.ConditionFalse, "Findings"
+		compliant.Message = "Findings: " + strings.Join(found, ", ")
+	}
+	if err := rc.writePKIStatus(ctx, namespace, compliant); err != nil {
+		logrus.Warnf("Failed to record the verification in [%s]: %s", rc.getPKIStatusName(), err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, cl, "ns", kube.DefaultPersister)
+
+	ca := resource.CreateTLSSecret("crdb-ca-secret", corev1.SecretTypeOpaque, r)
+	require.NoError(t, ca.UpdateCASecret([]byte(testcerts.CAKey), []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "43800h0m0s", "648h")))
+
+	// the node certificate is only valid for localhost, and expires in an hour
+	nodeCert, nodeKey := signPair(t, security.NodeUser)
+	node := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, node.UpdateTLSSecret(nodeCert, nodeKey, []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "1h0m0s", "10m")))
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.PublicServiceName = "crdb-public"
+	rc.ClusterDomain = "cluster.local"
+	rc.Persister = kube.DefaultPersister
+
+	report, err := rc.Verify(ctx, "ns", VerifyOptions{NearExpiry: 2 * time.Hour, MinRSABits: 4096})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-ca-secret", "crdb-client-secret", "crdb-node-secret"}, report.Secrets)
+
+	checks := map[string][]string{}
+	for _, f := range report.Findings {
+		checks[f.Secret] = append(checks[f.Secret], f.Check)
+	}
+	assert.Equal(t, map[string][]string{
+		"crdb-client-secret": {CheckMissing},
+		"crdb-node-secret":   {CheckNearExpiry, CheckWeakKey, CheckSANDrift},
+	}, checks)
+	assert.Contains(t, report.Findings[3].Message, "missing names 127.0.0.1, crdb-public")
+	assert.False(t, report.Passed(false))
+
+	// only the missing and invalid certificates fail the verification unless strict
+	clientCert, clientKey := signPair(t, security.RootUser)
+	client := resource.CreateTLSSecret("crdb-client-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, client.UpdateTLSSecret(clientCert, clientKey, []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "1h0m0s", "10m")))
+
+	report, err = rc.Verify(ctx, "ns", VerifyOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, CheckSANDrift, report.Findings[0].Check)
+	assert.True(t, report.Passed(false))
+	assert.False(t, report.Passed(true))
+
+	// expired certificates are critical
+	rc.Clock = clock.NewFake(time.Now().Add(2 * time.Hour))
+	report, err = rc.Verify(ctx, "ns", VerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, CheckExpired, report.Findings[0].Check)
+	assert.False(t, report.Passed(false))
+}
+
+func TestRecordVerification(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, cl, "verified", kube.DefaultPersister)
+
+	ca := resource.CreateTLSSecret("crdb-ca-secret", corev1.SecretTypeOpaque, r)
+	require.NoError(t, ca.UpdateCASecret([]byte(testcerts.CAKey), []byte(testcerts.CACert),
+		resource.GetSecretAnnotations("2021-01-01T00:00:00Z", "2121-01-01T00:00:00Z", "43800h0m0s", "648h")))
+
+	rc := NewGenerateCert(cl)
+	rc.DiscoveryServiceName = "crdb"
+	rc.Persister = kube.DefaultPersister
+	rc.RecordPKIStatus = true
+
+	report, err := rc.RecordVerification(ctx, "verified", VerifyOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Findings, 2)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(verificationFindings.WithLabelValues("verified", "crdb", CheckMissing)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(verificationFindings.WithLabelValues("verified", "crdb", CheckWeakKey)))
+	assert.Equal(t, float64(report.CheckedAt.Unix()), testutil.ToFloat64(verificationTimestamp.WithLabelValues("verified", "crdb")))
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(PKIStatusKind)
+	require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "verified", Name: "crdb-pki"}, obj))
+
+	var pki PKIStatus
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object["status"].(map[string]interface{}), &pki))
+	compliant := meta.FindStatusCondition(pki.Conditions, ConditionCompliant)
+	require.NotNil(t, compliant)
+	assert.Equal(t, metav1.ConditionFalse, compliant.Status)
+	assert.Equal(t, "Findings: 2 missing", compliant.Message)
+
+	// the condition is kept by the runs recording the PKI status
+	rc.recordPKIStatus(ctx, "verified")
+	require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "verified", Name: "crdb-pki"}, obj))
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object["status"].(map[string]interface{}), &pki))
+	assert.True(t, meta.IsStatusConditionFalse(pki.Conditions, ConditionCompliant))
+}
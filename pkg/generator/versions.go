@@ -0,0 +1,220 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+const (
+	// InPlaceRotation overwrites the node and client secrets with the rotated certificates.
+	InPlaceRotation = "in-place"
+	// VersionedRotation writes the rotated certificates to the next version of the secret, and keeps the
+	// previous version for the rollback grace period.
+	VersionedRotation = "versioned"
+)
+
+// versioned returns true if rotated certificates are written to a new version of the secret.
+func (rc *GenerateCert) versioned() bool {
+	return rc.ImmutableSecrets || rc.RotationStrategy == VersionedRotation
+}
+
+// getSecretVersionsName returns the name of the ConfigMap pointing to the current version of immutable secrets.
+func (rc *GenerateCert) getSecretVersionsName() string {
+	if rc.SecretVersionsName != "" {
+		return rc.SecretVersionsName
+	}
+	return rc.DiscoveryServiceName + "-secret-versions"
+}
+
+// currentSecretName returns the name of the secret holding the current certificate of the secret name,
+// which differs from the name once a versioned secret was rotated.
+func (rc *GenerateCert) currentSecretName(ctx context.Context, namespace, name string) (string, error) {
+	if !rc.versioned() {
+		return name, nil
+	}
+
+	versions, err := resource.LoadSecretVersions(rc.getSecretVersionsName(),
+		resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get secret versions")
+	}
+
+	return versions.Current(name), nil
+}
+
+// newTLSSecret returns the secret replacing the loaded one. It is the loaded secret itself, only written if
+// no other run updated it since it was loaded. Versioned secrets are instead replaced by the next version
+// of the secret, unless the loaded secret doesn't exist yet.
+func (rc *GenerateCert) newTLSSecret(ctx context.Context, namespace string, loaded *resource.TLSSecret,
+	persister kube.PersistFn) *resource.TLSSecret {
+
+	name, version := loaded.Secret().Name, loaded.Secret().ResourceVersion
+	if rc.versioned() && version != "" {
+		name, version = resource.NextSecretVersion(name), ""
+	}
+
+	secret := resource.CreateTLSSecret(name, corev1.SecretTypeTLS, resource.NewKubeResource(ctx, rc.client, namespace, persister))
+	secret.ExpectResourceVersion(version)
+	secret.SetPolicyVersion(rc.PolicyVersion)
+	if rc.ImmutableSecrets {
+		secret.SetImmutable()
+	}
+
+	return secret
+}
+
+// pointToSecret records current as the secret holding the current certificate of the secret name. The
+// secret it pointed to before is kept for the rollback grace period.
+func (rc *GenerateCert) pointToSecret(ctx context.Context, namespace, name, current string) error {
+	if !rc.versioned() {
+		return nil
+	}
+
+	versions, err := resource.LoadSecretVersions(rc.getSecretVersionsName(),
+		resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return errors.Wrap(err, "failed to get secret versions")
+	}
+
+	if err := versions.Supersede(name, current, rc.now()); err != nil {
+		return errors.Wrap(err, "failed to update secret versions")
+	}
+
+	logrus.Infof("Secret [%s] now points to [%s]", name, current)
+	return nil
+}
+
+// updateSecretCA replaces the CA certificate of the TLS secret, keeping its certificate and key. Versioned
+// secrets are copied to their next version.
+func (rc *GenerateCert) updateSecretCA(ctx context.Context, namespace, name string, ca []byte,
+	persister kube.PersistFn) (*resource.TLSSecret, error) {
+
+	currentName, err := rc.currentSecretName(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := resource.LoadTLSSecret(currentName, resource.NewKubeResource(ctx, rc.client, namespace, persister))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get secret [%s]", currentName)
+	}
+
+	secret := loaded
+	if rc.versioned() {
+		secret = rc.newTLSSecret(ctx, namespace, loaded, persister)
+	}
+
+	if err := secret.UpdateTLSSecret(loaded.TLSCert(), loaded.TLSPrivateKey(), ca, loaded.Secret().Annotations); err != nil {
+		return nil, err
+	}
+	rc.logFingerprints("CA updated", secret.Secret().Name, ca)
+
+	return secret, rc.pointToSecret(ctx, namespace, name, secret.Secret().Name)
+}
+
+// rolloutNodeSecret makes the CockroachDB pods pick up the node secret current, which replaced previous.
+func (rc *GenerateCert) rolloutNodeSecret(ctx context.Context, namespace, previous string, current *resource.TLSSecret) error {
+	return rc.rolloutSecret(ctx, namespace, rc.getNodeSecretName(), previous, current)
+}
+
+// rolloutSecret makes the CockroachDB pods pick up the secret current, which replaced previous as the current
+// version of the secret name. A versioned secret is rolled out by pointing the statefulset volumes to it, first
+// to a canary pod if CanaryRotation is set, any other secret by restarting the statefulset.
+func (rc *GenerateCert) rolloutSecret(ctx context.Context, namespace, name, previous string, current *resource.TLSSecret) error {
+	if currentName := current.Secret().Name; currentName != previous {
+		var canary *canaryRollout
+		if rc.CanaryRotation && name == rc.getNodeSecretName() {
+			var err error
+			if canary, err = rc.startCanary(ctx, namespace); err != nil {
+				return err
+			}
+		}
+
+		replaced, err := kube.ReplaceSecretVolume(ctx, rc.client, rc.DiscoveryServiceName, namespace, previous, currentName)
+		if err == nil && replaced && canary != nil {
+			return rc.finishCanary(ctx, namespace, name, previous, current, canary)
+		}
+		if canary != nil {
+			if err := rc.restorePartition(ctx, namespace, canary); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to update the node secret volume of the statefulset")
+		}
+		if replaced {
+			return nil
+		}
+
+		logrus.Warnf("No volume of statefulset [%s] mounts secret [%s], restarting the statefulset", rc.DiscoveryServiceName, previous)
+	}
+
+	return rc.restartStatefulSet(ctx, namespace, name, current.Checksum())
+}
+
+// deleteRetiredSecrets deletes the previous versions of the secrets which were replaced longer than the
+// rollback grace period ago.
+func (rc *GenerateCert) deleteRetiredSecrets(ctx context.Context, namespace string) error {
+	if !rc.versioned() {
+		return nil
+	}
+
+	versions, err := resource.LoadSecretVersions(rc.getSecretVersionsName(),
+		resource.NewKubeResource(ctx, rc.client, namespace, rc.persister()))
+	if err != nil {
+		return errors.Wrap(err, "failed to get secret versions")
+	}
+
+	var deleted []string
+	for name, at := range versions.Retired() {
+		if rc.now().Sub(at) < rc.RollbackGracePeriod {
+			continue
+		}
+
+		if err := rc.deleteSecret(ctx, namespace, name); err != nil {
+			return errors.Wrapf(err, "failed to delete retired secret [%s]", name)
+		}
+
+		logrus.Infof("Deleted secret [%s], retired at %s", name, at.Format(time.RFC3339))
+		deleted = append(deleted, name)
+
+		if err := audit.Record(ctx, rc.AuditLog, audit.Event{
+			Operation: audit.Delete,
+			Namespace: namespace,
+			Secret:    name,
+			Requester: rc.Requester,
+		}); err != nil {
+			return errors.Wrap(err, "failed to record audit event")
+		}
+	}
+
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	return errors.Wrap(versions.Forget(deleted...), "failed to update secret versions")
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health queries the health of the CockroachDB nodes over their HTTP port, so that the pods are only
+// restarted while the cluster can tolerate losing a node.
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// degradingMetrics are the metrics of the _status/vars endpoint whose value must be zero on every node.
+var degradingMetrics = []string{"ranges_underreplicated", "ranges_unavailable"}
+
+// buildMetric is the metric of the _status/vars endpoint labeled with the version of the node.
+const buildMetric = "build_timestamp"
+
+var versionTag = regexp.MustCompile(`[{,]tag="([^"]+)"`)
+
+// Checker checks the readiness and the replication of the CockroachDB nodes.
+type Checker struct {
+	Client *http.Client
+}
+
+// NewChecker returns a checker verifying the certificates of the nodes with the CA certificates.
+func NewChecker(caCert []byte) (*Checker, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("no CA certificate found to verify the nodes")
+	}
+
+	return &Checker{Client: &http.Client{
+		Timeout:   defaultTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}}, nil
+}
+
+// Check returns an error if one of the nodes, given as the base URLs of their HTTP port, isn't ready to
+// serve or reports under-replicated or unavailable ranges.
+func (c *Checker) Check(ctx context.Context, nodes []string) error {
+	for _, node := range nodes {
+		if err := c.ready(ctx, node); err != nil {
+			return err
+		}
+
+		values, err := c.metrics(ctx, node)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range degradingMetrics {
+			if values[name] > 0 {
+				return errors.Errorf("node %s reports %g %s", node, values[name], name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ready returns an error unless the node answers its readiness endpoint with a success.
+func (c *Checker) ready(ctx context.Context, node string) error {
+	resp, err := c.get(ctx, node+"/health?ready=1")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("node %s is not ready: %s", node, resp.Status)
+	}
+	return nil
+}
+
+// metrics returns the sums of the degradingMetrics over the stores of the node.
+func (c *Checker) metrics(ctx context.Context, node string) (map[string]float64, error) {
+	resp, err := c.get(ctx, node+"/_status/vars")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to get metrics of node %s: %s", node, resp.Status)
+	}
+
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, name := range degradingMetrics {
+			// the metrics are labeled with the store, e.g. ranges_underreplicated{store="1"} 0
+			if !strings.HasPrefix(line, name+"{") && !strings.HasPrefix(line, name+" ") {
+				continue
+			}
+
+			fields := strings.Fields(line)
+			value, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid metric of node %s: %s", node, line)
+			}
+			values[name] += value
+		}
+	}
+
+	return values, errors.Wrapf(scanner.Err(), "failed to read metrics of node %s", node)
+}
+
+// Version returns the version of CockroachDB run by the node, e.g. v21.1.0, read from the tag label of the
+// build_timestamp metric of its _status/vars endpoint.
+func (c *Checker) Version(ctx context.Context, node string) (string, error) {
+	resp, err := c.get(ctx, node+"/_status/vars")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to get metrics of node %s: %s", node, resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// e.g. build_timestamp{tag="v21.1.0",go_version="go1.15.11"} 1.6185e+09
+		line := scanner.Text()
+		if !strings.HasPrefix(line, buildMetric+"{") {
+			continue
+		}
+
+		match := versionTag.FindStringSubmatch(line)
+		if match == nil {
+			return "", errors.Errorf("no version found in metric of node %s: %s", node, line)
+		}
+		return match[1], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.Wrapf(err, "failed to read metrics of node %s", node)
+	}
+
+	return "", errors.Errorf("node %s doesn't report its version", node)
+}
+
+func (c *Checker) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach node: %w", err)
+	}
+	return resp, nil
+}
+
+// VerifyTLS dials the TLS address, verifies the chain presented by the server for serverName with the CA
+// certificates, and checks that the server presents the expected certificate, unless it is nil. It returns
+// the certificate presented by the server.
+func VerifyTLS(ctx context.Context, addr, serverName string, caCert, expected []byte) (*x509.Certificate, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("no CA certificate found to verify the server")
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: defaultTimeout},
+		Config:    &tls.Config{RootCAs: pool, ServerName: serverName},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "TLS handshake with %s failed", addr)
+	}
+	defer conn.Close()
+
+	leaf := conn.(*tls.Conn).ConnectionState().PeerCertificates[0]
+	if expected == nil {
+		return leaf, nil
+	}
+
+	block, _ := pem.Decode(expected)
+	if block == nil {
+		return leaf, errors.New("no expected certificate found")
+	}
+	if !bytes.Equal(leaf.Raw, block.Bytes) {
+		return leaf, errors.Errorf("%s presents the certificate with serial %s instead of the expected one", addr,
+			leaf.SerialNumber.Text(16))
+	}
+
+	return leaf, nil
+}
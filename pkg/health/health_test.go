@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health_test
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/health"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+func TestCheck(t *testing.T) {
+	ready, underReplicated := true, 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			assert.Equal(t, "1", r.URL.Query().Get("ready"))
+			if !ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/_status/vars":
+			fmt.Fprintf(w, "# HELP ranges_underreplicated Number of ranges with fewer live replicas than the replication target\n")
+			fmt.Fprintf(w, "ranges_underreplicated{store=\"1\"} %d\n", underReplicated)
+			fmt.Fprintf(w, "ranges_unavailable{store=\"1\"} 0\n")
+			fmt.Fprintf(w, "ranges_underreplicated_total{store=\"1\"} 5\n")
+		}
+	}))
+	defer srv.Close()
+
+	checker := &health.Checker{Client: srv.Client()}
+	ctx := context.TODO()
+	require.NoError(t, checker.Check(ctx, []string{srv.URL}))
+
+	underReplicated = 3
+	err := checker.Check(ctx, []string{srv.URL})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "3 ranges_underreplicated")
+
+	underReplicated, ready = 0, false
+	err = checker.Check(ctx, []string{srv.URL})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not ready")
+}
+
+func TestVersion(t *testing.T) {
+	build := "build_timestamp{tag=\"v21.1.0\",go_version=\"go1.15.11\"} 1.6185e+09\n"
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_status/vars", r.URL.Path)
+		fmt.Fprintf(w, "# TYPE build_timestamp gauge\n")
+		fmt.Fprint(w, build)
+		fmt.Fprintf(w, "ranges_unavailable{store=\"1\"} 0\n")
+	}))
+	defer srv.Close()
+
+	checker := &health.Checker{Client: srv.Client()}
+	v, err := checker.Version(context.TODO(), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "v21.1.0", v)
+
+	build = ""
+	_, err = checker.Version(context.TODO(), srv.URL)
+	assert.Error(t, err)
+}
+
+func TestNewChecker(t *testing.T) {
+	_, err := health.NewChecker([]byte(testcerts.CACert))
+	assert.NoError(t, err)
+
+	_, err = health.NewChecker(nil)
+	assert.Error(t, err)
+}
+
+func TestVerifyTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	addr := srv.Listener.Addr().String()
+	ctx := context.TODO()
+
+	leaf, err := health.VerifyTLS(ctx, addr, "example.com", ca, ca)
+	require.NoError(t, err)
+	assert.Equal(t, srv.Certificate().SerialNumber, leaf.SerialNumber)
+
+	_, err = health.VerifyTLS(ctx, addr, "example.com", ca, []byte(testcerts.NodeCert))
+	assert.Error(t, err)
+
+	// the chain isn't trusted by another CA
+	_, err = health.VerifyTLS(ctx, addr, "example.com", []byte(testcerts.CACert), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jws signs and verifies the compact JSON Web Signatures of the receipts of the signer API and of the
+// ID tokens of the OIDC providers, with RS256, ES256 or EdDSA.
+package jws
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Token is a compact JWS split into its parts.
+type Token struct {
+	header    string
+	payload   string
+	signature []byte
+}
+
+// Parse splits the compact JWS into its parts.
+func Parse(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	return &Token{header: parts[0], payload: parts[1], signature: sig}, nil
+}
+
+// Header decodes the JSON header of the token into v.
+func (t *Token) Header(v interface{}) error {
+	return decodeSegment(t.header, v)
+}
+
+// Claims decodes the JSON payload of the token into v. They are only trusted once the token is verified.
+func (t *Token) Claims(v interface{}) error {
+	return decodeSegment(t.payload, v)
+}
+
+// Verify checks the signature of the token with the algorithm of its header against the public key.
+func (t *Token) Verify(alg string, key crypto.PublicKey) error {
+	signed := []byte(t.header + "." + t.payload)
+	digest := sha256.Sum256(signed)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], t.signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if alg == "ES256" && len(t.signature) == 64 && ecdsa.Verify(pub, digest[:],
+			new(big.Int).SetBytes(t.signature[:32]), new(big.Int).SetBytes(t.signature[32:])) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" && ed25519.Verify(pub, signed, t.signature) {
+			return nil
+		}
+	}
+	return errors.Errorf("bad %s signature", alg)
+}
+
+// Alg returns the algorithm the key signs with: RS256, ES256 for P-256 keys, or EdDSA.
+func Alg(key crypto.PublicKey) (string, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return "", errors.Errorf("tokens can't be signed with a %s key", pub.Curve.Params().Name)
+		}
+		return "ES256", nil
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	default:
+		return "", errors.Errorf("tokens can't be signed with a %T key", pub)
+	}
+}
+
+// Sign returns the compact JWS of the claims signed by the key. The alg of the header is set to the one of
+// the key.
+func Sign(key crypto.Signer, header map[string]interface{}, claims interface{}) (string, error) {
+	alg, err := Alg(key.Public())
+	if err != nil {
+		return "", err
+	}
+
+	h := map[string]interface{}{"alg": alg}
+	for k, v := range header {
+		h[k] = v
+	}
+	rawHeader, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	if alg == "EdDSA" {
+		sig, err = key.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign the token")
+	}
+
+	// JWS encodes the ECDSA signatures as the concatenation of r and s instead of ASN.1
+	if alg == "ES256" {
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return "", errors.Wrap(err, "failed to sign the token")
+		}
+		sig = make([]byte, 64)
+		rs.R.FillBytes(sig[:32])
+		rs.S.FillBytes(sig[32:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return errors.Errorf("malformed token: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jws_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/jws"
+)
+
+func TestSignVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for alg, key := range map[string]crypto.Signer{"RS256": rsaKey, "ES256": ecKey, "EdDSA": edKey} {
+		t.Run(alg, func(t *testing.T) {
+			token, err := jws.Sign(key, map[string]interface{}{"kid": "key"}, map[string]string{"sub": "user"})
+			require.NoError(t, err)
+
+			parsed, err := jws.Parse(token)
+			require.NoError(t, err)
+			header := map[string]string{}
+			require.NoError(t, parsed.Header(&header))
+			assert.Equal(t, map[string]string{"alg": alg, "kid": "key"}, header)
+			require.NoError(t, parsed.Verify(alg, key.Public()))
+			claims := map[string]string{}
+			require.NoError(t, parsed.Claims(&claims))
+			assert.Equal(t, "user", claims["sub"])
+
+			// the algorithm must match the key, and the signed parts can't be changed
+			assert.Error(t, parsed.Verify("none", key.Public()))
+			other, err := jws.Sign(key, nil, map[string]string{"sub": "other"})
+			require.NoError(t, err)
+			parts := strings.Split(token, ".")
+			tampered, err := jws.Parse(parts[0] + "." + strings.Split(other, ".")[1] + "." + parts[2])
+			require.NoError(t, err)
+			assert.Error(t, tampered.Verify(alg, key.Public()))
+		})
+	}
+
+	// only P-256 ECDSA keys sign tokens
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, err = jws.Sign(p384, nil, nil)
+	assert.Error(t, err)
+
+	_, err = jws.Parse("not.a-token")
+	assert.Error(t, err)
+}
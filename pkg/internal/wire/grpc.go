@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// MaxMessageSize is the maximum size of a message read from a call.
+const MaxMessageSize = 4 << 20
+
+// gRPC status codes returned by the servers.
+const (
+	CodeOK                 = 0
+	CodeInvalidArgument    = 3
+	CodeNotFound           = 5
+	CodePermissionDenied   = 7
+	CodeFailedPrecondition = 9
+	CodeUnimplemented      = 12
+	CodeInternal           = 13
+)
+
+// Frame returns the message prefixed with its length, as it is sent in the body of a call.
+func Frame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// ReadMessage reads the next length prefixed message of the body of a call.
+func ReadMessage(body io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(body, header); err != nil {
+		return nil, errors.Wrap(err, "failed to read the message")
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxMessageSize {
+		return nil, errors.Errorf("message of %d bytes exceeds the maximum of %d", size, MaxMessageSize)
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, errors.Wrap(err, "failed to read the message")
+	}
+	return msg, nil
+}
+
+// WriteStatus sets the status of the call, sent in the trailers once the response message is written. The
+// handler has to announce the Grpc-Status and Grpc-Message trailers before writing the body.
+func WriteStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", msg)
+	}
+}
+
+// StatusError is the error of a call which returned a status other than OK.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.Code, e.Message)
+}
+
+// HeaderStatus returns the StatusError of the status of the headers or trailers of a call, if any.
+func HeaderStatus(h http.Header) error {
+	status := h.Get("Grpc-Status")
+	if status == "" || status == "0" {
+		return nil
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return errors.Errorf("invalid gRPC status %q", status)
+	}
+	return &StatusError{Code: code, Message: h.Get("Grpc-Message")}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wire_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/wire"
+)
+
+func TestReadMessage(t *testing.T) {
+	body := bytes.NewReader(append(wire.Frame([]byte("first")), wire.Frame(nil)...))
+
+	msg, err := wire.ReadMessage(body)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), msg)
+	msg, err = wire.ReadMessage(body)
+	require.NoError(t, err)
+	assert.Empty(t, msg)
+	_, err = wire.ReadMessage(body)
+	assert.Error(t, err)
+
+	compressed := wire.Frame([]byte("first"))
+	compressed[0] = 1
+	_, err = wire.ReadMessage(bytes.NewReader(compressed))
+	assert.Error(t, err)
+
+	_, err = wire.ReadMessage(bytes.NewReader([]byte{0, 0xff, 0xff, 0xff, 0xff}))
+	assert.Error(t, err)
+}
+
+func TestHeaderStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	wire.WriteStatus(w, wire.CodeOK, "")
+	assert.NoError(t, wire.HeaderStatus(w.Header()))
+	assert.NoError(t, wire.HeaderStatus(http.Header{}))
+
+	w = httptest.NewRecorder()
+	wire.WriteStatus(w, wire.CodeNotFound, "no such secret")
+	err := wire.HeaderStatus(w.Header())
+	var status *wire.StatusError
+	require.True(t, errors.As(err, &status))
+	assert.Equal(t, &wire.StatusError{Code: wire.CodeNotFound, Message: "no such secret"}, status)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wire encodes and decodes by hand the protobuf messages of the gRPC APIs the self-signer serves or
+// calls, and frames them as gRPC calls, so that only the few messages used are implemented instead of
+// generating the whole APIs.
+package wire
+
+import (
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field is a decoded field of a message, either a varint or bytes.
+type Field struct {
+	Num    protowire.Number
+	Varint uint64
+	Bytes  []byte
+}
+
+// DecodeFields decodes the fields of a message, skipping the fixed size ones which these messages don't use.
+func DecodeFields(b []byte) ([]Field, error) {
+	var fields []Field
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, errors.Wrap(protowire.ParseError(n), "failed to decode message")
+		}
+		b = b[n:]
+
+		f := Field{Num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.Varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.Bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, errors.Wrap(protowire.ParseError(n), "failed to decode message")
+		}
+		b = b[n:]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// AppendString appends the string field, omitted when empty as in proto3.
+func AppendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// AppendBytes appends the bytes field, or the encoded message, even when empty so that the empty elements of
+// repeated fields are kept.
+func AppendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// AppendVarint appends the varint field, omitted when zero as in proto3.
+func AppendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
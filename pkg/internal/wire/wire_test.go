@@ -0,0 +1,49 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wire_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/wire"
+)
+
+func TestDecodeFields(t *testing.T) {
+	b := wire.AppendString(nil, 1, "name")
+	b = wire.AppendString(b, 2, "")
+	b = wire.AppendVarint(b, 3, 420)
+	b = wire.AppendBytes(b, 4, nil)
+	// fixed size fields are skipped
+	b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 1)
+
+	fields, err := wire.DecodeFields(b)
+	require.NoError(t, err)
+	assert.Equal(t, []wire.Field{
+		{Num: 1, Bytes: []byte("name")},
+		{Num: 3, Varint: 420},
+		{Num: 4, Bytes: []byte{}},
+		{Num: 5},
+	}, fields)
+
+	_, err = wire.DecodeFields(b[:len(b)-1])
+	assert.Error(t, err)
+}
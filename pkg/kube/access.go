@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Permission is an action on a resource which the client must be allowed to perform.
+type Permission struct {
+	Verb     string
+	Group    string
+	Resource string
+	// Subresource is the subresource of the permission, e.g. eviction for pods/eviction.
+	Subresource string
+	// Name restricts the permission to a single object, all objects of the resource are covered if empty.
+	Name string
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Name != "" {
+		resource += "/" + p.Name
+	}
+
+	return fmt.Sprintf("%s %s", p.Verb, resource)
+}
+
+// MissingPermissionsError is returned by CheckPermissions when some permissions are not granted.
+type MissingPermissionsError struct {
+	Namespace string
+	Missing   []Permission
+}
+
+func (e *MissingPermissionsError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, p := range e.Missing {
+		missing[i] = p.String()
+	}
+
+	return fmt.Sprintf("missing permissions in namespace %s: %s", e.Namespace, strings.Join(missing, ", "))
+}
+
+// CheckPermissions asks the API server, through a SelfSubjectAccessReview for each permission, whether the
+// client is allowed to perform them in the namespace. It returns a MissingPermissionsError listing the
+// permissions which are not granted, so that they are all reported at once instead of failing mid-run.
+func CheckPermissions(ctx context.Context, cl client.Client, namespace string, permissions []Permission) error {
+	var missing []Permission
+	for _, p := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+					Name:        p.Name,
+				},
+			},
+		}
+
+		if err := cl.Create(ctx, review); err != nil {
+			return errors.Wrapf(err, "failed to review permission to %s", p)
+		}
+
+		if !review.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+
+	if len(missing) > 0 {
+		return &MissingPermissionsError{Namespace: namespace, Missing: missing}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+
+	// only reading and creating secrets is allowed
+	fakeClient.AddReactor("create", "selfsubjectaccessreviews", func(action testutils.Action) (bool, error) {
+		review := action.(*testutils.CreateAction).Object().(*authorizationv1.SelfSubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = attrs.Resource == "secrets" && (attrs.Verb == "get" || attrs.Verb == "create")
+		return true, nil
+	})
+
+	require.NoError(t, kube.CheckPermissions(ctx, fakeClient, "test-namespace", []kube.Permission{
+		{Verb: "get", Resource: "secrets"},
+		{Verb: "create", Resource: "secrets"},
+	}))
+
+	err := kube.CheckPermissions(ctx, fakeClient, "test-namespace", []kube.Permission{
+		{Verb: "get", Resource: "secrets"},
+		{Verb: "patch", Resource: "secrets"},
+		{Verb: "update", Group: "apps", Resource: "statefulsets", Name: "crdb"},
+	})
+
+	var missing *kube.MissingPermissionsError
+	require.True(t, errors.As(err, &missing))
+	assert.Equal(t, "missing permissions in namespace test-namespace: patch secrets, update statefulsets.apps/crdb", err.Error())
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// FieldManager is the field manager of the fields written by the self-signer with server-side apply.
+const FieldManager = "cockroachdb-self-signer"
+
+// ApplyPersister writes the object with server-side apply. Only the fields of the object as given and
+// the fields set by the mutation function are owned by the self-signer, so labels and annotations added
+// by other controllers survive rotations. Unlike DefaultPersister, the mutation function is called on
+// the object as given rather than its current state, with only the current resource version set.
+// The object is applied with the resource version read just
+// before mutating it, so concurrent writes make it fail with a conflict, which is retried.
+var ApplyPersister PersistFn = func(ctx context.Context, cl client.Client, obj client.Object, f MutateFn) (upserted bool, err error) {
+	gvk, err := apiutil.GVKForObject(obj, cl.Scheme())
+	if err != nil {
+		return false, errors.Wrap(err, "failed to find the kind of the object")
+	}
+
+	original := obj.DeepCopyObject()
+
+	err = retry.OnError(retry.DefaultRetry, IsWriteConflict, func() error {
+		current := original.DeepCopyObject().(client.Object)
+		err := cl.Get(ctx, client.ObjectKeyFromObject(current), current)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		exists := err == nil
+
+		// the applied object only holds the fields we manage, starting from the object as given
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(original.DeepCopyObject()).Elem())
+		clearServerFields(obj)
+		obj.SetResourceVersion(current.GetResourceVersion())
+
+		if err := f(); err != nil {
+			return err
+		}
+
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		if err := cl.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+			return err
+		}
+
+		upserted = !exists || obj.GetResourceVersion() != current.GetResourceVersion()
+		return nil
+	})
+
+	return upserted, err
+}
+
+// clearServerFields clears the metadata fields set by the API server, which can't be applied.
+func clearServerFields(obj client.Object) {
+	obj.SetManagedFields(nil)
+	obj.SetUID("")
+	obj.SetGeneration(0)
+	obj.SetSelfLink("")
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+}
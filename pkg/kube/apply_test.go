@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutil"
+)
+
+func TestApplyPersister(t *testing.T) {
+	ctx := context.TODO()
+	env := testutil.StartEnvironment(t)
+	namespace := env.CreateNamespace(t, "apply")
+	r := resource.NewKubeResource(ctx, env.Client, namespace, kube.ApplyPersister)
+
+	secret := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, secret.UpdateTLSSecret([]byte("cert"), []byte("key"), []byte("ca"),
+		map[string]string{"certificate-duration": "8760h"}))
+
+	// another controller labels and annotates the secret
+	labeled := testutil.RequireSecret(t, env.Client, namespace, "crdb-node-secret")
+	labeled.Labels = map[string]string{"policy": "restricted"}
+	labeled.Annotations["owner"] = "platform"
+	require.NoError(t, env.Client.Update(ctx, labeled))
+
+	loaded, err := resource.LoadTLSSecret("crdb-node-secret", r)
+	require.NoError(t, err)
+
+	rotated := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	rotated.ExpectResourceVersion(loaded.Secret().ResourceVersion)
+	require.NoError(t, rotated.UpdateTLSSecret([]byte("new-cert"), []byte("new-key"), []byte("ca"),
+		map[string]string{"certificate-duration": "8760h"}))
+
+	actual := testutil.RequireSecret(t, env.Client, namespace, "crdb-node-secret")
+	assert.Equal(t, []byte("new-cert"), actual.Data[corev1.TLSCertKey])
+	assert.Equal(t, "restricted", actual.Labels["policy"])
+	assert.Equal(t, "platform", actual.Annotations["owner"])
+
+	hasManager := false
+	for _, f := range actual.ManagedFields {
+		hasManager = hasManager || (f.Manager == kube.FieldManager && f.Operation == metav1.ManagedFieldsOperationApply)
+	}
+	assert.True(t, hasManager)
+
+	// the rotation is not applied over a secret rotated since it was loaded
+	stale := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	stale.ExpectResourceVersion(loaded.Secret().ResourceVersion)
+	err = stale.UpdateTLSSecret([]byte("stale"), []byte("key"), []byte("ca"), map[string]string{})
+	assert.True(t, errors.Is(err, resource.ErrModified))
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var secretGVK = corev1.SchemeGroupVersion.WithKind("Secret")
+
+// CachedSecretsClient reads the secrets from an informer cache and everything else, as well as all the writes,
+// through the wrapped client, so that a controller managing many releases doesn't get every secret from the
+// API server on each reconcile.
+//
+// The cache lags behind the writes, which would make the next write of a secret conflict. The secrets written
+// through the client are read from the API server until the cache holds the written version, or at least the
+// version last read from the API server.
+type CachedSecretsClient struct {
+	client.Client
+	Cache client.Reader
+	// Namespaces are the namespaces the cache is scoped to, all namespaces if empty. The secrets of the other
+	// namespaces, and the lists across namespaces of a scoped cache, go through the wrapped client.
+	Namespaces []string
+
+	mu sync.Mutex
+	// pending maps the secrets whose cached version is stale to their latest known resource version, empty
+	// for deleted secrets.
+	pending map[types.NamespacedName]string
+}
+
+// NewCachedSecretsClient returns a client reading the secrets of the namespaces, or of all namespaces if none,
+// from cache and the rest from cl.
+func NewCachedSecretsClient(cache client.Reader, cl client.Client, namespaces ...string) *CachedSecretsClient {
+	return &CachedSecretsClient{Client: cl, Cache: cache, Namespaces: namespaces, pending: map[types.NamespacedName]string{}}
+}
+
+// cached returns true if the secrets of the namespace, all namespaces if empty, are cached.
+func (c *CachedSecretsClient) cached(namespace string) bool {
+	if len(c.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range c.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Get reads a secret from the cache, unless its cached version is older than the one written or last read. The
+// metadata of a secret is read from the cached secret as well.
+func (c *CachedSecretsClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if meta, ok := obj.(*metav1.PartialObjectMetadata); ok && meta.GroupVersionKind() == secretGVK &&
+		c.cached(key.Namespace) {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, key, secret); err != nil {
+			return err
+		}
+		meta.ObjectMeta = secret.ObjectMeta
+		return nil
+	}
+
+	if _, ok := obj.(*corev1.Secret); !ok || !c.cached(key.Namespace) {
+		return c.Client.Get(ctx, key, obj)
+	}
+
+	c.mu.Lock()
+	version, stale := c.pending[key]
+	c.mu.Unlock()
+
+	err := c.Cache.Get(ctx, key, obj)
+	if !stale {
+		return err
+	}
+
+	// the cache caught up with the latest known version
+	if (version == "" && apierrors.IsNotFound(err)) || (err == nil && obj.GetResourceVersion() == version) {
+		c.mu.Lock()
+		if c.pending[key] == version {
+			delete(c.pending, key)
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	err = c.Client.Get(ctx, key, obj)
+	switch {
+	case err == nil:
+		c.track(key, obj.GetResourceVersion())
+	case apierrors.IsNotFound(err):
+		c.track(key, "")
+	}
+	return err
+}
+
+// List lists the secrets from the cache, which may miss the latest writes.
+func (c *CachedSecretsClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if _, ok := list.(*corev1.SecretList); ok && c.cached(listOpts.Namespace) {
+		return c.Cache.List(ctx, list, opts...)
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+// Create creates the object and, for secrets, reads it from the API server until the cache holds it.
+func (c *CachedSecretsClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	err := c.Client.Create(ctx, obj, opts...)
+	c.written(obj, err)
+	return err
+}
+
+// Update updates the object and, for secrets, reads it from the API server until the cache holds the update.
+func (c *CachedSecretsClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	err := c.Client.Update(ctx, obj, opts...)
+	c.written(obj, err)
+	return err
+}
+
+// Patch patches the object and, for secrets, reads it from the API server until the cache holds the patch.
+func (c *CachedSecretsClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	c.written(obj, err)
+	return err
+}
+
+// Delete deletes the object and, for secrets, reads it from the API server until the cache drops it.
+func (c *CachedSecretsClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	err := c.Client.Delete(ctx, obj, opts...)
+	if _, ok := obj.(*corev1.Secret); ok && (err == nil || apierrors.IsNotFound(err)) {
+		// a secret with finalizers is only marked as deleted, in which case the version read from the API
+		// server is tracked instead
+		c.track(client.ObjectKeyFromObject(obj), "")
+	}
+	return err
+}
+
+// written tracks the version of the secret written, or the secret itself if the write failed, as its cached
+// version may be the reason of a conflict.
+func (c *CachedSecretsClient) written(obj client.Object, err error) {
+	if _, ok := obj.(*corev1.Secret); !ok {
+		return
+	}
+
+	version := obj.GetResourceVersion()
+	if err != nil {
+		// an impossible version, so that the secret is read from the API server next time
+		version = "unknown"
+	}
+	c.track(client.ObjectKeyFromObject(obj), version)
+}
+
+func (c *CachedSecretsClient) track(key types.NamespacedName, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[key] = version
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+// countingClient counts the gets of the wrapped client.
+type countingClient struct {
+	client.Client
+	gets int
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	c.gets++
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestCachedSecretsClient(t *testing.T) {
+	ctx := context.TODO()
+	scheme := testutils.InitScheme(t)
+	newSecret := func() *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"},
+			Data:       map[string][]byte{"tls.crt": []byte("v1")},
+		}
+	}
+	cache := testutils.NewFakeClient(scheme, newSecret())
+	direct := &countingClient{Client: testutils.NewFakeClient(scheme, newSecret(),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "crdb-serials", Namespace: "ns"}})}
+	cl := kube.NewCachedSecretsClient(cache, direct, "ns")
+	key := client.ObjectKey{Namespace: "ns", Name: "crdb-node-secret"}
+
+	// the secrets are read from the cache, the other objects from the API server
+	var secret corev1.Secret
+	require.NoError(t, cl.Get(ctx, key, &secret))
+	require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "crdb-serials"}, &corev1.ConfigMap{}))
+	assert.Equal(t, 1, direct.gets)
+
+	// the written secret is read from the API server until the cache catches up
+	secret.Data["tls.crt"] = []byte("v2")
+	require.NoError(t, cl.Update(ctx, &secret))
+	var read corev1.Secret
+	require.NoError(t, cl.Get(ctx, key, &read))
+	assert.Equal(t, "v2", string(read.Data["tls.crt"]))
+	assert.Equal(t, 2, direct.gets)
+
+	var cached corev1.Secret
+	require.NoError(t, cache.Get(ctx, key, &cached))
+	cached.Data["tls.crt"] = []byte("v2")
+	require.NoError(t, cache.Update(ctx, &cached))
+	require.Equal(t, secret.ResourceVersion, cached.ResourceVersion)
+
+	require.NoError(t, cl.Get(ctx, key, &read))
+	require.NoError(t, cl.Get(ctx, key, &read))
+	assert.Equal(t, "v2", string(read.Data["tls.crt"]))
+	assert.Equal(t, 2, direct.gets)
+
+	// so is the metadata of the secrets
+	meta := &metav1.PartialObjectMetadata{}
+	meta.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+	require.NoError(t, cl.Get(ctx, key, meta))
+	assert.Equal(t, secret.ResourceVersion, meta.ResourceVersion)
+	assert.Equal(t, 2, direct.gets)
+
+	var list corev1.SecretList
+	require.NoError(t, cl.List(ctx, &list, client.InNamespace("ns")))
+	assert.Len(t, list.Items, 1)
+
+	// the secrets of the other namespaces aren't cached
+	assert.True(t, apierrors.IsNotFound(cl.Get(ctx, client.ObjectKey{Namespace: "other", Name: "crdb-node-secret"}, &read)))
+	assert.Equal(t, 3, direct.gets)
+
+	// the deleted secret is missing even though the cache still holds it
+	require.NoError(t, cl.Delete(ctx, &secret))
+	assert.True(t, apierrors.IsNotFound(cl.Get(ctx, key, &read)))
+	assert.Equal(t, 4, direct.gets)
+
+	require.NoError(t, cache.Delete(ctx, &cached))
+	assert.True(t, apierrors.IsNotFound(cl.Get(ctx, key, &read)))
+	assert.True(t, apierrors.IsNotFound(cl.Get(ctx, key, &read)))
+	assert.Equal(t, 4, direct.gets)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	util "github.com/cockroachdb/helm-charts/pkg/utils"
+)
+
+// GetConfig returns the config to talk to the API server. Without a kubeconfig path or context, the
+// in-cluster config is used when running in a pod, otherwise the default kubeconfig. With a path or
+// a context, the given kubeconfig context is used, which allows running against remote clusters.
+func GetConfig(kubeconfig, context string) (*rest.Config, error) {
+	if kubeconfig == "" && context == "" {
+		return ctrl.GetConfig()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = util.ExpandHome(kubeconfig)
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
+}
+
+// GetNamespace returns the namespace of the kubeconfig context, as used by kubectl when no namespace is
+// given. It is "default" if the context has no namespace.
+func GetNamespace(kubeconfig, context string) (string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = util.ExpandHome(kubeconfig)
+
+	namespace, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: context}).Namespace()
+	return namespace, err
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EventSource is the component reporting the events of the self-signer.
+const EventSource = "crdb-self-signer"
+
+// RecordEvent creates an event of the object, so that it shows up in kubectl describe. The events of
+// cluster-scoped objects, such as nodes, are created in the default namespace like the kubelet does.
+func RecordEvent(ctx context.Context, cl client.Client, object corev1.ObjectReference, eventType, reason, message string) error {
+	namespace := object.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", object.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: object,
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: EventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	return cl.Create(ctx, event)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestRecordEvent(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+
+	var key client.ObjectKey
+	cl.AddReactor("create", "events", func(action testutils.Action) (bool, error) {
+		key = action.Key()
+		return false, nil
+	})
+
+	ref := corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: "ns", Name: "crdb-0"}
+	require.NoError(t, kube.RecordEvent(ctx, cl, ref, corev1.EventTypeWarning, "Failed", "failed"))
+
+	var event corev1.Event
+	require.NoError(t, cl.Get(ctx, key, &event))
+	assert.Equal(t, "ns", event.Namespace)
+	assert.Equal(t, ref, event.InvolvedObject)
+	assert.Equal(t, corev1.EventTypeWarning, event.Type)
+	assert.Equal(t, "Failed", event.Reason)
+	assert.Equal(t, "failed", event.Message)
+	assert.Equal(t, kube.EventSource, event.Source.Component)
+	assert.Equal(t, int32(1), event.Count)
+}
+
+func TestRecordEventClusterScoped(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+
+	var key client.ObjectKey
+	cl.AddReactor("create", "events", func(action testutils.Action) (bool, error) {
+		key = action.Key()
+		return false, nil
+	})
+
+	ref := corev1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: "node-a"}
+	require.NoError(t, kube.RecordEvent(ctx, cl, ref, corev1.EventTypeWarning, "Failed", "failed"))
+
+	var event corev1.Event
+	require.NoError(t, cl.Get(ctx, key, &event))
+	assert.Equal(t, "default", event.Namespace)
+	assert.Equal(t, ref, event.InvolvedObject)
+}
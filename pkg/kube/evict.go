@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RestartPod restarts the pod by evicting it with the eviction API, so that the PodDisruptionBudgets of the
+// pod are respected, or by deleting it if evictions is nil. Evictions refused by a budget are retried until
+// the timeout.
+func RestartPod(ctx context.Context, cl client.Client, evictions kubernetes.Interface, name, namespace string,
+	timeout time.Duration) error {
+
+	if evictions == nil {
+		return client.IgnoreNotFound(cl.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}))
+	}
+
+	eviction := &policyv1beta1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	f := func() error {
+		err := evictions.CoreV1().Pods(namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil || apierrors.IsNotFound(err):
+			return nil
+		case apierrors.IsTooManyRequests(err):
+			logrus.Infof("Eviction of pod [%s] is blocked by a PodDisruptionBudget, retrying", name)
+			return err
+		default:
+			return backoff.Permanent(err)
+		}
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = timeout
+	b.MaxInterval = 10 * time.Second
+	return backoff.Retry(f, backoff.WithContext(b, ctx))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestRestartPod(t *testing.T) {
+	ctx := context.TODO()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "crdb-0", Namespace: "ns"}}
+
+	// the pod is deleted without an eviction client
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), pod)
+	require.NoError(t, kube.RestartPod(ctx, cl, nil, "crdb-0", "ns", time.Second))
+	err := cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-0"}, &corev1.Pod{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// evictions refused by a PodDisruptionBudget are retried
+	var evictions int
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1beta1.Eviction)
+		assert.Equal(t, "crdb-0", eviction.Name)
+		evictions++
+		if evictions == 1 {
+			return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		return true, nil, nil
+	})
+
+	require.NoError(t, kube.RestartPod(ctx, cl, clientset, "crdb-0", "ns", 10*time.Second))
+	assert.Equal(t, 2, evictions)
+
+	// other errors aren't retried
+	clientset = fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("pods"), "crdb-0", nil)
+	})
+	err = kube.RestartPod(ctx, cl, clientset, "crdb-0", "ns", 10*time.Second)
+	assert.True(t, apierrors.IsForbidden(err), err)
+}
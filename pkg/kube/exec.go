@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Executor runs commands in the containers of pods.
+type Executor interface {
+	// Exec runs the command in the container of the pod with the given stdin, which may be nil, and fails
+	// with its stderr if it exits with an error.
+	Exec(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader) error
+}
+
+// NewExecutor returns an Executor running the commands through the exec subresource of the pods, like
+// kubectl exec.
+func NewExecutor(config *rest.Config) (Executor, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &podExecutor{config: config, client: clientset.CoreV1().RESTClient()}, nil
+}
+
+type podExecutor struct {
+	config *rest.Config
+	client rest.Interface
+}
+
+func (e *podExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader) error {
+	req := e.client.Post().Resource("pods").Name(pod).Namespace(namespace).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	// the stream isn't bound to the context, so it is run aside to return once the context is done
+	var stdout, stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- exec.Stream(remotecommand.StreamOptions{Stdin: stdin, Stdout: &stdout, Stderr: &stderr})
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s in pod %s failed: %s: %s", strings.Join(command, " "), pod, err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides an in-memory persister for tests which don't have a Kubernetes cluster.
+package fake
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// Persister keeps the objects persisted through it in memory. Its Persist method can be used
+// wherever a kube.PersistFn is expected; the client passed to it is ignored.
+type Persister struct {
+	mu      sync.Mutex
+	objects map[types.NamespacedName]client.Object
+
+	// Err, if set, is returned by Persist without persisting anything.
+	Err error
+	// Calls is the number of times Persist was called.
+	Calls int
+}
+
+var _ kube.PersistFn = (&Persister{}).Persist
+
+// NewPersister returns a persister holding a copy of the given objects.
+func NewPersister(objs ...client.Object) *Persister {
+	p := &Persister{objects: map[types.NamespacedName]client.Object{}}
+	for _, obj := range objs {
+		p.objects[client.ObjectKeyFromObject(obj)] = obj.DeepCopyObject().(client.Object)
+	}
+
+	return p
+}
+
+// Persist loads the stored state of obj, if any, mutates it and stores it back.
+func (p *Persister) Persist(_ context.Context, _ client.Client, obj client.Object, f kube.MutateFn) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Calls++
+	if p.Err != nil {
+		return false, p.Err
+	}
+
+	key := client.ObjectKeyFromObject(obj)
+	existing, exists := p.objects[key]
+	if exists {
+		copyInto(obj, existing)
+	}
+
+	if err := f(); err != nil {
+		return false, err
+	}
+
+	if exists && equality.Semantic.DeepEqual(existing, obj) {
+		return false, nil
+	}
+
+	p.objects[key] = obj.DeepCopyObject().(client.Object)
+	return true, nil
+}
+
+// Fetch loads the stored state of obj, or returns a NotFound error.
+func (p *Persister) Fetch(namespace string, obj client.Object) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: namespace, Name: obj.GetName()}
+	existing, exists := p.objects[key]
+	if !exists {
+		return apierrors.NewNotFound(schema.GroupResource{}, obj.GetName())
+	}
+
+	copyInto(obj, existing)
+	return nil
+}
+
+// Object returns a copy of the stored object, or nil if it doesn't exist.
+func (p *Persister) Object(namespace, name string) client.Object {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	obj, exists := p.objects[types.NamespacedName{Namespace: namespace, Name: name}]
+	if !exists {
+		return nil
+	}
+
+	return obj.DeepCopyObject().(client.Object)
+}
+
+// Resource returns a resource reading and writing the objects of the namespace in memory.
+func (p *Persister) Resource(ctx context.Context, namespace string) resource.Resource {
+	return resource.Resource{
+		Fetcher:   fetcher{p: p, namespace: namespace},
+		Persister: resource.NewKubePersister(ctx, namespace, nil, p.Persist),
+	}
+}
+
+type fetcher struct {
+	p         *Persister
+	namespace string
+}
+
+func (f fetcher) Fetch(obj client.Object) error {
+	return f.p.Fetch(f.namespace, obj)
+}
+
+// copyInto overwrites dst with a copy of src, which must be of the same type.
+func copyInto(dst, src client.Object) {
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src.DeepCopyObject()).Elem())
+}
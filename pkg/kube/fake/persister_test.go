@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube/fake"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+func TestPersister(t *testing.T) {
+	ctx := context.TODO()
+	namespace := "test-namespace"
+	p := fake.NewPersister()
+	r := p.Resource(ctx, namespace)
+
+	_, err := resource.LoadTLSSecret("crdb-node-secret", r)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	secret := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, secret.UpdateTLSSecret([]byte(testcerts.NodeCert), []byte(testcerts.NodeKey),
+		[]byte(testcerts.CACert), map[string]string{}))
+
+	loaded, err := resource.LoadTLSSecret("crdb-node-secret", r)
+	require.NoError(t, err)
+	assert.True(t, loaded.Ready())
+	assert.Equal(t, []byte(testcerts.NodeCert), loaded.TLSCert())
+
+	stored := p.Object(namespace, "crdb-node-secret").(*corev1.Secret)
+	assert.Equal(t, corev1.SecretTypeTLS, stored.Type)
+	assert.Equal(t, 1, p.Calls)
+
+	// persisting the same state again doesn't report an update
+	upserted, err := p.Persist(ctx, nil, stored, func() error { return nil })
+	require.NoError(t, err)
+	assert.False(t, upserted)
+}
+
+func TestPersisterError(t *testing.T) {
+	p := fake.NewPersister()
+	p.Err = errors.New("conflict")
+
+	secret := resource.CreateTLSSecret("crdb-node-secret", corev1.SecretTypeTLS, p.Resource(context.TODO(), "ns"))
+	assert.EqualError(t, secret.UpdateTLSSecret(nil, nil, nil, map[string]string{}), "conflict")
+	assert.Nil(t, p.Object("ns", "crdb-node-secret"))
+}
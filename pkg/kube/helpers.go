@@ -18,7 +18,10 @@ package kube
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"strconv"
 	"time"
 
@@ -27,8 +30,10 @@ import (
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -36,12 +41,43 @@ import (
 
 type PersistFn func(context.Context, client.Client, client.Object, MutateFn) (upserted bool, err error)
 
+// DefaultPersister creates or updates the object. The update is sent with the resource version read
+// just before mutating the object, so a concurrent write makes it fail with a conflict instead of being
+// overwritten. Conflicts are retried by reading the object and mutating it again.
 var DefaultPersister PersistFn = func(ctx context.Context, cl client.Client, obj client.Object, f MutateFn) (upserted bool, err error) {
-	result, err := ctrl.CreateOrUpdate(ctx, cl, obj, func() error {
-		return f()
+	original := obj.DeepCopyObject()
+
+	err = retry.OnError(retry.DefaultRetry, IsWriteConflict, func() error {
+		// start each attempt from the object as given, dropping the state read by the failed attempt
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(original.DeepCopyObject()).Elem())
+
+		result, err := ctrl.CreateOrUpdate(ctx, cl, obj, func() error {
+			return f()
+		})
+
+		upserted = result == ctrlutil.OperationResultCreated || result == ctrlutil.OperationResultUpdated
+		return err
 	})
 
-	return result == ctrlutil.OperationResultCreated || result == ctrlutil.OperationResultUpdated, err
+	return upserted, err
+}
+
+// IsWriteConflict returns true if the write failed because the object was concurrently updated or created.
+func IsWriteConflict(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+}
+
+// IsTransient returns true if the request failed for a reason which may go away on its own, such as the
+// API server being overloaded or unreachable, so that running the operation again later may succeed.
+func IsTransient(err error) bool {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) || apierrors.IsConflict(err) ||
+		apierrors.IsUnexpectedServerError(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
 }
 
 // MutateFn is a function which mutates the existing object into it's desired state.
@@ -115,7 +151,8 @@ func WaitUntilAllStsPodsAreReady(ctx context.Context, cl client.Client, stsName,
 	return backoff.Retry(f, b)
 }
 
-func RollingUpdate(ctx context.Context, cl client.Client, stsName, namespace string, readinessWait, podUpdateTimeout time.Duration) error {
+func RollingUpdate(ctx context.Context, cl client.Client, evictions kubernetes.Interface, stsName, namespace string,
+	readinessWait, podUpdateTimeout time.Duration) error {
 	var sts v1.StatefulSet
 	if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
 		return err
@@ -124,15 +161,9 @@ func RollingUpdate(ctx context.Context, cl client.Client, stsName, namespace str
 	logrus.Info("Performing rolling update after certificate rotation")
 	for i := int32(0); i < sts.Status.Replicas; i++ {
 		replicaName := stsName + "-" + strconv.Itoa(int(i))
-		replica := &corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      replicaName,
-				Namespace: namespace,
-			},
-		}
 
-		if err := cl.Delete(ctx, replica); err != nil {
-			log.Errorf("Failed to delete the statefulset replica [%s]", replicaName)
+		if err := RestartPod(ctx, cl, evictions, replicaName, namespace, podUpdateTimeout); err != nil {
+			log.Errorf("Failed to restart the statefulset replica [%s]", replicaName)
 			return err
 		}
 
@@ -153,6 +184,69 @@ func RollingUpdate(ctx context.Context, cl client.Client, stsName, namespace str
 	return nil
 }
 
+// AnnotatePodTemplate sets the given annotations on the pod template of the statefulset. A changed
+// annotation makes the statefulset controller perform a rolling update of the pods.
+func AnnotatePodTemplate(ctx context.Context, cl client.Client, stsName, namespace string, annotations map[string]string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var sts v1.StatefulSet
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+			return err
+		}
+
+		if sts.Spec.Template.Annotations == nil {
+			sts.Spec.Template.Annotations = map[string]string{}
+		}
+
+		for k, v := range annotations {
+			sts.Spec.Template.Annotations[k] = v
+		}
+
+		logrus.Infof("Updating pod template annotations of statefulset [%s]", stsName)
+		return cl.Update(ctx, &sts)
+	})
+}
+
+// ReplaceSecretVolume points the volumes of the statefulset pod template which mount the secret oldName,
+// directly or through a projected volume, to the secret newName. The changed pod template makes the
+// statefulset controller perform a rolling update of the pods. It returns false if no volume mounts oldName.
+func ReplaceSecretVolume(ctx context.Context, cl client.Client, stsName, namespace, oldName, newName string) (replaced bool, err error) {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var sts v1.StatefulSet
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+			return err
+		}
+
+		replaced = false
+		for i := range sts.Spec.Template.Spec.Volumes {
+			volume := &sts.Spec.Template.Spec.Volumes[i]
+			if volume.Secret != nil && volume.Secret.SecretName == oldName {
+				volume.Secret.SecretName = newName
+				replaced = true
+			}
+
+			if volume.Projected == nil {
+				continue
+			}
+			for j := range volume.Projected.Sources {
+				source := &volume.Projected.Sources[j]
+				if source.Secret != nil && source.Secret.Name == oldName {
+					source.Secret.Name = newName
+					replaced = true
+				}
+			}
+		}
+
+		if !replaced {
+			return nil
+		}
+
+		logrus.Infof("Replacing secret [%s] with [%s] in the volumes of statefulset [%s]", oldName, newName, stsName)
+		return cl.Update(ctx, &sts)
+	})
+
+	return replaced, err
+}
+
 func WaitForPodReady(ctx context.Context, cl client.Client, name, namespace string, podUpdateTimeout,
 	podMaxPollingInterval time.Duration) error {
 	f := func() error {
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestReplaceSecretVolume(t *testing.T) {
+	ctx := context.TODO()
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "test-namespace"},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{
+						{
+							Name: "certs-secret",
+							VolumeSource: corev1.VolumeSource{
+								Projected: &corev1.ProjectedVolumeSource{
+									Sources: []corev1.VolumeProjection{{
+										Secret: &corev1.SecretProjection{
+											LocalObjectReference: corev1.LocalObjectReference{Name: "crdb-node-secret"},
+										},
+									}},
+								},
+							},
+						},
+						{
+							Name: "log-config",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{SecretName: "crdb-log-config"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t), sts)
+
+	replaced, err := kube.ReplaceSecretVolume(ctx, fakeClient, "crdb", "test-namespace", "crdb-node-secret", "crdb-node-secret-v2")
+	require.NoError(t, err)
+	assert.True(t, replaced)
+
+	var updated appsv1.StatefulSet
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Namespace: "test-namespace", Name: "crdb"}, &updated))
+	volumes := updated.Spec.Template.Spec.Volumes
+	assert.Equal(t, "crdb-node-secret-v2", volumes[0].Projected.Sources[0].Secret.Name)
+	assert.Equal(t, "crdb-log-config", volumes[1].Secret.SecretName)
+
+	replaced, err = kube.ReplaceSecretVolume(ctx, fakeClient, "crdb", "test-namespace", "crdb-node-secret", "crdb-node-secret-v3")
+	require.NoError(t, err)
+	assert.False(t, replaced)
+}
+
+func TestIsTransient(t *testing.T) {
+	secrets := schema.GroupResource{Resource: "secrets"}
+
+	assert.True(t, kube.IsTransient(apierrors.NewServerTimeout(secrets, "get", 1)))
+	assert.True(t, kube.IsTransient(apierrors.NewTooManyRequests("slow down", 1)))
+	assert.True(t, kube.IsTransient(apierrors.NewServiceUnavailable("unavailable")))
+	assert.True(t, kube.IsTransient(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.True(t, kube.IsTransient(context.DeadlineExceeded))
+
+	assert.False(t, kube.IsTransient(apierrors.NewNotFound(secrets, "crdb-node-secret")))
+	assert.False(t, kube.IsTransient(apierrors.NewForbidden(secrets, "crdb-node-secret", errors.New("forbidden"))))
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrNamespaceTerminating is returned when the namespace is being deleted, in which case the API server
+// rejects the creation of any resource in it.
+var ErrNamespaceTerminating = errors.New("namespace is terminating")
+
+// CheckNamespace returns ErrNamespaceTerminating if the namespace is being deleted. Since namespaces are
+// cluster scoped, reading them may not be allowed to the service account, in which case the namespace is
+// assumed to be active and a terminating namespace is only detected by IsNamespaceTerminating.
+func CheckNamespace(ctx context.Context, cl client.Client, namespace string) error {
+	var ns corev1.Namespace
+	if err := cl.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		if apierrors.IsForbidden(err) {
+			logrus.Debugf("Not allowed to get namespace [%s], skipping the namespace phase check", namespace)
+			return nil
+		}
+		return errors.Wrapf(err, "failed to get namespace [%s]", namespace)
+	}
+
+	if ns.Status.Phase == corev1.NamespaceTerminating || ns.DeletionTimestamp != nil {
+		return errors.Wrapf(ErrNamespaceTerminating, "namespace [%s]", namespace)
+	}
+
+	return nil
+}
+
+// IsNamespaceTerminating returns true if the error, or any error it wraps, is ErrNamespaceTerminating or
+// the API server rejecting a write because the namespace is being deleted.
+func IsNamespaceTerminating(err error) bool {
+	if errors.Is(err, ErrNamespaceTerminating) {
+		return true
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return apierrors.HasStatusCause(status.(error), corev1.NamespaceTerminatingCause)
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestCheckNamespace(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t),
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "active"},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+		},
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "terminating"},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+		},
+	)
+
+	require.NoError(t, kube.CheckNamespace(ctx, fakeClient, "active"))
+
+	err := kube.CheckNamespace(ctx, fakeClient, "terminating")
+	require.Error(t, err)
+	assert.True(t, kube.IsNamespaceTerminating(err))
+
+	err = kube.CheckNamespace(ctx, fakeClient, "missing")
+	require.Error(t, err)
+	assert.False(t, kube.IsNamespaceTerminating(err))
+}
+
+func TestIsNamespaceTerminating(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "crdb-node-secret",
+		errors.New("unable to create new content in namespace crdb because it is being terminated"))
+	assert.False(t, kube.IsNamespaceTerminating(forbidden))
+
+	forbidden.ErrStatus.Details.Causes = []metav1.StatusCause{{Type: corev1.NamespaceTerminatingCause}}
+	assert.True(t, kube.IsNamespaceTerminating(errors.Wrap(forbidden, "failed to update node TLS secret certs")))
+}
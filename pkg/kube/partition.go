@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SetPartition sets the partition of the rolling update of the statefulset, so that only the pods with an
+// ordinal greater than or equal to it are updated to a changed pod template. It returns the previous
+// partition, and false if the statefulset isn't updated with the RollingUpdate strategy.
+func SetPartition(ctx context.Context, cl client.Client, stsName, namespace string, partition int32) (int32, bool, error) {
+	var previous int32
+	var ok bool
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var sts v1.StatefulSet
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+			return err
+		}
+
+		strategy := &sts.Spec.UpdateStrategy
+		ok = strategy.Type == "" || strategy.Type == v1.RollingUpdateStatefulSetStrategyType
+		if !ok {
+			return nil
+		}
+
+		previous = 0
+		if strategy.RollingUpdate != nil && strategy.RollingUpdate.Partition != nil {
+			previous = *strategy.RollingUpdate.Partition
+		}
+		if previous == partition {
+			return nil
+		}
+
+		strategy.Type = v1.RollingUpdateStatefulSetStrategyType
+		strategy.RollingUpdate = &v1.RollingUpdateStatefulSetStrategy{Partition: &partition}
+
+		logrus.Infof("Setting the partition of statefulset [%s] to %d", stsName, partition)
+		return cl.Update(ctx, &sts)
+	})
+
+	return previous, ok, err
+}
+
+// WaitForPodUpdated waits until the pod of the statefulset runs the update revision of its pod template
+// and is ready.
+func WaitForPodUpdated(ctx context.Context, cl client.Client, stsName, name, namespace string, podUpdateTimeout,
+	podMaxPollingInterval time.Duration) error {
+
+	f := func() error {
+		var sts v1.StatefulSet
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+			return err
+		}
+		if sts.Status.ObservedGeneration < sts.Generation {
+			return fmt.Errorf("statefulset %s not observed yet", stsName)
+		}
+
+		var pod corev1.Pod
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &pod); err != nil {
+			return err
+		}
+
+		if pod.Labels[v1.ControllerRevisionHashLabelKey] != sts.Status.UpdateRevision {
+			return fmt.Errorf("Pod %s not updated yet", name)
+		}
+		if pod.Status.Phase == corev1.PodPending || !IsPodReady(&pod) {
+			return fmt.Errorf("Pod %s not in ready state", name)
+		}
+
+		logrus.Infof("Pod %s updated and in ready state now", name)
+		return nil
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = podUpdateTimeout
+	b.MaxInterval = podMaxPollingInterval
+	return backoff.Retry(f, backoff.WithContext(b, ctx))
+}
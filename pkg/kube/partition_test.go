@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestSetPartition(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t),
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"}},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "on-delete", Namespace: "ns"},
+			Spec: appsv1.StatefulSetSpec{
+				UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+			},
+		})
+
+	previous, ok, err := kube.SetPartition(ctx, cl, "crdb", "ns", 2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int32(0), previous)
+
+	var sts appsv1.StatefulSet
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb"}, &sts))
+	assert.Equal(t, int32(2), *sts.Spec.UpdateStrategy.RollingUpdate.Partition)
+
+	previous, _, err = kube.SetPartition(ctx, cl, "crdb", "ns", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), previous)
+
+	// the partition only applies to rolling updates
+	_, ok, err = kube.SetPartition(ctx, cl, "on-delete", "ns", 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
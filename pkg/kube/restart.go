@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RestartAllPods deletes all the pods of the statefulset at once and waits for their replacements to be
+// ready. It is meant for the pods which can't be restarted one by one, such as nodes which no longer trust
+// the certificates of each other. The PodDisruptionBudgets are bypassed, as they can't be met by such pods.
+func RestartAllPods(ctx context.Context, cl client.Client, stsName, namespace string, podUpdateTimeout time.Duration) error {
+	var sts appsv1.StatefulSet
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+		return err
+	}
+
+	if sts.Spec.PodManagementPolicy != appsv1.ParallelPodManagement {
+		logrus.Warnf("Statefulset [%s] creates its pods one by one, which may not get ready until all of them run", stsName)
+	}
+
+	replicas := int(sts.Status.Replicas)
+	if sts.Spec.Replicas != nil {
+		replicas = int(*sts.Spec.Replicas)
+	}
+
+	logrus.Infof("Restarting the %d pods of statefulset [%s] at once", replicas, stsName)
+	previous := map[string]types.UID{}
+	for i := 0; i < replicas; i++ {
+		name := stsName + "-" + strconv.Itoa(i)
+
+		var pod corev1.Pod
+		err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &pod)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		previous[name] = pod.UID
+
+		if err := cl.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete pod [%s]: %w", name, err)
+		}
+	}
+
+	for i := 0; i < replicas; i++ {
+		name := stsName + "-" + strconv.Itoa(i)
+		if err := waitForPodReplaced(ctx, cl, name, namespace, previous[name], podUpdateTimeout); err != nil {
+			return err
+		}
+	}
+
+	return WaitUntilAllStsPodsAreReady(ctx, cl, stsName, namespace, podUpdateTimeout, 5*time.Second)
+}
+
+// waitForPodReplaced waits for the pod to be recreated, with another UID than the deleted pod, and ready.
+func waitForPodReplaced(ctx context.Context, cl client.Client, name, namespace string, deleted types.UID,
+	timeout time.Duration) error {
+	f := func() error {
+		var pod corev1.Pod
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &pod); err != nil {
+			return err
+		}
+
+		if pod.UID == deleted || !IsPodReady(&pod) {
+			return fmt.Errorf("pod %s not replaced by a ready pod", name)
+		}
+
+		logrus.Infof("Pod %s in ready state now", name)
+		return nil
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = timeout
+	b.MaxInterval = 5 * time.Second
+	return backoff.Retry(f, backoff.WithContext(b, ctx))
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func readyPod(name string, uid types.UID) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", UID: uid},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func TestRestartAllPods(t *testing.T) {
+	ctx := context.TODO()
+	replicas := int32(2)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas, PodManagementPolicy: appsv1.ParallelPodManagement},
+		Status:     appsv1.StatefulSetStatus{Replicas: 2, ReadyReplicas: 2},
+	}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), sts, readyPod("crdb-0", "old-0"), readyPod("crdb-1", "old-1"))
+
+	// the statefulset controller recreates the deleted pods
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, name := range []string{"crdb-0", "crdb-1"} {
+			for {
+				err := cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: name}, &corev1.Pod{})
+				if apierrors.IsNotFound(err) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		for _, name := range []string{"crdb-0", "crdb-1"} {
+			if err := cl.Create(ctx, readyPod(name, types.UID("new-"+name))); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	require.NoError(t, kube.RestartAllPods(ctx, cl, "crdb", "ns", 10*time.Second))
+	<-done
+
+	// the pods which aren't recreated time out
+	require.NoError(t, cl.Delete(ctx, readyPod("crdb-1", "")))
+	require.Error(t, kube.RestartAllPods(ctx, cl, "crdb", "ns", time.Second))
+}
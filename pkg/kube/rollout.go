@@ -0,0 +1,185 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RolloutStarted annotates the statefulset with the time its progressive rollout started, until all the pods
+// created before it were restarted.
+const RolloutStarted = "crdb.io/rollout-started"
+
+// ErrRolloutHalted is returned when a progressive rollout stopped because the cluster was degraded.
+var ErrRolloutHalted = errors.New("rollout halted, the cluster is degraded")
+
+// HealthCheck returns an error while the cluster is degraded.
+type HealthCheck func(ctx context.Context) error
+
+// restartInterval is the time waited after deleting a pod before polling its replacement.
+var restartInterval = 5 * time.Second
+
+// ProgressiveRollout restarts the pods of the statefulset one by one, as RollingUpdate does, but only once
+// the check reports the cluster healthy. If the cluster doesn't recover within healthTimeout, the rollout
+// halts with ErrRolloutHalted and the statefulset keeps its RolloutStarted annotation, so that the next
+// rollout only restarts the pods which weren't restarted yet.
+func ProgressiveRollout(ctx context.Context, cl client.Client, evictions kubernetes.Interface, stsName, namespace string,
+	readinessWait, podUpdateTimeout, healthTimeout time.Duration, check HealthCheck) error {
+
+	started, err := startRollout(ctx, cl, stsName, namespace)
+	if err != nil {
+		return err
+	}
+
+	var sts v1.StatefulSet
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+		return err
+	}
+
+	logrus.Info("Performing progressive rolling update after certificate rotation")
+	for i := int32(0); i < sts.Status.Replicas; i++ {
+		replicaName := stsName + "-" + strconv.Itoa(int(i))
+
+		var pod corev1.Pod
+		err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: replicaName}, &pod)
+		if apierrors.IsNotFound(err) {
+			logrus.Infof("Pod [%s] is being recreated, skipping", replicaName)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !pod.CreationTimestamp.Time.Before(started) {
+			logrus.Infof("Pod [%s] was restarted since the rollout started, skipping", replicaName)
+			continue
+		}
+
+		if err := waitHealthy(ctx, check, healthTimeout); err != nil {
+			logrus.Errorf("Halting the rollout of statefulset [%s] before restarting pod [%s]: %s", stsName, replicaName, err)
+			return fmt.Errorf("%w: %s", ErrRolloutHalted, err)
+		}
+
+		if err := RestartPod(ctx, cl, evictions, replicaName, namespace, podUpdateTimeout); err != nil {
+			logrus.Errorf("Failed to restart the statefulset replica [%s]", replicaName)
+			return err
+		}
+
+		time.Sleep(restartInterval)
+		if err := WaitForPodReady(ctx, cl, replicaName, namespace, podUpdateTimeout, 5*time.Second); err != nil {
+			return err
+		}
+
+		logrus.Infof("waiting for %s duration for pod readiness", readinessWait.String())
+		time.Sleep(readinessWait)
+	}
+
+	if err := WaitUntilAllStsPodsAreReady(ctx, cl, stsName, namespace, podUpdateTimeout, 5*time.Second); err != nil {
+		return err
+	}
+
+	return finishRollout(ctx, cl, stsName, namespace)
+}
+
+// RolloutPending returns true if the statefulset has a progressive rollout which was halted.
+func RolloutPending(ctx context.Context, cl client.Client, stsName, namespace string) (bool, error) {
+	var sts v1.StatefulSet
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, ok := sts.Annotations[RolloutStarted]
+	return ok, nil
+}
+
+// waitHealthy polls the check until it succeeds or the timeout expires.
+func waitHealthy(ctx context.Context, check HealthCheck, timeout time.Duration) error {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = timeout
+	b.MaxInterval = 30 * time.Second
+
+	return backoff.Retry(func() error {
+		if err := check(ctx); err != nil {
+			logrus.Warnf("Cluster is degraded, waiting for it to recover: %s", err)
+			return err
+		}
+		return nil
+	}, backoff.WithContext(b, ctx))
+}
+
+// startRollout returns the start time of the rollout of the statefulset, recording it in the RolloutStarted
+// annotation unless a halted rollout is resumed.
+func startRollout(ctx context.Context, cl client.Client, stsName, namespace string) (time.Time, error) {
+	var started time.Time
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var sts v1.StatefulSet
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+			return err
+		}
+
+		if value, ok := sts.Annotations[RolloutStarted]; ok {
+			t, err := time.Parse(time.RFC3339, value)
+			if err == nil {
+				logrus.Infof("Resuming the rollout of statefulset [%s] started at %s", stsName, value)
+				started = t
+				return nil
+			}
+		}
+
+		started = time.Now().Truncate(time.Second)
+		if sts.Annotations == nil {
+			sts.Annotations = map[string]string{}
+		}
+		sts.Annotations[RolloutStarted] = started.Format(time.RFC3339)
+		return cl.Update(ctx, &sts)
+	})
+
+	return started, err
+}
+
+// finishRollout removes the RolloutStarted annotation of the statefulset.
+func finishRollout(ctx context.Context, cl client.Client, stsName, namespace string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var sts v1.StatefulSet
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: stsName}, &sts); err != nil {
+			return err
+		}
+
+		if _, ok := sts.Annotations[RolloutStarted]; !ok {
+			return nil
+		}
+
+		delete(sts.Annotations, RolloutStarted)
+		return cl.Update(ctx, &sts)
+	})
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestProgressiveRollout(t *testing.T) {
+	ctx := context.TODO()
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", CreationTimestamp: created}}
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb", Namespace: "ns"},
+		Status:     appsv1.StatefulSetStatus{Replicas: 2, ReadyReplicas: 2},
+	}
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), sts, pod("crdb-0"), pod("crdb-1"))
+
+	// the rollout halts before restarting any pod while the cluster is degraded
+	degraded := func(ctx context.Context) error { return errors.New("ranges are under-replicated") }
+	err := kube.ProgressiveRollout(ctx, cl, nil, "crdb", "ns", 0, time.Second, 10*time.Millisecond, degraded)
+	require.True(t, errors.Is(err, kube.ErrRolloutHalted), err)
+
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb-0"}, &corev1.Pod{}))
+	pending, err := kube.RolloutPending(ctx, cl, "crdb", "ns")
+	require.NoError(t, err)
+	assert.True(t, pending)
+
+	// the resumed rollout skips the pods created since the rollout started, and completes
+	var current appsv1.StatefulSet
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "crdb"}, &current))
+	current.Annotations[kube.RolloutStarted] = created.Add(-time.Minute).Format(time.RFC3339)
+	require.NoError(t, cl.Update(ctx, &current))
+
+	healthy := func(ctx context.Context) error { return nil }
+	require.NoError(t, kube.ProgressiveRollout(ctx, cl, nil, "crdb", "ns", 0, time.Second, time.Second, healthy))
+
+	pending, err = kube.RolloutPending(ctx, cl, "crdb", "ns")
+	require.NoError(t, err)
+	assert.False(t, pending)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migration upgrades secrets written by older versions of the self-signer, or by hand before the
+// self-signer took over, to the layout the current version expects.
+package migration
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/version"
+)
+
+// Migration upgrades an older layout of a secret in place.
+type Migration struct {
+	// Name identifies the migration in the logs.
+	Name string
+	// Data is true if the migration changes the secret data, which can't be changed in immutable secrets.
+	Data bool
+	// Migrate upgrades the secret if it has the older layout, and returns true if it changed it. It must be
+	// a no-op on secrets already in the current layout.
+	Migrate func(secret *corev1.Secret) bool
+}
+
+// Migrations are the migrations of the secrets, in the order they are applied.
+var Migrations = []Migration{
+	{Name: "cockroach-key-names", Data: true, Migrate: renameCockroachKeys},
+	{Name: "not-after-annotations", Migrate: dropNotAfter},
+	{Name: "unix-timestamps", Migrate: addUnixTimestamps},
+	{Name: "data-checksum", Migrate: addDataChecksum},
+}
+
+// Apply applies the migrations the secret needs and returns the names of the migrations which changed it.
+// Secrets last written by the running version of the self-signer or a newer one, and paused secrets, are
+// left as they are.
+func Apply(secret *corev1.Secret, migrations []Migration) []string {
+	if secret.Annotations[resource.Paused] == "true" {
+		return nil
+	}
+
+	if managedBy := secret.Annotations[resource.ManagedByVersion]; managedBy != "" && !version.Less(managedBy, version.Version) {
+		return nil
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		if m.Data && secret.Immutable != nil && *secret.Immutable {
+			continue
+		}
+
+		if m.Migrate(secret) {
+			applied = append(applied, m.Name)
+		}
+	}
+
+	if len(applied) > 0 {
+		secret.Annotations[resource.ManagedByVersion] = version.Version
+	}
+	return applied
+}
+
+// Run applies the migrations to the named secrets of the namespace which exist, before they are reconciled.
+func Run(ctx context.Context, cl client.Client, namespace string, names []string, migrations []Migration) error {
+	for _, name := range names {
+		var applied []string
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			secret := &corev1.Secret{}
+			if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+				return err
+			}
+
+			if secret.Annotations == nil {
+				secret.Annotations = map[string]string{}
+			}
+
+			if applied = Apply(secret, migrations); len(applied) == 0 {
+				return nil
+			}
+			return cl.Update(ctx, secret)
+		})
+		if client.IgnoreNotFound(err) != nil {
+			return errors.Wrapf(err, "failed to migrate secret [%s]", name)
+		}
+
+		if len(applied) > 0 {
+			logrus.Infof("Migrated secret [%s]: %s", name, strings.Join(applied, ", "))
+		}
+	}
+
+	return nil
+}
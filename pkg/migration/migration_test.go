@@ -0,0 +1,191 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/migration"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+	"github.com/cockroachdb/helm-charts/pkg/version"
+)
+
+func secret(annotations map[string]string, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "crdb", Annotations: annotations},
+		Data:       data,
+	}
+}
+
+func migrationNamed(t *testing.T, name string) migration.Migration {
+	for _, m := range migration.Migrations {
+		if m.Name == name {
+			return m
+		}
+	}
+
+	t.Fatalf("no migration %q", name)
+	return migration.Migration{}
+}
+
+func TestRenameCockroachKeys(t *testing.T) {
+	m := migrationNamed(t, "cockroach-key-names")
+
+	tests := []struct {
+		name     string
+		data     map[string][]byte
+		expected map[string][]byte
+		changed  bool
+	}{
+		{
+			name:     "node keys",
+			data:     map[string][]byte{"ca.crt": []byte("ca"), "node.crt": []byte("cert"), "node.key": []byte("key")},
+			expected: map[string][]byte{"ca.crt": []byte("ca"), "tls.crt": []byte("cert"), "tls.key": []byte("key")},
+			changed:  true,
+		},
+		{
+			name:     "client keys",
+			data:     map[string][]byte{"ca.crt": []byte("ca"), "client.root.crt": []byte("cert"), "client.root.key": []byte("key")},
+			expected: map[string][]byte{"ca.crt": []byte("ca"), "tls.crt": []byte("cert"), "tls.key": []byte("key")},
+			changed:  true,
+		},
+		{
+			name:     "current layout",
+			data:     map[string][]byte{"ca.crt": []byte("ca"), "tls.crt": []byte("cert"), "tls.key": []byte("key")},
+			expected: map[string][]byte{"ca.crt": []byte("ca"), "tls.crt": []byte("cert"), "tls.key": []byte("key")},
+		},
+		{
+			name:     "missing key",
+			data:     map[string][]byte{"ca.crt": []byte("ca"), "node.crt": []byte("cert")},
+			expected: map[string][]byte{"ca.crt": []byte("ca"), "node.crt": []byte("cert")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := secret(map[string]string{}, tt.data)
+			assert.Equal(t, tt.changed, m.Migrate(s))
+			assert.Equal(t, tt.expected, s.Data)
+		})
+	}
+}
+
+func TestRenameCockroachKeysUpdatesHash(t *testing.T) {
+	s := secret(map[string]string{resource.SecretDataHash: "1"},
+		map[string][]byte{"node.crt": []byte("cert"), "node.key": []byte("key")})
+	require.True(t, migrationNamed(t, "cockroach-key-names").Migrate(s))
+
+	hash, err := resource.DataHash(s.Data)
+	require.NoError(t, err)
+	assert.Equal(t, hash, s.Annotations[resource.SecretDataHash])
+	assert.Equal(t, resource.DataChecksum(s.Data), s.Annotations[resource.SecretDataChecksum])
+}
+
+func TestDropNotAfter(t *testing.T) {
+	m := migrationNamed(t, "not-after-annotations")
+
+	// the duplicate annotations are dropped, and the certificate info ones kept
+	s := secret(map[string]string{
+		"crdb.io/not-after":    "2021-08-05T04:15:35Z",
+		resource.CertValidUpto: "2021-08-05T04:15:35Z",
+	}, nil)
+	require.True(t, m.Migrate(s))
+	assert.Equal(t, map[string]string{resource.CertValidUpto: "2021-08-05T04:15:35Z"}, s.Annotations)
+
+	// or added from them if missing
+	s = secret(map[string]string{
+		"crdb.io/not-after":      "2021-08-05T04:15:35Z",
+		"crdb.io/not-after-unix": "1628136935",
+	}, nil)
+	require.True(t, m.Migrate(s))
+	assert.Equal(t, map[string]string{
+		resource.CertValidUpto:     "2021-08-05T04:15:35Z",
+		resource.CertValidUptoUnix: "1628136935",
+	}, s.Annotations)
+
+	// the migrated secret is left as it is
+	assert.False(t, m.Migrate(s))
+}
+
+func TestAddUnixTimestamps(t *testing.T) {
+	m := migrationNamed(t, "unix-timestamps")
+
+	s := secret(map[string]string{
+		resource.CertValidFrom: "2021-07-06T04:15:35Z",
+		resource.CertValidUpto: "2021-08-05T04:15:35Z",
+	}, nil)
+	require.True(t, m.Migrate(s))
+	assert.Equal(t, "1625544935", s.Annotations[resource.CertValidFromUnix])
+	assert.Equal(t, "1628136935", s.Annotations[resource.CertValidUptoUnix])
+	assert.NotContains(t, s.Annotations, resource.RotationDueAtUnix)
+
+	// the migrated secret is left as it is
+	assert.False(t, m.Migrate(s))
+}
+
+func TestAddDataChecksum(t *testing.T) {
+	m := migrationNamed(t, "data-checksum")
+	data := map[string][]byte{"tls.crt": []byte("cert")}
+
+	// secrets not written by the self-signer have no checksum to record
+	assert.False(t, m.Migrate(secret(map[string]string{}, data)))
+
+	s := secret(map[string]string{resource.SecretDataHash: "1"}, data)
+	require.True(t, m.Migrate(s))
+	assert.Equal(t, resource.DataChecksum(data), s.Annotations[resource.SecretDataChecksum])
+	assert.False(t, m.Migrate(s))
+}
+
+func TestApply(t *testing.T) {
+	data := map[string][]byte{"node.crt": []byte("cert"), "node.key": []byte("key")}
+
+	s := secret(map[string]string{}, data)
+	assert.Equal(t, []string{"cockroach-key-names"}, migration.Apply(s, migration.Migrations))
+	assert.Equal(t, version.Version, s.Annotations[resource.ManagedByVersion])
+
+	// secrets written by the running version are current
+	s = secret(map[string]string{resource.ManagedByVersion: version.Version}, data)
+	assert.Empty(t, migration.Apply(s, migration.Migrations))
+
+	// the data of immutable secrets can't be migrated
+	immutable := true
+	s = secret(map[string]string{}, data)
+	s.Immutable = &immutable
+	assert.Empty(t, migration.Apply(s, migration.Migrations))
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t), secret(map[string]string{},
+		map[string][]byte{"node.crt": []byte("cert"), "node.key": []byte("key")}))
+
+	require.NoError(t, migration.Run(ctx, cl, "crdb", []string{"crdb-node-secret", "crdb-missing-secret"},
+		migration.Migrations))
+
+	actual := &corev1.Secret{}
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "crdb", Name: "crdb-node-secret"}, actual))
+	assert.Equal(t, map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")}, actual.Data)
+	assert.Equal(t, version.Version, actual.Annotations[resource.ManagedByVersion])
+}
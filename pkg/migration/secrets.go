@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+)
+
+// renameCockroachKeys renames the node.crt and node.key, or client.<user>.crt and client.<user>.key, keys of
+// secrets created from a certs directory of the cockroach CLI to the tls.crt and tls.key keys.
+func renameCockroachKeys(secret *corev1.Secret) bool {
+	if _, ok := secret.Data[corev1.TLSCertKey]; ok {
+		return false
+	}
+
+	var prefix string
+	for key := range secret.Data {
+		if key == "node.crt" || strings.HasPrefix(key, "client.") && strings.HasSuffix(key, ".crt") {
+			prefix = strings.TrimSuffix(key, ".crt")
+			break
+		}
+	}
+
+	if _, ok := secret.Data[prefix+".key"]; prefix == "" || !ok {
+		return false
+	}
+
+	secret.Data[corev1.TLSCertKey] = secret.Data[prefix+".crt"]
+	secret.Data[corev1.TLSPrivateKeyKey] = secret.Data[prefix+".key"]
+	delete(secret.Data, prefix+".crt")
+	delete(secret.Data, prefix+".key")
+
+	// the hash recorded for the old keys would make the certificate look altered
+	if _, ok := secret.Annotations[resource.SecretDataHash]; ok {
+		if hash, err := resource.DataHash(secret.Data); err == nil {
+			secret.Annotations[resource.SecretDataHash] = hash
+		}
+		secret.Annotations[resource.SecretDataChecksum] = resource.DataChecksum(secret.Data)
+	}
+	return true
+}
+
+// The expiry annotations of the admission policies of older versions, which duplicated resource.CertValidUpto
+// and resource.CertValidUptoUnix.
+const (
+	notAfterAnnotation     = "crdb.io/not-after"
+	notAfterUnixAnnotation = "crdb.io/not-after-unix"
+)
+
+// dropNotAfter removes the expiry annotations duplicating the certificate info ones, which are added from them
+// if missing.
+func dropNotAfter(secret *corev1.Secret) bool {
+	changed := false
+	for old, current := range map[string]string{
+		notAfterAnnotation:     resource.CertValidUpto,
+		notAfterUnixAnnotation: resource.CertValidUptoUnix,
+	} {
+		value, ok := secret.Annotations[old]
+		if !ok {
+			continue
+		}
+
+		if _, ok := secret.Annotations[current]; !ok {
+			secret.Annotations[current] = value
+		}
+		delete(secret.Annotations, old)
+		changed = true
+	}
+
+	return changed
+}
+
+// addUnixTimestamps adds the UNIX timestamp annotations of the validity of the certificate, derived from the
+// RFC 3339 ones.
+func addUnixTimestamps(secret *corev1.Secret) bool {
+	changed := false
+	for rfc3339, unix := range map[string]string{
+		resource.CertValidFrom: resource.CertValidFromUnix,
+		resource.CertValidUpto: resource.CertValidUptoUnix,
+		resource.RotationDueAt: resource.RotationDueAtUnix,
+	} {
+		if _, ok := secret.Annotations[unix]; ok {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, secret.Annotations[rfc3339])
+		if err != nil {
+			continue
+		}
+
+		secret.Annotations[unix] = strconv.FormatInt(t.Unix(), 10)
+		changed = true
+	}
+
+	return changed
+}
+
+// addDataChecksum adds the checksum of the data of secrets written by the self-signer, which only recorded
+// the hash of the data.
+func addDataChecksum(secret *corev1.Secret) bool {
+	if _, ok := secret.Annotations[resource.SecretDataHash]; !ok {
+		return false
+	}
+
+	if _, ok := secret.Annotations[resource.SecretDataChecksum]; ok {
+		return false
+	}
+
+	secret.Annotations[resource.SecretDataChecksum] = resource.DataChecksum(secret.Data)
+	return true
+}
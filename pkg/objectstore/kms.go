@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	kmsService     = "kms"
+	kmsContentType = "application/x-amz-json-1.1"
+)
+
+// KMSClient encrypts and decrypts small payloads, e.g. data keys, with an AWS KMS key. It signs its requests
+// like the S3Store.
+type KMSClient struct {
+	// Endpoint defaults to the regional endpoint https://kms.<region>.amazonaws.com.
+	Endpoint    string
+	Region      string
+	Credentials Credentials
+	HTTPClient  *http.Client
+}
+
+// NewKMSClient returns a client of the regional KMS endpoint.
+func NewKMSClient(region string, creds Credentials) *KMSClient {
+	return &KMSClient{
+		Region:      region,
+		Credentials: creds,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type kmsEncryptRequest struct {
+	KeyID     string `json:"KeyId"`
+	Plaintext []byte `json:"Plaintext"`
+}
+
+type kmsEncryptResponse struct {
+	CiphertextBlob []byte `json:"CiphertextBlob"`
+}
+
+type kmsDecryptRequest struct {
+	CiphertextBlob []byte `json:"CiphertextBlob"`
+}
+
+type kmsDecryptResponse struct {
+	Plaintext []byte `json:"Plaintext"`
+}
+
+// Encrypt encrypts the plaintext, at most 4 KiB, with the key, which is a key id, ARN or alias.
+func (k *KMSClient) Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	var resp kmsEncryptResponse
+	if err := k.call(ctx, "Encrypt", kmsEncryptRequest{KeyID: keyID, Plaintext: plaintext}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// Decrypt decrypts a ciphertext returned by Encrypt, KMS finding the key in its metadata.
+func (k *KMSClient) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var resp kmsDecryptResponse
+	if err := k.call(ctx, "Decrypt", kmsDecryptRequest{CiphertextBlob: ciphertext}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (k *KMSClient) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint := k.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", k.Region)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kmsContentType)
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	signV4(req, hashHex(body), k.Credentials, k.Region, kmsService, time.Now())
+
+	client := k.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call KMS %s", action)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call KMS %s", action)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("failed to call KMS %s: %s: %s", action, resp.Status, data)
+	}
+
+	return errors.Wrapf(json.Unmarshal(data, out), "failed to decode KMS %s response", action)
+}
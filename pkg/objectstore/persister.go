@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+// NewPersister returns a persister which, after persisting a secret through next, also uploads
+// each of its keys to the store as <prefix>/<namespace>/<secret name>/<key>. Objects other
+// than secrets are only persisted through next.
+func NewPersister(store Store, prefix string, next kube.PersistFn) kube.PersistFn {
+	return func(ctx context.Context, cl client.Client, obj client.Object, f kube.MutateFn) (bool, error) {
+		upserted, err := next(ctx, cl, obj, f)
+		if err != nil {
+			return upserted, err
+		}
+
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			return upserted, nil
+		}
+
+		keys := make([]string, 0, len(secret.Data))
+		for k := range secret.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			objectKey := path.Join(prefix, secret.Namespace, secret.Name, k)
+			if err := store.Put(ctx, objectKey, secret.Data[k]); err != nil {
+				return upserted, errors.Wrapf(err, "failed to upload secret [%s] to object store", secret.Name)
+			}
+		}
+
+		logrus.Infof("Uploaded secret [%s] to object store", secret.Name)
+		return upserted, nil
+	}
+}
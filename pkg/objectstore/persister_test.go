@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/objectstore"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+type memoryStore map[string][]byte
+
+func (m memoryStore) Put(_ context.Context, key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func TestPersister(t *testing.T) {
+	ctx := context.TODO()
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	store := memoryStore{}
+
+	persist := objectstore.NewPersister(store, "bundles", kube.DefaultPersister)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-client-secret", Namespace: "test-namespace"},
+	}
+	upserted, err := persist(ctx, cl, secret, func() error {
+		secret.Data = map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, upserted)
+
+	assert.Equal(t, memoryStore{
+		"bundles/test-namespace/app-client-secret/tls.crt": []byte("cert"),
+		"bundles/test-namespace/app-client-secret/tls.key": []byte("key"),
+	}, store)
+}
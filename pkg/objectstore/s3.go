@@ -0,0 +1,292 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Store writes objects to an object storage bucket.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// ErrNotFound is returned by Get when the object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+const (
+	// DefaultEndpoint is the AWS S3 endpoint. Google Cloud Storage can be used through its
+	// S3 compatible XML API at https://storage.googleapis.com with HMAC keys.
+	DefaultEndpoint = "https://s3.amazonaws.com"
+
+	// DefaultSSE is the server side encryption requested for every object.
+	DefaultSSE = "AES256"
+
+	signAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+	s3Service     = "s3"
+
+	// DefaultContentType is the content type of the uploaded objects, the client certs.
+	DefaultContentType = "application/x-pem-file"
+)
+
+// Credentials are the access keys used to sign requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsFromEnv reads the credentials from the standard AWS_* envs.
+func CredentialsFromEnv() (Credentials, error) {
+	creds := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY envs are required")
+	}
+
+	return creds, nil
+}
+
+// S3Store is a Store backed by an S3 compatible bucket, addressed path-style.
+type S3Store struct {
+	Endpoint    string
+	Region      string
+	Bucket      string
+	Credentials Credentials
+	// SSE is the value of the x-amz-server-side-encryption header, e.g. AES256 or aws:kms.
+	// Server side encryption is not requested when empty.
+	SSE string
+	// SSEKMSKeyID is the KMS key used when SSE is aws:kms.
+	SSEKMSKeyID string
+	// ContentType of the uploaded objects, DefaultContentType when empty.
+	ContentType string
+	HTTPClient  *http.Client
+}
+
+// NewS3Store returns a store for the bucket which requests server side encryption with
+// S3 managed keys.
+func NewS3Store(endpoint, region, bucket string, creds Credentials) *S3Store {
+	return &S3Store{
+		Endpoint:    endpoint,
+		Region:      region,
+		Bucket:      bucket,
+		Credentials: creds,
+		SSE:         DefaultSSE,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Put uploads the object under key, replacing any existing object.
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	contentType := s.ContentType
+	if contentType == "" {
+		contentType = DefaultContentType
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.SSE != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.SSE)
+		if s.SSEKMSKeyID != "" {
+			req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.SSEKMSKeyID)
+		}
+	}
+
+	signV4(req, hashHex(data), s.Credentials, s.Region, s3Service, time.Now())
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload object [%s]", key)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("failed to upload object [%s]: %s: %s", key, resp.Status, body)
+	}
+
+	return nil
+}
+
+// Get downloads the object under key, returning ErrNotFound if it does not exist.
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	signV4(req, hashHex(nil), s.Credentials, s.Region, s3Service, time.Now())
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download object [%s]", key)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download object [%s]", key)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.Wrapf(ErrNotFound, "failed to download object [%s]", key)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Errorf("failed to download object [%s]: %s: %s", key, resp.Status, body)
+	}
+
+	return body, nil
+}
+
+func (s *S3Store) client() *http.Client {
+	if s.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return s.HTTPClient
+}
+
+func (s *S3Store) objectURL(key string) (string, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid object store endpoint %s", endpoint)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket + "/" + strings.TrimPrefix(key, "/")
+	return u.String(), nil
+}
+
+// signV4 signs the request to the AWS service with Signature Version 4, signing the host and every header set on it.
+func signV4(req *http.Request, payloadHash string, creds Credentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{signAlgorithm, amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := values[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+
+	return strings.Replace(strings.Join(parts, "&"), "+", "%20", -1)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oidc authenticates humans with an OpenID Connect provider, so that they can be issued client
+// certificates of their own SQL user. The device authorization grant (RFC 8628) obtains an ID token from a
+// terminal without a browser redirect, and the Verifier checks the ID tokens on the issuing side.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Provider is an OpenID Connect provider.
+type Provider struct {
+	Issuer     string       `json:"issuer"`
+	HTTPClient *http.Client `json:"-"`
+
+	// endpoints of the discovery document
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// Discover reads the discovery document of the issuer.
+func Discover(ctx context.Context, issuer string, httpClient *http.Client) (*Provider, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	p := &Provider{}
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, httpClient, wellKnown, p); err != nil {
+		return nil, errors.Wrapf(err, "failed to discover OIDC provider %s", issuer)
+	}
+
+	if strings.TrimSuffix(p.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, errors.Errorf("OIDC provider %s advertises the issuer %s", issuer, p.Issuer)
+	}
+	p.HTTPClient = httpClient
+	return p, nil
+}
+
+// DeviceAuthorization is the response of the device authorization endpoint.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// tokenResponse is the response of the token endpoint, successful or not.
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// DeviceFlow runs the device authorization grant of the client, and returns the ID token once the user
+// approved the request. prompt shows the user where to enter the user code.
+func (p *Provider) DeviceFlow(ctx context.Context, clientID string, scopes []string,
+	prompt func(auth *DeviceAuthorization)) (string, error) {
+
+	if p.DeviceAuthorizationEndpoint == "" {
+		return "", errors.Errorf("OIDC provider %s doesn't support the device flow", p.Issuer)
+	}
+
+	auth := &DeviceAuthorization{}
+	form := url.Values{"client_id": {clientID}, "scope": {strings.Join(append([]string{"openid"}, scopes...), " ")}}
+	if err := p.postForm(ctx, p.DeviceAuthorizationEndpoint, form, auth); err != nil {
+		return "", errors.Wrap(err, "failed to start the device flow")
+	}
+	prompt(auth)
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+
+	form = url.Values{
+		"client_id":   {clientID},
+		"device_code": {auth.DeviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+
+		token := &tokenResponse{}
+		if err := p.postForm(ctx, p.TokenEndpoint, form, token); err != nil {
+			return "", errors.Wrap(err, "failed to get the ID token")
+		}
+
+		switch token.Error {
+		case "":
+			if token.IDToken == "" {
+				return "", errors.New("the OIDC provider returned no ID token")
+			}
+			return token.IDToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return "", errors.Errorf("device flow failed: %s", token.Error)
+		}
+	}
+}
+
+// postForm posts the form and decodes the JSON response. Error responses of the token endpoint are decoded
+// too, as they carry the state of the device flow.
+func (p *Provider) postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func getJSON(ctx context.Context, httpClient *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
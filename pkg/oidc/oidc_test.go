@@ -0,0 +1,183 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/oidc"
+)
+
+// fakeProvider serves the discovery document, the keys and the device flow of an OIDC provider.
+type fakeProvider struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	pending int
+	idToken string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &fakeProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                        p.URL,
+			"device_authorization_endpoint": p.URL + "/device",
+			"token_endpoint":                p.URL + "/token",
+			"jwks_uri":                      p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":      "device",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": p.URL + "/activate",
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if p.pending > 0 {
+			p.pending--
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *fakeProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	p := newFakeProvider(t)
+	provider, err := oidc.Discover(context.Background(), p.URL, nil)
+	require.NoError(t, err)
+
+	v := &oidc.Verifier{Provider: provider, ClientID: "crdb"}
+	claims := func(iss, aud string, exp time.Duration) map[string]interface{} {
+		return map[string]interface{}{"iss": iss, "aud": aud, "exp": time.Now().Add(exp).Unix(), "email": "jane@example.com"}
+	}
+
+	got, err := v.Verify(context.Background(), p.sign(t, claims(p.URL, "crdb", time.Hour)))
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", got.String("email"))
+
+	for name, token := range map[string]string{
+		"other issuer":   p.sign(t, claims("https://other", "crdb", time.Hour)),
+		"other audience": p.sign(t, claims(p.URL, "other", time.Hour)),
+		"expired":        p.sign(t, claims(p.URL, "crdb", -time.Minute)),
+		"malformed":      "not-a-token",
+	} {
+		_, err := v.Verify(context.Background(), token)
+		assert.True(t, errors.Is(err, oidc.ErrInvalidToken), name)
+	}
+
+	// a token signed by another key
+	other := newFakeProvider(t)
+	_, err = v.Verify(context.Background(), other.sign(t, claims(p.URL, "crdb", time.Hour)))
+	assert.True(t, errors.Is(err, oidc.ErrInvalidToken))
+}
+
+func TestDeviceFlow(t *testing.T) {
+	p := newFakeProvider(t)
+	p.pending, p.idToken = 1, "token"
+
+	provider, err := oidc.Discover(context.Background(), p.URL, nil)
+	require.NoError(t, err)
+
+	var prompted *oidc.DeviceAuthorization
+	token, err := provider.DeviceFlow(context.Background(), "crdb", []string{"email"}, func(auth *oidc.DeviceAuthorization) {
+		prompted = auth
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "token", token)
+	require.NotNil(t, prompted)
+	assert.Equal(t, "ABCD-EFGH", prompted.UserCode)
+	assert.Zero(t, p.pending)
+}
+
+func TestUserMapping(t *testing.T) {
+	groupRoles, err := oidc.ParseGroupRoles([]string{"dba:admin", "eng:reader", "eng:writer", "ops:reader"})
+	require.NoError(t, err)
+
+	m := oidc.UserMapping{GroupRoles: groupRoles}
+	user, roles, err := m.Map(oidc.Claims{"email": "Jane.Doe+crdb@example.com", "groups": []interface{}{"eng", "ops"}})
+	require.NoError(t, err)
+	assert.Equal(t, "jane.doe_crdb", user)
+	assert.Equal(t, []string{"reader", "writer"}, roles)
+
+	_, _, err = m.Map(oidc.Claims{})
+	assert.Error(t, err)
+
+	_, err = oidc.ParseGroupRoles([]string{"eng"})
+	assert.Error(t, err)
+}
+
+func TestSQLUsername(t *testing.T) {
+	for name, want := range map[string]string{
+		"jane":             "jane",
+		"jane@example.com": "jane",
+		"John Smith":       "john_smith",
+		"1password":        "_1password",
+	} {
+		got, err := oidc.SQLUsername(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, name)
+	}
+}
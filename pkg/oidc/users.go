@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxUsernameLength is the maximum length of a SQL user name.
+const maxUsernameLength = 63
+
+// UserMapping maps the claims of a verified ID token to a SQL user and the SQL roles granted to it.
+type UserMapping struct {
+	// UsernameClaim is the claim the SQL user is derived from, email if empty. The domain of an email
+	// address is dropped.
+	UsernameClaim string
+	// GroupsClaim is the claim listing the groups of the user, groups if empty.
+	GroupsClaim string
+	// GroupRoles are the SQL roles granted to the members of each group.
+	GroupRoles map[string][]string
+}
+
+// Map returns the SQL user of the claims and its roles, sorted.
+func (m UserMapping) Map(claims Claims) (string, []string, error) {
+	claim := m.UsernameClaim
+	if claim == "" {
+		claim = "email"
+	}
+	user, err := SQLUsername(claims.String(claim))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "invalid %s claim", claim)
+	}
+
+	claim = m.GroupsClaim
+	if claim == "" {
+		claim = "groups"
+	}
+	granted := map[string]bool{}
+	for _, group := range claims.Strings(claim) {
+		for _, role := range m.GroupRoles[group] {
+			granted[role] = true
+		}
+	}
+
+	roles := make([]string, 0, len(granted))
+	for role := range granted {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	return user, roles, nil
+}
+
+// SQLUsername derives a valid SQL user name from a user name or email address: the domain is dropped, the
+// name is lower cased and the characters not allowed are replaced with underscores.
+func SQLUsername(name string) (string, error) {
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		return "", errors.New("empty user name")
+	}
+
+	b := []byte(strings.ToLower(name))
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			b[i] = '_'
+		}
+	}
+	if b[0] >= '0' && b[0] <= '9' || b[0] == '.' || b[0] == '-' {
+		b = append([]byte{'_'}, b...)
+	}
+	if len(b) > maxUsernameLength {
+		b = b[:maxUsernameLength]
+	}
+
+	return string(b), nil
+}
+
+// ParseGroupRoles parses the group:role pairs of the group to SQL role mapping.
+func ParseGroupRoles(pairs []string) (map[string][]string, error) {
+	groupRoles := map[string][]string{}
+	for _, pair := range pairs {
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, errors.Errorf("invalid group to role mapping %q, expected group:role", pair)
+		}
+		groupRoles[pair[:i]] = append(groupRoles[pair[:i]], pair[i+1:])
+	}
+	return groupRoles, nil
+}
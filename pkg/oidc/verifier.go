@@ -0,0 +1,197 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/jws"
+)
+
+// ErrInvalidToken is returned when an ID token can't be trusted.
+var ErrInvalidToken = errors.New("invalid ID token")
+
+// Claims are the claims of a verified ID token.
+type Claims map[string]interface{}
+
+// String returns the string claim, or an empty string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim holding a list of strings, such as the groups of the user.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Verifier verifies the ID tokens issued by the provider to the client.
+type Verifier struct {
+	Provider *Provider
+	ClientID string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// keysRefreshInterval is the minimum interval between two reads of the keys of the provider, so that
+// tokens of unknown keys don't make the verifier hammer it.
+const keysRefreshInterval = time.Minute
+
+// Verify checks the signature, the issuer, the audience and the expiry of the ID token, and returns its
+// claims. RS256 and ES256 signatures are supported.
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (Claims, error) {
+	token, err := jws.Parse(rawToken)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := token.Header(&header); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.Verify(header.Alg, key); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	claims := Claims{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	if claims.String("iss") != v.Provider.Issuer {
+		return nil, errors.Wrapf(ErrInvalidToken, "issued by %s", claims.String("iss"))
+	}
+	if !contains(claims.Strings("aud"), v.ClientID) {
+		return nil, errors.Wrapf(ErrInvalidToken, "not issued to %s", v.ClientID)
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, errors.Wrap(ErrInvalidToken, "expired")
+	}
+
+	return claims, nil
+}
+
+// key returns the key of the provider with the ID, reading the keys of the provider again if it is
+// unknown, as the provider may have rotated them.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok || time.Since(v.fetched) < keysRefreshInterval {
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidToken, "unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+
+	keys, err := v.Provider.keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.keys, v.fetched = keys, time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidToken, "unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// keys reads the JSON web key set of the provider.
+func (p *Provider) keys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, p.HTTPClient, p.JWKSURI, &set); err != nil {
+		return nil, errors.Wrap(err, "failed to get the keys of the OIDC provider")
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, e := decodeInt(k.N), decodeInt(k.E)
+			if n != nil && e != nil {
+				keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+			}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, y := decodeInt(k.X), decodeInt(k.Y)
+			if x != nil && y != nil {
+				keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+			}
+		}
+	}
+	return keys, nil
+}
+
+func decodeInt(s string) *big.Int {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(data)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+)
+
+// NewPersister returns a persister which, after persisting a secret through next, also hands it to the
+// plugin. Objects other than secrets are only persisted through next.
+func NewPersister(p *Plugin, next kube.PersistFn) kube.PersistFn {
+	return func(ctx context.Context, cl client.Client, obj client.Object, f kube.MutateFn) (bool, error) {
+		upserted, err := next(ctx, cl, obj, f)
+		if err != nil {
+			return upserted, err
+		}
+
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			return upserted, nil
+		}
+
+		return upserted, p.Persist(ctx, PersistRequest{
+			Namespace:   secret.Namespace,
+			Name:        secret.Name,
+			Annotations: secret.Annotations,
+			Data:        secret.Data,
+		})
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin runs exec plugins, executables adding proprietary signer and persister backends to the
+// self-signer without patching it, like the credential helpers of git and kubectl.
+//
+// Each call runs the plugin with a Request of APIVersion encoded in JSON on its stdin, and reads a Response
+// of the same kind encoded in JSON on its stdout. The plugin fails the call by setting the error of the
+// response, or by exiting with a non-zero status, in which case its stderr is reported. The plugin is
+// first run with a Handshake request, answered with the API version it speaks and its capabilities:
+//
+//	{"apiVersion": "selfsigner.cockroachlabs.com/v1", "kind": "Handshake"}
+//	{"apiVersion": "selfsigner.cockroachlabs.com/v1", "kind": "Handshake", "capabilities": ["sign", "persist"]}
+//
+// A plugin with the sign capability issues the certificates of Sign requests, with the CA bundle to trust
+// them. A plugin with the persist capability receives a Persist request with each secret written by the
+// self-signer, once it was written to Kubernetes.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// APIVersion is the version of the protocol spoken with the plugins.
+const APIVersion = "selfsigner.cockroachlabs.com/v1"
+
+// Kinds of the requests.
+const (
+	HandshakeKind = "Handshake"
+	SignKind      = "Sign"
+	PersistKind   = "Persist"
+)
+
+// Capabilities of the plugins.
+const (
+	SignCapability    = "sign"
+	PersistCapability = "persist"
+)
+
+const defaultTimeout = time.Minute
+
+// Request is the request written to the stdin of the plugin.
+type Request struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Sign       *SignRequest    `json:"sign,omitempty"`
+	Persist    *PersistRequest `json:"persist,omitempty"`
+}
+
+// SignRequest requests the certificate of a PEM certificate request, for the secret Name.
+type SignRequest struct {
+	Name       string   `json:"name"`
+	CSR        string   `json:"csr"`
+	CommonName string   `json:"commonName"`
+	Hosts      []string `json:"hosts,omitempty"`
+	// Node is set for node certificates, valid for server and client auth.
+	Node bool `json:"node"`
+	// Lifetime is the requested lifetime of the certificate, e.g. 8760h0m0s.
+	Lifetime string `json:"lifetime"`
+}
+
+// PersistRequest holds a secret written by the self-signer.
+type PersistRequest struct {
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Data holds the keys of the secret, base64 encoded in JSON like in Kubernetes.
+	Data map[string][]byte `json:"data"`
+}
+
+// Response is the response read from the stdout of the plugin.
+type Response struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Error      string `json:"error,omitempty"`
+	// Capabilities answers the Handshake.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Certificate and CA answer Sign requests, in PEM.
+	Certificate string `json:"certificate,omitempty"`
+	CA          string `json:"ca,omitempty"`
+}
+
+// Plugin is an exec plugin which completed the handshake.
+type Plugin struct {
+	Path string
+	Args []string
+	// Timeout bounds each call, a minute if zero.
+	Timeout time.Duration
+
+	capabilities []string
+}
+
+// New runs the handshake with the plugin at path, run with args.
+func New(ctx context.Context, path string, args ...string) (*Plugin, error) {
+	p := &Plugin{Path: path, Args: args}
+
+	var resp Response
+	if err := p.call(ctx, &Request{Kind: HandshakeKind}, &resp); err != nil {
+		return nil, errors.Wrapf(err, "handshake with plugin %s failed", path)
+	}
+	p.capabilities = resp.Capabilities
+	return p, nil
+}
+
+// Supports returns whether the plugin declared the capability in the handshake.
+func (p *Plugin) Supports(capability string) bool {
+	for _, c := range p.capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the PEM certificate issued by the plugin for the request, along with the CA bundle.
+func (p *Plugin) Sign(ctx context.Context, req SignRequest) (cert, ca []byte, err error) {
+	if !p.Supports(SignCapability) {
+		return nil, nil, errors.Errorf("plugin %s can't sign certificates", p.Path)
+	}
+
+	var resp Response
+	if err := p.call(ctx, &Request{Kind: SignKind, Sign: &req}, &resp); err != nil {
+		return nil, nil, errors.Wrapf(err, "plugin %s failed to sign the certificate of secret [%s]", p.Path, req.Name)
+	}
+	if resp.Certificate == "" || resp.CA == "" {
+		return nil, nil, errors.Errorf("plugin %s returned no certificate or CA for secret [%s]", p.Path, req.Name)
+	}
+	return []byte(resp.Certificate), []byte(resp.CA), nil
+}
+
+// Persist hands the secret to the plugin.
+func (p *Plugin) Persist(ctx context.Context, req PersistRequest) error {
+	if !p.Supports(PersistCapability) {
+		return errors.Errorf("plugin %s can't persist secrets", p.Path)
+	}
+
+	var resp Response
+	return errors.Wrapf(p.call(ctx, &Request{Kind: PersistKind, Persist: &req}, &resp),
+		"plugin %s failed to persist secret [%s]", p.Path, req.Name)
+}
+
+// call runs the plugin with the request, and decodes its response.
+func (p *Plugin) call(ctx context.Context, req *Request, resp *Response) error {
+	req.APIVersion = APIVersion
+	in, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, p.Args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "plugin failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return errors.Wrap(err, "invalid plugin response")
+	}
+	if resp.APIVersion != APIVersion {
+		return errors.Errorf("plugin speaks %s instead of %s", resp.APIVersion, APIVersion)
+	}
+	if resp.Kind != req.Kind {
+		return errors.Errorf("plugin answered %s to a %s request", resp.Kind, req.Kind)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// Handler answers the requests of a plugin written in Go. The capabilities of the plugin are the non-nil
+// funcs.
+type Handler struct {
+	Sign    func(SignRequest) (cert, ca []byte, err error)
+	Persist func(PersistRequest) error
+}
+
+// Serve answers the request read from in with the handler, writing the response to out. Plugins written in
+// Go call it with their stdin and stdout.
+func Serve(in io.Reader, out io.Writer, h Handler) error {
+	var req Request
+	if err := json.NewDecoder(in).Decode(&req); err != nil {
+		return errors.Wrap(err, "invalid request")
+	}
+	if req.APIVersion != APIVersion {
+		return errors.Errorf("unsupported API version %s", req.APIVersion)
+	}
+
+	resp := Response{APIVersion: APIVersion, Kind: req.Kind}
+	var err error
+	switch {
+	case req.Kind == HandshakeKind:
+		if h.Sign != nil {
+			resp.Capabilities = append(resp.Capabilities, SignCapability)
+		}
+		if h.Persist != nil {
+			resp.Capabilities = append(resp.Capabilities, PersistCapability)
+		}
+	case req.Kind == SignKind && h.Sign != nil && req.Sign != nil:
+		var cert, ca []byte
+		if cert, ca, err = h.Sign(*req.Sign); err == nil {
+			resp.Certificate, resp.CA = string(cert), string(ca)
+		}
+	case req.Kind == PersistKind && h.Persist != nil && req.Persist != nil:
+		err = h.Persist(*req.Persist)
+	default:
+		err = errors.Errorf("unsupported request %s", req.Kind)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	return json.NewEncoder(out).Encode(resp)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/plugin"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+// pluginEnv makes the test binary run as the plugin, persisting the secrets to the file it names, or
+// failing every request if it is set to fail.
+const pluginEnv = "SELF_SIGNER_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	out, ok := os.LookupEnv(pluginEnv)
+	if !ok {
+		os.Exit(m.Run())
+	}
+
+	if out == "fail" {
+		fmt.Fprintln(os.Stderr, "backend unavailable")
+		os.Exit(1)
+	}
+
+	err := plugin.Serve(os.Stdin, os.Stdout, plugin.Handler{
+		Sign: func(req plugin.SignRequest) ([]byte, []byte, error) {
+			if req.CommonName == "denied" {
+				return nil, nil, fmt.Errorf("user %s is not allowed", req.CommonName)
+			}
+			csr, err := security.ParseCSR([]byte(req.CSR))
+			if err != nil {
+				return nil, nil, err
+			}
+			caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+			if err != nil {
+				return nil, nil, err
+			}
+			lifetime, err := time.ParseDuration(req.Lifetime)
+			if err != nil {
+				return nil, nil, err
+			}
+			cert, err := security.SignCSR(csr, caCert, caKey, time.Now(), lifetime)
+			return cert, []byte(testcerts.CACert), err
+		},
+		Persist: func(req plugin.PersistRequest) error {
+			data, err := json.Marshal(req)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(out, data, 0600)
+		},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestPlugin(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "secret.json")
+	t.Setenv(pluginEnv, out)
+	ctx := context.TODO()
+
+	p, err := plugin.New(ctx, os.Args[0])
+	require.NoError(t, err)
+	assert.True(t, p.Supports(plugin.SignCapability))
+	assert.True(t, p.Supports(plugin.PersistCapability))
+
+	key, _, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	require.NoError(t, err)
+	csr, err := security.CreateCSR(key, security.NodeUser, []string{"crdb-0.crdb"})
+	require.NoError(t, err)
+
+	cert, ca, err := p.Sign(ctx, plugin.SignRequest{Name: "crdb-node-secret", CSR: string(csr),
+		CommonName: security.NodeUser, Node: true, Lifetime: "1h"})
+	require.NoError(t, err)
+	assert.Equal(t, testcerts.CACert, string(ca))
+	parsed, err := security.GetCertObj(cert)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"crdb-0.crdb"}, parsed.DNSNames)
+
+	// the plugin fails the request with the error of its response
+	_, _, err = p.Sign(ctx, plugin.SignRequest{Name: "denied-client-secret", CommonName: "denied"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "user denied is not allowed")
+
+	// only the secrets are handed to the plugin, once they were written
+	cl := testutils.NewFakeClient(testutils.InitScheme(t))
+	persist := plugin.NewPersister(p, kube.DefaultPersister)
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crdb-node-secret", Namespace: "ns"}}
+	_, err = persist(ctx, cl, secret, func() error {
+		secret.Data = map[string][]byte{"tls.crt": cert}
+		return nil
+	})
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	var persisted plugin.PersistRequest
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	assert.Equal(t, "ns", persisted.Namespace)
+	assert.Equal(t, cert, persisted.Data["tls.crt"])
+}
+
+func TestPluginFailure(t *testing.T) {
+	t.Setenv(pluginEnv, "fail")
+
+	_, err := plugin.New(context.TODO(), os.Args[0])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backend unavailable")
+}
+
+func TestServe(t *testing.T) {
+	in, err := json.Marshal(plugin.Request{APIVersion: plugin.APIVersion, Kind: plugin.HandshakeKind})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, plugin.Serve(bytes.NewReader(in), &out, plugin.Handler{Persist: func(plugin.PersistRequest) error { return nil }}))
+
+	var resp plugin.Response
+	require.NoError(t, json.Unmarshal(out.Bytes(), &resp))
+	assert.Equal(t, []string{plugin.PersistCapability}, resp.Capabilities)
+
+	// requests of other versions of the protocol are rejected
+	in, err = json.Marshal(plugin.Request{APIVersion: "selfsigner.cockroachlabs.com/v2", Kind: plugin.HandshakeKind})
+	require.NoError(t, err)
+	assert.Error(t, plugin.Serve(bytes.NewReader(in), &out, plugin.Handler{}))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Server evaluates the policy on an OPA server through its data API, so that the policy is managed
+// centrally.
+type Server struct {
+	// URL is the URL of the rule returning the violations, e.g. http://opa:8181/v1/data/selfsigner/deny.
+	URL    string
+	Client *http.Client
+}
+
+// Evaluate posts the input to the data API of the server.
+func (s *Server) Evaluate(ctx context.Context, input *Input) ([]string, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reach OPA")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("OPA answered %s", resp.Status)
+	}
+
+	// the result is undefined when no rule of the policy matches
+	var result struct {
+		Result []string `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "invalid OPA response")
+	}
+	return result.Result, nil
+}
+
+// CLI evaluates the policy files with the opa binary.
+type CLI struct {
+	// Binary is the path of the opa binary, opa if empty.
+	Binary string
+	Files  []string
+	// Query returns the violations, DefaultQuery if empty.
+	Query string
+}
+
+// Evaluate runs opa eval with the input on its stdin.
+func (c *CLI) Evaluate(ctx context.Context, input *Input) ([]string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	binary, query := c.Binary, c.Query
+	if binary == "" {
+		binary = "opa"
+	}
+	if query == "" {
+		query = DefaultQuery
+	}
+
+	args := []string{"eval", "--format", "json", "--stdin-input"}
+	for _, f := range c.Files {
+		args = append(args, "--data", f)
+	}
+	args = append(args, query)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "opa eval failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	// the result is empty when the query is undefined
+	var output struct {
+		Result []struct {
+			Expressions []struct {
+				Value []string `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, errors.Wrap(err, "invalid opa eval output")
+	}
+
+	var violations []string
+	for _, result := range output.Result {
+		for _, expression := range result.Expressions {
+			violations = append(violations, expression.Value...)
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy evaluates a Rego policy of the security team against each certificate before it is
+// persisted, so that the self-signer never stores a certificate violating the PKI policy.
+//
+// The policy is evaluated against an Input document, and returns the set of messages of the violations,
+// usually with deny rules:
+//
+//	package selfsigner
+//
+//	deny[msg] {
+//		not input.isCA
+//		input.durationSeconds > 90 * 24 * 3600
+//		msg := sprintf("%s is valid for more than 90 days", [input.secret])
+//	}
+package policy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// DefaultQuery is the Rego query returning the violations.
+const DefaultQuery = "data.selfsigner.deny"
+
+// Input holds the parameters of a certificate, as evaluated by the policy.
+type Input struct {
+	Namespace  string   `json:"namespace"`
+	Secret     string   `json:"secret"`
+	CommonName string   `json:"commonName"`
+	Hosts      []string `json:"hosts"`
+	IsCA       bool     `json:"isCA"`
+	NotBefore  string   `json:"notBefore"`
+	NotAfter   string   `json:"notAfter"`
+	// DurationSeconds is the validity of the certificate, from NotBefore to NotAfter.
+	DurationSeconds int64 `json:"durationSeconds"`
+	// KeyAlgorithm is one of RSA, ECDSA or Ed25519, and KeySize the size of the RSA key or of the curve.
+	KeyAlgorithm string `json:"keyAlgorithm"`
+	KeySize      int    `json:"keySize"`
+	// Inputs are the inputs the certificate was issued with, such as its configured duration.
+	Inputs map[string]string `json:"inputs,omitempty"`
+}
+
+// NewInput returns the input of the PEM certificate about to be stored in the secret.
+func NewInput(namespace, secret string, pemCert []byte, inputs map[string]string) (*Input, error) {
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &Input{
+		Namespace:       namespace,
+		Secret:          secret,
+		CommonName:      cert.Subject.CommonName,
+		Hosts:           append([]string{}, cert.DNSNames...),
+		IsCA:            cert.IsCA,
+		NotBefore:       cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:        cert.NotAfter.UTC().Format(time.RFC3339),
+		DurationSeconds: int64(cert.NotAfter.Sub(cert.NotBefore) / time.Second),
+		Inputs:          inputs,
+	}
+	for _, ip := range cert.IPAddresses {
+		input.Hosts = append(input.Hosts, ip.String())
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		input.KeyAlgorithm, input.KeySize = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		input.KeyAlgorithm, input.KeySize = "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		input.KeyAlgorithm, input.KeySize = "Ed25519", 256
+	}
+
+	return input, nil
+}
+
+// Evaluator evaluates the policy, returning the messages of the violations of the input.
+type Evaluator interface {
+	Evaluate(ctx context.Context, input *Input) ([]string, error)
+}
+
+// ViolationError is returned for a certificate violating the policy.
+type ViolationError struct {
+	Secret     string
+	Violations []string
+}
+
+func (e *ViolationError) Error() string {
+	return fmt.Sprintf("certificate of secret [%s] violates the PKI policy: %s", e.Secret, strings.Join(e.Violations, "; "))
+}
+
+// Check returns a ViolationError if the input violates the policy.
+func Check(ctx context.Context, e Evaluator, input *Input) error {
+	violations, err := e.Evaluate(ctx, input)
+	if err != nil {
+		return errors.Wrapf(err, "failed to evaluate the PKI policy for secret [%s]", input.Secret)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	sort.Strings(violations)
+	return &ViolationError{Secret: input.Secret, Violations: violations}
+}
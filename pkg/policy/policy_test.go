@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/policy"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+func TestNewInput(t *testing.T) {
+	input, err := policy.NewInput("ns", "crdb-node-secret", []byte(testcerts.NodeCert), map[string]string{"duration": "8760h0m0s"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "node", input.CommonName)
+	assert.False(t, input.IsCA)
+	assert.NotEmpty(t, input.Hosts)
+	assert.True(t, input.DurationSeconds > 0)
+	assert.NotEmpty(t, input.KeyAlgorithm)
+	assert.True(t, input.KeySize > 0)
+	assert.Equal(t, "8760h0m0s", input.Inputs["duration"])
+
+	ca, err := policy.NewInput("ns", "crdb-ca-secret", []byte(testcerts.CACert), nil)
+	require.NoError(t, err)
+	assert.True(t, ca.IsCA)
+}
+
+func TestServer(t *testing.T) {
+	violations := `{"result": ["crdb-node-secret is valid for more than 90 days"]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/data/selfsigner/deny", r.URL.Path)
+
+		var body struct {
+			Input policy.Input `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "crdb-node-secret", body.Input.Secret)
+		_, _ = w.Write([]byte(violations))
+	}))
+	defer srv.Close()
+
+	server := &policy.Server{URL: srv.URL + "/v1/data/selfsigner/deny"}
+	input := &policy.Input{Secret: "crdb-node-secret"}
+
+	err := policy.Check(context.TODO(), server, input)
+	var violation *policy.ViolationError
+	require.True(t, errors.As(err, &violation))
+	assert.Equal(t, []string{"crdb-node-secret is valid for more than 90 days"}, violation.Violations)
+
+	// the result is undefined when no rule matches
+	violations = `{}`
+	assert.NoError(t, policy.Check(context.TODO(), server, input))
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+
+	// the fake opa binary records its arguments and the input, and denies keys smaller than 2048 bits
+	opa := filepath.Join(dir, "opa")
+	script := `#!/bin/sh
+echo "$@" > ` + args + `
+if grep -q '"keySize":1024' ; then
+  echo '{"result": [{"expressions": [{"value": ["key of crdb-client-secret is too small"]}]}]}'
+else
+  echo '{}'
+fi
+`
+	require.NoError(t, ioutil.WriteFile(opa, []byte(script), 0700))
+
+	cli := &policy.CLI{Binary: opa, Files: []string{"pki.rego"}}
+	violations, err := cli.Evaluate(context.TODO(), &policy.Input{Secret: "crdb-client-secret", KeySize: 1024})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key of crdb-client-secret is too small"}, violations)
+
+	data, err := ioutil.ReadFile(args)
+	require.NoError(t, err)
+	assert.Equal(t, "eval --format json --stdin-input --data pki.rego data.selfsigner.deny\n", string(data))
+
+	violations, err = cli.Evaluate(context.TODO(), &policy.Input{Secret: "crdb-client-secret", KeySize: 2048})
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+
+	cli.Binary = filepath.Join(dir, "missing")
+	_, err = cli.Evaluate(context.TODO(), &policy.Input{})
+	assert.Error(t, err)
+}
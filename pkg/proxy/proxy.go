@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proxy builds the HTTP clients of the outbound integrations, such as the ACME CA, the object store
+// and the telemetry endpoint, which go through the egress proxy of the cluster.
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Direct is the proxy override disabling the proxy of an integration.
+const Direct = "direct"
+
+// Func returns the proxy function of an integration. Without an override, the proxy is read from the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY envs. The override is either Direct or the URL of the proxy to use
+// instead of the envs, and NO_PROXY still applies to it.
+func Func(override string) (func(*http.Request) (*url.URL, error), error) {
+	switch override {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case Direct:
+		return nil, nil
+	}
+
+	if _, err := url.Parse(override); err != nil {
+		return nil, errors.Wrapf(err, "invalid proxy URL %s", override)
+	}
+
+	config := &httpproxy.Config{
+		HTTPProxy:  override,
+		HTTPSProxy: override,
+		NoProxy:    getEnvAny("NO_PROXY", "no_proxy"),
+	}
+	proxy := config.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// NewClient returns an HTTP client with the timeout, going through the proxy of the override as done by Func.
+func NewClient(override string, timeout time.Duration) (*http.Client, error) {
+	proxy, err := Func(override)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy_test
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/proxy"
+)
+
+func TestFunc(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://acme.example.com/directory", nil)
+	require.NoError(t, err)
+
+	t.Run("direct", func(t *testing.T) {
+		f, err := proxy.Func(proxy.Direct)
+		require.NoError(t, err)
+		require.Nil(t, f)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		f, err := proxy.Func("http://proxy.example.com:3128")
+		require.NoError(t, err)
+
+		u, err := f(req)
+		require.NoError(t, err)
+		require.Equal(t, "proxy.example.com:3128", u.Host)
+	})
+
+	t.Run("override honors NO_PROXY", func(t *testing.T) {
+		defer os.Setenv("NO_PROXY", os.Getenv("NO_PROXY"))
+		require.NoError(t, os.Setenv("NO_PROXY", ".example.com"))
+
+		f, err := proxy.Func("http://proxy.example.com:3128")
+		require.NoError(t, err)
+
+		u, err := f(req)
+		require.NoError(t, err)
+		require.Nil(t, u)
+	})
+
+	t.Run("invalid override", func(t *testing.T) {
+		_, err := proxy.Func("http://proxy.example.com:port")
+		require.Error(t, err)
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := proxy.NewClient(proxy.Direct, 30*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, c.Timeout)
+	require.Nil(t, c.Transport.(*http.Transport).Proxy)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redact keeps the secret data, the paths of the keys and the tokens handled by the self-signer out
+// of its logs, whatever the log level. A Redactor applies regular expression filters to the logrus entries
+// through a Hook, and to the output of the standard logger through a Writer.
+package redact
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Placeholder replaces the redacted text.
+const Placeholder = "[REDACTED]"
+
+// Filter replaces the matches of Pattern with Replacement, which may refer to the submatches, e.g. $1.
+type Filter struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultFilters redact the PEM blocks, including lone headers of truncated blocks, the paths of private
+// keys, bearer tokens, JWTs, and the values of token and password parameters, e.g. token=value or
+// "password": "value", but not error messages such as "password: connection refused".
+var DefaultFilters = []Filter{
+	{Name: "pem", Pattern: regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]+-----.*?(-----END [A-Z0-9 ]+-----|$)`), Replacement: Placeholder},
+	{Name: "pem-header", Pattern: regexp.MustCompile(`-----(BEGIN|END) [A-Z0-9 ]+-----`), Replacement: Placeholder},
+	{Name: "key-path", Pattern: regexp.MustCompile(`[\w.~-]*(/[\w.~-]+)*/[\w.-]+\.key\b`), Replacement: Placeholder},
+	{Name: "bearer", Pattern: regexp.MustCompile(`(?i)\b(bearer\s+)[\w.~+/-]+=*`), Replacement: "${1}" + Placeholder},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[\w-]+\.[\w-]+\.[\w-]*`), Replacement: Placeholder},
+	{Name: "parameter", Pattern: regexp.MustCompile(`(?i)\b((?:access_|refresh_|id_)?token|password|passwd|secret_key|client_secret)(["']?(?:\s*=\s*["']?|:\s*["']|:))[^\s"'&,]+`), Replacement: "${1}${2}" + Placeholder},
+}
+
+// Redactor applies its filters to the text to log.
+type Redactor struct {
+	filters []Filter
+}
+
+// New returns a Redactor applying the DefaultFilters, then the patterns, whose matches are replaced with
+// the Placeholder.
+func New(patterns ...string) (*Redactor, error) {
+	filters := append([]Filter{}, DefaultFilters...)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid redaction pattern %q", p)
+		}
+		filters = append(filters, Filter{Name: p, Pattern: re, Replacement: Placeholder})
+	}
+
+	return &Redactor{filters: filters}, nil
+}
+
+// Redact returns the text with the matches of the filters replaced.
+func (r *Redactor) Redact(text string) string {
+	for _, f := range r.filters {
+		text = f.Pattern.ReplaceAllString(text, f.Replacement)
+	}
+	return text
+}
+
+// Hook returns a logrus hook redacting the message and the fields of the entries of every level.
+func (r *Redactor) Hook() logrus.Hook {
+	return &hook{redactor: r}
+}
+
+// Install redacts the entries of the logger.
+func (r *Redactor) Install(logger *logrus.Logger) {
+	logger.AddHook(r.Hook())
+}
+
+// Writer returns a writer redacting each write before passing it to w, e.g. the output of the standard
+// logger, which writes each entry at once.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &writer{redactor: r, out: w}
+}
+
+type hook struct {
+	redactor *Redactor
+}
+
+func (h *hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts the entry in place. The fields which are not strings are redacted in their string form, so
+// that errors and other values carrying secret data are covered as well.
+func (h *hook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.redactor.Redact(entry.Message)
+
+	fields := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case nil, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+			fields[key] = v
+		case string:
+			fields[key] = h.redactor.Redact(v)
+		case []byte:
+			fields[key] = h.redactor.Redact(string(v))
+		default:
+			fields[key] = h.redactor.Redact(fmt.Sprint(v))
+		}
+	}
+	entry.Data = fields
+
+	return nil
+}
+
+type writer struct {
+	redactor *Redactor
+	out      io.Writer
+}
+
+// Write reports the whole input as written, as the redacted text written to out has another length.
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, w.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redact_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/redact"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := redact.New(`crdb-secret-\d+`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		text     string
+		expected string
+	}{
+		{"key " + strings.TrimSpace(testcerts.NodeKey) + " loaded", "key [REDACTED] loaded"},
+		{"truncated " + testcerts.CACert[:40], "truncated [REDACTED]"},
+		{"unable to read /certs/ca.key: not found", "unable to read [REDACTED]: not found"},
+		{"--ca-key=certs/ca.key", "--ca-key=[REDACTED]"},
+		{"unable to read ca.key", "unable to read ca.key"},
+		{"Authorization: Bearer abc.def-123", "Authorization: Bearer [REDACTED]"},
+		{"id eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJ4In0.c2ln", "id [REDACTED]"},
+		{"https://host/login?token=s3cr3t&x=1", "https://host/login?token=[REDACTED]&x=1"},
+		{`{"password": "hunter2"}`, `{"password": "[REDACTED]"}`},
+		{"failed to set the root password: connection refused", "failed to set the root password: connection refused"},
+		{"saved in secret [crdb-node-secret]", "saved in secret [crdb-node-secret]"},
+		{"copied crdb-secret-42", "copied [REDACTED]"},
+	} {
+		assert.Equal(t, tc.expected, r.Redact(tc.text), tc.text)
+	}
+
+	_, err = redact.New("(")
+	assert.Error(t, err)
+}
+
+func TestHook(t *testing.T) {
+	r, err := redact.New()
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.TraceLevel)
+	r.Install(logger)
+
+	// secret data is redacted at every level, in the message and the fields
+	logger.WithField("cert", []byte(testcerts.NodeCert)).Debugf("loaded key %s", testcerts.NodeKey)
+	logger.WithError(errors.New("invalid "+strings.TrimSpace(testcerts.CAKey))).WithField("count", 2).Trace("failed")
+	logger.WithField("path", "/tmp/certs/client.root.key").Info("token=abc")
+
+	assert.NotContains(t, out.String(), "-----BEGIN")
+	assert.NotContains(t, out.String(), "PRIVATE KEY")
+	assert.NotContains(t, out.String(), "client.root.key")
+	assert.NotContains(t, out.String(), "abc")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "invalid [REDACTED]", entry["error"])
+	assert.Equal(t, float64(2), entry["count"])
+}
+
+func TestWriter(t *testing.T) {
+	r, err := redact.New()
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	logger := log.New(r.Writer(&out), "", 0)
+	logger.Printf("loaded %s from /certs/node.key", strings.TrimSpace(testcerts.NodeCert))
+
+	assert.Equal(t, "loaded [REDACTED] from [REDACTED]\n", out.String())
+}
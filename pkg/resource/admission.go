@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// addAdmissionAnnotations adds the annotations admission policies (e.g. Kyverno or Gatekeeper) rely on to
+// block the pods mounting expired or unmanaged certificate secrets: the fingerprint of the issuing CA, the
+// expiry of the certificate and the version of the PKI policy. The expiry is the CertValidUpto annotation of
+// the certificate info, which is only added here if it wasn't given. The certificate annotations are skipped
+// if the data holds no parsable certificate.
+func (s *TLSSecret) addAdmissionAnnotations(data map[string][]byte, annotations map[string]string) {
+	if s.policyVersion != "" {
+		annotations[PolicyVersion] = s.policyVersion
+	}
+
+	// invalid data is left to the validation of the secret, the annotations are only informative
+	cas, _ := security.ParseCerts(data[CaCert])
+	chain, _ := security.ParseCerts(data[corev1.TLSCertKey])
+
+	var cert, issuer *x509.Certificate
+	if len(chain) > 0 {
+		cert = chain[0]
+		issuer = findIssuer(cert, append(cas, chain[1:]...))
+	} else if len(cas) > 0 {
+		// the CA secrets hold the CA certificate issuing the certificates of the other secrets
+		cert, issuer = cas[0], cas[0]
+	}
+
+	if cert == nil {
+		return
+	}
+
+	if _, ok := annotations[CertValidUpto]; !ok {
+		annotations[CertValidUpto] = cert.NotAfter.UTC().Format(time.RFC3339)
+		annotations[CertValidUptoUnix] = strconv.FormatInt(cert.NotAfter.Unix(), 10)
+	}
+	if issuer != nil {
+		sum := sha256.Sum256(issuer.Raw)
+		annotations[IssuerFingerprint] = hex.EncodeToString(sum[:])
+	}
+}
+
+// findIssuer returns the certificate among candidates that signed cert, nil if none did.
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestAdmissionAnnotations(t *testing.T) {
+	ctx := context.TODO()
+	r := resource.NewKubeResource(ctx, testutils.NewFakeClient(testutils.InitScheme(t)), "test-namespace",
+		kube.DefaultPersister)
+
+	caFingerprint, err := security.Fingerprint([]byte(testcerts.CACert))
+	require.NoError(t, err)
+	node, err := security.GetCertObj([]byte(testcerts.NodeCert))
+	require.NoError(t, err)
+	ca, err := security.GetCertObj([]byte(testcerts.CACert))
+	require.NoError(t, err)
+
+	// the node certificate is issued by the CA found in the bundle
+	secret := resource.CreateTLSSecret("node-secret", corev1.SecretTypeTLS, r)
+	secret.SetPolicyVersion("v3")
+	require.NoError(t, secret.UpdateTLSSecret([]byte(testcerts.NodeCert), []byte(testcerts.NodeKey),
+		[]byte(testcerts.OtherCACert+testcerts.CACert), map[string]string{}))
+
+	secret, err = resource.LoadTLSSecret("node-secret", r)
+	require.NoError(t, err)
+	assert.Equal(t, resource.ManagedByValue, secret.Secret().Labels[resource.ManagedByLabel])
+	assert.Equal(t, caFingerprint, secret.Secret().Annotations[resource.IssuerFingerprint])
+	assert.Equal(t, node.NotAfter.UTC().Format(time.RFC3339), secret.Secret().Annotations[resource.CertValidUpto])
+	assert.Equal(t, strconv.FormatInt(node.NotAfter.Unix(), 10), secret.Secret().Annotations[resource.CertValidUptoUnix])
+	assert.Equal(t, "v3", secret.Secret().Annotations[resource.PolicyVersion])
+
+	// the CA secret is annotated with the fingerprint of the CA itself
+	secret = resource.CreateTLSSecret("ca-secret", corev1.SecretTypeOpaque, r)
+	require.NoError(t, secret.UpdateCASecret([]byte(testcerts.CAKey), []byte(testcerts.CACert), map[string]string{}))
+
+	secret, err = resource.LoadTLSSecret("ca-secret", r)
+	require.NoError(t, err)
+	assert.Equal(t, resource.ManagedByValue, secret.Secret().Labels[resource.ManagedByLabel])
+	assert.Equal(t, caFingerprint, secret.Secret().Annotations[resource.IssuerFingerprint])
+	assert.Equal(t, ca.NotAfter.UTC().Format(time.RFC3339), secret.Secret().Annotations[resource.CertValidUpto])
+	assert.NotContains(t, secret.Secret().Annotations, resource.PolicyVersion)
+
+	// the expiry of the certificate info is kept
+	secret = resource.CreateTLSSecret("annotated-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, secret.UpdateTLSSecret([]byte(testcerts.NodeCert), []byte(testcerts.NodeKey),
+		[]byte(testcerts.CACert), resource.GetSecretAnnotations("", "2030-01-01T00:00:00Z", "", "")))
+
+	secret, err = resource.LoadTLSSecret("annotated-secret", r)
+	require.NoError(t, err)
+	assert.Equal(t, "2030-01-01T00:00:00Z", secret.Secret().Annotations[resource.CertValidUpto])
+	assert.Equal(t, "1893456000", secret.Secret().Annotations[resource.CertValidUptoUnix])
+
+	// the certificate annotations are skipped when the data can't be parsed
+	secret = resource.CreateTLSSecret("invalid-secret", corev1.SecretTypeTLS, r)
+	require.NoError(t, secret.UpdateTLSSecret([]byte("cert"), []byte("key"), []byte("ca"), map[string]string{}))
+
+	secret, err = resource.LoadTLSSecret("invalid-secret", r)
+	require.NoError(t, err)
+	assert.Equal(t, resource.ManagedByValue, secret.Secret().Labels[resource.ManagedByLabel])
+	assert.NotContains(t, secret.Secret().Annotations, resource.IssuerFingerprint)
+	assert.NotContains(t, secret.Secret().Annotations, resource.CertValidUpto)
+}
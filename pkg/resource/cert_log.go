@@ -0,0 +1,280 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrCertLogTampered is returned when the entries of the certificate log don't form an unbroken hash chain.
+var ErrCertLogTampered = errors.New("certificate log was tampered with")
+
+const (
+	// CertLogEntriesKey is the key of the entries in the ConfigMaps of the certificate log, as JSON lines.
+	CertLogEntriesKey = "entries"
+	// CertLogSegmentsKey is the key of the number of sealed segments in the head ConfigMap.
+	CertLogSegmentsKey = "segments"
+	// DefaultCertLogSegmentSize is the default number of entries per ConfigMap of the certificate log.
+	DefaultCertLogSegmentSize = 500
+)
+
+// CertLogEntry is the record of a certificate in the certificate log. Each entry holds the hash of the previous
+// one, so that removing or editing an entry breaks the chain.
+type CertLogEntry struct {
+	Index       int       `json:"index"`
+	Time        time.Time `json:"time"`
+	Operation   string    `json:"operation"`
+	Secret      string    `json:"secret"`
+	SHA256      string    `json:"sha256"`
+	Serial      string    `json:"serial"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	PrevHash    string    `json:"prevHash,omitempty"`
+	Hash        string    `json:"hash"`
+}
+
+// digest returns the hash of the entry, computed over its JSON without the hash itself.
+func (e CertLogEntry) digest() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CertLog is an append-only log of the issued certificates. The latest entries are kept in the head ConfigMap
+// and, once it holds SegmentSize entries, they are sealed into the immutable <name>-<n> ConfigMaps, so that
+// the log isn't bounded by the size of a ConfigMap.
+type CertLog struct {
+	Resource
+
+	name        string
+	segmentSize int
+	head        *corev1.ConfigMap
+}
+
+// LoadCertLog fetches the head ConfigMap of the log. A missing ConfigMap is treated as an empty log.
+func LoadCertLog(name string, segmentSize int, r Resource) (*CertLog, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultCertLogSegmentSize
+	}
+
+	l := &CertLog{
+		Resource:    r,
+		name:        name,
+		segmentSize: segmentSize,
+		head: &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+		},
+	}
+
+	if err := l.Fetch(l.head); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Append sets the index and hashes of the entry, chaining it to the last entry, and appends it to the log.
+func (l *CertLog) Append(e CertLogEntry) (CertLogEntry, error) {
+	entries, err := parseCertLogEntries(l.head.Data[CertLogEntriesKey])
+	if err != nil {
+		return e, errors.Wrapf(err, "invalid head of certificate log [%s]", l.name)
+	}
+	segments, err := l.segments()
+	if err != nil {
+		return e, err
+	}
+
+	e.Index = segments * l.segmentSize
+	e.PrevHash = ""
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		e.Index = last.Index + 1
+		e.PrevHash = last.Hash
+	}
+	if e.Hash, err = e.digest(); err != nil {
+		return e, err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+
+	// the full head is sealed before the entry is added, so that the head always holds the last entry
+	sealed := len(entries) >= l.segmentSize
+	if sealed {
+		if err := l.seal(segments, l.head.Data[CertLogEntriesKey]); err != nil {
+			return e, err
+		}
+	}
+
+	_, err = l.Persist(l.head, func() error {
+		if l.head.Data == nil || sealed {
+			l.head.Data = map[string]string{}
+		}
+		if sealed {
+			segments++
+		}
+
+		l.head.Data[CertLogSegmentsKey] = strconv.Itoa(segments)
+		l.head.Data[CertLogEntriesKey] += string(line) + "\n"
+		return nil
+	})
+
+	return e, errors.Wrapf(err, "failed to append to certificate log [%s]", l.name)
+}
+
+// Entries returns the entries of the sealed segments and of the head, in order.
+func (l *CertLog) Entries() ([]CertLogEntry, error) {
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CertLogEntry
+	for n := 0; n < segments; n++ {
+		segment := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: l.segmentName(n),
+			},
+		}
+		if err := l.Fetch(segment); err != nil {
+			return nil, errors.Wrapf(err, "failed to get segment [%s] of certificate log", segment.Name)
+		}
+
+		parsed, err := parseCertLogEntries(segment.Data[CertLogEntriesKey])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid segment [%s] of certificate log", segment.Name)
+		}
+		entries = append(entries, parsed...)
+	}
+
+	parsed, err := parseCertLogEntries(l.head.Data[CertLogEntriesKey])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid head of certificate log [%s]", l.name)
+	}
+
+	return append(entries, parsed...), nil
+}
+
+// VerifyCertLog checks that the entries are numbered from 0 and that each of them holds its own hash and the
+// hash of the previous entry. It fails with ErrCertLogTampered otherwise.
+func VerifyCertLog(entries []CertLogEntry) error {
+	prev := ""
+	for i, e := range entries {
+		if e.Index != i {
+			return errors.Wrapf(ErrCertLogTampered, "entry %d has index %d", i, e.Index)
+		}
+		if e.PrevHash != prev {
+			return errors.Wrapf(ErrCertLogTampered, "entry %d doesn't chain to the previous entry", i)
+		}
+
+		digest, err := e.digest()
+		if err != nil {
+			return err
+		}
+		if digest != e.Hash {
+			return errors.Wrapf(ErrCertLogTampered, "entry %d doesn't match its hash", i)
+		}
+		prev = e.Hash
+	}
+
+	return nil
+}
+
+func (l *CertLog) segments() (int, error) {
+	value, ok := l.head.Data[CertLogSegmentsKey]
+	if !ok {
+		return 0, nil
+	}
+
+	segments, err := strconv.Atoi(value)
+	if err != nil || segments < 0 {
+		return 0, errors.Errorf("invalid number of segments %q in certificate log [%s]", value, l.name)
+	}
+	return segments, nil
+}
+
+func (l *CertLog) segmentName(n int) string {
+	return fmt.Sprintf("%s-%d", l.name, n)
+}
+
+// seal writes the entries to the immutable ConfigMap of segment n. A segment sealed by an earlier attempt
+// which failed to update the head is kept as is.
+func (l *CertLog) seal(n int, entries string) error {
+	immutable := true
+	segment := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: l.segmentName(n),
+		},
+	}
+
+	err := l.Fetch(segment)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get segment [%s] of certificate log", segment.Name)
+	}
+
+	_, err = l.Persist(segment, func() error {
+		segment.Immutable = &immutable
+		segment.Data = map[string]string{CertLogEntriesKey: entries}
+		return nil
+	})
+	return errors.Wrapf(err, "failed to seal segment [%s] of certificate log", segment.Name)
+}
+
+func parseCertLogEntries(data string) ([]CertLogEntry, error) {
+	var entries []CertLogEntry
+	for _, line := range bytes.Split([]byte(data), []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var e CertLogEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestCertLog(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+
+	certLog, err := resource.LoadCertLog("crdb-cert-log", 2, r)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		e, err := certLog.Append(resource.CertLogEntry{
+			Time:      now,
+			Operation: "issue",
+			Secret:    fmt.Sprintf("crdb-client-%d", i),
+			SHA256:    fmt.Sprintf("%064x", i),
+			NotAfter:  now.Add(time.Hour),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, i, e.Index)
+	}
+
+	// the entries are read back across the sealed segments from a fresh load
+	certLog, err = resource.LoadCertLog("crdb-cert-log", 2, r)
+	require.NoError(t, err)
+	entries, err := certLog.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	assert.Equal(t, "crdb-client-4", entries[4].Secret)
+	assert.Equal(t, entries[3].Hash, entries[4].PrevHash)
+	require.NoError(t, resource.VerifyCertLog(entries))
+
+	segment := &corev1.ConfigMap{}
+	require.NoError(t, fakeClient.Get(ctx, types.NamespacedName{Namespace: "test-namespace", Name: "crdb-cert-log-1"}, segment))
+	require.NotNil(t, segment.Immutable)
+	assert.True(t, *segment.Immutable)
+
+	// editing, removing or reordering an entry breaks the chain
+	edited := append([]resource.CertLogEntry(nil), entries...)
+	edited[2].Secret = "crdb-other"
+	assert.True(t, errors.Is(resource.VerifyCertLog(edited), resource.ErrCertLogTampered))
+
+	removed := append(append([]resource.CertLogEntry(nil), entries[:2]...), entries[3:]...)
+	assert.True(t, errors.Is(resource.VerifyCertLog(removed), resource.ErrCertLogTampered))
+
+	assert.True(t, errors.Is(resource.VerifyCertLog(entries[1:]), resource.ErrCertLogTampered))
+}
@@ -24,7 +24,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func Clean(ctx context.Context, cl client.Client, namespace string, stsName string) {
+// Clean deletes the CA, node and client secrets of the statefulset and returns the names of the deleted secrets.
+func Clean(ctx context.Context, cl client.Client, namespace string, stsName string) (deleted []string) {
 
 	secrets := []string{stsName + "-ca-secret", stsName + "-node-secret", stsName + "-client-secret"}
 	var failed bool
@@ -33,18 +34,28 @@ func Clean(ctx context.Context, cl client.Client, namespace string, stsName stri
 	for i := range secrets {
 		secret.SetName(secrets[i])
 		secret.SetNamespace(namespace)
-		if err := cl.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+		if _, err := Unprotect(ctx, cl, namespace, secrets[i]); err != nil {
+			logrus.Errorf("Failed to delete secret %s: error %s", secret.GetName(), err.Error())
+			failed = true
+			continue
+		}
+		if err := cl.Delete(ctx, secret); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
 			logrus.Errorf("Failed to delete secret %s: error %s", secret.GetName(), err.Error())
 			failed = true
 			// if error occurs, continue and try to clean as much as possible
 			continue
 		}
+		deleted = append(deleted, secrets[i])
 	}
 
 	if failed {
 		logrus.Warning("Not able to clean up some resources")
-		return
+		return deleted
 	}
 
 	logrus.Info("Successfully cleaned up dangling resources")
+	return deleted
 }
@@ -44,7 +44,8 @@ func TestClean(t *testing.T) {
 	otherSecret := secretObj(other, namespace, nil, nil)
 	fakeClient := testutils.NewFakeClient(scheme, caSecret, nodeSecret, clientSecret, otherSecret)
 
-	resource.Clean(ctx, fakeClient, namespace, stsName)
+	deleted := resource.Clean(ctx, fakeClient, namespace, stsName)
+	assert.Equal(t, []string{ca, node, client}, deleted)
 
 	r := resource.NewKubeResource(ctx, fakeClient, namespace, kube.DefaultPersister)
 
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// States of the run reported in the completion ConfigMap.
+const (
+	// CompletionPending is reported while a run is generating the certificates.
+	CompletionPending = "Pending"
+	// CompletionComplete is reported once a run issued all the certificates.
+	CompletionComplete = "Complete"
+	// CompletionFailed is reported when a run failed, along with the error.
+	CompletionFailed = "Failed"
+)
+
+// Keys of the completion ConfigMap.
+const (
+	CompletionStateKey    = "state"
+	CompletionRevisionKey = "revision"
+	CompletionUpdatedKey  = "updatedAt"
+	CompletionMessageKey  = "message"
+)
+
+// ErrNotComplete is returned while the completion ConfigMap doesn't report a completed run.
+var ErrNotComplete = errors.New("certificate generation not complete")
+
+// Completion is a ConfigMap reporting the state of the last run generating the certificates, so that the
+// pods mounting them can wait for it to complete instead of relying on the order of the hooks.
+type Completion struct {
+	Resource
+
+	configMap *corev1.ConfigMap
+}
+
+// LoadCompletion fetches the ConfigMap. A missing ConfigMap reports no state.
+func LoadCompletion(name string, r Resource) (*Completion, error) {
+	c := &Completion{
+		Resource: r,
+		configMap: &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+		},
+	}
+
+	if err := c.Fetch(c.configMap); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// State returns the state of the last run, empty if no run reported it yet.
+func (c *Completion) State() string {
+	return c.configMap.Data[CompletionStateKey]
+}
+
+// Revision returns the revision of the release the last run was part of.
+func (c *Completion) Revision() string {
+	return c.configMap.Data[CompletionRevisionKey]
+}
+
+// Message returns the error of the last run, if it failed.
+func (c *Completion) Message() string {
+	return c.configMap.Data[CompletionMessageKey]
+}
+
+// Set reports the state of the run of the release revision at the given time. The message is only kept
+// for failed runs.
+func (c *Completion) Set(state, revision, message string, at time.Time) error {
+	_, err := c.Persist(c.configMap, func() error {
+		c.configMap.Data = map[string]string{
+			CompletionStateKey:   state,
+			CompletionUpdatedKey: at.UTC().Format(time.RFC3339),
+		}
+		if revision != "" {
+			c.configMap.Data[CompletionRevisionKey] = revision
+		}
+		if state == CompletionFailed {
+			c.configMap.Data[CompletionMessageKey] = message
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Complete returns nil when the last run completed, and was part of the release revision if not empty, or an
+// error wrapping ErrNotComplete describing its state.
+func (c *Completion) Complete(revision string) error {
+	switch state := c.State(); state {
+	case CompletionComplete:
+		if revision != "" && c.Revision() != revision {
+			return errors.Wrapf(ErrNotComplete, "%s reports a completed run of revision %q, not %q", c.configMap.Name,
+				c.Revision(), revision)
+		}
+		return nil
+	case "":
+		return errors.Wrapf(ErrNotComplete, "%s reports no run", c.configMap.Name)
+	case CompletionFailed:
+		return errors.Wrapf(ErrNotComplete, "%s reports a failed run: %s", c.configMap.Name, c.Message())
+	default:
+		return errors.Wrapf(ErrNotComplete, "%s reports a %s run", c.configMap.Name, state)
+	}
+}
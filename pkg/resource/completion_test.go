@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestCompletion(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+	at := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	completion, err := resource.LoadCompletion("crdb-certs-completion", r)
+	require.NoError(t, err)
+	assert.Equal(t, "", completion.State())
+	assert.True(t, errors.Is(completion.Complete(""), resource.ErrNotComplete))
+
+	require.NoError(t, completion.Set(resource.CompletionPending, "2", "", at))
+	assert.True(t, errors.Is(completion.Complete(""), resource.ErrNotComplete))
+
+	require.NoError(t, completion.Set(resource.CompletionFailed, "2", "failed to generate CA", at))
+	completion, err = resource.LoadCompletion("crdb-certs-completion", r)
+	require.NoError(t, err)
+	assert.Equal(t, resource.CompletionFailed, completion.State())
+	assert.Equal(t, "2", completion.Revision())
+	assert.Equal(t, "failed to generate CA", completion.Message())
+	assert.Contains(t, completion.Complete("").Error(), "failed to generate CA")
+
+	require.NoError(t, completion.Set(resource.CompletionComplete, "3", "ignored", at))
+	completion, err = resource.LoadCompletion("crdb-certs-completion", r)
+	require.NoError(t, err)
+	assert.NoError(t, completion.Complete(""))
+	assert.NoError(t, completion.Complete("3"))
+	assert.Equal(t, "3", completion.Revision())
+	assert.Equal(t, "", completion.Message())
+
+	// a completed run of another revision doesn't complete the current one
+	assert.True(t, errors.Is(completion.Complete("4"), resource.ErrNotComplete))
+}
+
+func TestWaitForCompletion(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+
+	assert.Error(t, resource.WaitForCompletion(r, "crdb-certs-completion", "", time.Second, 100*time.Millisecond))
+
+	completion, err := resource.LoadCompletion("crdb-certs-completion", r)
+	require.NoError(t, err)
+	require.NoError(t, completion.Set(resource.CompletionComplete, "1", "", time.Now()))
+	assert.NoError(t, resource.WaitForCompletion(r, "crdb-certs-completion", "", time.Second, 100*time.Millisecond))
+	assert.NoError(t, resource.WaitForCompletion(r, "crdb-certs-completion", "1", time.Second, 100*time.Millisecond))
+	assert.Error(t, resource.WaitForCompletion(r, "crdb-certs-completion", "2", time.Second, 100*time.Millisecond))
+}
@@ -0,0 +1,21 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resource reads and writes the secrets holding the certificates. TLSSecret wraps a secret with the
+// annotations recording the lifetime of its certificate, which decide when it has to be rotated, and the
+// hash of its data, which detects changes made outside the self-signer. A Resource binds the reads and
+// writes to a namespace and the context of the operation.
+package resource
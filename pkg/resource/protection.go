@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// DeletionProtection is the finalizer set on the secrets written by the self-signer with --protect-secrets, so
+// that deleting a secret by accident leaves it terminating, with its certificate intact, until the finalizer is
+// removed.
+const DeletionProtection = "crdb.io/deletion-protection"
+
+// Unprotect removes the DeletionProtection finalizer from the secret, so that it can be deleted, and returns
+// true if the secret was protected. A missing secret is ignored.
+func Unprotect(ctx context.Context, cl client.Client, namespace, name string) (bool, error) {
+	secret := &corev1.Secret{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	if !controllerutil.ContainsFinalizer(secret, DeletionProtection) {
+		return false, nil
+	}
+
+	controllerutil.RemoveFinalizer(secret, DeletionProtection)
+	if err := cl.Update(ctx, secret); err != nil {
+		return false, errors.Wrapf(client.IgnoreNotFound(err), "failed to remove the deletion protection of secret [%s]", name)
+	}
+
+	return true, nil
+}
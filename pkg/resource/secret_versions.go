@@ -0,0 +1,155 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var versionSuffix = regexp.MustCompile(`^(.*)-v(\d+)$`)
+
+// retiredSuffix is the suffix of the ConfigMap keys recording when a previous version of a secret was replaced.
+const retiredSuffix = ".retired-at"
+
+// SecretVersions is a ConfigMap pointing the name of each secret to the secret holding its current
+// version. It is used for immutable secrets, where each rotation writes a new secret.
+type SecretVersions struct {
+	Resource
+
+	configMap *corev1.ConfigMap
+}
+
+// LoadSecretVersions fetches the ConfigMap. A missing ConfigMap is treated as empty.
+func LoadSecretVersions(name string, r Resource) (*SecretVersions, error) {
+	v := &SecretVersions{
+		Resource: r,
+		configMap: &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+		},
+	}
+
+	if err := v.Fetch(v.configMap); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Current returns the name of the secret holding the current version of the secret, which is the
+// secret name itself until it is first rotated.
+func (v *SecretVersions) Current(name string) string {
+	if current, ok := v.configMap.Data[name]; ok {
+		return current
+	}
+
+	return name
+}
+
+// Set points the secret name to the secret holding its current version.
+func (v *SecretVersions) Set(name, current string) error {
+	_, err := v.Persist(v.configMap, func() error {
+		if v.configMap.Data == nil {
+			v.configMap.Data = map[string]string{}
+		}
+		v.configMap.Data[name] = current
+
+		return nil
+	})
+
+	return err
+}
+
+// Supersede points the secret name to current, recording when the version it pointed to before was
+// replaced, so that it can be kept for a grace period and deleted afterwards.
+func (v *SecretVersions) Supersede(name, current string, at time.Time) error {
+	previous := v.Current(name)
+
+	_, err := v.Persist(v.configMap, func() error {
+		if v.configMap.Data == nil {
+			v.configMap.Data = map[string]string{}
+		}
+		v.configMap.Data[name] = current
+		if previous != current {
+			v.configMap.Data[previous+retiredSuffix] = at.UTC().Format(time.RFC3339)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Retired returns the previous versions of the secrets along with the time they were replaced. Secrets
+// which were made current again, e.g. by a rollback, are not returned.
+func (v *SecretVersions) Retired() map[string]time.Time {
+	current := map[string]bool{}
+	for key, value := range v.configMap.Data {
+		if !strings.HasSuffix(key, retiredSuffix) {
+			current[value] = true
+		}
+	}
+
+	retired := map[string]time.Time{}
+	for key, value := range v.configMap.Data {
+		name := strings.TrimSuffix(key, retiredSuffix)
+		if name == key || current[name] {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			continue
+		}
+		retired[name] = at
+	}
+
+	return retired
+}
+
+// Forget removes the records of the retired secrets, once they are deleted.
+func (v *SecretVersions) Forget(names ...string) error {
+	_, err := v.Persist(v.configMap, func() error {
+		for _, name := range names {
+			delete(v.configMap.Data, name+retiredSuffix)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// NextSecretVersion returns the name of the next version of the secret: crdb-node-secret is followed
+// by crdb-node-secret-v2, crdb-node-secret-v2 by crdb-node-secret-v3 and so on.
+func NextSecretVersion(name string) string {
+	if m := versionSuffix.FindStringSubmatch(name); m != nil {
+		version, _ := strconv.Atoi(m[2])
+		return fmt.Sprintf("%s-v%d", m[1], version+1)
+	}
+
+	return name + "-v2"
+}
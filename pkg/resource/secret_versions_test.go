@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestNextSecretVersion(t *testing.T) {
+	assert.Equal(t, "crdb-node-secret-v2", resource.NextSecretVersion("crdb-node-secret"))
+	assert.Equal(t, "crdb-node-secret-v3", resource.NextSecretVersion("crdb-node-secret-v2"))
+	assert.Equal(t, "crdb-node-secret-v10", resource.NextSecretVersion("crdb-node-secret-v9"))
+}
+
+func TestSecretVersions(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+
+	versions, err := resource.LoadSecretVersions("crdb-secret-versions", r)
+	require.NoError(t, err)
+	assert.Equal(t, "crdb-node-secret", versions.Current("crdb-node-secret"))
+
+	require.NoError(t, versions.Set("crdb-node-secret", "crdb-node-secret-v2"))
+	require.NoError(t, versions.Set("crdb-client-secret", "crdb-client-secret-v4"))
+
+	versions, err = resource.LoadSecretVersions("crdb-secret-versions", r)
+	require.NoError(t, err)
+	assert.Equal(t, "crdb-node-secret-v2", versions.Current("crdb-node-secret"))
+	assert.Equal(t, "crdb-client-secret-v4", versions.Current("crdb-client-secret"))
+}
+
+func TestSecretVersionsSupersede(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+	at := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	versions, err := resource.LoadSecretVersions("crdb-secret-versions", r)
+	require.NoError(t, err)
+	require.NoError(t, versions.Supersede("crdb-node-secret", "crdb-node-secret", at))
+	assert.Empty(t, versions.Retired())
+
+	require.NoError(t, versions.Supersede("crdb-node-secret", "crdb-node-secret-v2", at))
+	require.NoError(t, versions.Supersede("crdb-node-secret", "crdb-node-secret-v3", at.Add(time.Hour)))
+
+	versions, err = resource.LoadSecretVersions("crdb-secret-versions", r)
+	require.NoError(t, err)
+	assert.Equal(t, "crdb-node-secret-v3", versions.Current("crdb-node-secret"))
+	assert.Equal(t, map[string]time.Time{
+		"crdb-node-secret":    at,
+		"crdb-node-secret-v2": at.Add(time.Hour),
+	}, versions.Retired())
+
+	// a secret made current again by a rollback is not retired
+	require.NoError(t, versions.Set("crdb-node-secret", "crdb-node-secret-v2"))
+	assert.NotContains(t, versions.Retired(), "crdb-node-secret-v2")
+
+	require.NoError(t, versions.Forget("crdb-node-secret"))
+	assert.NotContains(t, versions.Retired(), "crdb-node-secret")
+}
+
+func TestUpdateImmutableTLSSecret(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+
+	secret := resource.CreateTLSSecret("crdb-node-secret-v2", corev1.SecretTypeTLS, r)
+	secret.SetImmutable()
+	require.NoError(t, secret.UpdateTLSSecret([]byte("cert"), []byte("key"), []byte("ca"), map[string]string{}))
+
+	loaded, err := resource.LoadTLSSecret("crdb-node-secret-v2", r)
+	require.NoError(t, err)
+	require.NotNil(t, loaded.Secret().Immutable)
+	assert.True(t, *loaded.Secret().Immutable)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrDuplicateSerial is returned when a serial number is registered twice.
+var ErrDuplicateSerial = errors.New("serial number already issued")
+
+// IssuedSerial is the record of a certificate issued by the self-signer.
+type IssuedSerial struct {
+	Secret     string     `json:"secret"`
+	CommonName string     `json:"commonName"`
+	IssuedAt   time.Time  `json:"issuedAt"`
+	NotAfter   time.Time  `json:"notAfter"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+// SerialRegistry is a ConfigMap recording the serial number of each issued certificate, keyed by the
+// hexadecimal serial, so that serial numbers are unique for the lifetime of the CA and certificates can be
+// revoked by serial.
+type SerialRegistry struct {
+	Resource
+
+	configMap *corev1.ConfigMap
+}
+
+// LoadSerialRegistry fetches the ConfigMap. A missing ConfigMap is treated as empty.
+func LoadSerialRegistry(name string, r Resource) (*SerialRegistry, error) {
+	s := &SerialRegistry{
+		Resource: r,
+		configMap: &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+		},
+	}
+
+	if err := s.Fetch(s.configMap); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SerialKey returns the key of the serial number in the registry.
+func SerialKey(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// ParseSerial parses a hex serial number, as printed by openssl with or without colons.
+func ParseSerial(s string) (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(s), "0x"), ":", ""), 16)
+	if !ok {
+		return nil, errors.Errorf("invalid serial number %q", s)
+	}
+	return serial, nil
+}
+
+// Lookup returns the record of the serial number, if it was issued.
+func (s *SerialRegistry) Lookup(serial *big.Int) (IssuedSerial, bool) {
+	var issued IssuedSerial
+	value, ok := s.configMap.Data[SerialKey(serial)]
+	if !ok || json.Unmarshal([]byte(value), &issued) != nil {
+		return issued, false
+	}
+	return issued, true
+}
+
+// Register records the serial number of a new certificate. It fails with ErrDuplicateSerial if the serial
+// number was already issued.
+func (s *SerialRegistry) Register(serial *big.Int, issued IssuedSerial) error {
+	return s.update(func(data map[string]string) error {
+		key := SerialKey(serial)
+		if _, ok := data[key]; ok {
+			return errors.Wrapf(ErrDuplicateSerial, "serial %s", key)
+		}
+
+		return setSerial(data, key, issued)
+	})
+}
+
+// Revoke marks the certificate of the serial number as revoked.
+func (s *SerialRegistry) Revoke(serial *big.Int, reason string, at time.Time) error {
+	return s.update(func(data map[string]string) error {
+		key := SerialKey(serial)
+
+		var issued IssuedSerial
+		value, ok := data[key]
+		if !ok {
+			return fmt.Errorf("serial %s wasn't issued", key)
+		}
+		if err := json.Unmarshal([]byte(value), &issued); err != nil {
+			return errors.Wrapf(err, "invalid record of serial %s", key)
+		}
+
+		if issued.RevokedAt == nil {
+			at = at.UTC()
+			issued.RevokedAt = &at
+			issued.Reason = reason
+		}
+
+		return setSerial(data, key, issued)
+	})
+}
+
+// Revoked returns the records of the revoked certificates which haven't expired, keyed by serial.
+func (s *SerialRegistry) Revoked(now time.Time) map[string]IssuedSerial {
+	revoked := map[string]IssuedSerial{}
+	for key, value := range s.configMap.Data {
+		var issued IssuedSerial
+		if json.Unmarshal([]byte(value), &issued) != nil {
+			continue
+		}
+
+		if issued.RevokedAt != nil && now.Before(issued.NotAfter) {
+			revoked[key] = issued
+		}
+	}
+
+	return revoked
+}
+
+// Prune removes the records of the certificates which expired before the given time. Certificates don't
+// outlive the CA which issued them, so the records older than the lifetime of the CA can't collide with the
+// serial numbers it issues.
+func (s *SerialRegistry) Prune(before time.Time) error {
+	var expired []string
+	for key, value := range s.configMap.Data {
+		var issued IssuedSerial
+		if json.Unmarshal([]byte(value), &issued) == nil && issued.NotAfter.Before(before) {
+			expired = append(expired, key)
+		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	return s.update(func(data map[string]string) error {
+		for _, key := range expired {
+			delete(data, key)
+		}
+		return nil
+	})
+}
+
+func (s *SerialRegistry) update(f func(data map[string]string) error) error {
+	_, err := s.Persist(s.configMap, func() error {
+		if s.configMap.Data == nil {
+			s.configMap.Data = map[string]string{}
+		}
+		return f(s.configMap.Data)
+	})
+
+	return err
+}
+
+func setSerial(data map[string]string, key string, issued IssuedSerial) error {
+	value, err := json.Marshal(issued)
+	if err != nil {
+		return err
+	}
+
+	data[key] = string(value)
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestSerialRegistry(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+
+	now := time.Now()
+	registry, err := resource.LoadSerialRegistry("crdb-issued-serials", r)
+	require.NoError(t, err)
+
+	node := resource.IssuedSerial{Secret: "crdb-node-secret", CommonName: "node", IssuedAt: now, NotAfter: now.Add(time.Hour)}
+	client := resource.IssuedSerial{Secret: "crdb-client-secret", CommonName: "root", IssuedAt: now, NotAfter: now.Add(-time.Hour)}
+	require.NoError(t, registry.Register(big.NewInt(0xabc), node))
+	require.NoError(t, registry.Register(big.NewInt(0xdef), client))
+
+	err = registry.Register(big.NewInt(0xabc), client)
+	assert.True(t, errors.Is(err, resource.ErrDuplicateSerial))
+
+	require.NoError(t, registry.Revoke(big.NewInt(0xabc), "key compromise", now))
+	assert.Error(t, registry.Revoke(big.NewInt(0x123), "", now))
+
+	registry, err = resource.LoadSerialRegistry("crdb-issued-serials", r)
+	require.NoError(t, err)
+
+	issued, ok := registry.Lookup(big.NewInt(0xabc))
+	require.True(t, ok)
+	assert.Equal(t, "crdb-node-secret", issued.Secret)
+	require.NotNil(t, issued.RevokedAt)
+	assert.Equal(t, "key compromise", issued.Reason)
+	assert.Equal(t, []string{"abc"}, keys(registry.Revoked(now)))
+
+	// the expired client certificate is pruned
+	require.NoError(t, registry.Prune(now))
+	_, ok = registry.Lookup(big.NewInt(0xdef))
+	assert.False(t, ok)
+	_, ok = registry.Lookup(big.NewInt(0xabc))
+	assert.True(t, ok)
+}
+
+func keys(m map[string]resource.IssuedSerial) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestParseSerial(t *testing.T) {
+	serial, err := resource.ParseSerial("4F:1C:0A")
+	require.NoError(t, err)
+	assert.Equal(t, "4f1c0a", resource.SerialKey(serial))
+
+	serial, err = resource.ParseSerial("0x4f1c0a")
+	require.NoError(t, err)
+	assert.Equal(t, "4f1c0a", resource.SerialKey(serial))
+
+	_, err = resource.ParseSerial("not-a-serial")
+	assert.Error(t, err)
+}
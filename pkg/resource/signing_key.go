@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"crypto"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// LoadSigningKey returns the signing key kept in the secret along with its certificate for the common name,
+// so that the receipts and attestations are never signed with the CA key. The ECDSA key is generated on first
+// use, and its certificate issued again with issue once it no longer verifies against the PEM encoded CA
+// bundle at now, e.g. because it expired or the CA was rotated.
+func LoadSigningKey(name string, r Resource, caBundle []byte, commonName string, now time.Time,
+	issue func(key crypto.Signer) ([]byte, error)) (*x509.Certificate, crypto.Signer, error) {
+
+	secret, err := LoadTLSSecret(name, r)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get signing key secret [%s]", name)
+	}
+
+	var key crypto.Signer
+	pemKey := secret.TLSPrivateKey()
+	if len(pemKey) > 0 {
+		if key, err = security.ParsePrivateKey(pemKey); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to parse the key of signing key secret [%s]", name)
+		}
+
+		cert, err := security.GetCertObj(secret.TLSCert())
+		if err == nil && security.VerifySigningCert(cert, caBundle, commonName, now) == nil {
+			return cert, key, nil
+		}
+	} else if key, pemKey, err = security.GenerateSigningKey(); err != nil {
+		return nil, nil, err
+	}
+
+	pemCert, err := issue(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret.ExpectResourceVersion(secret.Secret().ResourceVersion)
+	if err := secret.UpdateTLSSecret(pemCert, pemKey, caBundle, map[string]string{}); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to update signing key secret [%s]", name)
+	}
+
+	logrus.Infof("Issued the certificate of the signing key in secret [%s]", name)
+	return cert, key, nil
+}
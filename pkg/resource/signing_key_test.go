@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestLoadSigningKey(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+	issued := 0
+	issue := func(caCert *x509.Certificate, caKey crypto.Signer) func(key crypto.Signer) ([]byte, error) {
+		return func(key crypto.Signer) ([]byte, error) {
+			issued++
+			return security.CreateSigningCert(caCert, caKey, key, "signer", time.Now(), time.Hour)
+		}
+	}
+
+	// the key is generated and certified on first use, then reused
+	cert, key, err := resource.LoadSigningKey("signing-key", r, []byte(testcerts.CACert), "signer", time.Now(),
+		issue(caCert, caKey))
+	require.NoError(t, err)
+	assert.Equal(t, "signer", cert.Subject.CommonName)
+	assert.Equal(t, cert.PublicKey, key.Public())
+
+	reused, reusedKey, err := resource.LoadSigningKey("signing-key", r, []byte(testcerts.CACert), "signer", time.Now(),
+		issue(caCert, caKey))
+	require.NoError(t, err)
+	assert.Equal(t, cert.Raw, reused.Raw)
+	assert.Equal(t, key, reusedKey)
+	assert.Equal(t, 1, issued)
+
+	// the certificate is issued again, for the same key, once the CA was rotated
+	newCAKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Cockroach CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, newCAKey.Public(), newCAKey)
+	require.NoError(t, err)
+	newCACert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	rotated, rotatedKey, err := resource.LoadSigningKey("signing-key", r,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), "signer", time.Now(), issue(newCACert, newCAKey))
+	require.NoError(t, err)
+	assert.Equal(t, 2, issued)
+	assert.Equal(t, key, rotatedKey)
+	require.NoError(t, rotated.CheckSignatureFrom(newCACert))
+}
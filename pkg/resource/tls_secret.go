@@ -17,13 +17,20 @@ limitations under the License.
 package resource
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/mitchellh/hashstructure/v2"
+	"github.com/pkg/errors"
 	"github.com/robfig/cron"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cockroachdb/helm-charts/pkg/version"
 )
 
 const (
@@ -33,6 +40,42 @@ const (
 	CertValidUpto  = "certificate-valid-upto"
 	CertDuration   = "certificate-duration"
 	SecretDataHash = "secret-data-hash"
+	KeyAlgorithm   = "certificate-key-algorithm"
+
+	CertValidFromUnix = "certificate-valid-from-unix"
+	CertValidUptoUnix = "certificate-valid-upto-unix"
+	RotationDueAt     = "rotation-due-at"
+	RotationDueAtUnix = "rotation-due-at-unix"
+
+	SecretDataChecksum = "secret-data-checksum"
+	Attestation        = "certificate-attestation"
+	// AttestationRef is the reference, by digest, of the OCI artifact of the certificate the attestation was
+	// published with.
+	AttestationRef = "certificate-attestation-ref"
+
+	// ManagedByVersion is the version of the self-signer that last wrote the secret.
+	ManagedByVersion = "managed-by-version"
+
+	// RotateRequested requests the rotation of the certificate of the secret when set to "true". It is
+	// cleared by the rotation, which replaces the annotations of the secret.
+	RotateRequested = "crdb.io/rotate"
+	// Paused freezes the certificate of the secret, or of all the secrets of the namespace, when set to
+	// "true" on the secret or the namespace, e.g. during a maintenance window.
+	Paused = "crdb.io/paused"
+
+	// IssuerFingerprint is the hex encoded SHA-256 fingerprint of the CA certificate that issued the
+	// certificate of the secret, or of the CA certificate itself for CA secrets.
+	IssuerFingerprint = "crdb.io/issuer-fingerprint"
+	// PolicyVersion is the version of the PKI policy the certificate of the secret was issued under.
+	PolicyVersion = "crdb.io/policy-version"
+
+	// ManagedByLabel is set to ManagedByValue on every secret written by the self-signer, so that
+	// admission policies can select the certificate secrets it manages.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "cockroachdb-self-signer"
+	// StatefulSetLabel is set to the name of the StatefulSet of the release on every secret written by the
+	// self-signer, so that the secrets left behind by a deleted release can be garbage collected.
+	StatefulSetLabel = "crdb.io/statefulset"
 )
 
 // CreateTLSSecret returns a TLSSecret struct that is used to store the certs via secrets.
@@ -73,10 +116,60 @@ func LoadTLSSecret(name string, r Resource) (*TLSSecret, error) {
 	return s, err
 }
 
+// NewTLSSecret returns a TLSSecret struct of a secret already read from the API server.
+func NewTLSSecret(secret *corev1.Secret, r Resource) *TLSSecret {
+	s := &TLSSecret{
+		Resource: r,
+		secret:   secret.DeepCopy(),
+	}
+
+	if s.secret.Data == nil {
+		s.secret.Data = map[string][]byte{}
+	}
+
+	return s
+}
+
+// ErrModified is returned when updating a secret which was modified since the resource version
+// set with ExpectResourceVersion.
+var ErrModified = errors.New("secret was modified concurrently")
+
 type TLSSecret struct {
 	Resource
 
-	secret *corev1.Secret
+	secret          *corev1.Secret
+	expectedVersion *string
+	immutable       bool
+	policyVersion   string
+}
+
+// SetImmutable makes the updates of the secret mark it as immutable. Immutable secrets can't be
+// updated afterwards, a new secret has to be written instead.
+func (s *TLSSecret) SetImmutable() {
+	s.immutable = true
+}
+
+// SetPolicyVersion makes the updates of the secret record the version of the PKI policy the certificate was
+// issued under in the PolicyVersion annotation. It is not recorded if empty.
+func (s *TLSSecret) SetPolicyVersion(version string) {
+	s.policyVersion = version
+}
+
+// ExpectResourceVersion makes the updates of the secret fail with ErrModified unless the secret is still
+// at the given resource version, or still doesn't exist if the version is empty. It is used to not
+// overwrite the certificates written by a concurrent run since the secret was loaded.
+func (s *TLSSecret) ExpectResourceVersion(version string) {
+	s.expectedVersion = &version
+}
+
+// checkResourceVersion checks the resource version of the secret read before mutating it.
+func (s *TLSSecret) checkResourceVersion() error {
+	if s.expectedVersion == nil || s.secret.ResourceVersion == *s.expectedVersion {
+		return nil
+	}
+
+	return errors.Wrapf(ErrModified, "secret [%s] is at version %q instead of %q", s.secret.Name,
+		s.secret.ResourceVersion, *s.expectedVersion)
 }
 
 // ReadyCA checks if the CA secret contains required data
@@ -123,8 +216,22 @@ func (s *TLSSecret) ValidateAnnotations() bool {
 
 // IsRotationRequired validates if all the required annotations are present
 func (s *TLSSecret) IsRotationRequired(duration time.Duration, cronStr string) (bool, string) {
+	return s.IsRotationRequiredAt(duration, cronStr, time.Now())
+}
+
+// IsRotationRequiredAt is IsRotationRequired evaluated at the time now, which is checked against the
+// expiry of the certificate and the next run of the cron.
+func (s *TLSSecret) IsRotationRequiredAt(duration time.Duration, cronStr string, now time.Time) (bool, string) {
 	annotations := s.secret.Annotations
 
+	if s.Paused() {
+		return false, ""
+	}
+
+	if s.RotationRequested() {
+		return true, "Rotation requested by annotation, rotating certificate"
+	}
+
 	// validate secret data hash
 	hash, err := hashstructure.Hash(s.secret.Data, hashstructure.FormatV2, nil)
 	if err != nil {
@@ -156,7 +263,7 @@ func (s *TLSSecret) IsRotationRequired(duration time.Duration, cronStr string) (
 		return true, "Failed to verify expiry date due to invalid cron, rotating certificate"
 	}
 
-	nextRun := cronSchedule.Next(time.Now())
+	nextRun := cronSchedule.Next(now)
 
 	if expiryTime.Before(nextRun) {
 		return true, "Certificate about to expire, rotating certificate"
@@ -166,6 +273,38 @@ func (s *TLSSecret) IsRotationRequired(duration time.Duration, cronStr string) (
 
 }
 
+// Paused returns true if the Paused annotation of the secret is set to "true".
+func (s *TLSSecret) Paused() bool {
+	return s.secret.Annotations[Paused] == "true"
+}
+
+// RotationRequested returns true if the RotateRequested annotation of the secret is set to "true".
+func (s *TLSSecret) RotationRequested() bool {
+	return s.secret.Annotations[RotateRequested] == "true"
+}
+
+// IsRenewalDue returns true once the given fraction of the lifetime of the certificate elapsed, e.g. 0.5 to
+// renew it at half its lifetime.
+func (s *TLSSecret) IsRenewalDue(ratio float64, now time.Time) (bool, string) {
+	annotations := s.secret.Annotations
+
+	validFrom, err := time.Parse(time.RFC3339, annotations[CertValidFrom])
+	if err != nil {
+		return true, "Failed to verify start date, renewing certificate"
+	}
+	validUpto, err := time.Parse(time.RFC3339, annotations[CertValidUpto])
+	if err != nil {
+		return true, "Failed to verify expiry date, renewing certificate"
+	}
+
+	renewAt := validFrom.Add(time.Duration(ratio * float64(validUpto.Sub(validFrom))))
+	if now.Before(renewAt) {
+		return false, ""
+	}
+
+	return true, fmt.Sprintf("Certificate past %.0f%% of its lifetime, renewing certificate", ratio*100)
+}
+
 // Ready checks if secret contains required data
 func (s *TLSSecret) Ready() bool {
 	data := s.secret.Data
@@ -192,16 +331,31 @@ func (s *TLSSecret) UpdateTLSSecret(cert, key, ca []byte, annotations map[string
 	data := map[string][]byte{corev1.TLSCertKey: newCert, CaCert: newCA, corev1.TLSPrivateKeyKey: newKey}
 
 	// create hash of the new data
-	hash, err := hashstructure.Hash(data, hashstructure.FormatV2, nil)
+	hash, err := DataHash(data)
 	if err != nil {
 		return err
 	}
 
-	annotations[SecretDataHash] = fmt.Sprintf("%d", hash)
+	annotations[SecretDataHash] = hash
+	annotations[SecretDataChecksum] = DataChecksum(data)
+	annotations[ManagedByVersion] = version.Version
+	s.addAdmissionAnnotations(data, annotations)
 
 	_, err = s.Persist(s.secret, func() error {
+		if err := s.checkResourceVersion(); err != nil {
+			return err
+		}
+
 		s.secret.Data = data
 		s.secret.Annotations = annotations
+		if s.secret.Labels == nil {
+			s.secret.Labels = map[string]string{}
+		}
+		s.secret.Labels[ManagedByLabel] = ManagedByValue
+		if s.immutable {
+			immutable := true
+			s.secret.Immutable = &immutable
+		}
 
 		return nil
 	})
@@ -213,19 +367,44 @@ func (s *TLSSecret) UpdateTLSSecret(cert, key, ca []byte, annotations map[string
 func (s *TLSSecret) UpdateCASecret(cakey []byte, caCert []byte, annotations map[string]string) error {
 	newCAKey := append([]byte{}, cakey...)
 	newCACert := append([]byte{}, caCert...)
-	data := map[string][]byte{CaKey: newCAKey, CaCert: newCACert}
 
+	return s.updateData(map[string][]byte{CaKey: newCAKey, CaCert: newCACert}, annotations)
+}
+
+// UpdateCACertSecret updates the CA Cert only, for the CA secret mounted by the pods when the CA key is
+// stored in another secret.
+func (s *TLSSecret) UpdateCACertSecret(caCert []byte, annotations map[string]string) error {
+	return s.updateData(map[string][]byte{CaCert: append([]byte{}, caCert...)}, annotations)
+}
+
+// updateData replaces the data of the secret, annotated with its hash and checksum.
+func (s *TLSSecret) updateData(data map[string][]byte, annotations map[string]string) error {
 	// create hash of the new data
-	hash, err := hashstructure.Hash(data, hashstructure.FormatV2, nil)
+	hash, err := DataHash(data)
 	if err != nil {
 		return err
 	}
 
-	annotations[SecretDataHash] = fmt.Sprintf("%d", hash)
+	annotations[SecretDataHash] = hash
+	annotations[SecretDataChecksum] = DataChecksum(data)
+	annotations[ManagedByVersion] = version.Version
+	s.addAdmissionAnnotations(data, annotations)
 
 	_, err = s.Persist(s.secret, func() error {
+		if err := s.checkResourceVersion(); err != nil {
+			return err
+		}
+
 		s.secret.Data = data
 		s.secret.Annotations = annotations
+		if s.secret.Labels == nil {
+			s.secret.Labels = map[string]string{}
+		}
+		s.secret.Labels[ManagedByLabel] = ManagedByValue
+		if s.immutable {
+			immutable := true
+			s.secret.Immutable = &immutable
+		}
 
 		return nil
 	})
@@ -233,6 +412,47 @@ func (s *TLSSecret) UpdateCASecret(cakey []byte, caCert []byte, annotations map[
 	return err
 }
 
+// ManagedByVersion returns the version of the self-signer that last wrote the secret, empty if it was
+// written by a version that didn't record it.
+func (s *TLSSecret) ManagedByVersion() string {
+	return s.secret.Annotations[ManagedByVersion]
+}
+
+// Checksum returns the SHA-256 checksum of the secret data recorded when the secret was last updated
+func (s *TLSSecret) Checksum() string {
+	return s.secret.Annotations[SecretDataChecksum]
+}
+
+// DataHash returns the hash of the secret data recorded in the SecretDataHash annotation.
+func DataHash(data map[string][]byte) (string, error) {
+	hash, err := hashstructure.Hash(data, hashstructure.FormatV2, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d", hash), nil
+}
+
+// DataChecksum returns the hex encoded SHA-256 checksum of the secret data. Keys are
+// hashed in sorted order so that the checksum is stable.
+func DataChecksum(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write(data[k])
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // Secret returns the Secret object
 func (s *TLSSecret) Secret() *corev1.Secret {
 	return s.secret
@@ -253,10 +473,31 @@ func (s *TLSSecret) TLSPrivateKey() []byte {
 	return s.secret.Data[corev1.TLSPrivateKeyKey]
 }
 
-func GetSecretAnnotations(validFrom, validUpto, duration string) map[string]string {
-	return map[string]string{
+// GetSecretAnnotations returns the certificate info annotations. Besides the RFC3339 validity dates,
+// the UNIX timestamps and the time from which the certificate is due for rotation (validUpto minus the
+// expiryWindow) are added so that external tools can act on expiry without parsing dates.
+func GetSecretAnnotations(validFrom, validUpto, duration, expiryWindow string) map[string]string {
+	annotations := map[string]string{
 		CertValidUpto: validUpto,
 		CertValidFrom: validFrom,
 		CertDuration:  duration,
 	}
+
+	if from, err := time.Parse(time.RFC3339, validFrom); err == nil {
+		annotations[CertValidFromUnix] = strconv.FormatInt(from.Unix(), 10)
+	}
+
+	upto, err := time.Parse(time.RFC3339, validUpto)
+	if err != nil {
+		return annotations
+	}
+	annotations[CertValidUptoUnix] = strconv.FormatInt(upto.Unix(), 10)
+
+	if window, err := time.ParseDuration(expiryWindow); err == nil {
+		dueAt := upto.Add(-window)
+		annotations[RotationDueAt] = dueAt.Format(time.RFC3339)
+		annotations[RotationDueAtUnix] = strconv.FormatInt(dueAt.Unix(), 10)
+	}
+
+	return annotations
 }
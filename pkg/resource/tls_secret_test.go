@@ -18,6 +18,7 @@ package resource_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -31,6 +32,7 @@ import (
 	"github.com/cockroachdb/helm-charts/pkg/kube"
 	"github.com/cockroachdb/helm-charts/pkg/resource"
 	"github.com/cockroachdb/helm-charts/pkg/testutils"
+	"github.com/cockroachdb/helm-charts/pkg/version"
 )
 
 func TestLoadTLSSecret(t *testing.T) {
@@ -203,7 +205,7 @@ func TestUpdateCASecret(t *testing.T) {
 	r := resource.NewKubeResource(ctx, fakeClient, namespace, kube.DefaultPersister)
 	secret := resource.CreateTLSSecret(name, corev1.SecretTypeOpaque, r)
 
-	annotations := resource.GetSecretAnnotations("validFrom", "validUpto", "duration")
+	annotations := resource.GetSecretAnnotations("validFrom", "validUpto", "duration", "expiryWindow")
 	data := map[string][]byte{
 		"ca.crt": []byte("c2FtcGxlIGNlcnQ="), // sample cert
 		"ca.key": []byte("c2FtcGxlIGtleQ=="), // sample key
@@ -219,6 +221,24 @@ func TestUpdateCASecret(t *testing.T) {
 	assert.Equal(t, annotations, secret.Secret().GetAnnotations())
 }
 
+func TestUpdateCACertSecret(t *testing.T) {
+	ctx := context.TODO()
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t))
+	r := resource.NewKubeResource(ctx, fakeClient, "test-namespace", kube.DefaultPersister)
+	secret := resource.CreateTLSSecret("test-secret", corev1.SecretTypeOpaque, r)
+
+	annotations := resource.GetSecretAnnotations("validFrom", "validUpto", "duration", "expiryWindow")
+	require.NoError(t, secret.UpdateCACertSecret([]byte("c2FtcGxlIGNlcnQ="), annotations))
+
+	secret, err := resource.LoadTLSSecret("test-secret", r)
+	require.NoError(t, err)
+
+	// the CA key is never written to the secret
+	assert.Equal(t, map[string][]byte{"ca.crt": []byte("c2FtcGxlIGNlcnQ=")}, secret.Secret().Data)
+	assert.False(t, secret.ReadyCA())
+	assert.NotEmpty(t, secret.Secret().Annotations[resource.SecretDataHash])
+}
+
 func TestUpdateTLSSecret(t *testing.T) {
 	ctx := context.TODO()
 	scheme := testutils.InitScheme(t)
@@ -229,7 +249,7 @@ func TestUpdateTLSSecret(t *testing.T) {
 	r := resource.NewKubeResource(ctx, fakeClient, namespace, kube.DefaultPersister)
 	secret := resource.CreateTLSSecret(name, corev1.SecretTypeOpaque, r)
 
-	annotations := resource.GetSecretAnnotations("validFrom", "validUpto", "duration")
+	annotations := resource.GetSecretAnnotations("validFrom", "validUpto", "duration", "expiryWindow")
 	data := map[string][]byte{
 		"ca.crt":  []byte("c2FtcGxlIGNlcnQ="), // sample cert
 		"tls.key": []byte("c2FtcGxlIGtleQ=="), // sample key
@@ -244,6 +264,70 @@ func TestUpdateTLSSecret(t *testing.T) {
 
 	assert.Equal(t, data, secret.Secret().Data)
 	assert.Equal(t, annotations, secret.Secret().GetAnnotations())
+	assert.Equal(t, resource.DataChecksum(data), secret.Checksum())
+	assert.Equal(t, version.Version, secret.ManagedByVersion())
+}
+
+func TestUpdateTLSSecretRetriesConflict(t *testing.T) {
+	ctx := context.TODO()
+	scheme := testutils.InitScheme(t)
+	name := "test-secret"
+	namespace := "test-namespace"
+
+	fakeClient := testutils.NewFakeClient(scheme, secretObj(name, namespace, nil, nil))
+	updates := 0
+	fakeClient.AddReactor("update", "secrets", func(action testutils.Action) (bool, error) {
+		updates++
+		if updates == 1 {
+			return true, apierrors.NewConflict(corev1.Resource("secrets"), name, errors.New("object was modified"))
+		}
+		return false, nil
+	})
+
+	r := resource.NewKubeResource(ctx, fakeClient, namespace, kube.DefaultPersister)
+	secret, err := resource.LoadTLSSecret(name, r)
+	require.NoError(t, err)
+	secret.ExpectResourceVersion(secret.Secret().ResourceVersion)
+
+	require.NoError(t, secret.UpdateTLSSecret([]byte("cert"), []byte("key"), []byte("ca"), map[string]string{}))
+	assert.Equal(t, 2, updates)
+
+	secret, err = resource.LoadTLSSecret(name, r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cert"), secret.TLSCert())
+}
+
+func TestUpdateTLSSecretModifiedConcurrently(t *testing.T) {
+	ctx := context.TODO()
+	scheme := testutils.InitScheme(t)
+	name := "test-secret"
+	namespace := "test-namespace"
+
+	fakeClient := testutils.NewFakeClient(scheme, secretObj(name, namespace, nil, nil))
+	r := resource.NewKubeResource(ctx, fakeClient, namespace, kube.DefaultPersister)
+
+	loaded, err := resource.LoadTLSSecret(name, r)
+	require.NoError(t, err)
+
+	// another run rotates the certificate after the secret was loaded
+	other, err := resource.LoadTLSSecret(name, r)
+	require.NoError(t, err)
+	require.NoError(t, other.UpdateTLSSecret([]byte("other"), []byte("key"), []byte("ca"), map[string]string{}))
+
+	secret := resource.CreateTLSSecret(name, corev1.SecretTypeTLS, r)
+	secret.ExpectResourceVersion(loaded.Secret().ResourceVersion)
+	err = secret.UpdateTLSSecret([]byte("cert"), []byte("key"), []byte("ca"), map[string]string{})
+	assert.True(t, errors.Is(err, resource.ErrModified))
+
+	// a secret expected not to exist was created by another run
+	secret = resource.CreateTLSSecret(name, corev1.SecretTypeTLS, r)
+	secret.ExpectResourceVersion("")
+	err = secret.UpdateTLSSecret([]byte("cert"), []byte("key"), []byte("ca"), map[string]string{})
+	assert.True(t, errors.Is(err, resource.ErrModified))
+
+	current, err := resource.LoadTLSSecret(name, r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("other"), current.TLSCert())
 }
 
 func TestIsRotationRequired(t *testing.T) {
@@ -275,6 +359,40 @@ func TestIsRotationRequired(t *testing.T) {
 			rotate: true,
 			Reason: "Secret data altered, rotating certificate",
 		},
+		{
+			name: "secret annotated with a rotation request",
+			secret: secretObj(
+				name,
+				namespace,
+				map[string][]byte{"ca.crt": {}, "tls.crt": {}, "tls.key": {}},
+				map[string]string{
+					resource.CertValidUpto:   "2021-08-06T04:15:35Z",
+					resource.CertValidFrom:   "2021-07-06T04:15:35Z",
+					resource.CertDuration:    "720h0m0s",
+					resource.SecretDataHash:  "6889078329698146222",
+					resource.RotateRequested: "true",
+				}),
+			duration: 720 * time.Hour,
+			rotate:   true,
+			Reason:   "Rotation requested by annotation, rotating certificate",
+		},
+		{
+			name: "paused secret annotated with a rotation request",
+			secret: secretObj(
+				name,
+				namespace,
+				map[string][]byte{"ca.crt": {}, "tls.crt": {}, "tls.key": {}},
+				map[string]string{
+					resource.CertValidUpto:   "2021-08-06T04:15:35Z",
+					resource.CertValidFrom:   "2021-07-06T04:15:35Z",
+					resource.CertDuration:    "720h0m0s",
+					resource.SecretDataHash:  "123",
+					resource.RotateRequested: "true",
+					resource.Paused:          "true",
+				}),
+			duration: 720 * time.Hour,
+			rotate:   false,
+		},
 		{
 			name: "secret having different certificate duration then current duration (duration mismatch)",
 			secret: secretObj(
@@ -379,6 +497,73 @@ func TestIsRotationRequired(t *testing.T) {
 	}
 }
 
+func TestIsRotationRequiredAt(t *testing.T) {
+	secret, err := resource.LoadTLSSecret("test-secret", resource.NewKubeResource(context.TODO(),
+		testutils.NewFakeClient(testutils.InitScheme(t), secretObj(
+			"test-secret",
+			"test-namespace",
+			map[string][]byte{"ca.crt": {}, "tls.crt": {}, "tls.key": {}},
+			map[string]string{
+				resource.CertValidUpto:  "2021-08-06T04:15:35Z",
+				resource.CertValidFrom:  "2021-07-06T04:15:35Z",
+				resource.CertDuration:   "720h0m0s",
+				resource.SecretDataHash: "6889078329698146222",
+			})), "test-namespace", kube.DefaultPersister))
+	require.NoError(t, err)
+
+	// the certificate outlives the next daily run until the last run before its expiry
+	rotate, _ := secret.IsRotationRequiredAt(720*time.Hour, "0 0 * * *", time.Date(2021, 8, 5, 12, 0, 0, 0, time.UTC))
+	assert.False(t, rotate)
+
+	rotate, reason := secret.IsRotationRequiredAt(720*time.Hour, "0 0 * * *", time.Date(2021, 8, 6, 1, 0, 0, 0, time.UTC))
+	assert.True(t, rotate)
+	assert.Equal(t, "Certificate about to expire, rotating certificate", reason)
+}
+
+func TestIsRenewalDue(t *testing.T) {
+	ctx := context.TODO()
+	name, namespace := "test-secret", "test-namespace"
+	annotations := resource.GetSecretAnnotations("2021-07-06T04:00:00Z", "2021-07-06T12:00:00Z", "8h0m0s", "1h")
+
+	fakeClient := testutils.NewFakeClient(testutils.InitScheme(t), secretObj(name, namespace, nil, annotations))
+	secret, err := resource.LoadTLSSecret(name, resource.NewKubeResource(ctx, fakeClient, namespace, kube.DefaultPersister))
+	require.NoError(t, err)
+
+	due, _ := secret.IsRenewalDue(0.5, time.Date(2021, 7, 6, 7, 59, 0, 0, time.UTC))
+	assert.False(t, due)
+
+	due, reason := secret.IsRenewalDue(0.5, time.Date(2021, 7, 6, 8, 0, 0, 0, time.UTC))
+	assert.True(t, due)
+	assert.Equal(t, "Certificate past 50% of its lifetime, renewing certificate", reason)
+}
+
+func TestGetSecretAnnotations(t *testing.T) {
+	annotations := resource.GetSecretAnnotations("2021-07-06T04:15:35Z", "2021-08-05T04:15:35Z", "720h0m0s", "48h")
+
+	assert.Equal(t, map[string]string{
+		resource.CertValidFrom:     "2021-07-06T04:15:35Z",
+		resource.CertValidUpto:     "2021-08-05T04:15:35Z",
+		resource.CertDuration:      "720h0m0s",
+		resource.CertValidFromUnix: "1625544935",
+		resource.CertValidUptoUnix: "1628136935",
+		resource.RotationDueAt:     "2021-08-03T04:15:35Z",
+		resource.RotationDueAtUnix: "1627964135",
+	}, annotations)
+}
+
+func TestDataChecksum(t *testing.T) {
+	data := map[string][]byte{"ca.crt": []byte("ca"), "tls.crt": []byte("cert"), "tls.key": []byte("key")}
+	checksum := resource.DataChecksum(data)
+
+	assert.Len(t, checksum, 64)
+	assert.Equal(t, checksum, resource.DataChecksum(map[string][]byte{
+		"tls.key": []byte("key"), "tls.crt": []byte("cert"), "ca.crt": []byte("ca")}))
+
+	// moving bytes between keys must change the checksum
+	assert.NotEqual(t, checksum, resource.DataChecksum(map[string][]byte{
+		"ca.crt": []byte("cat"), "tls.crt": []byte("ert"), "tls.key": []byte("key")}))
+}
+
 func secretObj(name, namespace string, data map[string][]byte, annotations map[string]string) *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
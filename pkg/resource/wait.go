@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// ErrInvalidSecret is returned when a secret doesn't hold a usable certificate.
+var ErrInvalidSecret = errors.New("invalid secret")
+
+// Validate checks that the secret contains a certificate, key and CA and that the certificate
+// is valid at the given time.
+func (s *TLSSecret) Validate(now time.Time) error {
+	if !s.Ready() {
+		return errors.Wrapf(ErrInvalidSecret, "secret %s doesn't contain the required cert/key", s.secret.Name)
+	}
+
+	if !s.ValidateAnnotations() {
+		return errors.Wrapf(ErrInvalidSecret, "secret %s is missing the certificate annotations", s.secret.Name)
+	}
+
+	cert, err := security.GetCertObj(s.TLSCert())
+	if err != nil {
+		return errors.Wrapf(ErrInvalidSecret, "secret %s contains an invalid certificate: %s", s.secret.Name, err)
+	}
+
+	if now.Before(cert.NotBefore) {
+		return errors.Wrapf(ErrInvalidSecret, "certificate in secret %s is not valid before %s", s.secret.Name,
+			cert.NotBefore.Format(time.RFC3339))
+	}
+
+	if now.After(cert.NotAfter) {
+		return errors.Wrapf(ErrInvalidSecret, "certificate in secret %s expired at %s", s.secret.Name,
+			cert.NotAfter.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// WaitForTLSSecrets waits until all the secrets exist and contain a certificate valid at the time of the clock,
+// or returns an error after the timeout.
+func WaitForTLSSecrets(r Resource, names []string, clk clock.Clock, timeout, maxPollingInterval time.Duration) error {
+	f := func() error {
+		for _, name := range names {
+			secret, err := LoadTLSSecret(name, r)
+			if err != nil {
+				logrus.Infof("Waiting for secret [%s]: %s", name, err.Error())
+				return err
+			}
+
+			if err := secret.Validate(clk.Now()); err != nil {
+				logrus.Infof("Waiting for secret [%s]: %s", name, err.Error())
+				return err
+			}
+		}
+
+		logrus.Infof("Secrets %v are ready", names)
+		return nil
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = timeout
+	b.MaxInterval = maxPollingInterval
+	return backoff.Retry(f, b)
+}
+
+// WaitForCompletion waits until the completion ConfigMap reports a completed run of the release revision, any
+// revision if empty, or returns an error after the timeout. A failed run is waited on as well, as the job may be
+// retried.
+func WaitForCompletion(r Resource, name, revision string, timeout, maxPollingInterval time.Duration) error {
+	f := func() error {
+		completion, err := LoadCompletion(name, r)
+		if err != nil {
+			logrus.Infof("Waiting for completion [%s]: %s", name, err.Error())
+			return err
+		}
+
+		if err := completion.Complete(revision); err != nil {
+			logrus.Infof("Waiting for completion [%s]: %s", name, err.Error())
+			return err
+		}
+
+		logrus.Infof("Completion [%s] reports a completed run of revision [%s]", name, completion.Revision())
+		return nil
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = timeout
+	b.MaxInterval = maxPollingInterval
+	return backoff.Retry(f, b)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutils"
+)
+
+func TestWaitForTLSSecrets(t *testing.T) {
+	ctx := context.TODO()
+	scheme := testutils.InitScheme(t)
+	namespace := "test-namespace"
+
+	annotations := map[string]string{
+		resource.CertValidUpto:  "validUpto",
+		resource.CertValidFrom:  "validFrom",
+		resource.CertDuration:   "duration",
+		resource.SecretDataHash: "123",
+	}
+
+	valid := secretObj("valid", namespace, map[string][]byte{
+		"ca.crt":  {},
+		"tls.key": {},
+		"tls.crt": pemCert(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)),
+	}, annotations)
+	expired := secretObj("expired", namespace, map[string][]byte{
+		"ca.crt":  {},
+		"tls.key": {},
+		"tls.crt": pemCert(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)),
+	}, annotations)
+
+	fakeClient := testutils.NewFakeClient(scheme, valid, expired)
+	r := resource.NewKubeResource(ctx, fakeClient, namespace, kube.DefaultPersister)
+
+	require.NoError(t, resource.WaitForTLSSecrets(r, []string{"valid"}, clock.Real, time.Second, 100*time.Millisecond))
+	assert.Error(t, resource.WaitForTLSSecrets(r, []string{"valid", "expired"}, clock.Real, time.Second, 100*time.Millisecond))
+	assert.Error(t, resource.WaitForTLSSecrets(r, []string{"missing"}, clock.Real, time.Second, 100*time.Millisecond))
+
+	// the certificates are validated at the time of the clock
+	later := clock.NewFake(time.Now().Add(2 * time.Hour))
+	assert.Error(t, resource.WaitForTLSSecrets(r, []string{"valid"}, later, time.Second, 100*time.Millisecond))
+}
+
+func pemCert(t *testing.T, notBefore, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -17,11 +17,16 @@ limitations under the License.
 package security
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"time"
 )
 
@@ -199,3 +204,79 @@ func GetCertObj(pemCert []byte) (*x509.Certificate, error) {
 
 	return cert, nil
 }
+
+// ParseCerts returns all the certificates of the PEM data, such as a certificate chain or a CA bundle.
+func ParseCerts(pemCerts []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := pemCerts; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("failed to decode certificate")
+	}
+	return certs, nil
+}
+
+// Fingerprint returns the hex encoded SHA-256 fingerprint of the first certificate in the PEM data.
+func Fingerprint(pemCert []byte) (string, error) {
+	cert, err := GetCertObj(pemCert)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CertFile is a file of a certs directory.
+type CertFile struct {
+	Name string
+	Data []byte
+	Mode os.FileMode
+}
+
+// ClientCertFiles returns the CA certificate and the client certificate and key of the user as the files of
+// a certs directory, with the names and modes the cockroach CLI expects.
+func ClientCertFiles(user string, ca, cert, key []byte) []CertFile {
+	return []CertFile{
+		{Name: "ca.crt", Data: ca, Mode: CertFileMode},
+		{Name: fmt.Sprintf("client.%s.crt", user), Data: cert, Mode: CertFileMode},
+		{Name: fmt.Sprintf("client.%s.key", user), Data: key, Mode: KeyFileMode},
+	}
+}
+
+// WriteClientCerts writes the CA certificate and the client certificate and key of the user to certsDir,
+// with the names and modes the cockroach CLI expects.
+func WriteClientCerts(certsDir, user string, ca, cert, key []byte) error {
+	if err := os.MkdirAll(certsDir, 0700); err != nil {
+		return fmt.Errorf("failed to create the certs directory: %s", err)
+	}
+
+	for _, f := range ClientCertFiles(user, ca, cert, key) {
+		path := filepath.Join(certsDir, f.Name)
+		if err := ioutil.WriteFile(path, f.Data, f.Mode); err != nil {
+			return fmt.Errorf("unable to write %s: %s", f.Name, err)
+		}
+
+		// the mode of an existing file isn't changed by the write
+		if err := os.Chmod(path, f.Mode); err != nil {
+			return fmt.Errorf("unable to set the mode of %s: %s", f.Name, err)
+		}
+	}
+
+	return nil
+}
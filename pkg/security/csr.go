@@ -0,0 +1,191 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// NodeUser is the common name of node certificates.
+const NodeUser = "node"
+
+// GenerateKey generates a private key of the algorithm, RSA of keySize bits or Ed25519, and returns it
+// along with its PEM encoding. RSA keys are PKCS#1 encoded like the keys created by the cockroach CLI.
+func GenerateKey(algorithm string, keySize int) (crypto.Signer, []byte, error) {
+	var keys *KeyPool
+	return keys.GenerateKey(algorithm, keySize)
+}
+
+// GenerateKey generates a key like the GenerateKey func, taking the RSA keys from the pool while it holds
+// keys of keySize bits. The pool may be nil, in which case all the keys are generated on demand.
+func (p *KeyPool) GenerateKey(algorithm string, keySize int) (crypto.Signer, []byte, error) {
+	if algorithm == Ed25519Algorithm {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate ed25519 key: %s", err)
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal ed25519 key: %s", err)
+		}
+		return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	key := p.Take(keySize)
+	if key == nil {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, keySize); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate rsa key: %s", err)
+		}
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+}
+
+// CreateCSR returns the PEM encoded certificate request of the key, for the common name and hosts.
+func CreateCSR(key crypto.Signer, commonName string, hosts []string) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			Organization: []string{"Cockroach"},
+			CommonName:   commonName,
+		},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %s", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// ParseCSR decodes the PEM encoded certificate request and checks its signature.
+func ParseCSR(pemCSR []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(pemCSR)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("failed to decode certificate request")
+	}
+
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %s", err)
+	}
+
+	return req, nil
+}
+
+// ParseCAPair returns the certificate of the CA bundle matching the CA key, along with the key. The
+// bundle holds both the old and the new CA certificate while the CA is rotated.
+func ParseCAPair(pemCerts, pemKey []byte) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ParsePrivateKey(pemKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for rest := pemCerts; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if bytes.Equal(cert.RawSubjectPublicKeyInfo, pub) {
+			return cert, key, nil
+		}
+	}
+
+	return nil, nil, errors.New("no CA certificate matches the CA key")
+}
+
+// SignCSR issues the certificate requested by the certificate request, using the same templates as the
+// cockroach CLI: a node certificate, valid for server and client auth, if the common name is NodeUser and
+// a client certificate otherwise. The certificate doesn't outlive the CA.
+func SignCSR(req *x509.CertificateRequest, caCert *x509.Certificate, caKey crypto.Signer, now time.Time,
+	lifetime time.Duration) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+
+	notAfter := now.Add(lifetime)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               req.Subject,
+		NotBefore:             now.Add(-validFromBackdate),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// key encipherment only applies to RSA keys
+	if _, ok := req.PublicKey.(*rsa.PublicKey); !ok {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+
+	// the DNS names of client certificates are the additional principals of the identity they were mapped to
+	template.DNSNames = req.DNSNames
+	if req.Subject.CommonName == NodeUser {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		template.IPAddresses = req.IPAddresses
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, req.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %s", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
@@ -0,0 +1,21 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package security creates and parses the keys and certificates of CockroachDB. The CA, node and client
+// pairs are written to a certs directory with the cockroach cert commands, so that they have the layout
+// cockroach expects, while the certificates not handled by cockroach, such as the DB Console and Ingress
+// ones, and the certificates signed from CSRs, are created in memory.
+package security
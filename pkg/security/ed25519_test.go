@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestSupportsEd25519(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected bool
+	}{
+		{version: "v20.2.5", expected: false},
+		{version: "v21.1.0", expected: true},
+		{version: "v21.2.3", expected: true},
+		{version: "v22.1.0-beta.1", expected: true},
+		{version: "invalid", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			assert.Equal(t, tt.expected, security.SupportsEd25519(tt.version))
+		})
+	}
+}
+
+func TestCreateEd25519ClientPair(t *testing.T) {
+	certsDir, cleanup := tempDir(t)
+	defer cleanup()
+	caKey := filepath.Join(certsDir, "ca.key")
+	writeTestCA(t, certsDir, caKey)
+
+	u := security.SQLUsername{U: "app"}
+	require.NoError(t, security.CreateEd25519ClientPair(certsDir, caKey, time.Now(), defaultCertLifetime, u))
+
+	pemCert, err := ioutil.ReadFile(filepath.Join(certsDir, "client.app.crt"))
+	require.NoError(t, err)
+
+	cert, err := security.GetCertObj(pemCert)
+	require.NoError(t, err)
+	assert.Equal(t, "app", cert.Subject.CommonName)
+	assert.Equal(t, x509.Ed25519, cert.PublicKeyAlgorithm)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+
+	pemKey, err := ioutil.ReadFile(filepath.Join(certsDir, "client.app.key"))
+	require.NoError(t, err)
+
+	key, err := security.ParsePrivateKey(pemKey)
+	require.NoError(t, err)
+	assert.IsType(t, ed25519.PrivateKey{}, key)
+}
+
+// writeTestCA writes a self-signed RSA CA into certsDir without shelling out to the cockroach binary.
+func writeTestCA(t *testing.T, certsDir, caKeyPath string) {
+	key, err := rsa.GenerateKey(rand.Reader, defaultKeySize)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"Cockroach"}, CommonName: "Cockroach CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(defaultCALifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(certsDir, "ca.crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), security.CertFileMode))
+	require.NoError(t, ioutil.WriteFile(caKeyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		security.KeyFileMode))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+)
+
+// KeyPool generates RSA keys of a size on concurrent workers ahead of their use, as the generation of
+// large RSA keys dominates the time taken to issue many certificates. A pool is owned by a single run of the
+// generator, which passes it to the funcs creating the keys of its certificates.
+type KeyPool struct {
+	keySize int
+	keys    chan *rsa.PrivateKey
+
+	mu        sync.Mutex
+	remaining int
+	stopped   bool
+}
+
+// StartKeyPool starts workers generating count RSA keys of keySize bits in the background.
+func StartKeyPool(keySize, workers, count int) *KeyPool {
+	p := &KeyPool{
+		keySize:   keySize,
+		keys:      make(chan *rsa.PrivateKey, count),
+		remaining: count,
+	}
+
+	if workers > count {
+		workers = count
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p.claim() {
+				key, err := rsa.GenerateKey(rand.Reader, keySize)
+				if err != nil {
+					// the key is generated by GenerateKey instead, which reports the error
+					continue
+				}
+				p.keys <- key
+			}
+		}()
+	}
+
+	// the keys are taken until the workers are done, then generated on demand
+	go func() {
+		wg.Wait()
+		close(p.keys)
+	}()
+
+	return p
+}
+
+// claim reserves the generation of a key for a worker. It returns false once all the keys were generated
+// or the pool was stopped.
+func (p *KeyPool) claim() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped || p.remaining == 0 {
+		return false
+	}
+	p.remaining--
+	return true
+}
+
+// Take returns a pre-generated key of keySize bits, waiting for a worker generating one if none is ready.
+// It returns nil if the pool holds keys of another size or is exhausted.
+func (p *KeyPool) Take(keySize int) *rsa.PrivateKey {
+	if p == nil || keySize != p.keySize {
+		return nil
+	}
+	return <-p.keys
+}
+
+// Stop stops the workers once the keys they are generating are done. The keys already generated can
+// still be taken.
+func (p *KeyPool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+}
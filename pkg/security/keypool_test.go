@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"crypto/rsa"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestKeyPool(t *testing.T) {
+	pool := security.StartKeyPool(1024, 2, 3)
+	defer pool.Stop()
+
+	// the keys of another size are generated on demand
+	assert.Nil(t, pool.Take(2048))
+
+	for i := 0; i < 3; i++ {
+		key := pool.Take(1024)
+		require.NotNil(t, key)
+		assert.Equal(t, 1024, key.N.BitLen())
+		require.NoError(t, key.Validate())
+	}
+
+	// the pool is exhausted
+	assert.Nil(t, pool.Take(1024))
+}
+
+func TestGenerateKeyWithoutPool(t *testing.T) {
+	var pool *security.KeyPool
+
+	key, _, err := pool.GenerateKey(security.RSAAlgorithm, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, 1024, key.(*rsa.PrivateKey).N.BitLen())
+}
+
+func TestKeyPoolStop(t *testing.T) {
+	pool := security.StartKeyPool(1024, 1, 100)
+	pool.Stop()
+
+	// the key being generated when the pool stopped can still be taken, and no more are generated
+	taken := 0
+	for pool.Take(1024) != nil {
+		taken++
+	}
+	assert.LessOrEqual(t, taken, 1)
+}
+
+func TestGenerateKeyFromPool(t *testing.T) {
+	pool := security.StartKeyPool(1024, 1, 1)
+	defer pool.Stop()
+
+	key, pemKey, err := pool.GenerateKey(security.RSAAlgorithm, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, 1024, key.(*rsa.PrivateKey).N.BitLen())
+
+	parsed, err := security.ParsePrivateKey(pemKey)
+	require.NoError(t, err)
+	assert.True(t, key.(*rsa.PrivateKey).Equal(parsed))
+
+	// once the pool is exhausted the keys are generated on demand
+	key, _, err = pool.GenerateKey(security.RSAAlgorithm, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, 1024, key.(*rsa.PrivateKey).N.BitLen())
+}
+
+// benchmarkKeys is the number of keys of a run issuing the certificates of a release with per-node
+// certificates, tenants and the DB Console and Ingress certificates.
+const benchmarkKeys = 8
+
+// BenchmarkGenerateKeys guards the time taken to generate the keys of a run one after the other, which
+// BenchmarkGenerateKeysPooled must beat on machines with several CPUs.
+func BenchmarkGenerateKeys(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for k := 0; k < benchmarkKeys; k++ {
+			if _, _, err := security.GenerateKey(security.RSAAlgorithm, 4096); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkGenerateKeysPooled(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		pool := security.StartKeyPool(4096, runtime.NumCPU(), benchmarkKeys)
+		for k := 0; k < benchmarkKeys; k++ {
+			if _, _, err := pool.GenerateKey(security.RSAAlgorithm, 4096); err != nil {
+				b.Fatal(err)
+			}
+		}
+		pool.Stop()
+	}
+}
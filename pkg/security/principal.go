@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// ClientPrincipal is the identity of a client certificate for clusters mapping the certificate identities to
+// SQL users with the server.identity_map.configuration cluster setting, instead of the common name being the
+// SQL user itself.
+type ClientPrincipal struct {
+	// CommonName is the system identity matched by the identity map, e.g. app@corp.example.com.
+	CommonName string
+	// DNSNames are additional principals of the certificate, as DNS SANs, e.g. for --cert-principal-map.
+	DNSNames []string
+	// OrganizationalUnits are the units of the subject, for the auth integrations keying off them.
+	OrganizationalUnits []string
+}
+
+// template returns the client certificate template of the user with the principal as its subject.
+func (p ClientPrincipal) template(user SQLUsername) *x509.Certificate {
+	template := clientTemplate(user)
+	if p.CommonName != "" {
+		template.Subject.CommonName = p.CommonName
+	}
+	template.Subject.OrganizationalUnit = p.OrganizationalUnits
+	return template
+}
+
+// CreateClientPairForPrincipal creates a client key of the algorithm and certificate like
+// CreateClientPairWithProfile, identifying the principal instead of the user. The certificate and key are
+// still written to the client.<user>.crt and client.<user>.key files of the certs directory.
+func CreateClientPairForPrincipal(certsDir, caKeyPath string, keys *KeyPool, algorithm string, keySize int,
+	now time.Time, lifetime time.Duration, user SQLUsername, principal ClientPrincipal, profile *Profile) error {
+
+	key, pemKey, err := keys.GenerateKey(algorithm, keySize)
+	if err != nil {
+		return err
+	}
+	return createPairWithProfile(certsDir, caKeyPath, key, pemKey, now, lifetime, principal.template(user),
+		principal.DNSNames, profile, fmt.Sprintf("client.%s.crt", user.U), fmt.Sprintf("client.%s.key", user.U))
+}
+
+// ReissueClientPairForPrincipal signs a client certificate like CreateClientPairForPrincipal for the existing
+// PEM encoded key, instead of creating a new one, and writes both to the certs directory.
+func ReissueClientPairForPrincipal(certsDir, caKeyPath string, pemKey []byte, now time.Time, lifetime time.Duration,
+	user SQLUsername, principal ClientPrincipal, profile *Profile) error {
+
+	key, err := ParsePrivateKey(pemKey)
+	if err != nil {
+		return err
+	}
+	return createPairWithProfile(certsDir, caKeyPath, key, pemKey, now, lifetime, principal.template(user),
+		principal.DNSNames, profile, fmt.Sprintf("client.%s.crt", user.U), fmt.Sprintf("client.%s.key", user.U))
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestCreateClientPairForPrincipal(t *testing.T) {
+	certsDir, cleanup := tempDir(t)
+	defer cleanup()
+	caKey := filepath.Join(certsDir, "ca.key")
+	writeTestCA(t, certsDir, caKey)
+
+	principal := security.ClientPrincipal{
+		CommonName:          "app@corp.example.com",
+		DNSNames:            []string{"app.corp.example.com"},
+		OrganizationalUnits: []string{"payments"},
+	}
+	require.NoError(t, security.CreateClientPairForPrincipal(certsDir, caKey, nil, security.RSAAlgorithm, 2048,
+		time.Now(), defaultCertLifetime, security.SQLUsername{U: "app"}, principal, nil))
+
+	// the files are still named after the SQL user
+	cert := readCert(t, filepath.Join(certsDir, "client.app.crt"))
+	assert.Equal(t, "app@corp.example.com", cert.Subject.CommonName)
+	assert.Equal(t, []string{"Cockroach"}, cert.Subject.Organization)
+	assert.Equal(t, []string{"payments"}, cert.Subject.OrganizationalUnit)
+	assert.Equal(t, []string{"app.corp.example.com"}, cert.DNSNames)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+
+	pemKey, err := ioutil.ReadFile(filepath.Join(certsDir, "client.app.key"))
+	require.NoError(t, err)
+	require.NoError(t, security.ReissueClientPairForPrincipal(certsDir, caKey, pemKey, time.Now(), defaultCertLifetime,
+		security.SQLUsername{U: "app"}, principal, nil))
+	reissued := readCert(t, filepath.Join(certsDir, "client.app.crt"))
+	assert.Equal(t, cert.PublicKey, reissued.PublicKey)
+	assert.Equal(t, "app@corp.example.com", reissued.Subject.CommonName)
+
+	// without a common name, the principal is the user
+	require.NoError(t, security.CreateClientPairForPrincipal(certsDir, caKey, nil, security.Ed25519Algorithm, 0,
+		time.Now(), defaultCertLifetime, security.SQLUsername{U: "app"}, security.ClientPrincipal{}, nil))
+	cert = readCert(t, filepath.Join(certsDir, "client.app.crt"))
+	assert.Equal(t, "app", cert.Subject.CommonName)
+	assert.Empty(t, cert.DNSNames)
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile overrides the key usages of the certificates of a class, such as node or client certificates,
+// for validators outside CockroachDB which expect different usages than the ones set by the cockroach CLI,
+// and adds the policies and extensions required by enterprise PKI policies.
+type Profile struct {
+	// KeyUsage replaces the key usage bits of the certificate if not zero.
+	KeyUsage x509.KeyUsage
+	// ExtKeyUsage replaces the extended key usages of the certificate if not empty.
+	ExtKeyUsage []x509.ExtKeyUsage
+	// Policies are the certificate policy OIDs of the certificate.
+	Policies []asn1.ObjectIdentifier
+	// Extensions are added to the certificate as is.
+	Extensions []pkix.Extension
+}
+
+// ProfileSpec describes a Profile as written in config files.
+type ProfileSpec struct {
+	KeyUsage    []string
+	ExtKeyUsage []string
+	// Policies are dotted OIDs, e.g. 1.3.6.1.4.1.99999.1.1.
+	Policies   []string
+	Extensions []ExtensionSpec
+}
+
+// ExtensionSpec describes a certificate extension as written in config files.
+type ExtensionSpec struct {
+	// OID is the dotted OID of the extension.
+	OID      string
+	Critical bool
+	// Value is the base64 encoded DER value of the extension.
+	Value string
+}
+
+// managedExtensions are the extensions set from the certificate template, which can't be overridden by
+// the extensions of a profile.
+var managedExtensions = map[string]string{
+	"2.5.29.15": "key usage",
+	"2.5.29.17": "subject alternative name",
+	"2.5.29.19": "basic constraints",
+	"2.5.29.32": "certificate policies",
+	"2.5.29.35": "authority key identifier",
+	"2.5.29.37": "extended key usage",
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"serverAuth": x509.ExtKeyUsageServerAuth,
+	"clientAuth": x509.ExtKeyUsageClientAuth,
+}
+
+// NewProfile returns the profile of the spec. Usages are named as in RFC 5280, e.g. digitalSignature and
+// serverAuth. It returns nil if the spec is empty, keeping the default usages.
+func NewProfile(spec ProfileSpec) (*Profile, error) {
+	if len(spec.KeyUsage) == 0 && len(spec.ExtKeyUsage) == 0 && len(spec.Policies) == 0 && len(spec.Extensions) == 0 {
+		return nil, nil
+	}
+
+	p := &Profile{}
+	for _, name := range spec.KeyUsage {
+		usage, ok := keyUsages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown key usage %s", name)
+		}
+		p.KeyUsage |= usage
+	}
+
+	for _, name := range spec.ExtKeyUsage {
+		usage, ok := extKeyUsages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage %s", name)
+		}
+		p.ExtKeyUsage = append(p.ExtKeyUsage, usage)
+	}
+
+	for _, policy := range spec.Policies {
+		oid, err := ParseOID(policy)
+		if err != nil {
+			return nil, err
+		}
+		p.Policies = append(p.Policies, oid)
+	}
+
+	for _, ext := range spec.Extensions {
+		oid, err := ParseOID(ext.OID)
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := managedExtensions[oid.String()]; ok {
+			return nil, fmt.Errorf("the %s extension %s can't be set as a custom extension", name, oid)
+		}
+
+		value, err := base64.StdEncoding.DecodeString(ext.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of extension %s: %s", oid, err)
+		}
+
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(value, &raw); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("the value of extension %s isn't a DER encoded ASN.1 value", oid)
+		}
+
+		p.Extensions = append(p.Extensions, pkix.Extension{Id: oid, Critical: ext.Critical, Value: value})
+	}
+
+	return p, nil
+}
+
+// ParseOID parses a dotted OID, e.g. 1.3.6.1.4.1.99999.1.1.
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+
+	oid := make(asn1.ObjectIdentifier, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, n)
+	}
+
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] > 39) {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+// apply overrides the usages of the certificate template with the ones of the profile, and adds its
+// policies and extensions.
+func (p *Profile) apply(template *x509.Certificate) {
+	if p == nil {
+		return
+	}
+
+	if p.KeyUsage != 0 {
+		template.KeyUsage = p.KeyUsage
+	}
+	if len(p.ExtKeyUsage) > 0 {
+		template.ExtKeyUsage = append([]x509.ExtKeyUsage{}, p.ExtKeyUsage...)
+	}
+	template.PolicyIdentifiers = append(template.PolicyIdentifiers, p.Policies...)
+	template.ExtraExtensions = append(template.ExtraExtensions, p.Extensions...)
+}
+
+// CreateNodePairWithProfile creates a node key and certificate like CreateNodePair, with the usages of the
+// profile, taking the key from keys if not nil. The cockroach CLI can't change the usages, so the certificate
+// is built natively using the same template as the CLI.
+func CreateNodePairWithProfile(certsDir, caKeyPath string, keys *KeyPool, keySize int,
+	now time.Time, lifetime time.Duration, hosts []string, profile *Profile) error {
+
+	key, pemKey, err := keys.GenerateKey(RSAAlgorithm, keySize)
+	if err != nil {
+		return err
+	}
+	return createPairWithProfile(certsDir, caKeyPath, key, pemKey, now, lifetime, nodeTemplate(), hosts, profile,
+		"node.crt", "node.key")
+}
+
+// ReissueNodePair signs a node certificate like CreateNodePairWithProfile for the existing PEM encoded key,
+// instead of creating a new one, and writes both to the certs directory.
+func ReissueNodePair(certsDir, caKeyPath string, pemKey []byte, now time.Time, lifetime time.Duration,
+	hosts []string, profile *Profile) error {
+
+	key, err := ParsePrivateKey(pemKey)
+	if err != nil {
+		return err
+	}
+	return createPairWithProfile(certsDir, caKeyPath, key, pemKey, now, lifetime, nodeTemplate(), hosts, profile,
+		"node.crt", "node.key")
+}
+
+// CreateClientPairWithProfile creates a client key of the algorithm and certificate like CreateClientPair,
+// with the usages of the profile, taking the key from keys if not nil.
+func CreateClientPairWithProfile(certsDir, caKeyPath string, keys *KeyPool, algorithm string, keySize int,
+	now time.Time, lifetime time.Duration, user SQLUsername, profile *Profile) error {
+
+	key, pemKey, err := keys.GenerateKey(algorithm, keySize)
+	if err != nil {
+		return err
+	}
+	return createPairWithProfile(certsDir, caKeyPath, key, pemKey, now, lifetime, clientTemplate(user), nil, profile,
+		fmt.Sprintf("client.%s.crt", user.U), fmt.Sprintf("client.%s.key", user.U))
+}
+
+// ReissueClientPair signs a client certificate like CreateClientPairWithProfile for the existing PEM encoded
+// key, instead of creating a new one, and writes both to the certs directory.
+func ReissueClientPair(certsDir, caKeyPath string, pemKey []byte,
+	now time.Time, lifetime time.Duration, user SQLUsername,
+	profile *Profile) error {
+
+	key, err := ParsePrivateKey(pemKey)
+	if err != nil {
+		return err
+	}
+	return createPairWithProfile(certsDir, caKeyPath, key, pemKey, now, lifetime, clientTemplate(user), nil, profile,
+		fmt.Sprintf("client.%s.crt", user.U), fmt.Sprintf("client.%s.key", user.U))
+}
+
+// nodeTemplate returns the template of the node certificates of the cockroach CLI.
+func nodeTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"Cockroach"},
+			CommonName:   NodeUser,
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+}
+
+// clientTemplate returns the template of the client certificates of the cockroach CLI.
+func clientTemplate(user SQLUsername) *x509.Certificate {
+	return &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"Cockroach"},
+			CommonName:   user.U,
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+}
+
+// createPairWithProfile signs the certificate of the template for the key with the CA of the certs directory
+// and writes it along with the PEM encoded key to certFile and keyFile of the directory.
+func createPairWithProfile(certsDir, caKeyPath string, key crypto.Signer, pemKey []byte,
+	now time.Time, lifetime time.Duration, template *x509.Certificate, hosts []string, profile *Profile,
+	certFile, keyFile string) error {
+
+	if len(caKeyPath) == 0 {
+		return errors.New("the path to the CA key is required")
+	}
+
+	if len(certsDir) == 0 {
+		return errors.New("the path to the certs directory is required")
+	}
+
+	caCert, caKey, err := loadCAPair(filepath.Join(certsDir, "ca.crt"), caKeyPath)
+	if err != nil {
+		return err
+	}
+
+	pemCert, err := signLeafCert(caCert, caKey, key, now, lifetime, template, hosts, profile)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(certsDir, certFile), pemCert, CertFileMode); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(certsDir, keyFile), pemKey, KeyFileMode)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestNewProfile(t *testing.T) {
+	profile, err := security.NewProfile(security.ProfileSpec{})
+	require.NoError(t, err)
+	assert.Nil(t, profile)
+
+	profile, err = security.NewProfile(security.ProfileSpec{
+		KeyUsage:    []string{"digitalSignature", "keyAgreement"},
+		ExtKeyUsage: []string{"clientAuth"},
+		Policies:    []string{"1.3.6.1.4.1.99999.1.1"},
+		Extensions:  []security.ExtensionSpec{{OID: "1.3.6.1.4.1.99999.2", Value: "BQA="}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyAgreement, profile.KeyUsage)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, profile.ExtKeyUsage)
+	assert.Equal(t, []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 99999, 1, 1}}, profile.Policies)
+	require.Len(t, profile.Extensions, 1)
+	assert.Equal(t, []byte{0x05, 0x00}, profile.Extensions[0].Value)
+
+	invalid := []security.ProfileSpec{
+		{ExtKeyUsage: []string{"codeSigning"}},
+		{Policies: []string{"1.3.6.x"}},
+		{Extensions: []security.ExtensionSpec{{OID: "2.5.29.17", Value: "BQA="}}},
+		{Extensions: []security.ExtensionSpec{{OID: "1.3.6.1.4.1.99999.2", Value: "not DER"}}},
+	}
+	for _, spec := range invalid {
+		_, err = security.NewProfile(spec)
+		assert.Error(t, err)
+	}
+}
+
+func TestCreatePairsWithProfile(t *testing.T) {
+	certsDir, cleanup := tempDir(t)
+	defer cleanup()
+	caKey := filepath.Join(certsDir, "ca.key")
+	writeTestCA(t, certsDir, caKey)
+
+	// a node certificate for servers only, marked internal by a policy
+	profile := &security.Profile{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Policies:    []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 99999, 1, 1}},
+	}
+	require.NoError(t, security.CreateNodePairWithProfile(certsDir, caKey, nil, 2048, time.Now(), defaultCertLifetime,
+		[]string{"crdb-public"}, profile))
+
+	cert := readCert(t, filepath.Join(certsDir, "node.crt"))
+	assert.Equal(t, "node", cert.Subject.CommonName)
+	assert.Equal(t, []string{"crdb-public"}, cert.DNSNames)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+	assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, cert.KeyUsage)
+	assert.Equal(t, profile.Policies, cert.PolicyIdentifiers)
+
+	// a client certificate also valid for server auth
+	profile = &security.Profile{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	require.NoError(t, security.CreateClientPairWithProfile(certsDir, caKey, nil, security.Ed25519Algorithm, 0,
+		time.Now(), defaultCertLifetime, security.SQLUsername{U: "app"}, profile))
+
+	cert = readCert(t, filepath.Join(certsDir, "client.app.crt"))
+	assert.Equal(t, "app", cert.Subject.CommonName)
+	assert.Equal(t, x509.KeyUsageDigitalSignature, cert.KeyUsage)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+}
+
+func TestReissuePairs(t *testing.T) {
+	certsDir, cleanup := tempDir(t)
+	defer cleanup()
+	caKey := filepath.Join(certsDir, "ca.key")
+	writeTestCA(t, certsDir, caKey)
+
+	require.NoError(t, security.CreateNodePairWithProfile(certsDir, caKey, nil, 2048, time.Now(), defaultCertLifetime,
+		[]string{"crdb-public"}, nil))
+	pemKey, err := ioutil.ReadFile(filepath.Join(certsDir, "node.key"))
+	require.NoError(t, err)
+	cert := readCert(t, filepath.Join(certsDir, "node.crt"))
+
+	// the node key is re-signed, the certificate gets a new serial
+	require.NoError(t, security.ReissueNodePair(certsDir, caKey, pemKey, time.Now(), defaultCertLifetime,
+		[]string{"crdb-public"}, nil))
+	reissued := readCert(t, filepath.Join(certsDir, "node.crt"))
+	assert.NotEqual(t, cert.SerialNumber, reissued.SerialNumber)
+	assert.Equal(t, cert.PublicKey, reissued.PublicKey)
+	assert.Equal(t, cert.ExtKeyUsage, reissued.ExtKeyUsage)
+
+	written, err := ioutil.ReadFile(filepath.Join(certsDir, "node.key"))
+	require.NoError(t, err)
+	assert.Equal(t, pemKey, written)
+
+	// Ed25519 client keys are re-signed without key encipherment
+	require.NoError(t, security.CreateClientPairWithProfile(certsDir, caKey, nil, security.Ed25519Algorithm, 0,
+		time.Now(), defaultCertLifetime, security.SQLUsername{U: "app"}, nil))
+	pemKey, err = ioutil.ReadFile(filepath.Join(certsDir, "client.app.key"))
+	require.NoError(t, err)
+	cert = readCert(t, filepath.Join(certsDir, "client.app.crt"))
+
+	require.NoError(t, security.ReissueClientPair(certsDir, caKey, pemKey, time.Now(), defaultCertLifetime,
+		security.SQLUsername{U: "app"}, nil))
+	reissued = readCert(t, filepath.Join(certsDir, "client.app.crt"))
+	assert.Equal(t, cert.PublicKey, reissued.PublicKey)
+	assert.Equal(t, x509.KeyUsageDigitalSignature, reissued.KeyUsage)
+
+	assert.Error(t, security.ReissueClientPair(certsDir, caKey, []byte("not a key"), time.Now(), defaultCertLifetime,
+		security.SQLUsername{U: "app"}, nil))
+}
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+	pemCert, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	cert, err := security.GetCertObj(pemCert)
+	require.NoError(t, err)
+	return cert
+}
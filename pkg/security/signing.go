@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GenerateSigningKey generates an ECDSA P-256 key, the key type JWS ES256 and cosign sign with, and returns it
+// along with its PKCS#8 PEM encoding.
+func GenerateSigningKey() (crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ecdsa key: %s", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal ecdsa key: %s", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// CreateSigningCert signs a certificate of the key with the CA, valid for code signing only, so that the
+// receipts and attestations signed by the key can be verified against the CA without the CA key ever signing
+// them. The certificate never authenticates a client or a server. It returns the PEM encoded certificate.
+func CreateSigningCert(caCert *x509.Certificate, caKey crypto.Signer, key crypto.Signer, commonName string,
+	now time.Time, lifetime time.Duration) ([]byte, error) {
+
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"Cockroach"},
+			CommonName:   commonName,
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	return signLeafCert(caCert, caKey, key, now, lifetime, template, nil, nil)
+}
+
+// VerifySigningCert checks that the certificate was issued to the common name for code signing by one of the CA
+// certificates in the PEM bundle, at the given time.
+func VerifySigningCert(cert *x509.Certificate, caBundle []byte, commonName string, now time.Time) error {
+	if cert.Subject.CommonName != commonName {
+		return fmt.Errorf("certificate is issued to %s instead of %s", cert.Subject.CommonName, commonName)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return errors.New("failed to parse CA bundle")
+	}
+
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	return err
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The following constants are used to run SQL statements with the crdb binary
+const (
+	SQL     string = "sql"
+	HOST    string = "--host=%s"
+	EXECUTE string = "--execute=%s"
+)
+
+// ExecSQL runs the SQL statements against the cluster at host as the root user. The certs directory
+// must contain the CA cert and the root client cert and key.
+func ExecSQL(certsDir, host string, statements ...string) error {
+	if len(certsDir) == 0 {
+		return errors.New("the path to the certs directory is required")
+	}
+
+	if len(statements) == 0 {
+		return nil
+	}
+
+	args := []string{SQL, fmt.Sprintf(CERTS_DIR, certsDir), fmt.Sprintf(HOST, host)}
+	for _, stmt := range statements {
+		args = append(args, fmt.Sprintf(EXECUTE, stmt))
+	}
+
+	cmd := exec.Command(CR, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to execute SQL: %s\nout: %s", err, out)
+	}
+
+	return nil
+}
+
+// QuerySQL runs the SQL query against the cluster at host as the root user, like ExecSQL, and returns the rows
+// of its result keyed by column name.
+func QuerySQL(certsDir, host, query string) ([]map[string]string, error) {
+	if len(certsDir) == 0 {
+		return nil, errors.New("the path to the certs directory is required")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(CR, SQL, fmt.Sprintf(CERTS_DIR, certsDir), fmt.Sprintf(HOST, host), "--format=csv",
+		fmt.Sprintf(EXECUTE, query))
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL: %s\nout: %s", err, stderr.Bytes())
+	}
+
+	return ParseRows(out)
+}
+
+// ParseRows parses the CSV output of a query, whose first line holds the column names, into rows keyed by
+// column name.
+func ParseRows(out []byte) ([]map[string]string, error) {
+	records, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SQL output: %s", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(record))
+		for i, value := range record {
+			if i < len(records[0]) {
+				row[records[0][i]] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ExecSQLInput runs the SQL statements read from input against the cluster at host as the root user.
+// Unlike ExecSQL, the statements are piped to the crdb binary, so that secrets such as passwords don't
+// show up in its command line.
+func ExecSQLInput(certsDir, host, input string) error {
+	if len(certsDir) == 0 {
+		return errors.New("the path to the certs directory is required")
+	}
+
+	cmd := exec.Command(CR, SQL, fmt.Sprintf(CERTS_DIR, certsDir), fmt.Sprintf(HOST, host))
+	cmd.Stdin = strings.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to execute SQL: %s\nout: %s", err, out)
+	}
+
+	return nil
+}
+
+// QuoteIdentifier quotes a SQL identifier, such as a user name, so that it can be safely
+// used in a statement.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// CreateUserStatement returns the statement creating the SQL user if it doesn't exist.
+func CreateUserStatement(user string) string {
+	return fmt.Sprintf("CREATE USER IF NOT EXISTS %s", QuoteIdentifier(user))
+}
+
+// QuoteString quotes a SQL string literal, such as a password, so that it can be safely used in a
+// statement.
+func QuoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// AlterPasswordStatement returns the statement setting the password of the SQL user.
+func AlterPasswordStatement(user, password string) string {
+	return fmt.Sprintf("ALTER USER %s WITH PASSWORD %s", QuoteIdentifier(user), QuoteString(password))
+}
+
+// GeneratePassword returns a random password holding 256 bits of entropy.
+func GeneratePassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Grant describes the privileges on a database and the roles granted to a SQL user.
+type Grant struct {
+	User       string
+	Database   string
+	Privileges []string
+	Roles      []string
+}
+
+// CurrentGrant returns the privileges of the user of the grant on its database, if any, and the roles granted
+// to it, as listed by SHOW GRANTS on the cluster at host.
+func CurrentGrant(certsDir, host string, g Grant) (Grant, error) {
+	current := Grant{User: g.User, Database: g.Database}
+
+	if g.Database != "" {
+		rows, err := QuerySQL(certsDir, host, fmt.Sprintf("SHOW GRANTS ON DATABASE %s FOR %s",
+			QuoteIdentifier(g.Database), QuoteIdentifier(g.User)))
+		if err != nil {
+			return current, err
+		}
+		for _, row := range rows {
+			if row["grantee"] == g.User {
+				current.Privileges = append(current.Privileges, row["privilege_type"])
+			}
+		}
+	}
+
+	rows, err := QuerySQL(certsDir, host, fmt.Sprintf("SHOW GRANTS ON ROLE FOR %s", QuoteIdentifier(g.User)))
+	if err != nil {
+		return current, err
+	}
+	for _, row := range rows {
+		if row["member"] == g.User {
+			current.Roles = append(current.Roles, row["role_name"])
+		}
+	}
+
+	return current, nil
+}
+
+// GrantStatements returns the statements reconciling the privileges and roles of the user with the grant: the
+// ones of current, as returned by CurrentGrant, which the grant doesn't list are revoked first, then the ones
+// of the grant are granted. GRANT and REVOKE are idempotent, so the statements can be executed on every run.
+func GrantStatements(g Grant, current Grant) []string {
+	var statements []string
+
+	if g.Database != "" && current.Database == g.Database {
+		if revoked := missing(current.Privileges, g.Privileges); len(revoked) > 0 {
+			statements = append(statements, fmt.Sprintf("REVOKE %s ON DATABASE %s FROM %s",
+				strings.Join(revoked, ", "), QuoteIdentifier(g.Database), QuoteIdentifier(g.User)))
+		}
+	}
+
+	if revoked := missing(current.Roles, g.Roles); len(revoked) > 0 {
+		statements = append(statements, fmt.Sprintf("REVOKE %s FROM %s", quoteIdentifiers(revoked),
+			QuoteIdentifier(g.User)))
+	}
+
+	if g.Database != "" && len(g.Privileges) > 0 {
+		statements = append(statements, fmt.Sprintf("GRANT %s ON DATABASE %s TO %s",
+			strings.Join(g.Privileges, ", "), QuoteIdentifier(g.Database), QuoteIdentifier(g.User)))
+	}
+
+	if len(g.Roles) > 0 {
+		statements = append(statements, fmt.Sprintf("GRANT %s TO %s", quoteIdentifiers(g.Roles),
+			QuoteIdentifier(g.User)))
+	}
+
+	return statements
+}
+
+// missing returns the values which aren't in wanted. SQL keywords are case insensitive, so the values are
+// compared regardless of case.
+func missing(values, wanted []string) []string {
+	var result []string
+	for _, v := range values {
+		found := false
+		for _, w := range wanted {
+			if strings.EqualFold(v, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// quoteIdentifiers quotes the identifiers and joins them into a list.
+func quoteIdentifiers(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, QuoteIdentifier(name))
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestCreateUserStatement(t *testing.T) {
+	assert.Equal(t, `CREATE USER IF NOT EXISTS "app"`, security.CreateUserStatement("app"))
+	assert.Equal(t, `CREATE USER IF NOT EXISTS "a""; DROP DATABASE x; --"`,
+		security.CreateUserStatement(`a"; DROP DATABASE x; --`))
+}
+
+func TestAlterPasswordStatement(t *testing.T) {
+	assert.Equal(t, `ALTER USER "root" WITH PASSWORD 'secret'`, security.AlterPasswordStatement("root", "secret"))
+	assert.Equal(t, `ALTER USER "root" WITH PASSWORD 'a''; DROP DATABASE x; --'`,
+		security.AlterPasswordStatement("root", "a'; DROP DATABASE x; --"))
+}
+
+func TestGeneratePassword(t *testing.T) {
+	p1, err := security.GeneratePassword()
+	assert.NoError(t, err)
+	p2, err := security.GeneratePassword()
+	assert.NoError(t, err)
+
+	assert.Len(t, p1, 43)
+	assert.NotEqual(t, p1, p2)
+}
+
+func TestGrantStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		grant    security.Grant
+		current  security.Grant
+		expected []string
+	}{
+		{
+			name:  "database privileges",
+			grant: security.Grant{User: "app", Database: "app_db", Privileges: []string{"SELECT", "INSERT"}},
+			expected: []string{
+				`GRANT SELECT, INSERT ON DATABASE "app_db" TO "app"`,
+			},
+		},
+		{
+			name:  "database privileges and roles",
+			grant: security.Grant{User: "app", Database: "app_db", Privileges: []string{"ALL"}, Roles: []string{"admin", "reporting"}},
+			expected: []string{
+				`GRANT ALL ON DATABASE "app_db" TO "app"`,
+				`GRANT "admin", "reporting" TO "app"`,
+			},
+		},
+		{
+			name:  "database without privileges",
+			grant: security.Grant{User: "app", Database: "app_db"},
+		},
+		{
+			name:    "current privileges and roles",
+			grant:   security.Grant{User: "app", Database: "app_db", Privileges: []string{"SELECT"}, Roles: []string{"reporting"}},
+			current: security.Grant{User: "app", Database: "app_db", Privileges: []string{"select"}, Roles: []string{"reporting"}},
+			expected: []string{
+				`GRANT SELECT ON DATABASE "app_db" TO "app"`,
+				`GRANT "reporting" TO "app"`,
+			},
+		},
+		{
+			name:    "removed privilege",
+			grant:   security.Grant{User: "app", Database: "app_db", Privileges: []string{"SELECT"}},
+			current: security.Grant{User: "app", Database: "app_db", Privileges: []string{"SELECT", "INSERT"}},
+			expected: []string{
+				`REVOKE INSERT ON DATABASE "app_db" FROM "app"`,
+				`GRANT SELECT ON DATABASE "app_db" TO "app"`,
+			},
+		},
+		{
+			name:    "all privileges removed",
+			grant:   security.Grant{User: "app", Database: "app_db"},
+			current: security.Grant{User: "app", Database: "app_db", Privileges: []string{"ALL"}},
+			expected: []string{
+				`REVOKE ALL ON DATABASE "app_db" FROM "app"`,
+			},
+		},
+		{
+			name:    "removed role",
+			grant:   security.Grant{User: "app", Roles: []string{"reporting"}},
+			current: security.Grant{User: "app", Roles: []string{"admin", "reporting"}},
+			expected: []string{
+				`REVOKE "admin" FROM "app"`,
+				`GRANT "reporting" TO "app"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, security.GrantStatements(tt.grant, tt.current))
+		})
+	}
+}
+
+func TestParseRows(t *testing.T) {
+	rows, err := security.ParseRows([]byte("database_name,grantee,privilege_type\napp_db,app,SELECT\napp_db,app,INSERT\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"database_name": "app_db", "grantee": "app", "privilege_type": "SELECT"},
+		{"database_name": "app_db", "grantee": "app", "privilege_type": "INSERT"},
+	}, rows)
+
+	rows, err = security.ParseRows(nil)
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+
+	_, err = security.ParseRows([]byte("a,b\n\"unterminated\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TenantsOU is the organizational unit of tenant client certificates. CockroachDB only accepts a client
+// certificate as the identity of a SQL tenant when it is part of this unit, the tenant ID being the common
+// name.
+const TenantsOU = "Tenants"
+
+// CreateTenantClientCert creates an RSA key, taken from keys if not nil, and the client certificate of the SQL tenant, signed by the
+// CA. The SQL pods of the tenant also serve SQL clients with it, so the certificate is valid for client and
+// server auth, for the hosts. It returns the PEM encoded certificate and key.
+func CreateTenantClientCert(caCert *x509.Certificate, caKey crypto.Signer, keys *KeyPool, keySize int,
+	now time.Time, lifetime time.Duration, tenantID uint64, hosts []string) ([]byte, []byte, error) {
+
+	// the system tenant is the KV layer itself and uses the node certificate
+	if tenantID < 2 {
+		return nil, nil, fmt.Errorf("invalid tenant ID %d, secondary tenants start at 2", tenantID)
+	}
+
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization:       []string{"Cockroach"},
+			OrganizationalUnit: []string{TenantsOU},
+			CommonName:         strconv.FormatUint(tenantID, 10),
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	return createLeafCert(caCert, caKey, keys, RSAAlgorithm, keySize, now, lifetime, template, hosts, nil)
+}
+
+// TenantID returns the ID of the SQL tenant of a tenant client certificate.
+func TenantID(cert *x509.Certificate) (uint64, error) {
+	isTenant := false
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == TenantsOU {
+			isTenant = true
+		}
+	}
+
+	if !isTenant {
+		return 0, fmt.Errorf("certificate of %s isn't a tenant client certificate", cert.Subject.CommonName)
+	}
+
+	id, err := strconv.ParseUint(cert.Subject.CommonName, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tenant ID %q: %s", cert.Subject.CommonName, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+func TestCreateTenantClientCert(t *testing.T) {
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+
+	pemCert, _, err := security.CreateTenantClientCert(caCert, caKey, nil, 2048, time.Now(), defaultCertLifetime, 10,
+		[]string{"tenant-10-sql", "127.0.0.1"})
+	require.NoError(t, err)
+
+	cert, err := security.GetCertObj(pemCert)
+	require.NoError(t, err)
+	assert.Equal(t, "10", cert.Subject.CommonName)
+	assert.Equal(t, []string{security.TenantsOU}, cert.Subject.OrganizationalUnit)
+	assert.Equal(t, []string{"tenant-10-sql"}, cert.DNSNames)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+
+	id, err := security.TenantID(cert)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), id)
+
+	_, _, err = security.CreateTenantClientCert(caCert, caKey, nil, 2048, time.Now(), defaultCertLifetime, 1, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testcerts_test
+
+import (
+	"crypto"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+func TestFixtures(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	pairs := map[string][2]string{
+		"ca":            {testcerts.CACert, testcerts.CAKey},
+		"other ca":      {testcerts.OtherCACert, testcerts.OtherCAKey},
+		"node":          {testcerts.NodeCert, testcerts.NodeKey},
+		"client":        {testcerts.ClientCert, testcerts.ClientKey},
+		"expired":       {testcerts.ExpiredCert, testcerts.ExpiredKey},
+		"not yet valid": {testcerts.NotYetValidCert, testcerts.NotYetValidKey},
+		"untrusted":     {testcerts.UntrustedCert, testcerts.UntrustedKey},
+	}
+	for name, pair := range pairs {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, keyMatches(t, pair[0], pair[1]))
+			assert.False(t, keyMatches(t, pair[0], testcerts.MismatchedKey))
+		})
+	}
+
+	ca := []byte(testcerts.CACert)
+	for _, cert := range []string{testcerts.NodeCert, testcerts.ClientCert} {
+		assert.NoError(t, security.VerifyChain(certChain(t, cert), ca, now))
+	}
+	assert.Error(t, security.VerifyChain(certChain(t, testcerts.ExpiredCert), ca, now))
+	assert.Error(t, security.VerifyChain(certChain(t, testcerts.NotYetValidCert), ca, now))
+	assert.Error(t, security.VerifyChain(certChain(t, testcerts.UntrustedCert), ca, now))
+}
+
+func keyMatches(t *testing.T, cert, key string) bool {
+	c, err := security.GetCertObj([]byte(cert))
+	require.NoError(t, err)
+	k, err := security.ParsePrivateKey([]byte(key))
+	require.NoError(t, err)
+
+	return k.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(c.PublicKey)
+}
+
+func certChain(t *testing.T, cert string) []*x509.Certificate {
+	c, err := security.GetCertObj([]byte(cert))
+	require.NoError(t, err)
+
+	return []*x509.Certificate{c}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// PeerCertificates dials addr over TLS and returns the certificate chain presented by the server.
+// The chain is not verified here, so that an invalid chain can be inspected and reported.
+func PeerCertificates(addr string, timeout time.Duration) ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		// the chain is verified by the caller against the CA of the cluster
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate presented by %s", addr)
+	}
+
+	return certs, nil
+}
+
+// VerifyChain verifies that the leaf of the chain is signed by one of the CA certificates in the
+// PEM bundle and is valid at the given time.
+func VerifyChain(chain []*x509.Certificate, caBundle []byte, now time.Time) error {
+	if len(chain) == 0 {
+		return errors.New("empty certificate chain")
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return errors.New("failed to parse CA bundle")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	return err
+}
+
+// VerifyPair checks that the PEM encoded certificate matches the key, is issued to the common name and is
+// signed by one of the CA certificates in the PEM bundle, at the given time.
+func VerifyPair(pemCert, pemKey, caBundle []byte, commonName string, now time.Time) error {
+	pair, err := tls.X509KeyPair(pemCert, pemKey)
+	if err != nil {
+		return fmt.Errorf("invalid certificate and key: %s", err)
+	}
+
+	var chain []*x509.Certificate
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, cert)
+	}
+
+	if chain[0].Subject.CommonName != commonName {
+		return fmt.Errorf("certificate is issued to %q instead of %q", chain[0].Subject.CommonName, commonName)
+	}
+
+	return VerifyChain(chain, caBundle, now)
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestPeerCertificatesAndVerifyChain(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	chain, err := security.PeerCertificates(ts.Listener.Addr().String(), time.Second)
+	require.NoError(t, err)
+
+	// the test server certificate is self-signed, so it acts as its own CA
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	assert.NoError(t, security.VerifyChain(chain, ca, time.Now()))
+	assert.Error(t, security.VerifyChain(chain, ca, ts.Certificate().NotAfter.Add(time.Hour)))
+	assert.Error(t, security.VerifyChain(chain, []byte("not a CA"), time.Now()))
+}
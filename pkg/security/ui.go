@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// UICert and UIKey are the names of the files CockroachDB serves the DB Console with, instead of the node
+// certificate, when they exist in its certs directory.
+const (
+	UICert = "ui.crt"
+	UIKey  = "ui.key"
+)
+
+// CreateUIPair creates a DB Console key, taken from keys if not nil, and a certificate for the hosts, signed by the CA. The cockroach CLI
+// doesn't create DB Console certificates, so the certificate is built natively. The profile, if set,
+// overrides the key usages of the certificate.
+func CreateUIPair(certsDir, caKeyPath string, keys *KeyPool, keySize int,
+	now time.Time, lifetime time.Duration, hosts []string,
+	profile *Profile) error {
+	if len(caKeyPath) == 0 {
+		return errors.New("the path to the CA key is required")
+	}
+
+	if len(certsDir) == 0 {
+		return errors.New("the path to the certs directory is required")
+	}
+
+	caCert, caKey, err := loadCAPair(filepath.Join(certsDir, "ca.crt"), caKeyPath)
+	if err != nil {
+		return err
+	}
+
+	pemCert, pemKey, err := createServerCert(caCert, caKey, keys, keySize, now, lifetime, hosts, profile)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(certsDir, UICert), pemCert, CertFileMode); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(certsDir, UIKey), pemKey, KeyFileMode)
+}
+
+// CreateServerCert creates an RSA key, taken from keys if not nil, and a certificate for the hosts, valid for
+// server auth only, signed by the CA. It returns the PEM encoded certificate and key.
+func CreateServerCert(caCert *x509.Certificate, caKey crypto.Signer, keys *KeyPool, keySize int,
+	now time.Time, lifetime time.Duration, hosts []string) ([]byte, []byte, error) {
+
+	return createServerCert(caCert, caKey, keys, keySize, now, lifetime, hosts, nil)
+}
+
+func createServerCert(caCert *x509.Certificate, caKey crypto.Signer, keys *KeyPool, keySize int,
+	now time.Time, lifetime time.Duration, hosts []string, profile *Profile) ([]byte, []byte, error) {
+
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("at least one host is required")
+	}
+
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"Cockroach"},
+			CommonName:   hosts[0],
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	return createLeafCert(caCert, caKey, keys, RSAAlgorithm, keySize, now, lifetime, template, hosts, profile)
+}
+
+// createLeafCert creates a key of the algorithm and signs the certificate of the template for it with the CA,
+// for the hosts. The serial number and the validity of the template, from now for the lifetime, are set here,
+// and the profile, if set, overrides its key usages. It returns the PEM encoded certificate and key.
+func createLeafCert(caCert *x509.Certificate, caKey crypto.Signer, keys *KeyPool, algorithm string,
+	keySize int, now time.Time, lifetime time.Duration, template *x509.Certificate, hosts []string,
+	profile *Profile) ([]byte, []byte, error) {
+
+	key, pemKey, err := keys.GenerateKey(algorithm, keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pemCert, err := signLeafCert(caCert, caKey, key, now, lifetime, template, hosts, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pemCert, pemKey, nil
+}
+
+// signLeafCert signs the certificate of the template for the key with the CA, like createLeafCert. It
+// returns the PEM encoded certificate.
+func signLeafCert(caCert *x509.Certificate, caKey crypto.Signer, key crypto.Signer, now time.Time,
+	lifetime time.Duration, template *x509.Certificate, hosts []string, profile *Profile) ([]byte, error) {
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+
+	notAfter := now.Add(lifetime)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	template.SerialNumber = serial
+	template.NotBefore = now.Add(-validFromBackdate)
+	template.NotAfter = notAfter
+	template.BasicConstraintsValid = true
+
+	// key encipherment only applies to RSA keys
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	profile.apply(template)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate of %s: %s", template.Subject.CommonName, err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+func TestCreateUIPair(t *testing.T) {
+	certsDir, cleanup := tempDir(t)
+	defer cleanup()
+	caKey := filepath.Join(certsDir, "ca.key")
+	writeTestCA(t, certsDir, caKey)
+
+	hosts := []string{"crdb.example.com", "crdb-public", "127.0.0.1"}
+	require.NoError(t, security.CreateUIPair(certsDir, caKey, nil, 2048, time.Now(), defaultCertLifetime, hosts, nil))
+
+	pemCert, err := ioutil.ReadFile(filepath.Join(certsDir, security.UICert))
+	require.NoError(t, err)
+
+	cert, err := security.GetCertObj(pemCert)
+	require.NoError(t, err)
+	assert.Equal(t, "crdb.example.com", cert.Subject.CommonName)
+	assert.Equal(t, []string{"crdb.example.com", "crdb-public"}, cert.DNSNames)
+	assert.Len(t, cert.IPAddresses, 1)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+
+	_, err = ioutil.ReadFile(filepath.Join(certsDir, security.UIKey))
+	require.NoError(t, err)
+
+	assert.Error(t, security.CreateUIPair(certsDir, caKey, nil, 2048, time.Now(), defaultCertLifetime, nil, nil))
+}
+
+func TestCreateUIPairAt(t *testing.T) {
+	certsDir, cleanup := tempDir(t)
+	defer cleanup()
+	caKey := filepath.Join(certsDir, "ca.key")
+	writeTestCA(t, certsDir, caKey)
+
+	// the certificate is valid from the given time, backdated for clock skews
+	now := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	require.NoError(t, security.CreateUIPair(certsDir, caKey, nil, 2048, now, defaultCertLifetime, []string{"crdb-public"},
+		nil))
+
+	pemCert, err := ioutil.ReadFile(filepath.Join(certsDir, security.UICert))
+	require.NoError(t, err)
+	cert, err := security.GetCertObj(pemCert)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-time.Hour).UTC(), cert.NotBefore.UTC())
+	assert.Equal(t, now.Add(defaultCertLifetime).UTC(), cert.NotAfter.UTC())
+}
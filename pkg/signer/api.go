@@ -0,0 +1,383 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/oidc"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative api.proto
+
+// ErrPermissionDenied is returned when the caller or the request is not allowed.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// API implements the Signer service of api.proto, so that platforms can issue and rotate certificates
+// programmatically. Callers authenticate with a client certificate signed by the CallerCA, or the CA.
+type API struct {
+	UnimplementedSignerServer
+
+	// CA is the resource of the namespace holding the CA secret.
+	CA           resource.Resource
+	CASecretName string
+	// Callers are the common names of the client certificates allowed to call the API. They are never issued
+	// through the API.
+	Callers []string
+	// CallerCA is the PEM encoded bundle the client certificates of the callers are verified against, so that
+	// no certificate signed by the CA authenticates a caller. The CA if empty.
+	CallerCA []byte
+	// Policy restricts the users of the client certificates. Its requesters are not used, as the callers
+	// are authenticated by their certificate.
+	Policy         Policy
+	ClientDuration time.Duration
+	// Receipts makes IssueClientCert and IssueUserCert return a Receipt of the certificate signed by the receipt
+	// key.
+	Receipts bool
+	// ReceiptKeySecretName is the secret holding the receipt key and its certificate issued by the CA, in the
+	// namespace of the CA secret. The key is generated on first use, so that the CA key never signs receipts.
+	ReceiptKeySecretName string
+	// Rotate rotates the node certificate and returns the name of the secret holding it.
+	Rotate func(ctx context.Context) (string, error)
+
+	// OIDC verifies the ID tokens of the humans requesting a certificate of their own SQL user. IssueUserCert
+	// is disabled if nil.
+	OIDC        *oidc.Verifier
+	UserMapping oidc.UserMapping
+	// UserDuration is the lifetime of the certificates issued to humans.
+	UserDuration time.Duration
+	// GrantRoles creates the SQL user of a human and grants it the roles mapped from its groups.
+	GrantRoles func(ctx context.Context, user string, roles []string) error
+
+	// Clock is the time the certificates are signed at, the real time if nil.
+	Clock clock.Clock
+
+	// receiptMu serializes the writes of the receipt key secret.
+	receiptMu sync.Mutex
+}
+
+// IssueClientCert signs the certificate request of a SQL user allowed by the policy. The reserved users and
+// the callers are never issued, so that a caller can't impersonate another one.
+func (a *API) IssueClientCert(ctx context.Context, in *IssueClientCertRequest) (*IssueClientCertResponse, error) {
+	if contains(reservedUsers, in.User) || contains(a.Callers, in.User) {
+		return nil, errors.Wrapf(ErrPermissionDenied, "SQL user %s can't be issued through the API", in.User)
+	}
+
+	req, err := security.ParseCSR(in.Csr)
+	if err != nil {
+		return nil, err
+	}
+	if req.Subject.CommonName != in.User {
+		return nil, errors.Wrapf(ErrPermissionDenied, "the common name of the request is not %s", in.User)
+	}
+	if err := a.Policy.checkClient(req); err != nil {
+		return nil, errors.Wrap(ErrPermissionDenied, err.Error())
+	}
+
+	ca, caCert, caKey, err := a.loadCA()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := security.SignCSR(req, caCert, caKey, a.now(), a.ClientDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt, err := a.receipt(cert, ca, caCert, caKey, callerFrom(ctx), AuthnMTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Issued client certificate of %s through the API", in.User)
+	return &IssueClientCertResponse{Certificate: cert, Ca: ca.CA(), Receipt: receipt}, nil
+}
+
+// reservedUsers are the SQL users never issued through the API.
+var reservedUsers = []string{security.RootUser, security.NodeUser, "admin", "public"}
+
+// IssueUserCert issues a short-lived client certificate of the SQL user of the human authenticated by the
+// ID token, with the key of the certificate request. The SQL user is created and granted the roles of its
+// groups first. The users of the policy are shared by services and the callers authenticate to the API, so
+// they are never issued to humans.
+func (a *API) IssueUserCert(ctx context.Context, in *IssueUserCertRequest) (*IssueUserCertResponse, error) {
+	if a.OIDC == nil {
+		return nil, errors.Wrap(ErrPermissionDenied, "OIDC authentication is not enabled")
+	}
+
+	claims, err := a.OIDC.Verify(ctx, in.IdToken)
+	if err != nil {
+		return nil, errors.Wrap(ErrPermissionDenied, err.Error())
+	}
+
+	user, roles, err := a.UserMapping.Map(claims)
+	if err != nil {
+		return nil, errors.Wrap(ErrPermissionDenied, err.Error())
+	}
+	if contains(reservedUsers, user) || contains(a.Callers, user) || contains(a.Policy.ClientUsers, user) ||
+		a.Policy.principal(user) {
+		return nil, errors.Wrapf(ErrPermissionDenied, "SQL user %s can't be issued to humans", user)
+	}
+
+	req, err := security.ParseCSR(in.Csr)
+	if err != nil {
+		return nil, err
+	}
+	// the certificate is issued to the user of the token, whatever the request asks for
+	req.Subject = pkix.Name{CommonName: user}
+	req.DNSNames, req.IPAddresses, req.EmailAddresses, req.URIs = nil, nil, nil, nil
+
+	if a.GrantRoles != nil {
+		if err := a.GrantRoles(ctx, user, roles); err != nil {
+			return nil, err
+		}
+	}
+
+	ca, caCert, caKey, err := a.loadCA()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := security.SignCSR(req, caCert, caKey, a.now(), a.UserDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt, err := a.receipt(cert, ca, caCert, caKey, claims.String("sub"), AuthnOIDC)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Issued client certificate of %s with roles %v to %s through OIDC", user, roles, claims.String("sub"))
+	return &IssueUserCertResponse{User: user, Roles: roles, Certificate: cert, Ca: ca.CA(), Receipt: receipt}, nil
+}
+
+// receipt returns the receipt of the certificate issued to the caller signed by the receipt key, or an empty
+// string if the receipts are disabled.
+func (a *API) receipt(cert []byte, ca *resource.TLSSecret, caCert *x509.Certificate, caKey crypto.Signer,
+	caller, authn string) (string, error) {
+	if !a.Receipts {
+		return "", nil
+	}
+
+	r, err := NewReceipt(cert, caller, authn)
+	if err != nil {
+		return "", err
+	}
+
+	keyCert, key, err := a.receiptKey(ca, caCert, caKey)
+	if err != nil {
+		return "", err
+	}
+	return r.Sign(keyCert, key)
+}
+
+// receiptKeyLifetime is the lifetime of the certificates of the receipt key. They never outlive the CA.
+const receiptKeyLifetime = 365 * 24 * time.Hour
+
+// receiptKey returns the receipt key and its certificate, kept in the receipt key secret.
+func (a *API) receiptKey(ca *resource.TLSSecret, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
+	a.receiptMu.Lock()
+	defer a.receiptMu.Unlock()
+
+	now := a.now()
+	return resource.LoadSigningKey(a.ReceiptKeySecretName, a.CA, ca.CA(), ReceiptIssuer, now,
+		func(key crypto.Signer) ([]byte, error) {
+			return security.CreateSigningCert(caCert, caKey, key, ReceiptIssuer, now, receiptKeyLifetime)
+		})
+}
+
+// now returns the current time of the Clock.
+func (a *API) now() time.Time {
+	if a.Clock == nil {
+		return clock.Real.Now()
+	}
+	return a.Clock.Now()
+}
+
+// callerKey is the context key of the common name of the authenticated caller.
+type callerKey struct{}
+
+// callerFrom returns the common name of the caller authenticated by its client certificate, if any.
+func callerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// RotateNodeCert rotates the node certificate, whether or not it is about to expire.
+func (a *API) RotateNodeCert(ctx context.Context, _ *RotateNodeCertRequest) (*RotateNodeCertResponse, error) {
+	if a.Rotate == nil {
+		return nil, errors.Wrap(ErrPermissionDenied, "node certificate rotation is not enabled")
+	}
+
+	secret, err := a.Rotate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Rotated node certificate in secret [%s] through the API", secret)
+	return &RotateNodeCertResponse{Secret: secret}, nil
+}
+
+// GetCAStatus returns the CA bundle and the lifetime of the CA certificate matching the CA key.
+func (a *API) GetCAStatus(ctx context.Context, _ *GetCAStatusRequest) (*GetCAStatusResponse, error) {
+	ca, caCert, _, err := a.loadCA()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(caCert.Raw)
+	return &GetCAStatusResponse{
+		Ca:          ca.CA(),
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotBefore:   timestamppb.New(caCert.NotBefore),
+		NotAfter:    timestamppb.New(caCert.NotAfter),
+	}, nil
+}
+
+// loadCA reads the CA secret, so that a rotated CA is picked up.
+func (a *API) loadCA() (*resource.TLSSecret, *x509.Certificate, crypto.Signer, error) {
+	ca, err := resource.LoadTLSSecret(a.CASecretName, a.CA)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "failed to get CA secret [%s]", a.CASecretName)
+	}
+
+	caCert, caKey, err := security.ParseCAPair(ca.CA(), ca.CAKey())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ca, caCert, caKey, nil
+}
+
+// Server returns the gRPC server of the API, serving it over TLS with the config. The RPCs other than
+// IssueUserCert only accept the callers whose verified client certificate has one of the allowed common names.
+func (a *API) Server(tlsConfig *tls.Config) *grpc.Server {
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)), grpc.UnaryInterceptor(a.intercept))
+	RegisterSignerServer(server, a)
+	return server
+}
+
+// intercept authenticates the caller of the RPC and maps the errors of the RPCs to gRPC status codes.
+func (a *API) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	// humans authenticate with their ID token instead of a client certificate
+	caller := "OIDC user"
+	if info.FullMethod != Signer_IssueUserCert_FullMethodName {
+		var state *tls.ConnectionState
+		if p, ok := peer.FromContext(ctx); ok {
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				state = &tlsInfo.State
+			}
+		}
+
+		var err error
+		if caller, err = a.authenticate(state); err != nil {
+			logrus.Warnf("Denying API call %s: %s", info.FullMethod, err)
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		ctx = context.WithValue(ctx, callerKey{}, caller)
+	}
+
+	logrus.Infof("API call %s by %s", info.FullMethod, caller)
+	resp, err := handler(ctx, req)
+	switch {
+	case errors.Is(err, ErrPermissionDenied):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		logrus.Errorf("API call %s by %s failed: %s", info.FullMethod, caller, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+// authenticate returns the common name of the verified client certificate of the connection.
+func (a *API) authenticate(state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return "", errors.New("a client certificate signed by the caller CA is required")
+	}
+
+	caller := state.VerifiedChains[0][0].Subject.CommonName
+	if !contains(a.Callers, caller) {
+		return "", errors.Errorf("%s is not allowed to call the API", caller)
+	}
+	return caller, nil
+}
+
+// TLSConfig returns the config of the API server: it serves a certificate for the hosts issued by the CA,
+// and requires client certificates signed by the CallerCA, or the CA. The certificate is issued once, so the
+// server has to be restarted after the CA is rotated.
+func (a *API) TLSConfig(hosts []string, lifetime time.Duration) (*tls.Config, error) {
+	ca, caCert, caKey, err := a.loadCA()
+	if err != nil {
+		return nil, err
+	}
+
+	pemCert, pemKey, err := security.CreateServerCert(caCert, caKey, nil, 2048, a.now(), lifetime, hosts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to issue the API server certificate")
+	}
+
+	cert, err := tls.X509KeyPair(append(pemCert, ca.CA()...), pemKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if len(a.CallerCA) > 0 {
+		if !pool.AppendCertsFromPEM(a.CallerCA) {
+			return nil, errors.New("the caller CA bundle holds no valid CA certificate")
+		}
+	} else if !pool.AppendCertsFromPEM(ca.CA()) {
+		return nil, errors.New("the CA secret holds no valid CA certificate")
+	}
+
+	// the RPCs requiring a client certificate check it themselves when humans may call the API
+	clientAuth := tls.RequireAndVerifyClientCert
+	if a.OIDC != nil {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+		// gRPC runs over HTTP/2
+		NextProtos: []string{"h2"},
+	}, nil
+}
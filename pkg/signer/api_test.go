@@ -0,0 +1,308 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cockroachdb/helm-charts/pkg/oidc"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+// apiClient returns a client of the API authenticated by a certificate of the common name, signed by the
+// test CA.
+func apiClient(t *testing.T, address, commonName string) signer.SignerClient {
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+	return apiClientOf(t, address, commonName, caCert, caKey)
+}
+
+// apiClientOf returns a client of the API authenticated by a certificate of the common name, signed by the CA.
+func apiClientOf(t *testing.T, address, commonName string, caCert *x509.Certificate, caKey crypto.Signer) signer.SignerClient {
+	conn, err := signer.DialAPI(address, callerTLSConfig(t, commonName, caCert, caKey), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return signer.NewSignerClient(conn)
+}
+
+// callerTLSConfig returns the TLS config of a caller with a certificate of the common name, signed by the CA.
+func callerTLSConfig(t *testing.T, commonName string, caCert *x509.Certificate, caKey crypto.Signer) *tls.Config {
+	key, pemKey, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	require.NoError(t, err)
+	pemCSR, err := security.CreateCSR(key, commonName, nil)
+	require.NoError(t, err)
+	req, err := security.ParseCSR(pemCSR)
+	require.NoError(t, err)
+	pemCert, err := security.SignCSR(req, caCert, caKey, time.Now(), time.Hour)
+	require.NoError(t, err)
+
+	cert, err := tls.X509KeyPair(pemCert, pemKey)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(testcerts.CACert))
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}
+}
+
+// newAPIServer serves the API over TLS and returns its address.
+func newAPIServer(t *testing.T, api *signer.API) string {
+	tlsConfig, err := api.TLSConfig([]string{"127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := api.Server(tlsConfig)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+// denied returns whether the call was denied by the API.
+func denied(err error) bool {
+	return status.Code(err) == codes.PermissionDenied
+}
+
+func TestAPI(t *testing.T) {
+	s, _ := newSigner(t)
+	policy := s.Policy
+	policy.ClientUsers = []string{"app", security.RootUser, "platform"}
+	api := &signer.API{
+		CA:             s.CA,
+		CASecretName:   s.CASecretName,
+		Callers:        []string{"platform"},
+		Policy:         policy,
+		ClientDuration: time.Hour,
+		Receipts:       true,
+
+		ReceiptKeySecretName: "receipt-key-secret",
+	}
+
+	address := newAPIServer(t, api)
+	platform := apiClient(t, address, "platform")
+	ctx := context.TODO()
+
+	caStatus, err := platform.GetCAStatus(ctx, &signer.GetCAStatusRequest{})
+	require.NoError(t, err)
+	fingerprint, err := security.Fingerprint([]byte(testcerts.CACert))
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, caStatus.Fingerprint)
+	caCert, err := security.GetCertObj([]byte(testcerts.CACert))
+	require.NoError(t, err)
+	assert.True(t, caCert.NotBefore.Equal(caStatus.NotBefore.AsTime()))
+	assert.True(t, caCert.NotAfter.Equal(caStatus.NotAfter.AsTime()))
+
+	key, pemKey, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	require.NoError(t, err)
+	csr, err := security.CreateCSR(key, "app", nil)
+	require.NoError(t, err)
+
+	issued, err := platform.IssueClientCert(ctx, &signer.IssueClientCertRequest{User: "app", Csr: csr})
+	require.NoError(t, err)
+	require.NoError(t, security.VerifyPair(issued.Certificate, pemKey, issued.Ca, "app", time.Now()))
+
+	// the receipt binds the certificate to the caller
+	receipt, err := signer.VerifyReceipt(issued.Receipt, issued.Ca)
+	require.NoError(t, err)
+	assert.Equal(t, "platform", receipt.Subject)
+	assert.Equal(t, signer.AuthnMTLS, receipt.Authn)
+	assert.Equal(t, "app", receipt.User)
+	cert, err := security.GetCertObj(issued.Certificate)
+	require.NoError(t, err)
+	assert.Equal(t, cert.SerialNumber.Text(16), receipt.Serial)
+
+	// the receipts are signed by the receipt key, generated once and certified by the CA
+	keySecret, err := resource.LoadTLSSecret("receipt-key-secret", s.CA)
+	require.NoError(t, err)
+	keyCert, err := security.GetCertObj(keySecret.TLSCert())
+	require.NoError(t, err)
+	assert.Equal(t, signer.ReceiptIssuer, keyCert.Subject.CommonName)
+	assert.NotEqual(t, testcerts.CAKey, string(keySecret.TLSPrivateKey()))
+
+	issued, err = platform.IssueClientCert(ctx, &signer.IssueClientCertRequest{User: "app", Csr: csr})
+	require.NoError(t, err)
+	_, err = signer.VerifyReceipt(issued.Receipt, issued.Ca)
+	require.NoError(t, err)
+	reloaded, err := resource.LoadTLSSecret("receipt-key-secret", s.CA)
+	require.NoError(t, err)
+	assert.Equal(t, keySecret.Secret().ResourceVersion, reloaded.Secret().ResourceVersion)
+
+	// users not allowed by the policy, and callers not allowed, are denied
+	csr, err = security.CreateCSR(key, "admin", nil)
+	require.NoError(t, err)
+	_, err = platform.IssueClientCert(ctx, &signer.IssueClientCertRequest{User: "admin", Csr: csr})
+	assert.True(t, denied(err))
+	_, err = apiClient(t, address, "other").GetCAStatus(ctx, &signer.GetCAStatusRequest{})
+	assert.True(t, denied(err))
+	_, err = platform.RotateNodeCert(ctx, &signer.RotateNodeCertRequest{})
+	assert.True(t, denied(err))
+
+	// the reserved users and the callers are never issued, even if the policy allows them
+	for _, user := range []string{security.RootUser, "platform"} {
+		csr, err = security.CreateCSR(key, user, nil)
+		require.NoError(t, err)
+		_, err = platform.IssueClientCert(ctx, &signer.IssueClientCertRequest{User: user, Csr: csr})
+		assert.True(t, denied(err), user)
+	}
+}
+
+func TestAPICallerCA(t *testing.T) {
+	s, _ := newSigner(t)
+	callerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	callerCert, callerCA := selfSignedCA(t, callerKey)
+
+	api := &signer.API{CA: s.CA, CASecretName: s.CASecretName, Callers: []string{"platform"}, CallerCA: callerCA}
+	address := newAPIServer(t, api)
+
+	_, err = apiClientOf(t, address, "platform", callerCert, callerKey).GetCAStatus(context.TODO(),
+		&signer.GetCAStatusRequest{})
+	assert.NoError(t, err)
+
+	// the certificates signed by the cluster CA don't authenticate callers
+	_, err = apiClient(t, address, "platform").GetCAStatus(context.TODO(), &signer.GetCAStatusRequest{})
+	assert.Error(t, err)
+}
+
+// oidcVerifier returns a verifier of the ID tokens signed by the returned function.
+func oidcVerifier(t *testing.T) (*oidc.Verifier, func(email string) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+
+	provider := &oidc.Provider{Issuer: "https://idp", JWKSURI: server.URL, HTTPClient: http.DefaultClient}
+	sign := func(email string) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+		payload, _ := json.Marshal(map[string]interface{}{
+			"iss": provider.Issuer, "aud": "crdb", "sub": email, "email": email, "exp": time.Now().Add(time.Hour).Unix(),
+		})
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	return &oidc.Verifier{Provider: provider, ClientID: "crdb"}, sign
+}
+
+func TestIssueUserCertDeniesCallers(t *testing.T) {
+	s, _ := newSigner(t)
+	verifier, sign := oidcVerifier(t)
+	api := &signer.API{
+		CA:           s.CA,
+		CASecretName: s.CASecretName,
+		Callers:      []string{"platform"},
+		OIDC:         verifier,
+		UserDuration: time.Hour,
+	}
+
+	key, _, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	require.NoError(t, err)
+	csr, err := security.CreateCSR(key, "ignored", nil)
+	require.NoError(t, err)
+
+	issued, err := api.IssueUserCert(context.TODO(), &signer.IssueUserCertRequest{IdToken: sign("jane@example.com"), Csr: csr})
+	require.NoError(t, err)
+	assert.Equal(t, "jane", issued.User)
+
+	// a human whose SQL user is the common name of an API caller would be able to call the API
+	_, err = api.IssueUserCert(context.TODO(), &signer.IssueUserCertRequest{IdToken: sign("platform@example.com"), Csr: csr})
+	assert.True(t, errors.Is(err, signer.ErrPermissionDenied))
+}
+
+func TestDialAPIThroughProxy(t *testing.T) {
+	s, _ := newSigner(t)
+	api := &signer.API{CA: s.CA, CASecretName: s.CASecretName, Callers: []string{"platform"}}
+	address := newAPIServer(t, api)
+
+	// the proxy tunnels the connections it is asked to CONNECT to
+	var tunneled string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		tunneled = r.Host
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		_, _ = io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	t.Cleanup(proxy.Close)
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+	conn, err := signer.DialAPI(address, callerTLSConfig(t, "platform", caCert, caKey), http.ProxyURL(proxyURL))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	_, err = signer.NewSignerClient(conn).GetCAStatus(ctx, &signer.GetCAStatusRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, address, tunneled)
+}
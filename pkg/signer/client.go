@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// DialAPI returns a connection to the signer API at the address, host:port, verified with the TLS config. The
+// connection goes through the proxy returned by proxy for the address, if any, like the HTTP clients of
+// pkg/proxy. Calls are made with NewSignerClient, and the connection has to be closed once done.
+func DialAPI(address string, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) (*grpc.ClientConn, error) {
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialProxy(ctx, addr, proxy)
+	}
+	return grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), grpc.WithContextDialer(dial))
+}
+
+// dialProxy connects to the address, tunneling through the proxy with CONNECT if one is set for it.
+func dialProxy(ctx context.Context, address string, proxy func(*http.Request) (*url.URL, error)) (net.Conn, error) {
+	var dialer net.Dialer
+	if proxy == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	proxyURL, err := proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to proxy %s", proxyURL.Host)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to write CONNECT request")
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to read CONNECT response")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, address, resp.Status)
+	}
+	// the proxy doesn't send anything before the TLS handshake, so nothing is buffered
+	if reader.Buffered() > 0 {
+		conn.Close()
+		return nil, errors.New("unexpected data after the CONNECT response")
+	}
+	return conn, nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// PodCert keeps the node certificate of a pod in a local certs directory. The private key is generated
+// in the pod and only its certificate request is sent to the signer, so it never leaves the pod.
+type PodCert struct {
+	Client   kubernetes.Interface
+	CertsDir string
+	// Name prefixes the names of the certificate signing requests.
+	Name    string
+	Hosts   []string
+	KeySize int
+	// RenewBefore is the time before expiry at which the certificate is requested again.
+	RenewBefore  time.Duration
+	Timeout      time.Duration
+	PollInterval time.Duration
+	// Clock is the time the certificate is checked at, the real time if nil.
+	Clock clock.Clock
+}
+
+// Ensure requests a node certificate, unless the certs directory already holds one which doesn't expire
+// within RenewBefore. It returns true if a certificate was requested.
+func (p *PodCert) Ensure(ctx context.Context) (bool, error) {
+	if pemCert, err := ioutil.ReadFile(filepath.Join(p.CertsDir, "node.crt")); err == nil {
+		cert, err := security.GetCertObj(pemCert)
+		if err == nil && cert.NotAfter.Sub(p.now()) > p.RenewBefore {
+			return false, nil
+		}
+	}
+
+	key, pemKey, err := security.GenerateKey(security.RSAAlgorithm, p.KeySize)
+	if err != nil {
+		return false, err
+	}
+
+	csr, err := security.CreateCSR(key, security.NodeUser, p.Hosts)
+	if err != nil {
+		return false, err
+	}
+
+	cert, ca, err := Request(ctx, p.Client, p.Name, csr, true, p.Timeout, p.PollInterval)
+	if err != nil {
+		return false, err
+	}
+
+	// the key is written first, so that the certificate never lags its key
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"node.key", pemKey, security.KeyFileMode},
+		{"node.crt", cert, security.CertFileMode},
+		{"ca.crt", ca, security.CertFileMode},
+	}
+
+	for _, f := range files {
+		if err := writeFileAtomic(filepath.Join(p.CertsDir, f.name), f.data, f.mode); err != nil {
+			return false, errors.Wrapf(err, "failed to write %s", f.name)
+		}
+	}
+
+	logrus.Infof("Wrote node certificate to %s", p.CertsDir)
+	return true, nil
+}
+
+// Run calls Ensure every interval until the context is done, renewing the certificate before it expires.
+func (p *PodCert) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.Ensure(ctx); err != nil {
+			logrus.Errorf("Failed to renew node certificate: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeFileAtomic writes the file through a temporary file renamed over it, so that readers never see a
+// partially written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// now returns the current time of the Clock.
+func (p *PodCert) now() time.Time {
+	if p.Clock == nil {
+		return clock.Real.Now()
+	}
+	return p.Clock.Now()
+}
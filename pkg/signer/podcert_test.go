@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+func TestPodCertEnsure(t *testing.T) {
+	s, clientset := newSigner(t)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go func() {
+		_ = s.Run(ctx, 10*time.Millisecond)
+	}()
+
+	dir, err := ioutil.TempDir("", "podcert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &signer.PodCert{
+		Client:       clientset,
+		CertsDir:     dir,
+		Name:         "crdb-0",
+		Hosts:        []string{"localhost", "crdb-public"},
+		KeySize:      2048,
+		RenewBefore:  10 * time.Minute,
+		Timeout:      5 * time.Second,
+		PollInterval: 10 * time.Millisecond,
+	}
+
+	renewed, err := p.Ensure(ctx)
+	require.NoError(t, err)
+	assert.True(t, renewed)
+
+	ca, err := ioutil.ReadFile(filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	assert.Equal(t, testcerts.CACert, string(ca))
+
+	cert, err := ioutil.ReadFile(filepath.Join(dir, "node.crt"))
+	require.NoError(t, err)
+	parsed, err := security.GetCertObj(cert)
+	require.NoError(t, err)
+	assert.Equal(t, security.NodeUser, parsed.Subject.CommonName)
+
+	_, err = ioutil.ReadFile(filepath.Join(dir, "node.key"))
+	require.NoError(t, err)
+
+	// the certificate is still valid for longer than RenewBefore
+	renewed, err = p.Ensure(ctx)
+	require.NoError(t, err)
+	assert.False(t, renewed)
+
+	p.RenewBefore = 2 * time.Hour
+	renewed, err = p.Ensure(ctx)
+	require.NoError(t, err)
+	assert.True(t, renewed)
+}
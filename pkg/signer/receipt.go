@@ -0,0 +1,151 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/jws"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// ReceiptIssuer is the issuer of the receipts, and the common name of the certificate of the receipt key.
+const ReceiptIssuer = "cockroachdb-self-signer"
+
+// The methods the callers of the API are authenticated with, recorded in the receipts.
+const (
+	AuthnMTLS = "mtls"
+	AuthnOIDC = "oidc"
+)
+
+// ErrInvalidReceipt is returned when a receipt isn't signed by a receipt key certified by the CA.
+var ErrInvalidReceipt = errors.New("invalid receipt")
+
+// Receipt binds a certificate issued through the API to the identity which requested it, so that platforms
+// can correlate the certificates with their workloads. It is returned as a JWT signed by the receipt key, whose
+// x5c header holds the certificate of the receipt key issued by the CA, and whose kid header is the SHA-256
+// fingerprint of that certificate.
+type Receipt struct {
+	Issuer string `json:"iss"`
+	// Subject is the identity of the caller: the common name of its client certificate, or the subject of
+	// its ID token.
+	Subject string `json:"sub"`
+	// Authn is how the caller was authenticated, AuthnMTLS or AuthnOIDC.
+	Authn string `json:"authn"`
+	// IssuedAt and ExpiresAt are the lifetime of the certificate, as UNIX time.
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+	// Serial is the hex serial number of the certificate, as in the certificate log.
+	Serial string `json:"serial"`
+	// User is the SQL user of the certificate.
+	User string `json:"user"`
+	// Fingerprint is the hex encoded SHA-256 fingerprint of the certificate.
+	Fingerprint string `json:"fingerprint"`
+}
+
+type receiptHeader struct {
+	Alg string   `json:"alg"`
+	Kid string   `json:"kid"`
+	X5c []string `json:"x5c"`
+}
+
+// NewReceipt returns the receipt of the PEM encoded certificate issued to the caller.
+func NewReceipt(pemCert []byte, caller, authn string) (*Receipt, error) {
+	cert, err := security.GetCertObj(pemCert)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	return &Receipt{
+		Issuer:      ReceiptIssuer,
+		Subject:     caller,
+		Authn:       authn,
+		IssuedAt:    cert.NotBefore.Unix(),
+		ExpiresAt:   cert.NotAfter.Unix(),
+		Serial:      resource.SerialKey(cert.SerialNumber),
+		User:        cert.Subject.CommonName,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// Sign returns the receipt as a JWT signed by the receipt key, with RS256, ES256 or EdDSA depending on the key.
+// The certificate of the key is embedded, so that the receipt can be verified against the CA bundle alone.
+func (r *Receipt) Sign(cert *x509.Certificate, key crypto.Signer) (string, error) {
+	sum := sha256.Sum256(cert.Raw)
+	header := map[string]interface{}{
+		"typ": "JWT",
+		"kid": hex.EncodeToString(sum[:]),
+		"x5c": []string{base64.StdEncoding.EncodeToString(cert.Raw)},
+	}
+
+	token, err := jws.Sign(key, header, r)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign the receipt")
+	}
+	return token, nil
+}
+
+// VerifyReceipt checks that the receipt is signed by a receipt key whose certificate was issued by one of the
+// certificates of the PEM encoded CA bundle, and returns its claims. Its expiry isn't checked, nor the one of
+// the certificate of the receipt key, as the receipts of expired certificates are still used to correlate them.
+func VerifyReceipt(token string, caBundle []byte) (*Receipt, error) {
+	t, err := jws.Parse(token)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidReceipt, err.Error())
+	}
+
+	header := receiptHeader{}
+	if err := t.Header(&header); err != nil {
+		return nil, errors.Wrap(ErrInvalidReceipt, err.Error())
+	}
+	if len(header.X5c) == 0 {
+		return nil, errors.Wrap(ErrInvalidReceipt, "no certificate of the receipt key")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(header.X5c[0])
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidReceipt, "malformed certificate of the receipt key")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidReceipt, "malformed certificate of the receipt key: %s", err)
+	}
+	if err := security.VerifySigningCert(cert, caBundle, ReceiptIssuer, cert.NotBefore); err != nil {
+		return nil, errors.Wrapf(ErrInvalidReceipt, "untrusted receipt key: %s", err)
+	}
+
+	if err := t.Verify(header.Alg, cert.PublicKey); err != nil {
+		return nil, errors.Wrap(ErrInvalidReceipt, err.Error())
+	}
+
+	r := &Receipt{}
+	if err := t.Claims(r); err != nil {
+		return nil, errors.Wrap(ErrInvalidReceipt, err.Error())
+	}
+	if r.Issuer != ReceiptIssuer {
+		return nil, errors.Wrapf(ErrInvalidReceipt, "issued by %s", r.Issuer)
+	}
+	return r, nil
+}
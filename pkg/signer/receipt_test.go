@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+// signingCert returns the certificate of the key issued by the CA to the common name for code signing.
+func signingCert(t *testing.T, caCert *x509.Certificate, caKey, key crypto.Signer, cn string) *x509.Certificate {
+	pemCert, err := security.CreateSigningCert(caCert, caKey, key, cn, time.Now(), time.Hour)
+	require.NoError(t, err)
+	cert, err := security.GetCertObj(pemCert)
+	require.NoError(t, err)
+	return cert
+}
+
+// selfSignedCA returns a CA certificate of the key.
+func selfSignedCA(t *testing.T, key crypto.Signer) (*x509.Certificate, []byte) {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Cockroach CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestReceipt(t *testing.T) {
+	receipt, err := signer.NewReceipt([]byte(testcerts.ClientCert), "platform", signer.AuthnMTLS)
+	require.NoError(t, err)
+	assert.Equal(t, security.RootUser, receipt.User)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, _, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	require.NoError(t, err)
+	caCert, caKey, err := security.ParseCAPair([]byte(testcerts.CACert), []byte(testcerts.CAKey))
+	require.NoError(t, err)
+	bundle := []byte(testcerts.CACert)
+
+	for alg, key := range map[string]crypto.Signer{"RS256": rsaKey, "ES256": ecKey, "EdDSA": edKey} {
+		t.Run(alg, func(t *testing.T) {
+			cert := signingCert(t, caCert, caKey, key, signer.ReceiptIssuer)
+
+			token, err := receipt.Sign(cert, key)
+			require.NoError(t, err)
+
+			verified, err := signer.VerifyReceipt(token, bundle)
+			require.NoError(t, err)
+			assert.Equal(t, receipt, verified)
+
+			// the claims can't be changed, and receipts of other CAs aren't trusted
+			parts := strings.Split(token, ".")
+			other, err := (&signer.Receipt{Issuer: signer.ReceiptIssuer, Subject: "other"}).Sign(cert, key)
+			require.NoError(t, err)
+			_, err = signer.VerifyReceipt(parts[0]+"."+strings.Split(other, ".")[1]+"."+parts[2], bundle)
+			assert.True(t, errors.Is(err, signer.ErrInvalidReceipt))
+
+			otherCA, _ := selfSignedCA(t, ecKey)
+			_, err = signer.VerifyReceipt(token, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.Raw}))
+			assert.True(t, errors.Is(err, signer.ErrInvalidReceipt))
+		})
+	}
+
+	// only the certificates of the receipt key are trusted, not the client certificates issued by the API
+	t.Run("untrusted key", func(t *testing.T) {
+		token, err := receipt.Sign(signingCert(t, caCert, caKey, edKey, "other"), edKey)
+		require.NoError(t, err)
+		_, err = signer.VerifyReceipt(token, bundle)
+		assert.True(t, errors.Is(err, signer.ErrInvalidReceipt))
+
+		csr, err := security.CreateCSR(edKey, signer.ReceiptIssuer, nil)
+		require.NoError(t, err)
+		req, err := security.ParseCSR(csr)
+		require.NoError(t, err)
+		pemCert, err := security.SignCSR(req, caCert, caKey, time.Now(), time.Hour)
+		require.NoError(t, err)
+		clientCert, err := security.GetCertObj(pemCert)
+		require.NoError(t, err)
+
+		token, err = receipt.Sign(clientCert, edKey)
+		require.NoError(t, err)
+		_, err = signer.VerifyReceipt(token, bundle)
+		assert.True(t, errors.Is(err, signer.ErrInvalidReceipt))
+
+		// the CA key doesn't sign receipts either
+		token, err = receipt.Sign(caCert, caKey)
+		require.NoError(t, err)
+		_, err = signer.VerifyReceipt(token, bundle)
+		assert.True(t, errors.Is(err, signer.ErrInvalidReceipt))
+	})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"encoding/pem"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrDenied is returned by Request when the signer denied the request.
+var ErrDenied = errors.New("certificate signing request denied")
+
+// Request submits the PEM encoded certificate request of a node or client certificate to the signer, and
+// waits until it is signed. It returns the PEM encoded certificate along with the CA bundle which signed it.
+func Request(ctx context.Context, cl kubernetes.Interface, name string, pemCSR []byte, node bool,
+	timeout, pollInterval time.Duration) (cert, ca []byte, err error) {
+	return request(ctx, cl, name, pemCSR, node, nil, timeout, pollInterval)
+}
+
+// request submits the certificate request with the annotations and waits until it is signed.
+func request(ctx context.Context, cl kubernetes.Interface, name string, pemCSR []byte, node bool,
+	annotations map[string]string, timeout, pollInterval time.Duration) (cert, ca []byte, err error) {
+
+	csrs := cl.CertificatesV1().CertificateSigningRequests()
+	csr, err := csrs.Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: name + "-",
+			Annotations:  annotations,
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    pemCSR,
+			SignerName: SignerName,
+			Usages:     usages(node),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create certificate signing request")
+	}
+
+	// the request is of no use once answered
+	defer func() {
+		if err := csrs.Delete(ctx, csr.Name, metav1.DeleteOptions{}); err != nil {
+			logrus.Warnf("Failed to delete certificate signing request [%s]: %s", csr.Name, err)
+		}
+	}()
+
+	logrus.Infof("Waiting for certificate signing request [%s] to be signed", csr.Name)
+	err = wait.PollImmediate(pollInterval, timeout, func() (bool, error) {
+		if csr, err = csrs.Get(ctx, csr.Name, metav1.GetOptions{}); err != nil {
+			return false, err
+		}
+
+		for _, c := range csr.Status.Conditions {
+			if c.Type == certificatesv1.CertificateDenied || c.Type == certificatesv1.CertificateFailed {
+				return false, errors.Wrapf(ErrDenied, "%s: %s", c.Reason, c.Message)
+			}
+		}
+
+		return len(csr.Status.Certificate) > 0, nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "certificate signing request [%s] was not signed", csr.Name)
+	}
+
+	block, rest := pem.Decode(csr.Status.Certificate)
+	if block == nil || len(rest) == 0 {
+		return nil, nil, errors.Errorf("certificate signing request [%s] doesn't hold a certificate and CA", csr.Name)
+	}
+
+	return pem.EncodeToMemory(block), rest, nil
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cockroachdb/helm-charts/pkg/clock"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// SignerName is the signer of the CertificateSigningRequests handled by the Signer.
+const SignerName = "cockroachlabs.com/self-signer"
+
+// Policy restricts the certificates the Signer issues.
+type Policy struct {
+	// Requesters are the users, usually service accounts such as
+	// system:serviceaccount:<namespace>:<name>, allowed to request certificates.
+	Requesters []string
+	// NodeHosts are the DNS names and IP addresses node certificates may be issued for. A wildcard name
+	// such as *.crdb also allows the names of the pods, e.g. crdb-0.crdb.
+	NodeHosts []string
+	// ClientUsers are the SQL users client certificates may be issued for.
+	ClientUsers []string
+	// ClientPrincipals are the identities client certificates may also be issued for, with their DNS names,
+	// for clusters mapping them to SQL users with an identity map.
+	ClientPrincipals []security.ClientPrincipal
+	// TenantUsers are the SQL users whose client certificates may be requested with RequestClientCert by the
+	// users of each namespace, by namespace. The requesters also need the TenantVerb permission on
+	// TenantResource in the namespace, and don't need to be Requesters.
+	TenantUsers map[string][]string
+	// TenantMaxDuration is the maximum lifetime of the certificates requested by the tenants, the lifetime of
+	// the client certificates if zero or longer.
+	TenantMaxDuration time.Duration
+	// APICallers are the common names of the clients of the API, never issued to the tenants.
+	APICallers []string
+}
+
+// Check returns an error if the request doesn't comply with the policy.
+func (p Policy) Check(csr *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest) error {
+	if !contains(p.Requesters, csr.Spec.Username) {
+		return fmt.Errorf("user %s is not allowed to request certificates", csr.Spec.Username)
+	}
+
+	if req.Subject.CommonName != security.NodeUser {
+		return p.checkClient(req)
+	}
+
+	for _, host := range req.DNSNames {
+		if !matchesHost(p.NodeHosts, host) {
+			return fmt.Errorf("node certificates for %s are not allowed", host)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !containsIP(p.NodeHosts, ip) {
+			return fmt.Errorf("node certificates for %s are not allowed", ip)
+		}
+	}
+	if len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return errors.New("node certificates can only have DNS and IP subject alternative names")
+	}
+
+	return nil
+}
+
+// checkClient returns an error if the client certificate request doesn't comply with the policy.
+func (p Policy) checkClient(req *x509.CertificateRequest) error {
+	name := req.Subject.CommonName
+	if len(req.IPAddresses) > 0 || len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return errors.New("client certificates can only have the DNS names of their principal")
+	}
+
+	for _, principal := range p.ClientPrincipals {
+		if principal.CommonName != name {
+			continue
+		}
+		for _, host := range req.DNSNames {
+			if !contains(principal.DNSNames, host) {
+				return fmt.Errorf("client certificates of principal %s for %s are not allowed", name, host)
+			}
+		}
+		return nil
+	}
+
+	if !contains(p.ClientUsers, name) {
+		return fmt.Errorf("client certificates of user %s are not allowed", name)
+	}
+	if len(req.DNSNames) > 0 {
+		return errors.New("client certificates can't have subject alternative names")
+	}
+	return nil
+}
+
+// principal returns true if name is the common name of one of the ClientPrincipals.
+func (p Policy) principal(name string) bool {
+	for _, principal := range p.ClientPrincipals {
+		if principal.CommonName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Signer issues the certificates requested through CertificateSigningRequests of SignerName, signing them
+// with the CA key. It is the only component reading the CA key, so the components requesting certificates
+// only need access to the node and client secrets.
+type Signer struct {
+	Client kubernetes.Interface
+	// CA is the resource of the namespace holding the CA secret, which is read before each pass so that a
+	// rotated CA is picked up.
+	CA             resource.Resource
+	CASecretName   string
+	Policy         Policy
+	NodeDuration   time.Duration
+	ClientDuration time.Duration
+	// Clock is the time the certificates are signed at, the real time if nil.
+	Clock clock.Clock
+}
+
+// SignPending approves and signs the pending requests complying with the policy, and denies the others.
+func (s *Signer) SignPending(ctx context.Context) error {
+	list, err := s.Client.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.signerName=" + SignerName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list certificate signing requests")
+	}
+
+	var ca *resource.TLSSecret
+	for i := range list.Items {
+		csr := &list.Items[i]
+		if csr.Spec.SignerName != SignerName || len(csr.Status.Certificate) > 0 || finished(csr) {
+			continue
+		}
+
+		if ca == nil {
+			if ca, err = resource.LoadTLSSecret(s.CASecretName, s.CA); err != nil {
+				return errors.Wrap(err, "failed to get CA secret")
+			}
+			if !ca.ReadyCA() {
+				return errors.Wrap(resource.ErrInvalidSecret, "CA secret doesn't contain the required CA cert/key")
+			}
+		}
+
+		if err := s.sign(ctx, csr, ca); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run signs the pending requests every interval until the context is done.
+func (s *Signer) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.SignPending(ctx); err != nil {
+			logrus.Errorf("Failed to sign certificate signing requests: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Signer) sign(ctx context.Context, csr *certificatesv1.CertificateSigningRequest, ca *resource.TLSSecret) error {
+	req, err := security.ParseCSR(csr.Spec.Request)
+	tenant := csr.Annotations[TenantNamespace]
+	if err == nil && tenant != "" {
+		err = s.checkTenant(ctx, csr, req, tenant)
+	} else if err == nil {
+		err = s.Policy.Check(csr, req)
+	}
+	if err == nil {
+		err = checkUsages(csr, req.Subject.CommonName == security.NodeUser)
+	}
+	if err != nil {
+		logrus.Warnf("Denying certificate signing request [%s]: %s", csr.Name, err)
+		return s.updateApproval(ctx, csr, certificatesv1.CertificateDenied, "PolicyViolation", err.Error())
+	}
+
+	if err := s.updateApproval(ctx, csr, certificatesv1.CertificateApproved, "PolicyCompliant",
+		"request complies with the self-signer policy"); err != nil {
+		return err
+	}
+
+	caCert, caKey, err := security.ParseCAPair(ca.CA(), ca.CAKey())
+	if err != nil {
+		return err
+	}
+
+	lifetime := s.ClientDuration
+	if tenant != "" {
+		lifetime = s.tenantLifetime(csr)
+	} else if req.Subject.CommonName == security.NodeUser {
+		lifetime = s.NodeDuration
+	}
+
+	cert, err := security.SignCSR(req, caCert, caKey, s.now(), lifetime)
+	if err != nil {
+		return err
+	}
+
+	// the CA bundle follows the issued certificate, so that the requester doesn't need to read the CA secret
+	name := csr.Name
+	csr, err = s.Client.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get certificate signing request [%s]", name)
+	}
+	csr.Status.Certificate = append(cert, ca.CA()...)
+
+	if _, err := s.Client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to update certificate signing request [%s]", csr.Name)
+	}
+
+	logrus.Infof("Signed certificate signing request [%s] of %s for %s", csr.Name, csr.Spec.Username, req.Subject.CommonName)
+	return nil
+}
+
+func (s *Signer) updateApproval(ctx context.Context, csr *certificatesv1.CertificateSigningRequest,
+	conditionType certificatesv1.RequestConditionType, reason, message string) error {
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           conditionType,
+		Status:         corev1.ConditionTrue,
+		Reason:         reason,
+		Message:        message,
+		LastUpdateTime: metav1.Now(),
+	})
+
+	_, err := s.Client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
+	return errors.Wrapf(err, "failed to update approval of certificate signing request [%s]", csr.Name)
+}
+
+// usages returns the key usages requested for node or client certificates.
+func usages(node bool) []certificatesv1.KeyUsage {
+	u := []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageKeyEncipherment,
+		certificatesv1.UsageClientAuth}
+	if node {
+		u = append(u, certificatesv1.UsageServerAuth)
+	}
+
+	return u
+}
+
+// checkUsages returns an error if the request asks for usages other than the ones of its certificate type.
+func checkUsages(csr *certificatesv1.CertificateSigningRequest, node bool) error {
+	allowed := usages(node)
+	for _, u := range csr.Spec.Usages {
+		found := false
+		for _, a := range allowed {
+			found = found || u == a
+		}
+		if !found {
+			return fmt.Errorf("usage %s is not allowed", u)
+		}
+	}
+
+	return nil
+}
+
+// finished returns true if the request was denied or failed.
+func finished(csr *certificatesv1.CertificateSigningRequest) bool {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == certificatesv1.CertificateDenied || c.Type == certificatesv1.CertificateFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesHost returns true if the host is one of the hosts, or matches one of the wildcard hosts.
+func matchesHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+
+		if strings.HasPrefix(h, "*.") {
+			label := strings.TrimSuffix(host, h[1:])
+			if label != host && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func containsIP(hosts []string, ip net.IP) bool {
+	for _, h := range hosts {
+		if hostIP := net.ParseIP(h); hostIP != nil && hostIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// now returns the current time of the Clock.
+func (s *Signer) now() time.Time {
+	if s.Clock == nil {
+		return clock.Real.Now()
+	}
+	return s.Clock.Now()
+}
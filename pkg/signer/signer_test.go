@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube/fake"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+const requester = "system:serviceaccount:crdb:crdb-self-signer"
+
+func newSigner(t *testing.T) (*signer.Signer, *k8sfake.Clientset) {
+	ca := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "crdb-ca-secret", Namespace: "crdb"},
+		Data: map[string][]byte{
+			resource.CaCert: []byte(testcerts.CACert),
+			resource.CaKey:  []byte(testcerts.CAKey),
+		},
+	}
+
+	clientset := k8sfake.NewSimpleClientset()
+	// the API server records the user creating the request
+	clientset.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*certificatesv1.CertificateSigningRequest)
+		csr.Spec.Username = requester
+		if csr.Name == "" {
+			csr.Name = csr.GenerateName + "1"
+		}
+		return false, nil, nil
+	})
+
+	return &signer.Signer{
+		Client:       clientset,
+		CA:           fake.NewPersister(ca).Resource(context.TODO(), "crdb"),
+		CASecretName: "crdb-ca-secret",
+		Policy: signer.Policy{
+			Requesters:  []string{requester},
+			NodeHosts:   []string{"localhost", "127.0.0.1", "crdb-public"},
+			ClientUsers: []string{security.RootUser},
+		},
+		NodeDuration:   time.Hour,
+		ClientDuration: time.Hour,
+	}, clientset
+}
+
+func request(t *testing.T, s *signer.Signer, clientset *k8sfake.Clientset, commonName string, hosts []string) ([]byte, []byte, error) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go func() {
+		_ = s.Run(ctx, 10*time.Millisecond)
+	}()
+
+	key, _, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	require.NoError(t, err)
+	csr, err := security.CreateCSR(key, commonName, hosts)
+	require.NoError(t, err)
+
+	return signer.Request(ctx, clientset, "crdb-node-secret", csr, commonName == security.NodeUser, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSignNodeCertificate(t *testing.T) {
+	s, clientset := newSigner(t)
+
+	cert, ca, err := request(t, s, clientset, security.NodeUser, []string{"localhost", "127.0.0.1", "crdb-public"})
+	require.NoError(t, err)
+	assert.Equal(t, testcerts.CACert, string(ca))
+
+	parsed, err := security.GetCertObj(cert)
+	require.NoError(t, err)
+	assert.Equal(t, security.NodeUser, parsed.Subject.CommonName)
+	assert.Equal(t, []string{"localhost", "crdb-public"}, parsed.DNSNames)
+	assert.Len(t, parsed.IPAddresses, 1)
+
+	caCert, err := security.GetCertObj(ca)
+	require.NoError(t, err)
+	assert.NoError(t, parsed.CheckSignatureFrom(caCert))
+
+	// the answered request is deleted
+	list, err := clientset.CertificatesV1().CertificateSigningRequests().List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, list.Items)
+}
+
+func TestDenyRequest(t *testing.T) {
+	s, clientset := newSigner(t)
+
+	_, _, err := request(t, s, clientset, security.NodeUser, []string{"crdb.example.com"})
+	assert.True(t, errors.Is(err, signer.ErrDenied))
+
+	_, _, err = request(t, s, clientset, "admin", nil)
+	assert.True(t, errors.Is(err, signer.ErrDenied))
+}
+
+func TestSignWildcardHost(t *testing.T) {
+	s, clientset := newSigner(t)
+	s.Policy.NodeHosts = append(s.Policy.NodeHosts, "*.crdb")
+
+	_, _, err := request(t, s, clientset, security.NodeUser, []string{"crdb-0.crdb"})
+	require.NoError(t, err)
+
+	// a wildcard only matches a single label
+	_, _, err = request(t, s, clientset, security.NodeUser, []string{"evil.crdb-0.crdb"})
+	assert.True(t, errors.Is(err, signer.ErrDenied))
+}
+
+func TestSignClientPrincipal(t *testing.T) {
+	s, clientset := newSigner(t)
+	s.Policy.ClientPrincipals = []security.ClientPrincipal{
+		{CommonName: "app@corp.example.com", DNSNames: []string{"app.corp.example.com"}},
+	}
+
+	cert, _, err := request(t, s, clientset, "app@corp.example.com", []string{"app.corp.example.com"})
+	require.NoError(t, err)
+	parsed, err := security.GetCertObj(cert)
+	require.NoError(t, err)
+	assert.Equal(t, "app@corp.example.com", parsed.Subject.CommonName)
+	assert.Equal(t, []string{"app.corp.example.com"}, parsed.DNSNames)
+
+	// only the DNS names of the principal are allowed
+	_, _, err = request(t, s, clientset, "app@corp.example.com", []string{"other.corp.example.com"})
+	assert.True(t, errors.Is(err, signer.ErrDenied))
+
+	// the users still can't have any
+	_, _, err = request(t, s, clientset, security.RootUser, []string{"app.corp.example.com"})
+	assert.True(t, errors.Is(err, signer.ErrDenied))
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+const (
+	// TenantNamespace is the annotation of the certificate requests of the tenants, set to the namespace the
+	// certificate is requested for.
+	TenantNamespace = "crdb.io/tenant-namespace"
+	// RequestedDuration is the annotation of the certificate requests of the tenants holding the lifetime of
+	// the certificate requested, which is capped by the policy.
+	RequestedDuration = "crdb.io/requested-duration"
+
+	// The requesters of the client certificates of a tenant need the TenantVerb permission on the
+	// TenantResource of TenantGroup in its namespace, which RBAC can restrict to the SQL users given as
+	// resource names.
+	TenantGroup    = "crdb.io"
+	TenantResource = "clientcertificates"
+	TenantVerb     = "request"
+)
+
+// ParseTenantUsers parses the namespace:user pairs of the SQL users allowed for the tenants.
+func ParseTenantUsers(pairs []string) (map[string][]string, error) {
+	tenantUsers := map[string][]string{}
+	for _, pair := range pairs {
+		i := strings.Index(pair, ":")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, errors.Errorf("invalid tenant user %q, expected namespace:user", pair)
+		}
+		tenantUsers[pair[:i]] = append(tenantUsers[pair[:i]], pair[i+1:])
+	}
+	return tenantUsers, nil
+}
+
+// RequestClientCert requests the client certificate of the SQL user for the tenant of the namespace, and waits
+// until it is signed. It returns the PEM encoded certificate, its key and the CA bundle which signed it. The
+// lifetime of the certificate is the requested duration, if non-zero and allowed by the policy.
+func RequestClientCert(ctx context.Context, cl kubernetes.Interface, namespace, user string, duration time.Duration,
+	timeout, pollInterval time.Duration) (cert, key, ca []byte, err error) {
+
+	privateKey, pemKey, err := security.GenerateKey(security.RSAAlgorithm, 2048)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pemCSR, err := security.CreateCSR(privateKey, user, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	annotations := map[string]string{TenantNamespace: namespace}
+	if duration > 0 {
+		annotations[RequestedDuration] = duration.String()
+	}
+
+	cert, ca, err = request(ctx, cl, tenantRequestName(namespace, user), pemCSR, false, annotations, timeout,
+		pollInterval)
+	return cert, pemKey, ca, err
+}
+
+// tenantRequestName returns the name of the certificate requests of the SQL user for the tenant of the namespace.
+// The dot can't be part of a namespace, so the names of different tenants never collide, and the user is hashed
+// as SQL users may hold characters not allowed in the name.
+func tenantRequestName(namespace, user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return namespace + "." + hex.EncodeToString(sum[:8])
+}
+
+// checkTenant returns an error unless the requester may request the client certificate for the tenant of the
+// namespace.
+func (s *Signer) checkTenant(ctx context.Context, csr *certificatesv1.CertificateSigningRequest,
+	req *x509.CertificateRequest, namespace string) error {
+
+	user := req.Subject.CommonName
+	if contains(reservedUsers, user) || contains(s.Policy.APICallers, user) ||
+		!contains(s.Policy.TenantUsers[namespace], user) {
+		return fmt.Errorf("client certificates of user %s are not allowed in namespace %s", user, namespace)
+	}
+	if len(req.DNSNames) > 0 || len(req.IPAddresses) > 0 || len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return errors.New("client certificates can't have subject alternative names")
+	}
+
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range csr.Spec.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	review, err := s.Client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      TenantVerb,
+				Group:     TenantGroup,
+				Resource:  TenantResource,
+				Name:      user,
+			},
+			User:   csr.Spec.Username,
+			Groups: csr.Spec.Groups,
+			UID:    csr.Spec.UID,
+			Extra:  extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to review the permissions of the requester")
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("user %s is not allowed to request client certificates of %s in namespace %s",
+			csr.Spec.Username, user, namespace)
+	}
+	return nil
+}
+
+// tenantLifetime returns the lifetime of the certificate requested by a tenant: the requested duration, capped
+// by the TenantMaxDuration and the lifetime of the client certificates.
+func (s *Signer) tenantLifetime(csr *certificatesv1.CertificateSigningRequest) time.Duration {
+	lifetime := s.ClientDuration
+	if s.Policy.TenantMaxDuration > 0 && s.Policy.TenantMaxDuration < lifetime {
+		lifetime = s.Policy.TenantMaxDuration
+	}
+
+	if requested, err := time.ParseDuration(csr.Annotations[RequestedDuration]); err == nil && requested > 0 &&
+		requested < lifetime {
+		lifetime = requested
+	}
+	return lifetime
+}
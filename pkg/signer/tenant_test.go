@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/cockroachdb/helm-charts/pkg/security"
+	"github.com/cockroachdb/helm-charts/pkg/signer"
+)
+
+func TestRequestClientCert(t *testing.T) {
+	s, clientset := newSigner(t)
+	s.Policy.TenantUsers = map[string][]string{"team-a": {"app_rw", "app_ro", "platform"}}
+	s.Policy.TenantMaxDuration = 45 * time.Minute
+	s.Policy.APICallers = []string{"platform"}
+
+	// RBAC only lets the requester request the certificates of app_rw and platform in team-a
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == requester && attrs.Namespace == "team-a" &&
+			(attrs.Name == "app_rw" || attrs.Name == "platform") && attrs.Verb == signer.TenantVerb &&
+			attrs.Resource == signer.TenantResource
+		return true, review, nil
+	})
+
+	var names []string
+	clientset.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		names = append(names, action.(k8stesting.CreateAction).GetObject().(*certificatesv1.CertificateSigningRequest).GenerateName)
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go func() {
+		_ = s.Run(ctx, 10*time.Millisecond)
+	}()
+
+	lifetime := func(duration time.Duration) time.Duration {
+		cert, key, ca, err := signer.RequestClientCert(ctx, clientset, "team-a", "app_rw", duration, 5*time.Second,
+			10*time.Millisecond)
+		require.NoError(t, err)
+		require.NoError(t, security.VerifyPair(cert, key, ca, "app_rw", time.Now()))
+
+		parsed, err := security.GetCertObj(cert)
+		require.NoError(t, err)
+		// the certificates are backdated, so their lifetime is counted from now
+		return time.Until(parsed.NotAfter).Round(time.Minute)
+	}
+
+	// the requested lifetime is capped by the policy
+	assert.Equal(t, 30*time.Minute, lifetime(30*time.Minute))
+	assert.Equal(t, 45*time.Minute, lifetime(0))
+	assert.Equal(t, 45*time.Minute, lifetime(2*time.Hour))
+
+	// the requests of different tenants don't share a name prefix
+	require.NotEmpty(t, names)
+	for _, name := range names {
+		assert.Regexp(t, `^team-a\.[0-9a-f]{16}-$`, name)
+	}
+
+	// the users not allowed by the policy or by RBAC, and the API callers, are denied
+	for _, tc := range []struct{ namespace, user string }{
+		{"team-a", "app_ro"},
+		{"team-a", security.RootUser},
+		{"team-a", "platform"},
+		{"team-b", "app_rw"},
+	} {
+		_, _, _, err := signer.RequestClientCert(ctx, clientset, tc.namespace, tc.user, 0, 5*time.Second,
+			10*time.Millisecond)
+		assert.True(t, errors.Is(err, signer.ErrDenied), "%s in %s", tc.user, tc.namespace)
+	}
+}
+
+func TestParseTenantUsers(t *testing.T) {
+	users, err := signer.ParseTenantUsers([]string{"team-a:app_rw", "team-a:app_ro", "team-b:reporting"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"team-a": {"app_rw", "app_ro"}, "team-b": {"reporting"}}, users)
+
+	_, err = signer.ParseTenantUsers([]string{"app_rw"})
+	assert.Error(t, err)
+}
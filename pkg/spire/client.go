@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spire
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Selector selects the registration entries of the workload whose SVIDs are fetched, e.g. k8s:ns:crdb.
+type Selector struct {
+	Type  string
+	Value string
+}
+
+// ParseSelectors parses type:value selectors, e.g. k8s:sa:crdb-cockroachdb.
+func ParseSelectors(selectors []string) ([]Selector, error) {
+	parsed := make([]Selector, 0, len(selectors))
+	for _, s := range selectors {
+		i := strings.Index(s, ":")
+		if i <= 0 || i == len(s)-1 {
+			return nil, errors.Errorf("invalid selector %q, expected type:value", s)
+		}
+		parsed = append(parsed, Selector{Type: s[:i], Value: s[i+1:]})
+	}
+	return parsed, nil
+}
+
+// SVID is an X509-SVID and its key.
+type SVID struct {
+	// ID is the SPIFFE ID of the SVID, e.g. spiffe://example.org/ns/crdb/sa/crdb-cockroachdb.
+	ID string
+	// Certificates is the chain of the SVID, leaf first.
+	Certificates []*x509.Certificate
+	Key          crypto.Signer
+	ExpiresAt    time.Time
+}
+
+// PEM returns the PEM encoded chain and PKCS#8 key of the SVID.
+func (s *SVID) PEM() (pemCert, pemKey []byte, err error) {
+	for _, cert := range s.Certificates {
+		pemCert = append(pemCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(s.Key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode the SVID key")
+	}
+	return pemCert, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// DNSNames returns the DNS names of the SVID.
+func (s *SVID) DNSNames() []string {
+	return s.Certificates[0].DNSNames
+}
+
+// TrustDomain returns the trust domain of the SPIFFE ID of the SVID.
+func (s *SVID) TrustDomain() string {
+	u, err := url.Parse(s.ID)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// Client fetches the SVIDs of other workloads from the delegated identity API of the SPIRE agent. The
+// caller has to be an authorized delegate of the agent.
+type Client struct {
+	// SocketPath is the admin socket of the agent.
+	SocketPath string
+}
+
+// FetchX509SVID returns the X509-SVID of the workload matching the selectors. If id is set, the SVID with
+// that SPIFFE ID is returned, otherwise the first one.
+func (c *Client) FetchX509SVID(ctx context.Context, selectors []Selector, id string) (*SVID, error) {
+	msg, err := c.first(ctx, "SubscribeToX509SVIDs", marshalSVIDsRequest(selectors))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch X509-SVIDs from the SPIRE agent")
+	}
+
+	svids, err := unmarshalSVIDsResponse(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, svid := range svids {
+		if id == "" || svid.ID == id {
+			return svid, nil
+		}
+	}
+	if id != "" {
+		return nil, errors.Errorf("no X509-SVID with SPIFFE ID %s matches the selectors", id)
+	}
+	return nil, errors.New("no X509-SVID matches the selectors")
+}
+
+// FetchBundle returns the PEM encoded X.509 trust bundle of the trust domain.
+func (c *Client) FetchBundle(ctx context.Context, trustDomain string) ([]byte, error) {
+	msg, err := c.first(ctx, "SubscribeToX509Bundles", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch X.509 bundles from the SPIRE agent")
+	}
+
+	bundles, err := unmarshalBundlesResponse(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	der, ok := bundles["spiffe://"+trustDomain]
+	if !ok {
+		der, ok = bundles[trustDomain]
+	}
+	if !ok {
+		return nil, errors.Errorf("the SPIRE agent has no bundle of trust domain %s", trustDomain)
+	}
+
+	certs, err := x509.ParseCertificates(der)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the bundle of trust domain %s", trustDomain)
+	}
+
+	var bundle bytes.Buffer
+	for _, cert := range certs {
+		_ = pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return bundle.Bytes(), nil
+}
+
+// Workload is a workload whose X509-SVID is fetched on its behalf.
+type Workload struct {
+	Client    Client
+	Selectors []Selector
+	// ID is the SPIFFE ID of the SVID, needed when the selectors match several registration entries.
+	ID string
+}
+
+// FetchX509 returns the X509-SVID of the workload and the PEM encoded bundle of its trust domain.
+func (w *Workload) FetchX509(ctx context.Context) (*SVID, []byte, error) {
+	svid, err := w.Client.FetchX509SVID(ctx, w.Selectors, w.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bundle, err := w.Client.FetchBundle(ctx, svid.TrustDomain())
+	if err != nil {
+		return nil, nil, err
+	}
+	return svid, bundle, nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spire_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/cockroachdb/helm-charts/pkg/spire"
+)
+
+func message(fields ...func([]byte) []byte) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = f(b)
+	}
+	return b
+}
+
+func bytesField(num protowire.Number, v []byte) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), v)
+	}
+}
+
+func varintField(num protowire.Number, v uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+	}
+}
+
+// fakeAgent serves the delegated identity API of a SPIRE agent on a unix socket, with an SVID signed by a
+// self-signed trust domain CA.
+func fakeAgent(t *testing.T) (socket string, selectors *[]byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{SerialNumber: big.NewInt(1), IsCA: true, BasicConstraintsValid: true,
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), KeyUsage: x509.KeyUsageCertSign}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	id, err := url.Parse("spiffe://example.org/ns/crdb/sa/crdb-cockroachdb")
+	require.NoError(t, err)
+	svidDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{SerialNumber: big.NewInt(2),
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), URIs: []*url.URL{id},
+		DNSNames: []string{"crdb-cockroachdb-public"}}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	svid := message(
+		bytesField(1, message(bytesField(1, []byte("example.org")), bytesField(2, []byte("/ns/crdb/sa/crdb-cockroachdb")))),
+		bytesField(2, svidDER),
+		varintField(3, uint64(time.Now().Add(time.Hour).Unix())),
+	)
+	responses := map[string][]byte{
+		"/spire.api.agent.delegatedidentity.v1.DelegatedIdentity/SubscribeToX509SVIDs": message(
+			bytesField(1, message(bytesField(1, svid), bytesField(2, keyDER)))),
+		"/spire.api.agent.delegatedidentity.v1.DelegatedIdentity/SubscribeToX509Bundles": message(
+			bytesField(1, message(bytesField(1, []byte("spiffe://example.org")), bytesField(2, caDER)))),
+	}
+
+	var received []byte
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/spire.api.agent.delegatedidentity.v1.DelegatedIdentity/SubscribeToX509SVIDs" {
+			received = body[5:]
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		msg := responses[r.URL.Path]
+		header := make([]byte, 5)
+		binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+		_, _ = w.Write(append(header, msg...))
+		// the stream stays open for updates
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	socket = filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{Handler: h2c.NewHandler(handler, &http2.Server{})}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { server.Close() })
+
+	return socket, &received
+}
+
+func TestFetchX509(t *testing.T) {
+	socket, received := fakeAgent(t)
+
+	selectors, err := spire.ParseSelectors([]string{"k8s:ns:crdb", "k8s:sa:crdb-cockroachdb"})
+	require.NoError(t, err)
+	assert.Equal(t, spire.Selector{Type: "k8s", Value: "sa:crdb-cockroachdb"}, selectors[1])
+
+	w := &spire.Workload{Client: spire.Client{SocketPath: socket}, Selectors: selectors}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	svid, bundle, err := w.FetchX509(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/ns/crdb/sa/crdb-cockroachdb", svid.ID)
+	assert.Equal(t, "example.org", svid.TrustDomain())
+	assert.Equal(t, []string{"crdb-cockroachdb-public"}, svid.DNSNames())
+	assert.Contains(t, string(*received), "sa:crdb-cockroachdb")
+
+	// the SVID verifies with the bundle
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(bundle))
+	_, err = svid.Certificates[0].Verify(x509.VerifyOptions{Roots: roots})
+	require.NoError(t, err)
+
+	pemCert, pemKey, err := svid.PEM()
+	require.NoError(t, err)
+	assert.Contains(t, string(pemCert), "CERTIFICATE")
+	assert.Contains(t, string(pemKey), "PRIVATE KEY")
+
+	w.ID = "spiffe://example.org/other"
+	_, _, err = w.FetchX509(ctx)
+	assert.Error(t, err)
+
+	_, err = spire.ParseSelectors([]string{"k8s"})
+	assert.Error(t, err)
+}
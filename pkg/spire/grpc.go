@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spire
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/wire"
+)
+
+// serviceName is the gRPC service of the delegated identity API.
+const serviceName = "spire.api.agent.delegatedidentity.v1.DelegatedIdentity"
+
+// first calls the server streaming method and returns the first message of the stream, which holds the
+// current SVIDs or bundles. Later updates are not watched, as the certificates are fetched on every run.
+func (c *Client) first(ctx context.Context, method string, req []byte) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// gRPC runs over HTTP/2 without TLS on the unix socket
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", c.SocketPath)
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost/"+serviceName+"/"+method,
+		bytes.NewReader(wire.Frame(req)))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+
+	resp, err := transport.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s failed with %s", method, resp.Status)
+	}
+	// errors are returned in the headers when the stream has no message
+	if err := wire.HeaderStatus(resp.Header); err != nil {
+		return nil, err
+	}
+
+	msg, err := wire.ReadMessage(resp.Body)
+	if err != nil {
+		// the trailers are read once the body is
+		if err := wire.HeaderStatus(resp.Trailer); err != nil {
+			return nil, err
+		}
+		return nil, errors.Wrapf(err, "%s returned no message", method)
+	}
+	return msg, nil
+}
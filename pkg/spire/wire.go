@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spire
+
+import (
+	"crypto"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/helm-charts/pkg/internal/wire"
+)
+
+// The messages of the delegated identity API are encoded by hand, as only its X.509 methods are called.
+
+// marshalSVIDsRequest encodes the SubscribeToX509SVIDsRequest of the selectors.
+func marshalSVIDsRequest(selectors []Selector) []byte {
+	var b []byte
+	for _, s := range selectors {
+		b = wire.AppendBytes(b, 1, wire.AppendString(wire.AppendString(nil, 1, s.Type), 2, s.Value))
+	}
+	return b
+}
+
+// unmarshalSVIDsResponse decodes the X509SVIDWithKey messages of a SubscribeToX509SVIDsResponse.
+func unmarshalSVIDsResponse(b []byte) ([]*SVID, error) {
+	fields, err := wire.DecodeFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var svids []*SVID
+	for _, f := range fields {
+		if f.Num != 1 {
+			continue
+		}
+		svid, err := unmarshalSVIDWithKey(f.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		svids = append(svids, svid)
+	}
+	return svids, nil
+}
+
+func unmarshalSVIDWithKey(b []byte) (*SVID, error) {
+	fields, err := wire.DecodeFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	svid := &SVID{}
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			if err := unmarshalX509SVID(f.Bytes, svid); err != nil {
+				return nil, err
+			}
+		case 2:
+			key, err := x509.ParsePKCS8PrivateKey(f.Bytes)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse the SVID key")
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, errors.New("unsupported SVID key")
+			}
+			svid.Key = signer
+		}
+	}
+
+	if len(svid.Certificates) == 0 || svid.Key == nil {
+		return nil, errors.Errorf("incomplete X509-SVID %s", svid.ID)
+	}
+	return svid, nil
+}
+
+// unmarshalX509SVID decodes the SPIFFE ID, chain and expiry of a spire.api.types.X509SVID.
+func unmarshalX509SVID(b []byte, svid *SVID) error {
+	fields, err := wire.DecodeFields(b)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			id, err := wire.DecodeFields(f.Bytes)
+			if err != nil {
+				return err
+			}
+			var trustDomain, path string
+			for _, idField := range id {
+				switch idField.Num {
+				case 1:
+					trustDomain = string(idField.Bytes)
+				case 2:
+					path = string(idField.Bytes)
+				}
+			}
+			svid.ID = "spiffe://" + trustDomain + path
+		case 2:
+			cert, err := x509.ParseCertificate(f.Bytes)
+			if err != nil {
+				return errors.Wrap(err, "failed to parse the SVID chain")
+			}
+			svid.Certificates = append(svid.Certificates, cert)
+		case 3:
+			svid.ExpiresAt = time.Unix(int64(f.Varint), 0)
+		}
+	}
+	return nil
+}
+
+// unmarshalBundlesResponse decodes the DER encoded bundles of a SubscribeToX509BundlesResponse, by trust
+// domain.
+func unmarshalBundlesResponse(b []byte) (map[string][]byte, error) {
+	fields, err := wire.DecodeFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := map[string][]byte{}
+	for _, f := range fields {
+		if f.Num != 1 {
+			continue
+		}
+		entry, err := wire.DecodeFields(f.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		var key string
+		var value []byte
+		for _, e := range entry {
+			switch e.Num {
+			case 1:
+				key = string(e.Bytes)
+			case 2:
+				value = e.Bytes
+			}
+		}
+		bundles[key] = value
+	}
+	return bundles, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package telemetry reports anonymized usage statistics of the self-signer, when opted in, so that the
+// maintainers can prioritize the failures actually hit in the field.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/version"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Report is the usage report sent at the end of a command. It holds no names, hosts or identifiers of the
+// cluster, only counts and classes.
+type Report struct {
+	Version string `json:"version"`
+	Command string `json:"command"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	// Certs counts the certificate operations by type, e.g. issue or rotate.
+	Certs map[audit.Operation]int `json:"certs"`
+	// ErrorClass is the class of the error the command failed with, empty on success.
+	ErrorClass      string  `json:"errorClass,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// Recorder counts the certificate operations of a command and sends the report to the endpoint. It is an
+// audit.Logger, so it is fed by the audit events of the generator.
+type Recorder struct {
+	Endpoint string
+	Client   *http.Client
+
+	mu      sync.Mutex
+	counts  map[audit.Operation]int
+	started time.Time
+}
+
+var _ audit.Logger = &Recorder{}
+
+// NewRecorder returns a recorder reporting to the endpoint.
+func NewRecorder(endpoint string) *Recorder {
+	return &Recorder{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: defaultTimeout},
+		counts:   map[audit.Operation]int{},
+		started:  time.Now(),
+	}
+}
+
+// Log counts the operation of the event, dropping everything else.
+func (r *Recorder) Log(ctx context.Context, e audit.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[e.Operation]++
+	return nil
+}
+
+// Report returns the report of the command.
+func (r *Recorder) Report(command, errorClass string) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certs := make(map[audit.Operation]int, len(r.counts))
+	for op, n := range r.counts {
+		certs[op] = n
+	}
+
+	return Report{
+		Version:         version.Version,
+		Command:         command,
+		OS:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		Certs:           certs,
+		ErrorClass:      errorClass,
+		DurationSeconds: time.Since(r.started).Seconds(),
+	}
+}
+
+// Send posts the report of the command to the endpoint as JSON.
+func (r *Recorder) Send(ctx context.Context, command, errorClass string) error {
+	body, err := json.Marshal(r.Report(command, errorClass))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/audit"
+	"github.com/cockroachdb/helm-charts/pkg/telemetry"
+)
+
+func TestSend(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	r := telemetry.NewRecorder(server.URL)
+	for _, op := range []audit.Operation{audit.Issue, audit.Issue, audit.Rotate} {
+		require.NoError(t, r.Log(context.TODO(), audit.Event{
+			Operation: op,
+			Namespace: "crdb",
+			Secret:    "crdb-node-secret",
+			Requester: "system:serviceaccount:crdb:crdb-self-signer",
+		}))
+	}
+
+	require.NoError(t, r.Send(context.TODO(), "rotate", "transient"))
+
+	var report telemetry.Report
+	require.NoError(t, json.Unmarshal(body, &report))
+	assert.Equal(t, "rotate", report.Command)
+	assert.Equal(t, "transient", report.ErrorClass)
+	assert.Equal(t, map[audit.Operation]int{audit.Issue: 2, audit.Rotate: 1}, report.Certs)
+
+	// nothing identifying the cluster is sent
+	for _, id := range []string{"crdb", "serviceaccount"} {
+		assert.False(t, strings.Contains(string(body), id), "report contains %q", id)
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	assert.Error(t, telemetry.NewRecorder(server.URL).Send(context.TODO(), "generate", ""))
+}
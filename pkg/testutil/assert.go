@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security"
+)
+
+// RequireSecret fetches the secret and fails the test if it doesn't exist.
+func RequireSecret(t *testing.T, cl client.Client, namespace, name string) *corev1.Secret {
+	t.Helper()
+
+	secret := &corev1.Secret{}
+	require.NoError(t, cl.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, secret),
+		"failed to get secret %s", name)
+
+	return secret
+}
+
+// RequireValidChain requires the certificate of the TLS secret to be currently valid and signed by the
+// CA certificate stored in the same secret.
+func RequireValidChain(t *testing.T, cl client.Client, namespace, name string) *x509.Certificate {
+	t.Helper()
+
+	secret := RequireSecret(t, cl, namespace, name)
+	return requireSignedBy(t, secret, secret.Data[resource.CaCert])
+}
+
+// RequireSignedBy requires the certificate of the TLS secret to be currently valid and signed by the
+// CA stored in the CA secret.
+func RequireSignedBy(t *testing.T, cl client.Client, namespace, name, caSecretName string) *x509.Certificate {
+	t.Helper()
+
+	secret := RequireSecret(t, cl, namespace, name)
+	caSecret := RequireSecret(t, cl, namespace, caSecretName)
+
+	return requireSignedBy(t, secret, caSecret.Data[resource.CaCert])
+}
+
+// RequireHosts requires the certificate to be valid for each of the hosts.
+func RequireHosts(t *testing.T, cert *x509.Certificate, hosts ...string) {
+	t.Helper()
+
+	for _, host := range hosts {
+		require.NoError(t, cert.VerifyHostname(host), "certificate is not valid for %s", host)
+	}
+}
+
+func requireSignedBy(t *testing.T, secret *corev1.Secret, caBundle []byte) *x509.Certificate {
+	t.Helper()
+
+	cert, err := security.GetCertObj(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err, "failed to parse the certificate of secret %s", secret.Name)
+
+	require.NoError(t, security.VerifyChain([]*x509.Certificate{cert}, caBundle, time.Now()),
+		"certificate of secret %s is not signed by the CA", secret.Name)
+
+	return cert
+}
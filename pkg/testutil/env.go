@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testutil provides a harness for integration tests of cert flows against a real API server,
+// started with envtest. The etcd and kube-apiserver binaries are looked up in KUBEBUILDER_ASSETS, see
+// the test/envtest target of the Makefile.
+package testutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// defaultAssetsDir is where envtest looks for the control plane binaries when KUBEBUILDER_ASSETS is not set.
+const defaultAssetsDir = "/usr/local/kubebuilder/bin"
+
+// Environment is a running control plane along with a client for it.
+type Environment struct {
+	Env    *envtest.Environment
+	Config *rest.Config
+	Client client.Client
+}
+
+// StartEnvironment starts a control plane which is stopped when the test completes. The test is
+// skipped if the control plane binaries are not installed.
+func StartEnvironment(t *testing.T) *Environment {
+	t.Helper()
+
+	if !assetsInstalled() {
+		t.Skip("envtest binaries not found, set KUBEBUILDER_ASSETS to run this test")
+	}
+
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	require.NoError(t, err, "failed to start the control plane")
+
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Logf("failed to stop the control plane: %s", err)
+		}
+	})
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	cl, err := client.New(cfg, client.Options{Scheme: scheme})
+	require.NoError(t, err)
+
+	return &Environment{Env: env, Config: cfg, Client: cl}
+}
+
+// CreateNamespace creates a namespace with a generated name starting with prefix and returns its name.
+func (e *Environment) CreateNamespace(t *testing.T, prefix string) string {
+	t.Helper()
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: prefix + "-"}}
+	require.NoError(t, e.Client.Create(context.TODO(), ns))
+
+	return ns.Name
+}
+
+func assetsInstalled() bool {
+	dir := os.Getenv("KUBEBUILDER_ASSETS")
+	if dir == "" {
+		dir = defaultAssetsDir
+	}
+
+	_, err := os.Stat(filepath.Join(dir, "kube-apiserver"))
+	return err == nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/security/testcerts"
+)
+
+// SeedCASecret creates a CA secret holding the given PEM encoded CA certificate and key.
+func SeedCASecret(t *testing.T, cl client.Client, namespace, name, caCert, caKey string) *corev1.Secret {
+	t.Helper()
+
+	return seed(t, cl, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			resource.CaCert: []byte(caCert),
+			resource.CaKey:  []byte(caKey),
+		},
+	})
+}
+
+// SeedTLSSecret creates a TLS secret holding the given PEM encoded certificate, key and CA certificate.
+func SeedTLSSecret(t *testing.T, cl client.Client, namespace, name, cert, key, caCert string) *corev1.Secret {
+	t.Helper()
+
+	return seed(t, cl, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(cert),
+			corev1.TLSPrivateKeyKey: []byte(key),
+			resource.CaCert:         []byte(caCert),
+		},
+	})
+}
+
+// SeedCluster creates the CA, node and client secrets of the statefulset from the testcerts fixtures.
+func SeedCluster(t *testing.T, cl client.Client, namespace, stsName string) {
+	t.Helper()
+
+	SeedCASecret(t, cl, namespace, stsName+"-ca-secret", testcerts.CACert, testcerts.CAKey)
+	SeedTLSSecret(t, cl, namespace, stsName+"-node-secret", testcerts.NodeCert, testcerts.NodeKey, testcerts.CACert)
+	SeedTLSSecret(t, cl, namespace, stsName+"-client-secret", testcerts.ClientCert, testcerts.ClientKey, testcerts.CACert)
+}
+
+func seed(t *testing.T, cl client.Client, secret *corev1.Secret) *corev1.Secret {
+	require.NoError(t, cl.Create(context.TODO(), secret), "failed to seed secret %s", secret.Name)
+	return secret
+}
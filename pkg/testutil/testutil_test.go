@@ -0,0 +1,44 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/kube"
+	"github.com/cockroachdb/helm-charts/pkg/resource"
+	"github.com/cockroachdb/helm-charts/pkg/testutil"
+)
+
+func TestSeedCluster(t *testing.T) {
+	env := testutil.StartEnvironment(t)
+	namespace := env.CreateNamespace(t, "seed")
+
+	testutil.SeedCluster(t, env.Client, namespace, "cockroachdb")
+
+	node := testutil.RequireSignedBy(t, env.Client, namespace, "cockroachdb-node-secret", "cockroachdb-ca-secret")
+	testutil.RequireHosts(t, node, "localhost", "cockroachdb-0.cockroachdb.default")
+	testutil.RequireValidChain(t, env.Client, namespace, "cockroachdb-client-secret")
+
+	r := resource.NewKubeResource(context.TODO(), env.Client, namespace, kube.DefaultPersister)
+	secret, err := resource.LoadTLSSecret("cockroachdb-node-secret", r)
+	require.NoError(t, err)
+	require.True(t, secret.Ready())
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutils
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fieldSet is a set of the paths of the leaf fields of an object, with their segments joined by fieldSep.
+type fieldSet map[string]bool
+
+const fieldSep = "\x00"
+
+// apply implements server-side apply on top of the fake client, which doesn't support it: the fields of the
+// object are set on the stored object, and the fields the field manager applied before but no longer does are
+// removed, while the fields of the other managers are kept. Lists are applied atomically. The apply fails with
+// a conflict if the object has a resource version other than the stored one.
+func (c *FakeClient) apply(ctx context.Context, obj client.Object, opts ...client.PatchOption) error {
+	options := &client.PatchOptions{}
+	options.ApplyOptions(opts)
+
+	applied, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	fields := fieldSet{}
+	collectFields(applied, nil, fields)
+
+	key := client.ObjectKeyFromObject(obj)
+	gvr, err := getGVRFromObject(c.scheme, obj)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find GVR of object")
+	}
+	id := gvr.String() + "/" + key.String()
+
+	current := obj.DeepCopyObject().(client.Object)
+	err = c.Get(ctx, key, current)
+	if apierrors.IsNotFound(err) {
+		created := obj.DeepCopyObject().(client.Object)
+		created.SetResourceVersion("")
+		if err := c.Create(ctx, created); err != nil {
+			return err
+		}
+		c.own(id, options.FieldManager, fields)
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(created).Elem())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if obj.GetResourceVersion() != "" && obj.GetResourceVersion() != current.GetResourceVersion() {
+		return apierrors.NewConflict(gvr.GroupResource(), key.Name,
+			errors.Errorf("the object has been modified, resource version %s", current.GetResourceVersion()))
+	}
+
+	stored, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		return err
+	}
+	for path := range c.ownedFields(id, options.FieldManager) {
+		if !fields[path] {
+			removeField(stored, strings.Split(path, fieldSep))
+		}
+	}
+	for path := range fields {
+		setField(stored, applied, strings.Split(path, fieldSep))
+	}
+
+	updated := obj.DeepCopyObject().(client.Object)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(stored, updated); err != nil {
+		return err
+	}
+	updated.SetResourceVersion(current.GetResourceVersion())
+	if err := c.Update(ctx, updated); err != nil {
+		return err
+	}
+
+	c.own(id, options.FieldManager, fields)
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(updated).Elem())
+	return nil
+}
+
+func (c *FakeClient) ownedFields(id, manager string) fieldSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.owned[id][manager]
+}
+
+func (c *FakeClient) own(id, manager string, fields fieldSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owned == nil {
+		c.owned = map[string]map[string]fieldSet{}
+	}
+	if c.owned[id] == nil {
+		c.owned[id] = map[string]fieldSet{}
+	}
+	c.owned[id][manager] = fields
+}
+
+// collectFields adds the paths of the leaf fields of the object to fields, except for the identity of the
+// object and the fields set by the API server.
+func collectFields(obj map[string]interface{}, path []string, fields fieldSet) {
+	for k, v := range obj {
+		p := append(append([]string{}, path...), k)
+		switch strings.Join(p, ".") {
+		case "apiVersion", "kind", "metadata.name", "metadata.namespace", "metadata.resourceVersion",
+			"metadata.creationTimestamp", "metadata.managedFields", "metadata.uid", "status":
+			continue
+		}
+
+		if m, ok := v.(map[string]interface{}); ok {
+			collectFields(m, p, fields)
+		} else if v != nil {
+			fields[strings.Join(p, fieldSep)] = true
+		}
+	}
+}
+
+func setField(obj, from map[string]interface{}, path []string) {
+	for _, k := range path[:len(path)-1] {
+		if _, ok := obj[k].(map[string]interface{}); !ok {
+			obj[k] = map[string]interface{}{}
+		}
+		obj, from = obj[k].(map[string]interface{}), from[k].(map[string]interface{})
+	}
+	obj[path[len(path)-1]] = from[path[len(path)-1]]
+}
+
+func removeField(obj map[string]interface{}, path []string) {
+	for _, k := range path[:len(path)-1] {
+		m, ok := obj[k].(map[string]interface{})
+		if !ok {
+			return
+		}
+		obj = m
+	}
+	delete(obj, path[len(path)-1])
+}
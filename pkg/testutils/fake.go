@@ -18,10 +18,12 @@ package testutils
 
 import (
 	"context"
+	"sync"
 
 	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -47,6 +49,10 @@ type FakeClient struct {
 	// ReactionChain is the list of reactors that will be attempted for every
 	// request in the order they are tried.
 	ReactionChain []Reactor
+
+	// owned holds the fields of each object owned by each field manager through server-side apply
+	mu    sync.Mutex
+	owned map[string]map[string]fieldSet
 }
 
 // Reactor is an interface to allow the composition of reaction functions.
@@ -71,11 +77,20 @@ func NewGetAction(key client.ObjectKey, gvr schema.GroupVersionResource) Action
 	}
 }
 
-func NewCreateAction(key client.ObjectKey, gvr schema.GroupVersionResource) Action {
-	return &GetAction{
+func NewCreateAction(key client.ObjectKey, gvr schema.GroupVersionResource, obj client.Object) Action {
+	return &CreateAction{
 		verb: "create",
 		key:  key,
 		gvr:  gvr,
+		obj:  obj,
+	}
+}
+
+func NewUpdateAction(key client.ObjectKey, gvr schema.GroupVersionResource) Action {
+	return &GetAction{
+		verb: "update",
+		key:  key,
+		gvr:  gvr,
 	}
 }
 
@@ -175,8 +190,8 @@ func (c *FakeClient) Get(ctx context.Context, key client.ObjectKey, obj client.O
 	return c.client.Get(ctx, key, obj)
 }
 
-func (c *FakeClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
-	panic("implement me")
+func (c *FakeClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.client.List(ctx, list, opts...)
 }
 
 func (c *FakeClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
@@ -186,7 +201,7 @@ func (c *FakeClient) Create(ctx context.Context, obj client.Object, opts ...clie
 	}
 
 	key := client.ObjectKeyFromObject(obj)
-	a := NewCreateAction(key, gvr)
+	a := NewCreateAction(key, gvr, obj)
 
 	if handled, err := c.invoke(a); handled {
 		return err
@@ -200,11 +215,25 @@ func (c *FakeClient) Delete(ctx context.Context, obj client.Object, opts ...clie
 }
 
 func (c *FakeClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	gvr, err := getGVRFromObject(c.scheme, obj)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find GVR of object")
+	}
+
+	a := NewUpdateAction(client.ObjectKeyFromObject(obj), gvr)
+
+	if handled, err := c.invoke(a); handled {
+		return err
+	}
+
 	return c.client.Update(ctx, obj, opts...)
 }
 
-func (c *FakeClient) Patch(_ context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
-	panic("implement me")
+func (c *FakeClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() == types.ApplyPatchType {
+		return c.apply(ctx, obj, opts...)
+	}
+	return c.client.Patch(ctx, obj, patch, opts...)
 }
 
 func (c *FakeClient) DeleteAllOf(_ context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
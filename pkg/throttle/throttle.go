@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package throttle spreads the certificate rotations of many namespaces over time, so that the namespaces
+// sharing a rotation schedule don't update their secrets and restart their CockroachDB pods all at once.
+package throttle
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Throttle delays the rotations of a namespace by its jitter, then admits them at the rate of the limiter,
+// which is shared by all the namespaces managed by the process.
+type Throttle struct {
+	// Jitter is the upper bound of the delay of the rotations of a namespace.
+	Jitter  time.Duration
+	limiter *rate.Limiter
+}
+
+// New returns a throttle delaying each namespace by up to jitter and admitting at most perMinute rotations
+// per minute, with bursts of up to burst rotations. A perMinute of 0 doesn't limit the rate.
+func New(jitter time.Duration, perMinute float64, burst int) *Throttle {
+	limit := rate.Inf
+	if perMinute > 0 {
+		limit = rate.Limit(perMinute / 60)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Throttle{Jitter: jitter, limiter: rate.NewLimiter(limit, burst)}
+}
+
+// Delay returns the jitter of the namespace. It is derived from the name of the namespace, so it is the
+// same for every run, and spread uniformly across the namespaces.
+func (t *Throttle) Delay(namespace string) time.Duration {
+	if t.Jitter <= 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(namespace))
+	return time.Duration(h.Sum64() % uint64(t.Jitter))
+}
+
+// Wait blocks until the namespace can rotate its certificates, or the context is done.
+func (t *Throttle) Wait(ctx context.Context, namespace string) error {
+	if delay := t.Delay(namespace); delay > 0 {
+		logrus.Infof("Delaying rotation of namespace [%s] by %s", namespace, delay.Round(time.Second))
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return t.limiter.Wait(ctx)
+}
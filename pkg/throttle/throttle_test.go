@@ -0,0 +1,55 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/throttle"
+)
+
+func TestDelay(t *testing.T) {
+	require.Zero(t, throttle.New(0, 0, 1).Delay("ns"))
+
+	th := throttle.New(time.Hour, 0, 1)
+	require.Equal(t, th.Delay("ns"), th.Delay("ns"))
+
+	// the delays of many namespaces are spread across the jitter
+	delays := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := th.Delay(fmt.Sprintf("tenant-%d", i))
+		require.True(t, d >= 0 && d < time.Hour)
+		delays[d] = true
+	}
+	require.Greater(t, len(delays), 90)
+}
+
+func TestWaitRate(t *testing.T) {
+	th := throttle.New(0, 60, 2)
+
+	// the burst is admitted right away, the next rotation waits for a second
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, th.Wait(ctx, "a"))
+	require.NoError(t, th.Wait(ctx, "b"))
+	require.Error(t, th.Wait(ctx, "c"))
+}
@@ -19,6 +19,8 @@ package util
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // CreateTempDir creates a temporary directory and returns
@@ -30,8 +32,40 @@ func CreateTempDir(baseDirectory string) (string, func()) {
 		panic(err)
 	}
 	return tmpDir, func() {
-		if err := os.RemoveAll(tmpDir); err != nil {
+		if err := RemoveAll(tmpDir); err != nil {
 			panic(err)
 		}
 	}
 }
+
+// RemoveAll removes the directory and its content like os.RemoveAll. On Windows, where the files written
+// without the owner write permission are read-only and can't be deleted, they are made writable first.
+func RemoveAll(dir string) error {
+	if err := os.RemoveAll(dir); err == nil {
+		return nil
+	}
+
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().Perm()&0200 == 0 {
+			_ = os.Chmod(path, info.Mode().Perm()|0200)
+		}
+		return nil
+	})
+
+	return os.RemoveAll(dir)
+}
+
+// ExpandHome replaces a leading ~ of the path with the home directory of the user. Shells expand it on
+// Unix, but not on Windows, where the path may also use \ as separator.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
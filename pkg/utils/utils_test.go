@@ -0,0 +1,52 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	util "github.com/cockroachdb/helm-charts/pkg/utils"
+)
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	assert.Equal(t, home, util.ExpandHome("~"))
+	assert.Equal(t, filepath.Join(home, ".kube", "config"), util.ExpandHome(filepath.Join("~", ".kube", "config")))
+	assert.Equal(t, filepath.Join("certs", "ca.crt"), util.ExpandHome(filepath.Join("certs", "ca.crt")))
+	assert.Equal(t, "~user/config", util.ExpandHome("~user/config"))
+}
+
+func TestRemoveAllReadOnlyFiles(t *testing.T) {
+	dir, cleanup := util.CreateTempDir("utils")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.key"), []byte("key"), 0400))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "nested", "node.key"), []byte("key"), 0400))
+
+	cleanup()
+
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package version holds the build info of the self-signer, embedded at build time with:
+//
+//	go build -ldflags "-X github.com/cockroachdb/helm-charts/pkg/version.Version=1.3.0 \
+//	  -X github.com/cockroachdb/helm-charts/pkg/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/cockroachdb/helm-charts/pkg/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Version is the semantic version of the self-signer, "dev" for unreleased builds.
+	Version = "dev"
+	// Commit is the git commit the self-signer was built from.
+	Commit = "unknown"
+	// BuildDate is the RFC 3339 time the self-signer was built at.
+	BuildDate = "unknown"
+)
+
+// Info is the build info of the self-signer.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build info of the running self-signer.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("self-signer %s (commit %s, built %s, %s %s)", i.Version, i.Commit, i.BuildDate, i.GoVersion, i.Platform)
+}
+
+// Less reports whether the semantic version a is older than b. A leading "v" and pre-release or build
+// suffixes are ignored. Versions that don't parse, such as the empty version of secrets created before the
+// version was recorded, are older than any parsed version, and "dev" is newer than any release.
+func Less(a, b string) bool {
+	pa, okA := parse(a)
+	pb, okB := parse(b)
+	if !okA || !okB {
+		return !okA && okB
+	}
+
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return pa[i] < pb[i]
+		}
+	}
+	return false
+}
+
+// parse returns the major, minor and patch numbers of the version.
+func parse(v string) ([3]int, bool) {
+	var parsed [3]int
+	if v == "dev" {
+		for i := range parsed {
+			parsed[i] = int(^uint(0) >> 1)
+		}
+		return parsed, true
+	}
+
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return parsed, false
+	}
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
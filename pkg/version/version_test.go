@@ -0,0 +1,47 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cockroachdb/helm-charts/pkg/version"
+)
+
+func TestLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{"1.2.0", "1.3.0", true},
+		{"1.3.0", "1.2.0", false},
+		{"v1.2.9", "1.10.0", true},
+		{"1.3", "1.3.0", false},
+		{"1.3.0-rc.1", "1.3.0", false},
+		{"", "1.0.0", true},
+		{"1.0.0", "", false},
+		{"", "", false},
+		{"1.3.0", "dev", true},
+		{"dev", "1.3.0", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.less, version.Less(tt.a, tt.b), "%q < %q", tt.a, tt.b)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package window restricts certificate rotations, and the pod restarts resulting from them, to maintenance
+// windows opening on a cron schedule.
+package window
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+)
+
+// Window is a maintenance window opening at every activation of its cron schedule and staying open for its
+// duration.
+type Window struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// Parse returns the window opening on the standard cron spec, e.g. "0 2 * * SAT" for saturdays at 2am, and
+// staying open for duration.
+func Parse(spec string, duration time.Duration) (*Window, error) {
+	if duration <= 0 {
+		return nil, errors.Errorf("maintenance window duration must be positive, got %s", duration)
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid maintenance window cron [%s]", spec)
+	}
+
+	return &Window{schedule: schedule, duration: duration}, nil
+}
+
+// Open returns true if a window is open at t.
+func (w *Window) Open(t time.Time) bool {
+	// the last window opened before t is the first opening after t minus the duration
+	return !w.schedule.Next(t.Add(-w.duration)).After(t)
+}
+
+// Next returns the time at which the next window after t opens.
+func (w *Window) Next(t time.Time) time.Time {
+	return w.schedule.Next(t)
+}
+
+// NextRun returns the first activation of schedule after t which falls inside a window, or false if there is
+// none up to until.
+func (w *Window) NextRun(schedule cron.Schedule, t, until time.Time) (time.Time, bool) {
+	for opening := w.Next(t.Add(-w.duration)); !opening.After(until); opening = w.Next(opening) {
+		from := opening.Add(-time.Second)
+		if from.Before(t) {
+			from = t
+		}
+
+		run := schedule.Next(from)
+		if run.After(until) {
+			return time.Time{}, false
+		}
+		if w.Open(run) {
+			return run, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Cockroach Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cockroachdb/helm-charts/pkg/window"
+)
+
+func TestWindow(t *testing.T) {
+	// saturdays from 2am to 4am
+	w, err := window.Parse("0 2 * * SAT", 2*time.Hour)
+	require.NoError(t, err)
+
+	saturday := time.Date(2021, 8, 7, 0, 0, 0, 0, time.Local)
+	assert.False(t, w.Open(saturday.Add(time.Hour)))
+	assert.True(t, w.Open(saturday.Add(2*time.Hour)))
+	assert.True(t, w.Open(saturday.Add(3*time.Hour+59*time.Minute)))
+	assert.False(t, w.Open(saturday.Add(4*time.Hour+time.Minute)))
+	assert.False(t, w.Open(saturday.Add(-24*time.Hour)))
+
+	assert.Equal(t, saturday.Add(2*time.Hour), w.Next(saturday))
+	assert.Equal(t, saturday.Add(7*24*time.Hour+2*time.Hour), w.Next(saturday.Add(3*time.Hour)))
+}
+
+func TestParse(t *testing.T) {
+	_, err := window.Parse("not a cron", time.Hour)
+	assert.Error(t, err)
+
+	_, err = window.Parse("0 2 * * SAT", 0)
+	assert.Error(t, err)
+}
+
+func TestNextRun(t *testing.T) {
+	// saturdays from 2am to 3am
+	w, err := window.Parse("0 2 * * SAT", time.Hour)
+	require.NoError(t, err)
+
+	friday := time.Date(2021, 8, 6, 12, 0, 0, 0, time.Local)
+	saturday := time.Date(2021, 8, 7, 0, 0, 0, 0, time.Local)
+
+	// a daily run at midnight never falls inside the window
+	daily, err := cron.ParseStandard("0 0 * * *")
+	require.NoError(t, err)
+	_, ok := w.NextRun(daily, friday, saturday.Add(30*24*time.Hour))
+	assert.False(t, ok)
+
+	// a run every 30 minutes does, at the window opening
+	often, err := cron.ParseStandard("*/30 * * * *")
+	require.NoError(t, err)
+	run, ok := w.NextRun(often, friday, saturday.Add(5*time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, saturday.Add(2*time.Hour), run)
+
+	// unless it is needed before
+	_, ok = w.NextRun(often, friday, saturday.Add(time.Hour))
+	assert.False(t, ok)
+
+	// or later in the open window
+	run, ok = w.NextRun(often, saturday.Add(2*time.Hour+10*time.Minute), saturday.Add(5*time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, saturday.Add(2*time.Hour+30*time.Minute), run)
+}